@@ -1,73 +1,226 @@
 package main
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/jessevdk/go-flags"
+	"github.com/vishvananda/netlink"
 )
 
 var opts struct {
-	Iname   string `short:"i" long:"interface" description:"print info from a specific interface (ensp6s0)"`
-	Verbose bool   `short:"v" long:"verbose" description:"print debugging information and verbose output"`
+	Iname   string  `short:"i" long:"interface" description:"print info from a specific interface (ensp6s0)"`
+	Numeric bool    `short:"n" long:"numeric" description:"don't resolve addresses to host names"`
+	Set     bool    `short:"s" long:"set" description:"add a static entry: arp -s HOST HWADDR [temp] [pub]"`
+	Delete  bool    `short:"d" long:"delete" description:"delete the entry for HOST"`
+	Inet4   bool    `short:"4" long:"inet4" description:"only IPv4 entries"`
+	Inet6   bool    `short:"6" long:"inet6" description:"only IPv6 neighbors"`
+	Probe   bool    `long:"probe" description:"send ARP requests to HOST like arping"`
+	Count   int     `short:"c" long:"count" default:"3" description:"number of probes to send"`
+	Timeout float64 `short:"w" long:"timeout" default:"1" description:"seconds to wait for each probe reply"`
+	Verbose bool    `short:"v" long:"verbose" description:"print debugging information and verbose output"`
 }
 
 var Debug = func(string, ...interface{}) {}
 
-type Arp struct {
-	addr   string
-	hwType string
-	flag   string
-	hwAddr string
-	mask   string
-	iname  string
+// hostname does the reverse lookup unless -n was given
+func hostname(ip net.IP) string {
+	if !opts.Numeric {
+		if names, err := net.LookupAddr(ip.String()); err == nil && len(names) > 0 {
+			return strings.TrimSuffix(names[0], ".")
+		}
+	}
+	return ip.String()
+}
+
+// resolve turns a host argument into an address of the family we are
+// working with
+func resolve(host string) (net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip, nil
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if (ip.To4() != nil) != opts.Inet6 {
+			return ip, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: no usable address", host)
 }
 
-type ArpTable map[string]Arp
+func ipFamily(ip net.IP) int {
+	if ip.To4() != nil {
+		return netlink.FAMILY_V4
+	}
+	return netlink.FAMILY_V6
+}
+
+// neighbors returns the IPv4 table from /proc and the IPv6 one from netlink
+func neighbors() (ArpTable, error) {
+	var table ArpTable
+	if !opts.Inet6 {
+		t, err := arp()
+		if err != nil {
+			return nil, err
+		}
+		table = append(table, t...)
+	}
+	if !opts.Inet4 {
+		t, err := neighbors6()
+		if err != nil {
+			return nil, err
+		}
+		table = append(table, t...)
+	}
+	return table, nil
+}
+
+func printTable(w io.Writer, table ArpTable, host net.IP) {
+	fmt.Fprintf(w, "%-24s %-7s %-19s %-5s %-15s %-8s %s\n",
+		"Address", "HWtype", "HWaddress", "Flags", "Mask", "Iface", "State")
+	for _, a := range table {
+		if opts.Iname != "" && opts.Iname != a.iname {
+			continue
+		}
+		if host != nil && !host.Equal(a.addr) {
+			continue
+		}
+		hwType, hwAddr := a.HWType(), a.hwAddr.String()
+		if a.flags&atfCom == 0 && a.flags&atfPubl == 0 {
+			hwType, hwAddr = "", "(incomplete)"
+		}
+		fmt.Fprintf(w, "%-24s %-7s %-19s %-5s %-15s %-8s %s\n",
+			hostname(a.addr), hwType, hwAddr, a.Flags(), a.mask, a.iname, a.state)
+	}
+}
 
 func getArp(args []string) error {
-	table, err := arp()
+	var host net.IP
+	if len(args) > 0 {
+		var err error
+		if host, err = resolve(args[0]); err != nil {
+			return err
+		}
+	}
+
+	table, err := neighbors()
 	if err != nil {
 		return err
 	}
-	fmt.Printf("%-16s %-17s %s\n", "address", "HW Address", "Interface")
-	for _, arp := range table {
-		if opts.Iname != "" && opts.Iname != arp.iname {
-			continue
+	printTable(os.Stdout, table, host)
+	return nil
+}
+
+// link finds the interface to use for ip: the one given with -i, otherwise
+// the one the kernel would route it through
+func link(ip net.IP) (netlink.Link, error) {
+	if opts.Iname != "" {
+		return netlink.LinkByName(opts.Iname)
+	}
+	routes, err := netlink.RouteGet(ip)
+	if err != nil {
+		return nil, fmt.Errorf("no route to %v: %v", ip, err)
+	}
+	if len(routes) == 0 || routes[0].LinkIndex == 0 {
+		return nil, fmt.Errorf("no interface for %v, use -i", ip)
+	}
+	return netlink.LinkByIndex(routes[0].LinkIndex)
+}
+
+// setArp adds a neighbor entry: HOST HWADDR [temp] [pub]
+func setArp(args []string) error {
+	if len(args) < 2 {
+		return errors.New("usage: arp -s HOST HWADDR [temp] [pub]")
+	}
+	ip, err := resolve(args[0])
+	if err != nil {
+		return err
+	}
+	hw, err := net.ParseMAC(args[1])
+	if err != nil {
+		return err
+	}
+	l, err := link(ip)
+	if err != nil {
+		return err
+	}
+
+	n := &netlink.Neigh{
+		LinkIndex:    l.Attrs().Index,
+		Family:       ipFamily(ip),
+		State:        netlink.NUD_PERMANENT,
+		IP:           ip,
+		HardwareAddr: hw,
+	}
+	for _, a := range args[2:] {
+		switch a {
+		case "temp":
+			n.State = netlink.NUD_REACHABLE
+		case "pub":
+			n.Flags |= netlink.NTF_PROXY
+		default:
+			return fmt.Errorf("unknown option %q", a)
 		}
-		fmt.Printf("%-16s %s %s\n", arp.addr, arp.hwAddr, arp.iname)
+	}
+
+	Debug("setting %v at %v on %v", ip, hw, l.Attrs().Name)
+	if err := netlink.NeighSet(n); err != nil {
+		return fmt.Errorf("setting %v: %v", ip, err)
 	}
 	return nil
 }
 
-func arp() (ArpTable, error) {
-	f, err := os.Open("/proc/net/arp")
+// deleteArp removes HOST from every interface it is on, or only from -i
+func deleteArp(args []string) error {
+	if len(args) < 1 {
+		return errors.New("usage: arp -d HOST")
+	}
+	ip, err := resolve(args[0])
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer f.Close()
-
-	scanner := bufio.NewScanner(f)
-	scanner.Scan() // skip a field
-	table := make(map[string]Arp)
-	for scanner.Scan() {
-		line := scanner.Text()
-		fields := strings.Fields(line)
-		a := Arp{
-			addr:   fields[0],
-			hwType: fields[1],
-			flag:   fields[2],
-			hwAddr: fields[3],
-			mask:   fields[4],
-			iname:  fields[5],
+
+	index := 0
+	if opts.Iname != "" {
+		l, err := netlink.LinkByName(opts.Iname)
+		if err != nil {
+			return err
 		}
-		table[a.iname] = a
+		index = l.Attrs().Index
+	}
+	neighs, err := netlink.NeighList(index, ipFamily(ip))
+	if err != nil {
+		return err
+	}
+	proxies, err := netlink.NeighProxyList(index, ipFamily(ip))
+	if err != nil {
+		return err
 	}
 
-	return table, nil
+	deleted := 0
+	for _, n := range append(neighs, proxies...) {
+		if !n.IP.Equal(ip) {
+			continue
+		}
+		Debug("deleting %v on link %d", n.IP, n.LinkIndex)
+		if err := netlink.NeighDel(&n); err != nil {
+			return fmt.Errorf("deleting %v: %v", ip, err)
+		}
+		deleted++
+	}
+	if deleted == 0 {
+		return fmt.Errorf("%s: no entry", args[0])
+	}
+	return nil
 }
 
 func main() {
@@ -80,7 +233,21 @@ func main() {
 		Debug = log.Printf
 	}
 
-	if err := getArp(args); err != nil {
+	switch {
+	case opts.Probe:
+		if len(args) < 1 {
+			log.Fatal("usage: arp --probe [-i IFACE] [-c COUNT] [-w SECONDS] HOST")
+		}
+		timeout := time.Duration(opts.Timeout * float64(time.Second))
+		err = probe(os.Stdout, args[0], opts.Count, timeout)
+	case opts.Set:
+		err = setArp(args)
+	case opts.Delete:
+		err = deleteArp(args)
+	default:
+		err = getArp(args)
+	}
+	if err != nil {
 		log.Fatal(err)
 	}
 }
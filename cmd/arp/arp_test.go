@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+const procArpFixture = `IP address       HW type     Flags       HW address            Mask     Device
+192.168.1.1      0x1         0x2         52:54:00:12:35:02     *        eth0
+192.168.1.7      0x1         0x0         00:00:00:00:00:00     *        eth0
+10.0.0.9         0x1         0x6         02:42:ac:11:00:02     *        br0
+10.0.0.0         0x1         0xc         00:00:00:00:00:00     255.255.255.0 br0
+`
+
+func TestParseArp(t *testing.T) {
+	table, err := parseArp(strings.NewReader(procArpFixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// two entries on the same interface must both survive
+	if len(table) != 4 {
+		t.Fatalf("got %d entries, want 4", len(table))
+	}
+
+	for i, want := range []struct {
+		addr, hw, flags, mask, state string
+	}{
+		{"192.168.1.1", "52:54:00:12:35:02", "C", "*", "REACHABLE"},
+		{"192.168.1.7", "", "", "*", "INCOMPLETE"},
+		{"10.0.0.9", "02:42:ac:11:00:02", "CM", "*", "PERMANENT"},
+		{"10.0.0.0", "", "MP", "255.255.255.0", "PERMANENT"},
+	} {
+		a := table[i]
+		if a.addr.String() != want.addr || a.hwAddr.String() != want.hw || a.Flags() != want.flags ||
+			a.mask != want.mask || a.state != want.state || a.HWType() != "ether" {
+			t.Errorf("entry %d = %+v, want %+v", i, a, want)
+		}
+	}
+
+	if _, err := parseArp(strings.NewReader("header\nnope 0x1 0x2 00:00:00:00:00:00 * eth0\n")); err == nil {
+		t.Errorf("parseArp() with a bad address = nil, want error")
+	}
+}
+
+func TestPrintTable(t *testing.T) {
+	opts.Numeric = true
+	defer func() { opts.Numeric = false }()
+
+	table, err := parseArp(strings.NewReader(procArpFixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	printTable(&b, table, net.ParseIP("192.168.1.7"))
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %q, want a header and one entry", b.String())
+	}
+	if f := strings.Fields(lines[1]); len(f) != 5 || f[1] != "(incomplete)" || f[4] != "INCOMPLETE" {
+		t.Errorf("got %q", lines[1])
+	}
+}
+
+func TestARPFrames(t *testing.T) {
+	iface := &net.Interface{HardwareAddr: net.HardwareAddr{2, 0, 0, 0, 0, 1}}
+	src, dst := net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")
+
+	req, err := arpRequest(iface, src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := gopacket.NewPacket(req, layers.LayerTypeEthernet, gopacket.Default)
+	a, ok := p.Layer(layers.LayerTypeARP).(*layers.ARP)
+	if !ok || a.Operation != layers.ARPRequest || !net.IP(a.DstProtAddress).Equal(dst) {
+		t.Fatalf("arpRequest() = %v", p)
+	}
+	if _, ok := arpReply(req, dst); ok {
+		t.Errorf("arpReply() accepted a request")
+	}
+
+	// answer it
+	peer := net.HardwareAddr{2, 0, 0, 0, 0, 2}
+	eth := &layers.Ethernet{SrcMAC: peer, DstMAC: iface.HardwareAddr, EthernetType: layers.EthernetTypeARP}
+	a.Operation = layers.ARPReply
+	a.SourceHwAddress, a.DstHwAddress = peer, iface.HardwareAddr
+	a.SourceProtAddress, a.DstProtAddress = dst.To4(), src.To4()
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, eth, a); err != nil {
+		t.Fatal(err)
+	}
+
+	hw, ok := arpReply(buf.Bytes(), dst)
+	if !ok || hw.String() != peer.String() {
+		t.Errorf("arpReply() = %v, %v, want %v", hw, ok, peer)
+	}
+	if _, ok := arpReply(buf.Bytes(), src); ok {
+		t.Errorf("arpReply() matched the wrong target")
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"golang.org/x/sys/unix"
+)
+
+func htons(v uint16) uint16 {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	return binary.NativeEndian.Uint16(b[:])
+}
+
+// arpRequest builds a broadcast "who has dst? tell src" frame
+func arpRequest(iface *net.Interface, src, dst net.IP) ([]byte, error) {
+	eth := &layers.Ethernet{
+		SrcMAC:       iface.HardwareAddr,
+		DstMAC:       layers.EthernetBroadcast,
+		EthernetType: layers.EthernetTypeARP,
+	}
+	arp := &layers.ARP{
+		AddrType:          layers.LinkTypeEthernet,
+		Protocol:          layers.EthernetTypeIPv4,
+		HwAddressSize:     6,
+		ProtAddressSize:   4,
+		Operation:         layers.ARPRequest,
+		SourceHwAddress:   iface.HardwareAddr,
+		SourceProtAddress: src.To4(),
+		DstHwAddress:      make([]byte, 6),
+		DstProtAddress:    dst.To4(),
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, eth, arp)
+	return buf.Bytes(), err
+}
+
+// arpReply returns the sender's hardware address if frame is an ARP reply
+// from target
+func arpReply(frame []byte, target net.IP) (net.HardwareAddr, bool) {
+	p := gopacket.NewPacket(frame, layers.LayerTypeEthernet, gopacket.NoCopy)
+	l, ok := p.Layer(layers.LayerTypeARP).(*layers.ARP)
+	if !ok || l.Operation != layers.ARPReply {
+		return nil, false
+	}
+	if !bytes.Equal(l.SourceProtAddress, target.To4()) {
+		return nil, false
+	}
+	return net.HardwareAddr(l.SourceHwAddress), true
+}
+
+// sourceAddr picks the first IPv4 address of iface to send from
+func sourceAddr(iface *net.Interface) (net.IP, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.To4() != nil {
+			return n.IP.To4(), nil
+		}
+	}
+	return nil, fmt.Errorf("%s has no IPv4 address", iface.Name)
+}
+
+// probe sends count ARP requests for host, one every timeout, and prints the
+// replies the way arping does
+func probe(w io.Writer, host string, count int, timeout time.Duration) error {
+	ip, err := resolve(host)
+	if err != nil {
+		return err
+	}
+	if ip.To4() == nil {
+		return fmt.Errorf("%v: ARP only works for IPv4", ip)
+	}
+	l, err := link(ip)
+	if err != nil {
+		return err
+	}
+	iface, err := net.InterfaceByIndex(l.Attrs().Index)
+	if err != nil {
+		return err
+	}
+	src, err := sourceAddr(iface)
+	if err != nil {
+		return err
+	}
+	req, err := arpRequest(iface, src, ip)
+	if err != nil {
+		return err
+	}
+
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, int(htons(unix.ETH_P_ARP)))
+	if err != nil {
+		return fmt.Errorf("packet socket: %v", err)
+	}
+	defer unix.Close(fd)
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ARP), Ifindex: iface.Index}); err != nil {
+		return err
+	}
+	to := &unix.SockaddrLinklayer{
+		Protocol: htons(unix.ETH_P_ARP),
+		Ifindex:  iface.Index,
+		Halen:    6,
+	}
+	copy(to.Addr[:], layers.EthernetBroadcast)
+
+	fmt.Fprintf(w, "ARPING %v from %v %s\n", ip, src, iface.Name)
+	received := 0
+	buf := make([]byte, 1500)
+	for sent := 0; sent < count; sent++ {
+		start := time.Now()
+		if err := unix.Sendto(fd, req, 0, to); err != nil {
+			return err
+		}
+
+		deadline := start.Add(timeout)
+		for {
+			left := time.Until(deadline)
+			if left <= 0 {
+				break
+			}
+			tv := unix.NsecToTimeval(left.Nanoseconds())
+			if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+				return err
+			}
+			n, _, err := unix.Recvfrom(fd, buf, 0)
+			if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if hw, ok := arpReply(buf[:n], ip); ok {
+				received++
+				fmt.Fprintf(w, "Unicast reply from %v [%v]  %.3fms\n", ip, hw,
+					float64(time.Since(start).Microseconds())/1000)
+				// wait out the interval so probes stay evenly spaced
+				time.Sleep(time.Until(deadline))
+				break
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "Sent %d probes, Received %d response(s)\n", count, received)
+	if received == 0 {
+		return fmt.Errorf("no response from %v", ip)
+	}
+	return nil
+}
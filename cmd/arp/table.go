@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+)
+
+const procArp = "/proc/net/arp"
+
+// flags from include/uapi/linux/if_arp.h
+const (
+	atfCom     = 0x02 // completed entry
+	atfPerm    = 0x04 // permanent entry
+	atfPubl    = 0x08 // publish entry
+	atfUseTrlr = 0x10 // has requested trailers
+)
+
+var hwTypes = map[int]string{
+	1:     "ether",
+	6:     "ieee802",
+	24:    "ieee1394",
+	32:    "infiniband",
+	772:   "loop",
+	65534: "none",
+}
+
+// Arp is one neighbor, whether it came from /proc/net/arp or netlink
+type Arp struct {
+	addr   net.IP
+	hwType int
+	flags  int
+	hwAddr net.HardwareAddr
+	mask   string
+	iname  string
+	state  string
+}
+
+// ArpTable holds every entry in the order the kernel listed them
+type ArpTable []Arp
+
+func (a Arp) HWType() string {
+	if name, ok := hwTypes[a.hwType]; ok {
+		return name
+	}
+	return fmt.Sprintf("%#x", a.hwType)
+}
+
+// Flags returns the flags the way net-tools prints them: C, M, P and T
+func (a Arp) Flags() string {
+	var f string
+	if a.flags&atfCom != 0 {
+		f += "C"
+	}
+	if a.flags&atfPerm != 0 {
+		f += "M"
+	}
+	if a.flags&atfPubl != 0 {
+		f += "P"
+	}
+	if a.flags&atfUseTrlr != 0 {
+		f += "T"
+	}
+	return f
+}
+
+// arpState decodes /proc flags into the closest neighbor state, /proc only
+// knows whether an entry resolved and whether it is static
+func arpState(flags int) string {
+	switch {
+	case flags&atfPerm != 0:
+		return "PERMANENT"
+	case flags&atfCom != 0:
+		return "REACHABLE"
+	}
+	return "INCOMPLETE"
+}
+
+// parseArp reads the /proc/net/arp format:
+// IP address       HW type     Flags       HW address            Mask     Device
+// 192.168.1.1      0x1         0x2         52:54:00:12:35:02     *        eth0
+func parseArp(r io.Reader) (ArpTable, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Scan() // skip the header
+
+	var table ArpTable
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			continue
+		}
+
+		a := Arp{
+			addr:  net.ParseIP(fields[0]),
+			mask:  fields[4],
+			iname: fields[5],
+		}
+		if a.addr == nil {
+			return nil, fmt.Errorf("bad address %q", fields[0])
+		}
+		hwType, err := strconv.ParseInt(fields[1], 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bad hardware type %q", fields[1])
+		}
+		a.hwType = int(hwType)
+		flags, err := strconv.ParseInt(fields[2], 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bad flags %q", fields[2])
+		}
+		a.flags = int(flags)
+		a.state = arpState(a.flags)
+		// incomplete entries show an all zero address
+		if a.flags&atfCom != 0 {
+			a.hwAddr, _ = net.ParseMAC(fields[3])
+		}
+		table = append(table, a)
+	}
+	return table, scanner.Err()
+}
+
+func arp() (ArpTable, error) {
+	f, err := os.Open(procArp)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseArp(f)
+}
+
+var neighStates = []struct {
+	state int
+	name  string
+}{
+	{netlink.NUD_INCOMPLETE, "INCOMPLETE"},
+	{netlink.NUD_REACHABLE, "REACHABLE"},
+	{netlink.NUD_STALE, "STALE"},
+	{netlink.NUD_DELAY, "DELAY"},
+	{netlink.NUD_PROBE, "PROBE"},
+	{netlink.NUD_FAILED, "FAILED"},
+	{netlink.NUD_NOARP, "NOARP"},
+	{netlink.NUD_PERMANENT, "PERMANENT"},
+}
+
+func neighState(state int) string {
+	var names []string
+	for _, s := range neighStates {
+		if state&s.state != 0 {
+			names = append(names, s.name)
+		}
+	}
+	if len(names) == 0 {
+		return "NONE"
+	}
+	return strings.Join(names, ",")
+}
+
+// neighbors6 lists the IPv6 neighbor cache, which has no /proc file
+func neighbors6() (ArpTable, error) {
+	neighs, err := netlink.NeighList(0, netlink.FAMILY_V6)
+	if err != nil {
+		return nil, fmt.Errorf("can't list neighbours: %v", err)
+	}
+
+	var table ArpTable
+	for _, n := range neighs {
+		if n.State&netlink.NUD_NOARP != 0 {
+			continue
+		}
+		a := Arp{
+			addr:   n.IP,
+			hwType: 1,
+			hwAddr: n.HardwareAddr,
+			mask:   "*",
+			state:  neighState(n.State),
+		}
+		if l, err := netlink.LinkByIndex(n.LinkIndex); err == nil {
+			a.iname = l.Attrs().Name
+		}
+		if n.State&(netlink.NUD_INCOMPLETE|netlink.NUD_FAILED) == 0 {
+			a.flags |= atfCom
+		}
+		if n.State&netlink.NUD_PERMANENT != 0 {
+			a.flags |= atfPerm
+		}
+		if n.Flags&netlink.NTF_PROXY != 0 {
+			a.flags |= atfPubl
+		}
+		table = append(table, a)
+	}
+	return table, nil
+}
@@ -1,42 +1,288 @@
+// blkid prints what the superblocks of block devices say about them, like
+// util-linux blkid.
+//
+// Synopsis:
+//
+//	blkid [-l] [-o FORMAT] [-s TAG]... [-t NAME=VALUE] [DEVICE...]
+//	blkid -p [-O OFFSET] [-o FORMAT] [-s TAG]... DEVICE...
+//	blkid -L LABEL | -U UUID
+//
+// Without devices, all block devices are probed. A DEVICE may also be an
+// image file. FORMAT is full, value, export, udev or device.
+//
+// -p probes low level, adding VERSION and USAGE, and with -O at an offset
+// into the device.
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
 
 	"mybox/pkg/mount/block"
 
 	"github.com/jessevdk/go-flags"
 )
 
-var opts struct {
-	Verbose bool `short:"v" long:"verbose" description:"print debugging information and verbose output"`
+// options are the flags of blkid
+type options struct {
+	Verbose bool     `short:"v" long:"verbose" description:"print debugging information and verbose output"`
+	Output  string   `short:"o" long:"output" default:"full" choice:"full" choice:"value" choice:"export" choice:"udev" choice:"device" description:"output format"`
+	Tags    []string `short:"s" long:"match-tag" description:"show only this tag"`
+	Token   string   `short:"t" long:"match-token" description:"show only devices with this NAME=VALUE"`
+	First   bool     `short:"l" long:"list-one" description:"with -t, show only the first device"`
+	Probe   bool     `short:"p" long:"probe" description:"low level superblock probing"`
+	Offset  int64    `short:"O" long:"offset" description:"with -p, probe at this offset"`
+	Label   string   `short:"L" long:"label" description:"show the device with this label"`
+	UUID    string   `short:"U" long:"uuid" description:"show the device with this UUID"`
 }
 
 var Debug = func(string, ...interface{}) {}
 
-func Blkid(args []string, out io.ReadWriter) error {
-	dev, err := block.GetBlockDevices()
+// errNotFound makes blkid exit 2, when there is nothing to show
+var errNotFound = errors.New("nothing found")
+
+// tag is one NAME="VALUE" of a device
+type tag struct {
+	name, value string
+}
+
+// probe reads the tags of path. With low, it reads them like -p, at offset.
+func probe(path string, offset int64, low bool) ([]tag, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	var r io.ReaderAt = f
+	if offset != 0 {
+		size, err := f.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		r = io.NewSectionReader(f, offset, size-offset)
+	}
+	sectorSize := 512
+	var dev *block.BlockDev
+	if fi.Mode()&os.ModeDevice != 0 {
+		if dev, err = block.Device(path); err == nil {
+			if n, err := dev.BlockSize(); err == nil {
+				sectorSize = n
+			}
+		}
+	}
+
+	var tags []tag
+	add := func(name, value string) {
+		if value != "" {
+			tags = append(tags, tag{name, value})
+		}
+	}
+	if sb, err := block.ProbeFS(r); err == nil {
+		add("LABEL", sb.Label)
+		add("SEC_TYPE", sb.SecType)
+		add("UUID", sb.UUID)
+		if low {
+			add("VERSION", sb.Version)
+		}
+		if sb.BlockSize != 0 {
+			add("BLOCK_SIZE", fmt.Sprint(sb.BlockSize))
+		}
+		add("TYPE", sb.Type)
+		if low {
+			add("USAGE", sb.Usage)
+		}
+	} else if pt, err := block.ProbePartTable(r, sectorSize); err == nil {
+		add("PTUUID", pt.UUID)
+		add("PTTYPE", pt.Type)
+	}
+	if dev != nil && !low {
+		if p, err := dev.PartInfo(); err == nil {
+			add("PARTLABEL", p.Label)
+			add("PARTUUID", p.UUID)
+		}
+	}
+	return tags, nil
+}
+
+// match tells whether tags have token, a NAME=VALUE
+func match(tags []tag, token string) bool {
+	name, value, _ := strings.Cut(token, "=")
+	value = strings.Trim(value, `"`)
+	for _, t := range tags {
+		if t.name == name && t.value == value {
+			return true
+		}
+	}
+	return false
+}
+
+// only keeps the tags named
+func only(tags []tag, names []string) []tag {
+	if len(names) == 0 {
+		return tags
+	}
+	var kept []tag
+	for _, t := range tags {
+		for _, n := range names {
+			if t.name == n {
+				kept = append(kept, t)
+				break
+			}
+		}
+	}
+	return kept
+}
+
+// encode escapes value like udev does, with \x and the hex of each byte
+// that is not safe in a name. UTF-8 is kept.
+func encode(value string) string {
+	var b strings.Builder
+	for i, r := range value {
+		switch {
+		case r == utf8.RuneError:
+			fmt.Fprintf(&b, `\x%02x`, value[i])
+		case r >= 0x80, r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', strings.ContainsRune("#+-.:=@_", r):
+			b.WriteRune(r)
+		default:
+			fmt.Fprintf(&b, `\x%02x`, r)
+		}
+	}
+	return b.String()
+}
+
+// safe replaces the white space in value, for udev
+func safe(value string) string {
+	return strings.NewReplacer(" ", "_", "\t", "_", "\n", "_").Replace(value)
+}
+
+// shellQuote escapes value for a shell
+func shellQuote(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c < 0x80 && !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("-_./:@,+=%", c) >= 0) {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
 	}
+	return b.String()
+}
 
-	for _, device := range dev {
-		fmt.Print(device.DevicePath())
-		if device.FsUUID != "" {
-			fmt.Fprintf(out, ` UUID="%s"`, device.FsUUID)
+// udevNames are the udev names of the tags not named ID_FS_*
+var udevNames = map[string]string{
+	"PTUUID":    "ID_PART_TABLE_UUID",
+	"PTTYPE":    "ID_PART_TABLE_TYPE",
+	"PARTLABEL": "ID_PART_ENTRY_NAME",
+	"PARTUUID":  "ID_PART_ENTRY_UUID",
+}
+
+// show prints the tags of dev in format. first is set for the first
+// device shown.
+func show(out io.Writer, dev string, tags []tag, format string, first bool) {
+	switch format {
+	case "device":
+		fmt.Fprintln(out, dev)
+	case "value":
+		for _, t := range tags {
+			fmt.Fprintln(out, t.value)
+		}
+	case "export":
+		if !first {
+			fmt.Fprintln(out)
+		}
+		fmt.Fprintf(out, "DEVNAME=%s\n", shellQuote(dev))
+		for _, t := range tags {
+			fmt.Fprintf(out, "%s=%s\n", t.name, shellQuote(t.value))
 		}
-		if device.FSType != "" {
-			fmt.Fprintf(out, ` TYPE="%s"`, device.FSType)
+	case "udev":
+		if !first {
+			fmt.Fprintln(out)
 		}
-		fmt.Println()
+		for _, t := range tags {
+			name, ok := udevNames[t.name]
+			if !ok {
+				name = "ID_FS_" + t.name
+			}
+			fmt.Fprintf(out, "%s=%s\n", name, safe(t.value))
+			if t.name == "LABEL" || t.name == "UUID" {
+				fmt.Fprintf(out, "%s_ENC=%s\n", name, encode(t.value))
+			}
+		}
+	default:
+		fmt.Fprintf(out, "%s:", dev)
+		r := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+		for _, t := range tags {
+			fmt.Fprintf(out, ` %s="%s"`, t.name, r.Replace(t.value))
+		}
+		fmt.Fprintln(out)
+	}
+}
+
+func Blkid(out io.Writer, opts options, args []string) error {
+	if opts.Probe && len(args) == 0 {
+		return errors.New("-p needs a device")
+	}
+	switch {
+	case opts.Label != "":
+		opts.Token, opts.Output, opts.First = "LABEL="+opts.Label, "device", true
+	case opts.UUID != "":
+		opts.Token, opts.Output, opts.First = "UUID="+opts.UUID, "device", true
+	}
+
+	if len(args) == 0 {
+		devs, err := block.GetBlockDevices()
+		if err != nil {
+			return err
+		}
+		for _, d := range devs {
+			args = append(args, d.DevicePath())
+		}
+	}
+
+	shown := 0
+	for _, dev := range args {
+		tags, err := probe(dev, opts.Offset, opts.Probe)
+		if err != nil {
+			Debug("%s: %v", dev, err)
+			continue
+		}
+		if len(tags) == 0 || opts.Token != "" && !match(tags, opts.Token) {
+			continue
+		}
+		tags = only(tags, opts.Tags)
+		if len(tags) == 0 {
+			continue
+		}
+		if opts.Label != "" || opts.UUID != "" {
+			if abs, err := filepath.Abs(dev); err == nil {
+				dev = abs
+			}
+		}
+		show(out, dev, tags, opts.Output, shown == 0)
+		shown++
+		if opts.First && opts.Token != "" {
+			break
+		}
+	}
+	if shown == 0 {
+		return errNotFound
 	}
 	return nil
 }
 
 func main() {
+	var opts options
 	args, err := flags.Parse(&opts)
 	if flags.WroteHelp(err) {
 		os.Exit(0)
@@ -47,9 +293,13 @@ func main() {
 
 	if opts.Verbose {
 		Debug = log.Printf
+		block.Debug = log.Printf
 	}
 
-	if err := Blkid(args, os.Stdout); err != nil {
+	if err := Blkid(os.Stdout, opts, args); err != nil {
+		if errors.Is(err, errNotFound) {
+			os.Exit(2)
+		}
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testImage = "../../pkg/mount/testdata/1MB.ext4_vfat"
+
+// images writes an ext4 image labeled "my disk" and a swap image to dir
+func images(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	f, err := os.Open(testImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// the ext4 partition is from the second sector up to sector 1025
+	ext := make([]byte, 1024*512)
+	if _, err := f.ReadAt(ext, 512); err != nil {
+		t.Fatal(err)
+	}
+	copy(ext[1024+120:], "my disk")
+	extImg := filepath.Join(dir, "ext.img")
+	if err := os.WriteFile(extImg, ext, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	swap := make([]byte, 8192)
+	swap[1024] = 1
+	copy(swap[1036:], []byte{0xe4, 0x6d, 0xd2, 0x8e, 0x2a, 0x7d, 0x41, 0xd4, 0x80, 0x0d, 0x96, 0x6a, 0x88, 0x39, 0x8e, 0x3a})
+	copy(swap[1052:], "swp")
+	copy(swap[4086:], "SWAPSPACE2")
+	swapImg := filepath.Join(dir, "swap.img")
+	if err := os.WriteFile(swapImg, swap, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return extImg, swapImg
+}
+
+func TestBlkid(t *testing.T) {
+	dir := t.TempDir()
+	ext, swap := images(t, dir)
+	abs, err := filepath.Abs(swap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const (
+		extUUID  = "2183ead8-a510-4b3d-9777-19c7090f66d9"
+		swapUUID = "e46dd28e-2a7d-41d4-800d-966a88398e3a"
+	)
+	for _, tt := range []struct {
+		name string
+		args []string
+		opts options
+		want string
+		err  error
+	}{
+		{
+			name: "full",
+			args: []string{ext, swap},
+			want: ext + `: LABEL="my disk" UUID="` + extUUID + `" BLOCK_SIZE="1024" TYPE="ext4"` + "\n" +
+				swap + `: LABEL="swp" UUID="` + swapUUID + `" TYPE="swap"` + "\n",
+		},
+		{
+			name: "low level",
+			args: []string{ext},
+			opts: options{Probe: true},
+			want: ext + `: LABEL="my disk" UUID="` + extUUID + `" VERSION="1.0" BLOCK_SIZE="1024" TYPE="ext4" USAGE="filesystem"` + "\n",
+		},
+		{
+			name: "offset",
+			args: []string{testImage},
+			opts: options{Probe: true, Offset: 1025 * 512},
+			want: testImage + `: SEC_TYPE="msdos" UUID="ACE5-5144" VERSION="FAT12" BLOCK_SIZE="512" TYPE="vfat" USAGE="filesystem"` + "\n",
+		},
+		{
+			name: "partition tables",
+			args: []string{testImage, "../../pkg/mount/testdata/gptdisk"},
+			want: testImage + `: PTUUID="675c66d6" PTTYPE="dos"` + "\n" +
+				`../../pkg/mount/testdata/gptdisk: PTUUID="569f7b95-0f2e-45dc-97d3-a9e4add43b64" PTTYPE="gpt"` + "\n",
+		},
+		{
+			name: "value",
+			args: []string{ext},
+			opts: options{Output: "value"},
+			want: "my disk\n" + extUUID + "\n1024\next4\n",
+		},
+		{
+			name: "export",
+			args: []string{ext, swap},
+			opts: options{Output: "export"},
+			want: "DEVNAME=" + ext + "\nLABEL=my\\ disk\nUUID=" + extUUID + "\nBLOCK_SIZE=1024\nTYPE=ext4\n\n" +
+				"DEVNAME=" + swap + "\nLABEL=swp\nUUID=" + swapUUID + "\nTYPE=swap\n",
+		},
+		{
+			name: "udev",
+			args: []string{ext},
+			opts: options{Output: "udev", Probe: true},
+			want: "ID_FS_LABEL=my_disk\nID_FS_LABEL_ENC=my\\x20disk\nID_FS_UUID=" + extUUID + "\nID_FS_UUID_ENC=" + extUUID +
+				"\nID_FS_VERSION=1.0\nID_FS_BLOCK_SIZE=1024\nID_FS_TYPE=ext4\nID_FS_USAGE=filesystem\n",
+		},
+		{
+			name: "udev partition table",
+			args: []string{"../../pkg/mount/testdata/gptdisk"},
+			opts: options{Output: "udev"},
+			want: "ID_PART_TABLE_UUID=569f7b95-0f2e-45dc-97d3-a9e4add43b64\nID_PART_TABLE_TYPE=gpt\n",
+		},
+		{
+			name: "tags",
+			args: []string{ext, swap},
+			opts: options{Tags: []string{"UUID", "TYPE"}},
+			want: ext + `: UUID="` + extUUID + `" TYPE="ext4"` + "\n" + swap + `: UUID="` + swapUUID + `" TYPE="swap"` + "\n",
+		},
+		{
+			name: "token",
+			args: []string{ext, swap},
+			opts: options{Token: "TYPE=swap"},
+			want: swap + `: LABEL="swp" UUID="` + swapUUID + `" TYPE="swap"` + "\n",
+		},
+		{
+			name: "first",
+			args: []string{ext, swap, ext},
+			opts: options{Token: `LABEL="my disk"`, First: true, Output: "device"},
+			want: ext + "\n",
+		},
+		{
+			name: "label",
+			args: []string{ext, swap},
+			opts: options{Label: "swp"},
+			want: abs + "\n",
+		},
+		{
+			name: "uuid",
+			args: []string{ext, swap},
+			opts: options{UUID: swapUUID},
+			want: abs + "\n",
+		},
+		{
+			name: "no match",
+			args: []string{ext, swap},
+			opts: options{Token: "TYPE=xfs"},
+			err:  errNotFound,
+		},
+		{
+			name: "nothing known",
+			args: []string{"../../pkg/mount/testdata/emptyFile", filepath.Join(dir, "nonexistent")},
+			err:  errNotFound,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.opts.Output == "" {
+				tt.opts.Output = "full"
+			}
+			var out bytes.Buffer
+			err := Blkid(&out, tt.opts, tt.args)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Blkid(%q) = %v, want %v", tt.args, err, tt.err)
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("Blkid(%q) wrote\n%s\nwant\n%s", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEscapes(t *testing.T) {
+	for _, tt := range []struct {
+		in, encoded, safe, quoted string
+	}{
+		{in: "plain", encoded: "plain", safe: "plain", quoted: "plain"},
+		{in: "my disk", encoded: `my\x20disk`, safe: "my_disk", quoted: `my\ disk`},
+		{in: "bad\xff", encoded: `bad\xff`, safe: "bad\xff", quoted: "bad\xff"},
+		{in: `a/b"c`, encoded: `a\x2fb\x22c`, safe: `a/b"c`, quoted: `a/b\"c`},
+		{in: "é", encoded: "é", safe: "é", quoted: "é"},
+	} {
+		if got := encode(tt.in); got != tt.encoded {
+			t.Errorf("encode(%q) = %q, want %q", tt.in, got, tt.encoded)
+		}
+		if got := safe(tt.in); got != tt.safe {
+			t.Errorf("safe(%q) = %q, want %q", tt.in, got, tt.safe)
+		}
+		if got := shellQuote(tt.in); got != tt.quoted {
+			t.Errorf("shellQuote(%q) = %q, want %q", tt.in, got, tt.quoted)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+// depmod writes the indexes modprobe finds modules and their dependencies
+// with.
+//
+// Synopsis:
+//
+//	depmod [-b BASEDIR] [-n] [-v] [VERSION]
+//
+// depmod reads the modules of BASEDIR/lib/modules/VERSION, VERSION being
+// that of the running kernel by default, compressed or not. A module
+// depends on those exporting the symbols it uses, in their __ksymtab
+// sections. depmod writes
+//
+//	modules.dep, modules.dep.bin:		the dependencies of each module
+//	modules.alias, modules.alias.bin:	the aliases of the modules
+//	modules.symbols, modules.symbols.bin:	the module exporting each symbol
+//	modules.softdep:			the soft dependencies of the modules
+//
+// With -n, depmod prints the text files instead of writing them.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+	"golang.org/x/sys/unix"
+
+	"mybox/pkg/go-modprobe"
+	"mybox/pkg/kmod"
+)
+
+// options are the flags of depmod
+type options struct {
+	BaseDir string `short:"b" long:"basedir" value-name:"BASEDIR" description:"read the modules of BASEDIR/lib/modules"`
+	DryRun  bool   `short:"n" long:"dry-run" description:"print the text files instead of writing them"`
+	All     bool   `short:"a" long:"all" description:"read all the modules, the default"`
+	Verbose bool   `short:"v" long:"verbose" description:"print the dependencies found"`
+}
+
+// Debug prints what depmod does
+var Debug = func(string, ...interface{}) {}
+
+// module is a module file, and what it tells of itself
+type module struct {
+	name string
+	// path is relative to the module directory
+	path string
+	info *modprobe.Module
+	deps []*module
+}
+
+// isModule tells whether a file is a module, by its extension
+func isModule(name string) bool {
+	for _, ext := range []string{".ko", ".ko.gz", ".ko.xz", ".ko.zst"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize makes the name of a module or alias the way modprobe looks for
+// it, with underscores for dashes
+func normalize(s string) string {
+	return strings.ReplaceAll(s, "-", "_")
+}
+
+// readModules reads the modules under dir, by path
+func readModules(dir string) ([]*module, error) {
+	var mods []*module
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !isModule(path) {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := modprobe.ReadModule(f)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		name, _, _ := strings.Cut(filepath.Base(path), ".ko")
+		if n := info.Get("name"); len(n) > 0 {
+			name = n[0]
+		}
+		mods = append(mods, &module{name: normalize(name), path: rel, info: info})
+		return nil
+	})
+	return mods, err
+}
+
+// resolve finds the modules each module depends on, by the symbols they
+// export, and returns the module of each symbol. The first module by path
+// of a name or symbol wins.
+func resolve(mods []*module) map[string]*module {
+	names := map[string]bool{}
+	symbols := map[string]*module{}
+	for _, m := range mods {
+		if names[m.name] {
+			log.Printf("%s: module %s is already in another file, ignoring it", m.path, m.name)
+			continue
+		}
+		names[m.name] = true
+		for _, s := range m.info.Exports {
+			if _, ok := symbols[s]; !ok {
+				symbols[s] = m
+			}
+		}
+	}
+	for _, m := range mods {
+		seen := map[*module]bool{}
+		for _, s := range m.info.Undefined {
+			// symbols of no module are the kernel's
+			if d, ok := symbols[s]; ok && d != m && !seen[d] {
+				seen[d] = true
+				m.deps = append(m.deps, d)
+				Debug("%s needs %s of %s", m.name, s, d.name)
+			}
+		}
+		sort.Slice(m.deps, func(i, j int) bool { return m.deps[i].path < m.deps[j].path })
+	}
+	return symbols
+}
+
+// order lists all the dependencies of m, direct or not, each after those
+// it depends on
+func order(m *module) []*module {
+	var list []*module
+	state := map[*module]int{}
+	var visit func(d *module)
+	visit = func(d *module) {
+		switch state[d] {
+		case 1:
+			log.Printf("%s: dependency loop through %s", m.name, d.name)
+			return
+		case 2:
+			return
+		}
+		state[d] = 1
+		for _, dd := range d.deps {
+			visit(dd)
+		}
+		state[d] = 2
+		if d != m {
+			list = append(list, d)
+		}
+	}
+	visit(m)
+	return list
+}
+
+// index is a text file and its binary index, if any
+type index struct {
+	name string
+	text bytes.Buffer
+	bin  map[string][]kmod.IndexValue
+}
+
+// indexes makes the files of the modules
+func indexes(mods []*module, symbols map[string]*module) []*index {
+	dep := &index{name: "modules.dep", bin: map[string][]kmod.IndexValue{}}
+	alias := &index{name: "modules.alias", bin: map[string][]kmod.IndexValue{}}
+	symbol := &index{name: "modules.symbols", bin: map[string][]kmod.IndexValue{}}
+	softdep := &index{name: "modules.softdep"}
+	fmt.Fprintln(&alias.text, "# Aliases extracted from modules themselves.")
+	fmt.Fprintln(&symbol.text, "# Aliases for symbols, used by symbol_request().")
+	fmt.Fprintln(&softdep.text, "# Soft dependencies extracted from modules themselves.")
+
+	// the values of the binary indexes go in the order of their modules
+	priority := map[*module]uint32{}
+	for i, m := range mods {
+		priority[m] = uint32(i)
+		// modprobe loads the last dependency first
+		list := order(m)
+		var paths []string
+		for i := len(list) - 1; i >= 0; i-- {
+			paths = append(paths, list[i].path)
+		}
+		line := strings.TrimSpace(m.path + ": " + strings.Join(paths, " "))
+		fmt.Fprintln(&dep.text, line)
+		if _, ok := dep.bin[m.name]; !ok {
+			dep.bin[m.name] = []kmod.IndexValue{{Value: line, Priority: priority[m]}}
+		}
+
+		for _, a := range m.info.Get("alias") {
+			fmt.Fprintf(&alias.text, "alias %s %s\n", a, m.name)
+			alias.bin[normalize(a)] = append(alias.bin[normalize(a)], kmod.IndexValue{Value: m.name, Priority: priority[m]})
+		}
+		for _, s := range m.info.Get("softdep") {
+			fmt.Fprintf(&softdep.text, "softdep %s %s\n", m.name, s)
+		}
+	}
+
+	var names []string
+	for s := range symbols {
+		names = append(names, s)
+	}
+	sort.Strings(names)
+	for _, s := range names {
+		fmt.Fprintf(&symbol.text, "alias symbol:%s %s\n", s, symbols[s].name)
+		symbol.bin["symbol:"+s] = []kmod.IndexValue{{Value: symbols[s].name, Priority: priority[symbols[s]]}}
+	}
+	return []*index{dep, alias, symbol, softdep}
+}
+
+// writeFile writes a file through a temporary one, for modprobe never to
+// see it half written
+func writeFile(name string, write func(io.Writer) error) error {
+	tmp := name + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+func run(w io.Writer, opts options, args []string) error {
+	var release string
+	switch len(args) {
+	case 0:
+		var u unix.Utsname
+		if err := unix.Uname(&u); err != nil {
+			return err
+		}
+		release = unix.ByteSliceToString(u.Release[:])
+	case 1:
+		release = args[0]
+	default:
+		return fmt.Errorf("unexpected arguments %q", args[1:])
+	}
+	dir := filepath.Join(opts.BaseDir, "/lib/modules", release)
+
+	mods, err := readModules(dir)
+	if err != nil {
+		return err
+	}
+	Debug("%d modules in %s", len(mods), dir)
+	for _, idx := range indexes(mods, resolve(mods)) {
+		if opts.DryRun {
+			if _, err := w.Write(idx.text.Bytes()); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := writeFile(filepath.Join(dir, idx.name), func(f io.Writer) error {
+			_, err := f.Write(idx.text.Bytes())
+			return err
+		}); err != nil {
+			return err
+		}
+		if idx.bin == nil {
+			continue
+		}
+		if err := writeFile(filepath.Join(dir, idx.name+".bin"), func(f io.Writer) error {
+			return kmod.WriteIndex(f, idx.bin)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func main() {
+	var opts options
+	args, err := flags.Parse(&opts)
+	if flags.WroteHelp(err) {
+		os.Exit(0)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+	log.SetFlags(0)
+	log.SetPrefix("depmod: ")
+	if opts.Verbose {
+		Debug = log.Printf
+	}
+
+	if err := run(os.Stdout, opts, args); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mybox/pkg/kmod"
+)
+
+// tree copies the test modules of pkg/go-modprobe into a new base directory
+func tree(t *testing.T) (base, dir string) {
+	t.Helper()
+	base = t.TempDir()
+	dir = filepath.Join(base, "lib/modules/6.1.0-test")
+	src := "../../pkg/go-modprobe/testdata/lib/modules/6.1.0-test"
+	err := filepath.WalkDir(filepath.Join(src, "kernel"), func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, rel)), 0o755)
+		return os.WriteFile(filepath.Join(dir, rel), data, 0o644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base, dir
+}
+
+func TestDepmod(t *testing.T) {
+	base, dir := tree(t)
+	if err := run(&bytes.Buffer{}, options{BaseDir: base}, []string{"6.1.0-test"}); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"modules.dep": "kernel/fs/ext4/ext4.ko.xz: kernel/fs/jbd2/jbd2.ko.gz kernel/lib/crc16.ko.zst\n" +
+			"kernel/fs/jbd2/jbd2.ko.gz: kernel/lib/crc16.ko.zst\n" +
+			"kernel/lib/crc16.ko.zst:\n",
+		"modules.alias": "# Aliases extracted from modules themselves.\n" +
+			"alias fs-ext3 ext4\nalias ext3 ext4\nalias fs-ext4 ext4\n",
+		"modules.symbols": "# Aliases for symbols, used by symbol_request().\n" +
+			"alias symbol:crc16 crc16\nalias symbol:jbd2_journal_start jbd2\n",
+		"modules.softdep": "# Soft dependencies extracted from modules themselves.\n" +
+			"softdep ext4 pre: crc32c\n",
+	} {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	// the binary indexes are those of depmod, byte for byte
+	for _, name := range []string{"modules.dep.bin", "modules.alias.bin"} {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := os.ReadFile(filepath.Join("../../pkg/kmod/testdata/index", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s = %x, want %x", name, got, want)
+		}
+	}
+
+	// modprobe finds the modules with the indexes alone
+	for _, name := range []string{"modules.dep", "modules.alias"} {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	k, err := kmod.New(kmod.SetRootDir(filepath.Join(base, "lib/modules")), kmod.SetKernelRelease("6.1.0-test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps, err := k.Dependencies("fs-ext4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, d := range deps {
+		deps[i], _ = filepath.Rel(dir, d)
+	}
+	want := []string{"kernel/lib/crc16.ko.zst", "kernel/fs/jbd2/jbd2.ko.gz", "kernel/fs/ext4/ext4.ko.xz"}
+	if !reflect.DeepEqual(deps, want) {
+		t.Errorf("Dependencies(fs-ext4) = %q, want %q", deps, want)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	base, dir := tree(t)
+	var out bytes.Buffer
+	if err := run(&out, options{BaseDir: base, DryRun: true}, []string{"6.1.0-test"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "kernel/fs/ext4/ext4.ko.xz: ") || !strings.Contains(out.String(), "alias symbol:crc16 crc16\n") {
+		t.Errorf("run() printed %q, want the text files", out.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "modules.dep")); !os.IsNotExist(err) {
+		t.Errorf("modules.dep written with -n: %v", err)
+	}
+}
+
+func TestArgs(t *testing.T) {
+	if err := run(&bytes.Buffer{}, options{}, []string{"a", "b"}); err == nil {
+		t.Error("run(a, b) = nil, want an error")
+	}
+}
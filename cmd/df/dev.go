@@ -4,7 +4,9 @@ import (
 	"golang.org/x/sys/unix"
 )
 
-func deviceNumber(path string) (uint64, error) {
+// deviceNumber is the device of the file system holding path, changed by
+// tests
+var deviceNumber = func(path string) (uint64, error) {
 	st := &unix.Stat_t{}
 	err := unix.Stat(path, st)
 	if err != nil {
@@ -2,37 +2,51 @@
 //
 //	-k: display values in KB (default)
 //	-m: dispaly values in MB
+//	-h: display values in powers of 1024, like 5.8G
+//	-H: display values in powers of 1000
+//	-i: display inode usage instead of blocks
+//	-T: display the filesystem type
+//	-t: only show filesystems of this type
+//	-x: exclude filesystems of this type
+//	-a: show every filesystem, including pseudo and duplicate ones
+//	--output: select the columns to show
+//	--total: print a grand total
 package main
 
 import (
-	"bytes"
 	"errors"
-	"flag"
 	"fmt"
 	"io"
-	"log"
 	"math"
 	"os"
+	"path/filepath"
+	"strings"
 	"syscall"
-)
-
-type flags struct {
-	k bool
-	m bool
-}
 
-var (
-	fargs = flags{}
-	units uint64
+	"github.com/jessevdk/go-flags"
+	"golang.org/x/sys/unix"
 
-	errKMExclusiv = errors.New("options -k and -m are mutually exclusive")
+	"mybox/pkg/mount"
 )
 
-func init() {
-	flag.BoolVar(&fargs.k, "k", false, "Express the values in kilobytes (default)")
-	flag.BoolVar(&fargs.m, "m", false, "Express the values in megabytes")
+// options are the flags of df
+type options struct {
+	KB       bool     `short:"k" description:"Express the values in kilobytes (default)"`
+	MB       bool     `short:"m" description:"Express the values in megabytes"`
+	Human    bool     `short:"h" long:"human-readable" description:"print sizes in powers of 1024 (e.g., 1023M)"`
+	SI       bool     `short:"H" long:"si" description:"print sizes in powers of 1000 (e.g., 1.1G)"`
+	Inodes   bool     `short:"i" long:"inodes" description:"list inode information instead of block usage"`
+	Type     bool     `short:"T" long:"print-type" description:"print file system type"`
+	Include  []string `short:"t" long:"type" description:"limit listing to file systems of this type"`
+	Exclude  []string `short:"x" long:"exclude-type" description:"limit listing to file systems not of this type"`
+	All      bool     `short:"a" long:"all" description:"include pseudo, duplicate and inaccessible file systems"`
+	Output   string   `long:"output" optional:"yes" optional-value:"all" description:"use the output format defined by a comma separated FIELD_LIST"`
+	Total    bool     `long:"total" description:"produce a grand total"`
+	HelpFlag bool     `long:"help" description:"display this help and exit"`
 }
 
+var errKMExclusiv = errors.New("options -k and -m are mutually exclusive")
+
 const (
 	// B is Bytes
 	B = 1
@@ -40,170 +54,451 @@ const (
 	KB = 1024 * B
 	// MB is megabytes
 	MB = 1024 * KB
-
-	procmountsFile = "/proc/mounts"
 )
 
-// Mount is a structure used to contain mount point data
-type mount struct {
-	Device         string
-	MountPoint     string
-	FileSystemType string
-	Flags          string
-	Bsize          int64
-	Blocks         uint64
-	Total          uint64
-	Used           uint64
-	Avail          uint64
-	PCT            uint8
-}
-
-type mountinfomap map[string]mount
-
-// mountinfo returns a map of mounts representing
-// the data in /proc/mounts
-func mountinfo() (mountinfomap, error) {
-	buf, err := os.ReadFile(procmountsFile)
-	if err != nil {
+// every field --output knows about, in the order --output without a list uses
+var fieldNames = []string{
+	"source", "fstype", "itotal", "iused", "iavail", "ipcent",
+	"size", "used", "avail", "pcent", "file", "target",
+}
+
+// usage is the statfs result for a single filesystem
+type usage struct {
+	mnt    *mount.Info
+	file   string
+	size   uint64
+	used   uint64
+	avail  uint64
+	itotal uint64
+	iused  uint64
+	iavail uint64
+}
+
+// statfsCall is statfs(2), changed by tests
+var statfsCall = syscall.Statfs
+
+// statfs fills in the usage numbers in bytes and inodes
+func statfs(mnt *mount.Info) (*usage, error) {
+	fs := syscall.Statfs_t{}
+	if err := statfsCall(mnt.Path, &fs); err != nil {
 		return nil, err
 	}
-	return mountinfoFromBytes(buf)
+
+	// note: arm7 Bsize is int32; all others are int64
+	bsize := uint64(fs.Bsize)
+	if fs.Frsize > 0 {
+		bsize = uint64(fs.Frsize)
+	}
+	return &usage{
+		mnt:    mnt,
+		file:   "-",
+		size:   fs.Blocks * bsize,
+		used:   (fs.Blocks - fs.Bfree) * bsize,
+		avail:  fs.Bavail * bsize,
+		itotal: fs.Files,
+		iused:  fs.Files - fs.Ffree,
+		iavail: fs.Ffree,
+	}, nil
 }
 
-// returns a map generated from the bytestream returned
-// from /proc/mounts
-// for tidiness, we decide to ignore filesystems of size 0
-// to exclude cgroup, procfs and sysfs types
-func mountinfoFromBytes(buf []byte) (mountinfomap, error) {
-	ret := make(mountinfomap)
-	for _, line := range bytes.Split(buf, []byte{'\n'}) {
-		kv := bytes.SplitN(line, []byte{' '}, 6)
-		if len(kv) != 6 {
-			// can't interpret this
-			continue
-		}
-		key := string(kv[1])
-		var mnt mount
-		mnt.Device = string(kv[0])
-		mnt.MountPoint = string(kv[1])
-		mnt.FileSystemType = string(kv[2])
-		mnt.Flags = string(kv[3])
-		if err := diskUsage(&mnt); err != nil {
-			return nil, err
-		}
-		if mnt.Blocks == 0 {
-			continue
-		} else {
-			ret[key] = mnt
+// percent rounds up like coreutils does, the reserved blocks do not count
+func percent(used, avail uint64) string {
+	if used+avail == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.0f%%", math.Ceil(float64(used)*100/float64(used+avail)))
+}
+
+// human formats n with one decimal below 10 and none above, rounding up
+// so that the values are guaranteed to be "at most X"
+func human(n uint64, base float64) string {
+	const suffixes = "KMGTPE"
+
+	f := float64(n)
+	i := -1
+	for f >= base && i < len(suffixes)-1 {
+		f /= base
+		i++
+	}
+	if i < 0 {
+		return fmt.Sprintf("%d", n)
+	}
+
+	if f < 10 {
+		f = math.Ceil(f*10) / 10
+		if f < 10 {
+			return fmt.Sprintf("%.1f%c", f, suffixes[i])
 		}
 	}
-	return ret, nil
+	f = math.Ceil(f)
+	if f >= base && i < len(suffixes)-1 {
+		return fmt.Sprintf("%.1f%c", 1.0, suffixes[i+1])
+	}
+	return fmt.Sprintf("%.0f%c", f, suffixes[i])
 }
 
-// diskUsage calculates the usage statistics of a mount point
-// note: arm7 Bsize is int32; all others are int64
-func diskUsage(mnt *mount) error {
-	fs := syscall.Statfs_t{}
-	if err := syscall.Statfs(mnt.MountPoint, &fs); err != nil {
-		return err
+type formatter struct {
+	units     uint64
+	blockName string
+	base      float64
+}
+
+func (opts *options) formatter() (*formatter, error) {
+	if opts.KB && opts.MB {
+		return nil, errKMExclusiv
 	}
-	mnt.Blocks = fs.Blocks * uint64(fs.Bsize) / units
-	mnt.Bsize = int64(fs.Bsize)
-	mnt.Total = fs.Blocks * uint64(fs.Bsize) / units
-	mnt.Avail = fs.Bavail * uint64(fs.Bsize) / units
-	mnt.Used = (fs.Blocks - fs.Bfree) * uint64(fs.Bsize) / units
-	pct := float64((fs.Blocks - fs.Bfree)) * 100 / float64(fs.Blocks)
-	mnt.PCT = uint8(math.Ceil(pct))
-	return nil
+
+	switch {
+	case opts.Human:
+		return &formatter{base: 1024, blockName: "Size"}, nil
+	case opts.SI:
+		return &formatter{base: 1000, blockName: "Size"}, nil
+	case opts.MB:
+		return &formatter{units: MB, blockName: "1M-blocks"}, nil
+	}
+	return &formatter{units: KB, blockName: "1K-blocks"}, nil
 }
 
-// setUnits takes the command line flags and configures
-// the correct units used to calculate display values
-func setUnits(inKB, inMB bool) error {
-	if inKB && inMB {
-		return errKMExclusiv
+func (f *formatter) bytes(n uint64) string {
+	if f.base != 0 {
+		return human(n, f.base)
 	}
-	if inMB {
-		units = MB
-	} else {
-		units = KB
+	// blocks round up like coreutils, a single byte still takes a block
+	return fmt.Sprintf("%d", (n+f.units-1)/f.units)
+}
+
+func (f *formatter) inodes(n uint64) string {
+	if f.base != 0 {
+		return human(n, f.base)
 	}
-	return nil
+	return fmt.Sprintf("%d", n)
 }
 
-func printHeader(w io.Writer, blockSize string) {
-	fmt.Fprintf(w, "Filesystem           Type         %v-blocks       Used    Available  Use%% Mounted on\n", blockSize)
+// header returns the column title of a field
+func (f *formatter) header(field string, custom bool) string {
+	switch field {
+	case "source":
+		return "Filesystem"
+	case "fstype":
+		return "Type"
+	case "itotal":
+		return "Inodes"
+	case "iused":
+		return "IUsed"
+	case "iavail":
+		return "IFree"
+	case "ipcent":
+		return "IUse%"
+	case "size":
+		return f.blockName
+	case "used":
+		return "Used"
+	case "avail":
+		if custom || f.base != 0 {
+			return "Avail"
+		}
+		return "Available"
+	case "pcent":
+		return "Use%"
+	case "file":
+		return "File"
+	case "target":
+		return "Mounted on"
+	}
+	return field
 }
 
-func printMount(w io.Writer, mnt mount) {
-	fmt.Fprintf(w, "%-20v %-9v %12v %10v %12v %4v%% %-13v\n",
-		mnt.Device,
-		mnt.FileSystemType,
-		mnt.Blocks,
-		mnt.Used,
-		mnt.Avail,
-		mnt.PCT,
-		mnt.MountPoint)
+func (f *formatter) value(field string, u *usage) string {
+	switch field {
+	case "source":
+		return u.mnt.Source
+	case "fstype":
+		return u.mnt.FSType
+	case "itotal":
+		return f.inodes(u.itotal)
+	case "iused":
+		return f.inodes(u.iused)
+	case "iavail":
+		return f.inodes(u.iavail)
+	case "ipcent":
+		return percent(u.iused, u.iavail)
+	case "size":
+		return f.bytes(u.size)
+	case "used":
+		return f.bytes(u.used)
+	case "avail":
+		return f.bytes(u.avail)
+	case "pcent":
+		return percent(u.used, u.avail)
+	case "file":
+		return u.file
+	case "target":
+		return u.mnt.Path
+	}
+	return ""
 }
 
-func df(w io.Writer, fargs flags, args []string) error {
-	if err := setUnits(fargs.k, fargs.m); err != nil {
-		return err
+// columns works out the fields to print from the flags
+func (opts *options) columns() ([]string, bool, error) {
+	if opts.Output != "" {
+		if opts.Inodes || opts.Type {
+			return nil, false, fmt.Errorf("options --output and -i/-T are mutually exclusive")
+		}
+		if opts.Output == "all" {
+			return fieldNames, true, nil
+		}
+
+		var fields []string
+		for _, f := range strings.Split(opts.Output, ",") {
+			found := false
+			for _, name := range fieldNames {
+				if f == name {
+					found = true
+				}
+			}
+			if !found {
+				return nil, false, fmt.Errorf("%q: not a valid field for --output", f)
+			}
+			fields = append(fields, f)
+		}
+		return fields, true, nil
 	}
-	mounts, err := mountinfo()
-	if err != nil {
-		return fmt.Errorf("mountinfo()=_,%q, want: _,nil", err)
+
+	fields := []string{"source"}
+	if opts.Type {
+		fields = append(fields, "fstype")
 	}
-	blocksize := "1K"
-	if fargs.m {
-		blocksize = "1M"
+	if opts.Inodes {
+		fields = append(fields, "itotal", "iused", "iavail", "ipcent")
+	} else {
+		fields = append(fields, "size", "used", "avail", "pcent")
 	}
+	return append(fields, "target"), false, nil
+}
 
-	if len(args) == 0 {
-		printHeader(w, blocksize)
-		for _, mnt := range mounts {
-			printMount(w, mnt)
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		for _, v := range strings.Split(l, ",") {
+			if v == s {
+				return true
+			}
 		}
+	}
+	return false
+}
 
-		return nil
+// selectType applies -t and -x
+func (opts *options) selectType(mnt *mount.Info) bool {
+	if len(opts.Include) > 0 && !contains(opts.Include, mnt.FSType) {
+		return false
 	}
+	return !contains(opts.Exclude, mnt.FSType)
+}
 
-	var fileDevs []uint64
-	for _, arg := range args {
-		fileDev, err := deviceNumber(arg)
+// visible drops the mounts df hides without -a: filesystems hidden below a
+// later mount on the same mountpoint, bind mounts of a device that is already
+// listed and pseudo filesystems without any blocks
+func (opts *options) visible(mounts []*mount.Info) []*usage {
+	var list []*usage
+	byDev := make(map[uint64]int)
+
+	for i, mnt := range mounts {
+		if !opts.selectType(mnt) {
+			continue
+		}
+
+		if !opts.All {
+			overmounted := false
+			for _, later := range mounts[i+1:] {
+				if later.Path == mnt.Path {
+					overmounted = true
+					break
+				}
+			}
+			if overmounted {
+				continue
+			}
+		}
+
+		u, err := statfs(mnt)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "df: %v\n", err)
+			if opts.All {
+				fmt.Fprintf(os.Stderr, "df: %v: %v\n", mnt.Path, err)
+			}
+			continue
+		}
+		if opts.All {
+			list = append(list, u)
 			continue
 		}
 
-		fileDevs = append(fileDevs, fileDev)
+		if u.size == 0 {
+			continue
+		}
+		// keep the shortest mountpoint of a device, that is usually the real one
+		dev := unix.Mkdev(mnt.Major, mnt.Minor)
+		if j, ok := byDev[dev]; ok {
+			if len(mnt.Path) < len(list[j].mnt.Path) {
+				list[j] = u
+			}
+			continue
+		}
+		byDev[dev] = len(list)
+		list = append(list, u)
 	}
+	return list
+}
 
-	showHeader := true
-	for _, mnt := range mounts {
-		stDev, err := deviceNumber(mnt.MountPoint)
+// containing finds the filesystem each path lives on, it is the last mount in
+// mountinfo order with the same device number whose mountpoint holds the path
+func (opts *options) containing(mounts []*mount.Info, args []string) []*usage {
+	var list []*usage
+	for _, arg := range args {
+		dev, err := deviceNumber(arg)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "df: %v\n", err)
 			continue
 		}
 
-		for _, fDev := range fileDevs {
-			if fDev == stDev {
-				if showHeader {
-					printHeader(w, blocksize)
-					showHeader = false
-				}
-				printMount(w, mnt)
+		path, err := filepath.Abs(arg)
+		if err == nil {
+			if p, err := filepath.EvalSymlinks(path); err == nil {
+				path = p
+			}
+		}
+
+		var found *mount.Info
+		for _, mnt := range mounts {
+			if unix.Mkdev(mnt.Major, mnt.Minor) != dev {
+				continue
+			}
+			if path == mnt.Path || mnt.Path == "/" || strings.HasPrefix(path, mnt.Path+"/") {
+				found = mnt
+			}
+		}
+		if found == nil {
+			fmt.Fprintf(os.Stderr, "df: %v: cannot find the mount point\n", arg)
+			continue
+		}
+		if !opts.selectType(found) {
+			continue
+		}
+
+		u, err := statfs(found)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "df: %v: %v\n", arg, err)
+			continue
+		}
+		u.file = arg
+		list = append(list, u)
+	}
+	return list
+}
+
+// total sums every row into a single one
+func total(list []*usage) *usage {
+	t := &usage{
+		mnt:  &mount.Info{Source: "total", FSType: "-", Path: "-"},
+		file: "-",
+	}
+	for _, u := range list {
+		t.size += u.size
+		t.used += u.used
+		t.avail += u.avail
+		t.itotal += u.itotal
+		t.iused += u.iused
+		t.iavail += u.iavail
+	}
+	return t
+}
+
+// printTable aligns the columns, text columns to the left and numbers to the right
+func printTable(w io.Writer, fields []string, rows [][]string) {
+	widths := make([]int, len(fields))
+	for _, row := range rows {
+		for i, cell := range row {
+			if len(cell) > widths[i] {
+				widths[i] = len(cell)
 			}
 		}
 	}
 
+	for _, row := range rows {
+		var line strings.Builder
+		for i, cell := range row {
+			if i > 0 {
+				line.WriteByte(' ')
+			}
+			switch fields[i] {
+			case "source", "fstype", "file", "target":
+				fmt.Fprintf(&line, "%-*s", widths[i], cell)
+			default:
+				fmt.Fprintf(&line, "%*s", widths[i], cell)
+			}
+		}
+		fmt.Fprintln(w, strings.TrimRight(line.String(), " "))
+	}
+}
+
+func df(w io.Writer, opts *options, args []string) error {
+	f, err := opts.formatter()
+	if err != nil {
+		return err
+	}
+	fields, custom, err := opts.columns()
+	if err != nil {
+		return err
+	}
+
+	mounts, err := mount.Mountinfo()
+	if err != nil {
+		return err
+	}
+
+	var list []*usage
+	if len(args) == 0 {
+		list = opts.visible(mounts)
+	} else {
+		list = opts.containing(mounts, args)
+	}
+	if len(list) == 0 {
+		return fmt.Errorf("no file systems processed")
+	}
+	if opts.Total {
+		list = append(list, total(list))
+	}
+
+	header := make([]string, len(fields))
+	for i, field := range fields {
+		header[i] = f.header(field, custom)
+	}
+	rows := [][]string{header}
+	for _, u := range list {
+		row := make([]string, len(fields))
+		for i, field := range fields {
+			row[i] = f.value(field, u)
+		}
+		rows = append(rows, row)
+	}
+
+	printTable(w, fields, rows)
 	return nil
 }
 
 func main() {
-	flag.Parse()
-	if err := df(os.Stdout, fargs, flag.Args()); err != nil {
-		log.Fatal(err)
+	var opts options
+	parser := flags.NewParser(&opts, flags.PassDoubleDash|flags.PrintErrors)
+	args, err := parser.Parse()
+	if err != nil {
+		os.Exit(1)
+	}
+	if opts.HelpFlag {
+		parser.WriteHelp(os.Stdout)
+		os.Exit(0)
+	}
+
+	if err := df(os.Stdout, &opts, args); err != nil {
+		fmt.Fprintf(os.Stderr, "df: %v\n", err)
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+
+	"mybox/pkg/mount"
+)
+
+// fakeFS stands for the file systems of testdata/mountinfo, in 4K blocks
+var fakeFS = map[string]syscall.Statfs_t{
+	"/":            {Blocks: 262144, Bfree: 131072, Bavail: 65536, Files: 65536, Ffree: 49152},
+	"/proc":        {},
+	"/run":         {Blocks: 256, Bfree: 256, Bavail: 256, Files: 1000, Ffree: 990},
+	"/home":        {Blocks: 2621440, Bfree: 2359296, Bavail: 2359296, Files: 655360, Ffree: 655000},
+	"/data/srv":    {Blocks: 2621440, Bfree: 2359296, Bavail: 2359296, Files: 655360, Ffree: 655000},
+	"/mnt/usb key": {Blocks: 1000},
+}
+
+// fakeDevs are the devices of the files df is asked about
+var fakeDevs = map[string]uint64{
+	"/home/user/notes": unix.Mkdev(8, 2),
+	"/etc/passwd":      unix.Mkdev(8, 1),
+}
+
+func fake(t *testing.T) {
+	t.Helper()
+	saved := []any{mount.MountinfoPath, statfsCall, deviceNumber}
+	t.Cleanup(func() {
+		mount.MountinfoPath = saved[0].(string)
+		statfsCall = saved[1].(func(string, *syscall.Statfs_t) error)
+		deviceNumber = saved[2].(func(string) (uint64, error))
+	})
+	mount.MountinfoPath = "testdata/mountinfo"
+	statfsCall = func(path string, st *syscall.Statfs_t) error {
+		fs, ok := fakeFS[path]
+		if !ok {
+			return syscall.ENOENT
+		}
+		*st = fs
+		st.Bsize, st.Frsize = 4096, 4096
+		return nil
+	}
+	deviceNumber = func(path string) (uint64, error) {
+		dev, ok := fakeDevs[path]
+		if !ok {
+			return 0, fmt.Errorf("%s: no such file or directory", path)
+		}
+		return dev, nil
+	}
+}
+
+func TestDF(t *testing.T) {
+	fake(t)
+	for _, tt := range []struct {
+		name string
+		opts options
+		args []string
+		want string
+		err  string
+	}{
+		{
+			// the overmounted /run, /proc without blocks and the bind
+			// mount of /dev/sda2 are left out
+			name: "default",
+			want: "Filesystem 1K-blocks    Used Available Use% Mounted on\n" +
+				"/dev/sda1    1048576  524288    262144  67% /\n" +
+				"/dev/sda2   10485760 1048576   9437184  10% /home\n" +
+				"/dev/sdb1       4000    4000         0 100% /mnt/usb key\n" +
+				"tmpfs           1024       0      1024   0% /run\n",
+		},
+		{
+			name: "human and type",
+			opts: options{Human: true, Type: true},
+			want: "Filesystem Type  Size Used Avail Use% Mounted on\n" +
+				"/dev/sda1  ext4  1.0G 512M  256M  67% /\n" +
+				"/dev/sda2  ext4   10G 1.0G  9.0G  10% /home\n" +
+				"/dev/sdb1  vfat  4.0M 4.0M     0 100% /mnt/usb key\n" +
+				"tmpfs      tmpfs 1.0M    0  1.0M   0% /run\n",
+		},
+		{
+			name: "si",
+			opts: options{SI: true},
+			want: "Filesystem Size Used Avail Use% Mounted on\n" +
+				"/dev/sda1  1.1G 537M  269M  67% /\n" +
+				"/dev/sda2   11G 1.1G  9.7G  10% /home\n" +
+				"/dev/sdb1  4.1M 4.1M     0 100% /mnt/usb key\n" +
+				"tmpfs      1.1M    0  1.1M   0% /run\n",
+		},
+		{
+			name: "megabytes",
+			opts: options{MB: true},
+			want: "Filesystem 1M-blocks Used Available Use% Mounted on\n" +
+				"/dev/sda1       1024  512       256  67% /\n" +
+				"/dev/sda2      10240 1024      9216  10% /home\n" +
+				"/dev/sdb1          4    4         0 100% /mnt/usb key\n" +
+				"tmpfs              1    0         1   0% /run\n",
+		},
+		{
+			name: "inodes",
+			opts: options{Inodes: true},
+			want: "Filesystem Inodes IUsed  IFree IUse% Mounted on\n" +
+				"/dev/sda1   65536 16384  49152   25% /\n" +
+				"/dev/sda2  655360   360 655000    1% /home\n" +
+				"/dev/sdb1       0     0      0     - /mnt/usb key\n" +
+				"tmpfs        1000    10    990    1% /run\n",
+		},
+		{
+			name: "type",
+			opts: options{Include: []string{"ext4,vfat"}, Exclude: []string{"vfat"}},
+			want: "Filesystem 1K-blocks    Used Available Use% Mounted on\n" +
+				"/dev/sda1    1048576  524288    262144  67% /\n" +
+				"/dev/sda2   10485760 1048576   9437184  10% /home\n",
+		},
+		{
+			name: "all",
+			opts: options{All: true, Type: true},
+			want: "Filesystem Type  1K-blocks    Used Available Use% Mounted on\n" +
+				"/dev/sda1  ext4    1048576  524288    262144  67% /\n" +
+				"proc       proc          0       0         0    - /proc\n" +
+				"tmpfs      tmpfs      1024       0      1024   0% /run\n" +
+				"/dev/sda2  ext4   10485760 1048576   9437184  10% /home\n" +
+				"/dev/sda2  ext4   10485760 1048576   9437184  10% /data/srv\n" +
+				"/dev/sdb1  vfat       4000    4000         0 100% /mnt/usb key\n" +
+				"tmpfs      tmpfs      1024       0      1024   0% /run\n",
+		},
+		{
+			name: "output and total",
+			opts: options{Output: "target,fstype,pcent", Total: true},
+			want: "Mounted on   Type  Use%\n" +
+				"/            ext4   67%\n" +
+				"/home        ext4   10%\n" +
+				"/mnt/usb key vfat  100%\n" +
+				"/run         tmpfs   0%\n" +
+				"-            -      14%\n",
+		},
+		{
+			name: "output all",
+			opts: options{Output: "all", Include: []string{"vfat"}},
+			want: "Filesystem Type Inodes IUsed IFree IUse% 1K-blocks Used Avail Use% File Mounted on\n" +
+				"/dev/sdb1  vfat      0     0     0     -      4000 4000     0 100% -    /mnt/usb key\n",
+		},
+		{
+			name: "files",
+			args: []string{"/home/user/notes", "/etc/passwd"},
+			opts: options{Output: "file,target"},
+			want: "File             Mounted on\n" +
+				"/home/user/notes /home\n" +
+				"/etc/passwd      /\n",
+		},
+		{
+			name: "-k and -m",
+			opts: options{KB: true, MB: true},
+			err:  errKMExclusiv.Error(),
+		},
+		{
+			name: "output and -i",
+			opts: options{Output: "all", Inodes: true},
+			err:  "mutually exclusive",
+		},
+		{
+			name: "bad field",
+			opts: options{Output: "source,nope"},
+			err:  `"nope": not a valid field`,
+		},
+		{
+			name: "nothing",
+			opts: options{Include: []string{"nfs"}},
+			err:  "no file systems processed",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := df(&out, &tt.opts, tt.args)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("df() = %v, want an error with %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("df() printed\n%s\nwant\n%s", out.String(), tt.want)
+			}
+		})
+	}
+}
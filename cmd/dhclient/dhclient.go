@@ -2,12 +2,18 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
+	"mybox/pkg/dhcp"
+
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
 	"github.com/jessevdk/go-flags"
@@ -16,23 +22,34 @@ import (
 )
 
 var opts struct {
-	Timeout  int    `short:"t" long:"timeout" description:"timeout in seconds"`
-	Retry    int    `short:"r" long:"retry" description:"Max number of attempts for DHCP clients to send requests. -1 means infinite"`
-	ipv4     bool   `long:"ipv4" description:"use IPV4"`
-	ipv6     bool   `long:"ipv6" description:"use IPV6"`
-	V6Port   int    `long:"v6-port" description:"DHCPv6 server port to send to"`
-	V4Port   int    `long:"v4-port" description:"DHCPv4 server port to send to"`
-	V6Server string `long:"v6-server" description:"DHCPv6 server address to send to (multicast or unicast)"`
-	DryRun   bool   `short:"d" long:"dry-run" description:"Just make the DHCP requests but dont configure interfaces"`
-	Verbose  []bool `short:"v" long:"verbose" description:"print debugging information and verbose output"`
+	Timeout    int    `short:"t" long:"timeout" description:"timeout in seconds"`
+	Retry      int    `long:"retry" description:"Max number of attempts for DHCP clients to send requests. -1 means infinite"`
+	IPv4       bool   `short:"4" long:"ipv4" description:"use IPV4 (default unless --ipv6 is given)"`
+	IPv6       bool   `short:"6" long:"ipv6" description:"use IPV6, one shot only"`
+	V6Port     int    `long:"v6-port" description:"DHCPv6 server port to send to"`
+	V4Port     int    `long:"v4-port" description:"DHCPv4 server port to send to"`
+	V6Server   string `long:"v6-server" description:"DHCPv6 server address to send to (multicast or unicast)"`
+	Release    bool   `short:"r" long:"release" description:"release the saved leases and deconfigure the interfaces"`
+	Once       bool   `short:"1" long:"once" description:"get a lease and exit instead of keeping it renewed"`
+	Background bool   `short:"b" long:"background" description:"detach and keep the leases renewed in the background"`
+	StateDir   string `long:"state-dir" description:"where leases are kept between runs"`
+	ResolvConf string `long:"resolv-conf" description:"file to write the name servers of the leases of all interfaces to"`
+	NoResolv   bool   `long:"no-resolv" description:"leave resolv.conf alone"`
+	Script     string `short:"s" long:"script" description:"hook script, or directory of them, run on bound, renew, rebind, expire and release"`
+	Hostname   string `short:"H" long:"hostname" description:"hostname to send to the server"`
+	DryRun     bool   `short:"d" long:"dry-run" description:"Just make the DHCP requests but dont configure interfaces"`
+	Verbose    []bool `short:"v" long:"verbose" description:"print debugging information and verbose output"`
 }
 
 var Debug = func(string, ...interface{}) {}
 var ifname = "^e.*"
 
+// backgroundEnv marks the detached copy of ourselves
+const backgroundEnv = "DHCLIENT_BACKGROUND"
+
 func Dhclient(args []string) error {
 	if len(args) > 1 {
-		return fmt.Errorf("only one re")
+		return fmt.Errorf("only one regular expression for interfaces")
 	}
 
 	if len(args) > 0 {
@@ -43,21 +60,121 @@ func Dhclient(args []string) error {
 	if err != nil {
 		return err
 	}
+	if !opts.IPv4 && !opts.IPv6 {
+		opts.IPv4 = true
+	}
+
+	if opts.Release {
+		return releaseAll(filteredInterfaces)
+	}
 
-	configureAll(filteredInterfaces)
+	if opts.Background && os.Getenv(backgroundEnv) == "" {
+		return background()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if opts.IPv6 {
+		configureAll(ctx, filteredInterfaces)
+	}
+	if opts.IPv4 {
+		return runAll(ctx, filteredInterfaces)
+	}
 	return nil
 }
 
-func configureAll(ifs []netlink.Link) {
+// client is the DHCPv4 client for one interface
+func client(ifname string) *dhcp.Client {
+	c := &dhcp.Client{
+		Interface:  ifname,
+		StateDir:   opts.StateDir,
+		ResolvConf: opts.ResolvConf,
+		Script:     opts.Script,
+		Hostname:   opts.Hostname,
+		Timeout:    time.Duration(opts.Timeout) * time.Second,
+		Retries:    opts.Retry,
+		ServerPort: opts.V4Port,
+		DryRun:     opts.DryRun,
+	}
+	if opts.NoResolv {
+		c.ResolvConf = ""
+	}
+	return c
+}
+
+// runAll keeps a lease on every interface until ctx is done, or gets one
+// for each and returns with --once
+func runAll(ctx context.Context, ifs []netlink.Link) error {
+	if opts.Once {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(ifs))
+	for i, link := range ifs {
+		wg.Add(1)
+		go func(i int, c *dhcp.Client) {
+			defer wg.Done()
+			if !opts.Once {
+				c.Run(ctx)
+				return
+			}
+			defer c.Close()
+			if _, err := c.Bind(ctx); err != nil {
+				errs[i] = fmt.Errorf("could not configure %s: %w", c.Interface, err)
+			}
+		}(i, client(link.Attrs().Name))
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func releaseAll(ifs []netlink.Link) error {
+	var errs []error
+	for _, link := range ifs {
+		c := client(link.Attrs().Name)
+		errs = append(errs, c.Release())
+		c.Close()
+	}
+	return errors.Join(errs...)
+}
+
+// background starts a copy of ourselves in its own session, away from the
+// terminal, and leaves it running
+func background() error {
+	null, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer null.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	p, err := os.StartProcess(exe, os.Args, &os.ProcAttr{
+		Dir:   "/",
+		Env:   append(os.Environ(), backgroundEnv+"=1"),
+		Files: []*os.File{null, null, null},
+		Sys:   &syscall.SysProcAttr{Setsid: true},
+	})
+	if err != nil {
+		return err
+	}
+	Debug("dhclient running in the background as %d", p.Pid)
+	return p.Release()
+}
+
+// configureAll gets DHCPv6 leases once
+func configureAll(ctx context.Context, ifs []netlink.Link) {
 	packetTimeout := time.Duration(opts.Timeout) * time.Second
 
 	c := dhclient.Config{
 		Timeout: packetTimeout,
 		Retries: opts.Retry,
-		V4ServerAddr: &net.UDPAddr{
-			IP:   net.IPv4bcast,
-			Port: opts.V4Port,
-		},
 		V6ServerAddr: &net.UDPAddr{
 			IP:   net.ParseIP(opts.V6Server),
 			Port: opts.V6Port,
@@ -69,7 +186,7 @@ func configureAll(ifs []netlink.Link) {
 	if len(opts.Verbose) > 1 {
 		c.LogLevel = dhclient.LogDebug
 	}
-	r := dhclient.SendRequests(context.Background(), ifs, opts.ipv4, opts.ipv6, c, 30*time.Second)
+	r := dhclient.SendRequests(ctx, ifs, false, true, c, 30*time.Second)
 
 	for result := range r {
 		if result.Err != nil {
@@ -82,7 +199,7 @@ func configureAll(ifs []netlink.Link) {
 			log.Printf("Configured %s with %s", result.Interface.Attrs().Name, result.Lease)
 		}
 	}
-	log.Printf("Finished trying to configure all interfaces.")
+	Debug("Finished trying to configure all interfaces for IPv6.")
 }
 
 func init() {
@@ -90,6 +207,9 @@ func init() {
 	opts.V4Port = dhcpv4.ServerPort
 	opts.Timeout = 15
 	opts.Retry = 5
+	opts.StateDir = dhcp.DefaultStateDir
+	opts.ResolvConf = dhcp.DefaultResolvConf
+	opts.Script = dhcp.DefaultScript
 }
 
 func main() {
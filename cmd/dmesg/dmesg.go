@@ -1,45 +1,373 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/jessevdk/go-flags"
+	"github.com/mattn/go-isatty"
 	"golang.org/x/sys/unix"
 )
 
 var opts struct {
-	clear     bool `short:"C" long:"clear" description:""`
-	readClear bool `short:"c" long:"read-clear" description:""`
+	Clear      bool   `short:"C" long:"clear" description:"clear the kernel ring buffer"`
+	ReadClear  bool   `short:"c" long:"read-clear" description:"read and clear all messages"`
+	Decode     bool   `short:"x" long:"decode" description:"decode facility and level to readable strings"`
+	Raw        bool   `short:"r" long:"raw" description:"print the raw message buffer"`
+	Level      string `short:"l" long:"level" description:"restrict output to the given levels, comma separated"`
+	Facility   string `short:"f" long:"facility" description:"restrict output to the given facilities, comma separated"`
+	Follow     bool   `short:"w" long:"follow" description:"wait for new messages"`
+	FollowNew  bool   `short:"W" long:"follow-new" description:"wait and print only new messages"`
+	Ctime      bool   `short:"T" long:"ctime" description:"show human readable timestamps"`
+	Delta      bool   `short:"d" long:"show-delta" description:"show the time delta between printed messages"`
+	NoTime     bool   `short:"t" long:"notime" description:"do not show any timestamps"`
+	TimeFormat string `long:"time-format" choice:"raw" choice:"ctime" choice:"delta" choice:"iso" choice:"notime" description:"timestamp format"`
+	Color      string `short:"L" long:"color" optional:"yes" optional-value:"always" default:"auto" choice:"auto" choice:"always" choice:"never" description:"colorize messages"`
+	JSON       bool   `short:"J" long:"json" description:"use JSON output format, one object per line when following"`
+	Syslog     bool   `short:"S" long:"syslog" description:"force use of syslog(2) rather than /dev/kmsg"`
+}
+
+// ansi colors per level, indexed like levelNames
+var levelColors = []string{
+	"\x1b[1;41m",
+	"\x1b[1;31m",
+	"\x1b[1;31m",
+	"\x1b[31m",
+	"\x1b[1;33m",
+	"\x1b[1m",
+	"",
+	"",
+}
+
+const (
+	colorTime  = "\x1b[32m"
+	colorReset = "\x1b[0m"
+)
+
+// printer formats records one at a time, it keeps the state needed for
+// delta timestamps and the JSON array. When following, every record is
+// flushed and JSON records are written one per line, for there is no end
+// to close the array at.
+type printer struct {
+	w        *bufio.Writer
+	format   string
+	color    bool
+	follow   bool
+	boot     time.Time
+	last     time.Duration
+	printed  int
+	levels   map[int]bool
+	facility map[int]bool
+}
+
+// bootTime is the wall clock time the kernel timestamps count from
+func bootTime() time.Time {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_BOOTTIME, &ts); err != nil {
+		return time.Now()
+	}
+	return time.Now().Add(-time.Duration(ts.Nano()))
+}
+
+func (p *printer) timestamp(r *Record) string {
+	switch p.format {
+	case "notime":
+		return ""
+	case "ctime":
+		return "[" + p.boot.Add(r.Timestamp).Format("Mon Jan _2 15:04:05 2006") + "] "
+	case "iso":
+		return p.boot.Add(r.Timestamp).Format("2006-01-02T15:04:05,000000-07:00") + " "
+	case "delta":
+		var delta time.Duration
+		if p.printed > 0 {
+			delta = r.Timestamp - p.last
+		}
+		return fmt.Sprintf("[%5d.%06d <%5d.%06d>] ", secs(r.Timestamp), usecs(r.Timestamp), secs(delta), usecs(delta))
+	}
+	return fmt.Sprintf("[%5d.%06d] ", secs(r.Timestamp), usecs(r.Timestamp))
+}
+
+func secs(d time.Duration) int64 {
+	return int64(d / time.Second)
+}
+
+func usecs(d time.Duration) int64 {
+	return int64(d%time.Second) / int64(time.Microsecond)
+}
+
+// wanted applies the level and facility filters
+func (p *printer) wanted(r *Record) bool {
+	if p.levels != nil && !p.levels[r.Level] {
+		return false
+	}
+	if p.facility != nil && !p.facility[r.Facility] {
+		return false
+	}
+	return true
+}
+
+type jsonRecord struct {
+	Pri      *int   `json:"pri,omitempty"`
+	Facility string `json:"fac,omitempty"`
+	Level    string `json:"level,omitempty"`
+	Time     any    `json:"time,omitempty"`
+	Message  string `json:"msg"`
+}
+
+func (p *printer) printJSON(r *Record) error {
+	jr := jsonRecord{Message: r.Message}
+	if opts.Decode {
+		jr.Facility = r.FacilityName()
+		jr.Level = r.LevelName()
+	} else {
+		pri := r.Pri()
+		jr.Pri = &pri
+	}
+
+	switch p.format {
+	case "notime":
+	case "ctime", "iso", "delta":
+		jr.Time = strings.TrimSpace(strings.Trim(strings.TrimSpace(p.timestamp(r)), "[]"))
+	default:
+		jr.Time = json.Number(fmt.Sprintf("%d.%06d", secs(r.Timestamp), usecs(r.Timestamp)))
+	}
+
+	b, err := json.Marshal(jr)
+	if err != nil {
+		return err
+	}
+
+	if p.follow {
+		_, err = fmt.Fprintf(p.w, "%s\n", b)
+		return err
+	}
+	sep := ",\n"
+	if p.printed == 0 {
+		sep = "{\n   \"dmesg\": [\n"
+	}
+	_, err = fmt.Fprintf(p.w, "%s      %s", sep, b)
+	return err
+}
+
+func (p *printer) Print(r *Record) error {
+	if !p.wanted(r) {
+		return nil
+	}
+
+	var err error
+	switch {
+	case opts.JSON:
+		err = p.printJSON(r)
+	case opts.Raw:
+		_, err = fmt.Fprintf(p.w, "<%d>%s%s\n", r.Pri(), p.timestamp(r), r.Message)
+	default:
+		var b strings.Builder
+		if opts.Decode {
+			fmt.Fprintf(&b, "%-6s:%-6s: ", r.FacilityName(), r.LevelName())
+		}
+
+		ts := p.timestamp(r)
+		if p.color && ts != "" {
+			ts = colorTime + strings.TrimSuffix(ts, " ") + colorReset + " "
+		}
+		b.WriteString(ts)
+
+		msg := r.Message
+		if p.color && r.Level < len(levelColors) && levelColors[r.Level] != "" {
+			msg = levelColors[r.Level] + msg + colorReset
+		}
+		b.WriteString(msg)
+		b.WriteByte('\n')
+		_, err = p.w.WriteString(b.String())
+	}
+
+	p.last = r.Timestamp
+	p.printed++
+	if p.follow {
+		p.w.Flush()
+	}
+	return err
+}
+
+// Close terminates the JSON array and flushes the output
+func (p *printer) Close() error {
+	if opts.JSON && !p.follow && p.printed > 0 {
+		fmt.Fprint(p.w, "\n   ]\n}\n")
+	}
+	return p.w.Flush()
+}
+
+// readKmsg reads one record per read(2) from /dev/kmsg. Without follow it
+// stops once the buffer is drained, onlyNew skips the existing messages.
+func readKmsg(follow, onlyNew bool, fn func(*Record) error) error {
+	flags := unix.O_RDONLY | unix.O_CLOEXEC
+	if !follow {
+		flags |= unix.O_NONBLOCK
+	}
+
+	fd, err := unix.Open("/dev/kmsg", flags, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	if onlyNew {
+		_, err = unix.Seek(fd, 0, unix.SEEK_END)
+	} else {
+		// start after the last clear, like syslog(2) does
+		_, err = unix.Seek(fd, 0, unix.SEEK_DATA)
+	}
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 8192)
+	for {
+		n, err := unix.Read(fd, buf)
+		switch {
+		case err == unix.EAGAIN:
+			return nil
+		case err == unix.EPIPE || err == unix.EINTR:
+			// EPIPE: the ring buffer overwrote records we have not read yet
+			continue
+		case err != nil:
+			return err
+		}
+
+		r, err := ParseRecord(string(buf[:n]))
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+}
+
+// readSyslog reads the whole ring buffer with syslog(2)
+func readSyslog(fn func(*Record) error) error {
+	size, err := unix.Klogctl(unix.SYSLOG_ACTION_SIZE_BUFFER, nil)
+	if err != nil || size <= 0 {
+		size = 256 * 1024
+	}
+
+	b := make([]byte, size)
+	n, err := unix.Klogctl(unix.SYSLOG_ACTION_READ_ALL, b)
+	if err != nil {
+		return fmt.Errorf("syslog failed: %w", err)
+	}
+
+	for _, line := range strings.Split(strings.TrimRight(string(b[:n]), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		r, err := ParseSyslogLine(line)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func timeFormat() string {
+	switch {
+	case opts.TimeFormat != "":
+		return opts.TimeFormat
+	case opts.NoTime:
+		return "notime"
+	case opts.Ctime:
+		return "ctime"
+	case opts.Delta:
+		return "delta"
+	}
+	return "raw"
+}
+
+func newPrinter(w io.Writer, color bool) (*printer, error) {
+	p := &printer{
+		w:      bufio.NewWriter(w),
+		format: timeFormat(),
+		color:  color,
+		follow: opts.Follow || opts.FollowNew,
+		boot:   bootTime(),
+	}
+
+	var err error
+	if opts.Level != "" {
+		if p.levels, err = parseNames(opts.Level, levelNames); err != nil {
+			return nil, fmt.Errorf("--level: %w", err)
+		}
+	}
+	if opts.Facility != "" {
+		if p.facility, err = parseNames(opts.Facility, facilityNames); err != nil {
+			return nil, fmt.Errorf("--facility: %w", err)
+		}
+	}
+	return p, nil
 }
 
 func dmesg() error {
-	level := unix.SYSLOG_ACTION_READ_ALL
-	if opts.clear && opts.readClear {
+	if opts.Clear && opts.ReadClear {
 		return fmt.Errorf("cannot use --clear and --read-clear at the same time, they are exclusive")
 	}
-	if opts.readClear {
-		level = unix.SYSLOG_ACTION_READ_CLEAR
+	if opts.Clear {
+		if _, err := unix.Klogctl(unix.SYSLOG_ACTION_CLEAR, nil); err != nil {
+			return fmt.Errorf("syslog failed: %w", err)
+		}
+		return nil
 	}
-	if opts.clear {
-		level = unix.SYSLOG_ACTION_CLEAR
+
+	color := opts.Color == "always" || (opts.Color == "auto" && isatty.IsTerminal(os.Stdout.Fd()))
+	p, err := newPrinter(os.Stdout, color && !opts.JSON && !opts.Raw)
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+
+	follow := opts.Follow || opts.FollowNew
+	if opts.Syslog {
+		if follow {
+			return fmt.Errorf("--follow requires /dev/kmsg")
+		}
+		err = readSyslog(p.Print)
+	} else {
+		err = readKmsg(follow, opts.FollowNew, p.Print)
+		if errors.Is(err, unix.ENOENT) && !follow {
+			err = readSyslog(p.Print)
+		}
 	}
-	b := make([]byte, 256*1024)
-	o, err := unix.Klogctl(level, b)
 	if err != nil {
-		return fmt.Errorf("syslog failed: %w", err)
+		return err
 	}
 
-	_, err = os.Stdout.Write(b[:o])
-	return err
+	if opts.ReadClear {
+		if _, err := unix.Klogctl(unix.SYSLOG_ACTION_CLEAR, nil); err != nil {
+			return fmt.Errorf("syslog failed: %w", err)
+		}
+	}
+	return nil
 }
 
 func main() {
 	_, err := flags.Parse(&opts)
-	if err == flags.ErrHelp {
+	if flags.WroteHelp(err) {
 		os.Exit(0)
 	}
+	if err != nil {
+		os.Exit(1)
+	}
 
 	if err := dmesg(); err != nil {
 		log.Fatal(err)
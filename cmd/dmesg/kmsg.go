@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var levelNames = []string{
+	"emerg",
+	"alert",
+	"crit",
+	"err",
+	"warn",
+	"notice",
+	"info",
+	"debug",
+}
+
+var facilityNames = []string{
+	"kern",
+	"user",
+	"mail",
+	"daemon",
+	"auth",
+	"syslog",
+	"lpr",
+	"news",
+	"uucp",
+	"cron",
+	"authpriv",
+	"ftp",
+	"res0",
+	"res1",
+	"res2",
+	"res3",
+	"local0",
+	"local1",
+	"local2",
+	"local3",
+	"local4",
+	"local5",
+	"local6",
+	"local7",
+}
+
+// Record is a single message of the kernel ring buffer, see
+// Documentation/ABI/testing/dev-kmsg in the kernel tree
+type Record struct {
+	Facility int
+	Level    int
+	Seq      uint64
+	// time since boot
+	Timestamp time.Duration
+	// '-' for a complete message, 'c' for a continuation and '+' for a fragment
+	Flag    byte
+	Message string
+	// key value pairs from the continuation lines, like SUBSYSTEM and DEVICE
+	Dict map[string]string
+}
+
+func (r *Record) Pri() int {
+	return r.Facility<<3 | r.Level
+}
+
+// LevelName returns the level name, or the number if it is out of range
+func (r *Record) LevelName() string {
+	if r.Level < len(levelNames) {
+		return levelNames[r.Level]
+	}
+	return strconv.Itoa(r.Level)
+}
+
+// FacilityName returns the facility name, or the number if it is out of range
+func (r *Record) FacilityName() string {
+	if r.Facility < len(facilityNames) {
+		return facilityNames[r.Facility]
+	}
+	return strconv.Itoa(r.Facility)
+}
+
+// unescape decodes the \xNN escapes the kernel uses for non printable bytes
+func unescape(s string) string {
+	if !strings.Contains(s, `\x`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) && s[i+1] == 'x' {
+			if n, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// ParseRecord parses one record as read from /dev/kmsg:
+//
+//	6,339,5140900,-;NET: Registered protocol family 10
+//	 SUBSYSTEM=net
+//	 DEVICE=n1
+func ParseRecord(data string) (*Record, error) {
+	data = strings.TrimRight(data, "\n")
+	lines := strings.Split(data, "\n")
+
+	prefix, msg, ok := strings.Cut(lines[0], ";")
+	if !ok {
+		return nil, fmt.Errorf("malformed record %q: missing ';'", lines[0])
+	}
+
+	fields := strings.Split(prefix, ",")
+	if len(fields) < 4 {
+		return nil, fmt.Errorf("malformed record %q: want at least 4 fields", prefix)
+	}
+
+	pri, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed priority %q", fields[0])
+	}
+	seq, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("malformed sequence %q", fields[1])
+	}
+	usec, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("malformed timestamp %q", fields[2])
+	}
+	if len(fields[3]) != 1 {
+		return nil, fmt.Errorf("malformed flag %q", fields[3])
+	}
+
+	r := &Record{
+		Facility:  pri >> 3,
+		Level:     pri & 7,
+		Seq:       seq,
+		Timestamp: time.Duration(usec) * time.Microsecond,
+		Flag:      fields[3][0],
+		Message:   unescape(msg),
+	}
+
+	for _, line := range lines[1:] {
+		if !strings.HasPrefix(line, " ") {
+			continue
+		}
+		key, val, ok := strings.Cut(line[1:], "=")
+		if !ok {
+			continue
+		}
+		if r.Dict == nil {
+			r.Dict = make(map[string]string)
+		}
+		r.Dict[key] = unescape(val)
+	}
+	return r, nil
+}
+
+// ParseSyslogLine parses a line of the syslog(2) buffer, "<6>[    5.140900] msg",
+// used when /dev/kmsg is not available
+func ParseSyslogLine(line string) (*Record, error) {
+	r := &Record{Flag: '-'}
+
+	if strings.HasPrefix(line, "<") {
+		end := strings.IndexByte(line, '>')
+		if end < 0 {
+			return nil, fmt.Errorf("malformed priority in %q", line)
+		}
+		pri, err := strconv.Atoi(line[1:end])
+		if err != nil {
+			return nil, fmt.Errorf("malformed priority in %q", line)
+		}
+		r.Facility = pri >> 3
+		r.Level = pri & 7
+		line = line[end+1:]
+	}
+
+	if strings.HasPrefix(line, "[") {
+		end := strings.IndexByte(line, ']')
+		if end < 0 {
+			return nil, fmt.Errorf("malformed timestamp in %q", line)
+		}
+		secs, err := strconv.ParseFloat(strings.TrimSpace(line[1:end]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed timestamp in %q", line)
+		}
+		r.Timestamp = time.Duration(secs * float64(time.Second)).Round(time.Microsecond)
+		line = strings.TrimPrefix(line[end+1:], " ")
+	}
+
+	r.Message = line
+	return r, nil
+}
+
+// parseNames turns a comma separated list of names into a set of indexes
+func parseNames(list string, names []string) (map[int]bool, error) {
+	set := make(map[int]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		found := false
+		for i, n := range names {
+			if n == name {
+				set[i] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			// util-linux accepts the numbers as well
+			if i, err := strconv.Atoi(name); err == nil && i >= 0 && i < len(names) {
+				set[i] = true
+				continue
+			}
+			return nil, fmt.Errorf("unknown name: %q", name)
+		}
+	}
+	return set, nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRecord(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		input   string
+		want    *Record
+		wantErr bool
+	}{
+		{
+			name:  "plain",
+			input: "6,339,5140900,-;NET: Registered protocol family 10\n",
+			want: &Record{
+				Facility:  0,
+				Level:     6,
+				Seq:       339,
+				Timestamp: 5140900 * time.Microsecond,
+				Flag:      '-',
+				Message:   "NET: Registered protocol family 10",
+			},
+		},
+		{
+			name:  "dictionary",
+			input: "6,339,5140900,-;NET: Registered protocol family 10\n SUBSYSTEM=net\n DEVICE=n1\n",
+			want: &Record{
+				Level:     6,
+				Seq:       339,
+				Timestamp: 5140900 * time.Microsecond,
+				Flag:      '-',
+				Message:   "NET: Registered protocol family 10",
+				Dict:      map[string]string{"SUBSYSTEM": "net", "DEVICE": "n1"},
+			},
+		},
+		{
+			name:  "facility and continuation flag",
+			input: "30,1024,0,c;systemd[1]: started",
+			want: &Record{
+				Facility: 3,
+				Level:    6,
+				Seq:      1024,
+				Flag:     'c',
+				Message:  "systemd[1]: started",
+			},
+		},
+		{
+			name:  "extra prefix fields",
+			input: "4,12,345,-,caller=T1;warning",
+			want: &Record{
+				Level:     4,
+				Seq:       12,
+				Timestamp: 345 * time.Microsecond,
+				Flag:      '-',
+				Message:   "warning",
+			},
+		},
+		{
+			name:  "escaped bytes",
+			input: `5,1,2,-;tab\x09and\x5cbackslash`,
+			want: &Record{
+				Level:     5,
+				Seq:       1,
+				Timestamp: 2 * time.Microsecond,
+				Flag:      '-',
+				Message:   "tab\tand\\backslash",
+			},
+		},
+		{
+			name:  "semicolon in message",
+			input: "6,2,3,-;a;b",
+			want: &Record{
+				Level:     6,
+				Seq:       2,
+				Timestamp: 3 * time.Microsecond,
+				Flag:      '-',
+				Message:   "a;b",
+			},
+		},
+		{name: "no separator", input: "6,339,5140900,-", wantErr: true},
+		{name: "short prefix", input: "6,339;msg", wantErr: true},
+		{name: "bad priority", input: "x,339,5140900,-;msg", wantErr: true},
+		{name: "bad timestamp", input: "6,339,abc,-;msg", wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRecord(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseRecord(%q) = %+v, want error", tt.input, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseRecord(%q) = %v", tt.input, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseRecord(%q) = %+v, want %+v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSyslogLine(t *testing.T) {
+	got, err := ParseSyslogLine("<14>[   12.345678] hello world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Record{
+		Facility:  1,
+		Level:     6,
+		Timestamp: 12345678 * time.Microsecond,
+		Flag:      '-',
+		Message:   "hello world",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseSyslogLine() = %+v, want %+v", got, want)
+	}
+}
+
+func TestRecordNames(t *testing.T) {
+	r, err := ParseRecord("27,1,0,-;msg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.FacilityName() != "daemon" || r.LevelName() != "err" || r.Pri() != 27 {
+		t.Errorf("got %v.%v (%d), want daemon.err (27)", r.FacilityName(), r.LevelName(), r.Pri())
+	}
+}
+
+func TestParseNames(t *testing.T) {
+	set, err := parseNames("err,warn,7", levelNames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[int]bool{3: true, 4: true, 7: true}; !reflect.DeepEqual(set, want) {
+		t.Errorf("parseNames() = %v, want %v", set, want)
+	}
+
+	if _, err := parseNames("err,nope", levelNames); err == nil {
+		t.Errorf("parseNames() with unknown name = nil, want error")
+	}
+}
+
+func TestPrintJSON(t *testing.T) {
+	saved := opts
+	defer func() { opts = saved }()
+	records := []*Record{
+		{Level: 6, Timestamp: 5140900 * time.Microsecond, Message: "one"},
+		{Level: 3, Timestamp: 6 * time.Second, Message: "two"},
+	}
+	for _, tt := range []struct {
+		name   string
+		follow bool
+		want   string
+	}{
+		{
+			name: "array",
+			want: "{\n   \"dmesg\": [\n" +
+				"      {\"pri\":6,\"time\":5.140900,\"msg\":\"one\"},\n" +
+				"      {\"pri\":3,\"time\":6.000000,\"msg\":\"two\"}\n   ]\n}\n",
+		},
+		{
+			name:   "follow",
+			follow: true,
+			want: "{\"pri\":6,\"time\":5.140900,\"msg\":\"one\"}\n" +
+				"{\"pri\":3,\"time\":6.000000,\"msg\":\"two\"}\n",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			opts = saved
+			opts.JSON, opts.Follow = true, tt.follow
+			var out bytes.Buffer
+			p, err := newPrinter(&out, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, r := range records {
+				if err := p.Print(r); err != nil {
+					t.Fatal(err)
+				}
+				// following, each record is out before the next
+				if tt.follow && bytes.Count(out.Bytes(), []byte("\n")) != i+1 {
+					t.Errorf("after %d records, printed %q", i+1, out.String())
+				}
+			}
+			if err := p.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("printed %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,385 @@
+// flash identifies, reads, verifies and writes MTD flash chips, like a
+// small flashrom.
+//
+// Synopsis:
+//
+//	flash [-d DEVICE] [--jedec ID] [-i]
+//	flash [-d DEVICE] [-q] -r FILE
+//	flash [-d DEVICE] [-q] -v FILE
+//	flash [-d DEVICE] [-q] [-e SIZE] -w FILE
+//
+// -i, the default, identifies the chip by its JEDEC ID, as the spi-nor
+// driver shows it in sysfs, or as given with --jedec, like c84018.
+//
+// -w writes FILE at the start of the device an erase block at a time.
+// Blocks that are the same as in FILE are left alone, and blocks are only
+// erased if some of their bits must go from 0 to 1. The device is read
+// back and verified after.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+
+	"mybox/pkg/mount/mtd"
+)
+
+// options are the flags of flash
+type options struct {
+	Device    string `short:"d" long:"device" default:"/dev/mtd0" description:"the MTD device"`
+	Identify  bool   `short:"i" long:"identify" description:"identify the chip"`
+	JEDEC     string `long:"jedec" value-name:"ID" description:"the JEDEC ID of the chip, in hex"`
+	Read      string `short:"r" long:"read" value-name:"FILE" description:"read the device into FILE"`
+	Verify    string `short:"v" long:"verify" value-name:"FILE" description:"verify the device against FILE"`
+	Write     string `short:"w" long:"write" value-name:"FILE" description:"write FILE to the device"`
+	EraseSize int64  `short:"e" long:"erase-size" value-name:"SIZE" description:"the erase block size, if the device can not tell"`
+	Quiet     bool   `short:"q" long:"quiet" description:"do not show progress"`
+}
+
+// Debug prints what flash does
+var Debug = func(string, ...interface{}) {}
+
+// sysfs is where the MTD devices are described
+var sysfs = "/sys"
+
+// defaultEraseSize is the smallest erase block of most SPI NOR chips
+const defaultEraseSize = 4096
+
+// readSize is how much is read at once, and progress shown for
+const readSize = 64 << 10
+
+// progress shows how much of a task is done, as a percentage
+type progress struct {
+	w       io.Writer
+	task    string
+	percent int64
+}
+
+func newProgress(w io.Writer, task string) *progress {
+	return &progress{w: w, task: task, percent: -1}
+}
+
+// update shows done of total, when the percentage changes
+func (p *progress) update(done, total int64) {
+	percent := int64(100)
+	if total > 0 {
+		percent = done * 100 / total
+	}
+	if percent == p.percent {
+		return
+	}
+	p.percent = percent
+	fmt.Fprintf(p.w, "\r%s: %3d%%", p.task, percent)
+	if percent == 100 {
+		fmt.Fprintln(p.w)
+	}
+}
+
+// sizer is a Flasher that knows its size, like mtd.Dev
+type sizer interface {
+	Size() (int64, error)
+}
+
+// size is the size of f, or -1 if it can not tell
+func size(f mtd.Flasher) (int64, error) {
+	if s, ok := f.(sizer); ok {
+		return s.Size()
+	}
+	return -1, nil
+}
+
+// attr reads an attribute of the MTD device dev in sysfs
+func attr(dev, name string) (string, error) {
+	b, err := os.ReadFile(filepath.Join(sysfs, "class/mtd", filepath.Base(dev), name))
+	return strings.TrimSpace(string(b)), err
+}
+
+// parseJEDEC splits a JEDEC ID into the vendor, with its continuation
+// codes, and the chip
+func parseJEDEC(s string) (mtd.VendorID, mtd.ChipID, error) {
+	s = strings.TrimPrefix(strings.ToLower(s), "0x")
+	if len(s) < 4 || len(s)%2 != 0 {
+		return 0, 0, fmt.Errorf("JEDEC ID %q: want at least 2 bytes in hex", s)
+	}
+	if _, err := strconv.ParseUint(s, 16, 64); err != nil || len(s) > 16 {
+		return 0, 0, fmt.Errorf("JEDEC ID %q: want at most 8 bytes in hex", s)
+	}
+	i := 0
+	for i+2 < len(s) && s[i:i+2] == "7f" {
+		i += 2
+	}
+	vid, _ := strconv.ParseUint(s[:i+2], 16, 64)
+	did, err := strconv.ParseUint(s[i+2:], 16, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("JEDEC ID %q: no chip ID after the vendor", s)
+	}
+	return mtd.VendorID(vid), mtd.ChipID(did), nil
+}
+
+// identify prints the chip of dev, from its JEDEC ID, and what the kernel
+// says of the device
+func (opts *options) identify(w io.Writer, dev string) error {
+	for _, a := range []struct{ name, attr string }{
+		{"Name", "name"},
+		{"Type", "type"},
+		{"Size", "size"},
+		{"Erase size", "erasesize"},
+	} {
+		if v, err := attr(dev, a.attr); err == nil {
+			fmt.Fprintf(w, "%-11s %s\n", a.name+":", v)
+		}
+	}
+	id := opts.JEDEC
+	if id == "" {
+		var err error
+		if id, err = attr(dev, "device/spi-nor/jedec_id"); err != nil {
+			return fmt.Errorf("%s: no JEDEC ID in sysfs, give one with --jedec", dev)
+		}
+	}
+	vid, did, err := parseJEDEC(id)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%-11s %s\n", "JEDEC ID:", id)
+	v, err := mtd.VendorFromID(vid)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%-11s %s\n", "Vendor:", v.Name())
+	c, err := v.Chip(did)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%-11s %s\n", "Chip:", c)
+	if !mtd.Supported(c) {
+		fmt.Fprintf(w, "%-11s %s\n", "Warning:", "the size of the chip is not known")
+	}
+	return nil
+}
+
+// dump copies all of f to out
+func dump(f mtd.Flasher, out io.Writer, p *progress) error {
+	total, err := size(f)
+	if err != nil {
+		return err
+	}
+	if total < 0 {
+		return fmt.Errorf("%s: the size of the device is not known", f.Name())
+	}
+	buf := make([]byte, readSize)
+	for off := int64(0); off < total; off += readSize {
+		b := buf[:min(readSize, total-off)]
+		if _, err := f.ReadAt(b, off); err != nil {
+			return fmt.Errorf("%s: reading at %#x: %w", f.Name(), off, err)
+		}
+		if _, err := out.Write(b); err != nil {
+			return err
+		}
+		p.update(off+int64(len(b)), total)
+	}
+	p.update(total, total)
+	return nil
+}
+
+// verify compares the start of f with image
+func verify(f mtd.Flasher, image []byte, p *progress) error {
+	buf := make([]byte, readSize)
+	total := int64(len(image))
+	for off := int64(0); off < total; off += readSize {
+		want := image[off:min(off+readSize, total)]
+		b := buf[:len(want)]
+		if _, err := f.ReadAt(b, off); err != nil {
+			return fmt.Errorf("%s: reading at %#x: %w", f.Name(), off, err)
+		}
+		if i := mismatch(b, want); i >= 0 {
+			return fmt.Errorf("%s: differs at %#x: %#02x, want %#02x", f.Name(), off+int64(i), b[i], want[i])
+		}
+		p.update(off+int64(len(b)), total)
+	}
+	p.update(total, total)
+	return nil
+}
+
+// mismatch is the first index where a and b differ, or -1
+func mismatch(a, b []byte) int {
+	for i := range a {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return -1
+}
+
+// needsErase tells whether writing b over old needs an erase first, to
+// set bits to 1
+func needsErase(old, b []byte) bool {
+	for i := range old {
+		if old[i]&b[i] != b[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// stats are what write did
+type stats struct {
+	blocks, written, erased int
+}
+
+// block is an erase block to write
+type block struct {
+	off   int64
+	b     []byte
+	erase bool
+}
+
+// write writes image to the start of f, a block of eraseSize bytes at a
+// time. Blocks that do not change are not written, and those that only
+// have bits going from 1 to 0 are not erased. The end of the last block
+// stays as it was. All is read before anything is written, for Flashers
+// that queue their writes.
+func write(f mtd.Flasher, image []byte, eraseSize int64, p *progress) (stats, error) {
+	var s stats
+	total, err := size(f)
+	if err != nil {
+		return s, err
+	}
+	if total < 0 {
+		return s, fmt.Errorf("%s: the size of the device is not known", f.Name())
+	}
+	if int64(len(image)) > total {
+		return s, fmt.Errorf("%s: the image is %d bytes, the device %d", f.Name(), len(image), total)
+	}
+	eraser, _ := f.(mtd.Eraser)
+	length := int64(len(image))
+	var blocks []block
+	for off := int64(0); off < length; off += eraseSize {
+		s.blocks++
+		old := make([]byte, min(eraseSize, total-off))
+		if _, err := f.ReadAt(old, off); err != nil {
+			return s, fmt.Errorf("%s: reading at %#x: %w", f.Name(), off, err)
+		}
+		b := bytes.Clone(old)
+		copy(b, image[off:min(off+eraseSize, length)])
+		if !bytes.Equal(old, b) {
+			blocks = append(blocks, block{off: off, b: b, erase: eraser != nil && needsErase(old, b)})
+		}
+	}
+
+	for i, b := range blocks {
+		if b.erase {
+			Debug("erasing %#x, %d bytes", b.off, len(b.b))
+			if err := eraser.Erase(b.off, int64(len(b.b))); err != nil {
+				return s, fmt.Errorf("%s: erasing at %#x: %w", f.Name(), b.off, err)
+			}
+			s.erased++
+		}
+		Debug("writing %#x, %d bytes", b.off, len(b.b))
+		if _, err := f.QueueWriteAt(b.b, b.off); err != nil {
+			return s, fmt.Errorf("%s: writing at %#x: %w", f.Name(), b.off, err)
+		}
+		s.written++
+		p.update(int64(i+1), int64(len(blocks)))
+	}
+	p.update(int64(len(blocks)), int64(len(blocks)))
+	return s, f.SyncWrite()
+}
+
+func run(w io.Writer, opts options, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments %q", args)
+	}
+	n := 0
+	for _, set := range []bool{opts.Identify, opts.Read != "", opts.Verify != "", opts.Write != ""} {
+		if set {
+			n++
+		}
+	}
+	if n > 1 {
+		return fmt.Errorf("only one of -i, -r, -v and -w at once")
+	}
+	if n == 0 || opts.Identify {
+		return opts.identify(w, opts.Device)
+	}
+
+	f, err := mtd.NewDev(opts.Device)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// progress goes to stderr, unless -q
+	var shown io.Writer = os.Stderr
+	if opts.Quiet {
+		shown = io.Discard
+	}
+
+	switch {
+	case opts.Read != "":
+		out, err := os.Create(opts.Read)
+		if err != nil {
+			return err
+		}
+		if err := dump(f, out, newProgress(shown, "reading")); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	case opts.Verify != "":
+		image, err := os.ReadFile(opts.Verify)
+		if err != nil {
+			return err
+		}
+		if err := verify(f, image, newProgress(shown, "verifying")); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s: verified %d bytes\n", opts.Device, len(image))
+		return nil
+	}
+
+	image, err := os.ReadFile(opts.Write)
+	if err != nil {
+		return err
+	}
+	eraseSize := opts.EraseSize
+	if e, ok := f.(mtd.Eraser); ok && eraseSize == 0 {
+		eraseSize = e.EraseSize()
+	}
+	if eraseSize <= 0 {
+		eraseSize = defaultEraseSize
+	}
+	s, err := write(f, image, eraseSize, newProgress(shown, "writing"))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%s: wrote %d of %d blocks of %d bytes, erased %d\n", opts.Device, s.written, s.blocks, eraseSize, s.erased)
+	if err := verify(f, image, newProgress(shown, "verifying")); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%s: verified %d bytes\n", opts.Device, len(image))
+	return nil
+}
+
+func main() {
+	var opts options
+	args, err := flags.Parse(&opts)
+	if flags.WroteHelp(err) {
+		os.Exit(0)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+	log.SetFlags(0)
+	log.SetPrefix("flash: ")
+
+	if err := run(os.Stdout, opts, args); err != nil {
+		log.Fatal(err)
+	}
+}
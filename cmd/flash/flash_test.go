@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"mybox/pkg/mount/mtd"
+)
+
+// mem is a NOR flash in memory: writes only clear bits, and only erasing
+// sets them again. Writes are queued until SyncWrite.
+type mem struct {
+	data      []byte
+	eraseSize int64
+	queue     []func()
+	erased    []int64
+	written   []int64
+}
+
+func (m *mem) ReadAt(b []byte, off int64) (int, error) {
+	if len(m.queue) > 0 {
+		return 0, fmt.Errorf("reading with %d writes queued", len(m.queue))
+	}
+	return copy(b, m.data[off:]), nil
+}
+
+func (m *mem) QueueWriteAt(b []byte, off int64) (int, error) {
+	b = bytes.Clone(b)
+	m.written = append(m.written, off)
+	m.queue = append(m.queue, func() {
+		for i := range b {
+			m.data[off+int64(i)] &= b[i]
+		}
+	})
+	return len(b), nil
+}
+
+func (m *mem) SyncWrite() error {
+	for _, w := range m.queue {
+		w()
+	}
+	m.queue = nil
+	return nil
+}
+
+func (m *mem) Close() error         { return nil }
+func (m *mem) Name() string         { return "mem" }
+func (m *mem) Size() (int64, error) { return int64(len(m.data)), nil }
+func (m *mem) EraseSize() int64     { return m.eraseSize }
+
+func (m *mem) Erase(off, size int64) error {
+	if off%m.eraseSize != 0 || (size%m.eraseSize != 0 && off+size != int64(len(m.data))) {
+		return fmt.Errorf("erasing %#x, %d bytes, not in blocks of %d", off, size, m.eraseSize)
+	}
+	m.erased = append(m.erased, off)
+	copy(m.data[off:off+size], bytes.Repeat([]byte{0xff}, int(size)))
+	return nil
+}
+
+var (
+	_ mtd.Flasher = &mem{}
+	_ mtd.Eraser  = &mem{}
+)
+
+func TestWrite(t *testing.T) {
+	const block = 16
+	// four blocks, the last cut short
+	old := []byte(strings.Repeat("\xff", block) + strings.Repeat("A", block) + strings.Repeat("B", block) + "CCCCCCCC")
+	for _, tt := range []struct {
+		name    string
+		image   []byte
+		written []int64
+		erased  []int64
+		want    []byte
+	}{
+		{
+			name:  "same",
+			image: old,
+		},
+		{
+			name:    "programming an erased block",
+			image:   append([]byte(strings.Repeat("Z", block)), old[block:]...),
+			written: []int64{0},
+		},
+		{
+			// 'A' is 0x41, '@' 0x40: only a bit goes to 0
+			name:    "clearing bits",
+			image:   []byte(strings.Repeat("\xff", block) + strings.Repeat("@", block) + strings.Repeat("B", block) + "CCCCCCCC"),
+			written: []int64{block},
+		},
+		{
+			// 'C' is 0x43: a bit goes to 1
+			name:    "setting bits",
+			image:   []byte(strings.Repeat("\xff", block) + strings.Repeat("A", block) + strings.Repeat("C", block) + "CCCCCCCC"),
+			written: []int64{2 * block},
+			erased:  []int64{2 * block},
+		},
+		{
+			name:    "short image",
+			image:   []byte("\xff\xff\xff\xff"),
+			written: nil,
+			want:    old,
+		},
+		{
+			name:    "short image ending in a block",
+			image:   append(bytes.Clone(old[:block]), "abc"...),
+			written: []int64{block},
+			erased:  []int64{block},
+			want:    []byte(strings.Repeat("\xff", block) + "abc" + strings.Repeat("A", block-3) + strings.Repeat("B", block) + "CCCCCCCC"),
+		},
+		{
+			name:    "last block",
+			image:   append(bytes.Clone(old[:3*block]), "DDDDDDDD"...),
+			written: []int64{3 * block},
+			erased:  []int64{3 * block},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mem{data: bytes.Clone(old), eraseSize: block}
+			s, err := write(m, tt.image, block, newProgress(&bytes.Buffer{}, "writing"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.want
+			if want == nil {
+				want = tt.image
+			}
+			if !bytes.Equal(m.data, want) {
+				t.Errorf("got %q, want %q", m.data, want)
+			}
+			if fmt.Sprint(m.written) != fmt.Sprint(tt.written) || fmt.Sprint(m.erased) != fmt.Sprint(tt.erased) {
+				t.Errorf("wrote %v and erased %v, want %v and %v", m.written, m.erased, tt.written, tt.erased)
+			}
+			if s.blocks != (len(tt.image)+block-1)/block || s.written != len(tt.written) || s.erased != len(tt.erased) {
+				t.Errorf("got %+v for %d written and %d erased", s, len(tt.written), len(tt.erased))
+			}
+			if err := verify(m, want, newProgress(&bytes.Buffer{}, "verifying")); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+
+	m := &mem{data: bytes.Clone(old), eraseSize: block}
+	if _, err := write(m, append(old, 0), block, newProgress(&bytes.Buffer{}, "writing")); err == nil {
+		t.Error("an image bigger than the device is written")
+	}
+}
+
+func TestProgress(t *testing.T) {
+	var out bytes.Buffer
+	p := newProgress(&out, "reading")
+	for _, done := range []int64{0, 1, 2, 50, 50, 100} {
+		p.update(done, 100)
+	}
+	if want := "\rreading:   0%\rreading:   1%\rreading:   2%\rreading:  50%\rreading: 100%\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
+
+func TestParseJEDEC(t *testing.T) {
+	for _, tt := range []struct {
+		in  string
+		vid mtd.VendorID
+		did mtd.ChipID
+		err bool
+	}{
+		{in: "c84018", vid: 0xc8, did: 0x4018},
+		{in: "0xEF4018", vid: 0xef, did: 0x4018},
+		{in: "7f9d2012", vid: 0x7f9d, did: 0x2012},
+		{in: "c8", err: true},
+		{in: "c8401", err: true},
+		{in: "zz4018", err: true},
+		{in: "7f7f", err: true},
+	} {
+		vid, did, err := parseJEDEC(tt.in)
+		if (err != nil) != tt.err || vid != tt.vid || did != tt.did {
+			t.Errorf("parseJEDEC(%q) = %#x, %#x, %v", tt.in, vid, did, err)
+		}
+	}
+}
+
+// flash runs run quietly with opts
+func flash(t *testing.T, opts options) (string, error) {
+	t.Helper()
+	opts.Quiet = true
+	var out bytes.Buffer
+	err := run(&out, opts, nil)
+	return out.String(), err
+}
+
+func TestIdentify(t *testing.T) {
+	dir := t.TempDir()
+	saved := sysfs
+	defer func() { sysfs = saved }()
+	sysfs = dir
+	for name, value := range map[string]string{
+		"name":                    "spi0.0",
+		"type":                    "nor",
+		"size":                    "16777216",
+		"erasesize":               "4096",
+		"device/spi-nor/jedec_id": "c84018",
+	} {
+		p := filepath.Join(dir, "class/mtd/mtd0", name)
+		os.MkdirAll(filepath.Dir(p), 0o755)
+		if err := os.WriteFile(p, []byte(value+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out, err := flash(t, options{Device: "/dev/mtd0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range []string{"Name:       spi0.0\n", "Erase size: 4096\n", "JEDEC ID:   c84018\n", "Vendor:     GIGADEVICE\n", "Chip:       GIGADEVICE/GD25Q128"} {
+		if !strings.Contains(out, w) {
+			t.Errorf("no %q in\n%s", w, out)
+		}
+	}
+
+	if _, err := flash(t, options{Device: "/dev/mtd0", JEDEC: "ba4018"}); err == nil || !strings.Contains(err.Error(), "no chip with id 0x4018") {
+		t.Errorf("got %v, want no chip 0x4018 for the vendor", err)
+	}
+	if _, err := flash(t, options{Device: "/dev/mtd1"}); err == nil || !strings.Contains(err.Error(), "--jedec") {
+		t.Errorf("got %v, want no JEDEC ID for mtd1", err)
+	}
+}
+
+// TestFile writes, reads and verifies a file standing in for an MTD device
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+	dev := filepath.Join(dir, "mtd")
+	image := filepath.Join(dir, "image")
+	dump := filepath.Join(dir, "dump")
+	data := bytes.Repeat([]byte("0123456789abcdef"), 20000)
+	if err := os.WriteFile(dev, make([]byte, 512<<10), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(image, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := flash(t, options{Device: dev, Write: image})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 320000 bytes are 79 blocks of 4096 bytes
+	if want := dev + ": wrote 79 of 79 blocks of 4096 bytes, erased 79\n" + dev + ": verified 320000 bytes\n"; out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+
+	// a byte changes: one block is written again
+	data[5000] = 'X'
+	if err := os.WriteFile(image, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, err = flash(t, options{Device: dev, Write: image, EraseSize: 8192})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "wrote 1 of 40 blocks of 8192 bytes, erased 1\n"; !strings.Contains(out, want) {
+		t.Errorf("got %q, want %q", out, want)
+	}
+
+	if _, err := flash(t, options{Device: dev, Verify: image}); err != nil {
+		t.Error(err)
+	}
+	if _, err := flash(t, options{Device: dev, Read: dump}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 512<<10 || !bytes.Equal(b[:len(data)], data) || b[len(data)] != 0 {
+		t.Errorf("the dump is not the device")
+	}
+
+	data[300000] = 'Y'
+	os.WriteFile(image, data, 0o644)
+	if _, err := flash(t, options{Device: dev, Verify: image}); err == nil || !strings.Contains(err.Error(), "differs at 0x493e0") {
+		t.Errorf("got %v, want a difference at 0x493e0", err)
+	}
+	if _, err := flash(t, options{Device: dev, Verify: image, Write: image}); err == nil {
+		t.Error("-v and -w together are not an error")
+	}
+}
@@ -9,7 +9,7 @@
 //
 // Synopsis:
 //
-//	free [-k] [-m] [-g] [-t] [-h] [-json]
+//	free [-k] [-m] [-g] [-t] [-h] [-w] [-s N] [-c N] [-json]
 //
 // Description:
 //
@@ -23,6 +23,9 @@
 //	-g: display the values in gibibytes
 //	-t: display the values in tebibytes
 //	-h: display the values in human-readable form
+//	-w: wide output, show buffers and cache in separate columns
+//	-s: repeat printing every N seconds
+//	-c: repeat printing N times, then exit
 //	-json: use JSON output
 package main
 
@@ -33,6 +36,9 @@ import (
 	"io"
 	"log"
 	"os"
+	"time"
+
+	"mybox/pkg/sysinfo"
 )
 
 var (
@@ -43,6 +49,9 @@ var (
 	inGB        = flag.Bool("g", false, "Express the values in gibibytes")
 	inTB        = flag.Bool("t", false, "Express the values in tebibytes")
 	toJSON      = flag.Bool("json", false, "Use JSON for output")
+	wide        = flag.Bool("w", false, "Wide output: show buffers and cache in separate columns")
+	seconds     = flag.Float64("s", 0, "Repeat printing every N seconds")
+	count       = flag.Int("c", 0, "Repeat printing N times, then exit")
 )
 
 type unit uint
@@ -64,44 +73,6 @@ var units = [...]string{"B", "K", "M", "G", "T"}
 
 var errMultipleUnits = fmt.Errorf("multiple unit options doesn't make sense")
 
-// the following types are used for JSON serialization
-type mainMemInfo struct {
-	Total     uint64 `json:"total"`
-	Used      uint64 `json:"used"`
-	Free      uint64 `json:"free"`
-	Shared    uint64 `json:"shared"`
-	Cached    uint64 `json:"cached"`
-	Buffers   uint64 `json:"buffers"`
-	Available uint64 `json:"available"`
-}
-
-type swapInfo struct {
-	Total uint64 `json:"total"`
-	Used  uint64 `json:"used"`
-	Free  uint64 `json:"free"`
-}
-
-// MemInfo represents the main memory and swap space information in a structured
-// manner, suitable for JSON encoding.
-type MemInfo struct {
-	Mem  mainMemInfo `json:"mem"`
-	Swap swapInfo    `json:"swap"`
-}
-
-type meminfomap map[string]uint64
-
-// missingRequiredFields checks if any of the specified fields are present in
-// the input map.
-func missingRequiredFields(m meminfomap, fields []string) bool {
-	for _, f := range fields {
-		if _, ok := m[f]; !ok {
-			log.Printf("Missing field '%v'", f)
-			return true
-		}
-	}
-	return false
-}
-
 // humanReadableValue returns a string representing the input value, treated as
 // a size in bytes, interpreted in a human readable form. E.g. the number 10240
 // woud return the string "10 kB". Note that the decimal part is truncated, not
@@ -143,7 +114,18 @@ func (c *cmd) formatValueByConfig(value uint64) string {
 
 func main() {
 	flag.Parse()
-	o := options{human: *humanOutput, bytes: *inBytes, kbytes: *inKB, mbytes: *inMB, gbytes: *inGB, tbytes: *inTB, json: *toJSON}
+	o := options{
+		human:  *humanOutput,
+		bytes:  *inBytes,
+		kbytes: *inKB,
+		mbytes: *inMB,
+		gbytes: *inGB,
+		tbytes: *inTB,
+		json:   *toJSON,
+		wide:   *wide,
+		delay:  time.Duration(*seconds * float64(time.Second)),
+		count:  *count,
+	}
 	cmd, err := command(os.Stdout, o)
 	if err != nil {
 		log.Fatal(err)
@@ -158,6 +140,9 @@ type cmd struct {
 	unit   unit
 	human  bool
 	toJSON bool
+	wide   bool
+	delay  time.Duration
+	count  int
 }
 
 type options struct {
@@ -168,6 +153,9 @@ type options struct {
 	gbytes bool
 	tbytes bool
 	json   bool
+	wide   bool
+	delay  time.Duration
+	count  int
 }
 
 func countTrue(b ...bool) int {
@@ -188,9 +176,20 @@ func command(stdout io.Writer, o options) (*cmd, error) {
 		return nil, errMultipleUnits
 	}
 
+	if o.delay < 0 || o.count < 0 {
+		return nil, fmt.Errorf("the repeat delay and count can not be negative")
+	}
+
 	c := &cmd{
 		stdout: stdout,
 		toJSON: o.json,
+		wide:   o.wide,
+		delay:  o.delay,
+		count:  o.count,
+	}
+	// like procps, a count alone repeats every second
+	if c.count > 0 && c.delay == 0 {
+		c.delay = time.Second
 	}
 
 	if o.human {
@@ -214,32 +213,56 @@ func command(stdout io.Writer, o options) (*cmd, error) {
 }
 
 // run prints physical memory and swap space information. The fields will be
-// expressed with the specified unit (e.g. KB, MB)
+// expressed with the specified unit (e.g. KB, MB). With a delay it repeats
+// until count reports are printed, or forever without a count.
 func (c *cmd) run() error {
-	m, err := meminfo()
-	if err != nil {
-		return err
-	}
+	for i := 1; ; i++ {
+		m, err := sysinfo.ReadMemInfoMap()
+		if err != nil {
+			return err
+		}
+		if err := c.parse(m); err != nil {
+			return err
+		}
 
-	return c.parse(m)
+		if c.delay == 0 || (c.count > 0 && i >= c.count) {
+			return nil
+		}
+		if !c.toJSON {
+			fmt.Fprintln(c.stdout)
+		}
+		time.Sleep(c.delay)
+	}
 }
 
-func (c *cmd) parse(m meminfomap) error {
-	mmi, err := getMainMemInfo(m)
+func (c *cmd) parse(m sysinfo.MemInfoMap) error {
+	mi, err := m.MemInfo()
 	if err != nil {
 		return err
 	}
-	si, err := getSwapInfo(m)
-	if err != nil {
-		return err
-	}
-	mi := MemInfo{Mem: *mmi, Swap: *si}
+	mmi, si := mi.Mem, mi.Swap
+
 	if c.toJSON {
 		jsonData, err := json.Marshal(mi)
 		if err != nil {
 			return err
 		}
 		fmt.Fprintln(c.stdout, string(jsonData))
+		return nil
+	}
+
+	if c.wide {
+		fmt.Fprintf(c.stdout, "              total        used        free      shared     buffers       cache   available\n")
+		fmt.Fprintf(c.stdout, "%-7s %11v %11v %11v %11v %11v %11v %11v\n",
+			"Mem:",
+			c.formatValueByConfig(mmi.Total),
+			c.formatValueByConfig(mmi.Used),
+			c.formatValueByConfig(mmi.Free),
+			c.formatValueByConfig(mmi.Shared),
+			c.formatValueByConfig(mmi.Buffers),
+			c.formatValueByConfig(mmi.Cached),
+			c.formatValueByConfig(mmi.Available),
+		)
 	} else {
 		fmt.Fprintf(c.stdout, "              total        used        free      shared  buff/cache   available\n")
 		fmt.Fprintf(c.stdout, "%-7s %11v %11v %11v %11v %11v %11v\n",
@@ -251,12 +274,12 @@ func (c *cmd) parse(m meminfomap) error {
 			c.formatValueByConfig(mmi.Buffers+mmi.Cached),
 			c.formatValueByConfig(mmi.Available),
 		)
-		fmt.Fprintf(c.stdout, "%-7s %11v %11v %11v\n",
-			"Swap:",
-			c.formatValueByConfig(si.Total),
-			c.formatValueByConfig(si.Used),
-			c.formatValueByConfig(si.Free),
-		)
 	}
+	fmt.Fprintf(c.stdout, "%-7s %11v %11v %11v\n",
+		"Swap:",
+		c.formatValueByConfig(si.Total),
+		c.formatValueByConfig(si.Used),
+		c.formatValueByConfig(si.Free),
+	)
 	return nil
 }
@@ -1,83 +1,143 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"mybox/pkg/termios"
 	"os"
-	"os/exec"
-	// "strconv"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"mybox/pkg/termios"
 
 	"github.com/jessevdk/go-flags"
+	"golang.org/x/sys/unix"
 )
 
 var opts struct {
-	Baud     int    `short:"b" long:"baud" description:"set baud rate of terminal"`
-	Port     string `short:"p" long:"port" description:"set port of terminal"`
-	Terminal string `short:"t" long:"terminal" description:"set terminal environment variable"`
-	Shell    string `short:"s" long:"shell" default:"/bin/sh" description:"set shell for new tty. defaults to /bin/sh"`
+	Baud         int    `short:"b" long:"baud" description:"set baud rate of terminal, any rate the line supports"`
+	Port         string `short:"p" long:"port" description:"set port of terminal"`
+	Terminal     string `short:"t" long:"terminal" description:"set terminal environment variable"`
+	LocalLine    bool   `short:"L" long:"local-line" description:"ignore the carrier detect line"`
+	Issue        string `short:"f" long:"issue-file" default:"/etc/issue" description:"file to show before the login prompt"`
+	NoIssue      bool   `short:"i" long:"noissue" description:"don't show the issue file"`
+	LoginProgram string `short:"l" long:"login-program" default:"/bin/login" description:"program to log in with, run as PROGRAM -- NAME"`
+	Autologin    string `short:"a" long:"autologin" description:"log USER in without asking, with PROGRAM -f USER"`
 }
 
-func Getty(args []string) error {
-	port := opts.Port
-	baud := opts.Baud
-	// port := args[0]
-	// baud, err := strconv.Atoi(args[1])
-	// if err != nil {
-	// 	baud = 0
-	// }
-
-	var term string
-	if opts.Terminal != "" {
-		term = opts.Terminal
+// portPath is the device for a port given as ttyS0 or /dev/ttyS0
+func portPath(port string) string {
+	if filepath.IsAbs(port) {
+		return port
 	}
+	return filepath.Join("/dev", port)
+}
 
-	// if len(args) > 2 {
-	// 	term = args[2]
-	// }
-
-	ttys, err := termios.NewTTYS(port)
-	if err != nil {
-		return fmt.Errorf("Error opening port %s: %w", port, err)
+// readLine reads a line from the terminal without its end
+func readLine(r *bufio.Reader) (string, error) {
+	l, err := r.ReadString('\n')
+	if err != nil && l == "" {
+		return "", err
 	}
+	return strings.TrimRight(l, "\r\n"), nil
+}
 
-	if _, err := ttys.Serial(baud); err != nil {
-		return fmt.Errorf("error configuring baud rate for %s to %d: %w", port, baud, err)
+// prompt shows the issue file and asks for a login name until it gets one
+func prompt(tty *termios.TTY, r *bufio.Reader, is *issue) (string, error) {
+	host := unix.ByteSliceToString(is.uname.Nodename[:])
+	for {
+		if !opts.NoIssue {
+			if b, err := os.ReadFile(opts.Issue); err == nil {
+				fmt.Fprint(tty, is.expand(string(b)))
+			}
+		}
+		fmt.Fprintf(tty, "%s login: ", host)
+		name, err := readLine(r)
+		if err != nil {
+			return "", err
+		}
+		if name = strings.TrimSpace(name); name != "" {
+			return name, nil
+		}
 	}
+}
 
-	if term != "" {
-		err = os.Setenv("TERM", term)
+func Getty(args []string) error {
+	// agetty order: port, baud and terminal, which the options override
+	port, baud, term := opts.Port, opts.Baud, opts.Terminal
+	if len(args) > 0 && port == "" {
+		port = args[0]
+	}
+	if len(args) > 1 && baud == 0 {
+		b, err := strconv.Atoi(args[1])
 		if err != nil {
-			log.Printf("error setting TERM environment variable: TERM=%s: %v", term, err)
+			return fmt.Errorf("bad baud rate %q", args[1])
 		}
+		baud = b
 	}
-
-	shell := opts.Shell
-	if shell == "" {
-		shell = "/bin/sh"
+	if len(args) > 2 && term == "" {
+		term = args[2]
+	}
+	if port == "" {
+		return errors.New("no port given")
 	}
 
-	cmd := exec.Command(shell)
-	cmd.Env = os.Environ()
-	ttys.Ctty(cmd)
+	tty, err := termios.Open(portPath(port))
+	if err != nil {
+		return fmt.Errorf("error opening port %s: %w", port, err)
+	}
+	if err := tty.LoginTTY(); err != nil {
+		return fmt.Errorf("error taking over %s: %w", port, err)
+	}
 
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("error starting shell: %w", err)
+	tio, err := tty.Get()
+	if err != nil {
+		return err
+	}
+	tio = termios.MakeCooked(tio)
+	if baud != 0 {
+		termios.SetSpeed(tio, baud)
+	}
+	if opts.LocalLine {
+		tio.Cflag |= unix.CLOCAL
+	}
+	if err := tty.Set(tio); err != nil {
+		return fmt.Errorf("error configuring %s at %d baud: %w", port, baud, err)
 	}
+	// line noise from before we were here isn't a login name
+	tty.Flush()
 
-	if err := cmd.Process.Release(); err != nil {
-		log.Printf("Error releasing process: %v", err)
+	if term != "" {
+		os.Setenv("TERM", term)
 	}
 
-	return nil
+	if opts.Autologin != "" {
+		return unix.Exec(opts.LoginProgram, []string{opts.LoginProgram, "-f", opts.Autologin}, os.Environ())
+	}
+	is := newIssue(strings.TrimPrefix(tty.Name(), "/dev/"), termios.Speed(tio))
+	name, err := prompt(tty, bufio.NewReader(tty), is)
+	if errors.Is(err, io.EOF) {
+		// hung up
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return unix.Exec(opts.LoginProgram, []string{opts.LoginProgram, "--", name}, os.Environ())
 }
 
 func main() {
 	args, err := flags.Parse(&opts)
-	if err != nil {
-		fmt.Println("Example: getty --terminal=linux --port=ttys0 --shell=/bin/sh")
+	if flags.WroteHelp(err) {
 		os.Exit(0)
 	}
+	if err != nil {
+		fmt.Println("Example: getty --terminal=linux --port=ttyS0 --baud=115200")
+		os.Exit(1)
+	}
 
 	if err := Getty(args); err != nil {
 		log.Fatal(err)
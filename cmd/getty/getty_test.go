@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExpand(t *testing.T) {
+	is := &issue{line: "ttyS0", baud: 115200, users: 1, os: "mybox", now: time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)}
+	copy(is.uname.Nodename[:], "box")
+	copy(is.uname.Sysname[:], "Linux")
+	copy(is.uname.Machine[:], "x86_64")
+
+	for _, tt := range []struct{ in, want string }{
+		{`\S \s \m on \l`, "mybox Linux x86_64 on ttyS0"},
+		{`\n login at \b: \d \t`, "box login at 115200: Tue Jan  2 2024 15:04:05"},
+		{`\U, \u`, "1 user, 1"},
+		{`\\o/ \q \`, `\o/ \q \`},
+	} {
+		if got := is.expand(tt.in); got != tt.want {
+			t.Errorf("expand(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	is.users = 3
+	if got := is.expand(`\U`); got != "3 users" {
+		t.Errorf(`expand(\U) = %q`, got)
+	}
+}
+
+func TestOSName(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "os-release")
+	os.WriteFile(path, []byte("NAME=x\nPRETTY_NAME=\"Box Linux 1\"\n"), 0o644)
+	if got := osName(path); got != "Box Linux 1" {
+		t.Errorf("osName = %q", got)
+	}
+	if got := osName(path + ".missing"); got != "Linux" {
+		t.Errorf("osName(missing) = %q", got)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"mybox/pkg/sysinfo"
+
+	"golang.org/x/sys/unix"
+)
+
+// issue is what the escapes in /etc/issue expand to
+type issue struct {
+	uname unix.Utsname
+	line  string
+	baud  int
+	users int
+	os    string
+	now   time.Time
+}
+
+func newIssue(line string, baud int) *issue {
+	is := &issue{line: line, baud: baud, now: time.Now(), os: osName("/etc/os-release")}
+	unix.Uname(&is.uname)
+	if u, err := sysinfo.Users(); err == nil {
+		is.users = len(u)
+	}
+	return is
+}
+
+// osName is PRETTY_NAME from os-release
+func osName(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return "Linux"
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if v, ok := strings.CutPrefix(s.Text(), "PRETTY_NAME="); ok {
+			return strings.Trim(v, `"'`)
+		}
+	}
+	return "Linux"
+}
+
+// expand replaces the agetty escapes in text
+func (is *issue) expand(text string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] != '\\' || i+1 == len(text) {
+			b.WriteByte(text[i])
+			continue
+		}
+		i++
+		switch text[i] {
+		case 'b':
+			fmt.Fprint(&b, is.baud)
+		case 'd':
+			b.WriteString(is.now.Format("Mon Jan _2 2006"))
+		case 't':
+			b.WriteString(is.now.Format("15:04:05"))
+		case 'e':
+			b.WriteByte(0x1b)
+		case 'l':
+			b.WriteString(is.line)
+		case 'm':
+			b.WriteString(unix.ByteSliceToString(is.uname.Machine[:]))
+		case 'n', 'h':
+			b.WriteString(unix.ByteSliceToString(is.uname.Nodename[:]))
+		case 'o':
+			b.WriteString(unix.ByteSliceToString(is.uname.Domainname[:]))
+		case 'r':
+			b.WriteString(unix.ByteSliceToString(is.uname.Release[:]))
+		case 's':
+			b.WriteString(unix.ByteSliceToString(is.uname.Sysname[:]))
+		case 'S':
+			b.WriteString(is.os)
+		case 'v':
+			b.WriteString(unix.ByteSliceToString(is.uname.Version[:]))
+		case 'u':
+			fmt.Fprint(&b, is.users)
+		case 'U':
+			if is.users == 1 {
+				b.WriteString("1 user")
+			} else {
+				fmt.Fprintf(&b, "%d users", is.users)
+			}
+		case '\\':
+			b.WriteByte('\\')
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(text[i])
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"mybox/pkg/mount/gpt"
+)
+
+// extent is a run of sectors, first to last
+type extent struct {
+	first, last uint64
+}
+
+// free lists the unused extents of the usable sectors of g, in order
+func free(g *gpt.GPT) []extent {
+	var used []extent
+	for i := range g.Parts {
+		if p := &g.Parts[i]; !p.IsEmpty() {
+			used = append(used, extent{p.FirstLBA, p.LastLBA})
+		}
+	}
+	sort.Slice(used, func(i, j int) bool { return used[i].first < used[j].first })
+	var e []extent
+	next := g.FirstLBA
+	for _, u := range used {
+		if u.first > next {
+			e = append(e, extent{next, u.first - 1})
+		}
+		next = max(next, u.last+1)
+	}
+	if next <= g.LastLBA {
+		e = append(e, extent{next, g.LastLBA})
+	}
+	return e
+}
+
+// parseSize reads a number of sectors, or of bytes with a K, M, G or T
+// suffix, which are rounded up to whole sectors
+func parseSize(s string) (uint64, error) {
+	shift := 0
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K', 'k':
+			shift = 10
+		case 'M', 'm':
+			shift = 20
+		case 'G', 'g':
+			shift = 30
+		case 'T', 't':
+			shift = 40
+		}
+	}
+	num := s
+	if shift > 0 {
+		num = s[:len(s)-1]
+	}
+	n, err := strconv.ParseUint(num, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad size %q", s)
+	}
+	if shift == 0 {
+		return n, nil
+	}
+	return (n<<shift + gpt.BlockSize - 1) / gpt.BlockSize, nil
+}
+
+// end reads the END of -n and --resize for a partition from first: 0 for
+// the end of the free space, +SIZE for a size, -SIZE for that much before
+// the end of the free space, or a sector. limit is the last free sector.
+func end(spec string, first, limit uint64) (uint64, error) {
+	var last uint64
+	switch {
+	case spec == "0" || spec == "":
+		last = limit
+	case spec[0] == '+':
+		n, err := parseSize(spec[1:])
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			return 0, fmt.Errorf("size %q is empty", spec)
+		}
+		last = first + n - 1
+	case spec[0] == '-':
+		n, err := parseSize(spec[1:])
+		if err != nil {
+			return 0, err
+		}
+		if n > limit {
+			return 0, fmt.Errorf("%q is before the start of the disk", spec)
+		}
+		last = limit - n
+	default:
+		n, err := parseSize(spec)
+		if err != nil {
+			return 0, err
+		}
+		last = n
+	}
+	if last < first || last > limit {
+		return 0, fmt.Errorf("end sector %d is not within %d-%d", last, first, limit)
+	}
+	return last, nil
+}
+
+// number reads the partition number in front of spec, and returns the
+// index of its entry and the rest of spec
+func number(g *gpt.GPT, spec string) (int, string, error) {
+	s, rest, _ := strings.Cut(spec, ":")
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > len(g.Parts) {
+		return 0, "", fmt.Errorf("bad partition number %q", s)
+	}
+	return n - 1, rest, nil
+}
+
+// used is like number for a partition that must exist
+func used(g *gpt.GPT, spec string) (*gpt.Part, string, error) {
+	i, rest, err := number(g, spec)
+	if err != nil {
+		return nil, "", err
+	}
+	if i < 0 || g.Parts[i].IsEmpty() {
+		return nil, "", fmt.Errorf("partition %d does not exist", i+1)
+	}
+	return &g.Parts[i], rest, nil
+}
+
+// add makes a partition from N:START:END. N of 0 is the first unused
+// entry. START of 0 is the first aligned free sector; other starts are
+// aligned up. END is as for end.
+func add(g *gpt.GPT, spec string, align uint64) (int, error) {
+	i, rest, err := number(g, spec)
+	if err != nil {
+		return 0, err
+	}
+	if i < 0 {
+		for i = 0; i < len(g.Parts) && !g.Parts[i].IsEmpty(); i++ {
+		}
+		if i == len(g.Parts) {
+			return 0, fmt.Errorf("all %d entries are used", len(g.Parts))
+		}
+	} else if !g.Parts[i].IsEmpty() {
+		return 0, fmt.Errorf("partition %d is in use", i+1)
+	}
+	startSpec, endSpec, _ := strings.Cut(rest, ":")
+
+	align = max(align, 1)
+	var start uint64
+	var in *extent
+	exts := free(g)
+	if startSpec == "0" || startSpec == "" {
+		for j, e := range exts {
+			if s := (e.first + align - 1) / align * align; s <= e.last {
+				start, in = s, &exts[j]
+				break
+			}
+		}
+		if in == nil {
+			return 0, fmt.Errorf("no free space")
+		}
+	} else {
+		s, err := parseSize(startSpec)
+		if err != nil {
+			return 0, err
+		}
+		start = (s + align - 1) / align * align
+		for j, e := range exts {
+			if start >= e.first && start <= e.last {
+				in = &exts[j]
+			}
+		}
+		if in == nil {
+			return 0, fmt.Errorf("start sector %d is not free", start)
+		}
+	}
+	last, err := end(endSpec, start, in.last)
+	if err != nil {
+		return 0, err
+	}
+	id, err := gpt.NewGUID()
+	if err != nil {
+		return 0, err
+	}
+	g.Parts[i] = gpt.Part{
+		PartGUID:   gpt.Types["linux"],
+		UniqueGUID: id,
+		FirstLBA:   start,
+		LastLBA:    last,
+	}
+	return i + 1, nil
+}
+
+// remove empties the entry of partition N
+func remove(g *gpt.GPT, n int) error {
+	p, _, err := used(g, strconv.Itoa(n))
+	if err != nil {
+		return err
+	}
+	*p = gpt.Part{}
+	return nil
+}
+
+// resize moves the end of a partition, from N:END. END is as for end,
+// limited by the next partition.
+func resize(g *gpt.GPT, spec string) error {
+	p, rest, err := used(g, spec)
+	if err != nil {
+		return err
+	}
+	limit := g.LastLBA
+	for i := range g.Parts {
+		if o := &g.Parts[i]; o != p && !o.IsEmpty() && o.FirstLBA > p.FirstLBA {
+			limit = min(limit, o.FirstLBA-1)
+		}
+	}
+	last, err := end(rest, p.FirstLBA, limit)
+	if err != nil {
+		return err
+	}
+	p.LastLBA = last
+	return nil
+}
+
+// setType sets the type of a partition, from N:TYPE. TYPE is a name in
+// gpt.Types or a GUID.
+func setType(g *gpt.GPT, spec string) error {
+	p, rest, err := used(g, spec)
+	if err != nil {
+		return err
+	}
+	t, ok := gpt.Types[strings.ToLower(rest)]
+	if !ok {
+		if t, err = gpt.ParseGUID(rest); err != nil {
+			return fmt.Errorf("unknown partition type %q", rest)
+		}
+	}
+	p.PartGUID = t
+	return nil
+}
+
+// rename names a partition, from N:NAME
+func rename(g *gpt.GPT, spec string) error {
+	p, rest, err := used(g, spec)
+	if err != nil {
+		return err
+	}
+	name, err := gpt.NewPartName(rest)
+	if err != nil {
+		return err
+	}
+	p.Name = name
+	return nil
+}
+
+// attributes are the names of the attribute bits that have one
+var attributes = map[string]uint{
+	"required":    0,
+	"noblockio":   1,
+	"legacyboot":  2,
+	"readonly":    60,
+	"hidden":      62,
+	"noautomount": 63,
+}
+
+// setAttribute changes an attribute bit of a partition, from
+// N:set:BIT, N:clear:BIT or N:toggle:BIT. BIT is a number or a name in
+// attributes.
+func setAttribute(g *gpt.GPT, spec string) error {
+	p, rest, err := used(g, spec)
+	if err != nil {
+		return err
+	}
+	op, b, _ := strings.Cut(rest, ":")
+	bit, ok := attributes[strings.ToLower(b)]
+	if !ok {
+		n, err := strconv.ParseUint(b, 10, 8)
+		if err != nil || n > 63 {
+			return fmt.Errorf("bad attribute bit %q", b)
+		}
+		bit = uint(n)
+	}
+	mask := gpt.PartAttr(1) << bit
+	switch op {
+	case "set":
+		p.Attribute |= mask
+	case "clear":
+		p.Attribute &^= mask
+	case "toggle":
+		p.Attribute ^= mask
+	default:
+		return fmt.Errorf("bad attribute operation %q, want set, clear or toggle", op)
+	}
+	return nil
+}
@@ -0,0 +1,360 @@
+// gpt creates and edits GUID partition tables of block devices and disk
+// images, like sgdisk.
+//
+// Synopsis:
+//
+//	gpt [OPTIONS] DEVICE
+//
+// Partitions are numbered from 1. The options are applied in this order,
+// and the table is written once at the end:
+//
+//	--import FILE             load a table saved as JSON with --export
+//	-o                        make a new, empty table
+//	--repair                  rebuild a broken primary GPT from the backup, or the other way
+//	-d N                      delete partition N
+//	-n N:START:END            add partition N, 0 for the first free entry
+//	--resize N:END            move the end of partition N
+//	-t N:TYPE                 set the type, a GUID or linux, esp, swap, lvm, raid, home or bios
+//	-c N:NAME                 name partition N
+//	-A N:set|clear|toggle:BIT change an attribute bit, a number or required,
+//	                          noblockio, legacyboot, readonly, hidden or noautomount
+//	-v                        verify the table, exiting 2 if there are problems
+//	-p                        print the table
+//	--export FILE             save the table as JSON, - for stdout
+//
+// START is a sector, or a size in K, M, G or T, aligned up to the -a
+// alignment; 0 is the first free aligned sector. END is a sector, +SIZE
+// for the size of the partition, -SIZE for that much before the end of
+// the free space, or 0 for all of the free space.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"mybox/pkg/mount/block"
+	"mybox/pkg/mount/gpt"
+
+	"github.com/jessevdk/go-flags"
+)
+
+// options are the flags of gpt
+type options struct {
+	Print     bool     `short:"p" long:"print" description:"print the partition table"`
+	Verify    bool     `short:"v" long:"verify" description:"check the partition table for problems"`
+	Clear     bool     `short:"o" long:"clear" description:"make a new, empty partition table"`
+	New       []string `short:"n" long:"new" value-name:"N:START:END" description:"add a partition"`
+	Delete    []int    `short:"d" long:"delete" value-name:"N" description:"delete a partition"`
+	Resize    []string `long:"resize" value-name:"N:END" description:"move the end of a partition"`
+	Type      []string `short:"t" long:"typecode" value-name:"N:TYPE" description:"set the type of a partition"`
+	Name      []string `short:"c" long:"change-name" value-name:"N:NAME" description:"name a partition"`
+	Attribute []string `short:"A" long:"attributes" value-name:"N:OP:BIT" description:"set, clear or toggle an attribute bit"`
+	Align     uint64   `short:"a" long:"set-alignment" default:"2048" value-name:"SECTORS" description:"align partition starts"`
+	Repair    bool     `long:"repair" description:"rebuild a broken GPT from the other copy"`
+	Import    string   `long:"import" value-name:"FILE" description:"load the partition table from JSON"`
+	Export    string   `long:"export" value-name:"FILE" description:"save the partition table as JSON"`
+}
+
+// Exit codes of sgdisk
+const (
+	exitUsage   = 1
+	exitRead    = 2
+	exitProblem = 2
+	exitWrite   = 4
+)
+
+var errUsage = errors.New("usage: gpt [OPTIONS] DEVICE")
+
+// edits tells whether opts change the table
+func (opts *options) edits() bool {
+	return opts.Import != "" || opts.Clear || opts.Repair || len(opts.New) > 0 || len(opts.Delete) > 0 ||
+		len(opts.Resize) > 0 || len(opts.Type) > 0 || len(opts.Name) > 0 || len(opts.Attribute) > 0
+}
+
+// load reads the table to edit, from the disk, from --import or new with -o
+func (opts *options) load(f *os.File, sectors uint64) (*gpt.PartitionTable, bool, error) {
+	switch {
+	case opts.Import != "":
+		b, err := os.ReadFile(opts.Import)
+		if err != nil {
+			return nil, false, err
+		}
+		p := &gpt.PartitionTable{}
+		if err := json.Unmarshal(b, p); err != nil {
+			return nil, false, fmt.Errorf("%s: %v", opts.Import, err)
+		}
+		if p.Primary == nil || len(p.Primary.Parts) != int(p.Primary.NPart) {
+			return nil, false, fmt.Errorf("%s: no primary GPT", opts.Import)
+		}
+		if p.Primary.BackupLBA >= sectors {
+			return nil, false, fmt.Errorf("%s: the table is for a disk of %d sectors, not %d", opts.Import, p.Primary.BackupLBA+1, sectors)
+		}
+		if p.MasterBootRecord == nil {
+			p.MasterBootRecord = gpt.ProtectiveMBR(sectors)
+		}
+		return p, true, nil
+	case opts.Clear:
+		id, err := gpt.NewGUID()
+		if err != nil {
+			return nil, false, err
+		}
+		p, err := gpt.Create(sectors, id)
+		return p, true, err
+	case opts.Repair:
+		return gpt.Repair(f, int64(sectors*gpt.BlockSize))
+	}
+	p, err := gpt.New(f)
+	if err != nil {
+		return nil, false, fmt.Errorf("%v; --repair may fix it", err)
+	}
+	return p, false, nil
+}
+
+// edit applies the changes of opts to g, and tells if there were any
+func (opts *options) edit(g *gpt.GPT) (bool, error) {
+	for _, n := range opts.Delete {
+		if err := remove(g, n); err != nil {
+			return false, err
+		}
+	}
+	for _, s := range opts.New {
+		if _, err := add(g, s, opts.Align); err != nil {
+			return false, fmt.Errorf("-n %s: %v", s, err)
+		}
+	}
+	for _, list := range []struct {
+		name  string
+		specs []string
+		f     func(*gpt.GPT, string) error
+	}{
+		{"--resize", opts.Resize, resize},
+		{"-t", opts.Type, setType},
+		{"-c", opts.Name, rename},
+		{"-A", opts.Attribute, setAttribute},
+	} {
+		for _, s := range list.specs {
+			if err := list.f(g, s); err != nil {
+				return false, fmt.Errorf("%s %s: %v", list.name, s, err)
+			}
+		}
+	}
+	return len(opts.Delete)+len(opts.New)+len(opts.Resize)+len(opts.Type)+len(opts.Name)+len(opts.Attribute) > 0, nil
+}
+
+// check lists what is wrong with the partitions of g
+func check(g *gpt.GPT) []string {
+	var problems []string
+	var parts []int
+	for i := range g.Parts {
+		p := &g.Parts[i]
+		if p.IsEmpty() {
+			continue
+		}
+		switch {
+		case p.FirstLBA > p.LastLBA:
+			problems = append(problems, fmt.Sprintf("partition %d ends at sector %d, before it starts at %d", i+1, p.LastLBA, p.FirstLBA))
+		case p.FirstLBA < g.FirstLBA || p.LastLBA > g.LastLBA:
+			problems = append(problems, fmt.Sprintf("partition %d (%d-%d) is outside of the usable sectors %d-%d", i+1, p.FirstLBA, p.LastLBA, g.FirstLBA, g.LastLBA))
+		}
+		parts = append(parts, i)
+	}
+	for a, i := range parts {
+		for _, j := range parts[a+1:] {
+			p, q := &g.Parts[i], &g.Parts[j]
+			if p.FirstLBA <= q.LastLBA && q.FirstLBA <= p.LastLBA {
+				problems = append(problems, fmt.Sprintf("partitions %d and %d overlap", i+1, j+1))
+			}
+		}
+	}
+	return problems
+}
+
+// verify lists what is wrong with the table of a disk of sectors blocks
+func verify(r io.ReaderAt, sectors uint64) []string {
+	var problems []string
+	mbr := &gpt.MBR{}
+	switch _, err := r.ReadAt(mbr[:], 0); {
+	case err != nil:
+		problems = append(problems, fmt.Sprintf("reading the MBR: %v", err))
+	case mbr[510] != 0x55 || mbr[511] != 0xaa:
+		problems = append(problems, "the MBR has no signature")
+	case mbr[0x1be+4] != 0xee:
+		problems = append(problems, "the MBR is not a protective MBR")
+	}
+
+	primary, err := gpt.Table(r, gpt.BlockSize)
+	if err != nil {
+		problems = append(problems, err.Error())
+		primary = nil
+	}
+	backup, err := gpt.Table(r, int64(sectors-1)*gpt.BlockSize)
+	if err != nil {
+		problems = append(problems, err.Error())
+		backup = nil
+	}
+	if primary != nil && primary.BackupLBA != sectors-1 {
+		problems = append(problems, fmt.Sprintf("the backup GPT is at sector %d, not at the end of the disk, %d", primary.BackupLBA, sectors-1))
+	}
+	if primary != nil && backup != nil {
+		if err := gpt.EqualHeader(primary.Header, backup.Header); err != nil {
+			problems = append(problems, fmt.Sprintf("primary and backup headers differ: %v", err))
+		}
+		if err := gpt.EqualParts(primary, backup); err != nil {
+			problems = append(problems, fmt.Sprintf("primary and backup partitions differ: %v", err))
+		}
+	}
+	for _, g := range []*gpt.GPT{primary, backup} {
+		if g != nil {
+			problems = append(problems, check(g)...)
+			break
+		}
+	}
+	return problems
+}
+
+// ieee prints a size in bytes like sgdisk, in binary units
+func ieee(n uint64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d bytes", n)
+	}
+	units := []string{"KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+	f, u := float64(n)/1024, 0
+	for f >= 1024 && u < len(units)-1 {
+		f, u = f/1024, u+1
+	}
+	return fmt.Sprintf("%.1f %s", f, units[u])
+}
+
+// show prints the table of the disk named dev
+func (opts *options) show(w io.Writer, dev string, sectors uint64, g *gpt.GPT) {
+	var total uint64
+	for _, e := range free(g) {
+		total += e.last - e.first + 1
+	}
+	fmt.Fprintf(w, "Disk %s: %d sectors, %s\n", dev, sectors, ieee(sectors*gpt.BlockSize))
+	fmt.Fprintf(w, "Sector size (logical): %d bytes\n", gpt.BlockSize)
+	fmt.Fprintf(w, "Disk identifier (GUID): %s\n", &g.DiskGUID)
+	fmt.Fprintf(w, "Partition table holds up to %d entries\n", g.NPart)
+	fmt.Fprintf(w, "Main partition table begins at sector %d and ends at sector %d\n",
+		g.PartStart, g.PartStart+(uint64(g.NPart)*uint64(g.PartSize)+gpt.BlockSize-1)/gpt.BlockSize-1)
+	fmt.Fprintf(w, "First usable sector is %d, last usable sector is %d\n", g.FirstLBA, g.LastLBA)
+	fmt.Fprintf(w, "Partitions will be aligned on %d-sector boundaries\n", opts.Align)
+	fmt.Fprintf(w, "Total free space is %d sectors (%s)\n", total, ieee(total*gpt.BlockSize))
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Number  Start (sector)    End (sector)  Size        Type    Name\n")
+	for i := range g.Parts {
+		p := &g.Parts[i]
+		if p.IsEmpty() {
+			continue
+		}
+		line := fmt.Sprintf("%6d  %14d  %14d  %-10s  %-6s  %s", i+1, p.FirstLBA, p.LastLBA, ieee(p.Sectors()*gpt.BlockSize), gpt.TypeName(p.PartGUID), &p.Name)
+		fmt.Fprintln(w, strings.TrimRight(line, " "))
+	}
+}
+
+func run(w io.Writer, opts options, args []string) (int, error) {
+	if len(args) != 1 {
+		return exitUsage, errUsage
+	}
+	dev := args[0]
+	write := opts.edits()
+	flag := os.O_RDONLY
+	if write {
+		flag = os.O_RDWR
+	}
+	f, err := os.OpenFile(dev, flag, 0)
+	if err != nil {
+		return exitRead, err
+	}
+	defer f.Close()
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return exitRead, err
+	}
+	sectors := uint64(size) / gpt.BlockSize
+
+	if write || opts.Print || opts.Export != "" {
+		p, changed, err := opts.load(f, sectors)
+		if err != nil {
+			return exitRead, fmt.Errorf("%s: %v", dev, err)
+		}
+		edited, err := opts.edit(p.Primary)
+		if err != nil {
+			return exitUsage, err
+		}
+		if changed || edited {
+			if problems := check(p.Primary); len(problems) > 0 {
+				return exitUsage, fmt.Errorf("not writing a bad table: %v", problems)
+			}
+			p.Backup = p.Primary.Mirror()
+			if err := gpt.Write(f, p); err != nil {
+				return exitWrite, fmt.Errorf("%s: %v", dev, err)
+			}
+			if err := f.Sync(); err != nil {
+				return exitWrite, fmt.Errorf("%s: %v", dev, err)
+			}
+			if fi, err := f.Stat(); err == nil && fi.Mode()&os.ModeDevice != 0 {
+				if b, err := block.Device(dev); err == nil {
+					if err := b.ReadPartitionTable(); err != nil {
+						log.Printf("%s: the kernel still uses the old partition table: %v", dev, err)
+					}
+				}
+			}
+		}
+		if opts.Print {
+			opts.show(w, dev, sectors, p.Primary)
+		}
+		if opts.Export != "" {
+			if err := opts.export(w, p); err != nil {
+				return exitWrite, err
+			}
+		}
+	}
+
+	if opts.Verify {
+		problems := verify(f, sectors)
+		if len(problems) == 0 {
+			fmt.Fprintln(w, "No problems found.")
+			return 0, nil
+		}
+		for _, p := range problems {
+			fmt.Fprintf(w, "Problem: %s\n", p)
+		}
+		fmt.Fprintf(w, "Identified %d problems!\n", len(problems))
+		return exitProblem, nil
+	}
+	return 0, nil
+}
+
+// export saves p as JSON to --export, or w for -
+func (opts *options) export(w io.Writer, p *gpt.PartitionTable) error {
+	if opts.Export == "-" {
+		_, err := fmt.Fprintln(w, p)
+		return err
+	}
+	return os.WriteFile(opts.Export, []byte(p.String()+"\n"), 0o644)
+}
+
+func main() {
+	var opts options
+	args, err := flags.Parse(&opts)
+	if flags.WroteHelp(err) {
+		os.Exit(0)
+	}
+	if err != nil {
+		os.Exit(exitUsage)
+	}
+	log.SetFlags(0)
+	log.SetPrefix("gpt: ")
+
+	code, err := run(os.Stdout, opts, args)
+	if err != nil {
+		log.Print(err)
+	}
+	os.Exit(code)
+}
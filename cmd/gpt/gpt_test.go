@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"mybox/pkg/mount/gpt"
+)
+
+// disk makes a sparse image of 100 MiB
+func disk(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "disk.img")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.Truncate(100 << 20); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// gptRun runs gpt on dev with opts, aligned on 2048 sectors like by default
+func gptRun(t *testing.T, dev string, opts options) (string, int, error) {
+	t.Helper()
+	if opts.Align == 0 {
+		opts.Align = 2048
+	}
+	var out bytes.Buffer
+	code, err := run(&out, opts, []string{dev})
+	return out.String(), code, err
+}
+
+// table reads the primary GPT of dev
+func table(t *testing.T, dev string) *gpt.GPT {
+	t.Helper()
+	f, err := os.Open(dev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p, err := gpt.New(f)
+	if err != nil {
+		t.Fatalf("reading the table back: %v", err)
+	}
+	return p.Primary
+}
+
+func TestGPT(t *testing.T) {
+	dev := disk(t)
+	out, code, err := gptRun(t, dev, options{
+		Clear:     true,
+		New:       []string{"0:0:+10M", "0:0:-1M", "5:0:0"},
+		Type:      []string{"1:esp", "5:SWAP", "2:e6d6d379-f507-44c2-a23c-238f2a3df928"},
+		Name:      []string{"1:EFI system", "2:vg:0"},
+		Attribute: []string{"2:set:legacyboot", "2:set:60", "2:toggle:60", "1:set:hidden"},
+		Print:     true,
+	})
+	if err != nil || code != 0 {
+		t.Fatalf("gpt -o -n ...: %d, %v", code, err)
+	}
+	g := table(t, dev)
+	for _, tt := range []struct {
+		n           int
+		first, last uint64
+		typ, name   string
+		attr        gpt.PartAttr
+	}{
+		{1, 2048, 22527, "esp", "EFI system", 1 << 62},
+		{2, 22528, 202718, "lvm", "vg:0", 1 << 2},
+		{5, 202752, 204766, "swap", "", 0},
+	} {
+		p := &g.Parts[tt.n-1]
+		if p.FirstLBA != tt.first || p.LastLBA != tt.last || gpt.TypeName(p.PartGUID) != tt.typ || p.Name.String() != tt.name || p.Attribute != tt.attr {
+			t.Errorf("partition %d is %d-%d %s %q %#x, want %d-%d %s %q %#x", tt.n,
+				p.FirstLBA, p.LastLBA, gpt.TypeName(p.PartGUID), &p.Name, p.Attribute, tt.first, tt.last, tt.typ, tt.name, tt.attr)
+		}
+	}
+	for _, want := range []string{
+		"Disk " + dev + ": 204800 sectors, 100.0 MiB\n",
+		"First usable sector is 34, last usable sector is 204766\n",
+		"Total free space is 2047 sectors (1023.5 KiB)\n",
+		"Number  Start (sector)    End (sector)  Size        Type    Name\n" +
+			"     1            2048           22527  10.0 MiB    esp     EFI system\n" +
+			"     2           22528          202718  88.0 MiB    lvm     vg:0\n" +
+			"     5          202752          204766  1007.5 KiB  swap\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("-p printed\n%s\nwant it to have\n%s", out, want)
+		}
+	}
+
+	// sector 2048 is in partition 1, and nothing is written when -n fails
+	if _, code, err := gptRun(t, dev, options{Delete: []int{2}, New: []string{"3:1M:+100M"}}); err == nil || code != exitUsage {
+		t.Errorf("-n 3:1M:+100M over partition 1: got %d, %v, want an error", code, err)
+	}
+	if _, _, err := gptRun(t, dev, options{Delete: []int{2}, Resize: []string{"1:0"}}); err != nil {
+		t.Fatalf("-d 2 --resize 1:0: %v", err)
+	}
+	g = table(t, dev)
+	if p := &g.Parts[0]; p.LastLBA != 202751 || !g.Parts[1].IsEmpty() {
+		t.Errorf("after -d 2 --resize 1:0, partition 1 ends at %d, want 202751, and 2 is %v", p.LastLBA, g.Parts[1])
+	}
+
+	out, code, err = gptRun(t, dev, options{Verify: true})
+	if err != nil || code != 0 || out != "No problems found.\n" {
+		t.Errorf("-v: got %q, %d, %v", out, code, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	dev := disk(t)
+	if _, _, err := gptRun(t, dev, options{Clear: true, New: []string{"1:0:+10M"}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name string
+		opts options
+		code int
+	}{
+		{"in use", options{New: []string{"1:0:0"}}, exitUsage},
+		{"too big", options{New: []string{"2:0:+1G"}}, exitUsage},
+		{"not free", options{New: []string{"2:4096:0"}}, exitUsage},
+		{"bad number", options{Delete: []int{129}}, exitUsage},
+		{"no partition", options{Name: []string{"2:x"}}, exitUsage},
+		{"long name", options{Name: []string{"1:" + strings.Repeat("x", 37)}}, exitUsage},
+		{"bad type", options{Type: []string{"1:ntfs"}}, exitUsage},
+		{"bad attribute", options{Attribute: []string{"1:flip:2"}}, exitUsage},
+		{"bad bit", options{Attribute: []string{"1:set:64"}}, exitUsage},
+		{"bad size", options{Resize: []string{"1:+10X"}}, exitUsage},
+		{"import", options{Import: filepath.Join(t.TempDir(), "none.json")}, exitRead},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, code, err := gptRun(t, dev, tt.opts); err == nil || code != tt.code {
+				t.Errorf("got %d, %v, want %d and an error", code, err, tt.code)
+			}
+		})
+	}
+	// nothing was written
+	if g := table(t, dev); g.Parts[0].LastLBA != 22527 || !g.Parts[1].IsEmpty() {
+		t.Errorf("the table changed: %v", g)
+	}
+}
+
+func TestRepairAndExport(t *testing.T) {
+	dev := disk(t)
+	saved := filepath.Join(t.TempDir(), "table.json")
+	if _, _, err := gptRun(t, dev, options{Clear: true, New: []string{"1:0:0"}, Name: []string{"1:data"}, Export: saved}); err != nil {
+		t.Fatal(err)
+	}
+
+	// break the primary header
+	f, err := os.OpenFile(dev, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt(make([]byte, gpt.BlockSize), gpt.BlockSize); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	out, code, err := gptRun(t, dev, options{Verify: true})
+	if err != nil || code != exitProblem || !strings.Contains(out, "Problem: Primary GPT signature invalid") {
+		t.Errorf("-v of a broken primary: got %q, %d, %v", out, code, err)
+	}
+	if _, code, err := gptRun(t, dev, options{Print: true}); err == nil || code != exitRead {
+		t.Errorf("-p of a broken primary: got %d, %v, want %d and an error", code, err, exitRead)
+	}
+	out, code, err = gptRun(t, dev, options{Repair: true, Verify: true})
+	if err != nil || code != 0 || out != "No problems found.\n" {
+		t.Errorf("--repair -v: got %q, %d, %v", out, code, err)
+	}
+	if g := table(t, dev); g.Parts[0].Name.String() != "data" {
+		t.Errorf("after --repair, partition 1 is named %q, want data", &g.Parts[0].Name)
+	}
+
+	// a fresh table, then the saved one
+	if _, _, err := gptRun(t, dev, options{Clear: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := gptRun(t, dev, options{Import: saved}); err != nil {
+		t.Fatal(err)
+	}
+	if g := table(t, dev); g.Parts[0].Name.String() != "data" || g.Parts[0].LastLBA != 204766 {
+		t.Errorf("after --import, partition 1 is %v", g.Parts[0])
+	}
+	out, _, err = gptRun(t, dev, options{Export: "-"})
+	if err != nil || !strings.Contains(out, `"Primary": {`) {
+		t.Errorf("--export -: got %q, %v", out, err)
+	}
+}
@@ -0,0 +1,233 @@
+// hdparm identifies ATA disks and manages their security: unlocking them,
+// setting and removing their passwords, and erasing them.
+//
+// Synopsis:
+//
+//	hdparm [-i] [-j] DEVICE
+//	hdparm [--user-master u|m] --security-unlock PASSWORD DEVICE
+//	hdparm [--user-master u|m] --yes-i-know --security-set-pass PASSWORD DEVICE
+//	hdparm [--user-master u|m] --yes-i-know --security-disable PASSWORD DEVICE
+//	hdparm [--user-master u|m] --yes-i-know --security-erase[-enhanced] PASSWORD DEVICE
+//
+// -i, the default, prints the model, serial number, firmware, size and
+// security state of the disk, or with -j, all that the disk tells as JSON.
+//
+// The passwords are those of the user, or with --user-master m, of the
+// master (admin). Setting or removing a password and erasing the disk can
+// lock you out of it or lose all of its data, so hdparm wants --yes-i-know
+// to do them.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+
+	"mybox/pkg/mount/scuzz"
+)
+
+// options are the flags of hdparm
+type options struct {
+	Identify      bool          `short:"i" long:"identify" description:"print what the disk tells of itself"`
+	JSON          bool          `short:"j" long:"json" description:"print it as JSON"`
+	UserMaster    string        `long:"user-master" choice:"u" choice:"m" default:"u" description:"use the user or the master password"`
+	Unlock        string        `long:"security-unlock" value-name:"PASSWORD" description:"unlock the disk"`
+	SetPass       string        `long:"security-set-pass" value-name:"PASSWORD" description:"set the password"`
+	Disable       string        `long:"security-disable" value-name:"PASSWORD" description:"remove the user password"`
+	Erase         string        `long:"security-erase" value-name:"PASSWORD" description:"erase the disk"`
+	EnhancedErase string        `long:"security-erase-enhanced" value-name:"PASSWORD" description:"erase the disk and the sectors it no longer uses"`
+	YesIKnow      bool          `long:"yes-i-know" description:"do what can lose the data on the disk"`
+	Timeout       time.Duration `long:"timeout" default:"15s" description:"how long to wait for the disk"`
+}
+
+// Debug prints what hdparm does
+var Debug = func(string, ...interface{}) {}
+
+// maxPassword is how long an ATA password can be
+const maxPassword = 32
+
+// disk is the file of a device, as scuzz.NewSGDiskFromFile takes it
+type disk interface {
+	Close() error
+	Name() string
+	Fd() uintptr
+}
+
+// openDisk opens a device, changed by tests for a fake disk
+var openDisk = func(name string) (disk, error) {
+	return os.OpenFile(name, os.O_RDWR, 0)
+}
+
+// yesNo is the name of a security state, or "not" and the name
+func yesNo(b bool, name string) string {
+	if b {
+		return name
+	}
+	return "not " + name
+}
+
+// show prints the identity of a disk
+func show(w io.Writer, name string, i *scuzz.Info) {
+	fmt.Fprintf(w, "%s:\n", name)
+	fmt.Fprintf(w, "%-18s %s\n", "Model:", i.Model)
+	fmt.Fprintf(w, "%-18s %s\n", "Serial number:", i.Serial)
+	fmt.Fprintf(w, "%-18s %s\n", "Firmware:", i.FirmwareRevision)
+	fmt.Fprintf(w, "%-18s %d (%d MB)\n", "Sectors:", i.NumberSectors, i.NumberSectors*512/1000/1000)
+	s := i.SecurityStatus
+	fmt.Fprintln(w, "Security:")
+	if !s.SecuritySupported() {
+		fmt.Fprintln(w, "\tnot supported")
+		return
+	}
+	fmt.Fprintf(w, "\tMaster password revision: %d\n", i.MasterRevision)
+	for _, state := range []string{
+		"supported",
+		yesNo(s.SecurityEnabled(), "enabled"),
+		yesNo(s.SecurityLocked(), "locked"),
+		yesNo(s.SecurityFrozen(), "frozen"),
+		yesNo(s.SecurityCountExpired(), "expired: security count"),
+	} {
+		fmt.Fprintf(w, "\t%s\n", state)
+	}
+}
+
+// security checks that the security state of the disk allows the
+// command, and runs it
+func (opts *options) security(w io.Writer, d *scuzz.SGDisk, i *scuzz.Info, name string) error {
+	admin := opts.UserMaster == "m"
+	who := "user"
+	if admin {
+		who = "master"
+	}
+	s := i.SecurityStatus
+	if !s.SecuritySupported() {
+		return fmt.Errorf("%s: the disk has no security", name)
+	}
+	if s.SecurityFrozen() {
+		return fmt.Errorf("%s: security is frozen, it can not be changed until the disk is reset", name)
+	}
+
+	if opts.Unlock != "" {
+		if s.SecurityCountExpired() {
+			return fmt.Errorf("%s: too many failed unlocks, the disk must be reset first", name)
+		}
+		Debug("unlocking %s with the %s password", name, who)
+		if err := d.Unlock(opts.Unlock, admin); err != nil {
+			return fmt.Errorf("%s: unlocking with the %s password: %w", name, who, err)
+		}
+		fmt.Fprintf(w, "%s: unlocked with the %s password\n", name, who)
+		return nil
+	}
+
+	if !opts.YesIKnow {
+		return fmt.Errorf("%s: this can lock you out of the disk or erase it, give --yes-i-know to go on", name)
+	}
+	if s.SecurityLocked() {
+		return fmt.Errorf("%s: the disk is locked, unlock it first", name)
+	}
+	switch {
+	case opts.SetPass != "":
+		Debug("setting the %s password of %s", who, name)
+		if err := d.SetPassword(opts.SetPass, admin); err != nil {
+			return fmt.Errorf("%s: setting the %s password: %w", name, who, err)
+		}
+		fmt.Fprintf(w, "%s: set the %s password\n", name, who)
+	case opts.Disable != "":
+		if !s.SecurityEnabled() {
+			return fmt.Errorf("%s: security is not enabled, there is no password to remove", name)
+		}
+		Debug("disabling security of %s with the %s password", name, who)
+		if err := d.DisablePassword(opts.Disable, admin); err != nil {
+			return fmt.Errorf("%s: removing the password with the %s password: %w", name, who, err)
+		}
+		fmt.Fprintf(w, "%s: removed the password\n", name)
+	default:
+		if !s.SecurityEnabled() {
+			return fmt.Errorf("%s: security is not enabled, set a user password to erase the disk", name)
+		}
+		password, enhanced := opts.Erase, opts.EnhancedErase != ""
+		if enhanced {
+			password = opts.EnhancedErase
+		}
+		fmt.Fprintf(w, "%s: erasing, this can take hours\n", name)
+		Debug("erasing %s with the %s password, enhanced %v", name, who, enhanced)
+		start := time.Now()
+		if err := d.SecureErase(password, admin, enhanced); err != nil {
+			return fmt.Errorf("%s: erasing with the %s password: %w", name, who, err)
+		}
+		fmt.Fprintf(w, "%s: erased in %v\n", name, time.Since(start).Round(time.Second))
+	}
+	return nil
+}
+
+func run(w io.Writer, opts options, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("want one DEVICE, got %q", args)
+	}
+	name := args[0]
+
+	var commands []string
+	for _, p := range []string{opts.Unlock, opts.SetPass, opts.Disable, opts.Erase, opts.EnhancedErase} {
+		if p != "" {
+			commands = append(commands, p)
+		}
+	}
+	if len(commands) > 1 {
+		return errors.New("only one security command at once")
+	}
+	if len(commands) == 1 && opts.Identify {
+		return errors.New("-i and a security command together")
+	}
+	for _, p := range commands {
+		if len(p) > maxPassword {
+			return fmt.Errorf("the password is %d bytes, at most %d can be used", len(p), maxPassword)
+		}
+	}
+
+	f, err := openDisk(name)
+	if err != nil {
+		return err
+	}
+	d, err := scuzz.NewSGDiskFromFile(f, scuzz.WithTimeout(opts.Timeout))
+	if err != nil {
+		f.Close()
+		return err
+	}
+	defer d.Close()
+	i, err := d.Identify()
+	if err != nil {
+		return err
+	}
+
+	if len(commands) == 1 {
+		return opts.security(w, d, i, name)
+	}
+	if opts.JSON {
+		fmt.Fprintln(w, i)
+		return nil
+	}
+	show(w, name, i)
+	return nil
+}
+
+func main() {
+	var opts options
+	args, err := flags.Parse(&opts)
+	if flags.WroteHelp(err) {
+		os.Exit(0)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+	log.SetFlags(0)
+	log.SetPrefix("hdparm: ")
+
+	if err := run(os.Stdout, opts, args); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"mybox/pkg/mount/scuzz"
+)
+
+// fake is a disk with ATA security that carries out the commands itself
+type fake struct {
+	user, master string
+	security     uint16
+	prepared     bool
+	erased       bool
+	commands     []byte
+}
+
+func (f *fake) Close() error  { return nil }
+func (f *fake) Name() string  { return "fake" }
+func (f *fake) Fd() uintptr   { return ^uintptr(0) }
+func (f *fake) enabled() bool { return f.security&0x2 != 0 }
+
+// ataString swaps the bytes of s, space padded to n bytes
+func ataString(s string, n int) []byte {
+	b := []byte(s + strings.Repeat(" ", n-len(s)))
+	for i := 0; i < n; i += 2 {
+		b[i], b[i+1] = b[i+1], b[i]
+	}
+	return b
+}
+
+func (f *fake) Operate(cdb, data, status []byte) error {
+	cmd := cdb[14]
+	f.commands = append(f.commands, cmd)
+	password := string(bytes.TrimRight(data[2:34], "\x00"))
+	admin := data[1] == 1
+	right := (admin && password == f.master) || (!admin && password == f.user)
+	fail := func() error {
+		// an ATA Return descriptor with the error bit set
+		status[0], status[8], status[21] = 0x72, 0x09, 0x51
+		return nil
+	}
+	if cmd != 0xf4 {
+		f.prepared = false
+	}
+	switch cmd {
+	case 0xec:
+		copy(data[20:], ataString("S3Z9NB0K123456", 20))
+		copy(data[46:], ataString("RVT04B6Q", 8))
+		copy(data[54:], ataString("Samsung SSD 860 EVO 500GB", 40))
+		binary.LittleEndian.PutUint16(data[184:], 0xfffe)
+		binary.LittleEndian.PutUint64(data[200:], 976773168)
+		binary.LittleEndian.PutUint16(data[256:], f.security)
+	case 0xf2:
+		if !right {
+			return fail()
+		}
+		f.security &^= 0x4
+	case 0xf1:
+		if admin {
+			f.master = password
+		} else {
+			f.user = password
+			f.security |= 0x2
+		}
+	case 0xf6:
+		if !right {
+			return fail()
+		}
+		f.security &^= 0x2
+	case 0xf3:
+		f.prepared = true
+	case 0xf4:
+		if !f.prepared || !right {
+			return fail()
+		}
+		f.erased = true
+		f.security &^= 0x2
+	default:
+		return fmt.Errorf("command %#02x is not supported", cmd)
+	}
+	return nil
+}
+
+// hdparm runs run on d with opts, the flags it leaves unset at their
+// defaults
+func hdparm(t *testing.T, d *fake, opts options) (string, error) {
+	t.Helper()
+	saved := openDisk
+	defer func() { openDisk = saved }()
+	openDisk = func(string) (disk, error) { return d, nil }
+	if opts.UserMaster == "" {
+		opts.UserMaster = "u"
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = scuzz.DefaultTimeout
+	}
+	var out bytes.Buffer
+	err := run(&out, opts, []string{"/dev/sda"})
+	return out.String(), err
+}
+
+func TestIdentify(t *testing.T) {
+	d := &fake{security: 0x1 | 0x8}
+	out, err := hdparm(t, d, options{Identify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range []string{
+		"Model:             Samsung SSD 860 EVO 500GB\n",
+		"Serial number:     S3Z9NB0K123456\n",
+		"Firmware:          RVT04B6Q\n",
+		"Sectors:           976773168 (500107 MB)\n",
+		"\tMaster password revision: 65534\n\tsupported\n\tnot enabled\n\tnot locked\n\tfrozen\n\tnot expired: security count\n",
+	} {
+		if !strings.Contains(out, w) {
+			t.Errorf("no %q in\n%s", w, out)
+		}
+	}
+
+	out, err = hdparm(t, d, options{JSON: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var i scuzz.Info
+	if err := json.Unmarshal([]byte(out), &i); err != nil {
+		t.Fatalf("%v:\n%s", err, out)
+	}
+	if i.Model != "Samsung SSD 860 EVO 500GB" || i.NumberSectors != 976773168 || !i.SecurityStatus.SecurityFrozen() {
+		t.Errorf("got %+v", i)
+	}
+}
+
+func TestSecurity(t *testing.T) {
+	d := &fake{security: 0x1, master: "master"}
+	for _, tt := range []struct {
+		name string
+		opts options
+		// state is set in the security word of the disk first
+		state uint16
+		want  string
+		err   string
+	}{
+		{name: "set without --yes-i-know", opts: options{SetPass: "user"}, err: "--yes-i-know"},
+		{name: "erase without a user password", opts: options{Erase: "user", YesIKnow: true}, err: "set a user password"},
+		{name: "set", opts: options{SetPass: "user", YesIKnow: true}, want: "/dev/sda: set the user password\n"},
+		{name: "locked", state: 0x4, want: "\tenabled\n\tlocked\n"},
+		{name: "set while locked", opts: options{SetPass: "other", YesIKnow: true}, err: "unlock it first"},
+		{name: "wrong unlock", opts: options{Unlock: "wrong"}, err: "unlocking with the user password"},
+		{name: "unlock", opts: options{Unlock: "user"}, want: "/dev/sda: unlocked with the user password\n"},
+		{name: "enhanced erase with the master password", opts: options{EnhancedErase: "master", UserMaster: "m", YesIKnow: true}, want: "/dev/sda: erased in "},
+		{name: "disable without a password", opts: options{Disable: "user", YesIKnow: true}, err: "no password to remove"},
+		{name: "set again", opts: options{SetPass: "again", YesIKnow: true}, want: "set the user password"},
+		{name: "disable", opts: options{Disable: "again", YesIKnow: true}, want: "/dev/sda: removed the password\n"},
+		{name: "frozen", opts: options{Unlock: "again"}, state: 0x8, err: "security is frozen"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			d.security |= tt.state
+			out, err := hdparm(t, d, tt.opts)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("got %v, want an error with %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(out, tt.want) {
+				t.Errorf("no %q in\n%s", tt.want, out)
+			}
+		})
+	}
+	if !d.erased {
+		t.Error("the disk is not erased")
+	}
+	if got, want := fmt.Sprintf("% x", d.commands[len(d.commands)-5:]), "ec ec f6 ec ec"; got != want {
+		t.Errorf("got the last commands %s, want %s", got, want)
+	}
+}
+
+func TestArgs(t *testing.T) {
+	d := &fake{security: 0x1}
+	for _, opts := range []options{
+		{Unlock: "a", SetPass: "b"},
+		{Unlock: "a", Identify: true},
+		{Unlock: strings.Repeat("x", 33)},
+	} {
+		if _, err := hdparm(t, d, opts); err == nil {
+			t.Error("got no error")
+		}
+	}
+	if len(d.commands) != 0 {
+		t.Errorf("commands %x sent to the disk for bad arguments", d.commands)
+	}
+}
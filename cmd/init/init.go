@@ -84,7 +84,7 @@ func sysInit(start time.Time) error {
 	go internal.Gettys(ctx, 1, true)
 
 	go func() {
-		_, err := internal.DHCPClient("eth0")
+		_, err := internal.DHCPClient(ctx, "eth0")
 		if err != nil {
 			log.Panicln(err)
 			return
@@ -5,7 +5,7 @@ import (
 	"os"
 	"strings"
 
-	"mybox/pkg/kmodule"
+	"mybox/pkg/kmod"
 )
 
 const usage = `insmod [filename] [module parameters...]`
@@ -33,7 +33,7 @@ func main() {
 	}
 	defer f.Close()
 
-	if err := kmodule.FileInit(f, opts, 0); err != nil {
+	if err := kmod.FileInit(f, opts, 0); err != nil {
 		log.Fatalf("insmod: could not load %v: %v", filename, err)
 	}
 }
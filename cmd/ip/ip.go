@@ -1,11 +1,10 @@
 package main
 
 import (
-	"errors"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
-	"net"
 	"os"
 	"strings"
 
@@ -14,7 +13,11 @@ import (
 )
 
 var opts struct {
+	Inet4   bool `short:"4" long:"inet4" description:"use ipv4"`
 	Inet6   bool `short:"6" long:"inet6" description:"use ipv6"`
+	Stats   bool `short:"s" long:"stats" description:"show link statistics"`
+	JSON    bool `short:"j" long:"json" description:"output in JSON"`
+	Pretty  bool `short:"p" long:"pretty" description:"pretty print JSON output"`
 	Verbose bool `short:"v" long:"verbose" description:"print debugging information and verbose output"`
 }
 
@@ -44,6 +47,38 @@ func usage() error {
 		arg[0:cursor], arg[cursor:], arg[cursor], whatIWant)
 }
 
+// next moves the cursor on and returns the token under it. Running off the
+// end panics like every other arg[cursor] and run turns that into an error.
+func next(want ...string) string {
+	cursor++
+	whatIWant = want
+	return arg[cursor]
+}
+
+// more reports whether there are tokens left after the cursor
+func more() bool {
+	return cursor+1 < len(arg)
+}
+
+// family picks the address family from -4 and -6, falling back to def
+func family(def int) int {
+	switch {
+	case opts.Inet6:
+		return netlink.FAMILY_V6
+	case opts.Inet4:
+		return netlink.FAMILY_V4
+	}
+	return def
+}
+
+func printJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	if opts.Pretty {
+		enc.SetIndent("", "    ")
+	}
+	return enc.Encode(v)
+}
+
 func one(cmd string, cmds []string) string {
 	var x, n int
 	for i, v := range cmds {
@@ -71,28 +106,20 @@ func dev() (netlink.Link, error) {
 	return netlink.LinkByName(arg[cursor])
 }
 
-func maybename() (string, error) {
-	cursor++
-	whatIWant = []string{"name", "device name"}
-	if arg[cursor] == "name" {
-		cursor++
-	}
-	whatIWant = []string{"device name"}
-	return arg[cursor], nil
-}
-
 func addrip(w io.Writer) error {
 	var err error
 	var addr *netlink.Addr
-	if len(arg) == 1 {
-		return showLinks(w, true)
+	if !more() {
+		return showLinks(w, nil, true)
 	}
 	cursor++
-	whatIWant = []string{"add", "del"}
+	whatIWant = []string{"add", "del", "show", "list"}
 	cmd := arg[cursor]
 
 	c := one(cmd, whatIWant)
 	switch c {
+	case "show", "list":
+		return linkshow(w, true)
 	case "add", "del":
 		cursor++
 		whatIWant = []string{"CIDR format address"}
@@ -123,253 +150,64 @@ func addrip(w io.Writer) error {
 }
 
 func neigh(w io.Writer) error {
-	if len(arg) != 1 {
-		return errors.New("neigh subcommands not supported yet")
-	}
-	return showNeighbours(w, true)
-}
-
-func linkshow(w io.Writer) error {
-	cursor++
-	whatIWant = []string{"<nothing>", "<device name>"}
-	if len(arg[cursor:]) == 0 {
-		return showLinks(w, false)
-	}
-	return nil
-}
-
-func setHardwareAddress(iface netlink.Link) error {
-	cursor++
-	hwAddr, err := net.ParseMAC(arg[cursor])
-	if err != nil {
-		return fmt.Errorf("%v cant parse mac addr %v: %v", iface.Attrs().Name, hwAddr, err)
-	}
-	err = netlink.LinkSetHardwareAddr(iface, hwAddr)
-	if err != nil {
-		return fmt.Errorf("%v cant set mac addr %v: %v", iface.Attrs().Name, hwAddr, err)
-	}
-	return nil
-}
-
-func linkset() error {
-	iface, err := dev()
-	if err != nil {
-		return err
-	}
-
-	cursor++
-	whatIWant = []string{"address", "up", "down", "master"}
-	switch one(arg[cursor], whatIWant) {
-	case "address":
-		return setHardwareAddress(iface)
-	case "up":
-		if err := netlink.LinkSetUp(iface); err != nil {
-			return fmt.Errorf("%v can't make it up: %v", iface.Attrs().Name, err)
-		}
-	case "down":
-		if err := netlink.LinkSetDown(iface); err != nil {
-			return fmt.Errorf("%v can't make it down: %v", iface.Attrs().Name, err)
+	if !more() {
+		return showNeighbours(w, nil)
+	}
+	whatIWant = []string{"show", "list"}
+	switch one(next("show", "list"), whatIWant) {
+	case "show", "list":
+		if !more() {
+			return showNeighbours(w, nil)
 		}
-	case "master":
-		cursor++
-		whatIWant = []string{"device name"}
-		master, err := netlink.LinkByName(arg[cursor])
+		iface, err := dev()
 		if err != nil {
 			return err
 		}
-		return netlink.LinkSetMaster(iface, master)
-	default:
-		return usage()
-	}
-	return nil
-}
-
-func linkadd() error {
-	name, err := maybename()
-	if err != nil {
-		return err
-	}
-	attrs := netlink.LinkAttrs{Name: name}
-
-	cursor++
-	whatIWant = []string{"type"}
-	if arg[cursor] != "type" {
-		return usage()
-	}
-
-	cursor++
-	whatIWant = []string{"bridge"}
-	if arg[cursor] != "bridge" {
-		return usage()
-	}
-	return netlink.LinkAdd(&netlink.Bridge{LinkAttrs: attrs})
-}
-
-func link(w io.Writer) error {
-	if len(arg) == 1 {
-		return linkshow(w)
-	}
-
-	cursor++
-	whatIWant = []string{"show", "set", "add"}
-	cmd := arg[cursor]
-
-	switch one(cmd, whatIWant) {
-	case "show":
-		return linkshow(w)
-	case "set":
-		return linkset()
-	case "add":
-		return linkadd()
-	}
-	return usage()
-}
-
-func routeshow(w io.Writer) error {
-	return showRoutes(w, opts.Inet6)
-}
-
-func nodespec() string {
-	cursor++
-	whatIWant = []string{"default", "CIDR"}
-	return arg[cursor]
-}
-
-func nexthop() (string, net.IP, error) {
-	cursor++
-	whatIWant = []string{"via"}
-	if arg[cursor] != "via" {
-		return "", nil, usage()
-	}
-	nh := arg[cursor]
-	cursor++
-	whatIWant = []string{"Gateway CIDR"}
-	addr := net.ParseIP(arg[cursor])
-	if addr == nil {
-		return "", nil, fmt.Errorf("failed to parse gateway IP: %v", arg[cursor])
-	}
-	return nh, addr, nil
-}
-
-func routeadddefault(w io.Writer) error {
-	nh, nhval, err := nexthop()
-	if err != nil {
-		return err
-	}
-	// TODO: NHFLAGS.
-	l, err := dev()
-	if err != nil {
-		return err
-	}
-	switch nh {
-	case "via":
-		fmt.Fprintf(w, "Add default route %v via %v", nhval, l.Attrs().Name)
-		r := &netlink.Route{LinkIndex: l.Attrs().Index, Gw: nhval}
-		if err := netlink.RouteAdd(r); err != nil {
-			return fmt.Errorf("error adding default route to %v: %v", l.Attrs().Name, err)
-		}
-		return nil
-	}
-	return usage()
-}
-
-func routeadd(w io.Writer) error {
-	ns := nodespec()
-	switch ns {
-	case "default":
-		return routeadddefault(w)
-	default:
-		addr, err := netlink.ParseAddr(arg[cursor])
-		if err != nil {
-			return usage()
-		}
-		d, err := dev()
-		if err != nil {
-			return usage()
-		}
-		r := &netlink.Route{LinkIndex: d.Attrs().Index, Dst: addr.IPNet}
-		if err := netlink.RouteAdd(r); err != nil {
-			return fmt.Errorf("error adding route %s -> %s: %v", addr, d.Attrs().Name, err)
-		}
-		return nil
-	}
-}
-
-func routedel() error {
-	cursor++
-	addr, err := netlink.ParseAddr(arg[cursor])
-	if err != nil {
-		return usage()
-	}
-	d, err := dev()
-	if err != nil {
-		return usage()
-	}
-	r := &netlink.Route{LinkIndex: d.Attrs().Index, Dst: addr.IPNet}
-	if err := netlink.RouteDel(r); err != nil {
-		return fmt.Errorf("error adding route %s -> %s: %v", addr, d.Attrs().Name, err)
-	}
-	return nil
-}
-
-func route(w io.Writer) error {
-	cursor++
-	if len(arg[cursor:]) == 0 {
-		return routeshow(w)
-	}
-
-	whatIWant = []string{"show", "add", "del"}
-	switch one(arg[cursor], whatIWant) {
-	case "add":
-		return routeadd(w)
-	case "del":
-		return routedel()
-	case "show":
-		return routeshow(w)
+		return showNeighbours(w, iface)
 	}
 	return usage()
 }
 
-func run(out io.Writer) error {
+func run(out io.Writer) (err error) {
 	// When this is embedded in busybox we need to reinit some things.
-	whatIWant = []string{"address", "route", "link", "neigh"}
+	whatIWant = []string{"address", "route", "rule", "link", "neigh", "netns", "monitor"}
 	cursor = 0
 
-	defer func() error {
-		switch err := recover().(type) {
+	defer func() {
+		switch e := recover().(type) {
 		case nil:
 		case error:
-			if strings.Contains(err.Error(), "index out of range") {
-				return fmt.Errorf("args: %v, I got to arg %v, I wanted %v after that", arg, cursor, whatIWant)
-			} else if strings.Contains(err.Error(), "slice bounds out of range") {
-				return fmt.Errorf("args: %v, I got to arg %v, I wanted %v after that", arg, cursor, whatIWant)
+			if strings.Contains(e.Error(), "index out of range") {
+				err = fmt.Errorf("args: %v, I got to arg %v, I wanted %v after that", arg, cursor, whatIWant)
+			} else if strings.Contains(e.Error(), "slice bounds out of range") {
+				err = fmt.Errorf("args: %v, I got to arg %v, I wanted %v after that", arg, cursor, whatIWant)
+			} else {
+				err = fmt.Errorf("bummer: %v", e)
 			}
-			return fmt.Errorf("bummer: %v", err)
 		default:
-			return fmt.Errorf("unexpected panic value: %T(%v)", err, err)
+			err = fmt.Errorf("unexpected panic value: %T(%v)", e, e)
 		}
-		return nil
 	}()
 
 	// The ip command doesn't actually follow the BNF it prints on error.
 	// There are lots of handy shortcuts that people will expect.
-	var err error
 	switch one(arg[cursor], whatIWant) {
 	case "address":
-		err = addrip(out)
+		return addrip(out)
 	case "link":
-		err = link(out)
+		return link(out)
 	case "route":
-		err = route(out)
+		return route(out)
+	case "rule":
+		return rule(out)
 	case "neigh":
-		err = neigh(out)
-	default:
-		err = usage()
-	}
-	if err != nil {
-		return err
+		return neigh(out)
+	case "netns":
+		return netnsCmd(out)
+	case "monitor":
+		return monitor(out)
 	}
-	return nil
+	return usage()
 }
 
 func IP(arg []string) error {
@@ -377,9 +215,10 @@ func IP(arg []string) error {
 }
 
 func main() {
-	args, err := flags.Parse(&opts)
+	// stop at the first non-option so "ip netns exec NAME cmd -x" keeps
+	// its arguments for cmd
+	args, err := flags.NewParser(&opts, flags.Default|flags.PassAfterNonOption).Parse()
 	if flags.WroteHelp(err) {
-		usage()
 		os.Exit(0)
 	}
 	if err != nil {
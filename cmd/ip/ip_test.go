@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+// inNetns runs the test on a locked thread moved into a fresh network
+// namespace, so nothing here touches the host's links
+func inNetns(t *testing.T) {
+	t.Helper()
+	if os.Getuid() != 0 {
+		t.Skip("needs root to create a network namespace")
+	}
+
+	runtime.LockOSThread()
+	orig, err := netns.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns, err := netns.New()
+	if err != nil {
+		orig.Close()
+		runtime.UnlockOSThread()
+		t.Skipf("can't create network namespace: %v", err)
+	}
+	t.Cleanup(func() {
+		ns.Close()
+		if err := netns.Set(orig); err != nil {
+			t.Fatalf("restoring network namespace: %v", err)
+		}
+		orig.Close()
+		runtime.UnlockOSThread()
+	})
+}
+
+func ip(t *testing.T, args ...string) string {
+	t.Helper()
+	var b bytes.Buffer
+	arg = args
+	if err := run(&b); err != nil {
+		t.Fatalf("ip %s: %v", strings.Join(args, " "), err)
+	}
+	return b.String()
+}
+
+func ipJSON(t *testing.T, v interface{}, args ...string) {
+	t.Helper()
+	opts.JSON = true
+	defer func() { opts.JSON = false }()
+	if err := json.Unmarshal([]byte(ip(t, args...)), v); err != nil {
+		t.Fatalf("ip -j %s: %v", strings.Join(args, " "), err)
+	}
+}
+
+func TestLinksRoutesRules(t *testing.T) {
+	inNetns(t)
+
+	arg = strings.Fields("link add veth0 type veth peer name veth1")
+	if err := run(&bytes.Buffer{}); errors.Is(err, unix.EOPNOTSUPP) {
+		t.Skipf("no veth support: %v", err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	ip(t, "link", "set", "veth0", "up", "mtu", "1400")
+	ip(t, "link", "set", "dev", "veth1", "up")
+	ip(t, "address", "add", "10.0.0.1/24", "dev", "veth0")
+	ip(t, "route", "add", "192.168.5.0/24", "via", "10.0.0.254", "dev", "veth0", "metric", "50")
+	ip(t, "route", "add", "172.16.0.0/16", "dev", "veth0", "table", "100", "src", "10.0.0.1")
+	ip(t, "rule", "add", "from", "10.0.0.0/24", "fwmark", "0x1/0xff", "table", "100", "pref", "100")
+
+	var links []linkJSON
+	ipJSON(t, &links, "link", "show", "veth0")
+	if len(links) != 1 {
+		t.Fatalf("got %d links, want 1", len(links))
+	}
+	if l := links[0]; l.Ifname != "veth0" || l.Link != "veth1" || l.MTU != 1400 || l.InfoKind != "veth" {
+		t.Errorf("link show veth0 = %+v", l)
+	}
+
+	opts.Stats = true
+	ipJSON(t, &links, "link", "show", "veth0")
+	opts.Stats = false
+	if links[0].Stats64 == nil {
+		t.Errorf("link -s show veth0 has no statistics")
+	}
+
+	ipJSON(t, &links, "address", "show", "dev", "veth0")
+	var found bool
+	for _, a := range links[0].AddrInfo {
+		found = found || a.Local == "10.0.0.1" && a.Prefixlen == 24 && a.Scope == "global"
+	}
+	if !found {
+		t.Errorf("address show veth0 = %+v, want 10.0.0.1/24", links[0].AddrInfo)
+	}
+
+	out := ip(t, "route")
+	for _, want := range []string{
+		"10.0.0.0/24 dev veth0 proto kernel scope link src 10.0.0.1\n",
+		"192.168.5.0/24 via 10.0.0.254 dev veth0 metric 50\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("ip route = %q, want it to contain %q", out, want)
+		}
+	}
+	if strings.Contains(out, "172.16.0.0") {
+		t.Errorf("ip route = %q, shows table 100", out)
+	}
+
+	var routes []routeJSON
+	ipJSON(t, &routes, "route", "show", "table", "100")
+	want := routeJSON{Dst: "172.16.0.0/16", Dev: "veth0", Table: "100", Scope: "link", Prefsrc: "10.0.0.1"}
+	if len(routes) != 1 || routes[0] != want {
+		t.Errorf("route show table 100 = %+v, want %+v", routes, want)
+	}
+
+	if got := ip(t, "route", "get", "192.168.5.7"); !strings.HasPrefix(got, "192.168.5.7 via 10.0.0.254 dev veth0") {
+		t.Errorf("route get = %q", got)
+	}
+
+	if got, want := ip(t, "rule"), "100:\tfrom 10.0.0.0/24 fwmark 0x1/0xff lookup 100\n"; !strings.Contains(got, want) {
+		t.Errorf("ip rule = %q, want it to contain %q", got, want)
+	}
+
+	ip(t, "rule", "del", "pref", "100")
+	ip(t, "route", "del", "192.168.5.0/24")
+	ip(t, "link", "set", "veth1", "name", "peer0")
+	ip(t, "link", "del", "veth0")
+
+	if out := ip(t, "rule") + ip(t, "route") + ip(t, "link"); strings.Contains(out, "veth") ||
+		strings.Contains(out, "fwmark") || strings.Contains(out, "peer0") {
+		t.Errorf("leftovers after deleting:\n%s", out)
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	for _, args := range []string{
+		"route add",
+		"link add foo type nope",
+		"link set",
+		"frobnicate",
+	} {
+		arg = strings.Fields(args)
+		if err := run(&bytes.Buffer{}); err == nil {
+			t.Errorf("ip %s = nil, want error", args)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+var macvlanModes = map[string]netlink.MacvlanMode{
+	"private":  netlink.MACVLAN_MODE_PRIVATE,
+	"vepa":     netlink.MACVLAN_MODE_VEPA,
+	"bridge":   netlink.MACVLAN_MODE_BRIDGE,
+	"passthru": netlink.MACVLAN_MODE_PASSTHRU,
+	"source":   netlink.MACVLAN_MODE_SOURCE,
+}
+
+func linkshow(w io.Writer, withAddresses bool) error {
+	if !more() {
+		return showLinks(w, nil, withAddresses)
+	}
+	iface, err := dev()
+	if err != nil {
+		return err
+	}
+	return showLinks(w, iface, withAddresses)
+}
+
+func setHardwareAddress(iface netlink.Link) error {
+	cursor++
+	hwAddr, err := net.ParseMAC(arg[cursor])
+	if err != nil {
+		return fmt.Errorf("%v cant parse mac addr %v: %v", iface.Attrs().Name, hwAddr, err)
+	}
+	err = netlink.LinkSetHardwareAddr(iface, hwAddr)
+	if err != nil {
+		return fmt.Errorf("%v cant set mac addr %v: %v", iface.Attrs().Name, hwAddr, err)
+	}
+	return nil
+}
+
+func number(what string) (int, error) {
+	s := next(what)
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", what, s)
+	}
+	return n, nil
+}
+
+// setNetns moves iface into a network namespace given by name or by the pid
+// of a process living in it
+func setNetns(iface netlink.Link, target string) error {
+	if pid, err := strconv.Atoi(target); err == nil {
+		return netlink.LinkSetNsPid(iface, pid)
+	}
+	ns, err := netns.GetFromName(target)
+	if err != nil {
+		return fmt.Errorf("netns %q: %v", target, err)
+	}
+	defer ns.Close()
+	return netlink.LinkSetNsFd(iface, int(ns))
+}
+
+func linkset() error {
+	iface, err := dev()
+	if err != nil {
+		return err
+	}
+	name := iface.Attrs().Name
+
+	// ip link set dev eth0 down name lan0 up
+	for more() {
+		cursor++
+		whatIWant = []string{"address", "up", "down", "master", "nomaster", "mtu", "name", "netns", "txqueuelen"}
+		switch one(arg[cursor], whatIWant) {
+		case "address":
+			err = setHardwareAddress(iface)
+		case "up":
+			if err := netlink.LinkSetUp(iface); err != nil {
+				return fmt.Errorf("%v can't make it up: %v", name, err)
+			}
+		case "down":
+			if err := netlink.LinkSetDown(iface); err != nil {
+				return fmt.Errorf("%v can't make it down: %v", name, err)
+			}
+		case "master":
+			var master netlink.Link
+			master, err = netlink.LinkByName(next("device name"))
+			if err != nil {
+				return err
+			}
+			err = netlink.LinkSetMaster(iface, master)
+		case "nomaster":
+			err = netlink.LinkSetNoMaster(iface)
+		case "mtu":
+			var mtu int
+			if mtu, err = number("mtu"); err == nil {
+				err = netlink.LinkSetMTU(iface, mtu)
+			}
+		case "txqueuelen":
+			var qlen int
+			if qlen, err = number("queue length"); err == nil {
+				err = netlink.LinkSetTxQLen(iface, qlen)
+			}
+		case "name":
+			newName := next("device name")
+			if err = netlink.LinkSetName(iface, newName); err == nil {
+				iface.Attrs().Name = newName
+			}
+		case "netns":
+			err = setNetns(iface, next("namespace name", "pid"))
+		default:
+			return usage()
+		}
+		if err != nil {
+			return fmt.Errorf("%v: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// linkattrs parses the part of "ip link add" before "type":
+// [link DEV] [name] NAME [mtu N] [address LLADDR] [txqueuelen N]
+func linkattrs() (netlink.LinkAttrs, error) {
+	attrs := netlink.NewLinkAttrs()
+	for {
+		cursor++
+		whatIWant = []string{"link", "name", "mtu", "address", "txqueuelen", "type", "device name"}
+		var err error
+		switch arg[cursor] {
+		case "type":
+			if attrs.Name == "" {
+				return attrs, fmt.Errorf("link add: no device name given")
+			}
+			return attrs, nil
+		case "link":
+			var parent netlink.Link
+			if parent, err = netlink.LinkByName(next("device name")); err == nil {
+				attrs.ParentIndex = parent.Attrs().Index
+			}
+		case "name":
+			attrs.Name = next("device name")
+		case "mtu":
+			attrs.MTU, err = number("mtu")
+		case "txqueuelen", "txqlen":
+			attrs.TxQLen, err = number("queue length")
+		case "address":
+			attrs.HardwareAddr, err = net.ParseMAC(next("link layer address"))
+		default:
+			if attrs.Name != "" {
+				return attrs, usage()
+			}
+			attrs.Name = arg[cursor]
+		}
+		if err != nil {
+			return attrs, err
+		}
+	}
+}
+
+func linkadd() error {
+	attrs, err := linkattrs()
+	if err != nil {
+		return err
+	}
+
+	var l netlink.Link
+	whatIWant = []string{"bridge", "dummy", "veth", "vlan", "macvlan", "bond", "vxlan"}
+	switch typ := next(whatIWant...); typ {
+	case "bridge":
+		l = &netlink.Bridge{LinkAttrs: attrs}
+	case "dummy":
+		l = &netlink.Dummy{LinkAttrs: attrs}
+	case "veth":
+		l, err = vethArgs(attrs)
+	case "vlan":
+		l, err = vlanArgs(attrs)
+	case "macvlan":
+		l, err = macvlanArgs(attrs)
+	case "bond":
+		l, err = bondArgs(attrs)
+	case "vxlan":
+		l, err = vxlanArgs(attrs)
+	default:
+		return usage()
+	}
+	if err != nil {
+		return err
+	}
+	if err := netlink.LinkAdd(l); err != nil {
+		return fmt.Errorf("adding %v: %v", attrs.Name, err)
+	}
+	return nil
+}
+
+// veth peer name PEER
+func vethArgs(attrs netlink.LinkAttrs) (netlink.Link, error) {
+	v := &netlink.Veth{LinkAttrs: attrs}
+	for more() {
+		switch next("peer") {
+		case "peer":
+			v.PeerName = next("name", "device name")
+			if v.PeerName == "name" {
+				v.PeerName = next("device name")
+			}
+		default:
+			return nil, usage()
+		}
+	}
+	if v.PeerName == "" {
+		return nil, fmt.Errorf("veth: peer name is required")
+	}
+	return v, nil
+}
+
+// vlan id ID [protocol 802.1q|802.1ad]
+func vlanArgs(attrs netlink.LinkAttrs) (netlink.Link, error) {
+	if attrs.ParentIndex == 0 {
+		return nil, fmt.Errorf("vlan: link is required")
+	}
+	v := &netlink.Vlan{LinkAttrs: attrs, VlanId: -1, VlanProtocol: netlink.VLAN_PROTOCOL_8021Q}
+	for more() {
+		var err error
+		switch next("id", "protocol") {
+		case "id":
+			v.VlanId, err = number("vlan id")
+		case "protocol":
+			s := next("802.1q", "802.1ad")
+			if v.VlanProtocol = netlink.StringToVlanProtocol(s); v.VlanProtocol == netlink.VLAN_PROTOCOL_UNKNOWN {
+				err = fmt.Errorf("vlan: unknown protocol %q", s)
+			}
+		default:
+			return nil, usage()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if v.VlanId < 0 || v.VlanId > 4095 {
+		return nil, fmt.Errorf("vlan: id must be between 0 and 4095")
+	}
+	return v, nil
+}
+
+// macvlan mode private|vepa|bridge|passthru|source
+func macvlanArgs(attrs netlink.LinkAttrs) (netlink.Link, error) {
+	if attrs.ParentIndex == 0 {
+		return nil, fmt.Errorf("macvlan: link is required")
+	}
+	m := &netlink.Macvlan{LinkAttrs: attrs}
+	for more() {
+		switch next("mode") {
+		case "mode":
+			s := next("private", "vepa", "bridge", "passthru", "source")
+			mode, ok := macvlanModes[s]
+			if !ok {
+				return nil, fmt.Errorf("macvlan: unknown mode %q", s)
+			}
+			m.Mode = mode
+		default:
+			return nil, usage()
+		}
+	}
+	return m, nil
+}
+
+// bond [mode MODE] [miimon MS]
+func bondArgs(attrs netlink.LinkAttrs) (netlink.Link, error) {
+	b := netlink.NewLinkBond(attrs)
+	for more() {
+		var err error
+		switch next("mode", "miimon") {
+		case "mode":
+			s := next("balance-rr", "active-backup", "balance-xor", "broadcast", "802.3ad", "balance-tlb", "balance-alb")
+			if b.Mode = netlink.StringToBondMode(s); b.Mode == netlink.BOND_MODE_UNKNOWN {
+				err = fmt.Errorf("bond: unknown mode %q", s)
+			}
+		case "miimon":
+			b.Miimon, err = number("miimon")
+		default:
+			return nil, usage()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// vxlan id VNI [dev DEV] [local IP] [remote IP | group IP] [dstport PORT] [ttl N] [nolearning]
+func vxlanArgs(attrs netlink.LinkAttrs) (netlink.Link, error) {
+	v := &netlink.Vxlan{LinkAttrs: attrs, VxlanId: -1, Learning: true}
+	if attrs.ParentIndex != 0 {
+		v.VtepDevIndex = attrs.ParentIndex
+		v.LinkAttrs.ParentIndex = 0
+	}
+	ip := func(what string) (net.IP, error) {
+		s := next(what)
+		addr := net.ParseIP(s)
+		if addr == nil {
+			return nil, fmt.Errorf("vxlan: invalid %s address %q", what, s)
+		}
+		return addr, nil
+	}
+	for more() {
+		var err error
+		switch next("id", "dev", "local", "remote", "group", "dstport", "ttl", "learning", "nolearning") {
+		case "id", "vni":
+			v.VxlanId, err = number("vni")
+		case "dev":
+			var l netlink.Link
+			if l, err = netlink.LinkByName(next("device name")); err == nil {
+				v.VtepDevIndex = l.Attrs().Index
+			}
+		case "local":
+			v.SrcAddr, err = ip("local")
+		case "remote", "group":
+			v.Group, err = ip(arg[cursor])
+		case "dstport":
+			v.Port, err = number("port")
+		case "ttl":
+			v.TTL, err = number("ttl")
+		case "learning":
+			v.Learning = true
+		case "nolearning":
+			v.Learning = false
+		default:
+			return nil, usage()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if v.VxlanId < 0 {
+		return nil, fmt.Errorf("vxlan: id is required")
+	}
+	return v, nil
+}
+
+func linkdel() error {
+	iface, err := dev()
+	if err != nil {
+		return err
+	}
+	if err := netlink.LinkDel(iface); err != nil {
+		return fmt.Errorf("deleting %v: %v", iface.Attrs().Name, err)
+	}
+	return nil
+}
+
+func link(w io.Writer) error {
+	if !more() {
+		return linkshow(w, false)
+	}
+
+	cursor++
+	whatIWant = []string{"show", "list", "set", "add", "delete"}
+	cmd := arg[cursor]
+
+	switch one(cmd, whatIWant) {
+	case "show", "list":
+		return linkshow(w, false)
+	case "set":
+		return linkset()
+	case "add":
+		return linkadd()
+	case "delete":
+		return linkdel()
+	}
+	return usage()
+}
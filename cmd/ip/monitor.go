@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+type monitorJSON struct {
+	Deleted bool       `json:"deleted,omitempty"`
+	Link    *linkJSON  `json:"link,omitempty"`
+	Address *addrJSON  `json:"address,omitempty"`
+	Route   *routeJSON `json:"route,omitempty"`
+	Neigh   *neighJSON `json:"neigh,omitempty"`
+}
+
+var errSubscription = errors.New("netlink subscription closed")
+
+func printEvent(w io.Writer, ev *monitorJSON) error {
+	if opts.JSON {
+		return printJSON(w, ev)
+	}
+	if ev.Deleted {
+		fmt.Fprint(w, "Deleted ")
+	}
+	switch {
+	case ev.Link != nil:
+		printLink(w, ev.Link)
+	case ev.Address != nil:
+		// eth0    inet 10.0.0.1/24 scope global
+		a := ev.Address
+		fmt.Fprintf(w, "%s    %s %s/%d scope %s\n", a.Label, a.Family, a.Local, a.Prefixlen, a.Scope)
+	case ev.Route != nil:
+		fmt.Fprintln(w, formatRoute(*ev.Route))
+	case ev.Neigh != nil:
+		fmt.Fprintln(w, formatNeigh(*ev.Neigh))
+	}
+	return nil
+}
+
+func addrEvent(u netlink.AddrUpdate) *monitorJSON {
+	a := &addrJSON{
+		Family:            "inet",
+		Local:             u.LinkAddress.IP.String(),
+		Scope:             addrScopes[netlink.Scope(u.Scope)],
+		Label:             linkName(u.LinkIndex),
+		ValidLifeTime:     uint32(u.ValidLft),
+		PreferredLifeTime: uint32(u.PreferedLft),
+	}
+	if u.LinkAddress.IP.To4() == nil {
+		a.Family = "inet6"
+	}
+	a.Prefixlen, _ = u.LinkAddress.Mask.Size()
+	return &monitorJSON{Deleted: !u.NewAddr, Address: a}
+}
+
+// monitor prints netlink notifications until the subscriptions break:
+// ip monitor [all|link|address|route|neigh]...
+func monitor(w io.Writer) error {
+	want := map[string]bool{}
+	for more() {
+		whatIWant = []string{"all", "link", "address", "route", "neigh"}
+		obj := one(next(whatIWant...), whatIWant)
+		if obj == "" {
+			return usage()
+		}
+		want[obj] = true
+	}
+	all := len(want) == 0 || want["all"]
+
+	done := make(chan struct{})
+	defer close(done)
+
+	var (
+		links  chan netlink.LinkUpdate
+		addrs  chan netlink.AddrUpdate
+		routes chan netlink.RouteUpdate
+		neighs chan netlink.NeighUpdate
+	)
+	if all || want["link"] {
+		links = make(chan netlink.LinkUpdate)
+		if err := netlink.LinkSubscribe(links, done); err != nil {
+			return err
+		}
+	}
+	if all || want["address"] {
+		addrs = make(chan netlink.AddrUpdate)
+		if err := netlink.AddrSubscribe(addrs, done); err != nil {
+			return err
+		}
+	}
+	if all || want["route"] {
+		routes = make(chan netlink.RouteUpdate)
+		if err := netlink.RouteSubscribe(routes, done); err != nil {
+			return err
+		}
+	}
+	if all || want["neigh"] {
+		neighs = make(chan netlink.NeighUpdate)
+		if err := netlink.NeighSubscribe(neighs, done); err != nil {
+			return err
+		}
+	}
+
+	for {
+		var ev *monitorJSON
+		select {
+		case u, ok := <-links:
+			if !ok {
+				return errSubscription
+			}
+			l, err := linkInfo(u.Link, false)
+			if err != nil {
+				return err
+			}
+			ev = &monitorJSON{Deleted: u.Header.Type == unix.RTM_DELLINK, Link: l}
+		case u, ok := <-addrs:
+			if !ok {
+				return errSubscription
+			}
+			ev = addrEvent(u)
+		case u, ok := <-routes:
+			if !ok {
+				return errSubscription
+			}
+			r := routeInfo(u.Route)
+			ev = &monitorJSON{Deleted: u.Type == unix.RTM_DELROUTE, Route: &r}
+		case u, ok := <-neighs:
+			if !ok {
+				return errSubscription
+			}
+			n := neighInfo(u.Neigh)
+			ev = &monitorJSON{Deleted: u.Type == unix.RTM_DELNEIGH, Neigh: &n}
+		}
+		if err := printEvent(w, ev); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"syscall"
+
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+// netnsDir is where named namespaces are bind mounted, shared with iproute2
+var netnsDir = "/run/netns"
+
+type netnsJSON struct {
+	Name string `json:"name"`
+}
+
+func netnsList(w io.Writer) error {
+	entries, err := os.ReadDir(netnsDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	names := []netnsJSON{}
+	for _, e := range entries {
+		names = append(names, netnsJSON{Name: e.Name()})
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i].Name < names[j].Name })
+
+	if opts.JSON {
+		return printJSON(w, names)
+	}
+	for _, n := range names {
+		fmt.Fprintln(w, n.Name)
+	}
+	return nil
+}
+
+// netnsAdd creates a named namespace. Creating it moves the calling thread
+// into it, so switch back before anyone else gets to use the thread.
+func netnsAdd(name string) error {
+	runtime.LockOSThread()
+
+	orig, err := netns.Get()
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	defer orig.Close()
+
+	ns, err := netns.NewNamed(name)
+	if err != nil {
+		// NewNamed may have failed after switching namespaces
+		if err := netns.Set(orig); err == nil {
+			runtime.UnlockOSThread()
+		}
+		return fmt.Errorf("netns add %s: %v", name, err)
+	}
+	ns.Close()
+
+	if err := netns.Set(orig); err != nil {
+		// leave the thread locked so the runtime throws it away
+		return err
+	}
+	runtime.UnlockOSThread()
+	return nil
+}
+
+func netnsDel(name string) error {
+	if err := netns.DeleteNamed(name); err != nil {
+		return fmt.Errorf("netns delete %s: %v", name, err)
+	}
+	return nil
+}
+
+// netnsExec runs argv inside the named namespace. Like iproute2 it gets a
+// private mount namespace with /sys remounted for the new network namespace
+// and any files in /etc/netns/NAME bound over their /etc counterparts.
+func netnsExec(name string, argv []string) error {
+	if len(argv) == 0 {
+		return fmt.Errorf("netns exec %s: no command given", name)
+	}
+	path, err := exec.LookPath(argv[0])
+	if err != nil {
+		return err
+	}
+
+	// setns only changes this thread, which is the one that must exec
+	runtime.LockOSThread()
+
+	ns, err := netns.GetFromName(name)
+	if err != nil {
+		return fmt.Errorf("netns %s: %v", name, err)
+	}
+	if err := netns.Set(ns); err != nil {
+		return fmt.Errorf("setting netns %s: %v", name, err)
+	}
+	ns.Close()
+
+	if err := unix.Unshare(unix.CLONE_NEWNS); err != nil {
+		return fmt.Errorf("unshare mount namespace: %v", err)
+	}
+	if err := unix.Mount("", "/", "none", unix.MS_SLAVE|unix.MS_REC, ""); err != nil {
+		return fmt.Errorf("making / a slave mount: %v", err)
+	}
+	if err := unix.Unmount("/sys", unix.MNT_DETACH); err == nil {
+		if err := unix.Mount(name, "/sys", "sysfs", 0, ""); err != nil {
+			Debug("mounting sysfs: %v", err)
+		}
+	}
+
+	etc := filepath.Join("/etc/netns", name)
+	entries, _ := os.ReadDir(etc)
+	for _, e := range entries {
+		src, dst := filepath.Join(etc, e.Name()), filepath.Join("/etc", e.Name())
+		if err := unix.Mount(src, dst, "none", unix.MS_BIND, ""); err != nil {
+			Debug("bind %s -> %s: %v", src, dst, err)
+		}
+	}
+
+	return syscall.Exec(path, argv, os.Environ())
+}
+
+func netnsCmd(w io.Writer) error {
+	if !more() {
+		return netnsList(w)
+	}
+
+	cursor++
+	whatIWant = []string{"list", "add", "delete", "exec"}
+	switch one(arg[cursor], whatIWant) {
+	case "list":
+		return netnsList(w)
+	case "add":
+		return netnsAdd(next("name"))
+	case "delete":
+		return netnsDel(next("name"))
+	case "exec":
+		name := next("name")
+		return netnsExec(name, arg[cursor+1:])
+	}
+	return usage()
+}
@@ -8,51 +8,188 @@ import (
 	"fmt"
 	"io"
 	"math"
-	"net"
 	"strings"
 
 	"github.com/vishvananda/netlink"
 	"golang.org/x/sys/unix"
 )
 
-func showLinks(w io.Writer, withAddresses bool) error {
-	ifaces, err := netlink.LinkList()
+type addrJSON struct {
+	Family            string `json:"family"`
+	Local             string `json:"local"`
+	Prefixlen         int    `json:"prefixlen"`
+	Broadcast         string `json:"broadcast,omitempty"`
+	Scope             string `json:"scope"`
+	Label             string `json:"label,omitempty"`
+	ValidLifeTime     uint32 `json:"valid_life_time"`
+	PreferredLifeTime uint32 `json:"preferred_life_time"`
+}
+
+type rxJSON struct {
+	Bytes      uint64 `json:"bytes"`
+	Packets    uint64 `json:"packets"`
+	Errors     uint64 `json:"errors"`
+	Dropped    uint64 `json:"dropped"`
+	OverErrors uint64 `json:"over_errors"`
+	Multicast  uint64 `json:"multicast"`
+}
+
+type txJSON struct {
+	Bytes         uint64 `json:"bytes"`
+	Packets       uint64 `json:"packets"`
+	Errors        uint64 `json:"errors"`
+	Dropped       uint64 `json:"dropped"`
+	CarrierErrors uint64 `json:"carrier_errors"`
+	Collisions    uint64 `json:"collisions"`
+}
+
+type statsJSON struct {
+	RX rxJSON `json:"rx"`
+	TX txJSON `json:"tx"`
+}
+
+type linkJSON struct {
+	Ifindex   int        `json:"ifindex"`
+	Link      string     `json:"link,omitempty"`
+	Ifname    string     `json:"ifname"`
+	Flags     []string   `json:"flags"`
+	MTU       int        `json:"mtu"`
+	Master    string     `json:"master,omitempty"`
+	Operstate string     `json:"operstate"`
+	Txqlen    int        `json:"txqlen"`
+	LinkType  string     `json:"link_type"`
+	Address   string     `json:"address,omitempty"`
+	InfoKind  string     `json:"info_kind,omitempty"`
+	AddrInfo  []addrJSON `json:"addr_info,omitempty"`
+	Stats64   *statsJSON `json:"stats64,omitempty"`
+}
+
+func linkFlags(l *netlink.LinkAttrs) []string {
+	var flags []string
+	if l.Flags.String() != "0" {
+		flags = strings.Split(strings.ToUpper(l.Flags.String()), "|")
+	}
+	if l.RawFlags&unix.IFF_LOWER_UP != 0 {
+		flags = append(flags, "LOWER_UP")
+	}
+	return flags
+}
+
+// linkName looks up the name of another link by index, falling back to the
+// "if%d" form ip uses when the link lives in another namespace
+func linkName(index int) string {
+	l, err := netlink.LinkByIndex(index)
 	if err != nil {
-		return fmt.Errorf("can't enumerate interfaces: %v", err)
+		return fmt.Sprintf("if%d", index)
 	}
+	return l.Attrs().Name
+}
 
-	for _, v := range ifaces {
-		l := v.Attrs()
-
-		master := ""
-		if l.MasterIndex != 0 {
-			link, err := netlink.LinkByIndex(l.MasterIndex)
-			if err != nil {
-				return fmt.Errorf("can't get link with index %d: %v", l.MasterIndex, err)
-			}
-			master = fmt.Sprintf("master %s ", link.Attrs().Name)
+func linkInfo(link netlink.Link, withAddresses bool) (*linkJSON, error) {
+	l := link.Attrs()
+	j := &linkJSON{
+		Ifindex:   l.Index,
+		Ifname:    l.Name,
+		Flags:     linkFlags(l),
+		MTU:       l.MTU,
+		Operstate: strings.ToUpper(l.OperState.String()),
+		Txqlen:    l.TxQLen,
+		LinkType:  l.EncapType,
+		Address:   l.HardwareAddr.String(),
+	}
+	if link.Type() != "device" {
+		j.InfoKind = link.Type()
+	}
+	if l.ParentIndex != 0 {
+		j.Link = linkName(l.ParentIndex)
+	}
+	if l.MasterIndex != 0 {
+		j.Master = linkName(l.MasterIndex)
+	}
+	if opts.Stats && l.Statistics != nil {
+		s := l.Statistics
+		j.Stats64 = &statsJSON{
+			RX: rxJSON{s.RxBytes, s.RxPackets, s.RxErrors, s.RxDropped, s.RxOverErrors, s.Multicast},
+			TX: txJSON{s.TxBytes, s.TxPackets, s.TxErrors, s.TxDropped, s.TxCarrierErrors, s.Collisions},
+		}
+	}
+	if withAddresses {
+		addrs, err := linkAddresses(link)
+		if err != nil {
+			return nil, err
 		}
-		fmt.Fprintf(w, "%d: %s: <%s> mtu %d %sstate %s\n", l.Index, l.Name,
-			strings.Replace(strings.ToUpper(l.Flags.String()), "|", ",", -1),
-			l.MTU, master, strings.ToUpper(l.OperState.String()))
+		j.AddrInfo = addrs
+	}
+	return j, nil
+}
+
+func showLinks(w io.Writer, link netlink.Link, withAddresses bool) error {
+	ifaces := []netlink.Link{link}
+	if link == nil {
+		var err error
+		ifaces, err = netlink.LinkList()
+		if err != nil {
+			return fmt.Errorf("can't enumerate interfaces: %v", err)
+		}
+	}
 
-		fmt.Fprintf(w, "    link/%s %s\n", l.EncapType, l.HardwareAddr)
+	links := make([]*linkJSON, 0, len(ifaces))
+	for _, v := range ifaces {
+		l, err := linkInfo(v, withAddresses)
+		if err != nil {
+			return err
+		}
+		// -4 and -6 hide links without addresses of that family
+		if withAddresses && (opts.Inet4 || opts.Inet6) && len(l.AddrInfo) == 0 {
+			continue
+		}
+		links = append(links, l)
+	}
+	if opts.JSON {
+		return printJSON(w, links)
+	}
 
+	for _, l := range links {
+		printLink(w, l)
 		if withAddresses {
-			showLinkAddresses(w, v)
+			printLinkAddresses(w, l.AddrInfo)
 		}
 	}
 	return nil
 }
 
-func showLinkAddresses(w io.Writer, link netlink.Link) error {
-	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+func printLink(w io.Writer, l *linkJSON) {
+	name := l.Ifname
+	if l.Link != "" {
+		name += "@" + l.Link
+	}
+	master := ""
+	if l.Master != "" {
+		master = fmt.Sprintf("master %s ", l.Master)
+	}
+	fmt.Fprintf(w, "%d: %s: <%s> mtu %d %sstate %s\n", l.Ifindex, name,
+		strings.Join(l.Flags, ","), l.MTU, master, l.Operstate)
+
+	fmt.Fprintf(w, "    link/%s %s\n", l.LinkType, l.Address)
+
+	if s := l.Stats64; s != nil {
+		fmt.Fprintf(w, "    RX: bytes  packets  errors  dropped overrun mcast\n")
+		fmt.Fprintf(w, "    %-10d %-8d %-7d %-7d %-7d %d\n",
+			s.RX.Bytes, s.RX.Packets, s.RX.Errors, s.RX.Dropped, s.RX.OverErrors, s.RX.Multicast)
+		fmt.Fprintf(w, "    TX: bytes  packets  errors  dropped carrier collsns\n")
+		fmt.Fprintf(w, "    %-10d %-8d %-7d %-7d %-7d %d\n",
+			s.TX.Bytes, s.TX.Packets, s.TX.Errors, s.TX.Dropped, s.TX.CarrierErrors, s.TX.Collisions)
+	}
+}
+
+func linkAddresses(link netlink.Link) ([]addrJSON, error) {
+	addrs, err := netlink.AddrList(link, family(netlink.FAMILY_ALL))
 	if err != nil {
-		return fmt.Errorf("can't enumerate addresses: %v", err)
+		return nil, fmt.Errorf("can't enumerate addresses: %v", err)
 	}
 
+	var ret []addrJSON
 	for _, addr := range addrs {
-
 		var inet string
 		switch len(addr.IPNet.IP) {
 		case 4:
@@ -60,30 +197,43 @@ func showLinkAddresses(w io.Writer, link netlink.Link) error {
 		case 16:
 			inet = "inet6"
 		default:
-			return fmt.Errorf("can't figure out IP protocol version: IP length is %d", len(addr.IPNet.IP))
+			return nil, fmt.Errorf("can't figure out IP protocol version: IP length is %d", len(addr.IPNet.IP))
 		}
 
-		fmt.Fprintf(w, "    %s %s", inet, addr.IP)
-		if addr.Broadcast != nil {
-			fmt.Fprintf(w, " brd %s", addr.Broadcast)
+		a := addrJSON{
+			Family: inet,
+			Local:  addr.IP.String(),
+			Scope:  addrScopes[netlink.Scope(addr.Scope)],
+			Label:  addr.Label,
+			// TODO: fix vishnavanda/netlink. *Lft should be uint32, not int.
+			ValidLifeTime:     uint32(addr.ValidLft),
+			PreferredLifeTime: uint32(addr.PreferedLft),
 		}
-		fmt.Fprintf(w, " scope %s %s\n", addrScopes[netlink.Scope(addr.Scope)], addr.Label)
-
-		var validLft, preferredLft string
-		// TODO: fix vishnavanda/netlink. *Lft should be uint32, not int.
-		if uint32(addr.PreferedLft) == math.MaxUint32 {
-			preferredLft = "forever"
-		} else {
-			preferredLft = fmt.Sprintf("%dsec", addr.PreferedLft)
+		a.Prefixlen, _ = addr.Mask.Size()
+		if addr.Broadcast != nil {
+			a.Broadcast = addr.Broadcast.String()
 		}
-		if uint32(addr.ValidLft) == math.MaxUint32 {
-			validLft = "forever"
-		} else {
-			validLft = fmt.Sprintf("%dsec", addr.ValidLft)
+		ret = append(ret, a)
+	}
+	return ret, nil
+}
+
+func lifetime(t uint32) string {
+	if t == math.MaxUint32 {
+		return "forever"
+	}
+	return fmt.Sprintf("%dsec", t)
+}
+
+func printLinkAddresses(w io.Writer, addrs []addrJSON) {
+	for _, addr := range addrs {
+		fmt.Fprintf(w, "    %s %s/%d", addr.Family, addr.Local, addr.Prefixlen)
+		if addr.Broadcast != "" {
+			fmt.Fprintf(w, " brd %s", addr.Broadcast)
 		}
-		fmt.Fprintf(w, "       valid_lft %s preferred_lft %s\n", validLft, preferredLft)
+		fmt.Fprintf(w, " scope %s %s\n", addr.Scope, addr.Label)
+		fmt.Fprintf(w, "       valid_lft %s preferred_lft %s\n", lifetime(addr.ValidLifeTime), lifetime(addr.PreferredLifeTime))
 	}
-	return nil
 }
 
 var neighStates = map[int]string{
@@ -111,42 +261,65 @@ func getState(state int) string {
 	return strings.Join(ret, ",")
 }
 
-func showNeighbours(w io.Writer, withAddresses bool) error {
-	ifaces, err := net.Interfaces()
+type neighJSON struct {
+	Dst    string   `json:"dst"`
+	Dev    string   `json:"dev"`
+	Lladdr string   `json:"lladdr,omitempty"`
+	Router bool     `json:"router,omitempty"`
+	State  []string `json:"state"`
+}
+
+func neighInfo(v netlink.Neigh) neighJSON {
+	n := neighJSON{
+		Dst:    v.IP.String(),
+		Dev:    linkName(v.LinkIndex),
+		Router: v.Flags&netlink.NTF_ROUTER != 0,
+		State:  strings.Split(getState(v.State), ","),
+	}
+	if v.HardwareAddr != nil {
+		n.Lladdr = v.HardwareAddr.String()
+	}
+	return n
+}
+
+func formatNeigh(n neighJSON) string {
+	entry := fmt.Sprintf("%s dev %s", n.Dst, n.Dev)
+	if n.Lladdr != "" {
+		entry += fmt.Sprintf(" lladdr %s", n.Lladdr)
+	}
+	if n.Router {
+		entry += " router"
+	}
+	return entry + " " + strings.Join(n.State, ",")
+}
+
+// showNeighbours lists the neighbour table, of one link if iface is not nil
+func showNeighbours(w io.Writer, iface netlink.Link) error {
+	index := 0
+	if iface != nil {
+		index = iface.Attrs().Index
+	}
+	neighs, err := netlink.NeighList(index, family(netlink.FAMILY_ALL))
 	if err != nil {
-		return err
+		return fmt.Errorf("can't list neighbours: %v", err)
 	}
-	for _, iface := range ifaces {
-		neighs, err := netlink.NeighList(iface.Index, 0)
-		if err != nil {
-			return fmt.Errorf("can't list neighbours: %v", err)
-		}
 
-		for _, v := range neighs {
-			if v.State&netlink.NUD_NOARP != 0 {
-				continue
-			}
-			entry := fmt.Sprintf("%s dev %s", v.IP.String(), iface.Name)
-			if v.HardwareAddr != nil {
-				entry += fmt.Sprintf(" lladdr %s", v.HardwareAddr)
-			}
-			if v.Flags&netlink.NTF_ROUTER != 0 {
-				entry += " router"
-			}
-			entry += " " + getState(v.State)
-			fmt.Println(entry)
+	entries := []neighJSON{}
+	for _, v := range neighs {
+		if v.State&netlink.NUD_NOARP != 0 {
+			continue
 		}
+		entries = append(entries, neighInfo(v))
+	}
+	if opts.JSON {
+		return printJSON(w, entries)
+	}
+	for _, n := range entries {
+		fmt.Fprintln(w, formatNeigh(n))
 	}
 	return nil
 }
 
-const (
-	defaultFmt   = "default via %v dev %s proto %s metric %d\n"
-	routeFmt     = "%v dev %s proto %s scope %s src %s metric %d\n"
-	route6Fmt    = "%s dev %s proto %s metric %d\n"
-	routeVia6Fmt = "%s via %s dev %s proto %s metric %d\n"
-)
-
 // routing protocol identifier
 // specified in Linux Kernel header: include/uapi/linux/rtnetlink.h
 // See man IP-ROUTE(8) and RTNETLINK(7)
@@ -173,57 +346,3 @@ var rtProto = map[int]string{
 	unix.RTPROT_XORP:     "xorp",
 	unix.RTPROT_ZEBRA:    "zebra",
 }
-
-func showRoutes(w io.Writer, inet6 bool) error {
-	var f int
-	if inet6 {
-		f = netlink.FAMILY_V6
-	} else {
-		f = netlink.FAMILY_V4
-	}
-
-	routes, err := netlink.RouteList(nil, f)
-	if err != nil {
-		return err
-	}
-	for _, route := range routes {
-		link, err := netlink.LinkByIndex(route.LinkIndex)
-		if err != nil {
-			return err
-		}
-		if route.Dst == nil {
-			defaultRoute(w, route, link)
-		} else {
-			showRoute(w, route, link, f)
-		}
-	}
-	return nil
-}
-
-func defaultRoute(w io.Writer, r netlink.Route, l netlink.Link) {
-	gw := r.Gw
-	name := l.Attrs().Name
-	proto := rtProto[int(r.Protocol)]
-	metric := r.Priority
-	fmt.Fprintf(w, defaultFmt, gw, name, proto, metric)
-}
-
-func showRoute(w io.Writer, r netlink.Route, l netlink.Link, f int) {
-	dest := r.Dst
-	name := l.Attrs().Name
-	proto := rtProto[int(r.Protocol)]
-	metric := r.Priority
-	switch f {
-	case netlink.FAMILY_V4:
-		scope := addrScopes[r.Scope]
-		src := r.Src
-		fmt.Fprintf(w, routeFmt, dest, name, proto, scope, src, metric)
-	case netlink.FAMILY_V6:
-		if r.Gw != nil {
-			gw := r.Gw
-			fmt.Fprintf(w, routeVia6Fmt, dest, gw, name, proto, metric)
-		} else {
-			fmt.Fprintf(w, route6Fmt, dest, name, proto, metric)
-		}
-	}
-}
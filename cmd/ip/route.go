@@ -0,0 +1,374 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// the reserved tables from /etc/iproute2/rt_tables
+var rtTables = map[int]string{
+	unix.RT_TABLE_DEFAULT: "default",
+	unix.RT_TABLE_MAIN:    "main",
+	unix.RT_TABLE_LOCAL:   "local",
+}
+
+var rtTypes = map[int]string{
+	unix.RTN_UNICAST:     "unicast",
+	unix.RTN_LOCAL:       "local",
+	unix.RTN_BROADCAST:   "broadcast",
+	unix.RTN_ANYCAST:     "anycast",
+	unix.RTN_MULTICAST:   "multicast",
+	unix.RTN_BLACKHOLE:   "blackhole",
+	unix.RTN_UNREACHABLE: "unreachable",
+	unix.RTN_PROHIBIT:    "prohibit",
+	unix.RTN_THROW:       "throw",
+}
+
+// lookup finds name in one of the tables above, or takes it as a number
+func lookup(names map[int]string, what, name string) (int, error) {
+	for k, v := range names {
+		if v == name {
+			return k, nil
+		}
+	}
+	n, err := strconv.Atoi(name)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q", what, name)
+	}
+	return n, nil
+}
+
+func tableName(t int) string {
+	if name, ok := rtTables[t]; ok {
+		return name
+	}
+	return strconv.Itoa(t)
+}
+
+// parsePrefix accepts "default", a CIDR or a bare address meaning a host route
+func parsePrefix(s string) (*net.IPNet, error) {
+	if s == "default" {
+		if opts.Inet6 {
+			return &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}, nil
+		}
+		return nil, nil
+	}
+	if strings.Contains(s, "/") {
+		_, n, err := net.ParseCIDR(s)
+		return n, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid prefix %q", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+func address(what string) (net.IP, error) {
+	s := next(what)
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid %s %q", what, s)
+	}
+	return ip, nil
+}
+
+// routespec parses
+// [TYPE] PREFIX [via ADDR] [dev DEV] [src ADDR] [table TABLE] [metric N] [proto PROTO] [scope SCOPE]
+func routespec() (*netlink.Route, bool, error) {
+	r := &netlink.Route{}
+	scoped := false
+
+	s := next("default", "CIDR", "route type")
+	if t, err := lookup(rtTypes, "type", s); err == nil && t > 0 {
+		r.Type = t
+		s = next("default", "CIDR")
+	}
+	dst, err := parsePrefix(s)
+	if err != nil {
+		return nil, false, err
+	}
+	r.Dst = dst
+
+	for more() {
+		cursor++
+		whatIWant = []string{"via", "dev", "src", "table", "metric", "proto", "scope"}
+		switch arg[cursor] {
+		case "via":
+			r.Gw, err = address("gateway")
+		case "dev", "oif":
+			var l netlink.Link
+			if l, err = netlink.LinkByName(next("device name")); err == nil {
+				r.LinkIndex = l.Attrs().Index
+			}
+		case "src":
+			r.Src, err = address("source")
+		case "table":
+			r.Table, err = lookup(rtTables, "table", next("table"))
+		case "metric", "priority", "preference":
+			r.Priority, err = number("metric")
+		case "proto", "protocol":
+			var p int
+			if p, err = lookup(rtProto, "protocol", next("protocol")); err == nil {
+				r.Protocol = netlink.RouteProtocol(p)
+			}
+		case "scope":
+			var sc int
+			if sc, err = lookup(scopeNames(), "scope", next("scope")); err == nil {
+				r.Scope = netlink.Scope(sc)
+				scoped = true
+			}
+		default:
+			return nil, false, usage()
+		}
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	return r, scoped, nil
+}
+
+func scopeNames() map[int]string {
+	m := make(map[int]string, len(addrScopes))
+	for k, v := range addrScopes {
+		m[int(k)] = v
+	}
+	return m
+}
+
+func routeadd(replace bool) error {
+	r, scoped, err := routespec()
+	if err != nil {
+		return err
+	}
+	// as ip does, directly connected routes are link scope and local ones host
+	if !scoped {
+		switch {
+		case r.Type == unix.RTN_LOCAL:
+			r.Scope = netlink.SCOPE_HOST
+		case r.Gw == nil && (r.Type == 0 || r.Type == unix.RTN_UNICAST):
+			r.Scope = netlink.SCOPE_LINK
+		}
+	}
+	if replace {
+		err = netlink.RouteReplace(r)
+	} else {
+		err = netlink.RouteAdd(r)
+	}
+	if err != nil {
+		return fmt.Errorf("error adding route %s: %v", formatRoute(routeInfo(*r)), err)
+	}
+	return nil
+}
+
+func routedel() error {
+	r, scoped, err := routespec()
+	if err != nil {
+		return err
+	}
+	// matches any scope
+	if !scoped {
+		r.Scope = netlink.SCOPE_NOWHERE
+	}
+	if err := netlink.RouteDel(r); err != nil {
+		return fmt.Errorf("error deleting route %s: %v", formatRoute(routeInfo(*r)), err)
+	}
+	return nil
+}
+
+type routeJSON struct {
+	Type     string `json:"type,omitempty"`
+	Dst      string `json:"dst"`
+	Gateway  string `json:"gateway,omitempty"`
+	Dev      string `json:"dev,omitempty"`
+	Table    string `json:"table,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	Prefsrc  string `json:"prefsrc,omitempty"`
+	Metric   int    `json:"metric,omitempty"`
+}
+
+func routeInfo(r netlink.Route) routeJSON {
+	j := routeJSON{Dst: "default", Metric: r.Priority}
+	if r.Type != 0 && r.Type != unix.RTN_UNICAST {
+		j.Type = rtTypes[r.Type]
+	}
+	if r.Dst != nil {
+		if ones, bits := r.Dst.Mask.Size(); ones == bits {
+			j.Dst = r.Dst.IP.String()
+		} else if ones != 0 {
+			j.Dst = r.Dst.String()
+		}
+	}
+	if r.Gw != nil {
+		j.Gateway = r.Gw.String()
+	}
+	if r.LinkIndex != 0 {
+		j.Dev = linkName(r.LinkIndex)
+	}
+	if r.Table != 0 && r.Table != unix.RT_TABLE_MAIN {
+		j.Table = tableName(r.Table)
+	}
+	// routes added without a protocol are "boot" and ip leaves it out
+	if r.Protocol != unix.RTPROT_BOOT && r.Protocol != unix.RTPROT_UNSPEC {
+		j.Protocol = rtProto[int(r.Protocol)]
+		if j.Protocol == "" {
+			j.Protocol = strconv.Itoa(int(r.Protocol))
+		}
+	}
+	if r.Scope != netlink.SCOPE_UNIVERSE {
+		j.Scope = addrScopes[r.Scope]
+	}
+	if r.Src != nil {
+		j.Prefsrc = r.Src.String()
+	}
+	return j
+}
+
+func formatRoute(r routeJSON) string {
+	var b strings.Builder
+	if r.Type != "" {
+		b.WriteString(r.Type + " ")
+	}
+	b.WriteString(r.Dst)
+	for _, kv := range [][2]string{
+		{"via", r.Gateway},
+		{"dev", r.Dev},
+		{"table", r.Table},
+		{"proto", r.Protocol},
+		{"scope", r.Scope},
+		{"src", r.Prefsrc},
+	} {
+		if kv[1] != "" {
+			fmt.Fprintf(&b, " %s %s", kv[0], kv[1])
+		}
+	}
+	if r.Metric != 0 {
+		fmt.Fprintf(&b, " metric %d", r.Metric)
+	}
+	return b.String()
+}
+
+func printRoutes(w io.Writer, routes []netlink.Route) error {
+	entries := make([]routeJSON, 0, len(routes))
+	for _, r := range routes {
+		entries = append(entries, routeInfo(r))
+	}
+	if opts.JSON {
+		return printJSON(w, entries)
+	}
+	for _, r := range entries {
+		fmt.Fprintln(w, formatRoute(r))
+	}
+	return nil
+}
+
+// routeshow lists routes: [table TABLE|all] [dev DEV] [proto PROTO] [type TYPE]
+func routeshow(w io.Writer) error {
+	filter := &netlink.Route{Table: unix.RT_TABLE_MAIN}
+	mask := netlink.RT_FILTER_TABLE
+
+	for more() {
+		var err error
+		cursor++
+		whatIWant = []string{"table", "dev", "proto", "type"}
+		switch arg[cursor] {
+		case "table":
+			if s := next("table", "all"); s == "all" {
+				filter.Table = unix.RT_TABLE_UNSPEC
+			} else {
+				filter.Table, err = lookup(rtTables, "table", s)
+			}
+		case "dev", "oif":
+			var l netlink.Link
+			if l, err = netlink.LinkByName(next("device name")); err == nil {
+				filter.LinkIndex = l.Attrs().Index
+				mask |= netlink.RT_FILTER_OIF
+			}
+		case "proto", "protocol":
+			var p int
+			if p, err = lookup(rtProto, "protocol", next("protocol")); err == nil {
+				filter.Protocol = netlink.RouteProtocol(p)
+				mask |= netlink.RT_FILTER_PROTOCOL
+			}
+		case "type":
+			if filter.Type, err = lookup(rtTypes, "type", next("type")); err == nil {
+				mask |= netlink.RT_FILTER_TYPE
+			}
+		default:
+			return usage()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	routes, err := netlink.RouteListFiltered(family(netlink.FAMILY_V4), filter, mask)
+	if err != nil {
+		return err
+	}
+	return printRoutes(w, routes)
+}
+
+// routeget asks the kernel which route it would use: ADDR [from ADDR] [iif DEV] [oif DEV]
+func routeget(w io.Writer) error {
+	dst, err := address("destination")
+	if err != nil {
+		return err
+	}
+	options := &netlink.RouteGetOptions{}
+	for more() {
+		cursor++
+		whatIWant = []string{"from", "iif", "oif", "dev"}
+		switch arg[cursor] {
+		case "from":
+			options.SrcAddr, err = address("source")
+		case "iif":
+			options.Iif = next("device name")
+		case "oif", "dev":
+			options.Oif = next("device name")
+		default:
+			return usage()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	routes, err := netlink.RouteGetWithOptions(dst, options)
+	if err != nil {
+		return fmt.Errorf("route get %v: %v", dst, err)
+	}
+	return printRoutes(w, routes)
+}
+
+func route(w io.Writer) error {
+	if !more() {
+		return routeshow(w)
+	}
+
+	cursor++
+	whatIWant = []string{"show", "list", "add", "delete", "replace", "get"}
+	switch one(arg[cursor], whatIWant) {
+	case "add":
+		return routeadd(false)
+	case "replace":
+		return routeadd(true)
+	case "delete":
+		return routedel()
+	case "show", "list":
+		return routeshow(w)
+	case "get":
+		return routeget(w)
+	}
+	return usage()
+}
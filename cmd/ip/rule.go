@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+)
+
+type ruleJSON struct {
+	Priority int    `json:"priority"`
+	Not      bool   `json:"not,omitempty"`
+	Src      string `json:"src"`
+	Srclen   int    `json:"srclen,omitempty"`
+	Dst      string `json:"dst,omitempty"`
+	Dstlen   int    `json:"dstlen,omitempty"`
+	Fwmark   string `json:"fwmark,omitempty"`
+	Iif      string `json:"iif,omitempty"`
+	Oif      string `json:"oif,omitempty"`
+	Table    string `json:"table"`
+}
+
+func ruleInfo(r netlink.Rule) ruleJSON {
+	j := ruleJSON{
+		Priority: r.Priority,
+		Not:      r.Invert,
+		Src:      "all",
+		Iif:      r.IifName,
+		Oif:      r.OifName,
+		Table:    tableName(r.Table),
+	}
+	// the kernel leaves out a zero priority
+	if j.Priority < 0 {
+		j.Priority = 0
+	}
+	if r.Src != nil {
+		j.Src = r.Src.IP.String()
+		j.Srclen, _ = r.Src.Mask.Size()
+	}
+	if r.Dst != nil {
+		j.Dst = r.Dst.IP.String()
+		j.Dstlen, _ = r.Dst.Mask.Size()
+	}
+	if r.Mark != -1 {
+		j.Fwmark = fmt.Sprintf("%#x", uint32(r.Mark))
+		if r.Mask != -1 && uint32(r.Mask) != 0xffffffff {
+			j.Fwmark += fmt.Sprintf("/%#x", uint32(r.Mask))
+		}
+	}
+	return j
+}
+
+func prefix(ip string, length int) string {
+	if length == 0 || length == 32 && !strings.Contains(ip, ":") || length == 128 {
+		return ip
+	}
+	return fmt.Sprintf("%s/%d", ip, length)
+}
+
+// formatRule prints a rule the way "ip rule" does:
+// 32766:	from all lookup main
+func formatRule(r ruleJSON) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d:\t", r.Priority)
+	if r.Not {
+		b.WriteString("not ")
+	}
+	b.WriteString("from " + prefix(r.Src, r.Srclen))
+	if r.Dst != "" {
+		b.WriteString(" to " + prefix(r.Dst, r.Dstlen))
+	}
+	if r.Fwmark != "" {
+		b.WriteString(" fwmark " + r.Fwmark)
+	}
+	if r.Iif != "" {
+		b.WriteString(" iif " + r.Iif)
+	}
+	if r.Oif != "" {
+		b.WriteString(" oif " + r.Oif)
+	}
+	b.WriteString(" lookup " + r.Table)
+	return b.String()
+}
+
+func ruleshow(w io.Writer) error {
+	rules, err := netlink.RuleList(family(netlink.FAMILY_V4))
+	if err != nil {
+		return fmt.Errorf("can't list rules: %v", err)
+	}
+
+	entries := make([]ruleJSON, 0, len(rules))
+	for _, r := range rules {
+		entries = append(entries, ruleInfo(r))
+	}
+	if opts.JSON {
+		return printJSON(w, entries)
+	}
+	for _, r := range entries {
+		fmt.Fprintln(w, formatRule(r))
+	}
+	return nil
+}
+
+// rulespec parses
+// [not] [from PREFIX] [to PREFIX] [fwmark MARK[/MASK]] [iif DEV] [oif DEV] [pref N] [table TABLE]
+func rulespec() (*netlink.Rule, error) {
+	r := netlink.NewRule()
+	r.Family = family(netlink.FAMILY_V4)
+
+	for more() {
+		var err error
+		cursor++
+		whatIWant = []string{"not", "from", "to", "fwmark", "iif", "oif", "pref", "table"}
+		switch arg[cursor] {
+		case "not":
+			r.Invert = true
+		case "from":
+			if s := next("prefix"); s != "all" {
+				r.Src, err = parsePrefix(s)
+			}
+		case "to":
+			if s := next("prefix"); s != "all" {
+				r.Dst, err = parsePrefix(s)
+			}
+		case "fwmark":
+			mark, mask, masked := strings.Cut(next("mark"), "/")
+			var n uint64
+			if n, err = strconv.ParseUint(mark, 0, 32); err != nil {
+				break
+			}
+			r.Mark = int(n)
+			if masked {
+				if n, err = strconv.ParseUint(mask, 0, 32); err == nil {
+					r.Mask = int(n)
+				}
+			}
+		case "iif", "dev":
+			r.IifName = next("device name")
+		case "oif":
+			r.OifName = next("device name")
+		case "pref", "priority", "preference":
+			r.Priority, err = number("priority")
+		case "table", "lookup":
+			r.Table, err = lookup(rtTables, "table", next("table"))
+		default:
+			return nil, usage()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if r.Src != nil && r.Src.IP.To4() == nil || r.Dst != nil && r.Dst.IP.To4() == nil {
+		r.Family = netlink.FAMILY_V6
+	}
+	return r, nil
+}
+
+func rule(w io.Writer) error {
+	if !more() {
+		return ruleshow(w)
+	}
+
+	cursor++
+	whatIWant = []string{"show", "list", "add", "delete"}
+	switch one(arg[cursor], whatIWant) {
+	case "show", "list":
+		return ruleshow(w)
+	case "add":
+		r, err := rulespec()
+		if err != nil {
+			return err
+		}
+		if r.Table == 0 {
+			return fmt.Errorf("rule add: a table is required")
+		}
+		if err := netlink.RuleAdd(r); err != nil {
+			return fmt.Errorf("adding rule: %v", err)
+		}
+		return nil
+	case "delete":
+		r, err := rulespec()
+		if err != nil {
+			return err
+		}
+		if err := netlink.RuleDel(r); err != nil {
+			return fmt.Errorf("deleting rule: %v", err)
+		}
+		return nil
+	}
+	return usage()
+}
@@ -1,89 +1,397 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"os"
 	"strconv"
+	"strings"
 	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
 )
 
-var defaultSignal = "-SIGTERM"
+var defaultSignal = "SIGTERM"
 
-func kill(sig os.Signal, pids ...string) []error {
-	var errs []error
-	s := sig.(syscall.Signal)
-	for _, p := range pids {
-		pid, err := strconv.Atoi(p)
-		if err != nil {
-			errs = append(errs, fmt.Errorf("%v: args must be process or job ID", p))
+// exit codes as used by util-linux kill
+const (
+	exitFailure = 1
+	exitPartial = 64
+)
+
+// si_code for signals sent by sigqueue(3)
+const siQueue = -1
+
+type escalation struct {
+	after time.Duration
+	sig   syscall.Signal
+}
+
+type config struct {
+	sig      syscall.Signal
+	queue    bool
+	value    int
+	timeouts []escalation
+	verbose  bool
+	pidsOnly bool
+	targets  []string
+}
+
+// errNoTargets is returned by parseArgs when there is nothing to signal
+var errNoTargets = errors.New("not enough arguments")
+
+func usage(w io.Writer) {
+	fmt.Fprint(w, `Usage:
+ kill [options] <pid>|<name>...
+ kill -l [<signal>|<exit status>...]
+ kill -L
+
+Forcibly terminate a process.
+
+Options:
+ -<signal>, -s, --signal <signal>
+                        specify the <signal> to be sent
+ -q, --queue <value>    use sigqueue(2), not kill(2), and pass <value> as data
+ --timeout <ms> <signal>
+                        wait up to <ms> milliseconds and then send <signal>,
+                        may be given more than once to build a chain
+ -p, --pid              print pids without signaling them
+ --verbose              print pids that will be signaled
+ -l, --list [=<signal>] list signal names, or convert a signal number to a name
+ -L, --table            list signal names and numbers
+
+A negative <pid> signals a process group, use "--" before it,
+"kill -- -1" signals every process we are allowed to.
+`)
+}
+
+// signame returns the name of sig without the SIG prefix
+func signame(sig syscall.Signal) (string, bool) {
+	for name, s := range signums {
+		if s == sig && strings.HasPrefix(name, "SIG") {
+			return strings.TrimPrefix(name, "SIG"), true
+		}
+	}
+	return "", false
+}
+
+// parseSignal accepts "9", "KILL", "SIGKILL", "kill" and "RTMIN+3"
+func parseSignal(s string) (syscall.Signal, error) {
+	name := strings.ToUpper(s)
+	if _, err := strconv.Atoi(name); err != nil && !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+
+	sig, ok := signums[name]
+	if !ok {
+		return 0, fmt.Errorf("%v: unknown signal", s)
+	}
+	return sig.(syscall.Signal), nil
+}
+
+// list converts each argument between numbers and names, with no arguments
+// every signal name is printed
+func list(w io.Writer, args []string) error {
+	if len(args) == 0 {
+		var names []string
+		for _, n := range signames {
+			names = append(names, strings.TrimPrefix(n, "SIG"))
+		}
+		fmt.Fprintln(w, strings.Join(names, " "))
+		return nil
+	}
+
+	for _, arg := range args {
+		if n, err := strconv.Atoi(arg); err == nil {
+			// like the shell, an exit status above 128 means death by signal
+			if n > 128 {
+				n -= 128
+			}
+			name, ok := signame(syscall.Signal(n))
+			if !ok {
+				return fmt.Errorf("unknown signal: %v", arg)
+			}
+			fmt.Fprintln(w, name)
 			continue
 		}
-		if err := syscall.Kill(pid, s); err != nil {
-			errs = append(errs, err)
+
+		sig, err := parseSignal(arg)
+		if err != nil {
+			return err
 		}
+		fmt.Fprintln(w, int(sig))
 	}
-	return errs
+	return nil
 }
 
-func list() {
-	siglist()
+// parseArgs reads the options by hand, a flag parser would take negative
+// PIDs for options. It returns a nil config when the command is done, the
+// help and signal lists being written to w.
+func parseArgs(w io.Writer, args []string) (*config, error) {
+	sig, _ := parseSignal(defaultSignal)
+	c := &config{sig: sig}
+
+	next := func(i *int, opt string) (string, error) {
+		*i++
+		if *i >= len(args) {
+			return "", fmt.Errorf("option %v requires an argument", opt)
+		}
+		return args[*i], nil
+	}
+
+	var err error
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			c.targets = append(c.targets, args[i+1:]...)
+			break
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			c.targets = append(c.targets, args[i:]...)
+			break
+		}
+
+		switch {
+		case arg == "-h" || arg == "--help":
+			usage(w)
+			return nil, nil
+		case arg == "-l" || arg == "--list":
+			return nil, list(w, args[i+1:])
+		case strings.HasPrefix(arg, "--list="):
+			return nil, list(w, []string{strings.TrimPrefix(arg, "--list=")})
+		case arg == "-L" || arg == "--table":
+			fmt.Fprint(w, siglist())
+			return nil, nil
+		case arg == "-s" || arg == "--signal":
+			v, err := next(&i, arg)
+			if err != nil {
+				return nil, err
+			}
+			if c.sig, err = parseSignal(v); err != nil {
+				return nil, err
+			}
+		case arg == "-q" || arg == "--queue":
+			v, err := next(&i, arg)
+			if err != nil {
+				return nil, err
+			}
+			if c.value, err = strconv.Atoi(v); err != nil {
+				return nil, fmt.Errorf("invalid sigqueue value: %v", v)
+			}
+			c.queue = true
+		case arg == "--timeout":
+			ms, err := next(&i, arg)
+			if err != nil {
+				return nil, err
+			}
+			v, err := next(&i, arg)
+			if err != nil {
+				return nil, err
+			}
+			var e escalation
+			n, err := strconv.Atoi(ms)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid timeout: %v", ms)
+			}
+			e.after = time.Duration(n) * time.Millisecond
+			if e.sig, err = parseSignal(v); err != nil {
+				return nil, err
+			}
+			c.timeouts = append(c.timeouts, e)
+		case arg == "-p" || arg == "--pid":
+			c.pidsOnly = true
+		case arg == "--verbose":
+			c.verbose = true
+		default:
+			// -9, -KILL, -SIGKILL
+			if c.sig, err = parseSignal(arg[1:]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(c.targets) == 0 {
+		return nil, errNoTargets
+	}
+	return c, nil
 }
 
-func usage() {
+// pidsByName finds every process whose name matches, skipping ourselves
+func pidsByName(name string) ([]int, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
 
+	self := os.Getpid()
+	var pids []int
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil || pid == self {
+			continue
+		}
+		comm, err := os.ReadFile("/proc/" + e.Name() + "/comm")
+		if err != nil {
+			continue
+		}
+		if strings.TrimSpace(string(comm)) == name {
+			pids = append(pids, pid)
+		}
+	}
+
+	if len(pids) == 0 {
+		return nil, fmt.Errorf("cannot find process %q", name)
+	}
+	return pids, nil
 }
 
-func KillProcess(args []string) error {
-	if len(args) < 1 {
-		usage()
-		return nil
+// sigqueue sends sig with an integer payload through rt_sigqueueinfo(2)
+func sigqueue(pid int, sig syscall.Signal, value int) error {
+	// siginfo_t is 128 bytes, the _rt member of the union starts at the
+	// first pointer aligned offset after si_signo, si_errno and si_code
+	var info [128]byte
+	ptr := int(unsafe.Sizeof(uintptr(0)))
+	off := (12 + ptr - 1) &^ (ptr - 1)
+
+	*(*int32)(unsafe.Pointer(&info[0])) = int32(sig)
+	*(*int32)(unsafe.Pointer(&info[8])) = siQueue
+	*(*int32)(unsafe.Pointer(&info[off])) = int32(os.Getpid())
+	*(*uint32)(unsafe.Pointer(&info[off+4])) = uint32(os.Getuid())
+	// sival_int, the first member of union sigval
+	*(*int32)(unsafe.Pointer(&info[off+ptr])) = int32(value)
+
+	_, _, errno := unix.Syscall(unix.SYS_RT_SIGQUEUEINFO, uintptr(pid), uintptr(sig), uintptr(unsafe.Pointer(&info[0])))
+	if errno != 0 {
+		return errno
 	}
-	op := args[0]
-	pids := args[1:]
-	if op[0] != '-' {
-		op = defaultSignal
-		pids = args[0:]
+	return nil
+}
+
+// escalate sends each signal of the chain once its timeout passes and the
+// process is still alive. A pidfd keeps us from signaling a reused PID.
+func escalate(pid int, chain []escalation) error {
+	fd, err := unix.PidfdOpen(pid, 0)
+	if err != nil {
+		return fmt.Errorf("pidfd_open: %w", err)
 	}
+	defer unix.Close(fd)
 
-	if op[0:2] == "-l" {
-		if len(args) > 2 {
-			usage()
+	for _, e := range chain {
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+		n, err := unix.Poll(fds, int(e.after.Milliseconds()))
+		if err != nil && err != unix.EINTR {
+			return err
+		}
+		if n > 0 {
+			// the process exited
 			return nil
 		}
-		fmt.Printf("%s\n", siglist())
+		if err := unix.PidfdSendSignal(fd, unix.Signal(e.sig), nil, 0); err != nil {
+			if err == unix.ESRCH {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// signal delivers the configured signal to a single PID, process group or -1
+func (c *config) signal(pid int) error {
+	if c.verbose || c.pidsOnly {
+		fmt.Println(pid)
+	}
+	if c.pidsOnly {
 		return nil
 	}
 
-	if op == "-s" || op == "--signal" {
-		if len(args) < 2 {
-			usage()
-			return nil
+	var err error
+	if c.queue {
+		if pid <= 0 {
+			return fmt.Errorf("sigqueue cannot signal process groups")
 		}
-		op = args[1]
-		pids = args[2:]
+		err = sigqueue(pid, c.sig, c.value)
 	} else {
-		op = op[1:]
+		err = syscall.Kill(pid, c.sig)
+	}
+	if err != nil {
+		return err
 	}
 
-	s, ok := signums[op]
-	if !ok {
-		return fmt.Errorf("%v: is not a valid signature", op)
+	if len(c.timeouts) > 0 {
+		if pid <= 0 {
+			return fmt.Errorf("--timeout cannot follow process groups")
+		}
+		return escalate(pid, c.timeouts)
 	}
+	return nil
+}
 
-	if len(pids) < 1 {
-		usage()
-		return nil
+// KillProcess signals every target and returns one error per failed target
+// along with the number of targets that were signaled successfully
+func KillProcess(c *config) (int, error) {
+	var errs []error
+	ok := 0
+	for _, t := range c.targets {
+		var pids []int
+		if pid, err := strconv.Atoi(t); err == nil {
+			pids = []int{pid}
+		} else {
+			pids, err = pidsByName(t)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+
+		failed := false
+		for _, pid := range pids {
+			if err := c.signal(pid); err != nil {
+				errs = append(errs, fmt.Errorf("(%d) - %w", pid, err))
+				failed = true
+			}
+		}
+		if !failed {
+			ok++
+		}
 	}
-	if err := kill(s, pids...); err != nil {
-		return fmt.Errorf("some processes couldnt be killed: %v", err) // fix this
+	return ok, errors.Join(errs...)
+}
+
+// exitStatus is 0 when every target was signaled, exitPartial when only
+// some were and exitFailure when none were
+func exitStatus(ok int, err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case ok > 0:
+		return exitPartial
+	default:
+		return exitFailure
 	}
-	return nil
 }
 
 func main() {
-	args := os.Args[1:]
-	if err := KillProcess(args); err != nil {
-		log.Fatal(err)
+	c, err := parseArgs(os.Stdout, os.Args[1:])
+	if errors.Is(err, errNoTargets) {
+		usage(os.Stderr)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kill: %v\n", err)
+		os.Exit(exitFailure)
+	}
+	if c == nil {
+		return
+	}
+
+	ok, err := KillProcess(c)
+	if err != nil {
+		for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+			fmt.Fprintf(os.Stderr, "kill: %v\n", e)
+		}
 	}
+	os.Exit(exitStatus(ok, err))
 }
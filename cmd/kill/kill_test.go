@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseArgs(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		args []string
+		want *config
+		out  string
+		err  string
+	}{
+		{
+			name: "default signal",
+			args: []string{"123"},
+			want: &config{sig: syscall.SIGTERM, targets: []string{"123"}},
+		},
+		{
+			name: "-s name",
+			args: []string{"-s", "KILL", "1", "2"},
+			want: &config{sig: syscall.SIGKILL, targets: []string{"1", "2"}},
+		},
+		{
+			name: "--signal number",
+			args: []string{"--signal", "1", "1"},
+			want: &config{sig: syscall.SIGHUP, targets: []string{"1"}},
+		},
+		{
+			name: "-SIG",
+			args: []string{"-SIGUSR1", "bash"},
+			want: &config{sig: syscall.SIGUSR1, targets: []string{"bash"}},
+		},
+		{
+			name: "-name lower case",
+			args: []string{"-int", "1"},
+			want: &config{sig: syscall.SIGINT, targets: []string{"1"}},
+		},
+		{
+			name: "-number",
+			args: []string{"-9", "1"},
+			want: &config{sig: syscall.SIGKILL, targets: []string{"1"}},
+		},
+		{
+			name: "real time",
+			args: []string{"-RTMIN+3", "1"},
+			want: &config{sig: syscall.Signal(37), targets: []string{"1"}},
+		},
+		{
+			name: "process group",
+			args: []string{"-TERM", "--", "-42", "7"},
+			want: &config{sig: syscall.SIGTERM, targets: []string{"-42", "7"}},
+		},
+		{
+			name: "queue",
+			args: []string{"-q", "5", "-s", "USR2", "1"},
+			want: &config{sig: syscall.SIGUSR2, queue: true, value: 5, targets: []string{"1"}},
+		},
+		{
+			name: "timeout chain",
+			args: []string{"--timeout", "100", "TERM", "--timeout", "1000", "KILL", "-HUP", "1"},
+			want: &config{sig: syscall.SIGHUP, targets: []string{"1"}, timeouts: []escalation{
+				{100 * time.Millisecond, syscall.SIGTERM},
+				{time.Second, syscall.SIGKILL},
+			}},
+		},
+		{
+			name: "pids and verbose",
+			args: []string{"-p", "--verbose", "sleep"},
+			want: &config{sig: syscall.SIGTERM, pidsOnly: true, verbose: true, targets: []string{"sleep"}},
+		},
+		{
+			name: "list",
+			args: []string{"-l", "9", "137", "HUP"},
+			out:  "KILL\nKILL\n1\n",
+		},
+		{
+			name: "list one",
+			args: []string{"--list=15"},
+			out:  "TERM\n",
+		},
+		{
+			name: "list all",
+			args: []string{"-l"},
+			out:  "HUP INT QUIT ILL TRAP ABRT BUS FPE KILL USR1 SEGV USR2 PIPE ALRM TERM ",
+		},
+		{
+			name: "table",
+			args: []string{"-L"},
+			out:  " 1 HUP        2 INT        3 QUIT       4 ILL        5 TRAP       6 ABRT       7 BUS\n",
+		},
+		{
+			name: "help",
+			args: []string{"--help"},
+			out:  "Usage:\n",
+		},
+		{
+			name: "no targets",
+			args: []string{"-9"},
+			err:  errNoTargets.Error(),
+		},
+		{
+			name: "unknown signal",
+			args: []string{"-s", "FOO", "1"},
+			err:  "FOO: unknown signal",
+		},
+		{
+			name: "missing argument",
+			args: []string{"-s"},
+			err:  "option -s requires an argument",
+		},
+		{
+			name: "bad queue value",
+			args: []string{"-q", "x", "1"},
+			err:  "invalid sigqueue value",
+		},
+		{
+			name: "bad timeout",
+			args: []string{"--timeout", "-5", "KILL", "1"},
+			err:  "invalid timeout",
+		},
+		{
+			name: "list unknown",
+			args: []string{"-l", "200"},
+			err:  "unknown signal: 200",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			c, err := parseArgs(&out, tt.args)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("parseArgs(%q) = %v, want an error with %q", tt.args, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(c, tt.want) {
+				t.Errorf("parseArgs(%q) = %+v, want %+v", tt.args, c, tt.want)
+			}
+			if !strings.HasPrefix(out.String(), tt.out) {
+				t.Errorf("parseArgs(%q) printed %q, want it to start with %q", tt.args, out.String(), tt.out)
+			}
+		})
+	}
+}
+
+func TestSignals(t *testing.T) {
+	for i, name := range signames {
+		sig, ok := signums[name]
+		if !ok {
+			t.Errorf("%s has no number", name)
+			continue
+		}
+		if got, ok := signums[sig.(syscall.Signal).String()]; ok && got != sig {
+			t.Errorf("%s is %v, and its name is %v", name, sig, got)
+		}
+		// signals are listed by number, 32 and 33 being taken by glibc
+		if i > 0 && signums[signames[i-1]].(syscall.Signal) >= sig.(syscall.Signal) {
+			t.Errorf("%s listed after %s", name, signames[i-1])
+		}
+	}
+
+	for _, tt := range []struct {
+		in   string
+		want syscall.Signal
+		name string
+	}{
+		{"9", syscall.SIGKILL, "KILL"},
+		{"kill", syscall.SIGKILL, "KILL"},
+		{"SIGTERM", syscall.SIGTERM, "TERM"},
+		{"chld", syscall.SIGCHLD, "CHLD"},
+		{"rtmin", syscall.Signal(34), "RTMIN"},
+		{"RTMAX-1", syscall.Signal(63), "RTMAX-1"},
+		{"64", syscall.Signal(64), "RTMAX"},
+	} {
+		sig, err := parseSignal(tt.in)
+		if err != nil || sig != tt.want {
+			t.Errorf("parseSignal(%q) = %v, %v, want %v", tt.in, sig, err, tt.want)
+			continue
+		}
+		if name, ok := signame(sig); !ok || name != tt.name {
+			t.Errorf("signame(%v) = %q, %v, want %q", sig, name, ok, tt.name)
+		}
+	}
+	for _, in := range []string{"", "0", "33", "65", "SIGFOO", "RTMIN+16"} {
+		if sig, err := parseSignal(in); err == nil {
+			t.Errorf("parseSignal(%q) = %v, want an error", in, sig)
+		}
+	}
+}
+
+func TestKillProcess(t *testing.T) {
+	cmd := exec.Command("sleep", "30")
+	if err := cmd.Start(); err != nil {
+		t.Skip(err)
+	}
+	defer cmd.Process.Kill()
+
+	c, err := parseArgs(&bytes.Buffer{}, []string{"-KILL", "no-such-process-name", strconv.Itoa(cmd.Process.Pid)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := KillProcess(c)
+	if ok != 1 || err == nil {
+		t.Fatalf("KillProcess() = %d, %v, want 1 target signaled and an error", ok, err)
+	}
+	if got := exitStatus(ok, err); got != exitPartial {
+		t.Errorf("exitStatus(%d, %v) = %d, want %d", ok, err, got, exitPartial)
+	}
+	if err := cmd.Wait(); err == nil || !strings.Contains(err.Error(), "killed") {
+		t.Errorf("sleep exited with %v, want it killed", err)
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	failed := errors.New("failed")
+	for _, tt := range []struct {
+		ok   int
+		err  error
+		want int
+	}{
+		{2, nil, 0},
+		{1, failed, exitPartial},
+		{0, failed, exitFailure},
+	} {
+		if got := exitStatus(tt.ok, tt.err); got != tt.want {
+			t.Errorf("exitStatus(%d, %v) = %d, want %d", tt.ok, tt.err, got, tt.want)
+		}
+	}
+}
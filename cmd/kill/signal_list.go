@@ -4,6 +4,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 	"syscall"
 )
 
@@ -200,9 +201,16 @@ var (
 	}
 )
 
+// siglist formats every signal with its number, seven to a line like kill -L
 func siglist() (s string) {
-	for i, sig := range signames {
-		s = s + fmt.Sprintf("%d: %v\n", i, sig)
+	line := ""
+	for i, name := range signames {
+		sig := signums[name].(syscall.Signal)
+		line = line + fmt.Sprintf("%2d %-9s ", int(sig), strings.TrimPrefix(name, "SIG"))
+		if (i+1)%7 == 0 || i == len(signames)-1 {
+			s = s + strings.TrimRight(line, " ") + "\n"
+			line = ""
+		}
 	}
 	return
 }
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// AuditEntry is a single line of the audit log
+type AuditEntry struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	Rule   string    `json:"rule,omitempty"`
+	*AuditProcess
+	Signal string `json:"signal,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// AuditProcess is the process an entry is about. Its ids are always
+// written, 0 being root, and the whole of it is left out of the entries of
+// the daemon itself.
+type AuditProcess struct {
+	Pid     int    `json:"pid"`
+	PPid    int    `json:"ppid"`
+	Uid     int    `json:"uid"`
+	Exe     string `json:"exe,omitempty"`
+	Cmdline string `json:"cmdline,omitempty"`
+}
+
+// AuditLog writes one JSON object per line, it is safe for concurrent use
+type AuditLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func NewAuditLog(w io.Writer) *AuditLog {
+	return &AuditLog{enc: json.NewEncoder(w)}
+}
+
+// Log records an action taken on a process, info and rule may be nil
+func (a *AuditLog) Log(action string, info *ProcInfo, rule *Rule, sig string, err error) {
+	e := AuditEntry{
+		Time:   time.Now(),
+		Action: action,
+		Signal: sig,
+	}
+	if info != nil {
+		e.AuditProcess = &AuditProcess{
+			Pid:     info.Pid,
+			PPid:    info.PPid,
+			Uid:     info.Uid,
+			Exe:     info.Exe,
+			Cmdline: info.Cmdline,
+		}
+	}
+	if rule != nil {
+		e.Rule = rule.Source
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.enc.Encode(e)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// constants from linux/connector.h and linux/cn_proc.h
+const (
+	cnIdxProc = 0x1
+	cnValProc = 0x1
+
+	procCnMcastListen = 1
+	procCnMcastIgnore = 2
+
+	procEventFork = 0x00000001
+	procEventExec = 0x00000002
+	procEventComm = 0x00000200
+
+	// struct cn_msg without the trailing data
+	cnMsgLen = 20
+	// what, cpu and timestamp_ns of struct proc_event
+	procEventHeaderLen = 16
+)
+
+// ProcConnector receives process events from the kernel over the netlink
+// proc connector. It requires CAP_NET_ADMIN.
+type ProcConnector struct {
+	fd int
+}
+
+// NewProcConnector opens a connector socket and subscribes to process events
+func NewProcConnector() (*ProcConnector, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, unix.NETLINK_CONNECTOR)
+	if err != nil {
+		return nil, fmt.Errorf("netlink socket: %w", err)
+	}
+
+	sa := &unix.SockaddrNetlink{
+		Family: unix.AF_NETLINK,
+		Groups: cnIdxProc,
+		Pid:    uint32(os.Getpid()),
+	}
+	if err := unix.Bind(fd, sa); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("netlink bind: %w", err)
+	}
+
+	pc := &ProcConnector{fd: fd}
+	if err := pc.send(procCnMcastListen); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("proc connector subscribe: %w", err)
+	}
+	return pc, nil
+}
+
+// send writes a proc_cn_mcast_op message to the kernel
+func (pc *ProcConnector) send(op uint32) error {
+	buf := make([]byte, unix.NLMSG_HDRLEN+cnMsgLen+4)
+	ne := binary.NativeEndian
+
+	// struct nlmsghdr
+	ne.PutUint32(buf[0:], uint32(len(buf)))
+	ne.PutUint16(buf[4:], unix.NLMSG_DONE)
+	ne.PutUint16(buf[6:], 0)
+	ne.PutUint32(buf[8:], 0)
+	ne.PutUint32(buf[12:], uint32(os.Getpid()))
+
+	// struct cn_msg
+	msg := buf[unix.NLMSG_HDRLEN:]
+	ne.PutUint32(msg[0:], cnIdxProc)
+	ne.PutUint32(msg[4:], cnValProc)
+	ne.PutUint16(msg[16:], 4)
+	ne.PutUint32(msg[cnMsgLen:], op)
+
+	return unix.Sendto(pc.fd, buf, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK})
+}
+
+// Events blocks reading from the socket and sends the PID of every process
+// that forks, execs or renames itself on the returned channel. The channel is
+// closed when the connector is closed or reading fails.
+func (pc *ProcConnector) Events() <-chan int {
+	ch := make(chan int, 64)
+	go func() {
+		defer close(ch)
+		buf := make([]byte, os.Getpagesize())
+		for {
+			n, _, err := unix.Recvfrom(pc.fd, buf, 0)
+			if err == unix.EINTR || err == unix.ENOBUFS {
+				// ENOBUFS means we fell behind and the kernel dropped events
+				continue
+			}
+			if err != nil || n == 0 {
+				return
+			}
+
+			msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			for _, m := range msgs {
+				if pid, ok := parseProcEvent(m.Data); ok {
+					ch <- pid
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+// parseProcEvent decodes a cn_msg carrying a struct proc_event and returns
+// the PID for exec and comm events, and that of the child for fork events
+func parseProcEvent(data []byte) (int, bool) {
+	if len(data) < cnMsgLen+procEventHeaderLen+8 {
+		return 0, false
+	}
+
+	ne := binary.NativeEndian
+	if ne.Uint32(data[0:]) != cnIdxProc || ne.Uint32(data[4:]) != cnValProc {
+		return 0, false
+	}
+
+	ev := data[cnMsgLen:]
+	what := ne.Uint32(ev[0:])
+	body := ev[procEventHeaderLen:]
+
+	switch what {
+	case procEventFork:
+		// parent_pid, parent_tgid, child_pid, child_tgid. A new thread
+		// is not a new process.
+		if len(body) < 16 || ne.Uint32(body[8:]) != ne.Uint32(body[12:]) {
+			return 0, false
+		}
+		return int(ne.Uint32(body[12:])), true
+	case procEventExec, procEventComm:
+		// process_pid, process_tgid
+		return int(ne.Uint32(body[4:])), true
+	}
+	return 0, false
+}
+
+// Close unsubscribes from process events and closes the socket
+func (pc *ProcConnector) Close() error {
+	pc.send(procCnMcastIgnore)
+	return unix.Close(pc.fd)
+}
@@ -5,119 +5,275 @@ import (
 	"log"
 	"os"
 	"os/signal"
-	"strings"
+	"regexp"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/jessevdk/go-flags"
-)
-
-var (
-	File  string
-	Regex bool
-	Cmd   bool
+	"golang.org/x/sys/unix"
 )
 
 var usage = `Usage:
-		
+	killer_daemon [OPTIONS] [NAME...]
+
+Rules file format, one rule per line, every condition given has to match:
+	steam
+	name=kitty cmdline="start-as=fullscreen" signal=TERM grace=2s
+	cmdline="miner" user=nobody parent=bash signal=INT grace=500ms
+
 Examples:
-	killer -c "start-as=fullscreen"
+	killer_daemon -c "start-as=fullscreen"
+	killer_daemon -f /etc/killer.rules -a /var/log/killer.log
 	`
 
 var opts struct {
-	File    string `short:"f" long:"file" description:"path to a file with a list of process names to block"`
-	Regex   bool   `short:"r" long:"regex" description:"match processes using a matching substring"`
-	Command bool   `short:"c" long:"command" description:"match command arguments used for a process"`
+	File     string        `short:"f" long:"file" description:"path to a rules file, re-read on SIGHUP"`
+	Regex    bool          `short:"r" long:"regex" description:"treat NAME arguments as regular expressions"`
+	Command  bool          `short:"c" long:"command" description:"match NAME arguments against the command line of a process"`
+	Signal   string        `short:"s" long:"signal" default:"KILL" description:"signal to send for NAME arguments"`
+	Grace    time.Duration `short:"g" long:"grace" description:"time to wait after the signal before sending SIGKILL"`
+	Audit    string        `short:"a" long:"audit" description:"append the JSON audit log to this file instead of stdout"`
+	Poll     bool          `short:"p" long:"poll" description:"scan the process table instead of using the proc connector"`
+	Interval time.Duration `short:"i" long:"interval" default:"1s" description:"scan interval when polling"`
 }
 
-// get list of all processes
-func getProcs() ([]Process, error) {
-	p, err := processes()
-	if err != nil {
-		return nil, err
+// Daemon matches processes against a set of rules and kills them
+type Daemon struct {
+	mu    sync.RWMutex
+	rules []*Rule
+	audit *AuditLog
+
+	// PIDs that must never be touched, ourselves and our parent
+	protected map[int]bool
+
+	// processes that are currently being handled, keyed by pid and start time
+	pendingMu sync.Mutex
+	pending   map[[2]int]bool
+}
+
+func NewDaemon(rules []*Rule, audit *AuditLog) *Daemon {
+	return &Daemon{
+		rules:     rules,
+		audit:     audit,
+		protected: map[int]bool{os.Getpid(): true, os.Getppid(): true},
+		pending:   make(map[[2]int]bool),
 	}
-	return p, nil
 }
 
-// iterate process list for matching block list items
-func ProcessPid(arg string, p []Process) ([]Process, error) {
-	var matches []Process
-	for _, proc := range p {
-		if opts.Regex {
-			if strings.Contains(proc.Executable(), arg) {
-				fmt.Printf("Found match: %v of [PID] %v\n", proc.Executable(), proc.Pid())
-				matches = append(matches, proc)
-			}
-		}
+// SetRules atomically replaces the rule set
+func (d *Daemon) SetRules(rules []*Rule) {
+	d.mu.Lock()
+	d.rules = rules
+	d.mu.Unlock()
+}
 
-		if opts.Command {
-			cmdargs, err := Cmdline(proc.Pid())
-			if err != nil {
-				continue
-			}
+// Rules returns the current rule set
+func (d *Daemon) Rules() []*Rule {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.rules
+}
 
-			parent := os.Getppid()
-			if strings.Contains(cmdargs, arg) && proc.Pid() != parent && proc.PPid() != parent {
-				// fmt.Println(cmdargs, arg, self, parent)
-				fmt.Printf("Found substring  match: %v of [PID] %v\n", proc.Executable(), proc.Pid())
-				matches = append(matches, proc)
-			}
-		}
+// Check looks up pid and enforces the first matching rule. It returns the
+// matched rule, or nil if the process is allowed or already gone.
+func (d *Daemon) Check(pid int) *Rule {
+	if d.protected[pid] || pid <= 1 {
+		return nil
+	}
+
+	info, err := procInfo(pid)
+	if err != nil {
+		// the process exited before we could look at it
+		return nil
+	}
 
-		if !opts.Command && !opts.Regex {
-			if proc.Executable() == arg {
-				fmt.Printf("Found match: %v of [PID] %v\n", proc.Executable(), proc.Pid())
-				matches = append(matches, proc)
+	for _, r := range d.Rules() {
+		if r.Match(info) {
+			key := [2]int{info.Pid, info.StartTime}
+			d.pendingMu.Lock()
+			busy := d.pending[key]
+			d.pending[key] = true
+			d.pendingMu.Unlock()
+
+			if !busy {
+				go func() {
+					d.Enforce(info, r)
+					d.pendingMu.Lock()
+					delete(d.pending, key)
+					d.pendingMu.Unlock()
+				}()
 			}
+			return r
 		}
 	}
-	return matches, nil
+	return nil
 }
 
-// iterate over list of matching procs and send to kill
-func KillMatches(procs []Process) {
-	for _, proc := range procs {
-		err := kill(os.Kill, proc.Pid())
-		if err != nil {
-			log.Printf("unable to kill process: %v", err)
-		} else {
-			log.Printf("Killed process: %v of [PID] %v", proc.Executable(), proc.Pid())
+// alive reports whether the process in info still exists, a reused PID
+// is detected by comparing the start time
+func alive(info *ProcInfo) bool {
+	start, err := StartTime(info.Pid)
+	if err != nil || start != info.StartTime {
+		return false
+	}
+
+	p, err := newUnixProcess(info.Pid)
+	if err != nil {
+		return false
+	}
+	// zombies are dead already, they only wait for their parent to reap them
+	return p.State() != 'Z' && p.State() != 'X'
+}
+
+// Enforce sends the rules signal and escalates to SIGKILL once the grace
+// period runs out. It blocks until the process is gone or killed.
+func (d *Daemon) Enforce(info *ProcInfo, r *Rule) {
+	d.audit.Log("match", info, r, "", nil)
+
+	err := syscall.Kill(info.Pid, r.Signal)
+	d.audit.Log("signal", info, r, unix.SignalName(r.Signal), err)
+	if err != nil || r.Signal == syscall.SIGKILL {
+		return
+	}
+
+	deadline := time.Now().Add(r.Grace)
+	for time.Now().Before(deadline) {
+		if !alive(info) {
+			d.audit.Log("exited", info, r, "", nil)
+			return
 		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if !alive(info) {
+		d.audit.Log("exited", info, r, "", nil)
+		return
 	}
+
+	err = syscall.Kill(info.Pid, syscall.SIGKILL)
+	d.audit.Log("escalate", info, r, unix.SignalName(syscall.SIGKILL), err)
 }
 
-// kill PID with Signal
-func kill(sig os.Signal, pid int) error {
-	s := sig.(syscall.Signal)
-	if err := syscall.Kill(pid, s); err != nil {
+// Scan checks every process currently running
+func (d *Daemon) Scan() error {
+	procs, err := processes()
+	if err != nil {
 		return err
 	}
+	for _, p := range procs {
+		d.Check(p.Pid())
+	}
 	return nil
 }
 
-// scan processes - send to be processed - send to be killed
-func WatchDog(args []string) error {
-	p, _ := getProcs()
+// Watch checks every process running and every process that forks or
+// execs from now on. It falls back to polling if the proc connector is
+// unavailable.
+func (d *Daemon) Watch() error {
+	var events <-chan int
+	if !opts.Poll {
+		pc, err := NewProcConnector()
+		if err == nil {
+			defer pc.Close()
+			events = pc.Events()
+		} else {
+			log.Printf("%v, falling back to polling", err)
+		}
+	}
+
+	// subscribed first, a process that starts while we scan is in the
+	// events too
+	if err := d.Scan(); err != nil {
+		return err
+	}
+	d.audit.Log("start", nil, nil, "", nil)
+
+	if events != nil {
+		for pid := range events {
+			d.Check(pid)
+		}
+		return fmt.Errorf("proc connector closed")
+	}
+	for {
+		time.Sleep(opts.Interval)
+		if err := d.Scan(); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// argRules turns the NAME arguments into rules according to the flags
+func argRules(args []string) ([]*Rule, error) {
+	sig, err := parseSignal(opts.Signal)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []*Rule
 	for _, arg := range args {
-		matches, err := ProcessPid(arg, p)
+		r := newRule()
+		r.Source = arg
+		r.Signal = sig
+		r.Grace = opts.Grace
+
+		switch {
+		case opts.Command:
+			expr := arg
+			if !opts.Regex {
+				expr = regexp.QuoteMeta(arg)
+			}
+			r.Cmdline, err = regexp.Compile(expr)
+		case opts.Regex:
+			r.NameRe, err = regexp.Compile(arg)
+		default:
+			r.Name = arg
+		}
 		if err != nil {
-			log.Println(err)
+			return nil, err
 		}
-		go KillMatches(matches)
+		rules = append(rules, r)
 	}
-	return nil
+	return rules, nil
+}
+
+// loadAll reads the rules file, if any, and appends the argument rules
+func loadAll(args []string) ([]*Rule, error) {
+	rules, err := argRules(args)
+	if err != nil {
+		return nil, err
+	}
+	if opts.File == "" {
+		return rules, nil
+	}
+
+	fileRules, err := LoadRules(opts.File)
+	if err != nil {
+		return nil, err
+	}
+	return append(fileRules, rules...), nil
 }
 
-// capture signals that kill the main program
-func handleKillSignal() {
+// capture signals that kill the main program and reload the rules on SIGHUP
+func handleSignals(d *Daemon, args []string) {
 	signalChan := make(chan os.Signal, 1)
 	// sigkill apparently cannot be caught
-	signal.Notify(signalChan, syscall.SIGTERM, syscall.SIGINT)
+	signal.Notify(signalChan, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
 	go func() {
-		for {
-			<-signalChan
-			println("bye bye!")
-			os.Exit(0)
+		for sig := range signalChan {
+			if sig != syscall.SIGHUP {
+				d.audit.Log("stop", nil, nil, unix.SignalName(sig.(syscall.Signal)), nil)
+				os.Exit(0)
+			}
+
+			rules, err := loadAll(args)
+			if err != nil {
+				// keep the old rules around if the new file is broken
+				d.audit.Log("reload", nil, nil, "", err)
+				continue
+			}
+			d.SetRules(rules)
+			d.audit.Log("reload", nil, nil, "", nil)
 		}
 	}()
 }
@@ -131,14 +287,29 @@ func main() {
 		log.Fatal(err)
 	}
 
-	// handle signals and exit
-	handleKillSignal()
+	if len(args) == 0 && opts.File == "" {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(1)
+	}
 
-	// loop in the background while looking for procs to kill
-	for {
-		time.Sleep(1 * time.Second)
-		if err := WatchDog(args); err != nil {
-			log.Println(err)
+	rules, err := loadAll(args)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	out := os.Stdout
+	if opts.Audit != "" {
+		out, err = os.OpenFile(opts.Audit, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+		if err != nil {
+			log.Fatal(err)
 		}
+		defer out.Close()
+	}
+
+	d := NewDaemon(rules, NewAuditLog(out))
+	handleSignals(d, args)
+
+	if err := d.Watch(); err != nil {
+		log.Fatal(err)
 	}
 }
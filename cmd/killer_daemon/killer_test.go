@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseRule(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		line    string
+		wantErr bool
+		check   func(r *Rule) bool
+	}{
+		{
+			name:  "bare name",
+			line:  "steam",
+			check: func(r *Rule) bool { return r.Name == "steam" && r.Signal == syscall.SIGKILL },
+		},
+		{
+			name: "all keys",
+			line: `name=kitty cmdline="start-as=full screen" user=0 parent=1 signal=TERM grace=2s`,
+			check: func(r *Rule) bool {
+				return r.Name == "kitty" && r.Cmdline.String() == "start-as=full screen" &&
+					r.Uid == 0 && r.Parent == "1" && r.Signal == syscall.SIGTERM && r.Grace == 2*time.Second
+			},
+		},
+		{
+			name:  "numeric signal",
+			line:  "name=foo signal=15",
+			check: func(r *Rule) bool { return r.Signal == syscall.SIGTERM },
+		},
+		{name: "bad signal", line: "name=foo signal=NOPE", wantErr: true},
+		{name: "bad regex", line: "cmdline=(", wantErr: true},
+		{name: "unknown key", line: "colour=red", wantErr: true},
+		{name: "unterminated quote", line: `cmdline="abc`, wantErr: true},
+		{name: "no conditions", line: "signal=TERM", wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := ParseRule(tt.line)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseRule(%q) = nil error, want error", tt.line)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseRule(%q) = %v", tt.line, err)
+			}
+			if !tt.check(r) {
+				t.Errorf("ParseRule(%q) = %+v, unexpected fields", tt.line, r)
+			}
+		})
+	}
+}
+
+func TestReadRules(t *testing.T) {
+	rules, err := ReadRules(strings.NewReader("# comment\n\nsteam\nname=firefox signal=INT\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("got %d rules, want 2", len(rules))
+	}
+
+	if _, err := ReadRules(strings.NewReader("steam\nsignal=\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("ReadRules error = %v, want error on line 2", err)
+	}
+}
+
+// spawn starts a dummy child and waits until it has exec'd the final binary
+func spawn(t *testing.T, script string) *exec.Cmd {
+	t.Helper()
+	cmd := exec.Command("/bin/sh", "-c", script)
+	if err := cmd.Start(); err != nil {
+		t.Skipf("unable to start child: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	for i := 0; i < 100; i++ {
+		if info, err := procInfo(cmd.Process.Pid); err == nil && info.Exe == "sleep" {
+			return cmd
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("child never exec'd sleep")
+	return nil
+}
+
+func waitSignal(t *testing.T, cmd *exec.Cmd) syscall.Signal {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("child was not killed")
+	}
+
+	ws := cmd.ProcessState.Sys().(syscall.WaitStatus)
+	if !ws.Signaled() {
+		t.Fatalf("child exited with %v, want a signal", ws.ExitStatus())
+	}
+	return ws.Signal()
+}
+
+func TestDaemonSignal(t *testing.T) {
+	cmd := spawn(t, "exec sleep 30")
+
+	r, err := ParseRule("name=sleep cmdline=\"^sleep 30$\" signal=TERM grace=1s")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var audit bytes.Buffer
+	d := NewDaemon([]*Rule{r}, NewAuditLog(&audit))
+	if got := d.Check(cmd.Process.Pid); got != r {
+		t.Fatalf("Check(%d) = %v, want the rule", cmd.Process.Pid, got)
+	}
+
+	if sig := waitSignal(t, cmd); sig != syscall.SIGTERM {
+		t.Errorf("child got %v, want SIGTERM", sig)
+	}
+}
+
+func TestDaemonEscalate(t *testing.T) {
+	// ignored signals stay ignored across exec
+	cmd := spawn(t, "trap '' TERM; exec sleep 31")
+
+	r, err := ParseRule("cmdline=\"^sleep 31$\" signal=TERM grace=200ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var audit bytes.Buffer
+	d := NewDaemon([]*Rule{r}, NewAuditLog(&audit))
+	info, err := procInfo(cmd.Process.Pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Enforce reaps nothing, run it alongside Wait
+	enforced := make(chan struct{})
+	go func() {
+		d.Enforce(info, r)
+		close(enforced)
+	}()
+	if sig := waitSignal(t, cmd); sig != syscall.SIGKILL {
+		t.Errorf("child got %v, want SIGKILL", sig)
+	}
+	<-enforced
+
+	if !strings.Contains(audit.String(), `"action":"escalate"`) {
+		t.Errorf("audit log has no escalate entry:\n%s", audit.String())
+	}
+}
+
+func TestDaemonNoMatch(t *testing.T) {
+	cmd := spawn(t, "exec sleep 32")
+
+	r, err := ParseRule("name=sleep parent=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDaemon([]*Rule{r}, NewAuditLog(&bytes.Buffer{}))
+	if got := d.Check(cmd.Process.Pid); got != nil {
+		t.Errorf("Check(%d) = %v, want no match", cmd.Process.Pid, got)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	var out bytes.Buffer
+	a := NewAuditLog(&out)
+	a.Log("signal", &ProcInfo{Pid: 42, PPid: 0, Uid: 0, Exe: "sh"}, nil, "SIGTERM", nil)
+	a.Log("start", nil, nil, "", nil)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("audit log has %d lines, want 2:\n%s", len(lines), out.String())
+	}
+	for _, want := range []string{`"pid":42`, `"ppid":0`, `"uid":0`, `"exe":"sh"`} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("signal entry %s has no %s", lines[0], want)
+		}
+	}
+	if strings.Contains(lines[1], `"pid"`) || strings.Contains(lines[1], `"uid"`) {
+		t.Errorf("start entry %s has process fields", lines[1])
+	}
+}
+
+// procEvent makes the cn_msg of a proc_event what with body
+func procEvent(what uint32, body ...uint32) []byte {
+	ne := binary.NativeEndian
+	b := make([]byte, cnMsgLen+procEventHeaderLen+4*len(body))
+	ne.PutUint32(b[0:], cnIdxProc)
+	ne.PutUint32(b[4:], cnValProc)
+	ne.PutUint32(b[cnMsgLen:], what)
+	for i, v := range body {
+		ne.PutUint32(b[cnMsgLen+procEventHeaderLen+4*i:], v)
+	}
+	return b
+}
+
+func TestParseProcEvent(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		data []byte
+		pid  int
+		ok   bool
+	}{
+		{"exec", procEvent(procEventExec, 42, 42), 42, true},
+		{"comm", procEvent(procEventComm, 43, 42, 0, 0, 0, 0), 42, true},
+		{"fork", procEvent(procEventFork, 1, 1, 50, 50), 50, true},
+		{"thread", procEvent(procEventFork, 1, 1, 51, 1), 0, false},
+		{"exit", procEvent(0x80000000, 42, 42, 0, 0), 0, false},
+		{"short", procEvent(procEventExec), 0, false},
+	} {
+		pid, ok := parseProcEvent(tt.data)
+		if pid != tt.pid || ok != tt.ok {
+			t.Errorf("%s: parseProcEvent = %d, %v, want %d, %v", tt.name, pid, ok, tt.pid, tt.ok)
+		}
+	}
+}
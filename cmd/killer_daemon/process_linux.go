@@ -42,8 +42,14 @@ func StartTime(pid int) (int, error) {
 			return 0, err
 		}
 
-		statData := strings.Split(string(procStat), " ")
-		startTime, err := strconv.Atoi(statData[21])
+		// skip past the executable name, it may contain spaces
+		data := string(procStat)
+		statData := strings.Fields(data[strings.LastIndexByte(data, ')')+1:])
+		if len(statData) < 20 {
+			return 0, fmt.Errorf("%d: short stat line", pid)
+		}
+		// field 22 counted from the pid, the first field here is field 3
+		startTime, err := strconv.Atoi(statData[19])
 		if err != nil {
 			return 0, err
 		}
@@ -59,8 +65,69 @@ func Cmdline(pid int) (string, error) {
 		if err != nil {
 			return "", err
 		}
+		procStat = bytes.TrimRight(procStat, "\x00")
 		procStat = bytes.ReplaceAll(procStat, []byte("\x00"), []byte(" "))
 		return string(procStat), nil
 	}
 	return "", nil
 }
+
+// Uid returns the real user ID that owns the process
+func Uid(pid int) (int, error) {
+	status, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/status")
+	if err != nil {
+		return -1, err
+	}
+
+	for _, line := range strings.Split(string(status), "\n") {
+		if !strings.HasPrefix(line, "Uid:") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			break
+		}
+		return strconv.Atoi(fields[1])
+	}
+	return -1, fmt.Errorf("%d: no Uid in status", pid)
+}
+
+// ProcInfo is a snapshot of everything a Rule can match on
+type ProcInfo struct {
+	Pid       int
+	PPid      int
+	Uid       int
+	Exe       string
+	ParentExe string
+	Cmdline   string
+	StartTime int
+}
+
+// procInfo collects a ProcInfo for pid, the process may have exited in the meantime
+func procInfo(pid int) (*ProcInfo, error) {
+	p, err := newUnixProcess(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ProcInfo{
+		Pid:  pid,
+		PPid: p.PPid(),
+		Exe:  p.Executable(),
+	}
+
+	if info.Uid, err = Uid(pid); err != nil {
+		return nil, err
+	}
+	if info.Cmdline, err = Cmdline(pid); err != nil {
+		return nil, err
+	}
+	if info.StartTime, err = StartTime(pid); err != nil {
+		return nil, err
+	}
+
+	if parent, err := newUnixProcess(info.PPid); err == nil {
+		info.ParentExe = parent.Executable()
+	}
+	return info, nil
+}
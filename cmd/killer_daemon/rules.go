@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Rule describes a class of processes to block and how to get rid of them.
+// Every condition that is set has to match for the rule to apply.
+type Rule struct {
+	// line the rule came from, used in the audit log
+	Source string
+	// executable name, exact match unless NameRe is set
+	Name   string
+	NameRe *regexp.Regexp
+	// regex matched against the space separated command line
+	Cmdline *regexp.Regexp
+	// owner of the process, -1 means any user
+	Uid int
+	// parent executable name or parent PID
+	Parent string
+	// signal sent first and how long to wait before escalating to SIGKILL
+	Signal syscall.Signal
+	Grace  time.Duration
+}
+
+func newRule() *Rule {
+	return &Rule{Uid: -1, Signal: syscall.SIGKILL}
+}
+
+// Match reports whether the process info satisfies every condition of the rule
+func (r *Rule) Match(info *ProcInfo) bool {
+	switch {
+	case r.NameRe != nil:
+		if !r.NameRe.MatchString(info.Exe) {
+			return false
+		}
+	case r.Name != "":
+		if info.Exe != r.Name {
+			return false
+		}
+	}
+
+	if r.Cmdline != nil && !r.Cmdline.MatchString(info.Cmdline) {
+		return false
+	}
+
+	if r.Uid >= 0 && info.Uid != r.Uid {
+		return false
+	}
+
+	if r.Parent != "" {
+		if ppid, err := strconv.Atoi(r.Parent); err == nil {
+			if info.PPid != ppid {
+				return false
+			}
+		} else if info.ParentExe != r.Parent {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseSignal accepts "SIGTERM", "TERM" or a signal number
+func parseSignal(s string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n <= 0 || n > 64 {
+			return 0, fmt.Errorf("%v: invalid signal number", s)
+		}
+		return syscall.Signal(n), nil
+	}
+
+	name := strings.ToUpper(s)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+
+	sig := unix.SignalNum(name)
+	if sig == 0 {
+		return 0, fmt.Errorf("%v: unknown signal", s)
+	}
+	return sig, nil
+}
+
+// parseUser resolves a user name or a numeric UID
+func parseUser(s string) (int, error) {
+	if uid, err := strconv.Atoi(s); err == nil {
+		return uid, nil
+	}
+
+	u, err := user.Lookup(s)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(u.Uid)
+}
+
+// splitFields splits a rule line on whitespace, keeping double quoted values together
+func splitFields(line string) ([]string, error) {
+	var fields []string
+	var cur strings.Builder
+	inQuote := false
+	escaped := false
+	started := false
+
+	for _, c := range line {
+		switch {
+		case escaped:
+			cur.WriteRune(c)
+			escaped = false
+		case c == '\\' && inQuote:
+			escaped = true
+		case c == '"':
+			inQuote = !inQuote
+			started = true
+		case !inQuote && (c == ' ' || c == '\t'):
+			if started {
+				fields = append(fields, cur.String())
+				cur.Reset()
+				started = false
+			}
+		default:
+			cur.WriteRune(c)
+			started = true
+		}
+	}
+
+	if inQuote {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if started {
+		fields = append(fields, cur.String())
+	}
+	return fields, nil
+}
+
+// ParseRule parses a single line of the rules file. A line is a list of
+// key=value pairs, a bare word is shorthand for name=word:
+//
+//	steam
+//	name=kitty cmdline="start-as=fullscreen" signal=TERM grace=2s
+//	cmdline="^python3? .*miner" user=nobody parent=bash signal=INT grace=500ms
+func ParseRule(line string) (*Rule, error) {
+	fields, err := splitFields(line)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty rule")
+	}
+
+	r := newRule()
+	r.Source = strings.TrimSpace(line)
+	for _, f := range fields {
+		key, val, ok := strings.Cut(f, "=")
+		if !ok {
+			key, val = "name", f
+		}
+
+		switch key {
+		case "name":
+			r.Name = val
+		case "name~", "regex":
+			r.NameRe, err = regexp.Compile(val)
+		case "cmdline", "cmd":
+			r.Cmdline, err = regexp.Compile(val)
+		case "user", "uid":
+			r.Uid, err = parseUser(val)
+		case "parent", "ppid":
+			r.Parent = val
+		case "signal", "sig":
+			r.Signal, err = parseSignal(val)
+		case "grace":
+			r.Grace, err = time.ParseDuration(val)
+		default:
+			err = fmt.Errorf("unknown key %q", key)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", f, err)
+		}
+	}
+
+	if r.Name == "" && r.NameRe == nil && r.Cmdline == nil && r.Uid < 0 && r.Parent == "" {
+		return nil, fmt.Errorf("rule has no conditions")
+	}
+	return r, nil
+}
+
+// ReadRules parses a rules file, blank lines and lines starting with '#' are skipped
+func ReadRules(rd io.Reader) ([]*Rule, error) {
+	var rules []*Rule
+	scanner := bufio.NewScanner(rd)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		r, err := ParseRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineno, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, scanner.Err()
+}
+
+// LoadRules reads the rules file at path
+func LoadRules(path string) ([]*Rule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rules, err := ReadRules(f)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	return rules, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"mybox/pkg/shadow"
+)
+
+// Failed logins are tallied per user like pam_faillock does: deny of them
+// within failInterval lock the account until unlockTime after the last.
+// root is never locked out.
+const (
+	deny         = 3
+	failInterval = 15 * time.Minute
+	unlockTime   = 10 * time.Minute
+	// failDelay is the wait after the first failure, it grows with each
+	// one after that up to maxDelay
+	failDelay = 2 * time.Second
+	maxDelay  = 30 * time.Second
+)
+
+// faillockDir has a file per user with the times of their failed logins
+var faillockDir = "/var/run/faillock"
+
+// errLogin is all a failed login gets told, whatever went wrong
+var errLogin = errors.New("Login incorrect")
+
+// lockedError is returned for users locked out by their failures
+type lockedError struct {
+	failures int
+	left     time.Duration
+}
+
+func (e *lockedError) Error() string {
+	return fmt.Sprintf("The account is locked due to %d failed logins (%d minutes left to unlock)", e.failures, int(e.left.Minutes())+1)
+}
+
+// tally is a user's recent failed logins
+type tally struct {
+	path  string
+	times []time.Time
+}
+
+// readTally reads name's failures within failInterval of now
+func readTally(name string, now time.Time) *tally {
+	t := &tally{path: filepath.Join(faillockDir, name)}
+	b, err := os.ReadFile(t.path)
+	if err != nil {
+		return t
+	}
+	for _, l := range strings.Fields(string(b)) {
+		sec, err := strconv.ParseInt(l, 10, 64)
+		if err != nil {
+			continue
+		}
+		if at := time.Unix(sec, 0); now.Sub(at) < failInterval {
+			t.times = append(t.times, at)
+		}
+	}
+	return t
+}
+
+// locked tells how much longer the failures lock the account for
+func (t *tally) locked(now time.Time) (time.Duration, bool) {
+	if len(t.times) < deny {
+		return 0, false
+	}
+	left := t.times[len(t.times)-1].Add(unlockTime).Sub(now)
+	return left, left > 0
+}
+
+// fail records a failure at now
+func (t *tally) fail(now time.Time) error {
+	t.times = append(t.times, now)
+	var b strings.Builder
+	for _, at := range t.times {
+		fmt.Fprintln(&b, at.Unix())
+	}
+	if err := os.MkdirAll(faillockDir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(t.path, []byte(b.String()), 0o600)
+}
+
+// reset forgets the failures after a successful login
+func (t *tally) reset() error {
+	if err := os.Remove(t.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// delay is how long to wait after the failures before asking again
+func (t *tally) delay() time.Duration {
+	d := failDelay
+	for i := 1; i < len(t.times) && d < maxDelay; i++ {
+		d *= 2
+	}
+	return min(d, maxDelay)
+}
+
+// authenticate checks name's password at now, unless force is set, and
+// whether their account may log in. It returns their passwd and shadow
+// entries.
+func authenticate(name, password string, force bool, now time.Time) (*shadow.User, *shadow.Entry, error) {
+	u, err := shadow.LookupUser(name)
+	if err != nil {
+		return nil, nil, errLogin
+	}
+	e, err := shadow.Lookup(name)
+	if err != nil {
+		return nil, nil, errLogin
+	}
+
+	if !force {
+		t := readTally(name, now)
+		if left, ok := t.locked(now); ok && u.UID != 0 {
+			return nil, nil, &lockedError{len(t.times), left}
+		}
+		if err := e.Verify(password); err != nil {
+			if err := t.fail(now); err != nil {
+				fmt.Fprintf(os.Stderr, "login: recording failure: %v\n", err)
+			}
+			return nil, nil, errLogin
+		}
+		t.reset()
+	}
+
+	if err := e.Check(now); err != nil {
+		return nil, nil, err
+	}
+	return u, e, nil
+}
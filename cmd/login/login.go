@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"mybox/pkg/shadow"
+	"mybox/pkg/termios"
+
+	"github.com/jessevdk/go-flags"
+)
+
+var opts struct {
+	Preserve bool   `short:"p" long:"preserve-env" description:"keep the environment"`
+	Force    bool   `short:"f" long:"force" description:"skip authentication, the user is already logged in"`
+	Host     string `short:"h" long:"host" description:"name of the remote host for utmp"`
+}
+
+// retries is how many times we ask before giving up
+const retries = 3
+
+// readLine reads a line from the terminal without its end
+func readLine(r *bufio.Reader) (string, error) {
+	l, err := r.ReadString('\n')
+	if err != nil && l == "" {
+		return "", err
+	}
+	return strings.TrimRight(l, "\r\n"), nil
+}
+
+// password asks for a password with echo off
+func password(r *bufio.Reader) (string, error) {
+	tty := termios.New(os.Stdin)
+	if old, err := tty.NoEcho(); err == nil {
+		defer tty.Set(old)
+	}
+	fmt.Print("Password: ")
+	return readLine(r)
+}
+
+// terminal is the line we're logging in on, without /dev/
+func terminal() string {
+	name, err := os.Readlink("/proc/self/fd/0")
+	if err != nil || !strings.HasPrefix(name, "/dev/") {
+		return "?"
+	}
+	return strings.TrimPrefix(name, "/dev/")
+}
+
+func login(name string) (int, error) {
+	if os.Geteuid() != 0 {
+		return 1, errors.New("cannot possibly work without effective root")
+	}
+	if opts.Force && os.Getuid() != 0 {
+		return 1, errors.New("-f is for root only")
+	}
+	host, _ := os.Hostname()
+	r := bufio.NewReader(os.Stdin)
+
+	for try := 0; ; try++ {
+		for name == "" {
+			fmt.Printf("%s login: ", host)
+			l, err := readLine(r)
+			if err != nil {
+				return 1, err
+			}
+			name = strings.TrimSpace(l)
+		}
+
+		var pw string
+		if !opts.Force {
+			var err error
+			if pw, err = password(r); err != nil {
+				return 1, err
+			}
+		}
+		now := time.Now()
+		u, e, err := authenticate(name, pw, opts.Force, now)
+		if err == nil {
+			return session(u, e, terminal(), opts.Host)
+		}
+
+		var locked *lockedError
+		switch {
+		case errors.Is(err, shadow.ErrExpired):
+			fmt.Println("Your account has expired; please contact your system administrator.")
+			return 1, nil
+		case errors.Is(err, shadow.ErrInactive):
+			fmt.Println("Your account is inactive; please contact your system administrator.")
+			return 1, nil
+		case errors.As(err, &locked):
+			fmt.Printf("\n%v\n", err)
+		default:
+			fmt.Printf("\n%v\n", errLogin)
+		}
+		if opts.Force || try+1 == retries {
+			return 1, nil
+		}
+		time.Sleep(readTally(name, now).delay())
+		fmt.Println()
+		name = ""
+	}
+}
+
+func main() {
+	// -h is the remote host, as with every other login
+	args, err := flags.NewParser(&opts, flags.PrintErrors|flags.PassDoubleDash).Parse()
+	if err != nil {
+		os.Exit(1)
+	}
+	log.SetFlags(0)
+	log.SetPrefix("login: ")
+
+	var name string
+	if len(args) > 0 {
+		name = args[0]
+	}
+	code, err := login(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"mybox/pkg/shadow"
+)
+
+func fixtures(t *testing.T) {
+	t.Helper()
+	oldRoot, oldDir := shadow.Root, faillockDir
+	shadow.Root, faillockDir = "testdata", t.TempDir()
+	t.Cleanup(func() { shadow.Root, faillockDir = oldRoot, oldDir })
+}
+
+func TestAuthenticate(t *testing.T) {
+	fixtures(t)
+	now := time.Unix(19700*24*60*60, 0)
+
+	for _, tt := range []struct {
+		name, password string
+		force          bool
+		want           error
+	}{
+		{"root", "secret", false, nil},
+		{"alice", "secret", false, nil},
+		{"alice", "wrong", false, errLogin},
+		{"nobody", "secret", false, errLogin},
+		{"bob", "secret", false, shadow.ErrExpired},
+		{"carol", "secret", false, shadow.ErrInactive},
+		{"dave", "secret", false, errLogin},
+		// -f skips the password but not the account checks
+		{"alice", "", true, nil},
+		{"bob", "", true, shadow.ErrExpired},
+	} {
+		u, _, err := authenticate(tt.name, tt.password, tt.force, now)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("authenticate(%s, %q) = %v, want %v", tt.name, tt.password, err, tt.want)
+		}
+		if err == nil && u.Name != tt.name {
+			t.Errorf("authenticate(%s) returned %s", tt.name, u.Name)
+		}
+	}
+}
+
+func TestFaillock(t *testing.T) {
+	fixtures(t)
+	now := time.Unix(19700*24*60*60, 0)
+
+	var delays []time.Duration
+	for i := 0; i < deny; i++ {
+		if _, _, err := authenticate("alice", "wrong", false, now); err != errLogin {
+			t.Fatalf("failure %d: %v", i, err)
+		}
+		delays = append(delays, readTally("alice", now).delay())
+	}
+	if delays[0] != failDelay || delays[1] <= delays[0] || delays[2] <= delays[1] {
+		t.Errorf("delays = %v, want them to grow from %v", delays, failDelay)
+	}
+
+	// even the right password is refused now
+	var locked *lockedError
+	if _, _, err := authenticate("alice", "secret", false, now.Add(time.Minute)); !errors.As(err, &locked) || locked.failures != deny {
+		t.Errorf("authenticate after %d failures = %v, want locked", deny, err)
+	}
+	if _, _, err := authenticate("alice", "secret", false, now.Add(unlockTime)); err != nil {
+		t.Errorf("authenticate after the lock = %v", err)
+	}
+	if _, err := os.Stat(readTally("alice", now).path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("tally kept after logging in: %v", err)
+	}
+
+	// root can always try
+	for i := 0; i < deny; i++ {
+		authenticate("root", "wrong", false, now)
+	}
+	if _, _, err := authenticate("root", "secret", false, now); err != nil {
+		t.Errorf("root locked out: %v", err)
+	}
+
+	// old failures don't count
+	for i := 0; i < deny-1; i++ {
+		authenticate("alice", "wrong", false, now)
+	}
+	authenticate("alice", "wrong", false, now.Add(failInterval))
+	if _, _, err := authenticate("alice", "secret", false, now.Add(failInterval)); err != nil {
+		t.Errorf("locked by failures from long ago: %v", err)
+	}
+}
+
+func TestSessionSetup(t *testing.T) {
+	for line, want := range map[string]string{"tty1": "1", "ttyS0": "S0", "pts/12": "s/12", "console": "sole"} {
+		if got := utmpID(line); got != want {
+			t.Errorf("utmpID(%s) = %q, want %q", line, got, want)
+		}
+	}
+
+	t.Setenv("TERM", "vt100")
+	t.Setenv("HOME", "/elsewhere")
+	u := &shadow.User{Name: "alice", UID: 1000, Home: "/home/alice", Shell: "/bin/bash"}
+	env := strings.Join(environ(u, u.Home, u.Shell, false), " ")
+	want := "TERM=vt100 HOME=/home/alice SHELL=/bin/bash USER=alice LOGNAME=alice PATH=/usr/local/bin:/usr/bin:/bin MAIL=/var/spool/mail/alice"
+	if env != want {
+		t.Errorf("environ = %q, want %q", env, want)
+	}
+	// -p keeps the rest, but not their HOME
+	env = strings.Join(environ(u, u.Home, u.Shell, true), " ")
+	if strings.Contains(env, "/elsewhere") || !strings.Contains(env, "HOME=/home/alice") {
+		t.Errorf("environ -p = %q", env)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"mybox/pkg/shadow"
+	"mybox/pkg/sysinfo"
+
+	"golang.org/x/sys/unix"
+)
+
+// utmpID is the ut_id for a terminal line, like agetty and login use
+func utmpID(line string) string {
+	if id, ok := strings.CutPrefix(line, "tty"); ok {
+		return id
+	}
+	if len(line) > 4 {
+		return line[len(line)-4:]
+	}
+	return line
+}
+
+// environ is the environment of u's shell
+func environ(u *shadow.User, home, shell string, preserve bool) []string {
+	var env []string
+	if preserve {
+		env = os.Environ()
+	} else if term := os.Getenv("TERM"); term != "" {
+		env = append(env, "TERM="+term)
+	}
+	path := "/usr/local/bin:/usr/bin:/bin"
+	if u.UID == 0 {
+		path = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+	}
+	for _, kv := range []string{
+		"HOME=" + home,
+		"SHELL=" + shell,
+		"USER=" + u.Name,
+		"LOGNAME=" + u.Name,
+		"PATH=" + path,
+		"MAIL=/var/spool/mail/" + u.Name,
+	} {
+		// replace what -p kept, getenv would find that first
+		k, _, _ := strings.Cut(kv, "=")
+		for i := 0; i < len(env); i++ {
+			if strings.HasPrefix(env[i], k+"=") {
+				env = append(env[:i], env[i+1:]...)
+				i--
+			}
+		}
+		env = append(env, kv)
+	}
+	return env
+}
+
+// greet shows the last login, the message of the day and password expiry
+// warnings, unless the user has a .hushlogin
+func greet(u *shadow.User, e *shadow.Entry, home string, now time.Time) {
+	if _, err := os.Stat(filepath.Join(home, ".hushlogin")); err == nil {
+		return
+	}
+	if l, err := sysinfo.ReadLastlog(sysinfo.LastlogFile, u.UID); err == nil && !l.Time.IsZero() {
+		fmt.Printf("Last login: %s on %s", l.Time.Format("Mon Jan _2 15:04:05 2006"), l.Line)
+		if l.Host != "" {
+			fmt.Printf(" from %s", l.Host)
+		}
+		fmt.Println()
+	}
+	if b, err := os.ReadFile(filepath.Join(shadow.Root, "etc/motd")); err == nil {
+		os.Stdout.Write(b)
+	}
+	if days, ok := e.PasswordExpiry(now); ok {
+		if days < 0 {
+			fmt.Println("Warning: your password has expired.")
+		} else {
+			fmt.Printf("Warning: your password will expire in %d days.\n", days)
+		}
+	}
+}
+
+// record writes u's login, or with u nil the logout, to utmp and wtmp
+func record(u *shadow.User, line, host string, now time.Time) {
+	r := sysinfo.Utmp{Type: sysinfo.DeadProcess, Pid: os.Getpid(), Line: line, ID: utmpID(line), Time: now}
+	if u != nil {
+		r.Type, r.User, r.Host = sysinfo.UserProcess, u.Name, host
+		if sid, err := unix.Getsid(0); err == nil {
+			r.Session = sid
+		}
+	}
+	if err := sysinfo.WriteUtmp(sysinfo.UtmpFile, &r); err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "login: utmp: %v\n", err)
+	}
+	if err := sysinfo.AppendUtmp(sysinfo.WtmpFile, &r); err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "login: wtmp: %v\n", err)
+	}
+}
+
+// session runs u's shell on our terminal, line, and records the login and
+// logout. It returns the shell's exit code.
+func session(u *shadow.User, e *shadow.Entry, line, host string) (int, error) {
+	groups, err := shadow.Groups(u.Name, u.GID)
+	if err != nil {
+		return 1, err
+	}
+	gids := make([]uint32, len(groups))
+	for i, g := range groups {
+		gids[i] = uint32(g)
+	}
+
+	// the terminal is theirs now
+	if err := os.Stdin.Chown(u.UID, u.GID); err != nil {
+		return 1, err
+	}
+	if err := os.Stdin.Chmod(0o600); err != nil {
+		return 1, err
+	}
+
+	home := u.Home
+	if fi, err := os.Stat(home); err != nil || !fi.IsDir() {
+		fmt.Printf("No directory %s, logging in with HOME=/\n", home)
+		home = "/"
+	}
+	shell := u.Shell
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+
+	now := time.Now()
+	greet(u, e, home, now)
+	if err := sysinfo.WriteLastlog(sysinfo.LastlogFile, u.UID, &sysinfo.Lastlog{Time: now, Line: line, Host: host}); err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "login: lastlog: %v\n", err)
+	}
+	record(u, line, host, now)
+	defer func() { record(nil, line, "", time.Now()) }()
+
+	// a leading dash makes it a login shell
+	c := exec.Command(shell)
+	c.Args = []string{"-" + filepath.Base(shell)}
+	c.Env = environ(u, home, shell, opts.Preserve)
+	c.Dir = home
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	// the shell gets the terminal's foreground, we stay around to clean up
+	c.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid:    true,
+		Foreground: true,
+		Ctty:       0,
+		Credential: &syscall.Credential{Uid: uint32(u.UID), Gid: uint32(u.GID), Groups: gids},
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	if err := c.Start(); err != nil {
+		return 1, err
+	}
+	go func() {
+		for sig := range sigs {
+			syscall.Kill(-c.Process.Pid, sig.(syscall.Signal))
+		}
+	}()
+
+	err = c.Wait()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		if ws, ok := exit.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			return 128 + int(ws.Signal()), nil
+		}
+		return exit.ExitCode(), nil
+	}
+	return 0, err
+}
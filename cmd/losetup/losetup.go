@@ -0,0 +1,228 @@
+// losetup sets up and lists loop devices.
+//
+// Synopsis:
+//
+//	losetup [-o OFFSET] [--sizelimit SIZE] [-Pr] LOOPDEV FILE
+//	losetup -f [--show] [-o OFFSET] [--sizelimit SIZE] [-Pr] [FILE]
+//	losetup -d LOOPDEV...
+//	losetup -D
+//	losetup [-l] [-J] [LOOPDEV...]
+//
+// With -f and no FILE, losetup prints the first free device. With FILE, it
+// puts FILE on that device, and prints it with --show.
+//
+// Without options, or with -l or -J, losetup lists the devices in use, or
+// the LOOPDEVs, with their backing files, offsets and flags.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+
+	"mybox/pkg/mount/loop"
+)
+
+// options are the flags of losetup
+type options struct {
+	Find      bool   `short:"f" long:"find" description:"find the first free device"`
+	Show      bool   `long:"show" description:"print the device set up with -f"`
+	Offset    uint64 `short:"o" long:"offset" value-name:"OFFSET" description:"start the device at OFFSET bytes in the file"`
+	SizeLimit uint64 `long:"sizelimit" value-name:"SIZE" description:"make the device at most SIZE bytes"`
+	PartScan  bool   `short:"P" long:"partscan" description:"scan the device for partitions"`
+	ReadOnly  bool   `short:"r" long:"read-only" description:"set up a read only device"`
+	Detach    bool   `short:"d" long:"detach" description:"detach the LOOPDEVs"`
+	DetachAll bool   `short:"D" long:"detach-all" description:"detach all the devices in use"`
+	List      bool   `short:"l" long:"list" description:"list the devices"`
+	JSON      bool   `short:"J" long:"json" description:"list the devices as JSON"`
+}
+
+// Debug prints what losetup does
+var Debug = func(string, ...interface{}) {}
+
+// device is a device in the list, like util-linux prints it with -J
+type device struct {
+	Name      string `json:"name"`
+	SizeLimit uint64 `json:"sizelimit"`
+	Offset    uint64 `json:"offset"`
+	AutoClear bool   `json:"autoclear"`
+	RO        bool   `json:"ro"`
+	PartScan  bool   `json:"partscan"`
+	BackFile  string `json:"back-file"`
+	DIO       bool   `json:"dio"`
+}
+
+// devName makes a device path of a name like loop0, or a number
+func devName(name string) string {
+	if _, err := strconv.Atoi(name); err == nil {
+		return "/dev/loop" + name
+	}
+	if !strings.Contains(name, "/") {
+		return filepath.Join("/dev", name)
+	}
+	return name
+}
+
+// list prints the devices in use, or those of args
+func (opts *options) list(w io.Writer, args []string) error {
+	var infos []*loop.Info
+	var errs []error
+	if len(args) == 0 {
+		var err error
+		if infos, err = loop.Devices(); err != nil {
+			return err
+		}
+	}
+	for _, a := range args {
+		info, err := loop.Stat(devName(a))
+		if errors.Is(err, os.ErrNotExist) {
+			err = fmt.Errorf("%s: not in use", a)
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		infos = append(infos, info)
+	}
+
+	var devices []device
+	for _, i := range infos {
+		devices = append(devices, device{i.Dev, i.SizeLimit, i.Offset, i.AutoClear, i.ReadOnly, i.PartScan, i.BackingFile, i.DirectIO})
+	}
+	if opts.JSON {
+		b, err := json.MarshalIndent(map[string][]device{"loopdevices": devices}, "", "   ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\n", b)
+		return errors.Join(errs...)
+	}
+	if len(devices) == 0 {
+		return errors.Join(errs...)
+	}
+
+	flag := func(b bool) string {
+		if b {
+			return "1"
+		}
+		return "0"
+	}
+	table := [][]string{{"NAME", "SIZELIMIT", "OFFSET", "AUTOCLEAR", "RO", "PARTSCAN", "BACK-FILE", "DIO"}}
+	for _, d := range devices {
+		table = append(table, []string{
+			d.Name, strconv.FormatUint(d.SizeLimit, 10), strconv.FormatUint(d.Offset, 10),
+			flag(d.AutoClear), flag(d.RO), flag(d.PartScan), d.BackFile, flag(d.DIO),
+		})
+	}
+	widths := make([]int, len(table[0]))
+	for _, line := range table {
+		for i, cell := range line {
+			widths[i] = max(widths[i], len(cell))
+		}
+	}
+	for _, line := range table {
+		var b strings.Builder
+		for i, cell := range line {
+			// the names and backing files to the left, numbers to the right
+			if i == 0 || i == 6 {
+				fmt.Fprintf(&b, "%-*s ", widths[i], cell)
+			} else {
+				fmt.Fprintf(&b, "%*s ", widths[i], cell)
+			}
+		}
+		fmt.Fprintln(w, strings.TrimRight(b.String(), " "))
+	}
+	return errors.Join(errs...)
+}
+
+// detach frees the devices
+func detach(devs []string) error {
+	var errs []error
+	for _, d := range devs {
+		Debug("detaching %s", d)
+		if err := loop.ClearFile(devName(d)); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", d, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// attach puts file on dev, or on the first free device if dev is empty
+func (opts *options) attach(w io.Writer, dev, file string) error {
+	if dev == "" {
+		var err error
+		if dev, err = loop.FindDevice(); err != nil {
+			return fmt.Errorf("could not find a free loop device: %w", err)
+		}
+	}
+	c := loop.Config{Offset: opts.Offset, SizeLimit: opts.SizeLimit, ReadOnly: opts.ReadOnly, PartScan: opts.PartScan}
+	Debug("setting up %s for %s with %+v", dev, file, c)
+	if err := loop.Configure(dev, file, c); err != nil {
+		return fmt.Errorf("%s: failed to set up loop device: %w", file, err)
+	}
+	if opts.Show {
+		fmt.Fprintln(w, dev)
+	}
+	return nil
+}
+
+func run(w io.Writer, opts options, args []string) error {
+	switch {
+	case opts.DetachAll:
+		infos, err := loop.Devices()
+		if err != nil {
+			return err
+		}
+		var devs []string
+		for _, i := range infos {
+			devs = append(devs, i.Dev)
+		}
+		return detach(devs)
+	case opts.Detach:
+		if len(args) == 0 {
+			return fmt.Errorf("-d needs a LOOPDEV")
+		}
+		return detach(args)
+	case opts.Find:
+		switch len(args) {
+		case 0:
+			dev, err := loop.FindDevice()
+			if err != nil {
+				return fmt.Errorf("could not find a free loop device: %w", err)
+			}
+			fmt.Fprintln(w, dev)
+			return nil
+		case 1:
+			return opts.attach(w, "", args[0])
+		}
+		return fmt.Errorf("-f takes one FILE")
+	case opts.List || opts.JSON || len(args) != 2:
+		return opts.list(w, args)
+	}
+	return opts.attach(w, devName(args[0]), args[1])
+}
+
+func main() {
+	var opts options
+	args, err := flags.Parse(&opts)
+	if flags.WroteHelp(err) {
+		os.Exit(0)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+	log.SetFlags(0)
+	log.SetPrefix("losetup: ")
+
+	if err := run(os.Stdout, opts, args); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"mybox/pkg/mount/loop"
+)
+
+func TestDevName(t *testing.T) {
+	for _, tt := range []struct{ in, want string }{
+		{"3", "/dev/loop3"},
+		{"loop3", "/dev/loop3"},
+		{"/dev/loop3", "/dev/loop3"},
+		{"/dev/disk/by-id/x", "/dev/disk/by-id/x"},
+	} {
+		if got := devName(tt.in); got != tt.want {
+			t.Errorf("devName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// losetup runs run with opts, and returns what it printed
+func losetup(t *testing.T, args []string, opts options) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	err := run(&out, opts, args)
+	return out.String(), err
+}
+
+func TestLosetup(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("needs root to set up loop devices")
+	}
+	if _, err := os.Stat("/dev/loop-control"); err != nil {
+		t.Skip("no loop devices")
+	}
+	img := t.TempDir() + "/disk.img"
+	if err := os.WriteFile(img, make([]byte, 4<<20), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := losetup(t, []string{img}, options{Find: true, Show: true, Offset: 1 << 20, SizeLimit: 1 << 20, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev := strings.TrimSpace(out)
+	if !strings.HasPrefix(dev, "/dev/loop") {
+		t.Fatalf("got %q, want a loop device", out)
+	}
+	t.Cleanup(func() { loop.ClearFile(dev) })
+
+	out, err = losetup(t, []string{dev}, options{JSON: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list struct {
+		Devices []device `json:"loopdevices"`
+	}
+	if err := json.Unmarshal([]byte(out), &list); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	want := device{Name: dev, SizeLimit: 1 << 20, Offset: 1 << 20, RO: true, BackFile: img}
+	if len(list.Devices) != 1 || list.Devices[0] != want {
+		t.Errorf("got %+v, want %+v", list.Devices, want)
+	}
+
+	out, err = losetup(t, nil, options{List: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out, "NAME ") || !strings.Contains(out, dev+" ") || !strings.Contains(out, " 1048576 ") {
+		t.Errorf("%s is not listed:\n%s", dev, out)
+	}
+
+	if _, err := losetup(t, []string{dev}, options{Detach: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := losetup(t, []string{dev}, options{}); err == nil || !strings.Contains(err.Error(), "not in use") {
+		t.Errorf("got %v, want %s not in use", err, dev)
+	}
+
+	// a device and a file
+	free, err := losetup(t, nil, options{Find: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	free = strings.TrimSpace(free)
+	if _, err := losetup(t, []string{free, img}, options{PartScan: true}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { loop.ClearFile(free) })
+	info, err := loop.Stat(free)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.BackingFile != img || !info.PartScan || info.ReadOnly || info.Offset != 0 {
+		t.Errorf("got %+v, want %s with partscan", info, img)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		args []string
+		opts options
+		want string
+	}{
+		{"detach nothing", nil, options{Detach: true}, "-d needs a LOOPDEV"},
+		{"find two", []string{"a", "b"}, options{Find: true}, "-f takes one FILE"},
+		{"not a device", []string{"/dev/loop-nothere"}, options{}, "/dev/loop-nothere: not in use"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := losetup(t, tt.args, tt.opts); err == nil || err.Error() != tt.want {
+				t.Errorf("got %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,410 @@
+// lsblk lists block devices as a tree of disks, their partitions and the
+// devices stacked on them, like util-linux lsblk.
+//
+// Synopsis:
+//
+//	lsblk [-abdfJlnP] [-o COLUMNS] [DEVICE...]
+//
+// COLUMNS is a comma separated list of NAME, KNAME, MAJ:MIN, RM, SIZE, RO,
+// TYPE, FSTYPE, FSVER, LABEL, UUID, MOUNTPOINTS, MODEL and SERIAL. A list
+// starting with + adds to the default columns.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+)
+
+// options are the flags of lsblk
+type options struct {
+	All    bool   `short:"a" long:"all" description:"list empty devices too"`
+	Bytes  bool   `short:"b" long:"bytes" description:"print sizes in bytes"`
+	NoDeps bool   `short:"d" long:"nodeps" description:"do not list partitions or stacked devices"`
+	FS     bool   `short:"f" long:"fs" description:"list file systems"`
+	JSON   bool   `short:"J" long:"json" description:"print JSON"`
+	List   bool   `short:"l" long:"list" description:"print a list instead of a tree"`
+	NoHead bool   `short:"n" long:"noheadings" description:"do not print headings"`
+	Output string `short:"o" long:"output" description:"columns to print"`
+	Pairs  bool   `short:"P" long:"pairs" description:"print NAME=\"VALUE\" pairs"`
+}
+
+// Exit codes of util-linux lsblk
+const (
+	exitFailure  = 1
+	exitNotFound = 32
+	exitSome     = 64
+)
+
+var (
+	defaultColumns = []string{"NAME", "MAJ:MIN", "RM", "SIZE", "RO", "TYPE", "MOUNTPOINTS"}
+	fsColumns      = []string{"NAME", "FSTYPE", "FSVER", "LABEL", "UUID", "MOUNTPOINTS"}
+)
+
+// column is what lsblk knows of a column
+type column struct {
+	// right aligns the column
+	right bool
+	// probe is set for columns that need the superblock
+	probe bool
+}
+
+var columns = map[string]column{
+	"NAME":        {},
+	"KNAME":       {},
+	"MAJ:MIN":     {},
+	"RM":          {right: true},
+	"SIZE":        {right: true},
+	"RO":          {right: true},
+	"TYPE":        {},
+	"FSTYPE":      {probe: true},
+	"FSVER":       {probe: true},
+	"LABEL":       {probe: true},
+	"UUID":        {probe: true},
+	"MOUNTPOINTS": {},
+	"MODEL":       {},
+	"SERIAL":      {},
+}
+
+// human prints a size like util-linux does, with at most one decimal
+func human(n uint64) string {
+	const suffixes = "BKMGTPE"
+	exp := 0
+	for exp < 60 && n >= 1<<(exp+10) {
+		exp += 10
+	}
+	whole, frac := n>>exp, uint64(0)
+	if exp > 0 {
+		// three digits after the point, rounded to one
+		frac = (n&(1<<exp-1)*1000>>exp + 50) / 100
+		if frac == 10 {
+			whole, frac = whole+1, 0
+		}
+	}
+	if frac != 0 {
+		return fmt.Sprintf("%d.%d%c", whole, frac, suffixes[exp/10])
+	}
+	return fmt.Sprintf("%d%c", whole, suffixes[exp/10])
+}
+
+func flag(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// value is what d shows in col
+func (opts *options) value(d *device, col string) string {
+	sb := d.sb
+	switch col {
+	case "NAME":
+		return d.name
+	case "KNAME":
+		return d.kname
+	case "MAJ:MIN":
+		return d.majMin
+	case "RM":
+		return flag(d.rm)
+	case "SIZE":
+		if opts.Bytes {
+			return fmt.Sprint(d.size)
+		}
+		return human(d.size)
+	case "RO":
+		return flag(d.ro)
+	case "TYPE":
+		return d.typ
+	case "MOUNTPOINTS":
+		return strings.Join(d.mounts, "\n")
+	case "MODEL":
+		return d.model
+	case "SERIAL":
+		return d.serial
+	}
+	if sb == nil {
+		return ""
+	}
+	switch col {
+	case "FSTYPE":
+		return sb.Type
+	case "FSVER":
+		return sb.Version
+	case "LABEL":
+		return sb.Label
+	case "UUID":
+		return sb.UUID
+	}
+	return ""
+}
+
+// parseColumns reads -o, or -f, into the columns to print
+func (opts *options) parseColumns() ([]string, error) {
+	cols := defaultColumns
+	if opts.FS {
+		cols = fsColumns
+	}
+	if opts.Output == "" {
+		return cols, nil
+	}
+	list := opts.Output
+	if strings.HasPrefix(list, "+") {
+		list = list[1:]
+	} else {
+		cols = nil
+	}
+	cols = append([]string(nil), cols...)
+	for _, c := range strings.Split(list, ",") {
+		c = strings.ToUpper(strings.TrimSpace(c))
+		if _, ok := columns[c]; !ok {
+			return nil, fmt.Errorf("unknown column: %s", c)
+		}
+		cols = append(cols, c)
+	}
+	return cols, nil
+}
+
+// row is a line of the table, with the tree drawn in front of NAME
+type row struct {
+	prefix string
+	d      *device
+}
+
+// rows flattens devs. Unless flat, NAME gets the branches of the tree in
+// front, after indent.
+func (opts *options) rows(devs []*device, indent string, depth int, flat bool) []row {
+	var r []row
+	for i, d := range devs {
+		prefix, next := "", ""
+		if !flat && depth > 0 {
+			if i == len(devs)-1 {
+				prefix, next = "└─", "  "
+			} else {
+				prefix, next = "├─", "│ "
+			}
+		}
+		r = append(r, row{prefix: indent + prefix, d: d})
+		if !opts.NoDeps {
+			r = append(r, opts.rows(d.children, indent+next, depth+1, flat)...)
+		}
+	}
+	return r
+}
+
+// printTable aligns the columns, text to the left and numbers to the right.
+// A cell of several lines, like MOUNTPOINTS, takes as many lines.
+func printTable(w io.Writer, cols []string, table [][]string) {
+	widths := make([]int, len(cols))
+	for _, line := range table {
+		for i, cell := range line {
+			for _, l := range strings.Split(cell, "\n") {
+				if n := len([]rune(l)); n > widths[i] {
+					widths[i] = n
+				}
+			}
+		}
+	}
+	for _, line := range table {
+		height := 1
+		for _, cell := range line {
+			height = max(height, strings.Count(cell, "\n")+1)
+		}
+		for h := 0; h < height; h++ {
+			var b strings.Builder
+			for i, cell := range line {
+				if i > 0 {
+					b.WriteByte(' ')
+				}
+				var l string
+				if parts := strings.Split(cell, "\n"); h < len(parts) {
+					l = parts[h]
+				}
+				pad := strings.Repeat(" ", widths[i]-len([]rune(l)))
+				if columns[cols[i]].right {
+					b.WriteString(pad + l)
+				} else {
+					b.WriteString(l + pad)
+				}
+			}
+			fmt.Fprintln(w, strings.TrimRight(b.String(), " "))
+		}
+	}
+}
+
+// pairsEscape escapes a value for -P
+func pairsEscape(s string) string {
+	return strings.NewReplacer(`\`, `\x5c`, `"`, `\x22`, "\n", `\x0a`).Replace(s)
+}
+
+// field is a key of a JSON object, which keeps the order of its fields
+type field struct {
+	key   string
+	value any
+}
+
+type object []field
+
+// MarshalJSON implements json.Marshaler.
+func (o object) MarshalJSON() ([]byte, error) {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, f := range o {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		k, err := json.Marshal(f.key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return []byte(b.String()), nil
+}
+
+// jsonValue is what d shows in col for -J, typed like util-linux does it
+func (opts *options) jsonValue(d *device, col string) any {
+	v := opts.value(d, col)
+	switch col {
+	case "RM":
+		return d.rm
+	case "RO":
+		return d.ro
+	case "SIZE":
+		if opts.Bytes {
+			return d.size
+		}
+	case "MOUNTPOINTS":
+		if len(d.mounts) == 0 {
+			return []any{nil}
+		}
+		return d.mounts
+	}
+	if v == "" {
+		return nil
+	}
+	return v
+}
+
+// jsonTree is devs as JSON objects, with their children unless flat
+func (opts *options) jsonTree(devs []*device, cols []string, flat bool) []object {
+	var list []object
+	for _, d := range devs {
+		o := object{}
+		for _, c := range cols {
+			o = append(o, field{strings.ToLower(c), opts.jsonValue(d, c)})
+		}
+		if len(d.children) > 0 && !opts.NoDeps {
+			if flat {
+				list = append(list, o)
+				list = append(list, opts.jsonTree(d.children, cols, flat)...)
+				continue
+			}
+			o = append(o, field{"children", opts.jsonTree(d.children, cols, flat)})
+		}
+		list = append(list, o)
+	}
+	return list
+}
+
+func lsblk(w io.Writer, opts options, args []string) (int, error) {
+	cols, err := opts.parseColumns()
+	if err != nil {
+		return exitFailure, err
+	}
+	t := &tree{all: opts.All || len(args) > 0}
+	for _, c := range cols {
+		t.probe = t.probe || columns[c].probe
+	}
+	devs, err := t.devices()
+	if err != nil {
+		return exitFailure, err
+	}
+
+	code := 0
+	if len(args) > 0 {
+		var picked []*device
+		for _, a := range args {
+			kname := a
+			if p, err := filepath.EvalSymlinks(a); err == nil {
+				kname = p
+			}
+			d := find(devs, filepath.Base(kname))
+			if d == nil {
+				log.Printf("%s: not a block device", a)
+				code = exitSome
+				continue
+			}
+			picked = append(picked, d)
+		}
+		if len(picked) == 0 {
+			return exitNotFound, nil
+		}
+		devs = picked
+	}
+
+	switch {
+	case opts.JSON:
+		b, err := json.MarshalIndent(map[string]any{"blockdevices": opts.jsonTree(devs, cols, opts.List)}, "", "   ")
+		if err != nil {
+			return exitFailure, err
+		}
+		fmt.Fprintf(w, "%s\n", b)
+	case opts.Pairs:
+		for _, r := range opts.rows(devs, "", 0, true) {
+			var pairs []string
+			for _, c := range cols {
+				pairs = append(pairs, fmt.Sprintf(`%s="%s"`, c, pairsEscape(opts.value(r.d, c))))
+			}
+			fmt.Fprintln(w, strings.Join(pairs, " "))
+		}
+	default:
+		var table [][]string
+		if !opts.NoHead {
+			table = append(table, cols)
+		}
+		for _, r := range opts.rows(devs, "", 0, opts.List) {
+			line := make([]string, len(cols))
+			for i, c := range cols {
+				line[i] = opts.value(r.d, c)
+				if c == "NAME" {
+					line[i] = r.prefix + line[i]
+				}
+			}
+			table = append(table, line)
+		}
+		printTable(w, cols, table)
+	}
+	return code, nil
+}
+
+func main() {
+	var opts options
+	args, err := flags.Parse(&opts)
+	if flags.WroteHelp(err) {
+		os.Exit(0)
+	}
+	if err != nil {
+		os.Exit(exitFailure)
+	}
+	log.SetFlags(0)
+	log.SetPrefix("lsblk: ")
+
+	code, err := lsblk(os.Stdout, opts, args)
+	if err != nil {
+		log.Print(err)
+	}
+	os.Exit(code)
+}
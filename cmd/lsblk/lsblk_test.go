@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mybox/pkg/mount"
+)
+
+// fakeSys makes a sysfs with a disk sda of two partitions, the second
+// holding an LVM volume, a CD-ROM, a loop device on a file and an empty
+// one. The devices of sda1 and the volume hold the ext4 and vfat file
+// systems of the test image.
+func fakeSys(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	write := func(path, data string) {
+		t.Helper()
+		p := filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := func(path string) {
+		t.Helper()
+		p := filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink("../../"+filepath.Base(p), p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dev := func(name, majMin string, sectors int) {
+		write("sys/block/"+name+"/dev", majMin)
+		write("sys/block/"+name+"/size", strconv.Itoa(sectors))
+		write("sys/block/"+name+"/ro", "0")
+		write("sys/block/"+name+"/removable", "0")
+	}
+
+	dev("sda", "8:0", 2048)
+	write("sys/block/sda/device/model", "QEMU HARDDISK   ")
+	write("sys/block/sda/device/serial", "QM00001")
+	write("sys/block/sda/sda1/partition", "1")
+	write("sys/block/sda/sda1/dev", "8:1")
+	write("sys/block/sda/sda1/size", "1024")
+	write("sys/block/sda/sda1/ro", "0")
+	write("sys/block/sda/sda2/partition", "2")
+	write("sys/block/sda/sda2/dev", "8:2")
+	write("sys/block/sda/sda2/size", "1022")
+	write("sys/block/sda/sda2/ro", "0")
+	link("sys/block/sda/sda2/holders/dm-0")
+
+	dev("dm-0", "253:0", 1022)
+	write("sys/block/dm-0/dm/name", "vg-root")
+	write("sys/block/dm-0/dm/uuid", "LVM-Jx4bQ3")
+	link("sys/block/dm-0/slaves/sda2")
+
+	dev("sr0", "11:0", 1000)
+	write("sys/block/sr0/removable", "1")
+	write("sys/block/sr0/ro", "1")
+	dev("loop0", "7:0", 0)
+	dev("loop1", "7:1", 20480)
+	write("sys/block/loop1/loop/backing_file", "/tmp/disk.img")
+
+	img, err := os.ReadFile("../../pkg/mount/testdata/1MB.ext4_vfat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Mkdir(filepath.Join(dir, "dev"), 0o755)
+	write("dev/sda", string(img))
+	write("dev/sda1", string(img[512:1025*512]))
+	write("dev/dm-0", string(img[1025*512:]))
+
+	write("mountinfo", strings.Join([]string{
+		"22 1 8:1 / /boot rw,relatime - ext4 /dev/sda1 rw",
+		"23 1 253:0 / /srv/my\\040data rw,relatime - vfat /dev/mapper/vg-root rw",
+		"24 1 253:0 / /mnt rw,relatime - vfat /dev/mapper/vg-root rw",
+		"25 1 0:22 / /proc rw - proc proc rw",
+	}, "\n"))
+
+	saved := []string{sysfs, devfs, mount.MountinfoPath}
+	t.Cleanup(func() { sysfs, devfs, mount.MountinfoPath = saved[0], saved[1], saved[2] })
+	sysfs, devfs = filepath.Join(dir, "sys"), filepath.Join(dir, "dev")
+	mount.MountinfoPath = filepath.Join(dir, "mountinfo")
+}
+
+func TestHuman(t *testing.T) {
+	for _, tt := range []struct {
+		n    uint64
+		want string
+	}{
+		{0, "0B"},
+		{512, "512B"},
+		{1024, "1K"},
+		{1536, "1.5K"},
+		{523264, "511K"},
+		{521142272, "497M"},
+		{512110190592, "476.9G"},
+		{274877906944, "256G"},
+		// rounds up to the next whole number, in the same unit
+		{1073741312, "1024M"},
+	} {
+		if got := human(tt.n); got != tt.want {
+			t.Errorf("human(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestLsblk(t *testing.T) {
+	fakeSys(t)
+	for _, tt := range []struct {
+		name string
+		args []string
+		opts options
+		want string
+		code int
+	}{
+		{
+			name: "tree",
+			want: `NAME        MAJ:MIN RM SIZE RO TYPE MOUNTPOINTS
+loop1       7:1      0  10M  0 loop
+sda         8:0      0   1M  0 disk
+├─sda1      8:1      0 512K  0 part /boot
+└─sda2      8:2      0 511K  0 part
+  └─vg-root 253:0    0 511K  0 lvm  /srv/my data
+                                    /mnt
+sr0         11:0     1 500K  1 rom
+`,
+		},
+		{
+			name: "file systems",
+			opts: options{FS: true},
+			want: `NAME        FSTYPE FSVER LABEL UUID                                 MOUNTPOINTS
+loop1
+sda
+├─sda1      ext4   1.0         2183ead8-a510-4b3d-9777-19c7090f66d9 /boot
+└─sda2
+  └─vg-root vfat   FAT12       ACE5-5144                            /srv/my data
+                                                                    /mnt
+sr0
+`,
+		},
+		{
+			name: "columns",
+			args: []string{"/dev/sda"},
+			opts: options{Output: "name,size,model,serial", NoDeps: true},
+			want: `NAME SIZE MODEL         SERIAL
+sda    1M QEMU HARDDISK QM00001
+`,
+		},
+		{
+			name: "more columns",
+			args: []string{"sda2"},
+			opts: options{Output: "+KNAME", Bytes: true},
+			want: `NAME      MAJ:MIN RM   SIZE RO TYPE MOUNTPOINTS  KNAME
+sda2      8:2      0 523264  0 part              sda2
+└─vg-root 253:0    0 523264  0 lvm  /srv/my data dm-0
+                                    /mnt
+`,
+		},
+		{
+			name: "list",
+			args: []string{"sda"},
+			opts: options{List: true, NoHead: true, Output: "NAME,TYPE"},
+			want: `sda     disk
+sda1    part
+sda2    part
+vg-root lvm
+`,
+		},
+		{
+			name: "all",
+			opts: options{All: true, NoDeps: true, Output: "NAME"},
+			want: "NAME\nloop0\nloop1\nsda\nsr0\n",
+		},
+		{
+			name: "pairs",
+			args: []string{"sda2"},
+			opts: options{Pairs: true, Output: "NAME,FSTYPE,MOUNTPOINTS"},
+			want: `NAME="sda2" FSTYPE="" MOUNTPOINTS=""
+NAME="vg-root" FSTYPE="vfat" MOUNTPOINTS="/srv/my data\x0a/mnt"
+`,
+		},
+		{
+			name: "json",
+			args: []string{"sda"},
+			opts: options{JSON: true, Bytes: true, Output: "NAME,SIZE,RO,LABEL,MOUNTPOINTS"},
+			want: `{
+   "blockdevices": [
+      {
+         "name": "sda",
+         "size": 1048576,
+         "ro": false,
+         "label": null,
+         "mountpoints": [
+            null
+         ],
+         "children": [
+            {
+               "name": "sda1",
+               "size": 524288,
+               "ro": false,
+               "label": null,
+               "mountpoints": [
+                  "/boot"
+               ]
+            },
+            {
+               "name": "sda2",
+               "size": 523264,
+               "ro": false,
+               "label": null,
+               "mountpoints": [
+                  null
+               ],
+               "children": [
+                  {
+                     "name": "vg-root",
+                     "size": 523264,
+                     "ro": false,
+                     "label": null,
+                     "mountpoints": [
+                        "/srv/my data",
+                        "/mnt"
+                     ]
+                  }
+               ]
+            }
+         ]
+      }
+   ]
+}
+`,
+		},
+		{
+			name: "missing",
+			args: []string{"sda", "sdz"},
+			opts: options{Output: "NAME", NoDeps: true},
+			want: "NAME\nsda\n",
+			code: exitSome,
+		},
+		{
+			name: "none found",
+			args: []string{"sdz"},
+			code: exitNotFound,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			code, err := lsblk(&out, tt.opts, tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code != tt.code {
+				t.Errorf("exit code %d, want %d", code, tt.code)
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnknownColumn(t *testing.T) {
+	opts := options{Output: "NAME,COLOR"}
+	if _, err := opts.parseColumns(); err == nil || err.Error() != "unknown column: COLOR" {
+		t.Errorf("parseColumns() = %v, want unknown column: COLOR", err)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"mybox/pkg/mount"
+	"mybox/pkg/mount/block"
+)
+
+// sysfs and devfs are where the devices are read from
+var (
+	sysfs = "/sys"
+	devfs = "/dev"
+)
+
+// device is a block device and what is stacked on it
+type device struct {
+	// kname is the kernel name, name what is shown, which differs for
+	// device mapper devices
+	kname    string
+	name     string
+	majMin   string
+	size     uint64
+	ro       bool
+	rm       bool
+	typ      string
+	model    string
+	serial   string
+	sb       *block.Superblock
+	mounts   []string
+	children []*device
+}
+
+// readString reads a sysfs attribute, trimmed
+func readString(path ...string) string {
+	b, err := os.ReadFile(filepath.Join(path...))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// readBool reads a sysfs attribute that is 0 or 1
+func readBool(path ...string) bool {
+	return readString(path...) == "1"
+}
+
+// names lists a sysfs directory of links to devices, like holders
+func names(path ...string) []string {
+	entries, err := os.ReadDir(filepath.Join(path...))
+	if err != nil {
+		return nil
+	}
+	var n []string
+	for _, e := range entries {
+		n = append(n, e.Name())
+	}
+	return n
+}
+
+// devType is what lsblk calls the device in its TYPE column
+func devType(dir, kname string, part bool) string {
+	switch {
+	case part:
+		return "part"
+	case strings.HasPrefix(kname, "loop"):
+		return "loop"
+	case strings.HasPrefix(kname, "sr"):
+		return "rom"
+	case strings.HasPrefix(kname, "md"):
+		if level := readString(dir, "md", "level"); level != "" {
+			return level
+		}
+		return "md"
+	case strings.HasPrefix(kname, "dm-"):
+		// the prefix of the UUID tells which target made the device
+		uuid := readString(dir, "dm", "uuid")
+		if i := strings.IndexByte(uuid, '-'); i > 0 {
+			return strings.ToLower(uuid[:i])
+		}
+		return "dm"
+	}
+	return "disk"
+}
+
+// tree reads the devices from sysfs. probe reads the superblocks too,
+// and all keeps empty devices.
+type tree struct {
+	probe  bool
+	all    bool
+	mounts map[string][]string
+}
+
+// read reads the device in dir, its partitions and the devices held on
+// top of them
+func (t *tree) read(dir, kname string, part bool, parent *device) *device {
+	d := &device{
+		kname:  kname,
+		name:   kname,
+		majMin: readString(dir, "dev"),
+		ro:     readBool(dir, "ro"),
+		typ:    devType(dir, kname, part),
+	}
+	if name := readString(dir, "dm", "name"); name != "" {
+		d.name = name
+	}
+	if n, err := strconv.ParseUint(readString(dir, "size"), 10, 64); err == nil {
+		d.size = n * 512
+	}
+	if part && parent != nil {
+		d.rm = parent.rm
+	} else {
+		d.rm = readBool(dir, "removable")
+		d.model = readString(dir, "device", "model")
+		d.serial = readString(dir, "device", "serial")
+	}
+	d.mounts = t.mounts[d.majMin]
+	if t.probe {
+		if f, err := os.Open(filepath.Join(devfs, kname)); err == nil {
+			d.sb, _ = block.ProbeFS(f)
+			f.Close()
+		}
+	}
+
+	if !part {
+		for _, p := range partitions(dir) {
+			d.children = append(d.children, t.read(filepath.Join(dir, p), p, true, d))
+		}
+	}
+	for _, h := range names(dir, "holders") {
+		d.children = append(d.children, t.read(filepath.Join(sysfs, "block", h), h, false, d))
+	}
+	return d
+}
+
+// partitions are the partitions of the disk in dir, in order
+func partitions(dir string) []string {
+	type partition struct {
+		name string
+		n    int
+	}
+	var parts []partition
+	for _, name := range names(dir) {
+		if s := readString(dir, name, "partition"); s != "" {
+			n, _ := strconv.Atoi(s)
+			parts = append(parts, partition{name, n})
+		}
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].n < parts[j].n })
+	var p []string
+	for _, part := range parts {
+		p = append(p, part.name)
+	}
+	return p
+}
+
+// devices reads all block devices. Those stacked on others, like device
+// mapper devices, are only found below what they stack on.
+func (t *tree) devices() ([]*device, error) {
+	infos, err := mount.Mountinfo()
+	if err != nil {
+		return nil, err
+	}
+	t.mounts = map[string][]string{}
+	for _, m := range infos {
+		mm := strconv.Itoa(int(m.Major)) + ":" + strconv.Itoa(int(m.Minor))
+		t.mounts[mm] = append(t.mounts[mm], m.Path)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(sysfs, "block"))
+	if err != nil {
+		return nil, err
+	}
+	var devs []*device
+	for _, e := range entries {
+		dir := filepath.Join(sysfs, "block", e.Name())
+		if len(names(dir, "slaves")) > 0 {
+			continue
+		}
+		d := t.read(dir, e.Name(), false, nil)
+		if d.size == 0 && !t.all {
+			continue
+		}
+		devs = append(devs, d)
+	}
+	return devs, nil
+}
+
+// find finds the device named kname in devs
+func find(devs []*device, kname string) *device {
+	for _, d := range devs {
+		if d.kname == kname {
+			return d
+		}
+		if c := find(d.children, kname); c != nil {
+			return c
+		}
+	}
+	return nil
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/kdomanski/iso9660"
+)
+
+// The File of kdomanski/iso9660 knows Rock Ridge 1.10 only, when images
+// made by go-diskfs, and mkfs, have 1.12, and it knows no symlinks. So
+// directories are walked here, with its records and System Use entries.
+
+const (
+	flagDir         = 1 << 1
+	flagMultiExtent = 1 << 7
+)
+
+// rockRidge are the extension identifiers of Rock Ridge 1.10 and 1.12
+var rockRidge = map[string]bool{"RRIP_1991A": true, "IEEE_P1282": true, "IEEE_1282": true}
+
+// dir reads the directories of an image
+type dir struct {
+	r io.ReaderAt
+	// the bytes to skip in System Use fields, from the SP entry, if the
+	// image has Rock Ridge
+	skip      int
+	rockRidge bool
+}
+
+// entries splits a System Use field into entries, with those of the
+// continuation areas
+func (d *dir) entries(b []byte) (iso9660.SystemUseEntrySlice, error) {
+	var s iso9660.SystemUseEntrySlice
+	for len(b) >= 4 {
+		n := int(b[2])
+		if n < 4 || n > len(b) {
+			return s, fmt.Errorf("bad System Use entry %q", b[:2])
+		}
+		e := iso9660.SystemUseEntry(b[:n])
+		b = b[n:]
+		if e.Type() != "CE" {
+			s = append(s, e)
+			continue
+		}
+		if n != 28 {
+			return s, fmt.Errorf("bad continuation entry")
+		}
+		area := make([]byte, binary.LittleEndian.Uint32(e[20:]))
+		if _, err := d.r.ReadAt(area, int64(binary.LittleEndian.Uint32(e[4:]))*sectorSize+int64(binary.LittleEndian.Uint32(e[12:]))); err != nil {
+			return s, fmt.Errorf("reading continuation area: %w", err)
+		}
+		more, err := d.entries(area)
+		s = append(s, more...)
+		if err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+// recorded decodes a 7 byte recording time
+func recorded(b []byte) time.Time {
+	if b[1] == 0 {
+		return time.Time{}
+	}
+	zone := time.FixedZone("", int(int8(b[6]))*15*60)
+	return time.Date(1900+int(b[0]), time.Month(b[1]), int(b[2]), int(b[3]), int(b[4]), int(b[5]), 0, zone)
+}
+
+// modified is the modification time in a TF entry, if there is one
+func modified(e iso9660.SystemUseEntry) (time.Time, bool) {
+	flags, b := e[4], e[5:]
+	// long times are of volume descriptors
+	size := 7
+	if flags&0x80 != 0 {
+		size = 17
+	}
+	// creation comes before modification
+	if flags&2 == 0 {
+		return time.Time{}, false
+	}
+	if flags&1 != 0 {
+		b = b[min(size, len(b)):]
+	}
+	if len(b) < size {
+		return time.Time{}, false
+	}
+	if size == 17 {
+		return date(b), true
+	}
+	return recorded(b), true
+}
+
+// target is the target of a symlink, from its SL entries
+func target(s iso9660.SystemUseEntrySlice) string {
+	var t strings.Builder
+	// whether the last component goes on in the next
+	more := true
+	for _, e := range s {
+		if e.Type() != "SL" {
+			continue
+		}
+		for b := e[5:]; len(b) >= 2 && 2+int(b[1]) <= len(b); b = b[2+int(b[1]):] {
+			if !more && t.Len() > 0 && !strings.HasSuffix(t.String(), "/") {
+				t.WriteByte('/')
+			}
+			switch flags := b[0]; {
+			case flags&2 != 0:
+				t.WriteString(".")
+			case flags&4 != 0:
+				t.WriteString("..")
+			case flags&8 != 0:
+				t.WriteString("/")
+			default:
+				t.Write(b[2 : 2+int(b[1])])
+			}
+			more = b[0]&1 != 0
+		}
+	}
+	return t.String()
+}
+
+// name is the name of a record, from Rock Ridge, or its identifier
+// without the version and a trailing dot
+func name(de *iso9660.DirectoryEntry) string {
+	if n := de.SystemUseEntries.GetRockRidgeName(); n != "" {
+		return n
+	}
+	n, _, _ := strings.Cut(de.Identifier, ";")
+	if de.FileFlags&flagDir == 0 {
+		n = strings.TrimSuffix(n, ".")
+	}
+	return n
+}
+
+// record decodes the directory record at the start of b, with its System
+// Use entries
+func (d *dir) record(b []byte) (*iso9660.DirectoryEntry, error) {
+	de := &iso9660.DirectoryEntry{}
+	if len(b) < 34 || int(b[0]) > len(b) || 33+int(b[32]) > int(b[0]) {
+		return nil, fmt.Errorf("bad directory record")
+	}
+	if err := de.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	if d.rockRidge && len(de.SystemUse) > d.skip {
+		var err error
+		if de.SystemUseEntries, err = d.entries(de.SystemUse[d.skip:]); err != nil {
+			return nil, fmt.Errorf("%s: %w", de.Identifier, err)
+		}
+	}
+	return de, nil
+}
+
+// file makes the file at p of a record, and its recording time in b
+func (d *dir) file(de *iso9660.DirectoryEntry, b []byte, p string) file {
+	f := file{
+		Path:    p,
+		Size:    int64(de.ExtentLength),
+		Time:    recorded(b[18:25]),
+		Dir:     de.FileFlags&flagDir != 0,
+		extents: []extent{{int64(de.ExtentLocation) * sectorSize, int64(de.ExtentLength)}},
+	}
+	f.Mode = 0o444
+	if f.Dir {
+		f.Mode = os.ModeDir | 0o555
+	}
+	if d.rockRidge {
+		if m, err := de.SystemUseEntries.GetPosixAttr(); err == nil {
+			f.Mode = m
+		}
+		for _, e := range de.SystemUseEntries {
+			if e.Type() != "TF" {
+				continue
+			}
+			if t, ok := modified(e); ok {
+				f.Time = t
+			}
+		}
+		if f.Mode&os.ModeSymlink != 0 {
+			f.Target, f.Size, f.extents = target(de.SystemUseEntries), 0, nil
+		}
+	}
+	f.Perm = f.Mode.String()
+	return f
+}
+
+// read reads the records of the directory at off, of size bytes. They do
+// not cross sectors, and the rest of a sector after them is zeros.
+func (d *dir) read(off, size int64) ([][]byte, error) {
+	b := make([]byte, size)
+	if _, err := d.r.ReadAt(b, off); err != nil {
+		return nil, err
+	}
+	var records [][]byte
+	for i := 0; i < len(b); {
+		n := int(b[i])
+		if n == 0 {
+			i = (i/sectorSize + 1) * sectorSize
+			continue
+		}
+		if i+n > len(b) {
+			return nil, fmt.Errorf("bad directory record")
+		}
+		records = append(records, b[i:i+n])
+		i += n
+	}
+	return records, nil
+}
+
+// walk lists the directory f, and everything below it
+func (d *dir) walk(f file, files []file) ([]file, error) {
+	records, err := d.read(f.extents[0].off, f.extents[0].size)
+	if err != nil {
+		return files, fmt.Errorf("%s: %w", f.Path, err)
+	}
+	var last *file
+	for _, b := range records {
+		de, err := d.record(b)
+		if err != nil {
+			return files, fmt.Errorf("%s: %w", f.Path, err)
+		}
+		// . and .., and the directories relocated below RR_MOVED, which
+		// are listed where their CL entries are
+		if de.Identifier == "\x00" || de.Identifier == "\x01" || has(de, "RE") {
+			continue
+		}
+		c := d.file(de, b, path.Join(f.Path, name(de)))
+		// the parts of a file of several extents have the same name
+		if last != nil && last.Path == c.Path && !c.Dir {
+			last.extents = append(last.extents, c.extents...)
+			last.Size += c.Size
+			continue
+		}
+		if cl := link(de); cl >= 0 {
+			if c, err = d.relocated(c, cl); err != nil {
+				return files, err
+			}
+		}
+		files = append(files, c)
+		if de.FileFlags&flagMultiExtent != 0 {
+			last = &files[len(files)-1]
+		} else {
+			last = nil
+		}
+		if c.Dir {
+			if files, err = d.walk(c, files); err != nil {
+				return files, err
+			}
+			last = nil
+		}
+	}
+	return files, nil
+}
+
+// has tells whether de has a System Use entry of type t
+func has(de *iso9660.DirectoryEntry, t string) bool {
+	for _, e := range de.SystemUseEntries {
+		if e.Type() == t {
+			return true
+		}
+	}
+	return false
+}
+
+// link is the sector of the directory that a CL entry of de points to, or
+// -1
+func link(de *iso9660.DirectoryEntry) int64 {
+	for _, e := range de.SystemUseEntries {
+		if e.Type() == "CL" && len(e) >= 8 {
+			return int64(binary.LittleEndian.Uint32(e[4:]))
+		}
+	}
+	return -1
+}
+
+// relocated is f, the placeholder of a relocated directory, as the
+// directory at sector, by its . record
+func (d *dir) relocated(f file, sector int64) (file, error) {
+	b := make([]byte, sectorSize)
+	if _, err := d.r.ReadAt(b, sector*sectorSize); err != nil {
+		return f, fmt.Errorf("%s: %w", f.Path, err)
+	}
+	de, err := d.record(b)
+	if err != nil {
+		return f, fmt.Errorf("%s: %w", f.Path, err)
+	}
+	return d.file(de, b, f.Path), nil
+}
+
+// files lists the files of the image in r, from the root directory record
+// of its primary volume descriptor
+func files(r io.ReaderAt, root []byte) ([]file, error) {
+	d := &dir{r: r}
+	de, err := d.record(root)
+	if err != nil {
+		return nil, fmt.Errorf("root directory: %w", err)
+	}
+	// the . record of the root tells whether there is Rock Ridge
+	b := make([]byte, sectorSize)
+	if _, err := r.ReadAt(b, int64(de.ExtentLocation)*sectorSize); err != nil {
+		return nil, fmt.Errorf("root directory: %w", err)
+	}
+	dot, err := d.record(b)
+	if err != nil {
+		return nil, fmt.Errorf("root directory: %w", err)
+	}
+	if su := dot.SystemUse; len(su) >= 7 && string(su[:2]) == "SP" && su[4] == 0xbe && su[5] == 0xef {
+		entries, _ := d.entries(su)
+		ers, _ := entries.GetExtensionRecords()
+		for _, er := range ers {
+			d.rockRidge = d.rockRidge || rockRidge[er.Identifier]
+		}
+		if d.rockRidge {
+			dot.SystemUseEntries, d.skip = entries, int(su[6])
+		}
+	}
+	f := d.file(dot, b, "/")
+	return d.walk(f, []file{f})
+}
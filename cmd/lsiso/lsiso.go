@@ -1,31 +1,72 @@
+// lsiso lists and extracts the files of ISO 9660 images, and shows their
+// volume descriptors.
+//
+// Synopsis:
+//
+//	lsiso [OPTIONS] ISO...
+//
+// Files are listed by their Rock Ridge names, if the image has them. With
+// -l, they are listed with their modes, from Rock Ridge too, sizes and
+// times. -d shows the primary and supplementary volume descriptors and the
+// El Torito boot entries. --json prints all of it as JSON.
+//
+// -x extracts a file, or a directory and everything below it, into the
+// -C directory, by its path in the image. Symlinks are listed with their
+// targets, and not extracted.
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
-	"github.com/diskfs/go-diskfs"
-	"github.com/diskfs/go-diskfs/filesystem"
-	"github.com/diskfs/go-diskfs/filesystem/iso9660"
+	"github.com/jessevdk/go-flags"
+	"github.com/mattn/go-isatty"
 )
 
-func PrintIsoInfo(isoPath string) {
-	disk, err := diskfs.Open(isoPath)
-	if err != nil {
-		log.Fatal(err)
-	}
-	fs, err := disk.GetFilesystem(0)
-	if err != nil {
-		log.Fatal(err)
-	}
+// options are the flags of lsiso
+type options struct {
+	Descriptors bool     `short:"d" long:"descriptors" description:"show the volume descriptors and El Torito boot entries"`
+	Long        bool     `short:"l" long:"long" description:"list modes, sizes and times"`
+	Extract     []string `short:"x" long:"extract" value-name:"PATH" description:"extract PATH, a file or directory, instead of listing"`
+	Directory   string   `short:"C" long:"directory" default:"." value-name:"DIR" description:"extract into DIR"`
+	JSON        bool     `short:"J" long:"json" description:"print JSON"`
+}
 
-	err = fileInfoFor("/", fs)
-	if err != nil {
-		log.Fatalf("Failed to get file info: %s\n", err)
-	}
+// color tells whether modes are highlighted, when stdout is a terminal
+var color = false
+
+// file is a file of the image, as listed
+type file struct {
+	Path    string      `json:"path"`
+	Mode    os.FileMode `json:"-"`
+	Perm    string      `json:"mode"`
+	Size    int64       `json:"size"`
+	Time    time.Time   `json:"time"`
+	Dir     bool        `json:"dir,omitempty"`
+	Target  string      `json:"target,omitempty"`
+	extents []extent
+}
+
+// extent is where a file, or a part of it, is in the image
+type extent struct {
+	off, size int64
+}
+
+// report is all that lsiso knows of an image
+type report struct {
+	Image   string   `json:"image"`
+	Volumes []volume `json:"volumes"`
+	Boot    *boot    `json:"boot,omitempty"`
+	Files   []file   `json:"files,omitempty"`
+	image   *os.File
 }
 
 func hlmode(s string) string {
@@ -37,56 +78,210 @@ func hlmode(s string) string {
 	return str
 }
 
-func fileInfoFor(path string, fs filesystem.FileSystem) error {
-	files, err := fs.ReadDir(path)
+// inspect reads the descriptors and files of the image at name
+func inspect(name string) (*report, error) {
+	f, err := os.Open(name)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	// the files are read later, when extracting
+	r := &report{Image: name, image: f}
+	if r.Volumes, r.Boot, err = descriptors(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if len(r.Volumes) == 0 || r.Volumes[0].Type != "primary" {
+		f.Close()
+		return nil, fmt.Errorf("%s: no primary volume descriptor", name)
+	}
+	if r.Files, err = files(f, r.Volumes[0].root); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return r, nil
+}
+
+// show prints the descriptors and boot entries of r
+func show(w io.Writer, r *report) {
+	when := func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Format("2006-01-02 15:04:05 -07:00")
+	}
+	for _, v := range r.Volumes {
+		fmt.Fprintf(w, "%s%s volume descriptor\n", strings.ToUpper(v.Type[:1]), v.Type[1:])
+		if v.Joliet != 0 {
+			fmt.Fprintf(w, "  Joliet level:     %d\n", v.Joliet)
+		}
+		fmt.Fprintf(w, "  System id:        %s\n", v.System)
+		fmt.Fprintf(w, "  Volume id:        %s\n", v.Volume)
+		fmt.Fprintf(w, "  Volume set id:    %s\n", v.VolumeSet)
+		fmt.Fprintf(w, "  Publisher id:     %s\n", v.Publisher)
+		fmt.Fprintf(w, "  Data preparer id: %s\n", v.Preparer)
+		fmt.Fprintf(w, "  Application id:   %s\n", v.Application)
+		fmt.Fprintf(w, "  Volume size:      %d blocks of %d bytes\n", v.Blocks, v.BlockSize)
+		fmt.Fprintf(w, "  Created:          %s\n", when(v.Created))
+		fmt.Fprintf(w, "  Modified:         %s\n", when(v.Modified))
+	}
+	if r.Boot == nil {
+		return
+	}
+	fmt.Fprintf(w, "El Torito boot catalog at block %d\n", r.Boot.Catalog)
+	for i, e := range r.Boot.Entries {
+		bootable := "not bootable"
+		if e.Bootable {
+			bootable = "bootable"
+		}
+		fmt.Fprintf(w, "  Entry %d: %s, %s, %s, load segment %#04x, %d sectors at block %d\n",
+			i+1, e.Platform, bootable, e.Emulation, e.Segment, e.Sectors, e.Block)
+	}
+}
+
+// list prints the files of r, one path a line, or with -l their modes,
+// sizes and times too
+func (opts *options) list(w io.Writer, r *report) {
+	for _, f := range r.Files {
+		if !opts.Long {
+			fmt.Fprintln(w, f.Path)
+			continue
+		}
+		perm := f.Perm
+		if color {
+			perm = hlmode(perm)
+		}
+		fmt.Fprintf(w, "%s %10d %s %s", perm, f.Size, f.Time.Format("2006-01-02 15:04"), f.Path)
+		if f.Target != "" {
+			fmt.Fprintf(w, " -> %s", f.Target)
+		}
+		fmt.Fprintln(w)
 	}
+}
 
-	for _, file := range files {
-		fullPath := filepath.Join(path, file.Name())
-		if file.IsDir() {
-			err = fileInfoFor(fullPath, fs)
-			if err != nil {
+// extract writes the file at p in r, or the directory and everything below
+// it, into dir
+func extract(r *report, p, dir string) error {
+	p = path.Join("/", p)
+	found := false
+	for _, f := range r.Files {
+		if f.Path != p && !strings.HasPrefix(f.Path, strings.TrimSuffix(p, "/")+"/") {
+			continue
+		}
+		found = true
+		dst := filepath.Join(dir, filepath.FromSlash(f.Path))
+		switch {
+		case f.Mode&os.ModeSymlink != 0:
+			log.Printf("%s: skipping symlink", f.Path)
+			continue
+		case f.Dir:
+			// writable while extracting, its mode is set below
+			if err := os.MkdirAll(dst, 0o700); err != nil {
 				return err
 			}
 			continue
 		}
-		isoFile, err := fs.OpenFile(fullPath, os.O_RDONLY)
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
 		if err != nil {
-			fmt.Printf("Failed to open file %s: %v\n", fullPath, err)
+			return err
+		}
+		var parts []io.Reader
+		for _, e := range f.extents {
+			parts = append(parts, io.NewSectionReader(r.image, e.off, e.size))
+		}
+		if _, err := io.Copy(out, io.MultiReader(parts...)); err != nil {
+			out.Close()
+			return fmt.Errorf("%s: %w", f.Path, err)
+		}
+		if err := out.Close(); err != nil {
+			return err
+		}
+	}
+	if !found {
+		return fmt.Errorf("%s: %w", p, os.ErrNotExist)
+	}
+	// directories last, so that their times stay, deepest first
+	for i := len(r.Files) - 1; i >= 0; i-- {
+		f := r.Files[i]
+		// the root is dir
+		if f.Path == "/" || f.Mode&os.ModeSymlink != 0 || (f.Path != p && !strings.HasPrefix(f.Path, strings.TrimSuffix(p, "/")+"/")) {
 			continue
 		}
-
-		myFile := isoFile.(*iso9660.File)
-		m := fmt.Sprintf("%s", myFile.Mode())
-		mode := hlmode(m)
-		str := fmt.Sprintf("%v %-11v %-11v %-5v", mode, file.Size(), myFile.Location(), fullPath)
-		str = strings.ToLower(str)
-		fmt.Println(str)
-		// fmt.Printf("%s\n Size: %d\n Location: %d\n\n", fullPath, file.Size(), myFile.Location())
+		dst := filepath.Join(dir, filepath.FromSlash(f.Path))
+		if err := os.Chmod(dst, f.Mode.Perm()); err != nil {
+			return err
+		}
+		if err := os.Chtimes(dst, f.Time, f.Time); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func printHelp() {
-	fmt.Println("usage: lsiso <path-to-isos>")
-	fmt.Println("\tsupports listing more than one iso at a time")
+// run lists, shows or extracts each image of args. It goes on to the next
+// image after an error, and returns them all.
+func run(w io.Writer, opts options, args []string) error {
+	var errs []error
+	var reports []*report
+	for i, name := range args {
+		r, err := inspect(name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if opts.JSON {
+			r.image.Close()
+			reports = append(reports, r)
+			continue
+		}
+		if len(args) > 1 && (opts.Descriptors || len(opts.Extract) == 0) {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "%s:\n", name)
+		}
+		if opts.Descriptors {
+			show(w, r)
+		}
+		for _, p := range opts.Extract {
+			if err := extract(r, p, opts.Directory); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+		}
+		if len(opts.Extract) == 0 && (!opts.Descriptors || opts.Long) {
+			opts.list(w, r)
+		}
+		r.image.Close()
+	}
+	if opts.JSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(reports); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
 }
 
 func main() {
-	if len(os.Args) <= 1 {
-		printHelp()
+	var opts options
+	args, err := flags.Parse(&opts)
+	if flags.WroteHelp(err) {
 		os.Exit(0)
 	}
+	if err != nil {
+		os.Exit(1)
+	}
+	log.SetFlags(0)
+	log.SetPrefix("lsiso: ")
+	if len(args) == 0 {
+		log.Fatal("usage: lsiso [OPTIONS] ISO...")
+	}
+	color = isatty.IsTerminal(os.Stdout.Fd())
 
-	for _, file := range os.Args[1:] {
-		switch file {
-		case "-h", "--help":
-			printHelp()
-			os.Exit(0)
-		default:
-			PrintIsoInfo(file)
-		}
+	if err := run(os.Stdout, opts, args); err != nil {
+		log.Fatal(err)
 	}
 }
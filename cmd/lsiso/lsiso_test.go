@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/diskfs/go-diskfs/filesystem/iso9660"
+	kiso "github.com/kdomanski/iso9660"
+)
+
+// image makes an ISO with go-diskfs, with or without Rock Ridge, holding a
+// file, a boot image and a directory with a file
+func image(t *testing.T, rockRidge bool) string {
+	t.Helper()
+	dir := t.TempDir()
+	ws := filepath.Join(dir, "ws")
+	if err := os.MkdirAll(filepath.Join(ws, "sub"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{
+		"hello.txt":    []byte("hello\n"),
+		"boot.img":     bytes.Repeat([]byte{0x90}, 2048),
+		"sub/deep.txt": []byte("deep\n"),
+	} {
+		if err := os.WriteFile(filepath.Join(ws, name), data, 0o640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	name := filepath.Join(dir, "test.iso")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fs, err := iso9660.Create(f, 1<<20, 0, 2048, ws)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Finalize(iso9660.FinalizeOptions{
+		RockRidge:        rockRidge,
+		VolumeIdentifier: "TESTVOL",
+		ElTorito: &iso9660.ElTorito{
+			HideBootCatalog: true,
+			Platform:        iso9660.BIOS,
+			Entries: []*iso9660.ElToritoEntry{
+				{Platform: iso9660.BIOS, Emulation: iso9660.NoEmulation, BootFile: "/boot.img", LoadSize: 4},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// lsiso runs run with opts, extracting into . by default
+func lsiso(t *testing.T, args []string, opts options) (string, error) {
+	t.Helper()
+	if opts.Directory == "" {
+		opts.Directory = "."
+	}
+	var out bytes.Buffer
+	err := run(&out, opts, args)
+	return out.String(), err
+}
+
+func TestRun(t *testing.T) {
+	rr, plain := image(t, true), image(t, false)
+	for _, tt := range []struct {
+		name string
+		args []string
+		opts options
+		// color highlights modes, as on a terminal
+		color bool
+		want  []string
+		not   []string
+	}{
+		{
+			name: "list",
+			args: []string{rr},
+			want: []string{"/\n", "/hello.txt\n", "/boot.img\n", "/sub\n", "/sub/deep.txt\n"},
+			not:  []string{"Primary"},
+		},
+		{
+			name: "list without Rock Ridge",
+			args: []string{plain},
+			want: []string{"/HELLO.TXT\n", "/SUB/DEEP.TXT\n"},
+		},
+		{
+			name: "long",
+			args: []string{rr},
+			opts: options{Long: true},
+			want: []string{"-rw-r-----          6 ", " /hello.txt\n", "drwxr-x--- ", " /sub\n"},
+			not:  []string{"\x1b["},
+		},
+		{
+			name: "long without Rock Ridge",
+			args: []string{plain},
+			opts: options{Long: true},
+			want: []string{"-r--r--r--          6 ", "dr-xr-xr-x "},
+		},
+		{
+			name:  "color",
+			args:  []string{rr},
+			opts:  options{Long: true},
+			color: true,
+			want:  []string{"\x1b[33mr\x1b[0m"},
+		},
+		{
+			name: "descriptors",
+			args: []string{rr},
+			opts: options{Descriptors: true},
+			want: []string{
+				"Primary volume descriptor\n",
+				"  Volume id:        TESTVOL\n",
+				"blocks of 2048 bytes\n",
+				"El Torito boot catalog at block ",
+				"Entry 1: x86, bootable, no emulation, load segment 0x0000, 4 sectors at block ",
+			},
+			not: []string{"/hello.txt"},
+		},
+		{
+			name: "descriptors and long",
+			args: []string{rr},
+			opts: options{Descriptors: true, Long: true},
+			want: []string{"Volume id:        TESTVOL\n", " /hello.txt\n"},
+		},
+		{
+			name: "several",
+			args: []string{rr, plain},
+			want: []string{rr + ":\n/\n", "\n\n" + plain + ":\n/\n"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			color = tt.color
+			defer func() { color = false }()
+			out, err := lsiso(t, tt.args, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(out, w) {
+					t.Errorf("output has no %q:\n%s", w, out)
+				}
+			}
+			for _, n := range tt.not {
+				if strings.Contains(out, n) {
+					t.Errorf("output has %q:\n%s", n, out)
+				}
+			}
+		})
+	}
+}
+
+func TestJSON(t *testing.T) {
+	rr := image(t, true)
+	out, err := lsiso(t, []string{rr}, options{JSON: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reports []report
+	if err := json.Unmarshal([]byte(out), &reports); err != nil {
+		t.Fatalf("%v:\n%s", err, out)
+	}
+	if len(reports) != 1 {
+		t.Fatalf("got %d reports, want 1", len(reports))
+	}
+	r := reports[0]
+	if r.Image != rr || len(r.Volumes) == 0 || r.Volumes[0].Volume != "TESTVOL" {
+		t.Errorf("got %+v, want the primary volume TESTVOL of %s", r, rr)
+	}
+	if r.Boot == nil || len(r.Boot.Entries) != 1 || r.Boot.Entries[0].Sectors != 4 {
+		t.Errorf("got boot %+v, want one entry of 4 sectors", r.Boot)
+	}
+	var paths []string
+	for _, f := range r.Files {
+		paths = append(paths, f.Path+" "+f.Perm)
+	}
+	want := "/ drwxr-x---,/boot.img -rw-r-----,/hello.txt -rw-r-----,/sub drwxr-x---,/sub/deep.txt -rw-r-----"
+	if got := strings.Join(paths, ","); got != want {
+		t.Errorf("got files %q, want %q", got, want)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	rr := image(t, true)
+	dir := t.TempDir()
+	_, err := lsiso(t, []string{rr}, options{Extract: []string{"sub", "/hello.txt"}, Directory: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"hello.txt": "hello\n", "sub/deep.txt": "deep\n"} {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want {
+			t.Errorf("%s: got %q, want %q", name, b, want)
+		}
+	}
+	fi, err := os.Stat(filepath.Join(dir, "sub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o750 {
+		t.Errorf("sub: got mode %v, want 0750", fi.Mode().Perm())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "boot.img")); !os.IsNotExist(err) {
+		t.Errorf("boot.img is extracted: %v", err)
+	}
+
+	_, err = lsiso(t, []string{rr}, options{Extract: []string{"/nothere"}, Directory: dir})
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got %v, want an error for /nothere", err)
+	}
+}
+
+func TestBadImages(t *testing.T) {
+	rr := image(t, true)
+	junk := filepath.Join(t.TempDir(), "junk")
+	if err := os.WriteFile(junk, make([]byte, 40960), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := lsiso(t, []string{"/does/not/exist", junk, rr}, options{})
+	if err == nil {
+		t.Fatal("got no error")
+	}
+	if !strings.Contains(out, "/hello.txt") {
+		t.Errorf("%s is not listed after the bad images:\n%s", rr, out)
+	}
+	for _, w := range []string{"/does/not/exist", junk + ": sector 16 is not a volume descriptor"} {
+		if !strings.Contains(err.Error(), w) {
+			t.Errorf("got %v, want an error for %s", err, w)
+		}
+	}
+}
+
+func TestJoliet(t *testing.T) {
+	b := make([]byte, sectorSize)
+	b[0] = typeSupplementary
+	copy(b[1:], "CD001")
+	copy(b[88:], "%/E")
+	ucs := func(s string, n int) []byte {
+		out := make([]byte, n)
+		for i, u := range utf16.Encode([]rune(s + strings.Repeat(" ", n))) {
+			if 2*i+2 > n {
+				break
+			}
+			binary.BigEndian.PutUint16(out[2*i:], u)
+		}
+		return out
+	}
+	copy(b[40:], ucs("Vølume", 32))
+	copy(b[318:], ucs("Publisher", 128))
+	binary.LittleEndian.PutUint32(b[80:], 1234)
+	binary.LittleEndian.PutUint16(b[128:], 2048)
+	copy(b[813:], "2024010212304500")
+	b[829] = 4
+	v := parseVolume(b)
+	created := time.Date(2024, 1, 2, 12, 30, 45, 0, time.FixedZone("", 3600))
+	if v.Type != "supplementary" || v.Joliet != 3 || v.Volume != "Vølume" || v.Publisher != "Publisher" ||
+		v.Blocks != 1234 || v.BlockSize != 2048 || !v.Created.Equal(created) || !v.Modified.IsZero() {
+		t.Errorf("got %+v", v)
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	b := make([]byte, sectorSize)
+	// validation entry, x86, with its checksum
+	b[0], b[30], b[31] = 1, 0x55, 0xaa
+	var sum uint16
+	for i := 0; i < 32; i += 2 {
+		sum += binary.LittleEndian.Uint16(b[i:])
+	}
+	binary.LittleEndian.PutUint16(b[28:], -sum)
+	// the default entry, bootable from a 1.44M floppy image
+	b[32], b[33] = 0x88, 2
+	binary.LittleEndian.PutUint16(b[38:], 1)
+	binary.LittleEndian.PutUint32(b[40:], 30)
+	// the last section, EFI, with one entry
+	b[64], b[65], b[66] = 0x91, 0xef, 1
+	b[96] = 0x88
+	binary.LittleEndian.PutUint16(b[102:], 8)
+	binary.LittleEndian.PutUint32(b[104:], 40)
+	entries, err := parseCatalog(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []bootEntry{
+		{Platform: "x86", Bootable: true, Emulation: "1.44M floppy", Sectors: 1, Block: 30},
+		{Platform: "EFI", Bootable: true, Emulation: "no emulation", Sectors: 8, Block: 40},
+	}
+	if len(entries) != len(want) || entries[0] != want[0] || entries[1] != want[1] {
+		t.Errorf("got %+v, want %+v", entries, want)
+	}
+
+	b[28]++
+	if _, err := parseCatalog(b); err == nil {
+		t.Error("a bad checksum is not an error")
+	}
+}
+
+func TestTarget(t *testing.T) {
+	// SL entries: flags, then components of flags, length and content
+	sl := func(flags byte, components ...byte) kiso.SystemUseEntry {
+		return kiso.SystemUseEntry(append([]byte{'S', 'L', byte(5 + len(components)), 1, flags}, components...))
+	}
+	for _, tt := range []struct {
+		entries kiso.SystemUseEntrySlice
+		want    string
+	}{
+		{kiso.SystemUseEntrySlice{sl(0, 0, 5, 'h', 'e', 'l', 'l', 'o')}, "hello"},
+		{kiso.SystemUseEntrySlice{sl(0, 8, 0, 0, 3, 'u', 's', 'r', 0, 3, 'b', 'i', 'n')}, "/usr/bin"},
+		{kiso.SystemUseEntrySlice{sl(0, 4, 0, 0, 1, 'x')}, "../x"},
+		// a component going on in the next SL entry
+		{kiso.SystemUseEntrySlice{sl(1, 1, 3, 'a', 'b', 'c'), sl(0, 0, 3, 'd', 'e', 'f', 0, 1, 'g')}, "abcdef/g"},
+	} {
+		if got := target(tt.entries); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	sectorSize = 2048
+	// volume descriptors start after the system area
+	firstDescriptor = 16
+
+	typeBoot          = 0
+	typePrimary       = 1
+	typeSupplementary = 2
+	typeTerminator    = 255
+
+	elTorito = "EL TORITO SPECIFICATION"
+)
+
+// volume is what lsiso shows of a primary or supplementary volume
+// descriptor
+type volume struct {
+	Type        string    `json:"type"`
+	Joliet      int       `json:"joliet,omitempty"`
+	System      string    `json:"system"`
+	Volume      string    `json:"volume"`
+	VolumeSet   string    `json:"volume_set"`
+	Publisher   string    `json:"publisher"`
+	Preparer    string    `json:"preparer"`
+	Application string    `json:"application"`
+	Blocks      uint32    `json:"blocks"`
+	BlockSize   uint16    `json:"block_size"`
+	Created     time.Time `json:"created"`
+	Modified    time.Time `json:"modified"`
+	// the record of the root directory
+	root []byte
+}
+
+// bootEntry is an El Torito boot catalog entry
+type bootEntry struct {
+	Platform  string `json:"platform"`
+	Bootable  bool   `json:"bootable"`
+	Emulation string `json:"emulation"`
+	Segment   uint16 `json:"load_segment"`
+	Sectors   uint16 `json:"sectors"`
+	Block     uint32 `json:"block"`
+}
+
+// boot is the El Torito boot record and its catalog
+type boot struct {
+	Catalog uint32      `json:"catalog"`
+	Entries []bootEntry `json:"entries"`
+}
+
+var platforms = map[byte]string{0: "x86", 1: "PowerPC", 2: "Mac", 0xef: "EFI"}
+
+var emulations = []string{"no emulation", "1.2M floppy", "1.44M floppy", "2.88M floppy", "hard disk"}
+
+// text decodes an identifier, in UCS-2 for Joliet
+func text(b []byte, joliet bool) string {
+	if joliet {
+		u := make([]uint16, len(b)/2)
+		for i := range u {
+			u[i] = binary.BigEndian.Uint16(b[2*i:])
+		}
+		return strings.TrimRight(string(utf16.Decode(u)), " \x00")
+	}
+	return strings.TrimRight(string(b), " \x00")
+}
+
+// date decodes a volume descriptor date, "YYYYMMDDHHMMSScc" and an offset
+// from GMT in quarters of an hour. The zero time is all zeros.
+func date(b []byte) time.Time {
+	var n [7]int
+	for i, w := range []int{4, 2, 2, 2, 2, 2, 2} {
+		v, err := strconv.Atoi(string(b[:w]))
+		if err != nil {
+			return time.Time{}
+		}
+		n[i], b = v, b[w:]
+	}
+	if n[0] == 0 {
+		return time.Time{}
+	}
+	zone := time.FixedZone("", int(int8(b[0]))*15*60)
+	return time.Date(n[0], time.Month(n[1]), n[2], n[3], n[4], n[5], n[6]*10000000, zone)
+}
+
+// parseVolume reads a primary or supplementary volume descriptor
+func parseVolume(b []byte) volume {
+	v := volume{Type: "primary"}
+	if b[0] == typeSupplementary {
+		v.Type = "supplementary"
+		// the escape sequences of UCS-2 levels 1 to 3
+		if esc := string(b[88:91]); esc[:2] == "%/" && strings.IndexByte("@CE", esc[2]) >= 0 {
+			v.Joliet = strings.IndexByte("@CE", esc[2]) + 1
+		}
+	}
+	j := v.Joliet > 0
+	v.System = text(b[8:40], j)
+	v.Volume = text(b[40:72], j)
+	v.Blocks = binary.LittleEndian.Uint32(b[80:])
+	v.BlockSize = binary.LittleEndian.Uint16(b[128:])
+	v.VolumeSet = text(b[190:318], j)
+	v.Publisher = text(b[318:446], j)
+	v.Preparer = text(b[446:574], j)
+	v.Application = text(b[574:702], j)
+	v.Created = date(b[813:830])
+	v.Modified = date(b[830:847])
+	v.root = append([]byte(nil), b[156:190]...)
+	return v
+}
+
+// parseEntry reads an initial, default or section entry of a boot catalog
+func parseEntry(b []byte, platform byte) bootEntry {
+	e := bootEntry{
+		Platform:  platforms[platform],
+		Bootable:  b[0] == 0x88,
+		Emulation: "unknown",
+		Segment:   binary.LittleEndian.Uint16(b[2:]),
+		Sectors:   binary.LittleEndian.Uint16(b[6:]),
+		Block:     binary.LittleEndian.Uint32(b[8:]),
+	}
+	if e.Platform == "" {
+		e.Platform = fmt.Sprintf("%#x", platform)
+	}
+	if int(b[1]&0xf) < len(emulations) {
+		e.Emulation = emulations[b[1]&0xf]
+	}
+	return e
+}
+
+// parseCatalog reads an El Torito boot catalog: a validation entry, the
+// default entry, then sections of entries, each with a header
+func parseCatalog(b []byte) ([]bootEntry, error) {
+	if b[0] != 1 || b[30] != 0x55 || b[31] != 0xaa {
+		return nil, fmt.Errorf("bad boot catalog validation entry")
+	}
+	var sum uint16
+	for i := 0; i < 32; i += 2 {
+		sum += binary.LittleEndian.Uint16(b[i:])
+	}
+	if sum != 0 {
+		return nil, fmt.Errorf("bad boot catalog checksum")
+	}
+	entries := []bootEntry{parseEntry(b[32:64], b[1])}
+	for off := 64; off+32 <= len(b); {
+		header := b[off : off+32]
+		if header[0] != 0x90 && header[0] != 0x91 {
+			break
+		}
+		count := int(binary.LittleEndian.Uint16(header[2:]))
+		off += 32
+		for i := 0; i < count && off+32 <= len(b); i++ {
+			entries = append(entries, parseEntry(b[off:off+32], header[1]))
+			off += 32
+		}
+		if header[0] == 0x91 {
+			break
+		}
+	}
+	return entries, nil
+}
+
+// descriptors reads the volume descriptors of the image in r, up to the
+// terminator
+func descriptors(r io.ReaderAt) ([]volume, *boot, error) {
+	var vols []volume
+	var bt *boot
+	b := make([]byte, sectorSize)
+	for sector := int64(firstDescriptor); ; sector++ {
+		if _, err := r.ReadAt(b, sector*sectorSize); err != nil {
+			return nil, nil, fmt.Errorf("reading volume descriptor %d: %w", sector, err)
+		}
+		if string(b[1:6]) != "CD001" {
+			return nil, nil, fmt.Errorf("sector %d is not a volume descriptor", sector)
+		}
+		switch b[0] {
+		case typePrimary, typeSupplementary:
+			vols = append(vols, parseVolume(b))
+		case typeBoot:
+			if text(b[7:39], false) != elTorito {
+				continue
+			}
+			bt = &boot{Catalog: binary.LittleEndian.Uint32(b[71:])}
+			cat := make([]byte, sectorSize)
+			if _, err := r.ReadAt(cat, int64(bt.Catalog)*sectorSize); err != nil {
+				return nil, nil, fmt.Errorf("reading the boot catalog: %w", err)
+			}
+			var err error
+			if bt.Entries, err = parseCatalog(cat); err != nil {
+				return nil, nil, err
+			}
+		case typeTerminator:
+			return vols, bt, nil
+		}
+	}
+}
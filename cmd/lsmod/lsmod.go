@@ -1,36 +1,41 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strings"
+
+	"mybox/pkg/kmod"
 )
 
-func main() {
-	file, err := os.Open("/proc/modules")
+// lsmod prints the modules read from r
+func lsmod(w io.Writer, r io.Reader) error {
+	mods, err := kmod.ReadLoadedModules(r)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	defer file.Close()
-
-	fmt.Println("Module                 Size Used Used By")
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		s := strings.Split(scanner.Text(), " ")
-		name, size, used, usedBy := s[0], s[1], s[2], s[3]
-		final := fmt.Sprintf("%-19s %8s %s", name, size, used)
-		if usedBy != "-" {
-			usedBy = usedBy[:len(usedBy)-1]
-			final += fmt.Sprintf(" %s", usedBy)
+	fmt.Fprintln(w, "Module                 Size Used Used By")
+	for _, m := range mods {
+		final := fmt.Sprintf("%-19s %8d %d", m.Name, m.Size, m.Refs)
+		if len(m.UsedBy) > 0 {
+			final += " " + strings.Join(m.UsedBy, ",")
 		}
+		fmt.Fprintln(w, final)
+	}
+	return nil
+}
 
-		fmt.Println(final)
+func main() {
+	file, err := os.Open("/proc/modules")
+	if err != nil {
+		log.Fatal(err)
 	}
+	defer file.Close()
 
-	if err := scanner.Err(); err != nil {
+	if err := lsmod(os.Stdout, file); err != nil {
 		log.Fatal(err)
 	}
 }
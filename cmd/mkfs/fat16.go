@@ -0,0 +1,437 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// go-diskfs only makes FAT32, so FAT16 is made here. The layout is one
+// reserved sector, two FATs and a root directory of 512 entries, then the
+// clusters. Files and directories are written to consecutive clusters.
+const (
+	sectorSize       = 512
+	fat16Reserved    = 1
+	fat16RootEntries = 512
+	fat16RootSectors = fat16RootEntries * 32 / sectorSize
+	fat16MinClusters = 4085
+	fat16MaxClusters = 65524
+)
+
+// directory entry attributes
+const (
+	attrReadOnly = 0x01
+	attrVolume   = 0x08
+	attrDir      = 0x10
+	attrArchive  = 0x20
+	attrLongName = 0x0f
+)
+
+// fat16Layout is where things are in a FAT16 file system
+type fat16Layout struct {
+	sectors        uint32
+	clusterSectors uint32
+	fatSectors     uint32
+	clusters       uint32
+}
+
+// fat16Geometry picks the smallest clusters that keep the count of
+// clusters within what FAT16 can address
+func fat16Geometry(size int64) (fat16Layout, error) {
+	sectors := size / sectorSize
+	if sectors > 0xffffffff {
+		return fat16Layout{}, fmt.Errorf("%d bytes is too big for FAT16", size)
+	}
+	l := fat16Layout{sectors: uint32(sectors)}
+	if l.sectors < fat16Reserved+fat16RootSectors+2 {
+		return l, fmt.Errorf("%d bytes is too small for FAT16", size)
+	}
+	data := l.sectors - fat16Reserved - fat16RootSectors
+	for l.clusterSectors = 1; l.clusterSectors <= 64; l.clusterSectors *= 2 {
+		// the two first entries of the FAT are reserved
+		l.fatSectors = ((data/l.clusterSectors+2)*2 + sectorSize - 1) / sectorSize
+		l.clusters = (data - 2*l.fatSectors) / l.clusterSectors
+		if l.clusters < fat16MinClusters {
+			return l, fmt.Errorf("%d bytes is too small for FAT16", size)
+		}
+		if l.clusters <= fat16MaxClusters {
+			return l, nil
+		}
+	}
+	return l, fmt.Errorf("%d bytes is too big for FAT16", size)
+}
+
+// fat16Size is the size of the smallest FAT16 file system holding root
+func fat16Size(root *node) (int64, error) {
+	size := int64(4 << 20)
+	for {
+		l, err := fat16Geometry(size)
+		if err != nil {
+			return 0, err
+		}
+		// long names take more than one entry
+		cluster := int64(l.clusterSectors) * sectorSize
+		need := (root.usage(cluster, 64) + cluster - 1) / cluster
+		if need <= int64(l.clusters) {
+			return size, nil
+		}
+		size += (need - int64(l.clusters)) * cluster * 11 / 10
+	}
+}
+
+// fat16 writes a FAT16 file system at start of w
+type fat16 struct {
+	w     io.WriterAt
+	start int64
+	fat16Layout
+	fat  []uint16
+	next uint32
+}
+
+func (f *fat16) clusterBytes() int64 {
+	return int64(f.clusterSectors) * sectorSize
+}
+
+// offset is where cluster c is
+func (f *fat16) offset(c uint32) int64 {
+	sector := fat16Reserved + 2*f.fatSectors + fat16RootSectors + (c-2)*f.clusterSectors
+	return f.start + int64(sector)*sectorSize
+}
+
+// alloc chains enough clusters for size bytes, and returns the first, or
+// 0 for an empty file
+func (f *fat16) alloc(size int64) (uint32, error) {
+	n := uint32((size + f.clusterBytes() - 1) / f.clusterBytes())
+	if n == 0 {
+		return 0, nil
+	}
+	first := f.next
+	if first+n-1 > f.clusters+1 {
+		return 0, fmt.Errorf("the file system is full")
+	}
+	for c := first; c < first+n-1; c++ {
+		f.fat[c] = uint16(c + 1)
+	}
+	f.fat[first+n-1] = 0xffff
+	f.next += n
+	return first, nil
+}
+
+// fatTime is t as a FAT time and date. FAT dates start in 1980.
+func fatTime(t time.Time) (uint16, uint16) {
+	if t.Year() < 1980 {
+		t = time.Date(1980, 1, 1, 0, 0, 0, 0, time.Local)
+	}
+	tm := t.Hour()<<11 | t.Minute()<<5 | t.Second()/2
+	date := (t.Year()-1980)<<9 | int(t.Month())<<5 | t.Day()
+	return uint16(tm), uint16(date)
+}
+
+// shortChar maps a character of a long name to one allowed in a short
+// name
+func shortChar(r rune) (byte, bool) {
+	switch {
+	case r >= 'a' && r <= 'z':
+		return byte(r - 'a' + 'A'), true
+	case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', strings.ContainsRune("!#$%&'()-@^_`{}~", r):
+		return byte(r), true
+	}
+	return '_', false
+}
+
+// shortName makes the 8.3 name of name, one not in used. It tells whether
+// name needs long name entries too.
+func shortName(name string, used map[string]bool) ([11]byte, bool) {
+	base, ext := strings.TrimLeft(name, "."), ""
+	if i := strings.LastIndexByte(base, '.'); i >= 0 {
+		base, ext = base[:i], base[i+1:]
+	}
+	exact := base != "" && len(name) == len(base)+len(ext)+min(len(ext), 1)
+	clean := func(s string) []byte {
+		var b []byte
+		for _, r := range s {
+			if r == ' ' || r == '.' {
+				exact = false
+				continue
+			}
+			c, ok := shortChar(r)
+			exact = exact && ok && byte(r) == c
+			b = append(b, c)
+		}
+		return b
+	}
+	b, e := clean(base), clean(ext)
+	if len(e) > 3 {
+		e, exact = e[:3], false
+	}
+
+	var short [11]byte
+	fill := func(b []byte) string {
+		copy(short[:], "           ")
+		copy(short[:8], b)
+		copy(short[8:], e)
+		return string(short[:])
+	}
+	if len(b) <= 8 && len(b) > 0 && !used[fill(b)] {
+		used[string(short[:])] = true
+		return short, !exact
+	}
+	for i := 1; ; i++ {
+		tail := "~" + strconv.Itoa(i)
+		s := fill(append(b[:min(len(b), 8-len(tail)):min(len(b), 8-len(tail))], tail...))
+		if !used[s] {
+			used[s] = true
+			return short, true
+		}
+	}
+}
+
+// checksum is the checksum of a short name in its long name entries
+func checksum(short [11]byte) byte {
+	var sum byte
+	for _, c := range short {
+		sum = (sum&1)<<7 + sum>>1 + c
+	}
+	return sum
+}
+
+// longName makes the long name entries of name, last first as they are
+// on disk
+func longName(name string, short [11]byte) ([]byte, error) {
+	u := utf16.Encode([]rune(name))
+	if len(u) > 255 {
+		return nil, fmt.Errorf("%s: the name is too long for FAT", name)
+	}
+	count := (len(u) + 12) / 13
+	// the name ends in a 0, unless it fills the last entry, then 0xffff
+	padded := append(u, 0)
+	for len(padded) < count*13 {
+		padded = append(padded, 0xffff)
+	}
+	sum := checksum(short)
+	b := make([]byte, 0, count*32)
+	for i := count; i >= 1; i-- {
+		e := make([]byte, 32)
+		e[0] = byte(i)
+		if i == count {
+			e[0] |= 0x40
+		}
+		e[11], e[13] = attrLongName, sum
+		chars := padded[(i-1)*13 : i*13]
+		for j, off := range []int{1, 3, 5, 7, 9, 14, 16, 18, 20, 22, 24, 28, 30} {
+			binary.LittleEndian.PutUint16(e[off:], chars[j])
+		}
+		b = append(b, e...)
+	}
+	return b, nil
+}
+
+// dirEntry makes a short directory entry
+func dirEntry(short [11]byte, attr byte, cluster uint32, size uint32, t time.Time) []byte {
+	e := make([]byte, 32)
+	copy(e, short[:])
+	e[11] = attr
+	tm, date := fatTime(t)
+	binary.LittleEndian.PutUint16(e[14:], tm)
+	binary.LittleEndian.PutUint16(e[16:], date)
+	binary.LittleEndian.PutUint16(e[18:], date)
+	binary.LittleEndian.PutUint16(e[22:], tm)
+	binary.LittleEndian.PutUint16(e[24:], date)
+	binary.LittleEndian.PutUint16(e[26:], uint16(cluster))
+	binary.LittleEndian.PutUint32(e[28:], size)
+	return e
+}
+
+// writeDir writes the children of dir, then dir itself, to its clusters,
+// or to the root directory if it is the root. It returns its first
+// cluster.
+func (f *fat16) writeDir(dir *node, parent uint32, root bool, label []byte) (uint32, error) {
+	type entry struct {
+		n     *node
+		short [11]byte
+		long  []byte
+	}
+	var entries []entry
+	count := 0
+	used := map[string]bool{}
+	for _, c := range dir.children {
+		if c.mode&os.ModeSymlink != 0 {
+			log.Printf("%s: skipping symlink", c.src)
+			continue
+		}
+		e := entry{n: c}
+		var lfn bool
+		e.short, lfn = shortName(c.name, used)
+		if lfn {
+			var err error
+			if e.long, err = longName(c.name, e.short); err != nil {
+				return 0, err
+			}
+		}
+		entries = append(entries, e)
+		count += len(e.long)/32 + 1
+	}
+
+	var self uint32
+	if root {
+		if label != nil {
+			count++
+		}
+		if count > fat16RootEntries {
+			return 0, fmt.Errorf("%d entries do not fit in the root directory of %d", count, fat16RootEntries)
+		}
+	} else {
+		count += 2
+		var err error
+		if self, err = f.alloc(int64(count) * 32); err != nil {
+			return 0, err
+		}
+	}
+
+	var b []byte
+	if root && label != nil {
+		var short [11]byte
+		copy(short[:], label)
+		b = append(b, dirEntry(short, attrVolume, 0, 0, time.Now())...)
+	}
+	if !root {
+		b = append(b, dirEntry([11]byte([]byte(".          ")), attrDir, self, 0, dir.modTime)...)
+		b = append(b, dirEntry([11]byte([]byte("..         ")), attrDir, parent, 0, dir.modTime)...)
+	}
+	for _, e := range entries {
+		var first uint32
+		var attr byte
+		var size uint32
+		var err error
+		if e.n.mode.IsDir() {
+			attr = attrDir
+			first, err = f.writeDir(e.n, self, false, nil)
+		} else {
+			if e.n.size > 0xffffffff {
+				return 0, fmt.Errorf("%s: too big for FAT", e.n.src)
+			}
+			attr, size = attrArchive, uint32(e.n.size)
+			first, err = f.writeFile(e.n)
+		}
+		if err != nil {
+			return 0, err
+		}
+		if e.n.mode&0o222 == 0 {
+			attr |= attrReadOnly
+		}
+		b = append(b, e.long...)
+		b = append(b, dirEntry(e.short, attr, first, size, e.n.modTime)...)
+	}
+
+	off := f.start + int64(fat16Reserved+2*f.fatSectors)*sectorSize
+	if root {
+		// the root directory may have been used before, so all of it is
+		// written
+		b = append(b, make([]byte, fat16RootSectors*sectorSize-len(b))...)
+	} else {
+		off = f.offset(self)
+		b = append(b, make([]byte, (int64(len(b))+f.clusterBytes()-1)/f.clusterBytes()*f.clusterBytes()-int64(len(b)))...)
+	}
+	if _, err := f.w.WriteAt(b, off); err != nil {
+		return 0, err
+	}
+	return self, nil
+}
+
+// writeFile copies n to its clusters
+func (f *fat16) writeFile(n *node) (uint32, error) {
+	first, err := f.alloc(n.size)
+	if err != nil || first == 0 {
+		return first, err
+	}
+	Debug("%s -> cluster %d", n.src, first)
+	in, err := os.Open(n.src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	if _, err := io.Copy(io.NewOffsetWriter(f.w, f.offset(first)), io.LimitReader(in, n.size)); err != nil {
+		return 0, fmt.Errorf("%s: %w", n.src, err)
+	}
+	return first, nil
+}
+
+// volumeLabel is label as it is stored, in upper case and padded with
+// spaces, or nil for none
+func volumeLabel(label string) []byte {
+	if label == "" {
+		return nil
+	}
+	b := []byte(strings.ToUpper(label))
+	if len(b) > 11 {
+		b = b[:11]
+	}
+	return append(b, []byte(strings.Repeat(" ", 11-len(b)))...)
+}
+
+// createFAT16 makes a FAT16 file system of size bytes at start of w,
+// holding root
+func createFAT16(w io.WriterAt, start, size int64, label string, root *node) error {
+	l, err := fat16Geometry(size)
+	if err != nil {
+		return err
+	}
+	f := &fat16{w: w, start: start, fat16Layout: l, next: 2}
+	f.fat = make([]uint16, l.fatSectors*sectorSize/2)
+	f.fat[0], f.fat[1] = 0xfff8, 0xffff
+	Debug("FAT16: %d sectors, %d sectors per cluster, %d clusters, %d sectors per FAT", l.sectors, l.clusterSectors, l.clusters, l.fatSectors)
+
+	lbl := volumeLabel(label)
+	if _, err := f.writeDir(root, 0, true, lbl); err != nil {
+		return err
+	}
+
+	boot := make([]byte, sectorSize)
+	copy(boot, []byte{0xeb, 0x3c, 0x90})
+	copy(boot[3:], "MSWIN4.1")
+	binary.LittleEndian.PutUint16(boot[11:], sectorSize)
+	boot[13] = byte(l.clusterSectors)
+	binary.LittleEndian.PutUint16(boot[14:], fat16Reserved)
+	boot[16] = 2
+	binary.LittleEndian.PutUint16(boot[17:], fat16RootEntries)
+	if l.sectors < 0x10000 {
+		binary.LittleEndian.PutUint16(boot[19:], uint16(l.sectors))
+	} else {
+		binary.LittleEndian.PutUint32(boot[32:], l.sectors)
+	}
+	boot[21] = 0xf8
+	binary.LittleEndian.PutUint16(boot[22:], uint16(l.fatSectors))
+	binary.LittleEndian.PutUint16(boot[24:], 32)
+	binary.LittleEndian.PutUint16(boot[26:], 64)
+	binary.LittleEndian.PutUint32(boot[28:], uint32(start/sectorSize))
+	boot[36], boot[38] = 0x80, 0x29
+	// like the other FATs, the volume ID is the time of creation
+	now := time.Now()
+	binary.LittleEndian.PutUint32(boot[39:], uint32(now.Unix()<<20|now.UnixNano()/1000000))
+	if lbl == nil {
+		lbl = []byte("NO NAME    ")
+	}
+	copy(boot[43:], lbl)
+	copy(boot[54:], "FAT16   ")
+	boot[510], boot[511] = 0x55, 0xaa
+	if _, err := w.WriteAt(boot, start); err != nil {
+		return err
+	}
+
+	fat := make([]byte, len(f.fat)*2)
+	for i, e := range f.fat {
+		binary.LittleEndian.PutUint16(fat[2*i:], e)
+	}
+	for i := int64(0); i < 2; i++ {
+		if _, err := w.WriteAt(fat, start+(fat16Reserved+i*int64(l.fatSectors))*sectorSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,16 +1,39 @@
+// mkfs makes a file system in a disk image or on a block device, and
+// fills it from files and directories.
+//
+// Synopsis:
+//
+//	mkfs [OPTIONS] IMAGE [PATH...]
+//
+// The file system is of the -t type: fat32, fat16, iso9660 or squashfs.
+// It holds the contents of the -d directory, if any, and each PATH by its
+// base name. Modes and symlinks are kept where the type allows: squashfs
+// keeps both, iso9660 with Rock Ridge (-R) keeps modes, FAT neither.
+//
+// Without --size, a block device is used whole, and an image is made big
+// enough for its contents. Images of iso9660 and squashfs are then cut to
+// the size of the file system.
+//
+// With --partition gpt, IMAGE is a disk holding a GPT with one partition,
+// aligned to 1 MiB, for the file system. The partition is an EFI system
+// partition for FAT, and a Linux file system otherwise.
 package main
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 
-	diskfs "github.com/diskfs/go-diskfs"
-	"github.com/diskfs/go-diskfs/disk"
-	"github.com/diskfs/go-diskfs/filesystem"
-	"github.com/diskfs/go-diskfs/filesystem/squashfs"
+	"github.com/diskfs/go-diskfs/filesystem/fat32"
+	"github.com/diskfs/go-diskfs/filesystem/iso9660"
 	"github.com/jessevdk/go-flags"
+
+	"mybox/pkg/mount/gpt"
 )
 
 const (
@@ -28,109 +51,369 @@ const (
 	NoOfLogicalBlocksForGPTHeader = 1
 )
 
-var opts struct {
-	Label   string `short:"l" long:"label" description:"filesystem label"`
-	FsType  string `short:"f" long:"filesystem" description:"filesystem type [fat32|squashfs|iso]"`
-	Verbose bool   `short:"v" long:"verbose" description:"print debugging information and verbose output"`
+// options are the flags of mkfs
+type options struct {
+	Label      string `short:"l" long:"label" description:"filesystem label"`
+	FsType     string `short:"t" long:"type" default:"fat32" description:"filesystem type [fat32|fat16|iso9660|squashfs]"`
+	Filesystem string `short:"f" long:"filesystem" hidden:"true" description:"same as -t"`
+	Size       string `short:"s" long:"size" description:"filesystem size in bytes, or with a K, M or G suffix"`
+	Directory  string `short:"d" long:"directory" value-name:"DIR" description:"copy the contents of DIR into the filesystem"`
+	Partition  string `short:"p" long:"partition" choice:"gpt" description:"make a partitioned disk image"`
+	RockRidge  bool   `short:"R" long:"rock-ridge" description:"iso9660: keep long names, modes and symlinks with Rock Ridge"`
+	Boot       string `short:"b" long:"eltorito-boot" value-name:"PATH" description:"iso9660: El Torito BIOS boot image, a path in the filesystem"`
+	EFIBoot    string `short:"e" long:"efi-boot" value-name:"PATH" description:"iso9660: El Torito EFI boot image, a path in the filesystem"`
+	Catalog    string `short:"c" long:"eltorito-catalog" value-name:"PATH" description:"iso9660: path of the El Torito boot catalog"`
+	Verbose    bool   `short:"v" long:"verbose" description:"print debugging information and verbose output"`
 }
 
 var Debug = func(string, ...interface{}) {}
 
-func makeem(ff filesystem.Type, args []string) error {
-	fspec := disk.FilesystemSpec{Partition: 0, VolumeLabel: opts.Label}
-	fspec.FSType = ff
+const (
+	mib = 1 << 20
+	// go-diskfs makes FAT32 with clusters of one sector up to this size,
+	// and Linux wants 65525 clusters at least
+	fat32SmallSize = 260 * mib
+	fat32MinSize   = 34 * mib
+)
 
-	d, err := diskfs.Open(args[0])
-	if err != nil {
-		return fmt.Errorf("error opening disk %s: %w", args[0], err)
+// fsType is the name of the -t type t
+func fsType(t string) (string, error) {
+	switch strings.ToLower(t) {
+	case "fat", "fat32", "vfat":
+		return "fat32", nil
+	case "fat16":
+		return "fat16", nil
+	case "iso", "iso9660":
+		return "iso9660", nil
+	case "squash", "squashfs":
+		return "squashfs", nil
 	}
+	return "", fmt.Errorf("unsupported file system type: %v", t)
+}
 
-	fspec.FSType = filesystem.TypeFat32
-	if _, err := d.CreateFilesystem(fspec); err != nil {
-		return fmt.Errorf("error creating filesystem: %w", err)
+// parseSize reads a size in bytes, or in K, M or G
+func parseSize(s string) (int64, error) {
+	shift := 0
+	num := s
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K', 'k':
+			shift = 10
+		case 'M', 'm':
+			shift = 20
+		case 'G', 'g':
+			shift = 30
+		}
+	}
+	if shift > 0 {
+		num = s[:len(s)-1]
 	}
-	return nil
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n <= 0 || n > 1<<(62-shift) {
+		return 0, fmt.Errorf("bad size %q", s)
+	}
+	return n << shift, nil
 }
 
-func Mkfs(args []string) error {
-	switch strings.ToLower(opts.FsType) {
+// estimate is the size of a file system of type typ holding root
+func estimate(typ string, root *node) (int64, error) {
+	switch typ {
+	case "fat32":
+		// a FAT entry for each cluster, and some slack
+		size := int64(0)
+		for _, cluster := range []int64{512, 4096} {
+			data := root.usage(cluster, 64)
+			size = (data+2*data/cluster*4)*11/10 + mib
+			if size <= fat32SmallSize {
+				break
+			}
+		}
+		return max(size, fat32MinSize), nil
+	case "fat16":
+		return fat16Size(root)
+	case "iso9660":
+		// the system area, descriptors and path tables fit in 1 MiB
+		return root.usage(2048, 256)*11/10 + mib, nil
+	default:
+		// uncompressed, with the tables in 1 MiB
+		return root.usage(4096, 64)*11/10 + mib, nil
+	}
+}
 
-	case "fat", "fat32", "vfat":
-		makeem(filesystem.TypeFat32, args)
+// used is how much of the file system of type typ at start of f was
+// written, for the types that are written once
+func used(f io.ReaderAt, typ string, start int64) (int64, error) {
+	switch typ {
+	case "iso9660":
+		// the volume space size of the primary volume descriptor
+		var b [4]byte
+		if _, err := f.ReadAt(b[:], start+16*2048+80); err != nil {
+			return 0, err
+		}
+		return int64(binary.LittleEndian.Uint32(b[:])) * 2048, nil
+	case "squashfs":
+		// bytes_used of the superblock
+		var b [8]byte
+		if _, err := f.ReadAt(b[:], start+40); err != nil {
+			return 0, err
+		}
+		return (int64(binary.LittleEndian.Uint64(b[:])) + 4095) &^ 4095, nil
+	}
+	return 0, errors.ErrUnsupported
+}
 
-	case "iso", "iso9660":
-		makeem(filesystem.TypeISO9660, args)
+// section is f from start on, for the go-diskfs file systems that are
+// written at the beginning of their file whatever their start
+type section struct {
+	f     *os.File
+	start int64
+}
 
-	case "squash", "squashfs":
-		return CreateSquashfs(args[0], args[1:])
+func (s section) ReadAt(b []byte, off int64) (int, error) {
+	return s.f.ReadAt(b, s.start+off)
+}
 
-	default:
-		return fmt.Errorf("unsupported file system type: %v", opts.FsType)
+func (s section) WriteAt(b []byte, off int64) (int, error) {
+	return s.f.WriteAt(b, s.start+off)
+}
+
+func (s section) Seek(off int64, whence int) (int64, error) {
+	if whence == io.SeekStart {
+		off += s.start
 	}
-	return nil
+	n, err := s.f.Seek(off, whence)
+	return n - s.start, err
 }
 
-func CreateSquashfs(diskImg string, contents []string) error {
-	if diskImg == "" {
-		log.Fatal("must have a valid path for diskImg")
+// create makes the file system of type typ of size bytes at start of f
+func (opts *options) create(f *os.File, typ string, start, size int64, root *node) error {
+	switch typ {
+	case "fat32":
+		fs, err := fat32.Create(f, size, start, 512, opts.Label)
+		if err != nil {
+			return err
+		}
+		return root.populate(fs, "")
+	case "fat16":
+		return createFAT16(f, start, size, opts.Label, root)
+	case "iso9660":
+		return opts.createISO(section{f, start}, size, root)
+	}
+	if opts.Label != "" {
+		log.Printf("squashfs has no label, ignoring %q", opts.Label)
 	}
-	var diskSize int64 = 10 * 1024 * 1024 // 10 MB
-	mydisk, err := diskfs.Create(diskImg, diskSize, diskfs.Raw, diskfs.SectorSizeDefault)
+	return createSquashfs(f, start, size, root)
+}
+
+// createISO makes an ISO 9660 file system, with the El Torito and Rock
+// Ridge options
+func (opts *options) createISO(f section, size int64, root *node) error {
+	ws, err := os.MkdirTemp("", "mkfs")
 	if err != nil {
 		return err
 	}
-
-	fspec := disk.FilesystemSpec{Partition: 0, FSType: filesystem.TypeSquashfs, VolumeLabel: opts.Label}
-	fs, err := mydisk.CreateFilesystem(fspec)
+	defer os.RemoveAll(ws)
+	// go-diskfs writes symlinks as files
+	if err := root.copyTo(ws, false); err != nil {
+		return err
+	}
+	fs, err := iso9660.Create(f, size, 0, 2048, ws)
 	if err != nil {
 		return err
 	}
 
-	for _, spfile := range contents {
-		content, err := os.ReadFile(spfile)
-		if err != nil {
-			fmt.Println(err)
+	fo := iso9660.FinalizeOptions{RockRidge: opts.RockRidge, DeepDirectories: opts.RockRidge, VolumeIdentifier: opts.Label}
+	var entries []*iso9660.ElToritoEntry
+	for _, b := range []struct {
+		path     string
+		platform iso9660.Platform
+	}{
+		{opts.Boot, iso9660.BIOS},
+		{opts.EFIBoot, iso9660.EFI},
+	} {
+		if b.path == "" {
+			continue
 		}
+		p := "/" + strings.TrimLeft(b.path, "/")
+		if _, err := os.Stat(ws + p); err != nil {
+			return fmt.Errorf("boot image %s is not in the file system", b.path)
+		}
+		e := &iso9660.ElToritoEntry{Platform: b.platform, Emulation: iso9660.NoEmulation, BootFile: p}
+		if b.platform == iso9660.BIOS {
+			// like -no-emul-boot -boot-load-size 4 -boot-info-table
+			e.LoadSize, e.BootTable = 4, true
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) > 0 {
+		// go-diskfs can not list the catalog with Rock Ridge, it looks
+		// for it in the workspace
+		fo.ElTorito = &iso9660.ElTorito{BootCatalog: opts.Catalog, HideBootCatalog: opts.RockRidge, Entries: entries, Platform: entries[0].Platform}
+	} else if opts.Catalog != "" {
+		return fmt.Errorf("a boot catalog needs a boot image")
+	}
+	return fs.Finalize(fo)
+}
+
+// partition writes a GPT to f, a disk of size bytes, with one partition
+// of fsSize bytes at GPTPartitionStartByte for a file system of type typ
+func (opts *options) partition(f *os.File, typ string, size, fsSize int64) error {
+	sectors := uint64(size / gpt.BlockSize)
+	disk, err := gpt.NewGUID()
+	if err != nil {
+		return err
+	}
+	p, err := gpt.Create(sectors, disk)
+	if err != nil {
+		return err
+	}
+	part := &p.Primary.Parts[0]
+	part.PartGUID = gpt.Types["linux"]
+	if strings.HasPrefix(typ, "fat") {
+		part.PartGUID = gpt.Types["esp"]
+	}
+	if part.UniqueGUID, err = gpt.NewGUID(); err != nil {
+		return err
+	}
+	part.FirstLBA = GPTPartitionStartByte / gpt.BlockSize
+	part.LastLBA = part.FirstLBA + uint64(fsSize/gpt.BlockSize) - 1
+	if part.LastLBA > p.Primary.LastLBA {
+		return fmt.Errorf("a file system of %d bytes does not fit in a partition of a disk of %d bytes", fsSize, size)
+	}
+	if part.Name, err = gpt.NewPartName(opts.Label); err != nil {
+		return err
+	}
+	p.Backup = p.Primary.Mirror()
+	return gpt.Write(f, p)
+}
+
+// Mkfs makes the file system in args[0] holding the files args[1:]
+func Mkfs(opts options, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: mkfs [OPTIONS] IMAGE [PATH...]")
+	}
+	t := opts.FsType
+	if opts.Filesystem != "" {
+		t = opts.Filesystem
+	}
+	typ, err := fsType(t)
+	if err != nil {
+		return err
+	}
+	if typ != "iso9660" && (opts.RockRidge || opts.Boot != "" || opts.EFIBoot != "" || opts.Catalog != "") {
+		return fmt.Errorf("Rock Ridge and El Torito are for iso9660, not %s", typ)
+	}
+	root, err := content(opts.Directory, args[1:])
+	if err != nil {
+		return err
+	}
+
+	start, trailer := int64(0), int64(0)
+	if opts.Partition != "" {
+		// the backup GPT goes in the last MiB
+		start, trailer = GPTPartitionStartByte, mib
+	}
+
+	image := args[0]
+	device := false
+	if fi, err := os.Stat(image); err == nil && fi.Mode()&os.ModeDevice != 0 {
+		device = true
+	}
+	flag := os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	if device {
+		flag = os.O_RDWR
+	}
+	f, err := os.OpenFile(image, flag, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
 
-		rw, err := fs.OpenFile(spfile, os.O_CREATE|os.O_RDWR)
-		_, err = rw.Write(content)
+	var size int64
+	auto := false
+	switch {
+	case opts.Size != "":
+		if size, err = parseSize(opts.Size); err != nil {
+			return err
+		}
+	case device:
+		end, err := f.Seek(0, io.SeekEnd)
 		if err != nil {
 			return err
 		}
+		if size = end - start - trailer; size <= 0 {
+			return fmt.Errorf("%s is too small", image)
+		}
+	default:
+		if size, err = estimate(typ, root); err != nil {
+			return err
+		}
+		auto = true
 	}
+	if opts.Partition != "" {
+		// the partition ends on a MiB
+		if !device {
+			size += mib - 1
+		}
+		size = size / mib * mib
+	}
+	size = size / sectorSize * sectorSize
+	Debug("%s: %s of %d bytes at %d", image, typ, size, start)
 
-	// rw, err := fs.OpenFile("demo.txt", os.O_CREATE|os.O_RDWR)
-	// content := []byte("demo")
-	// _, err = rw.Write(content)
-	// if err != nil {
-	// 	return err
-	// }
+	if !device {
+		if err := f.Truncate(start + size + trailer); err != nil {
+			return err
+		}
+	}
+	if err := opts.create(f, typ, start, size, root); err != nil {
+		return fmt.Errorf("%s: %w", image, err)
+	}
 
-	sqs, ok := fs.(*squashfs.FileSystem)
-	if !ok {
+	if auto && (typ == "iso9660" || typ == "squashfs") {
+		n, err := used(f, typ, start)
 		if err != nil {
-			return fmt.Errorf("not a squashfs filesystem")
+			return err
+		}
+		if opts.Partition != "" {
+			n = (n + mib - 1) / mib * mib
+		}
+		Debug("%s: %d bytes used", image, n)
+		size = min(n, size)
+		if err := f.Truncate(start + size + trailer); err != nil {
+			return err
 		}
 	}
-
-	err = sqs.Finalize(squashfs.FinalizeOptions{})
-	if err != nil {
-		return err
+	if opts.Partition != "" {
+		total := start + size + trailer
+		if device {
+			if total, err = f.Seek(0, io.SeekEnd); err != nil {
+				return err
+			}
+		}
+		if err := opts.partition(f, typ, total, size); err != nil {
+			return fmt.Errorf("%s: %w", image, err)
+		}
 	}
-	return nil
+	return f.Close()
 }
 
 func main() {
+	var opts options
 	args, err := flags.Parse(&opts)
-	if err != nil {
+	if flags.WroteHelp(err) {
 		os.Exit(0)
 	}
+	if err != nil {
+		os.Exit(1)
+	}
+	log.SetFlags(0)
+	log.SetPrefix("mkfs: ")
 
 	if opts.Verbose {
 		Debug = log.Printf
 	}
 
-	if err := Mkfs(args); err != nil {
+	if err := Mkfs(opts, args); err != nil {
 		log.Fatal(err)
 	}
 }
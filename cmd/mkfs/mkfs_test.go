@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/squashfs"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+)
+
+// big takes many FAT clusters and ISO blocks, and one squashfs block and
+// a fragment: go-diskfs reads no more of squashfs files
+var big = bytes.Repeat([]byte("0123456789abcdef"), 12500)
+
+// tree makes the files to put in the file systems: a directory with a
+// file, a symlink and a subdirectory, and a file outside of it
+func tree(t *testing.T) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	for _, d := range []string{src, filepath.Join(src, "sub")} {
+		if err := os.Mkdir(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []struct {
+		path string
+		data []byte
+		mode os.FileMode
+	}{
+		{"src/hello.txt", []byte("hello\n"), 0o644},
+		{"src/sub/A long file name.text", big, 0o644},
+		{"src/boot.img", bytes.Repeat([]byte{0x90}, 2048), 0o644},
+		{"extra.cfg", []byte("extra\n"), 0o600},
+	} {
+		if err := os.WriteFile(filepath.Join(dir, f.path), f.data, f.mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("hello.txt", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+	return src, filepath.Join(dir, "extra.cfg")
+}
+
+// mkfs runs Mkfs with opts, making FAT32 by default
+func mkfs(t *testing.T, args []string, opts options) error {
+	t.Helper()
+	if opts.FsType == "" {
+		opts.FsType = "fat32"
+	}
+	return Mkfs(opts, args)
+}
+
+// open reads the file system in partition part of image, or in all of it
+// if part is 0
+func open(t *testing.T, image string, part int) filesystem.FileSystem {
+	t.Helper()
+	d, err := diskfs.Open(image, diskfs.WithOpenMode(diskfs.ReadOnly))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.File.Close() })
+	fs, err := d.GetFilesystem(part)
+	if err == nil {
+		return fs
+	}
+	// go-diskfs tries squashfs with blocks of a sector, which are too
+	// small for it
+	start, size := int64(0), d.Size
+	if part != 0 {
+		p := d.Table.GetPartitions()[part-1]
+		start, size = p.GetStart(), p.GetSize()
+	}
+	// nor does it read squashfs in a partition
+	f, err := os.Open(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	if fs, err = squashfs.Read(section{f, start}, size, 0, 0); err != nil {
+		t.Fatalf("reading the file system: %v", err)
+	}
+	return fs
+}
+
+// readFile reads path in fs. It is read in one go, go-diskfs squashfs
+// files lose their place when read in parts.
+func readFile(t *testing.T, fs filesystem.FileSystem, path string) []byte {
+	t.Helper()
+	f, err := fs.OpenFile(path, os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("opening %s: %v", path, err)
+	}
+	b := make([]byte, 1<<20)
+	n, err := f.Read(b)
+	if err != nil && err != io.EOF {
+		t.Fatalf("reading %s: %v", path, err)
+	}
+	return b[:n]
+}
+
+func TestParseSize(t *testing.T) {
+	for _, tt := range []struct {
+		s    string
+		want int64
+	}{
+		{"4096", 4096},
+		{"64K", 64 << 10},
+		{"10m", 10 << 20},
+		{"2G", 2 << 30},
+		{"", 0},
+		{"0", 0},
+		{"-1M", 0},
+		{"10X", 0},
+	} {
+		got, err := parseSize(tt.s)
+		if got != tt.want || (err != nil) != (tt.want == 0) {
+			t.Errorf("parseSize(%q) = %d, %v, want %d", tt.s, got, err, tt.want)
+		}
+	}
+}
+
+func TestMkfs(t *testing.T) {
+	src, extra := tree(t)
+	for _, tt := range []struct {
+		name string
+		opts options
+		part int
+		typ  gpt.Type
+		size int64
+		// the paths as they are read back
+		hello, long, extra string
+		// the file system keeps modes and symlinks
+		modes, symlinks bool
+	}{
+		{
+			name:  "fat32",
+			size:  fat32MinSize,
+			hello: "/hello.txt", long: "/sub/A long file name.text", extra: "/extra.cfg",
+		},
+		{
+			name:  "fat32 of a given size",
+			opts:  options{FsType: "vfat", Size: "40M"},
+			size:  40 << 20,
+			hello: "/hello.txt", long: "/sub/A long file name.text", extra: "/extra.cfg",
+		},
+		{
+			name:  "iso9660",
+			opts:  options{FsType: "iso9660"},
+			hello: "/HELLO.TXT", long: "/SUB/A_LONG_FILE_NAME.TEXT", extra: "/EXTRA.CFG",
+		},
+		{
+			name:  "iso9660 with Rock Ridge",
+			opts:  options{Filesystem: "iso", RockRidge: true},
+			hello: "/hello.txt", long: "/sub/A long file name.text", extra: "/extra.cfg",
+		},
+		{
+			name:  "squashfs",
+			opts:  options{FsType: "squashfs"},
+			hello: "/hello.txt", long: "/sub/A long file name.text", extra: "/extra.cfg",
+			modes: true, symlinks: true,
+		},
+		{
+			name:  "fat32 in a GPT",
+			opts:  options{Partition: "gpt", Label: "EFI"},
+			part:  1,
+			typ:   gpt.EFISystemPartition,
+			size:  fat32MinSize + 2*mib,
+			hello: "/hello.txt", long: "/sub/A long file name.text", extra: "/extra.cfg",
+		},
+		{
+			name:  "squashfs in a GPT",
+			opts:  options{FsType: "squashfs", Partition: "gpt"},
+			part:  1,
+			typ:   gpt.LinuxFilesystem,
+			hello: "/hello.txt", long: "/sub/A long file name.text", extra: "/extra.cfg",
+			modes: true, symlinks: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			image := filepath.Join(t.TempDir(), "fs.img")
+			tt.opts.Directory = src
+			if err := mkfs(t, []string{image, extra}, tt.opts); err != nil {
+				t.Fatalf("Mkfs: %v", err)
+			}
+			if fi, err := os.Stat(image); err != nil || (tt.size != 0 && fi.Size() != tt.size) {
+				t.Errorf("the image is %v, %v, want %d bytes", fi.Size(), err, tt.size)
+			}
+
+			fs := open(t, image, tt.part)
+			if tt.part != 0 {
+				d, err := diskfs.Open(image, diskfs.WithOpenMode(diskfs.ReadOnly))
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer d.File.Close()
+				pt, err := d.GetPartitionTable()
+				if err != nil {
+					t.Fatalf("reading the partition table: %v", err)
+				}
+				if p := pt.(*gpt.Table).Partitions[0]; p.Start != 2048 || p.Type != tt.typ {
+					t.Errorf("partition 1 is %s at %d, want %s at 2048", p.Type, p.Start, tt.typ)
+				}
+			}
+
+			for path, want := range map[string][]byte{tt.hello: []byte("hello\n"), tt.long: big, tt.extra: []byte("extra\n")} {
+				if got := readFile(t, fs, path); !bytes.Equal(got, want) {
+					t.Errorf("%s has %d bytes, want %d", path, len(got), len(want))
+				}
+			}
+			if !tt.modes && !tt.symlinks {
+				return
+			}
+			infos, err := fs.ReadDir("/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			files := map[string]os.FileInfo{}
+			for _, fi := range infos {
+				files[fi.Name()] = fi
+			}
+			if m := files["extra.cfg"].Mode(); m.Perm() != 0o600 {
+				t.Errorf("extra.cfg has mode %v, want 0600", m)
+			}
+			// go-diskfs does not tell symlinks from files, but they are
+			// all 0777
+			if fi := files["link"]; fi == nil || fi.Mode().Perm() != 0o777 {
+				t.Errorf("link is %v, want a symlink to hello.txt", fi)
+			}
+		})
+	}
+}
+
+func TestElTorito(t *testing.T) {
+	src, _ := tree(t)
+	image := filepath.Join(t.TempDir(), "boot.iso")
+	if err := mkfs(t, []string{image}, options{FsType: "iso9660", Label: "BOOT", Boot: "boot.img", EFIBoot: "/boot.img"}); err == nil {
+		t.Errorf("Mkfs of a boot image that is not there: got nil, want an error")
+	}
+	for _, rr := range []bool{false, true} {
+		if err := mkfs(t, []string{image}, options{
+			FsType: "iso9660", RockRidge: rr, Label: "BOOT", Directory: src,
+			Boot: "boot.img", EFIBoot: "/boot.img", Catalog: "/boot.cat",
+		}); err != nil {
+			t.Fatalf("Mkfs -R=%v: %v", rr, err)
+		}
+
+		b, err := os.ReadFile(image)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the boot record volume descriptor follows the primary one
+		if brvd := b[17*2048:]; brvd[0] != 0 || !bytes.HasPrefix(brvd[7:], []byte("EL TORITO SPECIFICATION")) {
+			t.Errorf("-R=%v: no El Torito boot record in sector 17", rr)
+		}
+		if label := strings.TrimRight(string(b[16*2048+40:16*2048+72]), " \x00"); label != "BOOT" {
+			t.Errorf("-R=%v: label is %q, want BOOT", rr, label)
+		}
+		if rr {
+			continue
+		}
+		fs := open(t, image, 0)
+		if got := readFile(t, fs, "/BOOT.CAT"); len(got) < 64 || got[0] != 1 {
+			t.Errorf("the boot catalog is %d bytes, starting with %#x", len(got), got[0])
+		}
+	}
+}
+
+// fat16File finds the file path in the FAT16 file system in b, by its long
+// name, and reads it
+func fat16File(t *testing.T, b []byte, path string) []byte {
+	t.Helper()
+	bps := int(binary.LittleEndian.Uint16(b[11:]))
+	spc := int(b[13])
+	reserved := int(binary.LittleEndian.Uint16(b[14:]))
+	rootEntries := int(binary.LittleEndian.Uint16(b[17:]))
+	fatSectors := int(binary.LittleEndian.Uint16(b[22:]))
+	fat := b[reserved*bps:]
+	rootOff := (reserved + int(b[16])*fatSectors) * bps
+	dataOff := rootOff + rootEntries*32
+	cluster := func(c int) []byte { return b[dataOff+(c-2)*spc*bps : dataOff+(c-1)*spc*bps] }
+	chain := func(c int) []byte {
+		var data []byte
+		for ; c >= 2 && c < 0xfff8; c = int(binary.LittleEndian.Uint16(fat[2*c:])) {
+			data = append(data, cluster(c)...)
+		}
+		return data
+	}
+
+	dir := b[rootOff:dataOff]
+	names := strings.Split(strings.Trim(path, "/"), "/")
+	for i, name := range names {
+		var long []uint16
+		found := false
+		for off := 0; off+32 <= len(dir) && dir[off] != 0; off += 32 {
+			e := dir[off : off+32]
+			if e[11] == attrLongName {
+				var u []uint16
+				for _, o := range []int{1, 3, 5, 7, 9, 14, 16, 18, 20, 22, 24, 28, 30} {
+					u = append(u, binary.LittleEndian.Uint16(e[o:]))
+				}
+				long = append(u, long...)
+				continue
+			}
+			got := strings.TrimRight(string(e[:8]), " ")
+			if ext := strings.TrimRight(string(e[8:11]), " "); ext != "" {
+				got += "." + ext
+			}
+			if long != nil {
+				got = string(utf16.Decode(long))
+				if j := strings.IndexRune(got, 0); j >= 0 {
+					got = got[:j]
+				}
+			}
+			long = nil
+			if got != name {
+				continue
+			}
+			data := chain(int(binary.LittleEndian.Uint16(e[26:])))
+			if i == len(names)-1 {
+				return data[:binary.LittleEndian.Uint32(e[28:])]
+			}
+			dir, found = data, true
+			break
+		}
+		if !found {
+			t.Fatalf("%s: no %s", path, name)
+		}
+	}
+	return nil
+}
+
+func TestFAT16(t *testing.T) {
+	src, extra := tree(t)
+	image := filepath.Join(t.TempDir(), "fat16.img")
+	if err := mkfs(t, []string{image, extra}, options{FsType: "fat16", Label: "small", Directory: src}); err != nil {
+		t.Fatalf("Mkfs: %v", err)
+	}
+	b, err := os.ReadFile(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b[54:62]) != "FAT16   " || string(b[43:54]) != "SMALL      " || b[510] != 0x55 {
+		t.Errorf("boot sector says %q, label %q", b[54:62], b[43:54])
+	}
+	l, err := fat16Geometry(int64(len(b)))
+	if err != nil || l.clusters < fat16MinClusters {
+		t.Errorf("%d bytes make %d clusters, %v", len(b), l.clusters, err)
+	}
+	for path, want := range map[string][]byte{
+		"hello.txt":                 []byte("hello\n"),
+		"sub/A long file name.text": big,
+		"extra.cfg":                 []byte("extra\n"),
+	} {
+		if got := fat16File(t, b, path); !bytes.Equal(got, want) {
+			t.Errorf("%s has %d bytes, want %d", path, len(got), len(want))
+		}
+	}
+
+	// the root directory has room for 512 entries
+	dir := t.TempDir()
+	for i := 0; i < fat16RootEntries; i++ {
+		if err := os.WriteFile(filepath.Join(dir, strings.Repeat("x", 20)+string(rune('a'+i%26))+string(rune('a'+i/26))), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mkfs(t, []string{image}, options{FsType: "fat16", Directory: dir}); err == nil {
+		t.Errorf("Mkfs of %d long names in the root: got nil, want an error", fat16RootEntries)
+	}
+}
+
+func TestShortName(t *testing.T) {
+	used := map[string]bool{}
+	for _, tt := range []struct {
+		name, short string
+		long        bool
+	}{
+		{"HELLO.TXT", "HELLO   TXT", false},
+		{"hello.txt", "HELLO~1 TXT", true},
+		{"README", "README     ", false},
+		{"a long name.html", "ALONGN~1HTM", true},
+		{"a long name.html", "ALONGN~2HTM", true},
+		{".profile", "PROFILE    ", true},
+	} {
+		short, long := shortName(tt.name, used)
+		if string(short[:]) != tt.short || long != tt.long {
+			t.Errorf("shortName(%q) = %q, %v, want %q, %v", tt.name, short, long, tt.short, tt.long)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	src, _ := tree(t)
+	image := filepath.Join(t.TempDir(), "fs.img")
+	for _, tt := range []struct {
+		name string
+		args []string
+		opts options
+	}{
+		{"no image", nil, options{}},
+		{"bad type", []string{image}, options{FsType: "ntfs"}},
+		{"Rock Ridge on FAT", []string{image}, options{RockRidge: true}},
+		{"bad size", []string{image}, options{Size: "big"}},
+		{"too small", []string{image}, options{FsType: "fat16", Size: "1M"}},
+		{"no such file", []string{image, filepath.Join(src, "none")}, options{}},
+		{"twice", []string{image, filepath.Join(src, "hello.txt")}, options{Directory: src}},
+		{"catalog alone", []string{image}, options{FsType: "iso9660", Catalog: "/boot.cat"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := mkfs(t, tt.args, tt.opts); err == nil {
+				t.Errorf("got nil, want an error")
+			}
+		})
+	}
+}
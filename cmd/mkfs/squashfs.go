@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// The squashfs writer of go-diskfs loses data of files of more than a
+// block, so squashfs is made here: version 4.0, gzip, and all files owned
+// by root. The layout is the superblock, the data blocks with the
+// fragment blocks holding the ends of files, the inode table, the
+// directory table, the fragment table and the ID table.
+const (
+	squashfsMagic     = 0x73717368
+	squashfsBlockSize = 128 << 10
+	squashfsBlockLog  = 17
+	squashfsMetaSize  = 8192
+	squashfsGzip      = 1
+
+	squashfsNoXattrs = 0x0200
+
+	squashfsDir     = 1
+	squashfsFile    = 2
+	squashfsSymlink = 3
+
+	// the size of a data block is flagged when it is not compressed, the
+	// header of a metadata block too
+	squashfsDataRaw = 1 << 24
+	squashfsMetaRaw = 0x8000
+	squashfsNone    = 0xffffffffffffffff
+)
+
+// compress compresses b with zlib, or tells it is not worth it
+func compress(b []byte) ([]byte, bool) {
+	var out bytes.Buffer
+	z := zlib.NewWriter(&out)
+	z.Write(b)
+	z.Close()
+	if out.Len() >= len(b) {
+		return b, false
+	}
+	return out.Bytes(), true
+}
+
+// metadata is a table of metadata blocks being written
+type metadata struct {
+	block bytes.Buffer
+	out   bytes.Buffer
+}
+
+// ref is where the next byte goes: the start of its block in the table,
+// and its offset in the block once uncompressed
+func (m *metadata) ref() (uint32, uint16) {
+	return uint32(m.out.Len()), uint16(m.block.Len())
+}
+
+func (m *metadata) Write(b []byte) (int, error) {
+	for n := 0; n < len(b); {
+		c, _ := m.block.Write(b[n:min(len(b), n+squashfsMetaSize-m.block.Len())])
+		n += c
+		if m.block.Len() == squashfsMetaSize {
+			m.flush()
+		}
+	}
+	return len(b), nil
+}
+
+// flush ends the current block
+func (m *metadata) flush() {
+	if m.block.Len() == 0 {
+		return
+	}
+	b, ok := compress(m.block.Bytes())
+	header := uint16(len(b))
+	if !ok {
+		header |= squashfsMetaRaw
+	}
+	binary.Write(&m.out, binary.LittleEndian, header)
+	m.out.Write(b)
+	m.block.Reset()
+}
+
+// squash writes a squashfs at start of w
+type squash struct {
+	w           io.WriterAt
+	start, size int64
+	pos         int64
+	// the fragment block being filled, and the table of those written
+	fragment  []byte
+	fragments metadata
+	nfrag     uint32
+	inodes    metadata
+	dirs      metadata
+	// the number of each inode, in the order they are written
+	number map[*node]uint32
+	count  uint32
+}
+
+// inodeRef is where the inode is, for directory entries and the
+// superblock
+type inodeRef struct {
+	block  uint32
+	offset uint16
+	number uint32
+	typ    uint16
+}
+
+// header writes the common header of inodes
+func (s *squash) header(n *node, typ uint16) error {
+	mode := uint16(n.mode.Perm())
+	if n.mode&os.ModeSetuid != 0 {
+		mode |= 0o4000
+	}
+	if n.mode&os.ModeSetgid != 0 {
+		mode |= 0o2000
+	}
+	if n.mode&os.ModeSticky != 0 {
+		mode |= 0o1000
+	}
+	// uid and gid are both the first ID, 0
+	return binary.Write(&s.inodes, binary.LittleEndian, struct {
+		Type, Mode, UID, GID uint16
+		MTime, Number        uint32
+	}{typ, mode, 0, 0, uint32(n.modTime.Unix()), s.number[n]})
+}
+
+// numberInodes numbers the inodes in the order write writes them, the
+// children of a directory first
+func (s *squash) numberInodes(n *node) {
+	for _, c := range n.children {
+		s.numberInodes(c)
+	}
+	s.count++
+	s.number[n] = s.count
+}
+
+// write writes n, and everything below it if it is a directory
+func (s *squash) write(n *node, parent uint32) (inodeRef, error) {
+	switch {
+	case n.mode&os.ModeSymlink != 0:
+		ref := s.ref(n, squashfsSymlink)
+		if err := s.header(n, squashfsSymlink); err != nil {
+			return ref, err
+		}
+		binary.Write(&s.inodes, binary.LittleEndian, []uint32{1, uint32(len(n.target))})
+		_, err := s.inodes.Write([]byte(n.target))
+		return ref, err
+	case n.mode.IsDir():
+		return s.writeDir(n, parent)
+	}
+	return s.writeFile(n)
+}
+
+func (s *squash) ref(n *node, typ uint16) inodeRef {
+	block, offset := s.inodes.ref()
+	return inodeRef{block: block, offset: offset, number: s.number[n], typ: typ}
+}
+
+// data writes a data block, compressed if it is worth it, and returns its
+// size as in inodes and the fragment table
+func (s *squash) data(b []byte) (uint32, error) {
+	c, ok := compress(b)
+	size := uint32(len(c))
+	if !ok {
+		size |= squashfsDataRaw
+	}
+	if s.pos+int64(len(c)) > s.size {
+		return 0, fmt.Errorf("the file system is full")
+	}
+	if _, err := s.w.WriteAt(c, s.start+s.pos); err != nil {
+		return 0, err
+	}
+	s.pos += int64(len(c))
+	return size, nil
+}
+
+// flushFragment writes the fragment block being filled
+func (s *squash) flushFragment() error {
+	if len(s.fragment) == 0 {
+		return nil
+	}
+	start := s.pos
+	size, err := s.data(s.fragment)
+	if err != nil {
+		return err
+	}
+	binary.Write(&s.fragments, binary.LittleEndian, struct {
+		Start        uint64
+		Size, Unused uint32
+	}{uint64(start), size, 0})
+	s.nfrag++
+	s.fragment = s.fragment[:0]
+	return nil
+}
+
+// writeFile writes the blocks of n, and its end to a fragment block, then
+// its inode
+func (s *squash) writeFile(n *node) (inodeRef, error) {
+	if n.size > 0xffffffff {
+		return inodeRef{}, fmt.Errorf("%s: too big for squashfs", n.src)
+	}
+	Debug("%s -> %d", n.src, s.pos)
+	first := s.pos
+	var sizes []uint32
+	frag, fragOffset := uint32(0xffffffff), uint32(0)
+	if n.size > 0 {
+		in, err := os.Open(n.src)
+		if err != nil {
+			return inodeRef{}, err
+		}
+		defer in.Close()
+		buf := make([]byte, squashfsBlockSize)
+		for left := n.size; left > 0; left -= int64(len(buf)) {
+			buf = buf[:min(left, squashfsBlockSize)]
+			if _, err := io.ReadFull(in, buf); err != nil {
+				return inodeRef{}, fmt.Errorf("%s: %w", n.src, err)
+			}
+			if len(buf) < squashfsBlockSize {
+				if len(s.fragment)+len(buf) > squashfsBlockSize {
+					if err := s.flushFragment(); err != nil {
+						return inodeRef{}, err
+					}
+				}
+				frag, fragOffset = s.nfrag, uint32(len(s.fragment))
+				s.fragment = append(s.fragment, buf...)
+				break
+			}
+			size, err := s.data(buf)
+			if err != nil {
+				return inodeRef{}, err
+			}
+			sizes = append(sizes, size)
+		}
+	}
+
+	ref := s.ref(n, squashfsFile)
+	if err := s.header(n, squashfsFile); err != nil {
+		return ref, err
+	}
+	binary.Write(&s.inodes, binary.LittleEndian, []uint32{uint32(first), frag, fragOffset, uint32(n.size)})
+	return ref, binary.Write(&s.inodes, binary.LittleEndian, sizes)
+}
+
+// writeDir writes the children of n, then its entries in the directory
+// table, then its inode
+func (s *squash) writeDir(n *node, parent uint32) (inodeRef, error) {
+	var refs []inodeRef
+	links := uint32(2)
+	for _, c := range n.children {
+		ref, err := s.write(c, s.number[n])
+		if err != nil {
+			return ref, err
+		}
+		refs = append(refs, ref)
+		if c.mode.IsDir() {
+			links++
+		}
+	}
+
+	start, offset := s.dirs.ref()
+	var listing bytes.Buffer
+	for i := 0; i < len(refs); {
+		// a header for up to 256 entries in the same block of inodes
+		j := i + 1
+		for j < len(refs) && j-i < 256 && refs[j].block == refs[i].block && refs[j].number-refs[i].number < 1<<15 {
+			j++
+		}
+		binary.Write(&listing, binary.LittleEndian, []uint32{uint32(j - i - 1), refs[i].block, refs[i].number})
+		for k := i; k < j; k++ {
+			name := n.children[k].name
+			binary.Write(&listing, binary.LittleEndian, []uint16{refs[k].offset, uint16(int16(refs[k].number - refs[i].number)), refs[k].typ, uint16(len(name) - 1)})
+			listing.WriteString(name)
+		}
+		i = j
+	}
+	// the size counts . and .. of 3 bytes, without their entries
+	if listing.Len()+3 > 0xffff {
+		return inodeRef{}, fmt.Errorf("%s: too many entries for squashfs", n.src)
+	}
+	s.dirs.Write(listing.Bytes())
+
+	ref := s.ref(n, squashfsDir)
+	if err := s.header(n, squashfsDir); err != nil {
+		return ref, err
+	}
+	return ref, binary.Write(&s.inodes, binary.LittleEndian, struct {
+		Start, Links uint32
+		Size, Offset uint16
+		Parent       uint32
+	}{start, links, uint16(listing.Len() + 3), offset, parent})
+}
+
+// createSquashfs makes a squashfs of at most size bytes at start of w,
+// holding root
+func createSquashfs(w io.WriterAt, start, size int64, root *node) error {
+	s := &squash{w: w, start: start, size: size, pos: 96, number: map[*node]uint32{}}
+	s.numberInodes(root)
+	// the parent of the root is one more than the last inode
+	ref, err := s.write(root, s.count+1)
+	if err != nil {
+		return err
+	}
+	if err := s.flushFragment(); err != nil {
+		return err
+	}
+	s.inodes.flush()
+	s.dirs.flush()
+	s.fragments.flush()
+	// the only ID is 0
+	var ids metadata
+	binary.Write(&ids, binary.LittleEndian, uint32(0))
+	ids.flush()
+
+	// the fragment and ID tables are their blocks, then where the blocks
+	// are. A block holds 512 fragments.
+	var tables bytes.Buffer
+	inodeStart := s.pos
+	tables.Write(s.inodes.out.Bytes())
+	dirStart := inodeStart + int64(tables.Len())
+	tables.Write(s.dirs.out.Bytes())
+	fragBlocks := s.pos + int64(tables.Len())
+	tables.Write(s.fragments.out.Bytes())
+	fragStart := s.pos + int64(tables.Len())
+	for off := 0; off < s.fragments.out.Len(); {
+		binary.Write(&tables, binary.LittleEndian, uint64(fragBlocks+int64(off)))
+		off += 2 + int(binary.LittleEndian.Uint16(s.fragments.out.Bytes()[off:])&^squashfsMetaRaw)
+	}
+	idBlock := s.pos + int64(tables.Len())
+	tables.Write(ids.out.Bytes())
+	idStart := s.pos + int64(tables.Len())
+	binary.Write(&tables, binary.LittleEndian, uint64(idBlock))
+	used := s.pos + int64(tables.Len())
+	if used > size {
+		return fmt.Errorf("the file system is full")
+	}
+	if _, err := w.WriteAt(tables.Bytes(), start+inodeStart); err != nil {
+		return err
+	}
+
+	var sb bytes.Buffer
+	binary.Write(&sb, binary.LittleEndian, struct {
+		Magic, Inodes, MTime, BlockSize, Fragments       uint32
+		Compressor, BlockLog, Flags, IDs, Major, Minor   uint16
+		Root, BytesUsed, IDTable, XattrTable, InodeTable uint64
+		DirTable, FragmentTable, ExportTable             uint64
+	}{
+		squashfsMagic, s.count, uint32(time.Now().Unix()), squashfsBlockSize, s.nfrag,
+		squashfsGzip, squashfsBlockLog, squashfsNoXattrs, 1, 4, 0,
+		uint64(ref.block)<<16 | uint64(ref.offset), uint64(used), uint64(idStart), squashfsNone, uint64(inodeStart),
+		uint64(dirStart), uint64(fragStart), squashfsNone,
+	})
+	_, err = w.WriteAt(sb.Bytes(), start)
+	return err
+}
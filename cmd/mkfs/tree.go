@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+)
+
+// node is a file to put in the file system, read from src
+type node struct {
+	name     string
+	src      string
+	mode     os.FileMode
+	size     int64
+	modTime  time.Time
+	target   string
+	children []*node
+}
+
+// readTree reads the tree at src, without following symlinks
+func readTree(src string) (*node, error) {
+	fi, err := os.Lstat(src)
+	if err != nil {
+		return nil, err
+	}
+	n := &node{name: fi.Name(), src: src, mode: fi.Mode(), size: fi.Size(), modTime: fi.ModTime()}
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		if n.target, err = os.Readlink(src); err != nil {
+			return nil, err
+		}
+	case fi.IsDir():
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			c, err := readTree(filepath.Join(src, e.Name()))
+			if err != nil {
+				return nil, err
+			}
+			n.children = append(n.children, c)
+		}
+	case !fi.Mode().IsRegular():
+		return nil, fmt.Errorf("%s: only directories, regular files and symlinks can be copied", src)
+	}
+	return n, nil
+}
+
+// content is the root of the file system: the contents of dir, if not
+// empty, and the files of paths
+func content(dir string, paths []string) (*node, error) {
+	root := &node{mode: os.ModeDir | 0o755, modTime: time.Now()}
+	if dir != "" {
+		d, err := readTree(dir)
+		if err != nil {
+			return nil, err
+		}
+		if !d.mode.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory", dir)
+		}
+		root = d
+		root.name = ""
+	}
+	for _, p := range paths {
+		n, err := readTree(p)
+		if err != nil {
+			return nil, err
+		}
+		root.children = append(root.children, n)
+	}
+	sort.Slice(root.children, func(i, j int) bool { return root.children[i].name < root.children[j].name })
+	for i := 1; i < len(root.children); i++ {
+		if root.children[i].name == root.children[i-1].name {
+			return nil, fmt.Errorf("%s is there twice", root.children[i].name)
+		}
+	}
+	return root, nil
+}
+
+// usage is the space n takes in blocks of block bytes, with entry bytes
+// for each directory entry
+func (n *node) usage(block, entry int64) int64 {
+	round := func(size int64) int64 { return (size + block - 1) / block * block }
+	if !n.mode.IsDir() {
+		return round(n.size)
+	}
+	total := round(int64(len(n.children)+2) * entry)
+	for _, c := range n.children {
+		total += c.usage(block, entry)
+	}
+	return total
+}
+
+// copyTo copies the tree below n into the directory dir, keeping modes,
+// times and, if symlinks, symlinks. dir gets the mode and time of n.
+func (n *node) copyTo(dir string, symlinks bool) error {
+	for _, c := range n.children {
+		dst := filepath.Join(dir, c.name)
+		switch {
+		case c.mode&os.ModeSymlink != 0 && !symlinks:
+			log.Printf("%s: skipping symlink", c.src)
+		case c.mode&os.ModeSymlink != 0:
+			if err := os.Symlink(c.target, dst); err != nil {
+				return err
+			}
+		case c.mode.IsDir():
+			if err := os.Mkdir(dst, 0o700); err != nil {
+				return err
+			}
+			if err := c.copyTo(dst, symlinks); err != nil {
+				return err
+			}
+		default:
+			if err := copyFile(c.src, dst); err != nil {
+				return err
+			}
+			if err := c.chmod(dst); err != nil {
+				return err
+			}
+		}
+	}
+	return n.chmod(dir)
+}
+
+// chmod gives path the mode and time of n
+func (n *node) chmod(path string) error {
+	if err := os.Chmod(path, n.mode.Perm()|n.mode&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return err
+	}
+	return os.Chtimes(path, n.modTime, n.modTime)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// populate copies the tree below n into the directory dir of fs, which
+// keeps neither modes nor symlinks
+func (n *node) populate(fs filesystem.FileSystem, dir string) error {
+	for _, c := range n.children {
+		dst := dir + "/" + c.name
+		switch {
+		case c.mode&os.ModeSymlink != 0:
+			log.Printf("%s: skipping symlink", c.src)
+		case c.mode.IsDir():
+			if err := fs.Mkdir(dst); err != nil {
+				return fmt.Errorf("%s: %w", dst, err)
+			}
+			if err := c.populate(fs, dst); err != nil {
+				return err
+			}
+		default:
+			Debug("%s -> %s", c.src, dst)
+			in, err := os.Open(c.src)
+			if err != nil {
+				return err
+			}
+			out, err := fs.OpenFile(dst, os.O_CREATE|os.O_RDWR)
+			if err != nil {
+				in.Close()
+				return fmt.Errorf("%s: %w", dst, err)
+			}
+			_, err = io.Copy(out, in)
+			in.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %w", dst, err)
+			}
+		}
+	}
+	return nil
+}
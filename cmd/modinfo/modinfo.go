@@ -0,0 +1,192 @@
+// modinfo prints what kernel modules tell of themselves.
+//
+// Synopsis:
+//
+//	modinfo [-F FIELD] [-k VERSION] [-b BASEDIR] [-0] MODULE...
+//
+// MODULE is the path of a module file, compressed or not, or the name of a
+// module or one of its aliases, found in BASEDIR/lib/modules/VERSION.
+// modinfo prints the file name of the module and the entries of its
+// .modinfo section, like license, description, author, depends, vermagic,
+// alias and parm, the type of each parameter after its description. With
+// -F, modinfo only prints the values of FIELD.
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+
+	"mybox/pkg/go-modprobe"
+	"mybox/pkg/kmod"
+)
+
+// options are the flags of modinfo
+type options struct {
+	Field   string `short:"F" long:"field" value-name:"FIELD" description:"only print the values of FIELD"`
+	Version string `short:"k" long:"set-version" value-name:"VERSION" description:"find modules of kernel VERSION, not of the running kernel"`
+	BaseDir string `short:"b" long:"basedir" value-name:"BASEDIR" description:"find modules in BASEDIR/lib/modules"`
+	Null    bool   `short:"0" long:"null" description:"end values with a NUL character, not a newline"`
+}
+
+// isPath tells whether a module is given by its file, not its name
+func isPath(module string) bool {
+	return strings.Contains(module, "/") || strings.Contains(module, ".ko")
+}
+
+// files finds the files of a module name or alias. Builtin modules have
+// no file: their name is returned with ok false.
+func files(k *kmod.Kmod, module string) ([]string, bool, error) {
+	names, err := k.Resolve(module)
+	if err != nil {
+		return nil, false, err
+	}
+	var paths []string
+	for _, name := range names {
+		if k.Builtin(name) {
+			return []string{name}, false, nil
+		}
+		deps, err := k.Dependencies(name)
+		if err != nil {
+			return nil, false, err
+		}
+		// the module comes after its dependencies
+		paths = append(paths, deps[len(deps)-1])
+	}
+	return paths, true, nil
+}
+
+// attrs lists the fields to print of a module, filename first, with the
+// types of the parameters in their descriptions
+func attrs(path string, m *modprobe.Module) []modprobe.Attr {
+	types := map[string]string{}
+	for _, t := range m.Get("parmtype") {
+		if name, typ, ok := strings.Cut(t, ":"); ok {
+			types[name] = typ
+		}
+	}
+
+	list := []modprobe.Attr{{Key: "filename", Value: path}}
+	described := map[string]bool{}
+	for _, a := range m.Attrs {
+		switch a.Key {
+		case "parmtype":
+			continue
+		case "parm":
+			name, _, _ := strings.Cut(a.Value, ":")
+			described[name] = true
+			if typ, ok := types[name]; ok {
+				a.Value += " (" + typ + ")"
+			}
+		}
+		list = append(list, a)
+	}
+	// parameters without a description still have a type
+	for _, t := range m.Get("parmtype") {
+		if name, typ, ok := strings.Cut(t, ":"); ok && !described[name] {
+			list = append(list, modprobe.Attr{Key: "parm", Value: name + ": (" + typ + ")"})
+		}
+	}
+	return list
+}
+
+// show prints the fields of a module file
+func (opts *options) show(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	m, err := modprobe.ReadModule(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	end := "\n"
+	if opts.Null {
+		end = "\x00"
+	}
+	for _, a := range attrs(path, m) {
+		switch {
+		case opts.Field == "":
+			fmt.Fprintf(w, "%-16s%s%s", a.Key+":", a.Value, end)
+		case opts.Field == a.Key:
+			fmt.Fprintf(w, "%s%s", a.Value, end)
+		}
+	}
+	return nil
+}
+
+func run(w io.Writer, opts options, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("must provide a module")
+	}
+
+	var k *kmod.Kmod
+	for _, module := range args {
+		if isPath(module) {
+			if err := opts.show(w, module); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if k == nil {
+			var options []kmod.Option
+			if opts.BaseDir != "" {
+				options = append(options, kmod.SetRootDir(filepath.Join(opts.BaseDir, "/lib/modules")))
+			}
+			if opts.Version != "" {
+				options = append(options, kmod.SetKernelRelease(opts.Version))
+			}
+			var err error
+			if k, err = kmod.New(options...); err != nil {
+				return err
+			}
+		}
+		paths, ok, err := files(k, module)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			// builtin modules only have a name
+			switch opts.Field {
+			case "":
+				fmt.Fprintf(w, "%-16s%s\n%-16s%s\n", "name:", paths[0], "filename:", "(builtin)")
+			case "name":
+				fmt.Fprintln(w, paths[0])
+			case "filename":
+				fmt.Fprintln(w, "(builtin)")
+			}
+			continue
+		}
+		for _, path := range paths {
+			if err := opts.show(w, path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func main() {
+	var opts options
+	args, err := flags.Parse(&opts)
+	if flags.WroteHelp(err) {
+		os.Exit(0)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+	log.SetFlags(0)
+	log.SetPrefix("modinfo: ")
+
+	if err := run(os.Stdout, opts, args); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const fixture = "../../pkg/go-modprobe/testdata/lib/modules/6.1.0-test"
+
+// tree makes a base directory with modules.dep and modules.alias for the
+// test modules of pkg/go-modprobe
+func tree(t *testing.T) string {
+	t.Helper()
+	base := t.TempDir()
+	dir := filepath.Join(base, "lib/modules/6.1.0-test")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	src, err := filepath.Abs(filepath.Join(fixture, "kernel"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(src, filepath.Join(dir, "kernel")); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{
+		"modules.dep": "kernel/fs/ext4/ext4.ko.xz: kernel/fs/jbd2/jbd2.ko.gz kernel/lib/crc16.ko.zst\n" +
+			"kernel/fs/jbd2/jbd2.ko.gz: kernel/lib/crc16.ko.zst\nkernel/lib/crc16.ko.zst:\n",
+		"modules.alias":   "alias fs-ext4 ext4\n",
+		"modules.builtin": "kernel/drivers/block/loop.ko\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return base
+}
+
+func TestModinfo(t *testing.T) {
+	base := tree(t)
+	ext4 := filepath.Join(base, "lib/modules/6.1.0-test/kernel/fs/ext4/ext4.ko.xz")
+	jbd2 := filepath.Join(fixture, "kernel/fs/jbd2/jbd2.ko.gz")
+	for _, tt := range []struct {
+		name string
+		args []string
+		opts options
+		want string
+		err  string
+	}{
+		{
+			name: "path",
+			args: []string{jbd2},
+			want: "filename:       " + jbd2 + "\n" +
+				"vermagic:       6.1.0-test SMP mod_unload \n" +
+				"name:           jbd2\n" +
+				"depends:        crc16\n" +
+				"parm:           jbd2_debug:Debugging level for jbd2 (ushort)\n" +
+				"license:        GPL\n",
+		},
+		{
+			name: "alias",
+			args: []string{"fs-ext4"},
+			opts: options{Field: "filename"},
+			want: ext4 + "\n",
+		},
+		{
+			name: "field",
+			args: []string{"ext4"},
+			opts: options{Field: "alias"},
+			want: "fs-ext3\next3\nfs-ext4\n",
+		},
+		{
+			name: "parameters",
+			args: []string{"ext4"},
+			opts: options{Field: "parm"},
+			want: "mballoc_debug:Debugging level for ext4's mballoc (ushort)\n",
+		},
+		{
+			name: "null",
+			args: []string{jbd2},
+			opts: options{Field: "depends", Null: true},
+			want: "crc16\x00",
+		},
+		{
+			name: "builtin",
+			args: []string{"loop"},
+			want: "name:           loop\nfilename:       (builtin)\n",
+		},
+		{
+			name: "not found",
+			args: []string{"dummy"},
+			err:  "not found",
+		},
+		{
+			name: "no module",
+			err:  "must provide",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.BaseDir, opts.Version = base, "6.1.0-test"
+			var out bytes.Buffer
+			err := run(&out, opts, tt.args)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("run(%q) = %v, want an error with %q", tt.args, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("run(%q) printed %q, want %q", tt.args, out.String(), tt.want)
+			}
+		})
+	}
+}
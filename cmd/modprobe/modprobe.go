@@ -1,81 +1,68 @@
+// modprobe loads and removes kernel modules with their dependencies.
+//
+// Synopsis:
+//
+//	modprobe [OPTIONS] MODULE [PARAMETERS...]
+//	modprobe [OPTIONS] -a MODULE...
+//	modprobe [OPTIONS] -r MODULE...
+//	modprobe [OPTIONS] -d MODULE...
+//	modprobe [OPTIONS] --resolve-alias NAME...
+//	modprobe -l
+//
+// MODULE is the name of a module or one of its aliases, from modules.alias
+// or the modprobe.d(5) files, whose options, aliases, blacklists, install
+// and remove commands and soft dependencies are used.
 package main
 
 import (
-	"bufio"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"runtime/pprof"
 	"strings"
 
 	"github.com/jessevdk/go-flags"
-	"github.com/pmorjan/kmod"
+
+	"mybox/pkg/kmod"
 )
 
-var opts struct {
-	All           bool   `short:"a" long:"all" description:"Load multiple kernel modules"`
-	Remove        bool   `short:"r" long:"remove" description:"Remove a Kernel module"`
-	List          bool   `short:"l" long:"list" description:"Load multiple kernel modules"`
-	Deps          bool   `short:"d" long:"show-depends" description:"print dependencies of a module, takes a path to a .ko file"`
-	DryRun        bool   `short:"D" long:"dry-run" description:"do everything except load or unload modules"`
-	IgnoreBuiltin bool   `short:"I" long:"ignore-builtin" description:"ignore builtin modules"`
-	IgnoreAlias   bool   `short:"A" long:"ignore-alias" description:"ignore module aliases"`
-	IgnoreStatus  bool   `short:"S" long:"ignore-status" description:"ignore the status of a module (loading|unloading|live|inUse|unloaded)"`
-	RootDir       string `short:"R" long:"root" description:"root directory, sets dir as root directory for modules. defaults (/lib/modules)"`
-	Config        string `short:"c" long:"config" description:"config file, sets FILE as config file for modules. defaults (/etc/modprobe.conf)"`
-	Verbose       bool   `short:"v" long:"verbose" description:"print debugging information and verbose output"`
+// options are the flags of modprobe
+type options struct {
+	All           bool     `short:"a" long:"all" description:"Load multiple kernel modules"`
+	Remove        bool     `short:"r" long:"remove" description:"Remove a Kernel module"`
+	List          bool     `short:"l" long:"list" description:"List the loaded kernel modules"`
+	Deps          bool     `short:"d" long:"show-depends" description:"print the modules to load for a module, with their options"`
+	ResolveAlias  bool     `long:"resolve-alias" description:"print the modules an alias stands for"`
+	DryRun        bool     `short:"D" long:"dry-run" description:"do everything except load or unload modules"`
+	IgnoreBuiltin bool     `short:"I" long:"ignore-builtin" description:"ignore builtin modules"`
+	IgnoreAlias   bool     `short:"A" long:"ignore-alias" description:"ignore module aliases"`
+	IgnoreStatus  bool     `short:"S" long:"ignore-status" description:"ignore the status of a module (loading|unloading|live|inUse|unloaded)"`
+	IgnoreInstall bool     `short:"i" long:"ignore-install" description:"ignore the install and remove commands of the configuration"`
+	UseBlacklist  bool     `short:"b" long:"use-blacklist" description:"apply the blacklist to module names too, not only to aliases"`
+	RootDir       string   `short:"R" long:"root" description:"root directory, sets dir as root directory for modules. defaults (/lib/modules)"`
+	Version       string   `long:"set-version" value-name:"VERSION" description:"use the modules of kernel VERSION, not those of the running kernel"`
+	Config        string   `short:"c" long:"config" description:"config file, sets FILE as config file for modules. defaults (/etc/modprobe.conf)"`
+	ConfigDirs    []string `short:"C" long:"config-dir" value-name:"DIR" description:"read the modprobe.d files of DIR instead of the default directories, can be repeated"`
+	Verbose       bool     `short:"v" long:"verbose" description:"print debugging information and verbose output"`
+
+	// hooks are more options for kmod, set by tests to not touch the
+	// kernel
+	hooks []kmod.Option
 }
 
 var Debug = func(string, ...interface{}) {}
 
-const modDir = "/proc/modules"
-
-// TODO: Fix kernel module dependency resolution
-// Thanks to https://terenceli.github.io/%E6%8A%80%E6%9C%AF/2018/06/02/linux-loadable-module and arch wiki
-// https://stackoverflow.com/questions/44277243/how-to-get-default-kernel-module-name-from-ko-files
-// to get a Kernel Module name, we read the ASCII bytes at offset 12 on 32bit and 24 for 64bit
-// CMD: readelf -x .gnu.linkonce.this_module test.ko
-
-func List() (map[string]bool, error) {
-	loaded := map[string]bool{}
-	f, err := os.Open(modDir)
-	if err != nil {
-		return loaded, err
-	}
-	defer f.Close()
-
-	sc := bufio.NewScanner(f)
-	for sc.Scan() {
-		loaded[strings.SplitN(sc.Text(), " ", 2)[0]] = true
-	}
-
-	return loaded, err
-}
-
-func rmmod(args []string, k *kmod.Kmod) error {
-	for _, mod := range args {
-		if err := k.Unload(mod); err != nil {
-			return fmt.Errorf("Error unloading module: %w", err)
-		}
-	}
-	return nil
-}
-
-func modinfo(args []string, k *kmod.Kmod) error {
-	for _, mod := range args {
-		deps, err := k.Dependencies(mod)
-		if err != nil {
-			return err
-		}
-
-		for _, dep := range deps {
-			fmt.Printf("insmod %s\n", dep)
-		}
-	}
-	return nil
+// moduleName is the name of the module in a file, like ext4 for
+// kernel/fs/ext4/ext4.ko.xz
+func moduleName(path string) string {
+	name, _, _ := strings.Cut(filepath.Base(path), ".ko")
+	return strings.ReplaceAll(name, "-", "_")
 }
 
-func ModProbe(args []string) error {
+// newKmod makes a kmod.Kmod of the options
+func (opts *options) newKmod() (*kmod.Kmod, error) {
 	options := []kmod.Option{}
 
 	if opts.DryRun {
@@ -94,6 +81,14 @@ func ModProbe(args []string) error {
 		options = append(options, kmod.SetIgnoreStatus())
 	}
 
+	if opts.IgnoreInstall {
+		options = append(options, kmod.SetIgnoreCommands())
+	}
+
+	if opts.UseBlacklist {
+		options = append(options, kmod.SetUseBlacklist())
+	}
+
 	if opts.Verbose {
 		options = append(options, kmod.SetVerbose())
 	}
@@ -102,58 +97,98 @@ func ModProbe(args []string) error {
 		options = append(options, kmod.SetRootDir(opts.RootDir))
 	}
 
+	if opts.Version != "" {
+		options = append(options, kmod.SetKernelRelease(opts.Version))
+	}
+
 	if opts.Config != "" {
 		options = append(options, kmod.SetConfigFile(opts.Config))
 	}
 
-	options = append(options, kmod.SetInitFunc(modInit))
+	if len(opts.ConfigDirs) > 0 {
+		options = append(options, kmod.SetConfigDirs(opts.ConfigDirs...))
+	}
 
-	k, err := kmod.New(options...)
-	loaded, err := List()
-	if err != nil {
-		return err
+	return kmod.New(append(options, opts.hooks...)...)
+}
+
+// showDepends prints the modules to load for each module, in order, the
+// way insmod would load them
+func showDepends(w io.Writer, k *kmod.Kmod, args []string) error {
+	for _, mod := range args {
+		deps, err := k.Dependencies(mod)
+		if err != nil {
+			return err
+		}
+
+		for _, dep := range deps {
+			fmt.Fprintln(w, strings.TrimSpace("insmod "+dep+" "+k.Options(moduleName(dep))))
+		}
 	}
+	return nil
+}
 
+func run(w io.Writer, opts options, args []string) error {
 	if opts.List {
-		for mod := range loaded {
-			fmt.Println(mod)
+		mods, err := kmod.LoadedModules()
+		if err != nil {
+			return err
+		}
+		for _, m := range mods {
+			fmt.Fprintln(w, m.Name)
 		}
 		return nil
 	}
 
-	if opts.Deps {
-		return modinfo(args, k)
+	if len(args) < 1 {
+		return fmt.Errorf("must provide module name")
 	}
 
-	if opts.Remove {
-		return rmmod(args, k)
+	k, err := opts.newKmod()
+	if err != nil {
+		return err
 	}
 
-	if len(args) < 1 {
-		return fmt.Errorf("must provide module name")
+	switch {
+	case opts.Deps:
+		return showDepends(w, k, args)
+	case opts.ResolveAlias:
+		for _, name := range args {
+			mods, err := k.Resolve(name)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(w, strings.Join(mods, "\n"))
+		}
+		return nil
+	case opts.Remove:
+		for _, mod := range args {
+			if err := k.Unload(mod); err != nil {
+				return fmt.Errorf("Error unloading module: %w", err)
+			}
+			Debug("Removed kernel module: %v", mod)
+		}
+		return nil
 	}
 
-	mod := args[0]
-	parameters := strings.Join(args[1:], " ")
-
-	// if the module is not already found in /proc/modules (indicating it is alread loaded)
-	// we then try to load the module with optional parameters
-	if !loaded[mod] {
+	mods, parameters := args[:1], strings.Join(args[1:], " ")
+	if opts.All {
+		mods, parameters = args, ""
+	}
+	for _, mod := range mods {
 		if err := k.Load(mod, parameters, 0); err != nil {
 			return fmt.Errorf("unable to load module '[%v]' with parameters [%v] %w", mod, parameters, err)
 		}
-	} else {
-		return fmt.Errorf("module is already loaded")
+		Debug("\x1b[33m[\x1b[0m\x1b[32mINFO\x1b[33m]\x1b[0m Loaded kernel module: %v\n", mod)
 	}
 
-	Debug("\x1b[33m[\x1b[0m\x1b[32mINFO\x1b[33m]\x1b[0m Loaded kernel module: %v\n", mod)
-
 	return nil
 }
 
 const usage = `modprobe <module_name> [OPTIONAL_PARAMETERS] options PARAMETERS=VALUE a,b,c,d`
 
 func main() {
+	var opts options
 	args, err := flags.Parse(&opts)
 	if flags.WroteHelp(err) {
 		fmt.Println(usage)
@@ -174,19 +209,10 @@ func main() {
 		}
 
 		pprof.StartCPUProfile(f)
-
-		f2, err := os.Create(os.Getenv("PPROF") + "_mem.profile")
-		if err != nil {
-			panic(err)
-		}
-
-		pprof.StartCPUProfile(f)
-		pprof.WriteHeapProfile(f2)
 		defer pprof.StopCPUProfile()
-		f2.Close()
 	}
 
-	if err := ModProbe(args); err != nil {
+	if err := run(os.Stdout, opts, args); err != nil {
 		log.Fatal(err)
 	}
 
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mybox/pkg/kmod"
+)
+
+// tree makes a module tree for release 6.1.0-test in root, and a
+// modprobe.d directory
+func tree(t *testing.T) (root, confDir string) {
+	t.Helper()
+	dir := t.TempDir()
+	root, confDir = filepath.Join(dir, "lib/modules"), filepath.Join(dir, "modprobe.d")
+	for name, data := range map[string]string{
+		"lib/modules/6.1.0-test/modules.dep": "kernel/fs/ext4/ext4.ko.zst: kernel/fs/jbd2/jbd2.ko.zst kernel/lib/crc16.ko.xz\n" +
+			"kernel/fs/jbd2/jbd2.ko.zst:\nkernel/lib/crc16.ko.xz:\nkernel/drivers/net/dummy.ko.gz:\n",
+		"lib/modules/6.1.0-test/modules.alias":   "alias fs-ext4 ext4\nalias rtnl-link-dummy dummy\n",
+		"lib/modules/6.1.0-test/modules.builtin": "kernel/drivers/block/loop.ko\n",
+		"modprobe.d/ext4.conf":                   "options ext4 debug=1\nblacklist dummy\n",
+	} {
+		p := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(p), 0o755)
+		if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root, confDir
+}
+
+func TestModprobe(t *testing.T) {
+	root, confDir := tree(t)
+	var loaded, removed []string
+	hooks := []kmod.Option{
+		kmod.SetInitFunc(func(filename, params string, flags int) error {
+			rel, _ := filepath.Rel(filepath.Join(root, "6.1.0-test"), filename)
+			loaded = append(loaded, strings.TrimSpace(rel+" "+params))
+			return nil
+		}),
+		kmod.SetRemoveFunc(func(name string, flags int) error {
+			removed = append(removed, name)
+			return nil
+		}),
+	}
+
+	for _, tt := range []struct {
+		name    string
+		args    []string
+		opts    options
+		out     string
+		loaded  []string
+		removed []string
+		err     string
+	}{
+		{
+			name:   "load",
+			args:   []string{"fs-ext4", "a=1", "b=2"},
+			loaded: []string{"kernel/lib/crc16.ko.xz", "kernel/fs/jbd2/jbd2.ko.zst", "kernel/fs/ext4/ext4.ko.zst debug=1 a=1 b=2"},
+		},
+		{
+			name:   "all",
+			args:   []string{"crc16", "dummy", "loop"},
+			opts:   options{All: true},
+			loaded: []string{"kernel/lib/crc16.ko.xz", "kernel/drivers/net/dummy.ko.gz"},
+		},
+		{
+			name: "blacklisted alias",
+			args: []string{"rtnl-link-dummy"},
+			err:  "blacklisted",
+		},
+		{
+			name: "not found",
+			args: []string{"nothere"},
+			err:  "module not found",
+		},
+		{
+			name:    "remove",
+			args:    []string{"ext4", "dummy"},
+			opts:    options{Remove: true},
+			removed: []string{"ext4", "jbd2", "crc16", "dummy"},
+		},
+		{
+			name: "show depends",
+			args: []string{"ext4"},
+			opts: options{Deps: true},
+			out: fmt.Sprintf("insmod %[1]s/kernel/lib/crc16.ko.xz\ninsmod %[1]s/kernel/fs/jbd2/jbd2.ko.zst\ninsmod %[1]s/kernel/fs/ext4/ext4.ko.zst debug=1\n",
+				filepath.Join(root, "6.1.0-test")),
+		},
+		{
+			name: "resolve alias",
+			args: []string{"fs-ext4"},
+			opts: options{ResolveAlias: true},
+			out:  "ext4\n",
+		},
+		{
+			name: "dry run",
+			args: []string{"ext4"},
+			opts: options{DryRun: true},
+		},
+		{
+			name: "no module",
+			err:  "must provide module name",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			loaded, removed = nil, nil
+			opts := tt.opts
+			opts.RootDir, opts.Version, opts.ConfigDirs, opts.Config, opts.IgnoreStatus = root, "6.1.0-test", []string{confDir}, "/nonexistent", true
+			opts.hooks = hooks
+			var out bytes.Buffer
+			err := run(&out, opts, tt.args)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("got %v, want an error with %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.out {
+				t.Errorf("got %q, want %q", out.String(), tt.out)
+			}
+			if !reflect.DeepEqual(loaded, tt.loaded) || !reflect.DeepEqual(removed, tt.removed) {
+				t.Errorf("loaded %q and removed %q, want %q and %q", loaded, removed, tt.loaded, tt.removed)
+			}
+		})
+	}
+}
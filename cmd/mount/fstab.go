@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// fstabEntry is a line of fstab(5)
+type fstabEntry struct {
+	Source  string
+	Target  string
+	FSType  string
+	Options string
+}
+
+// unescapeFstab decodes the \040 octal escapes fstab uses for spaces
+func unescapeFstab(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			var c byte
+			if _, err := fmt.Sscanf(s[i+1:i+4], "%03o", &c); err == nil {
+				b.WriteByte(c)
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// readFstab reads the entries of the fstab at path
+func readFstab(path string) ([]*fstabEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []*fstabEntry
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: parse error", path, n)
+		}
+		e := &fstabEntry{
+			Source:  unescapeFstab(fields[0]),
+			Target:  unescapeFstab(fields[1]),
+			FSType:  "auto",
+			Options: "defaults",
+		}
+		if len(fields) > 2 {
+			e.FSType = fields[2]
+		}
+		if len(fields) > 3 {
+			e.Options = fields[3]
+		}
+		entries = append(entries, e)
+	}
+	return entries, s.Err()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"mybox/pkg/mount"
+)
+
+// byLabel is where udev links file system labels to their devices
+var byLabel = "/dev/disk/by-label"
+
+// unescapeUdev decodes the \x20 escapes udev puts in link names
+func unescapeUdev(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && s[i+1] == 'x' {
+			if n, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// labels maps devices to the labels udev knows them by
+func labels() map[string]string {
+	m := map[string]string{}
+	entries, err := os.ReadDir(byLabel)
+	if err != nil {
+		return m
+	}
+	for _, e := range entries {
+		dev, err := filepath.EvalSymlinks(filepath.Join(byLabel, e.Name()))
+		if err == nil {
+			m[dev] = unescapeUdev(e.Name())
+		}
+	}
+	return m
+}
+
+// list shows the mounts of the types given, like util-linux does with no
+// arguments, and with showLabels their labels
+func list(w io.Writer, types string, showLabels bool) error {
+	mounts, err := mount.Mountinfo()
+	if err != nil {
+		return err
+	}
+	var lbl map[string]string
+	if showLabels {
+		lbl = labels()
+	}
+	for _, m := range mounts {
+		if !mount.TypeMatch(types, m.FSType) {
+			continue
+		}
+		fmt.Fprintf(w, "%s on %s type %s (%s)", m.Source, m.Path, m.FSType, m.AllOptions())
+		if l, ok := lbl[m.Source]; ok {
+			fmt.Fprintf(w, " [%s]", l)
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
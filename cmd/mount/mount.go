@@ -0,0 +1,466 @@
+// mount attaches file systems, like util-linux mount.
+//
+// Synopsis:
+//
+//	mount [-l] [-t TYPES]
+//	mount -a [-fv] [-t TYPES] [-O OPTS]
+//	mount [-fvrw] [-t TYPE] [-o OPTS] DEVICE|DIR
+//	mount [-fvrw] [-t TYPE] [-o OPTS] DEVICE DIR
+//	mount --bind|--rbind|--move OLDDIR NEWDIR
+//	mount --make-[r]{shared,slave,private,unbindable} DIR
+//
+// DEVICE may be UUID=, LABEL=, PARTUUID= or PARTLABEL=. A regular file is
+// mounted through a loop device. Without -t, or with -t auto, the file
+// system type is found from its superblock.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"mybox/pkg/mount"
+	"mybox/pkg/mount/block"
+	"mybox/pkg/mount/loop"
+
+	"github.com/jessevdk/go-flags"
+	"golang.org/x/sys/unix"
+)
+
+// mountFlags are the flags of mount, options being what -o parses to
+type mountFlags struct {
+	All         bool     `short:"a" long:"all" description:"mount all file systems in fstab"`
+	Types       string   `short:"t" long:"types" description:"file system type, or types to list or mount with -a"`
+	Options     []string `short:"o" long:"options" description:"comma separated mount options"`
+	TestOpts    string   `short:"O" long:"test-opts" description:"with -a, only mount fstab entries with these options"`
+	ReadOnly    bool     `short:"r" long:"read-only" description:"mount read-only"`
+	ReadWrite   bool     `short:"w" long:"rw" description:"mount read-write"`
+	ShowLabels  bool     `short:"l" long:"show-labels" description:"show labels when listing"`
+	Fake        bool     `short:"f" long:"fake" description:"do everything but the mount"`
+	Verbose     bool     `short:"v" long:"verbose" description:"say what is done"`
+	NoMtab      bool     `short:"n" long:"no-mtab" description:"ignored, there is no mtab"`
+	Bind        bool     `short:"B" long:"bind" description:"mount a subtree somewhere else"`
+	RBind       bool     `short:"R" long:"rbind" description:"mount a subtree and all its submounts somewhere else"`
+	Move        bool     `short:"M" long:"move" description:"move a subtree"`
+	Fstab       string   `short:"T" long:"fstab" default:"/etc/fstab" description:"fstab to use"`
+	Label       string   `short:"L" long:"label" description:"mount the device with this label"`
+	UUID        string   `short:"U" long:"uuid" description:"mount the device with this UUID"`
+	Shared      bool     `long:"make-shared" description:"mark a subtree shared"`
+	Slave       bool     `long:"make-slave" description:"mark a subtree slave"`
+	Private     bool     `long:"make-private" description:"mark a subtree private"`
+	Unbindable  bool     `long:"make-unbindable" description:"mark a subtree unbindable"`
+	RShared     bool     `long:"make-rshared" description:"recursively mark a whole subtree shared"`
+	RSlave      bool     `long:"make-rslave" description:"recursively mark a whole subtree slave"`
+	RPrivate    bool     `long:"make-rprivate" description:"recursively mark a whole subtree private"`
+	RUnbindable bool     `long:"make-runbindable" description:"recursively mark a whole subtree unbindable"`
+}
+
+// Exit codes of util-linux mount
+const (
+	exitUsage   = 1
+	exitFailure = 32
+	exitSome    = 64
+)
+
+var errUsage = errors.New("bad usage")
+
+// resolve turns a tag like UUID=... into the device it names
+func resolve(source string) (string, error) {
+	tag, value, ok := strings.Cut(source, "=")
+	if !ok {
+		return source, nil
+	}
+	value = strings.Trim(value, `"`)
+	var devs block.BlockDevices
+	switch tag {
+	case "LABEL", "UUID", "PARTUUID", "PARTLABEL":
+		all, err := block.GetBlockDevices()
+		if err != nil {
+			return "", err
+		}
+		switch tag {
+		case "LABEL":
+			devs = all.FilterFSLabel(value)
+		case "UUID":
+			devs = all.FilterFSUUID(value)
+		case "PARTUUID":
+			devs = all.FilterPartID(value)
+		case "PARTLABEL":
+			devs = all.FilterPartLabel(value)
+		}
+	default:
+		return source, nil
+	}
+	if len(devs) == 0 {
+		return "", fmt.Errorf("can't find %s", source)
+	}
+	return devs[0].DevicePath(), nil
+}
+
+// mountError explains a failed mount(2) the way util-linux does
+func mountError(source, target, fstype string, err error) error {
+	var errno unix.Errno
+	if !errors.As(err, &errno) {
+		return fmt.Errorf("%s: %v", target, err)
+	}
+	var msg string
+	switch errno {
+	case unix.EPERM, unix.EACCES:
+		msg = "must be superuser to use mount"
+	case unix.EBUSY:
+		msg = fmt.Sprintf("%s already mounted or mount point busy", source)
+	case unix.ENOENT:
+		if _, err := os.Stat(target); err != nil {
+			msg = "mount point does not exist"
+		} else {
+			msg = fmt.Sprintf("special device %s does not exist", source)
+		}
+	case unix.ENOTDIR:
+		msg = "mount point is not a directory"
+	case unix.ENODEV:
+		msg = fmt.Sprintf("unknown filesystem type '%s'", fstype)
+	case unix.ENOTBLK:
+		msg = fmt.Sprintf("%s is not a block device", source)
+	case unix.EROFS:
+		msg = fmt.Sprintf("cannot mount %s read-only", source)
+	case unix.EINVAL:
+		msg = fmt.Sprintf("wrong fs type, bad option, bad superblock on %s, missing codepage or helper program, or other error", source)
+	default:
+		msg = errno.Error()
+	}
+	return fmt.Errorf("%s: %s.", target, msg)
+}
+
+// setupLoop puts source on a loop device. The device goes away by itself
+// once it's unmounted, or when the file returned is closed without a mount.
+func setupLoop(source string, o *options) (*os.File, error) {
+	dev := o.loopDev
+	if dev == "" {
+		var err error
+		if dev, err = loop.FindDevice(); err != nil {
+			return nil, fmt.Errorf("failed to setup loop device for %s: %v", source, err)
+		}
+	}
+	mode := os.O_RDWR
+	if o.flags&unix.MS_RDONLY != 0 {
+		mode = os.O_RDONLY
+	}
+	file, err := os.OpenFile(source, mode, 0)
+	if err != nil && mode == os.O_RDWR {
+		mode = os.O_RDONLY
+		file, err = os.OpenFile(source, mode, 0)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup loop device for %s: %v", source, err)
+	}
+	defer file.Close()
+	f, err := os.OpenFile(dev, mode, 0)
+	if err != nil {
+		return nil, err
+	}
+	c := loop.Config{Offset: o.offset, ReadOnly: mode == os.O_RDONLY, AutoClear: true}
+	if err := loop.ConfigureFD(int(f.Fd()), int(file.Fd()), c); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to setup loop device for %s: %v", source, err)
+	}
+	return f, nil
+}
+
+// mountOne mounts source on target
+func (opts *mountFlags) mountOne(source, target, fstype string, o *options) error {
+	if o.flags&(unix.MS_BIND|unix.MS_MOVE|unix.MS_REMOUNT) == 0 && source != "" {
+		dev, err := resolve(source)
+		if err != nil {
+			return err
+		}
+		source = dev
+		if fi, err := os.Stat(source); err == nil && fi.Mode().IsRegular() {
+			o.loop = true
+		}
+	}
+	if opts.Fake {
+		if opts.Verbose {
+			fmt.Printf("mount: %s mounted on %s.\n", source, target)
+		}
+		return nil
+	}
+
+	if o.flags&(unix.MS_BIND|unix.MS_MOVE|unix.MS_REMOUNT) != 0 || source == "" {
+		fstype = ""
+	} else {
+		if o.loop {
+			f, err := setupLoop(source, o)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			source = f.Name()
+		}
+		if fstype == "" || fstype == "auto" {
+			fs, flags, err := mount.FSFromBlock(source)
+			if errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("%s: special device %s does not exist.", target, source)
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %v", target, err)
+			}
+			fstype = fs
+			o.flags |= flags
+		}
+	}
+
+	// with a list of types the first that works wins
+	var err error
+	for _, t := range strings.Split(fstype, ",") {
+		if _, err = mount.Mount(source, target, t, o.fsData(), o.flags); err == nil {
+			fstype = t
+			break
+		}
+	}
+	if err != nil {
+		return mountError(source, target, fstype, err)
+	}
+	// the kernel leaves out the flags of a bind mount until a remount
+	if o.flags&unix.MS_BIND != 0 && o.flags&^(unix.MS_BIND|unix.MS_REC|unix.MS_REMOUNT) != 0 {
+		if err := unix.Mount("none", target, "", o.flags|unix.MS_REMOUNT, ""); err != nil {
+			return mountError(source, target, fstype, err)
+		}
+	}
+	if o.propagation != 0 {
+		if err := unix.Mount("none", target, "", o.propagation, ""); err != nil {
+			return mountError(source, target, fstype, err)
+		}
+	}
+	if opts.Verbose {
+		fmt.Printf("mount: %s mounted on %s.\n", source, target)
+	}
+	return nil
+}
+
+// mounted tells whether something is mounted on target
+func mounted(mounts []*mount.Info, target string) bool {
+	if p, err := filepath.EvalSymlinks(target); err == nil {
+		target = p
+	}
+	for _, m := range mounts {
+		if m.Path == target {
+			return true
+		}
+	}
+	return false
+}
+
+// hasOptions tells whether all of the comma separated want, which may have
+// no in front for options that must not be there, are in have
+func hasOptions(have, want string) bool {
+	set := map[string]bool{}
+	for _, o := range strings.Split(have, ",") {
+		set[o] = true
+	}
+	for _, o := range strings.Split(want, ",") {
+		if o == "" {
+			continue
+		}
+		if n, ok := strings.CutPrefix(o, "no"); ok && !set[o] {
+			if set[n] {
+				return false
+			}
+			continue
+		}
+		if !set[o] {
+			return false
+		}
+	}
+	return true
+}
+
+// mountAll mounts what fstab has that isn't noauto or mounted yet. It
+// returns how many mounts failed and succeeded.
+func (opts *mountFlags) mountAll(entries []*fstabEntry) (failed, ok int) {
+	mounts, err := mount.Mountinfo()
+	if err != nil {
+		log.Print(err)
+		return 1, 0
+	}
+	for _, e := range entries {
+		o, err := parseOptions(append([]string{e.Options}, opts.Options...)...)
+		if err != nil {
+			log.Printf("%s: %v", e.Target, err)
+			failed++
+			continue
+		}
+		if o.noauto || e.FSType == "swap" || e.Target == "none" ||
+			!mount.TypeMatch(opts.Types, e.FSType) || !hasOptions(e.Options, opts.TestOpts) {
+			continue
+		}
+		if mounted(mounts, e.Target) {
+			if opts.Verbose {
+				fmt.Printf("%-25s: already mounted\n", e.Target)
+			}
+			continue
+		}
+		if err := opts.mountOne(e.Source, e.Target, e.FSType, o); err != nil {
+			if !o.nofail {
+				log.Print(err)
+				failed++
+			}
+			continue
+		}
+		ok++
+		if opts.Verbose {
+			fmt.Printf("%-25s: successfully mounted\n", e.Target)
+		}
+	}
+	return failed, ok
+}
+
+// find looks up the fstab entry for a device or mount point
+func find(entries []*fstabEntry, name string) *fstabEntry {
+	for _, e := range entries {
+		if e.Target == name || e.Source == name {
+			return e
+		}
+	}
+	if p, err := filepath.Abs(name); err == nil && p != name {
+		return find(entries, p)
+	}
+	return nil
+}
+
+// makeFlags is the propagation asked for with --make-*
+func (opts *mountFlags) makeFlags() uintptr {
+	var flags uintptr
+	for _, m := range []struct {
+		set   bool
+		flags uintptr
+	}{
+		{opts.Shared, unix.MS_SHARED},
+		{opts.Slave, unix.MS_SLAVE},
+		{opts.Private, unix.MS_PRIVATE},
+		{opts.Unbindable, unix.MS_UNBINDABLE},
+		{opts.RShared, unix.MS_SHARED | unix.MS_REC},
+		{opts.RSlave, unix.MS_SLAVE | unix.MS_REC},
+		{opts.RPrivate, unix.MS_PRIVATE | unix.MS_REC},
+		{opts.RUnbindable, unix.MS_UNBINDABLE | unix.MS_REC},
+	} {
+		if m.set {
+			flags |= m.flags
+		}
+	}
+	return flags
+}
+
+func run(stdout io.Writer, opts mountFlags, args []string) (int, error) {
+	cmdline := append([]string(nil), opts.Options...)
+	switch {
+	case opts.Bind:
+		cmdline = append(cmdline, "bind")
+	case opts.RBind:
+		cmdline = append(cmdline, "rbind")
+	case opts.Move:
+		cmdline = append(cmdline, "move")
+	}
+	if opts.ReadOnly {
+		cmdline = append(cmdline, "ro")
+	}
+	if opts.ReadWrite {
+		cmdline = append(cmdline, "rw")
+	}
+	opts.Options = cmdline
+	switch {
+	case opts.Label != "":
+		args = append([]string{"LABEL=" + opts.Label}, args...)
+	case opts.UUID != "":
+		args = append([]string{"UUID=" + opts.UUID}, args...)
+	}
+
+	if p := opts.makeFlags(); p != 0 {
+		if len(args) != 1 {
+			return exitUsage, errUsage
+		}
+		if opts.Fake {
+			return 0, nil
+		}
+		if err := unix.Mount("none", args[0], "", p, ""); err != nil {
+			return exitFailure, mountError("none", args[0], "", err)
+		}
+		return 0, nil
+	}
+
+	if opts.All {
+		entries, err := readFstab(opts.Fstab)
+		if err != nil {
+			return exitFailure, err
+		}
+		failed, ok := opts.mountAll(entries)
+		switch {
+		case failed == 0:
+			return 0, nil
+		case ok > 0:
+			return exitSome, nil
+		}
+		return exitFailure, nil
+	}
+
+	var source, target, fstype string
+	o, err := parseOptions(cmdline...)
+	if err != nil {
+		return exitUsage, err
+	}
+	switch len(args) {
+	case 0:
+		return 0, list(stdout, opts.Types, opts.ShowLabels)
+	case 1:
+		entries, err := readFstab(opts.Fstab)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return exitFailure, err
+		}
+		e := find(entries, args[0])
+		if e == nil {
+			if o.flags&unix.MS_REMOUNT == 0 {
+				return exitFailure, fmt.Errorf("%s: can't find in %s.", args[0], opts.Fstab)
+			}
+			// remounting what isn't in fstab needs only the mount point
+			target = args[0]
+			break
+		}
+		if o, err = parseOptions(append([]string{e.Options}, cmdline...)...); err != nil {
+			return exitUsage, err
+		}
+		source, target, fstype = e.Source, e.Target, e.FSType
+	case 2:
+		source, target = args[0], args[1]
+	default:
+		return exitUsage, errUsage
+	}
+	if opts.Types != "" {
+		fstype = opts.Types
+	}
+	if err := opts.mountOne(source, target, fstype, o); err != nil {
+		return exitFailure, err
+	}
+	return 0, nil
+}
+
+func main() {
+	var opts mountFlags
+	args, err := flags.Parse(&opts)
+	if flags.WroteHelp(err) {
+		os.Exit(0)
+	}
+	if err != nil {
+		os.Exit(exitUsage)
+	}
+	log.SetFlags(0)
+	log.SetPrefix("mount: ")
+
+	code, err := run(os.Stdout, opts, args)
+	if err != nil {
+		log.Print(err)
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mybox/pkg/mount"
+
+	"golang.org/x/sys/unix"
+)
+
+// testArgs runs the test binary as mount with these arguments
+const testArgs = "MOUNT_TEST_ARGS"
+
+func TestMain(m *testing.M) {
+	if v, ok := os.LookupEnv(testArgs); ok {
+		var args []string
+		if err := json.Unmarshal([]byte(v), &args); err != nil {
+			panic(err)
+		}
+		os.Args = append([]string{"mount"}, args...)
+		main()
+	}
+	os.Exit(m.Run())
+}
+
+// mountCmd runs mount with args and returns its output and exit code
+func mountCmd(t *testing.T, args ...string) (string, int) {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(args)
+	c := exec.Command(exe)
+	c.Env = append(os.Environ(), testArgs+"="+string(b))
+	out, err := c.CombinedOutput()
+	if err != nil && c.ProcessState == nil {
+		t.Fatal(err)
+	}
+	return string(out), c.ProcessState.ExitCode()
+}
+
+func TestParseOptions(t *testing.T) {
+	for _, tt := range []struct {
+		opts []string
+		want options
+	}{
+		{
+			opts: []string{"defaults"},
+			want: options{},
+		},
+		{
+			opts: []string{"ro,nosuid,nodev,noexec,noatime"},
+			want: options{flags: unix.MS_RDONLY | unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC | unix.MS_NOATIME},
+		},
+		{
+			// later options win
+			opts: []string{"ro,nosuid", "rw,suid"},
+			want: options{},
+		},
+		{
+			opts: []string{"rbind,rprivate"},
+			want: options{flags: unix.MS_BIND | unix.MS_REC, propagation: unix.MS_PRIVATE | unix.MS_REC},
+		},
+		{
+			opts: []string{"remount,ro,size=10m,mode=755"},
+			want: options{flags: unix.MS_REMOUNT | unix.MS_RDONLY, data: []string{"size=10m", "mode=755"}},
+		},
+		{
+			opts: []string{"loop=/dev/loop3,offset=0x200"},
+			want: options{loop: true, loopDev: "/dev/loop3", offset: 512},
+		},
+		{
+			opts: []string{"noauto,nofail,user,x-systemd.automount,_netdev,comment=x"},
+			want: options{noauto: true, nofail: true},
+		},
+	} {
+		got, err := parseOptions(tt.opts...)
+		if err != nil {
+			t.Errorf("parseOptions(%q): %v", tt.opts, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("parseOptions(%q) = %+v, want %+v", tt.opts, *got, tt.want)
+		}
+	}
+
+	if _, err := parseOptions("offset=x"); err == nil {
+		t.Error("parseOptions(offset=x) succeeded, want an error")
+	}
+	if o, _ := parseOptions("size=1m,mode=700"); o.fsData() != "size=1m,mode=700" {
+		t.Errorf("fsData() = %q", o.fsData())
+	}
+}
+
+func TestHasOptions(t *testing.T) {
+	for _, tt := range []struct {
+		have, want string
+		ok         bool
+	}{
+		{"defaults", "", true},
+		{"rw,_netdev", "_netdev", true},
+		{"rw", "_netdev", false},
+		{"rw,_netdev", "no_netdev", false},
+		{"rw", "no_netdev", true},
+	} {
+		if got := hasOptions(tt.have, tt.want); got != tt.ok {
+			t.Errorf("hasOptions(%q, %q) = %v, want %v", tt.have, tt.want, got, tt.ok)
+		}
+	}
+}
+
+func TestReadFstab(t *testing.T) {
+	entries, err := readFstab("testdata/fstab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*fstabEntry{
+		{Source: "UUID=1234-abcd", Target: "/boot", FSType: "vfat", Options: "defaults,noatime"},
+		{Source: "/dev/sda2", Target: "/mnt/my disk", FSType: "ext4", Options: "ro,nofail"},
+		{Source: "tmpfs", Target: "/tmp", FSType: "tmpfs", Options: "defaults"},
+		{Source: "/swapfile", Target: "none", FSType: "swap", Options: "sw"},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		for _, e := range entries {
+			t.Logf("%+v", e)
+		}
+		t.Errorf("readFstab = %v, want %v", entries, want)
+	}
+	if e := find(entries, "/mnt/my disk"); e == nil || e.Source != "/dev/sda2" {
+		t.Errorf("find(/mnt/my disk) = %v", e)
+	}
+	if e := find(entries, "/nowhere"); e != nil {
+		t.Errorf("find(/nowhere) = %v, want nil", e)
+	}
+}
+
+func TestList(t *testing.T) {
+	dir := t.TempDir()
+	dev := filepath.Join(dir, "sda1")
+	os.WriteFile(dev, nil, 0o644)
+	os.Mkdir(filepath.Join(dir, "by-label"), 0o755)
+	os.Symlink(dev, filepath.Join(dir, "by-label", `my\x20root`))
+	info := filepath.Join(dir, "mountinfo")
+	os.WriteFile(info, []byte(fmt.Sprintf(
+		"22 1 8:1 / / rw,relatime shared:1 - ext4 %s rw,errors=remount-ro\n"+
+			"23 22 0:22 / /proc rw,nosuid,nodev,noexec,relatime shared:12 - proc proc rw\n"+
+			"41 22 0:40 / /mnt/a\\040b rw,relatime - tmpfs none rw,size=1024k\n", dev)), 0o644)
+
+	defer func(path, label string) {
+		mount.MountinfoPath, byLabel = path, label
+	}(mount.MountinfoPath, byLabel)
+	mount.MountinfoPath, byLabel = info, filepath.Join(dir, "by-label")
+
+	for _, tt := range []struct {
+		types  string
+		labels bool
+		want   string
+	}{
+		{
+			want: dev + " on / type ext4 (rw,relatime,errors=remount-ro)\n" +
+				"proc on /proc type proc (rw,nosuid,nodev,noexec,relatime)\n" +
+				"none on /mnt/a b type tmpfs (rw,relatime,size=1024k)\n",
+		},
+		{
+			types:  "ext4",
+			labels: true,
+			want:   dev + " on / type ext4 (rw,relatime,errors=remount-ro) [my root]\n",
+		},
+		{
+			types: "noext4,proc",
+			want:  "none on /mnt/a b type tmpfs (rw,relatime,size=1024k)\n",
+		},
+	} {
+		var b bytes.Buffer
+		if err := list(&b, tt.types, tt.labels); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != tt.want {
+			t.Errorf("list(%q, %v) = %q, want %q", tt.types, tt.labels, b.String(), tt.want)
+		}
+	}
+}
+
+// mountedAt returns what mountinfo says is mounted at path
+func mountedAt(t *testing.T, path string) *mount.Info {
+	t.Helper()
+	mounts, err := mount.Mountinfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found *mount.Info
+	for _, m := range mounts {
+		if m.Path == path {
+			found = m
+		}
+	}
+	return found
+}
+
+func unmountAt(t *testing.T, path string) {
+	t.Cleanup(func() {
+		unix.Unmount(path, unix.MNT_DETACH)
+	})
+}
+
+func TestMount(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("needs root to mount")
+	}
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	os.Mkdir(a, 0o755)
+	os.Mkdir(b, 0o755)
+
+	unmountAt(t, a)
+	if out, code := mountCmd(t, "-v", "-t", "tmpfs", "-o", "size=1m,nosuid", "tmpfs", a); code != 0 {
+		t.Fatalf("mount tmpfs: %d %s", code, out)
+	} else if out != "mount: tmpfs mounted on "+a+".\n" {
+		t.Errorf("mount -v said %q", out)
+	}
+	m := mountedAt(t, a)
+	if m == nil || m.FSType != "tmpfs" || !strings.Contains(m.Options, "nosuid") || !strings.Contains(m.SuperOptions, "size=1024k") {
+		t.Fatalf("mounted at %s: %+v", a, m)
+	}
+	if out, _ := mountCmd(t, "-t", "tmpfs"); !strings.Contains(out, "tmpfs on "+a+" type tmpfs (rw,nosuid,relatime,size=1024k") {
+		t.Errorf("mount -t tmpfs lists %q", out)
+	}
+
+	// a read-only bind mount, made writable again
+	unmountAt(t, b)
+	if out, code := mountCmd(t, "--bind", "-r", a, b); code != 0 {
+		t.Fatalf("mount --bind: %d %s", code, out)
+	}
+	if m := mountedAt(t, b); m == nil || !strings.HasPrefix(m.Options, "ro") {
+		t.Errorf("bind mount at %s: %+v", b, m)
+	}
+	if out, code := mountCmd(t, "-o", "remount,rw", b); code != 0 {
+		t.Fatalf("remount: %d %s", code, out)
+	}
+	if m := mountedAt(t, b); m == nil || !strings.HasPrefix(m.Options, "rw") {
+		t.Errorf("remounted at %s: %+v", b, m)
+	}
+	if out, code := mountCmd(t, "--make-private", a); code != 0 {
+		t.Fatalf("--make-private: %d %s", code, out)
+	}
+	if m := mountedAt(t, a); m == nil || len(m.Optional) != 0 {
+		t.Errorf("private mount at %s: %+v", a, m)
+	}
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-t", "tmpfs", "none", filepath.Join(dir, "nothere")}, "mount point does not exist."},
+		{[]string{filepath.Join(dir, "nodev"), b}, "special device " + filepath.Join(dir, "nodev") + " does not exist."},
+		{[]string{"-T", "testdata/fstab", "/nowhere"}, "/nowhere: can't find in testdata/fstab."},
+	} {
+		out, code := mountCmd(t, tt.args...)
+		if code != exitFailure || !strings.Contains(out, tt.want) {
+			t.Errorf("mount %q = %d %q, want %d and %q", tt.args, code, out, exitFailure, tt.want)
+		}
+	}
+}
+
+func TestMountLoop(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("needs root to mount")
+	}
+	if _, err := os.Stat("/dev/loop-control"); err != nil {
+		t.Skip("no loop devices")
+	}
+	dir := t.TempDir()
+	// mounting writes to the image, so use a copy
+	img := filepath.Join(dir, "disk")
+	src, err := os.Open("../../pkg/mount/testdata/1MB.ext4_vfat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst, _ := os.Create(img)
+	io.Copy(dst, src)
+	src.Close()
+	dst.Close()
+
+	// the ext4 partition starts at the second sector
+	mnt := filepath.Join(dir, "mnt")
+	os.Mkdir(mnt, 0o755)
+	unmountAt(t, mnt)
+	if out, code := mountCmd(t, "-o", "ro,offset=512", img, mnt); code != 0 {
+		t.Fatalf("mount: %d %s", code, out)
+	}
+	m := mountedAt(t, mnt)
+	if m == nil || m.FSType != "ext4" || !strings.HasPrefix(m.Source, "/dev/loop") {
+		t.Fatalf("mounted at %s: %+v", mnt, m)
+	}
+	if _, err := os.Stat(filepath.Join(mnt, "lost+found")); err != nil {
+		t.Error(err)
+	}
+	if err := unix.Unmount(mnt, 0); err != nil {
+		t.Fatal(err)
+	}
+	// the loop device is let go with the mount
+	name := filepath.Base(m.Source)
+	if b, err := os.ReadFile(filepath.Join("/sys/block", name, "loop/backing_file")); err == nil {
+		t.Errorf("%s still backed by %s", m.Source, b)
+	}
+}
+
+func TestMountAll(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("needs root to mount")
+	}
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	os.Mkdir(a, 0o755)
+	os.Mkdir(b, 0o755)
+	fstab := filepath.Join(dir, "fstab")
+	os.WriteFile(fstab, []byte(fmt.Sprintf(
+		"none %s tmpfs size=1m\n"+
+			"none %s tmpfs noauto\n"+
+			"/dev/nothing %s ext4 nofail\n"+
+			"/swapfile none swap sw\n", a, b, b)), 0o644)
+
+	unmountAt(t, a)
+	out, code := mountCmd(t, "-av", "-T", fstab)
+	if code != 0 {
+		t.Fatalf("mount -a: %d %s", code, out)
+	}
+	if want := fmt.Sprintf("%-25s: successfully mounted\n", a); !strings.Contains(out, want) {
+		t.Errorf("mount -av said %q, want %q", out, want)
+	}
+	if mountedAt(t, a) == nil || mountedAt(t, b) != nil {
+		t.Errorf("mount -a mounted %v and %v", mountedAt(t, a), mountedAt(t, b))
+	}
+	out, _ = mountCmd(t, "-av", "-T", fstab)
+	if want := fmt.Sprintf("%-25s: already mounted\n", a); !strings.Contains(out, want) {
+		t.Errorf("mount -av again said %q, want %q", out, want)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// flag is what a mount option does to the mount flags
+type flag struct {
+	set   uintptr
+	clear uintptr
+}
+
+// flagOptions are the options mount(8) turns into flags
+var flagOptions = map[string]flag{
+	"ro":          {set: unix.MS_RDONLY},
+	"rw":          {clear: unix.MS_RDONLY},
+	"nosuid":      {set: unix.MS_NOSUID},
+	"suid":        {clear: unix.MS_NOSUID},
+	"nodev":       {set: unix.MS_NODEV},
+	"dev":         {clear: unix.MS_NODEV},
+	"noexec":      {set: unix.MS_NOEXEC},
+	"exec":        {clear: unix.MS_NOEXEC},
+	"sync":        {set: unix.MS_SYNCHRONOUS},
+	"async":       {clear: unix.MS_SYNCHRONOUS},
+	"dirsync":     {set: unix.MS_DIRSYNC},
+	"remount":     {set: unix.MS_REMOUNT},
+	"mand":        {set: unix.MS_MANDLOCK},
+	"nomand":      {clear: unix.MS_MANDLOCK},
+	"noatime":     {set: unix.MS_NOATIME},
+	"atime":       {clear: unix.MS_NOATIME},
+	"nodiratime":  {set: unix.MS_NODIRATIME},
+	"diratime":    {clear: unix.MS_NODIRATIME},
+	"relatime":    {set: unix.MS_RELATIME},
+	"norelatime":  {clear: unix.MS_RELATIME},
+	"strictatime": {set: unix.MS_STRICTATIME},
+	"lazytime":    {set: unix.MS_LAZYTIME},
+	"nolazytime":  {clear: unix.MS_LAZYTIME},
+	"silent":      {set: unix.MS_SILENT},
+	"loud":        {clear: unix.MS_SILENT},
+	"bind":        {set: unix.MS_BIND},
+	"rbind":       {set: unix.MS_BIND | unix.MS_REC},
+	"move":        {set: unix.MS_MOVE},
+}
+
+// propagation are the options that change how mount events propagate,
+// which takes a mount call of its own
+var propagation = map[string]uintptr{
+	"private":     unix.MS_PRIVATE,
+	"rprivate":    unix.MS_PRIVATE | unix.MS_REC,
+	"shared":      unix.MS_SHARED,
+	"rshared":     unix.MS_SHARED | unix.MS_REC,
+	"slave":       unix.MS_SLAVE,
+	"rslave":      unix.MS_SLAVE | unix.MS_REC,
+	"unbindable":  unix.MS_UNBINDABLE,
+	"runbindable": unix.MS_UNBINDABLE | unix.MS_REC,
+}
+
+// userspace are the options for mount(8) and fstab, not the kernel
+var userspace = map[string]bool{
+	"defaults": true, "auto": true, "noauto": true, "user": true,
+	"nouser": true, "users": true, "owner": true, "group": true,
+	"_netdev": true, "nofail": true,
+}
+
+// options is a parsed -o list
+type options struct {
+	flags uintptr
+	// propagation is applied after the mount
+	propagation uintptr
+	// data goes to the file system
+	data []string
+	// loop is set for loop, with the device if one was given
+	loop    bool
+	loopDev string
+	offset  uint64
+	noauto  bool
+	nofail  bool
+}
+
+// parseOptions parses comma separated mount options, later ones winning
+func parseOptions(list ...string) (*options, error) {
+	o := &options{}
+	for _, l := range list {
+		for _, opt := range strings.Split(l, ",") {
+			if err := o.add(opt); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return o, nil
+}
+
+func (o *options) add(opt string) error {
+	name, value, hasValue := strings.Cut(opt, "=")
+	if f, ok := flagOptions[opt]; ok {
+		o.flags = o.flags&^f.clear | f.set
+		return nil
+	}
+	if p, ok := propagation[opt]; ok {
+		o.propagation = p
+		return nil
+	}
+	switch {
+	case opt == "":
+	case name == "loop":
+		o.loop, o.loopDev = true, value
+	case name == "offset":
+		n, err := strconv.ParseUint(value, 0, 64)
+		if err != nil || !hasValue {
+			return fmt.Errorf("bad offset %q", value)
+		}
+		o.loop, o.offset = true, n
+	case opt == "noauto":
+		o.noauto = true
+	case opt == "nofail":
+		o.nofail = true
+	case userspace[opt], strings.HasPrefix(opt, "x-"), name == "comment":
+	default:
+		o.data = append(o.data, opt)
+	}
+	return nil
+}
+
+// fsData is the data argument to mount(2)
+func (o *options) fsData() string {
+	return strings.Join(o.data, ",")
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// enter is the second half of nsenter, run in the namespaces the C
+// constructor in setns.go joined
+type enter struct {
+	Args []string
+	// Root and Wd are directories opened before joining, or -1
+	Root, Wd int
+	// Setuid and Setgid are -1 to leave them alone
+	Setuid, Setgid int
+	// User is set when we joined a user namespace, Preserve keeps our
+	// credentials there
+	User, Preserve bool
+	// PID is a PID namespace for the children of the command, or -1
+	PID int
+}
+
+// environ is ours without the variables that got us here
+func environ() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, setnsEnv+"=") && !strings.HasPrefix(kv, enterEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// credentials switches to the ids we run the command as. In a new user
+// namespace that's root unless we preserve our own.
+func (e *enter) credentials() error {
+	uid, gid := e.Setuid, e.Setgid
+	if e.User && !e.Preserve {
+		if uid < 0 {
+			uid = 0
+		}
+		if gid < 0 {
+			gid = 0
+		}
+		// not allowed if the namespace denies setgroups
+		syscall.Setgroups(nil)
+	}
+	if gid >= 0 {
+		if err := syscall.Setgid(gid); err != nil {
+			return fmt.Errorf("setgid %d: %w", gid, err)
+		}
+	}
+	if uid >= 0 {
+		if err := syscall.Setuid(uid); err != nil {
+			return fmt.Errorf("setuid %d: %w", uid, err)
+		}
+	}
+	return nil
+}
+
+// run sets up the root, working directory and credentials and becomes the
+// command
+func (e *enter) run() error {
+	if e.Root >= 0 {
+		if err := unix.Fchdir(e.Root); err != nil {
+			return fmt.Errorf("changing root: %w", err)
+		}
+		if err := unix.Chroot("."); err != nil {
+			return fmt.Errorf("changing root: %w", err)
+		}
+		unix.Close(e.Root)
+	}
+	if e.Wd >= 0 {
+		if err := unix.Fchdir(e.Wd); err != nil {
+			return fmt.Errorf("changing directory: %w", err)
+		}
+		unix.Close(e.Wd)
+	}
+	if err := e.credentials(); err != nil {
+		return err
+	}
+
+	path, err := exec.LookPath(e.Args[0])
+	if err != nil {
+		return err
+	}
+	if e.PID >= 0 {
+		// no new threads once our children go elsewhere, so this comes
+		// last
+		runtime.LockOSThread()
+		if err := unix.Setns(e.PID, unix.CLONE_NEWPID); err != nil {
+			return fmt.Errorf("joining pid namespace: %w", err)
+		}
+		unix.Close(e.PID)
+	}
+	return unix.Exec(path, e.Args, environ())
+}
+
+// runEnter never returns
+func runEnter(v string) {
+	var e enter
+	if err := json.Unmarshal([]byte(v), &e); err != nil {
+		fmt.Fprintf(os.Stderr, "nsenter: %v\n", err)
+		os.Exit(1)
+	}
+	err := e.run()
+	fmt.Fprintf(os.Stderr, "nsenter: %v\n", err)
+	os.Exit(1)
+}
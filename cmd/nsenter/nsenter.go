@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// setnsEnv lists the namespaces for the C constructor in setns.go
+	// to join
+	setnsEnv = "NSENTER_SETNS"
+	// enterEnv carries what's left to do once we are in the namespaces
+	enterEnv = "NSENTER_ENTER"
+)
+
+// fromTarget is what a bare namespace, --root or --wd option gets: the one
+// of the target process
+const fromTarget = "-"
+
+var opts struct {
+	Target int  `short:"t" long:"target" description:"target process to get the namespaces from"`
+	All    bool `short:"a" long:"all" description:"enter all namespaces of the target that differ from ours"`
+
+	Mount  string `short:"m" long:"mount" optional:"yes" optional-value:"-" description:"enter the mount namespace of the target, or of FILE"`
+	UTS    string `short:"u" long:"uts" optional:"yes" optional-value:"-" description:"enter the UTS namespace of the target, or of FILE"`
+	IPC    string `short:"i" long:"ipc" optional:"yes" optional-value:"-" description:"enter the IPC namespace of the target, or of FILE"`
+	Net    string `short:"n" long:"net" optional:"yes" optional-value:"-" description:"enter the network namespace of the target, or of FILE"`
+	PID    string `short:"p" long:"pid" optional:"yes" optional-value:"-" description:"enter the PID namespace of the target, or of FILE"`
+	User   string `short:"U" long:"user" optional:"yes" optional-value:"-" description:"enter the user namespace of the target, or of FILE"`
+	Cgroup string `short:"C" long:"cgroup" optional:"yes" optional-value:"-" description:"enter the cgroup namespace of the target, or of FILE"`
+	Time   string `short:"T" long:"time" optional:"yes" optional-value:"-" description:"enter the time namespace of the target, or of FILE"`
+
+	Root string `short:"r" long:"root" optional:"yes" optional-value:"-" description:"set the root directory to the target's, or to DIR"`
+	Wd   string `short:"w" long:"wd" optional:"yes" optional-value:"-" description:"set the working directory to the target's, or to DIR"`
+
+	Setuid   int  `short:"S" long:"setuid" default:"-1" description:"set the uid in the entered namespaces, 0 when entering a user namespace"`
+	Setgid   int  `short:"G" long:"setgid" default:"-1" description:"set the gid in the entered namespaces, 0 when entering a user namespace"`
+	Preserve bool `long:"preserve-credentials" description:"keep our uid, gid and groups when entering a user namespace"`
+	NoFork   bool `short:"F" long:"no-fork" description:"don't fork before running the command when entering a PID namespace"`
+}
+
+// namespace is one namespace option, user first as setns expects
+type namespace struct {
+	name string
+	opt  *string
+}
+
+var namespaces = []namespace{
+	{"user", &opts.User},
+	{"cgroup", &opts.Cgroup},
+	{"ipc", &opts.IPC},
+	{"uts", &opts.UTS},
+	{"net", &opts.Net},
+	{"pid", &opts.PID},
+	{"mnt", &opts.Mount},
+	{"time", &opts.Time},
+}
+
+func getShell() string {
+	sh := os.Getenv("SHELL")
+	if sh == "" {
+		sh = "/bin/sh"
+	}
+	return sh
+}
+
+// targetPath is a file of the target process in /proc
+func targetPath(name string) (string, error) {
+	if opts.Target <= 0 {
+		return "", fmt.Errorf("%s needs a target process, use -t", name)
+	}
+	return filepath.Join("/proc", strconv.Itoa(opts.Target), name), nil
+}
+
+// open opens what an option points at, without O_CLOEXEC so it survives
+// exec
+func open(path string, flags int) (int, error) {
+	fd, err := unix.Open(path, flags, 0)
+	if err != nil {
+		return -1, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return fd, nil
+}
+
+// sameAsOurs tells whether the target's namespace is the one we're in
+func sameAsOurs(path, name string) bool {
+	theirs, err := os.Readlink(path)
+	if err != nil {
+		return false
+	}
+	ours, err := os.Readlink(filepath.Join("/proc/self/ns", name))
+	return err == nil && ours == theirs
+}
+
+// nsenter opens everything we are going to enter and runs ourselves again
+// to enter it. See setns.go and enter.go for the rest.
+func nsenter(args []string) error {
+	if err := joinable(); err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		args = []string{getShell()}
+	}
+	e := enter{Args: args, Root: -1, Wd: -1, PID: -1, Setuid: opts.Setuid, Setgid: opts.Setgid, Preserve: opts.Preserve}
+
+	var setns []string
+	for _, ns := range namespaces {
+		path := *ns.opt
+		if path == "" && !opts.All {
+			continue
+		}
+		if path == "" || path == fromTarget {
+			p, err := targetPath(filepath.Join("ns", ns.name))
+			if err != nil {
+				return err
+			}
+			if *ns.opt == "" && sameAsOurs(p, ns.name) {
+				continue
+			}
+			path = p
+		}
+		fd, err := open(path, unix.O_RDONLY)
+		if err != nil {
+			return err
+		}
+		switch {
+		case ns.name == "pid" && opts.NoFork:
+			e.PID = fd
+			continue
+		case ns.name == "user":
+			e.User = true
+		}
+		setns = append(setns, fmt.Sprintf("%s=%d", ns.name, fd))
+	}
+
+	// these are opened here, where paths still mean what they say
+	for _, dir := range []struct {
+		opt, target string
+		fd          *int
+	}{
+		{opts.Root, "root", &e.Root},
+		{opts.Wd, "cwd", &e.Wd},
+	} {
+		if dir.opt == "" {
+			continue
+		}
+		path := dir.opt
+		if path == fromTarget {
+			p, err := targetPath(dir.target)
+			if err != nil {
+				return err
+			}
+			path = p
+		}
+		fd, err := open(path, unix.O_RDONLY|unix.O_DIRECTORY)
+		if err != nil {
+			return err
+		}
+		*dir.fd = fd
+	}
+	// a new root keeps us where we were unless told otherwise
+	if e.Root >= 0 && e.Wd < 0 {
+		fd, err := open(".", unix.O_RDONLY|unix.O_DIRECTORY)
+		if err != nil {
+			return err
+		}
+		e.Wd = fd
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	env := append(os.Environ(), setnsEnv+"="+strings.Join(setns, ","), enterEnv+"="+string(b))
+	return unix.Exec("/proc/self/exe", os.Args, env)
+}
+
+func main() {
+	if v, ok := os.LookupEnv(enterEnv); ok {
+		runEnter(v)
+	}
+
+	// everything after the command belongs to it
+	args, err := flags.NewParser(&opts, flags.Default|flags.PassAfterNonOption).Parse()
+	if flags.WroteHelp(err) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := nsenter(args); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// testArgs runs the test binary as nsenter, since nsenter execs itself
+const testArgs = "NSENTER_TEST_ARGS"
+
+func TestMain(m *testing.M) {
+	if v, ok := os.LookupEnv(testArgs); ok {
+		var args []string
+		if err := json.Unmarshal([]byte(v), &args); err != nil {
+			panic(err)
+		}
+		os.Args = append([]string{"nsenter"}, args...)
+		main()
+	}
+	if _, ok := os.LookupEnv(enterEnv); ok {
+		main()
+	}
+	os.Exit(m.Run())
+}
+
+// target starts a process in fresh namespaces for nsenter to join. It
+// names its host "target" and sits in dir.
+func target(t *testing.T, flags uintptr, dir string, maps ...syscall.SysProcIDMap) int {
+	t.Helper()
+	if err := joinable(); err != nil {
+		t.Skip(err)
+	}
+	if os.Getuid() != 0 {
+		t.Skip("needs root to create namespaces")
+	}
+
+	c := exec.Command("sh", "-c", "hostname target && echo ready && exec sleep 60")
+	c.Dir = dir
+	c.SysProcAttr = &syscall.SysProcAttr{Cloneflags: flags, UidMappings: maps, GidMappings: maps}
+	if len(maps) > 0 {
+		// be whoever is root in there
+		c.SysProcAttr.Credential = &syscall.Credential{NoSetGroups: true}
+	}
+	out, err := c.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Start(); err != nil {
+		t.Skipf("can't create namespaces: %v", err)
+	}
+	t.Cleanup(func() {
+		c.Process.Kill()
+		c.Wait()
+	})
+	if l, _ := bufio.NewReader(out).ReadString('\n'); l != "ready\n" {
+		t.Fatalf("target didn't start: %q", l)
+	}
+	return c.Process.Pid
+}
+
+func run(t *testing.T, args ...string) (string, int) {
+	t.Helper()
+	b, err := json.Marshal(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := exec.Command(os.Args[0])
+	c.Env = append(os.Environ(), testArgs+"="+string(b))
+	var out, stderr bytes.Buffer
+	c.Stdout, c.Stderr = &out, &stderr
+	err = c.Run()
+	var exit *exec.ExitError
+	if err != nil && !errors.As(err, &exit) {
+		t.Fatal(err)
+	}
+	if stderr.Len() > 0 {
+		t.Logf("nsenter %s: %s", strings.Join(args, " "), stderr.String())
+	}
+	return out.String(), c.ProcessState.ExitCode()
+}
+
+func nsLink(t *testing.T, pid int, name string) string {
+	t.Helper()
+	l, err := os.Readlink(filepath.Join("/proc", strconv.Itoa(pid), "ns", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestEnter(t *testing.T) {
+	pid := target(t, syscall.CLONE_NEWUTS|syscall.CLONE_NEWNET|syscall.CLONE_NEWIPC|syscall.CLONE_NEWNS|syscall.CLONE_NEWPID, "/")
+	p := strconv.Itoa(pid)
+
+	out, code := run(t, "-t", p, "-a", "sh", "-c", "hostname; cd /proc/self/ns && readlink net ipc mnt pid; exit 4")
+	want := []string{"target"}
+	for _, ns := range []string{"net", "ipc", "mnt", "pid"} {
+		want = append(want, nsLink(t, pid, ns))
+	}
+	if got := strings.Fields(out); code != 4 || strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("nsenter -a = %q, exit %d, want %q", got, code, want)
+	}
+
+	// only what we ask for
+	out, _ = run(t, "-t", p, "--uts", "sh", "-c", "hostname; readlink /proc/self/ns/net")
+	if got := strings.Fields(out); len(got) != 2 || got[0] != "target" || got[1] != nsLink(t, os.Getpid(), "net") {
+		t.Errorf("nsenter --uts = %q", got)
+	}
+	// by file
+	out, _ = run(t, "--net=/proc/"+p+"/ns/net", "readlink", "/proc/self/ns/net")
+	if strings.TrimSpace(out) != nsLink(t, pid, "net") {
+		t.Errorf("nsenter --net=FILE = %q", out)
+	}
+
+	// without forking only the children are in the PID namespace
+	out, _ = run(t, "-t", p, "-p", "-F", "sh", "-c", "readlink /proc/$$/ns/pid /proc/$$/ns/pid_for_children")
+	if got := strings.Fields(out); len(got) != 2 || got[0] == nsLink(t, pid, "pid") || got[1] != nsLink(t, pid, "pid") {
+		t.Errorf("nsenter -p -F = %q", got)
+	}
+
+	if _, code := run(t, "-u", "true"); code == 0 {
+		t.Errorf("nsenter -u without a target worked")
+	}
+}
+
+func TestRootWd(t *testing.T) {
+	dir := t.TempDir()
+	pid := target(t, syscall.CLONE_NEWNS, dir)
+	p := strconv.Itoa(pid)
+
+	if out, _ := run(t, "-t", p, "-w", "pwd"); strings.TrimSpace(out) != dir {
+		t.Errorf("nsenter -w = %q, want %q", out, dir)
+	}
+	if out, _ := run(t, "-t", p, "-m", "-r", "-w", "pwd"); strings.TrimSpace(out) != dir {
+		t.Errorf("nsenter -m -r -w = %q, want %q", out, dir)
+	}
+
+	// directories of our own
+	if out, _ := run(t, "--root=/", "--wd="+dir, "pwd"); strings.TrimSpace(out) != dir {
+		t.Errorf("nsenter --root=/ --wd=DIR = %q, want %q", out, dir)
+	}
+}
+
+func TestUser(t *testing.T) {
+	pid := target(t, syscall.CLONE_NEWUSER|syscall.CLONE_NEWUTS, "/", syscall.SysProcIDMap{ContainerID: 0, HostID: 1000, Size: 1})
+	p := strconv.Itoa(pid)
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-U"}, "0 0 " + host},
+		{[]string{"-a"}, "0 0 target"},
+		{[]string{"-U", "-u", "--preserve-credentials"}, "65534 65534 target"},
+	} {
+		args := append([]string{"-t", p}, tt.args...)
+		out, _ := run(t, append(args, "sh", "-c", "id -u; id -g; hostname")...)
+		if got := strings.Join(strings.Fields(out), " "); got != tt.want {
+			t.Errorf("nsenter %s = %q, want %q", strings.Join(tt.args, " "), got, tt.want)
+		}
+	}
+}
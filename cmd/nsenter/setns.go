@@ -0,0 +1,116 @@
+//go:build cgo
+
+package main
+
+/*
+#define _GNU_SOURCE
+#include <errno.h>
+#include <sched.h>
+#include <signal.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <sys/wait.h>
+#include <unistd.h>
+
+#define MAXNS 8
+
+static pid_t child;
+
+static void forward(int sig)
+{
+	kill(child, sig);
+}
+
+// wait_child forks and returns in the child. The parent passes on how the
+// child ended, and the signals the terminal doesn't already send it.
+static void wait_child(void)
+{
+	int status;
+
+	child = fork();
+	if (child < 0) {
+		fprintf(stderr, "nsenter: fork: %s\n", strerror(errno));
+		_exit(1);
+	}
+	if (child == 0)
+		return;
+	signal(SIGINT, SIG_IGN);
+	signal(SIGQUIT, SIG_IGN);
+	signal(SIGTERM, forward);
+	signal(SIGHUP, forward);
+	while (waitpid(child, &status, 0) < 0) {
+		if (errno != EINTR)
+			_exit(1);
+	}
+	if (WIFSIGNALED(status)) {
+		signal(WTERMSIG(status), SIG_DFL);
+		kill(getpid(), WTERMSIG(status));
+	}
+	_exit(WIFEXITED(status) ? WEXITSTATUS(status) : 1);
+}
+
+// enter joins the namespaces listed in NSENTER_SETNS as name=fd pairs.
+// Joining a user or mount namespace needs a single threaded process, so
+// this runs before the Go runtime starts any threads.
+//
+// Like util-linux it takes two passes: the first joins everything but the
+// user namespace while we still have our privileges, the second joins the
+// user namespace, which comes first in the list, and then whatever needed
+// its capabilities.
+//
+// A PID namespace only takes our children, and the Go runtime can't start
+// threads in a process whose children go elsewhere. So once we have joined
+// one we fork, the parent waits and exits like the child, and the child
+// carries on into Go in the namespace.
+__attribute__((constructor)) static void enter(void)
+{
+	int pid = 0;
+	const char *list = getenv("NSENTER_SETNS");
+	char names[MAXNS][16];
+	int fds[MAXNS];
+	int n = 0;
+
+	if (list == NULL)
+		return;
+	while (*list && n < MAXNS) {
+		int len = strcspn(list, "=");
+		char *end;
+
+		if (list[len] != '=' || len >= (int)sizeof(names[n]))
+			break;
+		memcpy(names[n], list, len);
+		names[n][len] = 0;
+		fds[n] = strtol(list + len + 1, &end, 10);
+		n++;
+		list = *end == ',' ? end + 1 : end;
+	}
+
+	for (int pass = 0; pass < 2; pass++) {
+		for (int i = 0; i < n; i++) {
+			int user = strcmp(names[i], "user") == 0;
+
+			if (fds[i] < 0 || (pass == 0 && user))
+				continue;
+			if (setns(fds[i], 0) < 0) {
+				if (pass == 0)
+					continue;
+				fprintf(stderr, "nsenter: joining %s namespace: %s\n", names[i], strerror(errno));
+				_exit(1);
+			}
+			close(fds[i]);
+			fds[i] = -1;
+			pid |= strcmp(names[i], "pid") == 0;
+		}
+	}
+	if (pid)
+		wait_child();
+}
+*/
+import "C"
+
+// joinable tells whether we can join namespaces: the C constructor above
+// does it, before the Go runtime starts its threads
+func joinable() error {
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !cgo
+
+package main
+
+import "errors"
+
+// joinable needs the C constructor of setns.go to join the user and mount
+// namespaces before the Go runtime starts its threads
+func joinable() error {
+	return errors.New("built without cgo, can't join namespaces")
+}
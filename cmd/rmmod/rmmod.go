@@ -5,7 +5,7 @@ import (
 	"os"
 	"syscall"
 
-	"mybox/pkg/kmodule"
+	"mybox/pkg/kmod"
 )
 
 func main() {
@@ -14,7 +14,7 @@ func main() {
 	}
 
 	for _, modname := range os.Args[1:] {
-		if err := kmodule.Delete(modname, syscall.O_NONBLOCK); err != nil {
+		if err := kmod.Delete(modname, syscall.O_NONBLOCK); err != nil {
 			log.Fatalf("rmmod: %v", err)
 		}
 	}
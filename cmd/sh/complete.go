@@ -0,0 +1,169 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// builtins are the names the interpreter runs itself
+var builtins = []string{
+	"true", ":", "false", "exit", "set", "shift", "unset", "echo", "printf",
+	"break", "continue", "pwd", "cd", "wait", "builtin", "trap", "type",
+	"source", ".", "command", "dirs", "pushd", "popd", "umask", "alias",
+	"unalias", "fg", "bg", "jobs", "getopts", "eval", "test", "[", "exec",
+	"return", "read", "mapfile", "readarray", "shopt",
+}
+
+// keywords are the words after which a command comes
+var keywords = map[string]bool{
+	"if": true, "then": true, "else": true, "elif": true, "do": true,
+	"while": true, "until": true, "!": true, "time": true, "{": true,
+}
+
+// special are the characters escaped in completed words
+const special = " \t\\'\"`$&|;<>()*?[]{}!#"
+
+func escape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(special, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func unescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// completer completes command names and file names for the editor
+type completer struct {
+	r *interp.Runner
+}
+
+// wordStart is where the word ending at pos begins
+func wordStart(line []rune, pos int) int {
+	i := pos
+	for i > 0 {
+		c := line[i-1]
+		if strings.ContainsRune(" \t|;&<>(", c) && (i < 2 || line[i-2] != '\\') {
+			break
+		}
+		i--
+	}
+	return i
+}
+
+// commandPosition tells whether a word starting at start is a command name
+func commandPosition(line []rune, start int) bool {
+	before := strings.TrimRight(string(line[:start]), " \t")
+	if before == "" || strings.ContainsRune("|;&(", rune(before[len(before)-1])) {
+		return true
+	}
+	f := strings.Fields(before)
+	return keywords[f[len(f)-1]]
+}
+
+// complete returns where the word at pos starts and what it could be,
+// escaped and with a space or slash after
+func (c *completer) complete(line []rune, pos int) (int, []string) {
+	start := wordStart(line, pos)
+	word := unescape(string(line[start:pos]))
+	var cands []string
+	if commandPosition(line, start) && !strings.Contains(word, "/") {
+		cands = c.commands(word)
+	} else {
+		cands = c.files(word)
+	}
+	sort.Strings(cands)
+	// names can be both in PATH and builtins
+	uniq := cands[:0]
+	for i, s := range cands {
+		if i == 0 || s != cands[i-1] {
+			uniq = append(uniq, s)
+		}
+	}
+	return start, uniq
+}
+
+func (c *completer) vars(name string) string {
+	if v, ok := c.r.Vars[name]; ok {
+		return v.String()
+	}
+	return os.Getenv(name)
+}
+
+func (c *completer) commands(prefix string) []string {
+	var cands []string
+	add := func(name string) {
+		if strings.HasPrefix(name, prefix) {
+			cands = append(cands, escape(name)+" ")
+		}
+	}
+	for _, b := range builtins {
+		add(b)
+	}
+	for f := range c.r.Funcs {
+		add(f)
+	}
+	for _, dir := range filepath.SplitList(c.vars("PATH")) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if !strings.HasPrefix(e.Name(), prefix) {
+				continue
+			}
+			fi, err := os.Stat(filepath.Join(dir, e.Name()))
+			if err == nil && !fi.IsDir() && fi.Mode()&0o111 != 0 {
+				add(e.Name())
+			}
+		}
+	}
+	return cands
+}
+
+func (c *completer) files(word string) []string {
+	dir, prefix := filepath.Split(word)
+	path := dir
+	switch {
+	case strings.HasPrefix(dir, "~/"):
+		path = filepath.Join(c.vars("HOME"), dir[2:])
+	case path == "":
+		path = "."
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(c.r.Dir, path)
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil
+	}
+	var cands []string
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, prefix) || strings.HasPrefix(name, ".") && !strings.HasPrefix(prefix, ".") {
+			continue
+		}
+		suffix := " "
+		if fi, err := os.Stat(filepath.Join(path, name)); err == nil && fi.IsDir() {
+			suffix = "/"
+		}
+		cands = append(cands, escape(dir+name)+suffix)
+	}
+	return cands
+}
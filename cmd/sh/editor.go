@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+
+	"golang.org/x/term"
+)
+
+// errInterrupt is a line given up with ^C
+var errInterrupt = errors.New("interrupt")
+
+// editor reads lines from a terminal with emacs key bindings, history and
+// completion. The terminal is raw only while a line is read.
+type editor struct {
+	fd   int
+	in   *bufio.Reader
+	out  io.Writer
+	hist *history
+	comp *completer
+
+	buf    []rune
+	pos    int
+	prompt string
+	killed []rune
+}
+
+func newEditor(in *os.File, out io.Writer, hist *history, comp *completer) *editor {
+	return &editor{fd: int(in.Fd()), in: bufio.NewReader(in), out: out, hist: hist, comp: comp}
+}
+
+// readLine shows prompt and reads a line, which goes into the history. It
+// returns io.EOF for ^D on an empty line and errInterrupt for ^C.
+func (e *editor) readLine(prompt string) (string, error) {
+	old, err := term.MakeRaw(e.fd)
+	if err != nil {
+		fmt.Fprint(e.out, prompt)
+		l, err := e.in.ReadString('\n')
+		if err != nil && l == "" {
+			return "", err
+		}
+		l = strings.TrimRight(l, "\n")
+		e.hist.add(l)
+		return l, nil
+	}
+	defer term.Restore(e.fd, old)
+
+	// raw output needs its own carriage returns
+	fmt.Fprint(e.out, strings.ReplaceAll(prompt, "\n", "\r\n"))
+	if i := strings.LastIndexByte(prompt, '\n'); i >= 0 {
+		prompt = prompt[i+1:]
+	}
+	e.prompt, e.buf, e.pos = prompt, nil, 0
+	l, err := e.edit()
+	if err == nil {
+		e.hist.add(l)
+	}
+	return l, err
+}
+
+// refresh redraws the line with the cursor where it belongs
+func (e *editor) refresh() {
+	s := "\r" + e.prompt + string(e.buf) + "\x1b[K"
+	if n := len(e.buf) - e.pos; n > 0 {
+		s += fmt.Sprintf("\x1b[%dD", n)
+	}
+	io.WriteString(e.out, s)
+}
+
+func (e *editor) set(line string) {
+	e.buf = []rune(line)
+	e.pos = len(e.buf)
+}
+
+func (e *editor) insert(r ...rune) {
+	e.buf = append(e.buf[:e.pos], append(append([]rune(nil), r...), e.buf[e.pos:]...)...)
+	e.pos += len(r)
+}
+
+// kill cuts the text between from and to into the kill buffer
+func (e *editor) kill(from, to int) {
+	if from > to {
+		from, to = to, from
+	}
+	e.killed = append([]rune(nil), e.buf[from:to]...)
+	e.buf = append(e.buf[:from], e.buf[to:]...)
+	e.pos = from
+}
+
+func isWord(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+func (e *editor) wordLeft() int {
+	i := e.pos
+	for i > 0 && !isWord(e.buf[i-1]) {
+		i--
+	}
+	for i > 0 && isWord(e.buf[i-1]) {
+		i--
+	}
+	return i
+}
+
+func (e *editor) wordRight() int {
+	i := e.pos
+	for i < len(e.buf) && !isWord(e.buf[i]) {
+		i++
+	}
+	for i < len(e.buf) && isWord(e.buf[i]) {
+		i++
+	}
+	return i
+}
+
+// escape reads what follows an ESC and names the key
+func (e *editor) escape() string {
+	c, _, err := e.in.ReadRune()
+	if err != nil {
+		return ""
+	}
+	if c != '[' && c != 'O' {
+		return "M-" + string(c)
+	}
+	var seq []rune
+	for {
+		c, _, err := e.in.ReadRune()
+		if err != nil {
+			return ""
+		}
+		seq = append(seq, c)
+		if c >= 0x40 && c <= 0x7e {
+			break
+		}
+	}
+	switch string(seq) {
+	case "A":
+		return "up"
+	case "B":
+		return "down"
+	case "C":
+		return "right"
+	case "D":
+		return "left"
+	case "H", "1~", "7~":
+		return "home"
+	case "F", "4~", "8~":
+		return "end"
+	case "3~":
+		return "delete"
+	case "1;5C", "1;3C":
+		return "M-f"
+	case "1;5D", "1;3D":
+		return "M-b"
+	}
+	return ""
+}
+
+func ctrl(c byte) rune {
+	return rune(c & 0x1f)
+}
+
+// edit reads keys until the line is done
+func (e *editor) edit() (string, error) {
+	idx := len(e.hist.entries)
+	var saved string
+	tabs := 0
+	for {
+		c, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		key := string(c)
+		if c == 0x1b {
+			key = e.escape()
+		}
+		if c == '\t' {
+			tabs++
+		} else {
+			tabs = 0
+		}
+
+		switch {
+		case c == '\r' || c == '\n':
+			e.pos = len(e.buf)
+			e.refresh()
+			io.WriteString(e.out, "\r\n")
+			return string(e.buf), nil
+		case c == ctrl('C'):
+			io.WriteString(e.out, "^C\r\n")
+			return "", errInterrupt
+		case c == ctrl('D'):
+			if len(e.buf) == 0 {
+				io.WriteString(e.out, "\r\n")
+				return "", io.EOF
+			}
+			if e.pos < len(e.buf) {
+				e.buf = append(e.buf[:e.pos], e.buf[e.pos+1:]...)
+			}
+		case key == "delete":
+			if e.pos < len(e.buf) {
+				e.buf = append(e.buf[:e.pos], e.buf[e.pos+1:]...)
+			}
+		case c == ctrl('A') || key == "home":
+			e.pos = 0
+		case c == ctrl('E') || key == "end":
+			e.pos = len(e.buf)
+		case c == ctrl('B') || key == "left":
+			e.pos = max(e.pos-1, 0)
+		case c == ctrl('F') || key == "right":
+			e.pos = min(e.pos+1, len(e.buf))
+		case key == "M-b":
+			e.pos = e.wordLeft()
+		case key == "M-f":
+			e.pos = e.wordRight()
+		case key == "M-d":
+			e.kill(e.pos, e.wordRight())
+		case key == "M-\x7f" || c == ctrl('W'):
+			e.kill(e.wordLeft(), e.pos)
+		case c == 0x7f || c == ctrl('H'):
+			if e.pos > 0 {
+				e.buf = append(e.buf[:e.pos-1], e.buf[e.pos:]...)
+				e.pos--
+			}
+		case c == ctrl('K'):
+			e.kill(e.pos, len(e.buf))
+		case c == ctrl('U'):
+			e.kill(0, e.pos)
+		case c == ctrl('Y'):
+			e.insert(e.killed...)
+		case c == ctrl('T'):
+			if e.pos > 0 && len(e.buf) > 1 {
+				i := min(e.pos, len(e.buf)-1)
+				e.buf[i-1], e.buf[i] = e.buf[i], e.buf[i-1]
+				e.pos = i + 1
+			}
+		case c == ctrl('L'):
+			io.WriteString(e.out, "\x1b[H\x1b[2J")
+		case c == ctrl('P') || key == "up":
+			if idx > 0 {
+				if idx == len(e.hist.entries) {
+					saved = string(e.buf)
+				}
+				idx--
+				e.set(e.hist.entries[idx])
+			}
+		case c == ctrl('N') || key == "down":
+			if idx < len(e.hist.entries) {
+				idx++
+				if idx == len(e.hist.entries) {
+					e.set(saved)
+				} else {
+					e.set(e.hist.entries[idx])
+				}
+			}
+		case c == ctrl('R'):
+			done, err := e.search()
+			if err != nil || done {
+				return string(e.buf), err
+			}
+		case c == '\t':
+			e.complete(tabs > 1)
+		case c >= 0x20 && c != 0x7f && len(key) == 1 || c >= 0x80:
+			e.insert(c)
+		}
+		e.refresh()
+	}
+}
+
+// search is ^R, an incremental search back through the history. It tells
+// whether the line found was entered right away.
+func (e *editor) search() (bool, error) {
+	orig, origPos := string(e.buf), e.pos
+	var query []rune
+	idx := len(e.hist.entries)
+	match := ""
+	failed := false
+	show := func() {
+		label := "reverse-i-search"
+		if failed {
+			label = "failing " + label
+		}
+		fmt.Fprintf(e.out, "\r(%s)`%s': %s\x1b[K", label, string(query), match)
+	}
+	find := func(from int) {
+		if i, ok := e.hist.search(string(query), from); ok {
+			idx, match, failed = i, e.hist.entries[i], false
+		} else {
+			failed = true
+		}
+	}
+	show()
+	for {
+		c, _, err := e.in.ReadRune()
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case c == ctrl('R'):
+			if len(query) > 0 {
+				find(idx)
+			}
+		case c == ctrl('G') || c == ctrl('C'):
+			e.set(orig)
+			e.pos = origPos
+			return false, nil
+		case c == 0x7f || c == ctrl('H'):
+			if len(query) > 0 {
+				query = query[:len(query)-1]
+				match, idx = "", len(e.hist.entries)
+				if len(query) > 0 {
+					find(idx)
+				}
+			}
+		case c == '\r' || c == '\n':
+			e.set(match)
+			e.refresh()
+			io.WriteString(e.out, "\r\n")
+			return true, nil
+		case c >= 0x20 && c != 0x7f:
+			query = append(query, c)
+			// the current match may still do
+			find(min(idx+1, len(e.hist.entries)))
+		default:
+			// anything else takes the match for editing
+			e.set(match)
+			if c == 0x1b {
+				e.escape()
+			}
+			return false, nil
+		}
+		show()
+	}
+}
+
+// complete does Tab: the longest common prefix of what could go there, or
+// with list the candidates when there's nothing more to add
+func (e *editor) complete(list bool) {
+	if e.comp == nil {
+		return
+	}
+	start, cands := e.comp.complete(e.buf, e.pos)
+	if len(cands) == 0 {
+		io.WriteString(e.out, "\a")
+		return
+	}
+	prefix := cands[0]
+	for _, s := range cands[1:] {
+		for !strings.HasPrefix(s, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	word := string(e.buf[start:e.pos])
+	if len(prefix) > len(word) {
+		e.buf = append(e.buf[:start], append([]rune(prefix), e.buf[e.pos:]...)...)
+		e.pos = start + len([]rune(prefix))
+		return
+	}
+	if !list {
+		io.WriteString(e.out, "\a")
+		return
+	}
+
+	width := 0
+	for i, s := range cands {
+		cands[i] = strings.TrimSuffix(s, " ")
+		width = max(width, len(cands[i]))
+	}
+	width += 2
+	cols := 80
+	if w, _, err := term.GetSize(e.fd); err == nil && w > 0 {
+		cols = w
+	}
+	cols = max(cols/width, 1)
+	io.WriteString(e.out, "\r\n")
+	for i, s := range cands {
+		if i%cols == cols-1 || i == len(cands)-1 {
+			io.WriteString(e.out, s+"\r\n")
+		} else {
+			fmt.Fprintf(e.out, "%-*s", width, s)
+		}
+	}
+	io.WriteString(e.out, e.prompt)
+}
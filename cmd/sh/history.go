@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// history is the lines typed in, kept in a file as they come
+type history struct {
+	entries []string
+	size    int
+	path    string
+}
+
+// loadHistory reads the last size lines of the history file at path,
+// which may be empty for history that isn't kept
+func loadHistory(path string, size int) *history {
+	h := &history{size: size, path: path}
+	if path == "" {
+		return h
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return h
+	}
+	defer f.Close()
+
+	var lines []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		lines = append(lines, s.Text())
+	}
+	if len(lines) > size {
+		h.entries = lines[len(lines)-size:]
+	} else {
+		h.entries = lines
+	}
+	// the file only grows as we append, cut it down now and then
+	if len(lines) > 2*size {
+		os.WriteFile(path, []byte(strings.Join(h.entries, "\n")+"\n"), 0o600)
+	}
+	return h
+}
+
+// add remembers line, unless it's empty, starts with a space or repeats
+// the one before
+func (h *history) add(line string) {
+	if strings.TrimSpace(line) == "" || strings.HasPrefix(line, " ") {
+		return
+	}
+	if n := len(h.entries); n > 0 && h.entries[n-1] == line {
+		return
+	}
+	h.entries = append(h.entries, line)
+	if len(h.entries) > h.size {
+		h.entries = h.entries[len(h.entries)-h.size:]
+	}
+	if h.path == "" {
+		return
+	}
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	f.WriteString(line + "\n")
+}
+
+// search finds the latest entry before from that contains query
+func (h *history) search(query string, from int) (int, bool) {
+	for i := min(from, len(h.entries)) - 1; i >= 0; i-- {
+		if strings.Contains(h.entries[i], query) {
+			return i, true
+		}
+	}
+	return 0, false
+}
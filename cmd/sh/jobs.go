@@ -0,0 +1,618 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/interp"
+)
+
+// Job control for the interactive shell. Every statement typed in is a job
+// whose commands run in a process group of their own, which has the
+// terminal while the job is in the foreground. As in bash, stopping a job
+// stops the command that was running and lets the statement carry on.
+
+// si_code values of a SIGCHLD
+const (
+	cldStopped   = 5
+	cldContinued = 6
+)
+
+// jobBuiltin stands in for the job control builtins, which the interpreter
+// either lacks or leaves unimplemented, on their way to the exec handler.
+// wait is among them as background jobs aren't the interpreter's.
+const jobBuiltin = "\x00job"
+
+type proc struct {
+	pid     int
+	exited  bool
+	stopped bool
+	status  syscall.WaitStatus
+}
+
+type job struct {
+	id    int
+	text  string
+	fg    bool
+	pgid  int
+	procs []*proc
+	// alive counts the processes in pgid, once they're gone the next
+	// command starts a new group
+	alive int
+	// finished is set when the statement is over
+	finished bool
+	status   int
+	notified bool
+}
+
+func (j *job) stopped() bool {
+	for _, p := range j.procs {
+		if p.stopped && !p.exited {
+			return true
+		}
+	}
+	return false
+}
+
+func (j *job) done() bool {
+	return j.finished && j.alive == 0
+}
+
+func (j *job) state() string {
+	switch {
+	case j.stopped():
+		return "Stopped"
+	case j.done():
+		if j.status != 0 {
+			return fmt.Sprintf("Exit %d", j.status)
+		}
+		return "Done"
+	}
+	return "Running"
+}
+
+type jobKey struct{}
+
+func withJob(ctx context.Context, j *job) context.Context {
+	return context.WithValue(ctx, jobKey{}, j)
+}
+
+type jobTable struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	jobs []*job
+	// tty is our terminal and pgid our process group, tty is -1 without
+	// job control
+	tty  int
+	pgid int
+	out  io.Writer
+}
+
+// newJobTable takes over the terminal on f for job control, if we're in
+// its foreground
+func newJobTable(f *os.File, out io.Writer) *jobTable {
+	t := &jobTable{tty: -1, out: out}
+	t.cond = sync.NewCond(&t.mu)
+
+	fd := int(f.Fd())
+	fg, err := unix.IoctlGetInt(fd, unix.TIOCGPGRP)
+	if err != nil || fg != unix.Getpgrp() {
+		return t
+	}
+	// the terminal signals are for the jobs, not for us
+	signal.Notify(make(chan os.Signal, 1), syscall.SIGTSTP, syscall.SIGTTIN, syscall.SIGTTOU, syscall.SIGQUIT)
+	if pid := os.Getpid(); unix.Getpgrp() != pid {
+		if err := unix.Setpgid(0, 0); err != nil {
+			return t
+		}
+	}
+	t.tty, t.pgid = fd, os.Getpid()
+	t.takeTerminal()
+	go t.watch()
+	return t
+}
+
+func (t *jobTable) enabled() bool {
+	return t.tty >= 0
+}
+
+// takeTerminal puts us back in the foreground. We're in the background
+// when we do it, so SIGTTOU has to be blocked for the terminal to let us.
+func (t *jobTable) takeTerminal() {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	var set, old unix.Sigset_t
+	set.Val[0] = 1 << (unix.SIGTTOU - 1)
+	unix.PthreadSigmask(unix.SIG_BLOCK, &set, &old)
+	unix.IoctlSetPointerInt(t.tty, unix.TIOCSPGRP, t.pgid)
+	unix.PthreadSigmask(unix.SIG_SETMASK, &old, nil)
+}
+
+// sigchld is struct siginfo as filled in for SIGCHLD
+type sigchld struct {
+	Signo, Errno, Code, _ int32
+	Pid                   int32
+	UID                   uint32
+	Status                int32
+	_                     [100]byte
+}
+
+// watch notices stopped and continued processes. exec.Cmd.Wait only
+// hears about the exit, so this collects the rest on each SIGCHLD.
+func (t *jobTable) watch() {
+	chld := make(chan os.Signal, 1)
+	signal.Notify(chld, syscall.SIGCHLD)
+	for range chld {
+		t.mu.Lock()
+		for _, j := range t.jobs {
+			for _, p := range j.procs {
+				if p.exited {
+					continue
+				}
+				var info sigchld
+				if unix.Waitid(unix.P_PID, p.pid, (*unix.Siginfo)(unsafe.Pointer(&info)), unix.WSTOPPED|unix.WCONTINUED|unix.WNOHANG, nil) != nil {
+					continue
+				}
+				switch info.Code {
+				case cldStopped:
+					p.stopped = true
+					p.status = syscall.WaitStatus(info.Status<<8 | 0x7f)
+				case cldContinued:
+					p.stopped = false
+				}
+			}
+		}
+		t.cond.Broadcast()
+		t.mu.Unlock()
+	}
+}
+
+// add starts a job for the statement text
+func (t *jobTable) add(text string, fg bool) *job {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	id := 1
+	for _, j := range t.jobs {
+		id = max(id, j.id+1)
+	}
+	j := &job{id: id, text: text, fg: fg}
+	t.jobs = append(t.jobs, j)
+	return j
+}
+
+func (t *jobTable) remove(j *job) {
+	for i, o := range t.jobs {
+		if o == j {
+			t.jobs = append(t.jobs[:i], t.jobs[i+1:]...)
+			return
+		}
+	}
+}
+
+// finish records the end of j's statement
+func (t *jobTable) finish(j *job, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	j.finished = true
+	j.status = exitCode(err)
+	t.cond.Broadcast()
+}
+
+// environ is what exported variables a command gets
+func environ(env expand.Environ) []string {
+	var list []string
+	env.Each(func(name string, vr expand.Variable) bool {
+		if vr.Exported && vr.IsSet() {
+			list = append(list, name+"="+vr.String())
+		}
+		return true
+	})
+	return list
+}
+
+// start starts c as part of j
+func (t *jobTable) start(j *job, c *exec.Cmd) (*proc, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pgid := j.pgid
+	if j.alive == 0 {
+		pgid = 0
+	}
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pgid: pgid, Foreground: j.fg, Ctty: t.tty}
+	if err := c.Start(); err != nil {
+		return nil, err
+	}
+	p := &proc{pid: c.Process.Pid}
+	if pgid == 0 {
+		j.pgid = p.pid
+	}
+	j.procs = append(j.procs, p)
+	j.alive++
+	t.cond.Broadcast()
+	return p, nil
+}
+
+// execHandler runs the commands of jobs in their process groups, and the
+// job control builtins
+func (t *jobTable) execHandler(next interp.ExecHandlerFunc) interp.ExecHandlerFunc {
+	return func(ctx context.Context, args []string) error {
+		if args[0] == jobBuiltin {
+			return t.builtin(ctx, args[1:])
+		}
+		j, _ := ctx.Value(jobKey{}).(*job)
+		if j == nil || !t.enabled() {
+			return next(ctx, args)
+		}
+
+		hc := interp.HandlerCtx(ctx)
+		path, err := interp.LookPathDir(hc.Dir, hc.Env, args[0])
+		if err != nil {
+			fmt.Fprintln(hc.Stderr, err)
+			return interp.NewExitStatus(127)
+		}
+		c := &exec.Cmd{
+			Path:   path,
+			Args:   args,
+			Env:    environ(hc.Env),
+			Dir:    hc.Dir,
+			Stdin:  hc.Stdin,
+			Stdout: hc.Stdout,
+			Stderr: hc.Stderr,
+		}
+		p, err := t.start(j, c)
+		if err != nil {
+			fmt.Fprintln(hc.Stderr, err)
+			return interp.NewExitStatus(127)
+		}
+		go func() {
+			c.Wait()
+			t.mu.Lock()
+			p.exited, p.status = true, c.ProcessState.Sys().(syscall.WaitStatus)
+			j.alive--
+			t.cond.Broadcast()
+			t.mu.Unlock()
+		}()
+
+		t.mu.Lock()
+		for !p.exited && !p.stopped {
+			t.cond.Wait()
+		}
+		status := p.status
+		t.mu.Unlock()
+		return exitStatus(waitCode(status))
+	}
+}
+
+// exitStatus is the error a handler returns for a status
+func exitStatus(code int) error {
+	if code == 0 {
+		return nil
+	}
+	return interp.NewExitStatus(uint8(code))
+}
+
+// waitCode is the $? of a process that exited, was killed or stopped
+func waitCode(ws syscall.WaitStatus) int {
+	switch {
+	case ws.Stopped():
+		return 128 + int(ws.StopSignal())
+	case ws.Signaled():
+		return 128 + int(ws.Signal())
+	}
+	return ws.ExitStatus()
+}
+
+// callHandler sends the job control builtins to the exec handler, unless
+// there's a function of that name
+func (t *jobTable) callHandler(r *interp.Runner) interp.CallHandlerFunc {
+	return func(ctx context.Context, args []string) ([]string, error) {
+		switch args[0] {
+		case "jobs", "fg", "bg", "wait":
+			if r.Funcs[args[0]] == nil {
+				return append([]string{jobBuiltin}, args...), nil
+			}
+		}
+		return args, nil
+	}
+}
+
+// foreground runs a statement as a foreground job and returns its error
+func (t *jobTable) foreground(ctx context.Context, text string, run func(context.Context) error) error {
+	j := t.add(text, true)
+	err := run(withJob(ctx, j))
+	t.finish(j, err)
+	t.takeTerminal()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if j.stopped() {
+		j.fg, j.notified = false, true
+		fmt.Fprintf(t.out, "\n%s\n", t.line(j))
+	} else {
+		t.remove(j)
+	}
+	return err
+}
+
+// background runs a statement as a background job
+func (t *jobTable) background(ctx context.Context, text string, run func(context.Context) error) {
+	j := t.add(text+" &", false)
+	go func() {
+		t.finish(j, run(withJob(ctx, j)))
+	}()
+
+	// give it a moment to start, like other shells we show its pid
+	timer := time.AfterFunc(100*time.Millisecond, func() {
+		t.mu.Lock()
+		t.cond.Broadcast()
+		t.mu.Unlock()
+	})
+	defer timer.Stop()
+	start := time.Now()
+	t.mu.Lock()
+	for j.pgid == 0 && !j.finished && time.Since(start) < 100*time.Millisecond {
+		t.cond.Wait()
+	}
+	if j.pgid != 0 {
+		fmt.Fprintf(t.out, "[%d] %d\n", j.id, j.pgid)
+	} else {
+		fmt.Fprintf(t.out, "[%d]\n", j.id)
+	}
+	t.mu.Unlock()
+}
+
+// mark is + for the current job and - for the previous one
+func (t *jobTable) mark(j *job) byte {
+	var jobs []*job
+	for _, o := range t.jobs {
+		if !o.fg || o == j {
+			jobs = append(jobs, o)
+		}
+	}
+	switch n := len(jobs); {
+	case n > 0 && jobs[n-1] == j:
+		return '+'
+	case n > 1 && jobs[n-2] == j:
+		return '-'
+	}
+	return ' '
+}
+
+func (t *jobTable) line(j *job) string {
+	return fmt.Sprintf("[%d]%c  %-24s%s", j.id, t.mark(j), j.state(), j.text)
+}
+
+// notify reports the background jobs that finished or stopped since we
+// last looked, and forgets the finished ones
+func (t *jobTable) notify() {
+	if !t.enabled() {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, j := range append([]*job(nil), t.jobs...) {
+		switch {
+		case j.fg:
+		case j.done():
+			fmt.Fprintln(t.out, t.line(j))
+			t.remove(j)
+		case j.stopped() && !j.notified:
+			fmt.Fprintln(t.out, t.line(j))
+			j.notified = true
+		case !j.stopped():
+			j.notified = false
+		}
+	}
+}
+
+// count is how many jobs there are, for \j in prompts
+func (t *jobTable) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for _, j := range t.jobs {
+		if !j.fg {
+			n++
+		}
+	}
+	return n
+}
+
+// stopped tells whether any job is stopped
+func (t *jobTable) stopped() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, j := range t.jobs {
+		if j.stopped() {
+			return true
+		}
+	}
+	return false
+}
+
+// hangup sends the jobs SIGHUP as we leave, and wakes up the stopped ones
+// to get it
+func (t *jobTable) hangup() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, j := range t.jobs {
+		if j.pgid != 0 && j.alive > 0 {
+			unix.Kill(-j.pgid, unix.SIGHUP)
+			unix.Kill(-j.pgid, unix.SIGCONT)
+		}
+	}
+}
+
+// find looks up a job spec: %n, %+, %%, %-, %prefix or nothing for the
+// current job
+func (t *jobTable) find(spec string) (*job, error) {
+	var jobs []*job
+	for _, j := range t.jobs {
+		if !j.fg {
+			jobs = append(jobs, j)
+		}
+	}
+	if len(jobs) == 0 {
+		if spec == "" {
+			spec = "current"
+		}
+		return nil, fmt.Errorf("%s: no such job", spec)
+	}
+	switch spec {
+	case "", "%", "%%", "%+":
+		return jobs[len(jobs)-1], nil
+	case "%-":
+		if len(jobs) > 1 {
+			return jobs[len(jobs)-2], nil
+		}
+		return jobs[0], nil
+	}
+	s := strings.TrimPrefix(spec, "%")
+	if n, err := strconv.Atoi(s); err == nil {
+		for _, j := range jobs {
+			if j.id == n {
+				return j, nil
+			}
+		}
+	} else {
+		for i := len(jobs) - 1; i >= 0; i-- {
+			if strings.HasPrefix(jobs[i].text, s) {
+				return jobs[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%s: no such job", spec)
+}
+
+// wait waits for the background jobs given by pid or job spec, or all of
+// them, to finish or stop, and returns the status of the last
+func (t *jobTable) wait(hc interp.HandlerContext, args []string) error {
+	var jobs []*job
+	for _, spec := range args {
+		if !strings.HasPrefix(spec, "%") {
+			pid, _ := strconv.Atoi(spec)
+			for _, j := range t.jobs {
+				if j.pgid == pid && pid != 0 {
+					jobs = append(jobs, j)
+				}
+			}
+			continue
+		}
+		j, err := t.find(spec)
+		if err != nil {
+			fmt.Fprintf(hc.Stderr, "wait: %v\n", err)
+			return interp.NewExitStatus(127)
+		}
+		jobs = append(jobs, j)
+	}
+	if len(args) == 0 {
+		for _, j := range t.jobs {
+			if !j.fg {
+				jobs = append(jobs, j)
+			}
+		}
+	}
+	status := 0
+	for _, j := range jobs {
+		for !j.done() && !j.stopped() {
+			t.cond.Wait()
+		}
+		status = j.status
+	}
+	return exitStatus(status)
+}
+
+// builtin runs jobs, fg, bg and wait
+func (t *jobTable) builtin(ctx context.Context, args []string) error {
+	hc := interp.HandlerCtx(ctx)
+	name, args := args[0], args[1:]
+	if !t.enabled() {
+		fmt.Fprintf(hc.Stderr, "%s: no job control\n", name)
+		return interp.NewExitStatus(1)
+	}
+	var spec string
+	if len(args) > 0 {
+		spec = args[0]
+	}
+
+	t.mu.Lock()
+	if name == "wait" {
+		defer t.mu.Unlock()
+		return t.wait(hc, args)
+	}
+	if name == "jobs" {
+		defer t.mu.Unlock()
+		for _, j := range t.jobs {
+			if j.fg {
+				continue
+			}
+			if spec == "-p" {
+				fmt.Fprintln(hc.Stdout, j.pgid)
+			} else {
+				fmt.Fprintln(hc.Stdout, t.line(j))
+			}
+			if j.done() {
+				t.remove(j)
+			}
+		}
+		return nil
+	}
+	j, err := t.find(spec)
+	if err != nil {
+		t.mu.Unlock()
+		fmt.Fprintf(hc.Stderr, "%s: %v\n", name, err)
+		return interp.NewExitStatus(1)
+	}
+	if name == "bg" {
+		defer t.mu.Unlock()
+		if j.pgid != 0 && j.alive > 0 {
+			unix.Kill(-j.pgid, unix.SIGCONT)
+		}
+		fmt.Fprintf(hc.Stdout, "[%d]%c %s\n", j.id, t.mark(j), j.text)
+		return nil
+	}
+
+	// fg: hand it the terminal and wait like for any foreground job
+	j.fg = true
+	j.text = strings.TrimSuffix(j.text, " &")
+	fmt.Fprintln(hc.Stdout, j.text)
+	for _, p := range j.procs {
+		p.stopped = false
+	}
+	if j.pgid != 0 && j.alive > 0 {
+		unix.IoctlSetPointerInt(t.tty, unix.TIOCSPGRP, j.pgid)
+		unix.Kill(-j.pgid, unix.SIGCONT)
+	}
+	for !j.done() && !j.stopped() {
+		t.cond.Wait()
+	}
+	t.mu.Unlock()
+	t.takeTerminal()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if j.stopped() {
+		j.fg, j.notified = false, true
+		fmt.Fprintf(t.out, "\n%s\n", t.line(j))
+		return exitStatus(128 + int(unix.SIGTSTP))
+	}
+	t.remove(j)
+	status := j.status
+	if n := len(j.procs); n > 0 {
+		status = waitCode(j.procs[n-1].status)
+	}
+	return exitStatus(status)
+}
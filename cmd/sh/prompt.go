@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// Default prompts, as in POSIX
+const (
+	defaultPS1 = `\$ `
+	defaultPS2 = "> "
+)
+
+// quoteDoc escapes what would be expanded in a here-document-like string
+func quoteDoc(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, "$", `\$`, "`", "\\`")
+	return r.Replace(s)
+}
+
+// escapes expands the bash prompt escapes in ps. What they give is quoted
+// so that the expansion after leaves it alone.
+func escapes(ps string, r *interp.Runner, jobs, number int) string {
+	get := func(name string) string {
+		if v, ok := r.Vars[name]; ok && v.IsSet() {
+			return v.String()
+		}
+		return os.Getenv(name)
+	}
+	var b strings.Builder
+	for i := 0; i < len(ps); i++ {
+		c := ps[i]
+		if c != '\\' || i+1 == len(ps) {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		var s string
+		switch ps[i] {
+		case 'u':
+			s = get("USER")
+			if s == "" {
+				s = strconv.Itoa(os.Getuid())
+				if u, err := user.LookupId(s); err == nil {
+					s = u.Username
+				}
+			}
+		case 'h', 'H':
+			s, _ = os.Hostname()
+			if ps[i] == 'h' {
+				s, _, _ = strings.Cut(s, ".")
+			}
+		case 'w', 'W':
+			s = r.Dir
+			home := get("HOME")
+			switch {
+			case home != "" && home != "/" && s == home:
+				s = "~"
+			case ps[i] == 'W':
+				s = filepath.Base(s)
+			case home != "" && home != "/" && strings.HasPrefix(s, home+"/"):
+				s = "~" + s[len(home):]
+			}
+		case '$':
+			s = "$"
+			if os.Geteuid() == 0 {
+				s = "#"
+			}
+		case 'n':
+			s = "\n"
+		case 't':
+			s = time.Now().Format("15:04:05")
+		case 'A':
+			s = time.Now().Format("15:04")
+		case 'd':
+			s = time.Now().Format("Mon Jan 02")
+		case 's':
+			s = filepath.Base(strings.TrimPrefix(os.Args[0], "-"))
+		case 'j':
+			s = strconv.Itoa(jobs)
+		case '!', '#':
+			s = strconv.Itoa(number)
+		case 'e':
+			s = "\x1b"
+		case 'a':
+			s = "\a"
+		case '\\':
+			s = `\`
+		case '[', ']':
+			// only there to tell bash what doesn't take up room
+		default:
+			s = `\` + string(ps[i])
+		}
+		b.WriteString(quoteDoc(s))
+	}
+	return b.String()
+}
+
+// expandPrompt expands a prompt like bash does with promptvars on: the
+// escapes first, then parameters and command substitutions. status is the
+// $? of the last command.
+func expandPrompt(ps string, r *interp.Runner, status, jobs, number int) string {
+	ps = escapes(ps, r, jobs, number)
+	word, err := syntax.NewParser().Document(strings.NewReader(ps))
+	if err != nil {
+		return ps
+	}
+	cfg := &expand.Config{
+		Env: expand.FuncEnviron(func(name string) string {
+			if name == "?" {
+				return strconv.Itoa(status)
+			}
+			if v, ok := r.Vars[name]; ok {
+				return v.String()
+			}
+			return os.Getenv(name)
+		}),
+		CmdSubst: func(w io.Writer, cs *syntax.CmdSubst) error {
+			sub := r.Subshell()
+			interp.StdIO(nil, w, os.Stderr)(sub)
+			for _, stmt := range cs.Stmts {
+				if err := sub.Run(context.Background(), stmt); err != nil {
+					if _, ok := interp.IsExitStatus(err); !ok {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	}
+	s, err := expand.Document(cfg, word)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ps
+	}
+	return s
+}
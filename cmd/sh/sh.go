@@ -2,11 +2,14 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/jessevdk/go-flags"
@@ -36,7 +39,14 @@ func sh(w io.Writer, cmd string, args []string) error {
 }
 
 func runAll(cmd string, args []string) error {
-	r, err := interp.New(interp.StdIO(os.Stdin, os.Stdout, os.Stderr))
+	interactive := command == "" && len(args) == 0 && term.IsTerminal(int(os.Stdin.Fd()))
+	ropts := []interp.RunnerOption{interp.StdIO(os.Stdin, os.Stdout, os.Stderr)}
+	var jobs *jobTable
+	if interactive {
+		jobs = newJobTable(os.Stdin, os.Stderr)
+		ropts = append(ropts, interp.ExecHandlers(jobs.execHandler))
+	}
+	r, err := interp.New(ropts...)
 	if err != nil {
 		return err
 	}
@@ -44,8 +54,9 @@ func runAll(cmd string, args []string) error {
 		return run(r, strings.NewReader(command), "")
 	}
 	if len(args) == 0 {
-		if term.IsTerminal(int(os.Stdin.Fd())) {
-			return runInteractive(r, os.Stdin, os.Stdout, os.Stderr)
+		if interactive {
+			interp.CallHandler(jobs.callHandler(r))(r)
+			return runInteractive(r, jobs)
 		}
 		return run(r, os.Stdin, "")
 	}
@@ -57,6 +68,17 @@ func runAll(cmd string, args []string) error {
 	return nil
 }
 
+// exitCode is the $? for the error of a statement
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if e, ok := interp.IsExitStatus(err); ok {
+		return int(e)
+	}
+	return 1
+}
+
 func run(r *interp.Runner, reader io.Reader, name string) error {
 	prog, err := syntax.NewParser().Parse(reader, name)
 	if err != nil {
@@ -76,18 +98,102 @@ func runPath(r *interp.Runner, path string) error {
 	return run(r, f, path)
 }
 
-func runInteractive(r *interp.Runner, stdin io.Reader, stdout, stderr io.Writer) error {
+// source runs the file at path in r's shell, if there is one
+func source(ctx context.Context, r *interp.Runner, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return nil
+	}
+	lit := func(s string) *syntax.Word {
+		return &syntax.Word{Parts: []syntax.WordPart{&syntax.Lit{Value: s}}}
+	}
+	err := r.Run(ctx, &syntax.Stmt{Cmd: &syntax.CallExpr{Args: []*syntax.Word{lit("."), lit(path)}}})
+	if _, ok := interp.IsExitStatus(err); err != nil && !ok {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	return err
+}
+
+// lines feeds the parser a line at a time from the editor, with the
+// prompt that fits
+type lines struct {
+	ed      *editor
+	pending string
+	prompt  func() string
+}
+
+func (l *lines) Read(p []byte) (int, error) {
+	if l.pending == "" {
+		line, err := l.ed.readLine(l.prompt())
+		if err != nil {
+			return 0, err
+		}
+		l.pending = line + "\n"
+	}
+	n := copy(p, l.pending)
+	l.pending = l.pending[n:]
+	return n, nil
+}
+
+// runInteractive reads commands from the terminal with line editing, and
+// runs each as a job when there's job control. Login shells, those called
+// with a leading dash, read /etc/profile and ~/.profile first, and every
+// interactive shell then reads ~/.shrc.
+func runInteractive(r *interp.Runner, jobs *jobTable) error {
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, os.Interrupt)
-	parser := syntax.NewParser()
-	fmt.Fprintf(stdout, "$ ")
+
+	ctx := context.Background()
+	r.Reset()
+	get := func(name, def string) string {
+		if v, ok := r.Vars[name]; ok && v.IsSet() {
+			return v.String()
+		}
+		// Vars only has what the last Run left
+		if v, ok := os.LookupEnv(name); ok {
+			return v
+		}
+		return def
+	}
+	home := get("HOME", "")
+	if strings.HasPrefix(os.Args[0], "-") {
+		source(ctx, r, "/etc/profile")
+		if home != "" {
+			source(ctx, r, filepath.Join(home, ".profile"))
+		}
+	}
+	if home != "" {
+		source(ctx, r, filepath.Join(home, ".shrc"))
+	}
+	if r.Exited() {
+		return nil
+	}
+
+	histfile := ""
+	if home != "" {
+		histfile = filepath.Join(home, ".sh_history")
+	}
+	histfile = get("HISTFILE", histfile)
+	size, err := strconv.Atoi(get("HISTSIZE", ""))
+	if err != nil || size <= 0 {
+		size = 1000
+	}
+	hist := loadHistory(histfile, size)
+	ed := newEditor(os.Stdin, os.Stderr, hist, &completer{r: r})
+
 	var runErr error
-	fn := func(stmts []*syntax.Stmt) bool {
-		if parser.Incomplete() {
-			fmt.Fprintf(stdout, "> ")
-			return true
+	incomplete, warned := false, false
+	in := &lines{ed: ed, prompt: func() string {
+		if incomplete {
+			return expandPrompt(get("PS2", defaultPS2), r, exitCode(runErr), 0, len(hist.entries)+1)
 		}
+		jobs.notify()
+		return expandPrompt(get("PS1", defaultPS1), r, exitCode(runErr), jobs.count(), len(hist.entries)+1)
+	}}
+	printer := syntax.NewPrinter()
+	fn := func(stmts []*syntax.Stmt) bool {
+		incomplete = false
 		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
 		go func() {
 			select {
 			case <-signals:
@@ -98,18 +204,75 @@ func runInteractive(r *interp.Runner, stdin io.Reader, stdout, stderr io.Writer)
 			}
 		}()
 		for _, stmt := range stmts {
-			runErr = r.Run(ctx, stmt)
+			if !jobs.enabled() {
+				runErr = r.Run(ctx, stmt)
+			} else if stmt.Background {
+				fg := *stmt
+				fg.Background = false
+				var text strings.Builder
+				printer.Print(&text, &fg)
+				sub := r.Subshell()
+				// it outlives the line it came from
+				jobs.background(context.Background(), text.String(), func(ctx context.Context) error {
+					return sub.Run(ctx, &fg)
+				})
+				runErr = nil
+			} else {
+				var text strings.Builder
+				printer.Print(&text, stmt)
+				runErr = jobs.foreground(ctx, text.String(), func(ctx context.Context) error {
+					return r.Run(ctx, stmt)
+				})
+			}
 			if r.Exited() {
+				if jobs.stopped() && !warned {
+					fmt.Fprintln(os.Stderr, "There are stopped jobs.")
+					warned = true
+					return true
+				}
 				return false
 			}
+			warned = false
 		}
-		fmt.Fprintf(stdout, "$ ")
 		return true
 	}
-	if err := parser.Interactive(stdin, fn); err != nil {
-		return err
+	for {
+		parser := syntax.NewParser()
+		err := parser.Interactive(in, func(stmts []*syntax.Stmt) bool {
+			if parser.Incomplete() {
+				incomplete = true
+				return true
+			}
+			return fn(stmts)
+		})
+		in.pending, incomplete = "", false
+		var perr syntax.ParseError
+		switch {
+		case errors.Is(err, errInterrupt):
+			runErr = interp.NewExitStatus(130)
+			continue
+		case errors.As(err, &perr):
+			fmt.Fprintln(os.Stderr, err)
+			runErr = interp.NewExitStatus(2)
+			continue
+		case err != nil && !errors.Is(err, io.EOF):
+			return err
+		}
+		if err == nil && !r.Exited() {
+			// ^D, which exits like the builtin
+			if jobs.stopped() && !warned {
+				fmt.Fprintln(os.Stderr, "There are stopped jobs.")
+				warned = true
+				continue
+			}
+			fmt.Fprintln(os.Stderr, "exit")
+		}
+		jobs.hangup()
+		if r.Exited() {
+			return runErr
+		}
+		return nil
 	}
-	return runErr
 }
 
 func Shell(stdout io.Writer, args []string) error {
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+// testShell runs the test binary as an interactive sh
+const testShell = "SH_TEST_SHELL"
+
+func TestMain(m *testing.M) {
+	if _, ok := os.LookupEnv(testShell); ok {
+		os.Args = []string{"sh"}
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// console is a shell running on a pty
+type console struct {
+	t    *testing.T
+	c    *exec.Cmd
+	ptm  *os.File
+	mu   sync.Mutex
+	out  bytes.Buffer
+	seen int
+}
+
+// start runs the shell with HOME in a new directory, which is returned,
+// after setup has put files there
+func start(t *testing.T, setup func(home string), env ...string) (*console, string) {
+	t.Helper()
+	home := t.TempDir()
+	if setup != nil {
+		setup(home)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := exec.Command(exe)
+	c.Env = append([]string{testShell + "=1", "HOME=" + home, "PATH=" + os.Getenv("PATH"), "PS1=$ "}, env...)
+	c.Dir = home
+	ptm, err := pty.Start(c)
+	if err != nil {
+		t.Skipf("no ptys: %v", err)
+	}
+	tm := &console{t: t, c: c, ptm: ptm}
+	go func() {
+		b := make([]byte, 1024)
+		for {
+			n, err := ptm.Read(b)
+			tm.mu.Lock()
+			tm.out.Write(b[:n])
+			tm.mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		c.Process.Kill()
+		c.Wait()
+		ptm.Close()
+	})
+	tm.expect("$ ")
+	return tm, home
+}
+
+func (tm *console) send(s string) {
+	tm.t.Helper()
+	if _, err := tm.ptm.WriteString(s); err != nil {
+		tm.t.Fatal(err)
+	}
+}
+
+// expect waits for s in what the shell wrote since the last expect
+func (tm *console) expect(s string) {
+	tm.t.Helper()
+	for end := time.Now().Add(5 * time.Second); time.Now().Before(end); time.Sleep(10 * time.Millisecond) {
+		tm.mu.Lock()
+		out := tm.out.String()[tm.seen:]
+		if i := strings.Index(out, s); i >= 0 {
+			tm.seen += i + len(s)
+			tm.mu.Unlock()
+			return
+		}
+		tm.mu.Unlock()
+	}
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.t.Fatalf("no %q in %q", s, tm.out.String()[tm.seen:])
+}
+
+// exit leaves the shell and returns its exit code
+func (tm *console) exit(cmd string) int {
+	tm.t.Helper()
+	tm.send(cmd)
+	done := make(chan error, 1)
+	go func() { done <- tm.c.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		tm.t.Fatal("shell did not exit")
+	}
+	return tm.c.ProcessState.ExitCode()
+}
+
+func TestEditing(t *testing.T) {
+	tm, _ := start(t, nil)
+
+	tm.send("echo bc\x01\x06\x06\x06\x06\x06a\x05d\r")
+	tm.expect("abcd\r\n")
+	// kill a word and yank it back twice
+	tm.send("echo one two\x17\x19\x19\r")
+	tm.expect("one twotwo\r\n")
+	tm.send("echo xyz\x02\x02\x7f\r")
+	tm.expect("yz\r\n")
+	tm.send("echo gone\x15echo kept\r")
+	tm.expect("kept\r\n")
+	tm.send("echo left\x1b[D\x1b[Di\x1b[3~\r")
+	tm.expect("leit\r\n")
+	// ^C gives the line up
+	tm.send("echo never\x03echo after\r")
+	tm.expect("after\r\n")
+	if code := tm.exit("exit 3\r"); code != 3 {
+		t.Errorf("exit code = %d, want 3", code)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	histfile := filepath.Join(t.TempDir(), "history")
+	os.WriteFile(histfile, []byte("echo from before\n"), 0o600)
+	tm, _ := start(t, nil, "HISTFILE="+histfile)
+
+	tm.send("\x1b[A\r")
+	tm.expect("from before\r\n")
+	tm.send("echo first\r")
+	tm.expect("first\r\n")
+	tm.send(" echo secret\r")
+	tm.expect("secret\r\n")
+	tm.send("\x10\x10\x0e\r")
+	tm.expect("first\r\n")
+	tm.send("\x12befo\r")
+	tm.expect("from before\r\n")
+	// ^R again looks further back
+	tm.send("echo fir\x12f\x12\x12\x07\r")
+	tm.expect("fir\r\n")
+	tm.exit("\x04")
+
+	b, err := os.ReadFile(histfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "echo from before\necho first\necho from before\necho fir\n"
+	if string(b) != want {
+		t.Errorf("history = %q, want %q", b, want)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	tm, _ := start(t, func(home string) {
+		os.Mkdir(filepath.Join(home, "some dir"), 0o755)
+		os.WriteFile(filepath.Join(home, "some dir", "file"), []byte("contents\n"), 0o644)
+		os.WriteFile(filepath.Join(home, "alpha"), nil, 0o644)
+		os.WriteFile(filepath.Join(home, "alps"), nil, 0o644)
+	})
+
+	tm.send("ech\t1\r")
+	tm.expect("\r\n1\r\n")
+	tm.send("cat so\tf\t\r")
+	tm.expect("contents\r\n")
+	tm.send("echo al\t\t")
+	tm.expect("alpha  alps\r\n")
+	tm.send("\x15echo al\th\t\r")
+	tm.expect("alpha\r\n")
+}
+
+func TestPrompt(t *testing.T) {
+	tm, home := start(t, func(home string) {
+		os.WriteFile(filepath.Join(home, ".shrc"), []byte("PS1='[\\W $?]$(echo x)$ '\nPS2='more> '\n"), 0o644)
+	})
+	tm.send("false\r")
+	tm.expect("[~ 1]x$ ")
+	tm.send("cd /\r")
+	tm.expect("[/ 0]x$ ")
+	tm.send("echo 'a\r")
+	tm.expect("more> ")
+	tm.send("b'\r")
+	tm.expect("a\r\nb\r\n")
+	tm.send("echo $HOME\r")
+	tm.expect(home + "\r\n")
+}
+
+func TestJobs(t *testing.T) {
+	tm, _ := start(t, nil)
+
+	tm.send("sleep 30\r")
+	time.Sleep(200 * time.Millisecond)
+	tm.send("\x1a")
+	tm.expect("[1]+  Stopped                 sleep 30\r\n")
+	tm.send("sleep 31 &\r")
+	tm.expect("[2] ")
+	tm.send("(exit 4) & wait %3; echo $?\r")
+	tm.expect("4\r\n")
+	tm.expect("[3]+  Exit 4                  (exit 4) &\r\n")
+	tm.send("jobs\r")
+	tm.expect("[1]-  Stopped                 sleep 30\r\n[2]+  Running                 sleep 31 &\r\n")
+	tm.send("bg %1\r")
+	tm.expect("[1]- sleep 30\r\n")
+	tm.send("jobs\r")
+	tm.expect("[1]-  Running                 sleep 30\r\n")
+
+	// the job gets the terminal, so ^C is for it
+	tm.send("fg %1\r")
+	tm.expect("sleep 30\r\n")
+	time.Sleep(200 * time.Millisecond)
+	tm.send("\x03")
+	tm.send("echo $?\r")
+	tm.expect("130\r\n")
+	tm.send("kill $(jobs -p); wait %2; echo $?\r")
+	tm.expect("143\r\n")
+	tm.expect("[2]+  Exit 143                sleep 31 &\r\n")
+	tm.send("fg\r")
+	tm.expect("fg: current: no such job\r\n")
+
+	tm.send("sleep 32\r")
+	time.Sleep(200 * time.Millisecond)
+	tm.send("\x1a")
+	tm.expect("Stopped")
+	tm.send("exit\r")
+	tm.expect("There are stopped jobs.\r\n")
+	tm.exit("exit\r")
+}
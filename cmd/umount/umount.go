@@ -1,97 +1,258 @@
+// umount detaches file systems, like util-linux umount.
+//
+// Synopsis:
+//
+//	umount [-dflv] DIR|DEVICE...
+//	umount -R [-dflv] DIR...
+//	umount -a [-dflv] [-t TYPES]
+//
+// A DEVICE is unmounted from where it was mounted last. -R unmounts DIR and
+// everything mounted below it, deepest first. When a file system is busy,
+// the processes holding it are listed.
 package main
 
 import (
 	"errors"
-	"flag"
+	"fmt"
 	"log"
-	"syscall"
-	"unsafe"
-)
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"mybox/pkg/mount"
+	"mybox/pkg/mount/loop"
 
-var (
-	force = flag.Bool("f", false, "Force unmount")
-	lazy  = flag.Bool("l", false, "Lazy unmount")
+	"github.com/jessevdk/go-flags"
+	"golang.org/x/sys/unix"
 )
 
-// pulled mount flags from unix package
+// options are the flags of umount
+type options struct {
+	All       bool   `short:"a" long:"all" description:"unmount all file systems"`
+	Types     string `short:"t" long:"types" description:"with -a, only unmount file systems of these types"`
+	Recursive bool   `short:"R" long:"recursive" description:"unmount a subtree and all its submounts"`
+	Detach    bool   `short:"d" long:"detach-loop" description:"free the loop device after unmounting"`
+	Force     bool   `short:"f" long:"force" description:"force unmount"`
+	Lazy      bool   `short:"l" long:"lazy" description:"detach now, clean up when no longer busy"`
+	Verbose   bool   `short:"v" long:"verbose" description:"say what is done"`
+	NoMtab    bool   `short:"n" long:"no-mtab" description:"ignored, there is no mtab"`
+}
+
+// Exit codes of util-linux umount
 const (
-	UMOUNT_NOFOLLOW = 0x8
-	MNT_FORCE       = 0x1
-	MNT_DETACH      = 0x2
-	ENOENT          = syscall.Errno(0x2)
-	EAGAIN          = syscall.Errno(0xb)
-	EINVAL          = syscall.Errno(0x16)
+	exitUsage   = 1
+	exitFailure = 32
+	exitSome    = 64
 )
 
-var (
-	errEAGAIN error = syscall.EAGAIN
-	errEINVAL error = syscall.EINVAL
-	errENOENT error = syscall.ENOENT
-)
+// pseudo are the types umount -a leaves alone unless told otherwise
+const pseudo = "noproc,nodevfs,nodevpts,nosysfs,norpc_pipefs,nonfsd,noselinuxfs"
+
+// procfs is where busy finds the processes
+var procfs = "/proc"
 
-func errnoErr(e syscall.Errno) error {
-	switch e {
-	case 0:
-		return nil
-	case EAGAIN:
-		return errEAGAIN
-	case EINVAL:
-		return errEINVAL
-	case ENOENT:
-		return errENOENT
-	}
-	return e
+var errUsage = errors.New("bad usage")
+
+// canonical is the absolute path without symlinks, as mountinfo has it
+func canonical(path string) string {
+	if p, err := filepath.Abs(path); err == nil {
+		path = p
+	}
+	if p, err := filepath.EvalSymlinks(path); err == nil {
+		path = p
+	}
+	return path
 }
 
-func Unmount(target string, flags int) (err error) {
-	var _p0 *byte
-	_p0, err = syscall.BytePtrFromString(target)
-	if err != nil {
-		return
+// lookup finds what arg, a mount point or a device, names. Later mounts
+// hide earlier ones, so the last match wins.
+func lookup(mounts []*mount.Info, arg string) (*mount.Info, error) {
+	path := canonical(arg)
+	for i := len(mounts) - 1; i >= 0; i-- {
+		m := mounts[i]
+		if m.Path == path || m.Source == arg || m.Source == path {
+			return m, nil
+		}
 	}
-	_, _, e1 := syscall.Syscall(syscall.SYS_UMOUNT2, uintptr(unsafe.Pointer(_p0)), uintptr(flags), 0)
-	if e1 != 0 {
-		err = errnoErr(e1)
+	if _, err := os.Stat(arg); err != nil {
+		return nil, fmt.Errorf("%s: no mount point specified.", arg)
 	}
-	return
+	return nil, fmt.Errorf("%s: not mounted.", arg)
 }
 
-func umountDisk(path string, force, lazy bool) error {
-	flg := UMOUNT_NOFOLLOW
-	if len(path) == 0 {
-		return errors.New("path cannot be empty")
+// subtree is top and everything mounted below it, in the order they
+// have to be unmounted
+func subtree(mounts []*mount.Info, top *mount.Info) []*mount.Info {
+	prefix := strings.TrimSuffix(top.Path, "/") + "/"
+	var sub []*mount.Info
+	for i := len(mounts) - 1; i >= 0; i-- {
+		m := mounts[i]
+		if m.Path == top.Path || strings.HasPrefix(m.Path, prefix) {
+			sub = append(sub, m)
+		}
 	}
-	if force {
-		flg |= MNT_FORCE
+	return sub
+}
+
+// ofTypes is what umount -a unmounts: every mount of the types but the
+// root, last mounted first
+func ofTypes(mounts []*mount.Info, types string) []*mount.Info {
+	if types == "" {
+		types = pseudo
 	}
-	if lazy {
-		flg |= MNT_DETACH
+	var all []*mount.Info
+	for i := len(mounts) - 1; i >= 0; i-- {
+		m := mounts[i]
+		if m.Path != "/" && mount.TypeMatch(types, m.FSType) {
+			all = append(all, m)
+		}
 	}
-	if err := Unmount(path, flg); err != nil {
-		return err
+	return all
+}
+
+// busy lists the processes with a file, directory or root on the file
+// system of m, as "PID (COMMAND)"
+func busy(m *mount.Info) []string {
+	dev := unix.Mkdev(m.Major, m.Minor)
+	uses := func(path string) bool {
+		var st unix.Stat_t
+		return unix.Stat(path, &st) == nil && st.Dev == dev
 	}
-	return nil
+	dirs, _ := os.ReadDir(procfs)
+	var pids []int
+	for _, d := range dirs {
+		pid, err := strconv.Atoi(d.Name())
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		dir := filepath.Join(procfs, d.Name())
+		found := uses(filepath.Join(dir, "cwd")) || uses(filepath.Join(dir, "root")) || uses(filepath.Join(dir, "exe"))
+		if !found {
+			fds, _ := os.ReadDir(filepath.Join(dir, "fd"))
+			for _, fd := range fds {
+				if uses(filepath.Join(dir, "fd", fd.Name())) {
+					found = true
+					break
+				}
+			}
+		}
+		if found {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+	var procs []string
+	for _, pid := range pids {
+		comm, _ := os.ReadFile(filepath.Join(procfs, strconv.Itoa(pid), "comm"))
+		procs = append(procs, fmt.Sprintf("%d (%s)", pid, strings.TrimSpace(string(comm))))
+	}
+	return procs
 }
 
-func umount(args []string) error {
-	if len(args) < 1 {
-		return errors.New("usage: umount [-f|-l] /path/to/disk")
+// umountError explains a failed umount(2) the way util-linux does
+func umountError(m *mount.Info, err error) error {
+	var errno unix.Errno
+	if !errors.As(err, &errno) {
+		return fmt.Errorf("%s: %v", m.Path, err)
+	}
+	switch errno {
+	case unix.EBUSY:
+		if procs := busy(m); len(procs) > 0 {
+			return fmt.Errorf("%s: target is busy, in use by %s.", m.Path, strings.Join(procs, ", "))
+		}
+		return fmt.Errorf("%s: target is busy.", m.Path)
+	case unix.EINVAL:
+		return fmt.Errorf("%s: not mounted.", m.Path)
+	case unix.EPERM, unix.EACCES:
+		return fmt.Errorf("%s: must be superuser to unmount.", m.Path)
 	}
+	return fmt.Errorf("%s: %v.", m.Path, errno)
+}
 
-	for _, disk := range args {
-		err := umountDisk(disk, *force, *lazy)
-		if err != nil {
-			log.Println(err)
+// umountOne unmounts m and with -d frees the loop device under it
+func (opts *options) umountOne(m *mount.Info) error {
+	if err := mount.Unmount(m.Path, opts.Force, opts.Lazy); err != nil {
+		return umountError(m, err)
+	}
+	if opts.Verbose {
+		log.Printf("%s (%s) unmounted", m.Path, m.Source)
+	}
+	if opts.Detach && strings.HasPrefix(m.Source, "/dev/loop") {
+		// an autoclear device is already gone with its last mount
+		if err := loop.ClearFile(m.Source); err != nil && !errors.Is(err, unix.ENXIO) {
+			return fmt.Errorf("%s: %v", m.Source, err)
 		}
 	}
 	return nil
 }
 
+func run(opts options, args []string) (int, error) {
+	mounts, err := mount.Mountinfo()
+	if err != nil {
+		return exitFailure, err
+	}
+
+	var todo []*mount.Info
+	failed := 0
+	switch {
+	case opts.All:
+		if len(args) != 0 {
+			return exitUsage, errUsage
+		}
+		todo = ofTypes(mounts, opts.Types)
+	case len(args) == 0:
+		return exitUsage, errUsage
+	default:
+		for _, a := range args {
+			m, err := lookup(mounts, a)
+			if err != nil {
+				log.Print(err)
+				failed++
+				continue
+			}
+			if opts.Recursive {
+				todo = append(todo, subtree(mounts, m)...)
+			} else {
+				todo = append(todo, m)
+			}
+		}
+	}
+
+	ok := 0
+	for _, m := range todo {
+		if err := opts.umountOne(m); err != nil {
+			log.Print(err)
+			failed++
+			continue
+		}
+		ok++
+	}
+	switch {
+	case failed == 0:
+		return 0, nil
+	case ok > 0:
+		return exitSome, nil
+	}
+	return exitFailure, nil
+}
+
 func main() {
-	flag.Parse()
-	args := flag.Args()
+	var opts options
+	args, err := flags.Parse(&opts)
+	if flags.WroteHelp(err) {
+		os.Exit(0)
+	}
+	if err != nil {
+		os.Exit(exitUsage)
+	}
+	log.SetFlags(0)
+	log.SetPrefix("umount: ")
 
-	if err := umount(args); err != nil {
-		log.Fatal(err)
+	code, err := run(opts, args)
+	if err != nil {
+		log.Print(err)
 	}
+	os.Exit(code)
 }
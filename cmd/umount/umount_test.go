@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+
+	"mybox/pkg/mount"
+	"mybox/pkg/mount/loop"
+
+	"golang.org/x/sys/unix"
+)
+
+// testArgs runs the test binary as umount with these arguments
+const testArgs = "UMOUNT_TEST_ARGS"
+
+// privateNS makes the mount namespace of the test binary private first
+const privateNS = "UMOUNT_TEST_PRIVATE"
+
+func TestMain(m *testing.M) {
+	if v, ok := os.LookupEnv(testArgs); ok {
+		var args []string
+		if err := json.Unmarshal([]byte(v), &args); err != nil {
+			panic(err)
+		}
+		if _, ok := os.LookupEnv(privateNS); ok {
+			// keep unmounts from propagating back
+			if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+				panic(err)
+			}
+		}
+		os.Args = append([]string{"umount"}, args...)
+		main()
+	}
+	os.Exit(m.Run())
+}
+
+// umountCmd runs umount with args and returns its output and exit code
+func umountCmd(t *testing.T, args ...string) (string, int) {
+	t.Helper()
+	return runUmount(t, false, args)
+}
+
+// umountCmdNS runs umount in a private mount namespace
+func umountCmdNS(t *testing.T, args ...string) (string, int) {
+	t.Helper()
+	return runUmount(t, true, args)
+}
+
+func runUmount(t *testing.T, ns bool, args []string) (string, int) {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(args)
+	c := exec.Command(exe)
+	c.Env = append(os.Environ(), testArgs+"="+string(b))
+	if ns {
+		c.Env = append(c.Env, privateNS+"=1")
+		c.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWNS}
+	}
+	out, err := c.CombinedOutput()
+	if err != nil && c.ProcessState == nil {
+		t.Fatal(err)
+	}
+	return string(out), c.ProcessState.ExitCode()
+}
+
+func testMounts(t *testing.T) []*mount.Info {
+	t.Helper()
+	f, err := os.Open("testdata/mountinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	mounts, err := mount.ParseMountinfo(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mounts
+}
+
+func ids(mounts []*mount.Info) []int {
+	var ids []int
+	for _, m := range mounts {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+func TestLookup(t *testing.T) {
+	mounts := testMounts(t)
+	notMounted := t.TempDir()
+	for _, tt := range []struct {
+		arg string
+		id  int
+		err string
+	}{
+		{arg: "/srv/cache", id: 41},
+		{arg: "/srv/cache/", id: 41},
+		{arg: "/mnt/my data", id: 44},
+		// a device is unmounted from where it was mounted last
+		{arg: "/dev/sda2", id: 44},
+		{arg: "/dev/loop0", id: 42},
+		{arg: "/nonexistent/dir", err: "/nonexistent/dir: no mount point specified."},
+		{arg: notMounted, err: notMounted + ": not mounted."},
+	} {
+		m, err := lookup(mounts, tt.arg)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("lookup(%q): got %v, want %q", tt.arg, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("lookup(%q): %v", tt.arg, err)
+			continue
+		}
+		if m.ID != tt.id {
+			t.Errorf("lookup(%q): got mount %d, want %d", tt.arg, m.ID, tt.id)
+		}
+	}
+}
+
+func TestSubtree(t *testing.T) {
+	mounts := testMounts(t)
+	byID := map[int]*mount.Info{}
+	for _, m := range mounts {
+		byID[m.ID] = m
+	}
+	for _, tt := range []struct {
+		top  int
+		want []int
+	}{
+		// /srvx is beside /srv, not below it
+		{top: 40, want: []int{42, 41, 40}},
+		{top: 25, want: []int{26, 25}},
+		{top: 43, want: []int{43}},
+		{top: 22, want: []int{44, 43, 42, 41, 40, 26, 25, 24, 23, 22}},
+	} {
+		if got := ids(subtree(mounts, byID[tt.top])); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("subtree(%d): got %v, want %v", tt.top, got, tt.want)
+		}
+	}
+}
+
+func TestOfTypes(t *testing.T) {
+	mounts := testMounts(t)
+	for _, tt := range []struct {
+		types string
+		want  []int
+	}{
+		{types: "", want: []int{44, 43, 42, 41, 40, 25}},
+		{types: "tmpfs", want: []int{43, 41}},
+		{types: "ext4,squashfs", want: []int{44, 42, 40}},
+		{types: "notmpfs,ext4", want: []int{42, 26, 25, 24, 23}},
+	} {
+		if got := ids(ofTypes(mounts, tt.types)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ofTypes(%q): got %v, want %v", tt.types, got, tt.want)
+		}
+	}
+}
+
+func mountTmpfs(t *testing.T, path string) {
+	t.Helper()
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Mount("none", path, "tmpfs", 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { unix.Unmount(path, unix.MNT_DETACH) })
+}
+
+func isMounted(t *testing.T, path string) bool {
+	t.Helper()
+	mounts, err := mount.Mountinfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range mounts {
+		if m.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+func TestUmount(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("needs root to mount")
+	}
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a")
+	mountTmpfs(t, a)
+	if out, code := umountCmd(t, "-v", a); code != 0 || out != fmt.Sprintf("umount: %s (none) unmounted\n", a) {
+		t.Errorf("umount %s: %d %q", a, code, out)
+	}
+	if isMounted(t, a) {
+		t.Errorf("%s still mounted", a)
+	}
+	out, code := umountCmd(t, a)
+	if want := fmt.Sprintf("umount: %s: not mounted.\n", a); code != exitFailure || out != want {
+		t.Errorf("umount %s again: got %d %q, want %d %q", a, code, out, exitFailure, want)
+	}
+
+	b := filepath.Join(dir, "b")
+	mountTmpfs(t, b)
+	out, code = umountCmd(t, a, b)
+	if code != exitSome || !strings.Contains(out, "not mounted") {
+		t.Errorf("umount %s %s: %d %q", a, b, code, out)
+	}
+	if isMounted(t, b) {
+		t.Errorf("%s still mounted", b)
+	}
+	if _, code := umountCmd(t); code != exitUsage {
+		t.Errorf("umount with no arguments: got %d, want %d", code, exitUsage)
+	}
+}
+
+func TestUmountRecursive(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("needs root to mount")
+	}
+	dir := t.TempDir()
+	top := filepath.Join(dir, "top")
+	mountTmpfs(t, top)
+	mountTmpfs(t, filepath.Join(top, "a"))
+	mountTmpfs(t, filepath.Join(top, "a", "b"))
+	mountTmpfs(t, filepath.Join(top, "c"))
+	beside := filepath.Join(dir, "topx")
+	mountTmpfs(t, beside)
+
+	if out, code := umountCmd(t, top); code != exitFailure || !strings.Contains(out, "target is busy") {
+		t.Errorf("umount %s with submounts: %d %q", top, code, out)
+	}
+	if out, code := umountCmd(t, "-R", top); code != 0 {
+		t.Fatalf("umount -R %s: %d %q", top, code, out)
+	}
+	for _, p := range []string{top, filepath.Join(top, "a"), filepath.Join(top, "a", "b"), filepath.Join(top, "c")} {
+		if isMounted(t, p) {
+			t.Errorf("%s still mounted", p)
+		}
+	}
+	if !isMounted(t, beside) {
+		t.Errorf("%s was unmounted too", beside)
+	}
+}
+
+func TestUmountAll(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("needs root to mount")
+	}
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a")
+	mountTmpfs(t, a)
+	b := filepath.Join(dir, "b")
+	mountTmpfs(t, b)
+
+	// unmounting everything happens in a mount namespace of its own
+	out, code := umountCmdNS(t, "-a", "-v", "-t", "tmpfs")
+	if code != 0 && code != exitSome {
+		t.Fatalf("umount -a: %d %q", code, out)
+	}
+	for _, p := range []string{a, b} {
+		if !strings.Contains(out, "umount: "+p+" (none) unmounted\n") {
+			t.Errorf("umount -a did not unmount %s: %q", p, out)
+		}
+	}
+	if strings.Contains(out, "umount: /proc ") {
+		t.Errorf("umount -a -t tmpfs unmounted /proc: %q", out)
+	}
+	if !isMounted(t, a) || !isMounted(t, b) {
+		t.Errorf("umount -a reached out of its mount namespace")
+	}
+}
+
+func TestUmountBusy(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("needs root to mount")
+	}
+	a := filepath.Join(t.TempDir(), "a")
+	mountTmpfs(t, a)
+	sleep := exec.Command("sleep", "60")
+	sleep.Dir = a
+	if err := sleep.Start(); err != nil {
+		t.Skip(err)
+	}
+	out, code := umountCmd(t, a)
+	sleep.Process.Kill()
+	sleep.Wait()
+	want := fmt.Sprintf("umount: %s: target is busy, in use by %d (sleep).\n", a, sleep.Process.Pid)
+	if code != exitFailure || out != want {
+		t.Errorf("umount %s: got %d %q, want %d %q", a, code, out, exitFailure, want)
+	}
+	if out, code := umountCmd(t, a); code != 0 {
+		t.Errorf("umount %s when no longer busy: %d %q", a, code, out)
+	}
+}
+
+func TestUmountDetachLoop(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("needs root to mount")
+	}
+	if _, err := os.Stat("/dev/loop-control"); err != nil {
+		t.Skip("no loop devices")
+	}
+	dir := t.TempDir()
+	img := filepath.Join(dir, "disk")
+	src, err := os.Open("../../pkg/mount/testdata/1MB.ext4_vfat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst, _ := os.Create(img)
+	io.Copy(dst, src)
+	src.Close()
+	dst.Close()
+
+	// a loop device that stays after the unmount unless told to go
+	l, err := loop.New(img, "ext4", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Free()
+	f, err := os.Open(l.Dev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := unix.IoctlLoopGetStatus64(int(f.Fd()))
+	if err == nil {
+		// the ext4 partition starts at the second sector
+		info.Offset = 512
+		err = unix.IoctlLoopSetStatus64(int(f.Fd()), info)
+	}
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mnt := filepath.Join(dir, "mnt")
+	os.Mkdir(mnt, 0o755)
+	if _, err := l.Mount(mnt, unix.MS_RDONLY); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { unix.Unmount(mnt, unix.MNT_DETACH) })
+
+	if out, code := umountCmd(t, "-d", l.Dev); code != 0 {
+		t.Fatalf("umount -d %s: %d %q", l.Dev, code, out)
+	}
+	if isMounted(t, mnt) {
+		t.Errorf("%s still mounted", mnt)
+	}
+	backing := filepath.Join("/sys/block", filepath.Base(l.Dev), "loop/backing_file")
+	if b, err := os.ReadFile(backing); err == nil {
+		t.Errorf("%s still backed by %s", l.Dev, b)
+	}
+}
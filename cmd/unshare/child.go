@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// childEnv carries the child's half of the options
+const childEnv = "UNSHARE_CHILD"
+
+// child is what's left to do inside the new namespaces, where only a new
+// process can go: mounts, and waiting for the parent to map our ids
+type child struct {
+	Args        []string
+	Env         []string
+	Propagation string
+	// Chroot is the new root of the command, for unshare chroot DIR CMD
+	Chroot    string
+	MountProc string
+	// PID unshares the PID namespace for the children of the command
+	PID bool
+	// User is set in a new user namespace, Mapped once we have our ids
+	User, Mapped bool
+	// KillSignal is what we get when unshare, our Parent, dies
+	KillSignal unix.Signal
+	Parent     int
+}
+
+func (ch *child) run() error {
+	if !ch.Mapped {
+		// fd 3 says go, or closes without a word if the parent gave up
+		sync := os.NewFile(3, "sync")
+		var b [1]byte
+		if n, _ := sync.Read(b[:]); n != 1 {
+			return fmt.Errorf("parent failed to set up the namespaces")
+		}
+		sync.Close()
+	}
+	if ch.User && !ch.Mapped {
+		// we came in unmapped, and exec took our capabilities for it.
+		// Once more as whoever we are now gets them back.
+		ch.Mapped, ch.Parent = true, os.Getppid()
+		b, err := json.Marshal(ch)
+		if err != nil {
+			return err
+		}
+		if err := os.Setenv(childEnv, string(b)); err != nil {
+			return err
+		}
+		return unix.Exec("/proc/self/exe", os.Args, os.Environ())
+	}
+	if ch.Mapped && ch.KillSignal != 0 {
+		// the capabilities we got back cleared the parent death signal,
+		// and unshare may have died since
+		if err := unix.Prctl(unix.PR_SET_PDEATHSIG, uintptr(ch.KillSignal), 0, 0, 0); err != nil {
+			return fmt.Errorf("setting the parent death signal: %w", err)
+		}
+		if os.Getppid() != ch.Parent {
+			return fmt.Errorf("unshare is gone")
+		}
+	}
+
+	if flag, ok := propagation[ch.Propagation]; ok {
+		if err := unix.Mount("none", "/", "", unix.MS_REC|flag, ""); err != nil {
+			return fmt.Errorf("setting %s propagation on /: %w", ch.Propagation, err)
+		}
+	}
+	if ch.Chroot != "" {
+		if err := unix.Chroot(ch.Chroot); err != nil {
+			return fmt.Errorf("chroot %s: %w", ch.Chroot, err)
+		}
+		if err := unix.Chdir("/"); err != nil {
+			return err
+		}
+	}
+	if ch.MountProc != "" {
+		if err := unix.Mount("proc", ch.MountProc, "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+			return fmt.Errorf("mounting proc on %s: %w", ch.MountProc, err)
+		}
+	}
+
+	path := ch.Args[0]
+	if !strings.Contains(path, "/") {
+		var err error
+		if path, err = exec.LookPath(path); err != nil {
+			return err
+		}
+	}
+	if ch.PID {
+		// no new threads once our children go elsewhere, so this comes
+		// last
+		runtime.LockOSThread()
+		if err := unix.Unshare(unix.CLONE_NEWPID); err != nil {
+			return fmt.Errorf("unshare pid namespace: %w", err)
+		}
+	}
+	return unix.Exec(path, ch.Args, ch.Env)
+}
+
+// runChild never returns, it becomes the command or dies
+func runChild(v string) {
+	var ch child
+	err := json.Unmarshal([]byte(v), &ch)
+	if err == nil {
+		err = ch.run()
+	}
+	fmt.Fprintf(os.Stderr, "unshare: %v\n", err)
+	os.Exit(1)
+}
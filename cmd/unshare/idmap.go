@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// idMap is one line of uid_map or gid_map
+type idMap struct {
+	inner, outer, count int
+}
+
+func (m idMap) String() string {
+	return fmt.Sprintf("%d %d %d", m.inner, m.outer, m.count)
+}
+
+// subordinate finds the first block of ids belonging to name or id in an
+// /etc/subuid style file
+func subordinate(path, name string, id int) (idMap, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return idMap{}, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Split(strings.TrimSpace(s.Text()), ":")
+		if len(fields) != 3 || (fields[0] != name && fields[0] != strconv.Itoa(id)) {
+			continue
+		}
+		start, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return idMap{}, fmt.Errorf("%s: bad start %q", path, fields[1])
+		}
+		count, err := strconv.Atoi(fields[2])
+		if err != nil || count <= 0 {
+			return idMap{}, fmt.Errorf("%s: bad count %q", path, fields[2])
+		}
+		return idMap{outer: start, count: count}, nil
+	}
+	if err := s.Err(); err != nil {
+		return idMap{}, err
+	}
+	return idMap{}, fmt.Errorf("%s: no ids for %s", path, name)
+}
+
+// lookupID takes a number as is and looks anything else up by name
+func lookupID(s string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(s); err == nil {
+		return id, nil
+	}
+	id, err := lookup(s)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
+
+func lookupUser(name string) (string, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return "", err
+	}
+	return u.Uid, nil
+}
+
+func lookupGroup(name string) (string, error) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return "", err
+	}
+	return g.Gid, nil
+}
+
+// mapOne maps our own id to inner, and the subordinate ids of file to the
+// next free block from 0 with --map-auto
+func mapOne(uid bool, own int, inner *int, file string) ([]idMap, error) {
+	var m []idMap
+	if inner != nil {
+		m = append(m, idMap{*inner, own, 1})
+	}
+	if !opts.MapAuto {
+		return m, nil
+	}
+
+	name := strconv.Itoa(own)
+	if uid {
+		if u, err := user.LookupId(name); err == nil {
+			name = u.Username
+		}
+	} else if g, err := user.LookupGroupId(name); err == nil {
+		name = g.Name
+	}
+	sub, err := subordinate(file, name, own)
+	if err != nil {
+		return nil, err
+	}
+	if inner != nil && *inner == 0 {
+		sub.inner = 1
+	}
+	return append(m, sub), nil
+}
+
+// maps works out uid_map and gid_map from the options
+func maps() (uids, gids []idMap, err error) {
+	var uid, gid *int
+	euid, egid := os.Geteuid(), os.Getegid()
+	switch {
+	case opts.MapRoot:
+		zero := 0
+		uid, gid = &zero, &zero
+	case opts.MapCurrent:
+		uid, gid = &euid, &egid
+	}
+	if opts.MapUser != "" {
+		id, err := lookupID(opts.MapUser, lookupUser)
+		if err != nil {
+			return nil, nil, fmt.Errorf("--map-user: %w", err)
+		}
+		uid = &id
+	}
+	if opts.MapGroup != "" {
+		id, err := lookupID(opts.MapGroup, lookupGroup)
+		if err != nil {
+			return nil, nil, fmt.Errorf("--map-group: %w", err)
+		}
+		gid = &id
+	}
+
+	if uids, err = mapOne(true, euid, uid, "/etc/subuid"); err != nil {
+		return nil, nil, err
+	}
+	if gids, err = mapOne(false, egid, gid, "/etc/subgid"); err != nil {
+		return nil, nil, err
+	}
+	return uids, gids, nil
+}
+
+// setgroups is what goes in /proc/PID/setgroups. Without privileges the
+// kernel only takes a gid_map of our own group once setgroups is denied,
+// newgidmap handles that itself.
+func setgroups() string {
+	if opts.Setgroups != "" || opts.MapAuto {
+		return opts.Setgroups
+	}
+	if opts.MapRoot || opts.MapCurrent || opts.MapGroup != "" {
+		return "deny"
+	}
+	return ""
+}
+
+// writeMap writes a uid_map or gid_map for pid. We can write a map of our
+// own id directly, anything more goes through the setuid newuidmap and
+// newgidmap from shadow, which check /etc/subuid and /etc/subgid.
+func writeMap(pid int, kind string, own int, m []idMap) error {
+	if len(m) == 0 {
+		return nil
+	}
+	if os.Geteuid() == 0 || (len(m) == 1 && m[0].count == 1 && m[0].outer == own) {
+		var b strings.Builder
+		for _, l := range m {
+			fmt.Fprintln(&b, l)
+		}
+		// the kernel wants it in a single write
+		path := fmt.Sprintf("/proc/%d/%s_map", pid, kind)
+		if err := os.WriteFile(path, []byte(b.String()), 0); err != nil {
+			return fmt.Errorf("writing %s_map: %w", kind, err)
+		}
+		return nil
+	}
+
+	args := []string{strconv.Itoa(pid)}
+	for _, l := range m {
+		args = append(args, strings.Fields(l.String())...)
+	}
+	out, err := exec.Command("new"+kind+"map", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("new%smap: %v: %s", kind, err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func writeMaps(pid int, uids, gids []idMap, setgroups string) error {
+	if setgroups != "" {
+		path := fmt.Sprintf("/proc/%d/setgroups", pid)
+		if err := os.WriteFile(path, []byte(setgroups), 0); err != nil {
+			return fmt.Errorf("writing setgroups: %w", err)
+		}
+	}
+	if err := writeMap(pid, "uid", os.Geteuid(), uids); err != nil {
+		return err
+	}
+	return writeMap(pid, "gid", os.Getegid(), gids)
+}
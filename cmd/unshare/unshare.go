@@ -1,106 +1,244 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"os/exec"
-	"strings"
+	"os/signal"
+	"runtime"
 	"syscall"
 
 	"github.com/jessevdk/go-flags"
+	"golang.org/x/sys/unix"
 )
 
 var opts struct {
-	ipc    bool     `short:"i" long:"ipc" description:"Unshare the IPC namespace"`
-	mount  bool     `short:"m" long:"mount" description:"Unshare the mount namespace"`
-	pid    bool     `short:"p" long:"pid" description:"Unshare the PID namespace"`
-	net    bool     `short:"n" long:"net" description:"Unshare the net namespace"`
-	uts    bool     `short:"U" long:"uts" description:"Unshare the uts namespace"`
-	user   bool     `short:"u" long:"user" description:"Unshare the user namespace"`
-	env    bool     `short:"E" long:"preserve-env" description:"preserve environment variables"`
-	all    bool     `short:"a" long:"all" description:"Unshare all options provided"`
+	IPC    bool `short:"i" long:"ipc" description:"Unshare the IPC namespace"`
+	Mount  bool `short:"m" long:"mount" description:"Unshare the mount namespace"`
+	PID    bool `short:"p" long:"pid" description:"Unshare the PID namespace"`
+	Net    bool `short:"n" long:"net" description:"Unshare the net namespace"`
+	UTS    bool `short:"u" long:"uts" description:"Unshare the uts namespace"`
+	User   bool `short:"U" long:"user" description:"Unshare the user namespace"`
+	Cgroup bool `short:"C" long:"cgroup" description:"Unshare the cgroup namespace"`
+	Time   bool `short:"T" long:"time" description:"Unshare the time namespace"`
+	All    bool `short:"a" long:"all" description:"Unshare all namespaces"`
+
+	Fork        bool   `short:"f" long:"fork" description:"fork the command instead of running it directly, so it is pid 1 of a new PID namespace"`
+	KillChild   string `long:"kill-child" optional:"yes" optional-value:"KILL" description:"send the child this signal when unshare dies, implies --fork"`
+	MountProc   string `long:"mount-proc" optional:"yes" optional-value:"/proc" description:"mount proc here before running the command, implies --mount"`
+	Propagation string `long:"propagation" choice:"private" choice:"shared" choice:"slave" choice:"unchanged" default:"private" description:"mount propagation in a new mount namespace"`
+
+	MapRoot    bool   `short:"r" long:"map-root-user" description:"map the current user and group to root, implies --user"`
+	MapCurrent bool   `short:"c" long:"map-current-user" description:"map the current user and group to themselves, implies --user"`
+	MapUser    string `long:"map-user" description:"map the current user to this uid or name, implies --user"`
+	MapGroup   string `long:"map-group" description:"map the current group to this gid or name, implies --user"`
+	MapAuto    bool   `long:"map-auto" description:"map the first subordinate ids from /etc/subuid and /etc/subgid, implies --user"`
+	Setgroups  string `long:"setgroups" choice:"allow" choice:"deny" description:"allow or deny setgroups(2) in the user namespace"`
+
+	Env    bool     `short:"E" long:"preserve-env" description:"preserve environment variables"`
 	SetEnv []string `short:"e" long:"env" description:"set environment variables for command ex: (--env USER=suwu)"`
 }
 
+// propagation flags for --propagation
+var propagation = map[string]uintptr{
+	"private": unix.MS_PRIVATE,
+	"shared":  unix.MS_SHARED,
+	"slave":   unix.MS_SLAVE,
+}
+
 func getShell() string {
-	sh := os.ExpandEnv("SHELL")
+	sh := os.Getenv("SHELL")
 	if sh == "" {
-		sh = "/bin/bash"
+		sh = "/bin/sh"
 	}
 	return sh
 }
 
-func setEnv(args []string) {
-	for _, v := range args {
-		if strings.IndexByte(v, '=') > 0 {
-			kv := strings.SplitN(v, "=", 2) // split key/value pair in 2
-			if err := os.Setenv(kv[0], kv[1]); err != nil {
-				log.Println(err)
-			}
-		}
+// environ is the environment of the command
+func environ() []string {
+	env := os.Environ()
+	if len(opts.SetEnv) > 0 && !opts.Env {
+		env = nil
 	}
+	return append(env, opts.SetEnv...)
 }
 
-func unshare(args []string) error {
-	c := exec.Command(args[0], args[1:]...)
-	c.SysProcAttr = &syscall.SysProcAttr{}
-	if opts.mount {
-		c.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNS
+// implied turns on what other options need
+func implied() {
+	if opts.All {
+		opts.IPC, opts.Mount, opts.PID, opts.Net = true, true, true, true
+		opts.UTS, opts.User, opts.Cgroup, opts.Time = true, true, true, true
+	}
+	if opts.MountProc != "" {
+		opts.Mount = true
+	}
+	if opts.KillChild != "" {
+		opts.Fork = true
+	}
+	if opts.MapRoot || opts.MapCurrent || opts.MapUser != "" || opts.MapGroup != "" || opts.MapAuto {
+		opts.User = true
 	}
-	if opts.uts {
-		c.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUTS
+	if !opts.IPC && !opts.Mount && !opts.Net && !opts.PID && !opts.User && !opts.UTS && !opts.Cgroup && !opts.Time {
+		opts.PID = true
+		opts.User = true
 	}
-	if opts.ipc {
-		c.SysProcAttr.Cloneflags |= syscall.CLONE_NEWIPC
+}
+
+// command starts us again in the new namespaces, see child for the rest
+func command(args []string) (*exec.Cmd, error) {
+	if len(args) == 0 {
+		args = []string{getShell()}
+	}
+	ch := child{Args: args, Env: environ(), PID: opts.PID && !opts.Fork, User: opts.User}
+	// chroot is ours to do, in the new namespaces where we may, and its
+	// command runs in the new root
+	if args[0] == "chroot" && len(args) > 1 {
+		ch.Chroot, ch.Args = args[1], args[2:]
+		if len(ch.Args) == 0 {
+			ch.Args = []string{getShell()}
+		}
 	}
-	if opts.net {
-		c.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNET
+	if opts.Mount && opts.Propagation != "unchanged" {
+		ch.Propagation = opts.Propagation
 	}
-	if opts.pid {
-		c.SysProcAttr.Cloneflags |= syscall.CLONE_NEWPID
+	ch.MountProc = opts.MountProc
+	if opts.KillChild != "" {
+		sig := unix.SignalNum("SIG" + opts.KillChild)
+		if sig == 0 {
+			sig = unix.SignalNum(opts.KillChild)
+		}
+		if sig == 0 {
+			return nil, fmt.Errorf("unknown signal %q", opts.KillChild)
+		}
+		ch.KillSignal = sig
 	}
-	if opts.user {
-		c.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+	b, err := json.Marshal(ch)
+	if err != nil {
+		return nil, err
 	}
 
-	if args[0] == "chroot" {
-		syscall.Setuid(0)
-		c.SysProcAttr.Chroot = args[1]
+	c := exec.Command("/proc/self/exe")
+	c.Args = []string{os.Args[0]}
+	c.Env = append(os.Environ(), childEnv+"="+string(b))
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	c.SysProcAttr = &syscall.SysProcAttr{}
+
+	for _, ns := range []struct {
+		on   bool
+		flag uintptr
+	}{
+		{opts.Mount, unix.CLONE_NEWNS},
+		{opts.UTS, unix.CLONE_NEWUTS},
+		{opts.IPC, unix.CLONE_NEWIPC},
+		{opts.Net, unix.CLONE_NEWNET},
+		{opts.User, unix.CLONE_NEWUSER},
+		{opts.Cgroup, unix.CLONE_NEWCGROUP},
+	} {
+		if ns.on {
+			c.SysProcAttr.Cloneflags |= ns.flag
+		}
+	}
+	// without --fork the command itself stays where it is and only its
+	// children land in the new PID namespace, see child. The time
+	// namespace always works that way but exec moves us into it.
+	if opts.PID && opts.Fork {
+		c.SysProcAttr.Cloneflags |= unix.CLONE_NEWPID
+	}
+	if opts.Time {
+		c.SysProcAttr.Unshareflags |= unix.CLONE_NEWTIME
 	}
+	c.SysProcAttr.Pdeathsig = ch.KillSignal
+	return c, nil
+}
 
-	c.Stdin = os.Stdin
-	c.Stdout = os.Stdout
-	c.Stderr = os.Stderr
+// run starts c, sets up its user namespace and lets it go on to the
+// command. It returns the exit status of the command.
+func run(c *exec.Cmd) (int, error) {
+	uids, gids, err := maps()
+	if err != nil {
+		return 1, err
+	}
 
-	if opts.env {
-		c.Env = os.Environ()
+	// the child waits for us on its end of this
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 1, err
 	}
+	defer w.Close()
+	c.ExtraFiles = []*os.File{r}
 
-	if len(opts.SetEnv) > 0 {
-		c.Env = append(c.Env, opts.SetEnv...)
+	// Pdeathsig goes off when the thread that started the child exits
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	err = c.Start()
+	r.Close()
+	if err != nil {
+		return 1, err
 	}
 
-	if err := c.Run(); err != nil {
-		return err
+	// the terminal signals the command too, we wait for it to finish
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		for sig := range sigs {
+			if sig == syscall.SIGTERM {
+				c.Process.Signal(sig)
+			}
+		}
+	}()
+
+	if opts.User {
+		if err := writeMaps(c.Process.Pid, uids, gids, setgroups()); err != nil {
+			w.Close()
+			c.Wait()
+			return 1, err
+		}
 	}
-	return nil
+	if _, err := w.Write([]byte{0}); err != nil {
+		return 1, err
+	}
+	w.Close()
+
+	err = c.Wait()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		if ws, ok := exit.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			return 128 + int(ws.Signal()), nil
+		}
+		return exit.ExitCode(), nil
+	}
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
 }
 
 func main() {
-	args, err := flags.Parse(&opts)
+	if v, ok := os.LookupEnv(childEnv); ok {
+		runChild(v)
+	}
+
+	// everything after the command belongs to it
+	args, err := flags.NewParser(&opts, flags.Default|flags.PassAfterNonOption).Parse()
 	if flags.WroteHelp(err) {
 		os.Exit(0)
 	}
 	if err != nil {
 		log.Fatal(err)
 	}
+	implied()
 
-	if !opts.ipc && !opts.mount && !opts.net && !opts.pid && !opts.user && !opts.uts {
-		opts.pid = true
-		opts.user = true
+	c, err := command(args)
+	if err != nil {
+		log.Fatal(err)
 	}
-
-	if err := unshare(args); err != nil {
+	code, err := run(c)
+	if err != nil {
 		log.Fatal(err)
 	}
+	os.Exit(code)
 }
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+)
+
+// mainEnv makes the test binary run unshare, for tests that kill it
+const mainEnv = "UNSHARE_TEST_MAIN"
+
+// the test binary stands in for unshare when it starts itself again
+func TestMain(m *testing.M) {
+	if v, ok := os.LookupEnv(childEnv); ok {
+		runChild(v)
+	}
+	if _, ok := os.LookupEnv(mainEnv); ok {
+		main()
+	}
+	os.Exit(m.Run())
+}
+
+var defaults = opts
+
+// unshare runs argv like main does and returns what the command printed
+func unshare(t *testing.T, argv ...string) (string, int) {
+	t.Helper()
+	if os.Getuid() != 0 {
+		t.Skip("needs root to create namespaces")
+	}
+
+	opts = defaults
+	args, err := flags.NewParser(&opts, flags.Default|flags.PassAfterNonOption).ParseArgs(argv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	implied()
+	c, err := command(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	c.Stdout = &b
+	code, err := run(c)
+	if err != nil {
+		t.Fatalf("unshare %s: %v", strings.Join(argv, " "), err)
+	}
+	return b.String(), code
+}
+
+func TestMapRootUser(t *testing.T) {
+	out, code := unshare(t, "-r", "sh", "-c", "id -u; id -g; cat /proc/self/setgroups; cat /proc/self/uid_map")
+	if got, want := strings.Fields(out), []string{"0", "0", "deny", "0", "0", "1"}; code != 0 || strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("unshare -r = %q, exit %d, want %q", got, code, want)
+	}
+}
+
+func TestMapUser(t *testing.T) {
+	out, _ := unshare(t, "--map-user=1234", "--map-group=4321", "--setgroups=allow", "sh", "-c", "id -u; id -g; cat /proc/self/setgroups")
+	if got, want := strings.Fields(out), []string{"1234", "4321", "allow"}; strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("unshare --map-user --map-group = %q, want %q", got, want)
+	}
+}
+
+func TestForkMountProc(t *testing.T) {
+	out, _ := unshare(t, "-p", "-f", "--mount-proc", "sh", "-c", "echo $$; cat /proc/1/comm")
+	if out != "1\nsh\n" {
+		t.Errorf("unshare -pf --mount-proc = %q, want sh as pid 1", out)
+	}
+
+	// unforked, the command stays outside and its children are first in
+	out, _ = unshare(t, "-p", "sh", "-c", `echo $$; sh -c 'echo $$'`)
+	if pids := strings.Fields(out); len(pids) != 2 || pids[0] == "1" || pids[1] != "1" {
+		t.Errorf("unshare -p = %q, want the child to be pid 1", out)
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, ns := range []string{"uts", "net", "ipc", "cgroup", "time"} {
+		l, err := os.Readlink(filepath.Join("/proc/self/ns", ns))
+		if err != nil {
+			t.Skipf("no %s namespaces: %v", ns, err)
+		}
+		want = append(want, l)
+	}
+
+	out, code := unshare(t, "-u", "-n", "-i", "-C", "-T", "sh", "-c",
+		"hostname unshared && hostname && cd /proc/self/ns && readlink uts net ipc cgroup time; exit 3")
+	lines := strings.Fields(out)
+	if code != 3 || len(lines) != 6 || lines[0] != "unshared" {
+		t.Fatalf("unshare -unicT = %q, exit %d", out, code)
+	}
+	for i, l := range lines[1:] {
+		if l == want[i] {
+			t.Errorf("%s is still the parent's", l)
+		}
+	}
+	if h, _ := os.Hostname(); h != host {
+		t.Errorf("hostname changed outside to %q", h)
+	}
+}
+
+func TestEnv(t *testing.T) {
+	os.Setenv("UNSHARE_TEST", "kept")
+	defer os.Unsetenv("UNSHARE_TEST")
+
+	out, _ := unshare(t, "-U", "-e", "A=b", "/usr/bin/env")
+	if out != "A=b\n" {
+		t.Errorf("unshare -e = %q, want only A=b", out)
+	}
+	out, _ = unshare(t, "-U", "-E", "-e", "A=b", "/usr/bin/env")
+	if !strings.Contains(out, "UNSHARE_TEST=kept\n") || !strings.Contains(out, "A=b\n") || strings.Contains(out, childEnv) {
+		t.Errorf("unshare -E -e = %q", out)
+	}
+}
+
+func TestSubordinate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "subuid")
+	data := "# comment\nalice:100000:65536\n1001:200000:1000\nalice:300000:10\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name string
+		id   int
+		want idMap
+	}{
+		{"alice", 1000, idMap{0, 100000, 65536}},
+		{"bob", 1001, idMap{0, 200000, 1000}},
+	} {
+		got, err := subordinate(path, tt.name, tt.id)
+		if err != nil || got != tt.want {
+			t.Errorf("subordinate(%s) = %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+	if _, err := subordinate(path, "carol", 1002); err == nil {
+		t.Errorf("subordinate(carol) found ids")
+	}
+}
+
+func TestChroot(t *testing.T) {
+	opts = defaults
+	implied()
+	for _, tt := range []struct {
+		args   []string
+		chroot string
+		want   []string
+	}{
+		{[]string{"chroot", "/mnt", "ls", "-l"}, "/mnt", []string{"ls", "-l"}},
+		{[]string{"chroot", "/mnt"}, "/mnt", []string{getShell()}},
+		{[]string{"chroot"}, "", []string{"chroot"}},
+	} {
+		c, err := command(tt.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ch child
+		v := c.Env[len(c.Env)-1]
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(v, childEnv+"=")), &ch); err != nil {
+			t.Fatal(err)
+		}
+		if ch.Chroot != tt.chroot || strings.Join(ch.Args, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("command(%q) chroots to %q and runs %q, want %q and %q", tt.args, ch.Chroot, ch.Args, tt.chroot, tt.want)
+		}
+	}
+
+	// the command starts at the new root
+	out, code := unshare(t, "-r", "chroot", "/", "sh", "-c", "pwd")
+	if code != 0 || out != "/\n" {
+		t.Errorf("unshare -r chroot / sh -c pwd = %q, exit %d, want /", out, code)
+	}
+}
+
+// alive tells whether pid runs, a zombie being dead
+func alive(pid int) bool {
+	b, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return false
+	}
+	_, stat, _ := strings.Cut(string(b), ") ")
+	return !strings.HasPrefix(stat, "Z")
+}
+
+func TestKillChild(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("needs root to create namespaces")
+	}
+	// -r gets capabilities in the user namespace, which clears the
+	// parent death signal
+	for _, flag := range []string{"-m", "-r"} {
+		t.Run(flag, func(t *testing.T) {
+			pidFile := filepath.Join(t.TempDir(), "pid")
+			c := exec.Command(os.Args[0], flag, "--kill-child", "--", "/bin/sh", "-c", "echo $$ >"+pidFile+"; exec sleep 60")
+			c.Env = append(os.Environ(), mainEnv+"=1")
+			if err := c.Start(); err != nil {
+				t.Fatal(err)
+			}
+			pid := 0
+			for i := 0; i < 100 && pid == 0; i++ {
+				time.Sleep(50 * time.Millisecond)
+				if b, err := os.ReadFile(pidFile); err == nil && strings.HasSuffix(string(b), "\n") {
+					pid, _ = strconv.Atoi(strings.TrimSpace(string(b)))
+				}
+			}
+			c.Process.Kill()
+			c.Wait()
+			if pid == 0 {
+				t.Fatal("the command never started")
+			}
+			for i := 0; i < 100 && alive(pid); i++ {
+				time.Sleep(50 * time.Millisecond)
+			}
+			if alive(pid) {
+				syscall.Kill(pid, syscall.SIGKILL)
+				t.Errorf("unshare %s --kill-child: the command outlived unshare", flag)
+			}
+		})
+	}
+}
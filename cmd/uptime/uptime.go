@@ -1,83 +1,96 @@
 package main
 
 import (
-	"errors"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"strings"
 	"time"
+
+	"mybox/pkg/sysinfo"
 )
 
 var (
-	pretty = flag.Bool("p", false, "Pretty print uptime")
+	pretty = flag.Bool("p", false, "Show uptime in pretty format")
+	since  = flag.Bool("s", false, "System up since, in yyyy-mm-dd HH:MM:SS format")
+	toJSON = flag.Bool("json", false, "Use JSON for output")
 )
 
-func uptime(contents string) (*time.Time, error) {
-	uptimeArray := strings.Fields(contents)
-	if len(uptimeArray) == 0 {
-		return nil, errors.New("error:The contents of /proc/uptime are empty")
-	}
+// Report is everything uptime prints, suitable for JSON encoding
+type Report struct {
+	Time    time.Time        `json:"time"`
+	Since   time.Time        `json:"since"`
+	Uptime  float64          `json:"uptime"`
+	Users   int              `json:"users"`
+	LoadAvg *sysinfo.LoadAvg `json:"loadavg"`
+}
 
-	uptimeDuration, err := time.ParseDuration(string(uptimeArray[0]) + "s")
+func report(now time.Time) (*Report, error) {
+	up, err := sysinfo.ReadUptime()
 	if err != nil {
 		return nil, err
 	}
 
-	// uptime := time.Time{}.Add(uptimeDuration.Abs())
-	uptime := time.Time{}.Add(uptimeDuration)
-	return &uptime, nil
-}
-
-func loadAvgCompute(contents string) (loadaverage string, err error) {
-	loadav := strings.Fields(contents)
-	if len(loadav) < 3 {
-		return "", fmt.Errorf("error:invalid contents of /proc/loadavg")
+	load, err := sysinfo.ReadLoadAvg()
+	if err != nil {
+		return nil, err
 	}
 
-	var str string
-	if *pretty {
-		// str = fmt.Sprintf("\x1b[35m%s\x1b[0m, \x1b[35m%s\x1b[0m, \x1b[35m%s\x1b[0m", loadav[0], loadav[1], loadav[2])
-		str = fmt.Sprintf("CPU Load AVG:\n1min: \x1b[35m%s\x1b[0m\n5min: \x1b[35m%s\x1b[0m\n15min: \x1b[35m%s\x1b[0m", loadav[0], loadav[1], loadav[2])
-	} else {
-		// str = fmt.Sprintf("CPU Load AVG:\n1min: %s, %s, %s", loadav[0], loadav[1], loadav[2])
-		str = fmt.Sprintf("CPU Load AVG:\n1min: %s\n5min: %s\n15min: %s", loadav[0], loadav[1], loadav[2])
+	users, err := sysinfo.Users()
+	if err != nil {
+		return nil, err
 	}
 
-	return str, nil
+	return &Report{
+		Time:    now.Truncate(time.Second),
+		Since:   up.Since(now),
+		Uptime:  up.Up.Seconds(),
+		Users:   len(users),
+		LoadAvg: load,
+	}, nil
 }
 
-func loadavg() string {
-	procLoadavg, err := os.ReadFile("/proc/loadavg")
-	if err != nil {
-		log.Fatalf("error reading /proc/loadavg: %v\n", err)
+// printReport writes the classic line:
+// " 15:33:01 up 40 days,  3:04,  2 users,  load average: 0.20, 0.18, 0.12"
+func printReport(w io.Writer, r *Report) error {
+	up := sysinfo.Uptime{Up: time.Duration(r.Uptime * float64(time.Second))}
+
+	switch {
+	case *toJSON:
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	case *since:
+		_, err := fmt.Fprintln(w, r.Since.Format("2006-01-02 15:04:05"))
+		return err
+	case *pretty:
+		_, err := fmt.Fprintln(w, up.Pretty())
+		return err
 	}
-	loadAvg, err := loadAvgCompute(string(procLoadavg))
-	return loadAvg
+
+	_, err := fmt.Fprintf(w, " %s up %s,  %s,  load average: %.2f, %.2f, %.2f\n",
+		r.Time.Format("15:04:05"),
+		up.Short(),
+		sysinfo.Plural(r.Users, "user"),
+		r.LoadAvg.One, r.LoadAvg.Five, r.LoadAvg.Fifteen,
+	)
+	return err
 }
 
 func main() {
 	flag.Parse()
 
-	procUptime, err := os.ReadFile("/proc/uptime")
-	if err != nil {
-		log.Fatalf("error reading /proc/uptime: %v\n", err)
-	}
-
-	uptime, err := uptime(string(procUptime))
+	r, err := report(time.Now())
 	if err != nil {
-		log.Fatalf("%v\n", err)
+		log.Fatal(err)
 	}
 
-	load := loadavg()
-
-	if *pretty {
-		fmt.Printf("\x1b[35m%s\x1b[0m up \x1b[35m%d\x1b[0m days, \x1b[35m%d\x1b[0m hours, \x1b[35m%d\x1b[0m min\n", time.Now().Format("15:33:01"), (uptime.Day() - 1), uptime.Hour(), uptime.Minute())
-		fmt.Printf("%s\n", load)
-	} else {
-		fmt.Printf("%s up %d days, %d hours, %d min\n", time.Now().Format("15:33:01"), (uptime.Day() - 1), uptime.Hour(), uptime.Minute())
-		fmt.Printf("\n%s\n", load)
+	if err := printReport(os.Stdout, r); err != nil {
+		log.Fatal(err)
 	}
-
 }
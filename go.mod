@@ -4,16 +4,10 @@ go 1.21.4
 
 require (
 	github.com/GehirnInc/crypt v0.0.0-20230320061759-8cc1b52080c5
-	github.com/alessio/shellescape v1.4.2
 	github.com/araddon/dateparse v0.0.0-20210429162001-6b43995a97de
-	github.com/bramvdbogaerde/go-scp v1.2.1
 	github.com/cenkalti/backoff/v4 v4.2.1
 	github.com/creack/pty v1.1.21
-	github.com/cyphar/filepath-securejoin v0.2.4
-	github.com/digineo/go-dhclient v1.0.2
 	github.com/diskfs/go-diskfs v1.4.0
-	github.com/dsnet/compress v0.0.2-0.20210315054119-f66993602bf5
-	github.com/dustin/go-humanize v1.0.1
 	github.com/gdamore/tcell/v2 v2.7.0
 	github.com/gliderlabs/ssh v0.3.5
 	github.com/go-git/go-git/v5 v5.10.0
@@ -28,12 +22,9 @@ require (
 	github.com/klauspost/pgzip v1.2.6
 	github.com/mattn/go-isatty v0.0.20
 	github.com/mholt/archiver/v3 v3.5.1
-	github.com/nwaples/rardecode v1.1.3
-	github.com/ogier/pflag v0.0.1
 	github.com/pierrec/lz4 v2.6.1+incompatible
 	github.com/pilebones/go-udev v0.9.0
 	github.com/pkg/term v1.2.0-beta.2
-	github.com/pmorjan/kmod v1.1.1
 	github.com/rck/unit v0.0.3
 	github.com/rekby/gpt v0.0.0-20200219180433-a930afbc6edc
 	github.com/seccomp/libseccomp-golang v0.10.0
@@ -42,15 +33,13 @@ require (
 	github.com/u-root/u-root v0.12.1-0.20240114161452-ab3534910ced
 	github.com/ulikunitz/xz v0.5.11
 	github.com/vishvananda/netlink v1.2.1-beta.2
+	github.com/vishvananda/netns v0.0.4
 	github.com/xi2/xz v0.0.0-20171230120015-48954b6210f8
 	github.com/ybirader/pzip v0.2.2
 	golang.org/x/crypto v0.17.0
 	golang.org/x/sys v0.16.0
 	golang.org/x/term v0.15.0
-	golang.org/x/tools v0.16.1
 	mvdan.cc/sh/v3 v3.7.0
-	pault.ag/go/modprobe v0.1.2
-	pault.ag/go/topsort v0.1.1
 )
 
 require (
@@ -62,6 +51,9 @@ require (
 	github.com/andybalholm/brotli v1.0.1 // indirect
 	github.com/anmitsu/go-shlex v0.0.0-20200514113438-38f4b401e2be // indirect
 	github.com/cloudflare/circl v1.3.7 // indirect
+	github.com/cyphar/filepath-securejoin v0.2.4 // indirect
+	github.com/dsnet/compress v0.0.2-0.20210315054119-f66993602bf5 // indirect
+	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/elliotwutingfeng/asciiset v0.0.0-20230602022725-51bbb787efab // indirect
 	github.com/emirpasic/gods v1.18.1 // indirect
 	github.com/gdamore/encoding v1.0.0 // indirect
@@ -79,8 +71,8 @@ require (
 	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
 	github.com/mattn/go-runewidth v0.0.15 // indirect
 	github.com/mdlayher/packet v1.1.2 // indirect
-	github.com/mdlayher/raw v0.0.0-20191009151244-50f2db8cc065 // indirect
 	github.com/mdlayher/socket v0.5.0 // indirect
+	github.com/nwaples/rardecode v1.1.3 // indirect
 	github.com/pierrec/lz4/v4 v4.1.17 // indirect
 	github.com/pjbgf/sha1cd v0.3.0 // indirect
 	github.com/pkg/xattr v0.4.9 // indirect
@@ -89,13 +81,13 @@ require (
 	github.com/skeema/knownhosts v1.2.0 // indirect
 	github.com/u-root/gobusybox/src v0.0.0-20231228173702-b69f654846aa // indirect
 	github.com/u-root/uio v0.0.0-20230305220412-3e8cd9d6bf63 // indirect
-	github.com/vishvananda/netns v0.0.4 // indirect
 	github.com/xanzy/ssh-agent v0.3.3 // indirect
 	golang.org/x/exp v0.0.0-20231219180239-dc181d75b848 // indirect
 	golang.org/x/mod v0.14.0 // indirect
 	golang.org/x/net v0.19.0 // indirect
 	golang.org/x/sync v0.5.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
+	golang.org/x/tools v0.16.1 // indirect
 	gopkg.in/djherbis/times.v1 v1.3.0 // indirect
 	gopkg.in/warnings.v0 v0.1.2 // indirect
 	src.elv.sh v0.16.0-rc1.0.20220116211855-fda62502ad7f // indirect
@@ -0,0 +1,412 @@
+package dhcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
+	"github.com/vishvananda/netlink"
+)
+
+// DefaultStateDir is where leases are kept between runs
+const DefaultStateDir = "/var/lib/dhclient"
+
+// minRetry is the shortest we wait between renew or rebind attempts,
+// RFC 2131 4.4.5 says a minute
+var minRetry = 60 * time.Second
+
+// Client keeps one interface configured for as long as Run runs
+type Client struct {
+	Interface string
+
+	// StateDir keeps the lease between runs, "" keeps it in memory only
+	StateDir string
+	// ResolvConf gets the name servers of the lease, "" leaves DNS alone
+	ResolvConf string
+	// Script is run on every lease event, see RunHooks
+	Script string
+	// Hostname is sent to the server if set
+	Hostname string
+
+	// Timeout and Retries are per exchange with the server
+	Timeout time.Duration
+	Retries int
+	// ServerPort is the port servers listen on, 0 for the standard one
+	ServerPort int
+	// ServerAddr is where all requests go. Left nil, they are broadcast,
+	// but renewing unicasts to the server that gave us the lease.
+	ServerAddr *net.UDPAddr
+
+	// DryRun gets leases without configuring anything
+	DryRun bool
+	// Logf defaults to log.Printf
+	Logf func(string, ...interface{})
+
+	nc    *nclient4.Client
+	lease *Lease
+}
+
+// Lease is the lease we are currently holding, or nil
+func (c *Client) Lease() *Lease {
+	return c.lease
+}
+
+func (c *Client) logf(format string, v ...interface{}) {
+	if c.Logf != nil {
+		c.Logf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
+// open brings the interface up and gets a raw socket on it, which works
+// before we have an address, unless opts say otherwise
+func (c *Client) open(opts ...nclient4.ClientOpt) error {
+	if c.nc != nil {
+		return nil
+	}
+	link, err := netlink.LinkByName(c.Interface)
+	if err != nil {
+		return err
+	}
+	if err := netlink.LinkSetUp(link); err != nil {
+		return fmt.Errorf("%s: %w", c.Interface, err)
+	}
+	c.nc, err = c.newClient(opts...)
+	return err
+}
+
+// newClient gets an nclient4 client with our timeouts and server
+func (c *Client) newClient(opts ...nclient4.ClientOpt) (*nclient4.Client, error) {
+	if c.Timeout > 0 {
+		opts = append(opts, nclient4.WithTimeout(c.Timeout))
+	}
+	if c.Retries != 0 {
+		opts = append(opts, nclient4.WithRetry(c.Retries))
+	}
+	opts = append(opts, nclient4.WithServerAddr(c.serverAddr(nil)))
+	return nclient4.New(c.Interface, opts...)
+}
+
+// unicast makes nclient4 send from the leased address. A server behind the
+// Linux stack never sees unicasts from 0.0.0.0, which the raw socket sends.
+func (c *Client) unicast() nclient4.ClientOpt {
+	return nclient4.WithUnicast(&net.UDPAddr{IP: c.lease.Address, Port: nclient4.ClientPort})
+}
+
+// Close releases the socket, but not the lease
+func (c *Client) Close() error {
+	if c.nc == nil {
+		return nil
+	}
+	err := c.nc.Close()
+	c.nc = nil
+	return err
+}
+
+// serverAddr is where requests go: ServerAddr if set, server if known,
+// everyone otherwise
+func (c *Client) serverAddr(server net.IP) *net.UDPAddr {
+	if c.ServerAddr != nil {
+		return c.ServerAddr
+	}
+	port := c.ServerPort
+	if port == 0 {
+		port = dhcpv4.ServerPort
+	}
+	if server == nil {
+		server = net.IPv4bcast
+	}
+	return &net.UDPAddr{IP: server, Port: port}
+}
+
+func (c *Client) modifiers() []dhcpv4.Modifier {
+	mods := []dhcpv4.Modifier{
+		dhcpv4.WithRequestedOptions(
+			dhcpv4.OptionSubnetMask,
+			dhcpv4.OptionRouter,
+			dhcpv4.OptionDomainNameServer,
+			dhcpv4.OptionDomainName,
+			dhcpv4.OptionDNSDomainSearchList,
+			dhcpv4.OptionIPAddressLeaseTime,
+			dhcpv4.OptionRenewTimeValue,
+			dhcpv4.OptionRebindingTimeValue,
+		),
+	}
+	if c.Hostname != "" {
+		mods = append(mods, dhcpv4.WithOption(dhcpv4.OptHostName(c.Hostname)))
+	}
+	return mods
+}
+
+// savedLease is the lease from the last run, if it hasn't expired
+func (c *Client) savedLease() *Lease {
+	if c.StateDir == "" {
+		return nil
+	}
+	l, err := LoadLease(c.StateDir, c.Interface)
+	if err != nil {
+		c.logf("%s: ignoring saved lease: %v", c.Interface, err)
+		return nil
+	}
+	if l == nil || l.Expired(time.Now()) {
+		return nil
+	}
+	return l
+}
+
+// Acquire goes through discover, offer, request and ack. It asks for the
+// address of a saved lease so restarts keep their address.
+func (c *Client) Acquire(ctx context.Context) (*Lease, error) {
+	if err := c.open(); err != nil {
+		return nil, err
+	}
+
+	mods := c.modifiers()
+	prev := c.lease
+	if prev == nil {
+		prev = c.savedLease()
+	}
+	if prev != nil {
+		mods = append(mods, dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(prev.Address)))
+	}
+
+	l, err := c.nc.Request(ctx, mods...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c.Interface, err)
+	}
+	return NewLease(c.Interface, l), nil
+}
+
+// bind makes l the current lease
+func (c *Client) bind(l *Lease, event string) error {
+	c.logf("%s: %s %s", c.Interface, event, l)
+	if c.DryRun {
+		c.lease = l
+		return nil
+	}
+
+	var errs []error
+	if err := Configure(l, c.lease); err != nil {
+		errs = append(errs, err)
+	}
+	c.lease = l
+	if c.StateDir != "" {
+		errs = append(errs, l.Save(c.StateDir))
+	}
+	if c.ResolvConf != "" {
+		errs = append(errs, WriteResolvConf(c.ResolvConf, l))
+	}
+	errs = append(errs, RunHooks(c.Script, event, l))
+	return errors.Join(errs...)
+}
+
+// expire drops the current lease
+func (c *Client) expire(event string) error {
+	l := c.lease
+	c.lease = nil
+	if l == nil {
+		return nil
+	}
+	c.logf("%s: %s %s", c.Interface, event, l)
+	if c.DryRun {
+		return nil
+	}
+
+	var errs []error
+	errs = append(errs, Deconfigure(l))
+	if c.StateDir != "" {
+		errs = append(errs, RemoveLease(c.StateDir, c.Interface))
+	}
+	if c.ResolvConf != "" {
+		errs = append(errs, RemoveResolvConf(c.ResolvConf, c.Interface))
+	}
+	errs = append(errs, RunHooks(c.Script, event, l))
+	return errors.Join(errs...)
+}
+
+// Bind acquires a lease and configures it, once
+func (c *Client) Bind(ctx context.Context) (*Lease, error) {
+	l, err := c.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return l, c.bind(l, EventBound)
+}
+
+// renew asks for an extension until deadline. Renewing unicasts to the
+// server that gave us the lease, rebinding broadcasts and takes an answer
+// from anyone.
+func (c *Client) renew(ctx context.Context, deadline time.Time, rebind bool) (*Lease, error) {
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+
+	nl, err := c.lease.nclient()
+	if err != nil {
+		return nil, err
+	}
+	req, err := dhcpv4.NewRenewFromAck(nl.ACK, c.modifiers()...)
+	if err != nil {
+		return nil, err
+	}
+	nc, to := c.nc, c.serverAddr(nil)
+	match := nclient4.IsMessageType(dhcpv4.MessageTypeAck, dhcpv4.MessageTypeNak)
+	if !rebind {
+		if nc, err = c.newClient(c.unicast()); err != nil {
+			return nil, err
+		}
+		defer nc.Close()
+		to = c.serverAddr(nl.ACK.ServerIdentifier())
+		match = nclient4.IsAll(nclient4.IsCorrectServer(nl.ACK.ServerIdentifier()), match)
+	}
+	resp, err := nc.SendAndRead(ctx, to, req, match)
+	if err != nil {
+		return nil, err
+	}
+	if resp.MessageType() == dhcpv4.MessageTypeNak {
+		return nil, &nclient4.ErrNak{Offer: nl.Offer, Nak: resp}
+	}
+	offer := nl.Offer
+	if rebind {
+		// another server may have answered
+		offer = resp
+	}
+	return NewLease(c.Interface, &nclient4.Lease{Offer: offer, ACK: resp, CreationTime: time.Now()}), nil
+}
+
+// sleep waits until t, or returns false if ctx is done first
+func sleep(ctx context.Context, t time.Time) bool {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// retryAt is when to try again before limit: halfway there, but no sooner
+// than minRetry
+func retryAt(now, limit time.Time) time.Time {
+	wait := limit.Sub(now) / 2
+	if wait < minRetry {
+		wait = minRetry
+	}
+	if t := now.Add(wait); t.Before(limit) {
+		return t
+	}
+	return limit
+}
+
+// maintain keeps the current lease alive through RENEWING and REBINDING
+// and returns once it can't, after dropping it
+func (c *Client) maintain(ctx context.Context) error {
+	for {
+		l := c.lease
+		if !sleep(ctx, l.RenewAt()) {
+			return ctx.Err()
+		}
+
+		var next *Lease
+		for _, state := range []struct {
+			until  time.Time
+			rebind bool
+			event  string
+		}{
+			{l.RebindAt(), false, EventRenew},
+			{l.Expiry(), true, EventRebind},
+		} {
+			for next == nil && time.Now().Before(state.until) {
+				var err error
+				at := retryAt(time.Now(), state.until)
+				next, err = c.renew(ctx, at, state.rebind)
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				var nak *nclient4.ErrNak
+				if errors.As(err, &nak) {
+					c.logf("%s: server refused %s", c.Interface, l.Address)
+					return c.expire(EventExpire)
+				}
+				if err != nil {
+					c.logf("%s: %s failed: %v", c.Interface, state.event, err)
+					if !sleep(ctx, at) {
+						return ctx.Err()
+					}
+				}
+			}
+			if next != nil {
+				if err := c.bind(next, state.event); err != nil {
+					c.logf("%s: %v", c.Interface, err)
+				}
+				break
+			}
+		}
+		if next == nil {
+			return c.expire(EventExpire)
+		}
+	}
+}
+
+// Run gets a lease and keeps it until ctx is done, starting over whenever
+// a lease is lost. The lease stays configured when Run returns.
+func (c *Client) Run(ctx context.Context) error {
+	defer c.Close()
+	for {
+		if _, err := c.Bind(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			c.logf("%v", err)
+			if c.lease == nil {
+				if !sleep(ctx, time.Now().Add(10*time.Second)) {
+					return ctx.Err()
+				}
+				continue
+			}
+		}
+		if err := c.maintain(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			c.logf("%v", err)
+		}
+	}
+}
+
+// Release gives the current or saved lease back to the server and
+// deconfigures it
+func (c *Client) Release() error {
+	if c.lease == nil && c.StateDir != "" {
+		l, err := LoadLease(c.StateDir, c.Interface)
+		if err != nil {
+			return err
+		}
+		c.lease = l
+	}
+	if c.lease == nil {
+		return fmt.Errorf("%s: no lease to release", c.Interface)
+	}
+
+	// releases are unicast, so send from the leased address while we
+	// still hold it
+	if err := c.open(c.unicast()); err != nil {
+		if err := c.open(); err != nil {
+			return err
+		}
+	}
+	nl, err := c.lease.nclient()
+	if err != nil {
+		return err
+	}
+	if err := c.nc.Release(nl); err != nil {
+		c.logf("%s: sending release: %v", c.Interface, err)
+	}
+	return c.expire(EventRelease)
+}
@@ -0,0 +1,374 @@
+package dhcp
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/server4"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// testServer hands out clientIP for two seconds at a time
+type testServer struct {
+	*server4.Server
+	releases atomic.Int32
+	unicasts atomic.Int32
+	silent   atomic.Bool
+	// broadcastOnly ignores requests sent to the server itself
+	broadcastOnly atomic.Bool
+}
+
+func (s *testServer) handle(conn net.PacketConn, peer net.Addr, m *dhcpv4.DHCPv4) {
+	if s.silent.Load() {
+		return
+	}
+	var typ dhcpv4.MessageType
+	switch m.MessageType() {
+	case dhcpv4.MessageTypeDiscover:
+		typ = dhcpv4.MessageTypeOffer
+	case dhcpv4.MessageTypeRequest:
+		// server4 makes the peer of a client without an address broadcast
+		if peer.(*net.UDPAddr).IP.Equal(clientIP) {
+			s.unicasts.Add(1)
+			if s.broadcastOnly.Load() {
+				return
+			}
+		}
+		typ = dhcpv4.MessageTypeAck
+	case dhcpv4.MessageTypeRelease:
+		s.releases.Add(1)
+		return
+	default:
+		return
+	}
+	resp, err := ack(m, typ, 2*time.Second)
+	if err != nil {
+		return
+	}
+	conn.WriteTo(resp.ToBytes(), peer)
+}
+
+// veth puts the client end of a veth pair, dh0, in a fresh namespace that
+// the test thread stays in, and runs a server on dh1 in another one
+func veth(t *testing.T) (netns.NsHandle, *testServer) {
+	t.Helper()
+	if os.Getuid() != 0 {
+		t.Skip("needs root to create network namespaces")
+	}
+
+	runtime.LockOSThread()
+	orig, err := netns.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := netns.New()
+	if err != nil {
+		orig.Close()
+		runtime.UnlockOSThread()
+		t.Skipf("can't create network namespace: %v", err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		if err := netns.Set(orig); err != nil {
+			t.Fatalf("restoring network namespace: %v", err)
+		}
+		orig.Close()
+		runtime.UnlockOSThread()
+	})
+
+	la := netlink.NewLinkAttrs()
+	la.Name = "dh0"
+	if err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: la, PeerName: "dh1"}); err != nil {
+		t.Skipf("no veth support: %v", err)
+	}
+	peer, err := netlink.LinkByName("dh1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := netns.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// closing the last handle takes the namespace and both ends of the
+	// pair with it
+	t.Cleanup(func() { server.Close() })
+	if err := netns.Set(client); err != nil {
+		t.Fatal(err)
+	}
+	if err := netlink.LinkSetNsFd(peer, int(server)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the server's socket stays in its namespace once it's open
+	s := &testServer{}
+	if err := netns.Set(server); err != nil {
+		t.Fatal(err)
+	}
+	err = func() error {
+		link, err := netlink.LinkByName("dh1")
+		if err != nil {
+			return err
+		}
+		addr := &netlink.Addr{IPNet: &net.IPNet{IP: serverIP, Mask: net.CIDRMask(24, 32)}}
+		if err := netlink.AddrAdd(link, addr); err != nil {
+			return err
+		}
+		if err := netlink.LinkSetUp(link); err != nil {
+			return err
+		}
+		s.Server, err = server4.NewServer("dh1", &net.UDPAddr{Port: dhcpv4.ServerPort}, s.handle)
+		return err
+	}()
+	if err := netns.Set(client); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve()
+	t.Cleanup(func() { s.Close() })
+	return client, s
+}
+
+// waitFor polls until cond holds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func hasAddr(t *testing.T, name string) bool {
+	t.Helper()
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range addrs {
+		if a.IP.Equal(clientIP) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestClient(t *testing.T) {
+	ns, server := veth(t)
+
+	old := minRetry
+	minRetry = 200 * time.Millisecond
+	defer func() { minRetry = old }()
+
+	dir := t.TempDir()
+	events := filepath.Join(dir, "events")
+	script := filepath.Join(dir, "hook")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho $1 $ip $router >> "+events+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	newClient := func() *Client {
+		return &Client{
+			Interface:  "dh0",
+			StateDir:   filepath.Join(dir, "state"),
+			ResolvConf: filepath.Join(dir, "resolv.conf"),
+			Script:     script,
+			Timeout:    time.Second,
+			Retries:    3,
+			Logf:       t.Logf,
+		}
+	}
+	seen := func(event string) bool {
+		b, _ := os.ReadFile(events)
+		return strings.Contains(string(b), event+" 10.9.0.50 10.9.0.1\n")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		runtime.LockOSThread()
+		if err := netns.Set(ns); err != nil {
+			done <- err
+			return
+		}
+		done <- newClient().Run(ctx)
+	}()
+	defer func() {
+		if cancel != nil {
+			cancel()
+			<-done
+		}
+	}()
+
+	waitFor(t, "bound", func() bool { return seen(EventBound) })
+	if !hasAddr(t, "dh0") {
+		t.Errorf("dh0 has no %s after binding", clientIP)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "resolv.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "nameserver 10.9.0.1\n") {
+		t.Errorf("resolv.conf = %q", b)
+	}
+
+	waitFor(t, "renew", func() bool { return seen(EventRenew) })
+	if server.unicasts.Load() == 0 {
+		t.Errorf("renewing broadcast instead of asking the server")
+	}
+	l, err := LoadLease(filepath.Join(dir, "state"), "dh0")
+	if err != nil || l == nil {
+		t.Fatalf("LoadLease() = %v, %v", l, err)
+	}
+	if !l.Address.Equal(clientIP) || l.Duration != 2*time.Second {
+		t.Errorf("saved lease = %v", l)
+	}
+
+	cancel()
+	cancel = nil
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Run() = %v, want %v", err, context.Canceled)
+	}
+	if !hasAddr(t, "dh0") {
+		t.Errorf("Run() took the address with it")
+	}
+
+	// a later run gives the saved lease back
+	c := newClient()
+	if err := c.Release(); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if !seen(EventRelease) {
+		t.Errorf("no release event")
+	}
+	if hasAddr(t, "dh0") {
+		t.Errorf("dh0 still has %s after release", clientIP)
+	}
+	if l, err := LoadLease(filepath.Join(dir, "state"), "dh0"); l != nil || err != nil {
+		t.Errorf("lease still saved after release: %v, %v", l, err)
+	}
+	waitFor(t, "the server to see the release", func() bool { return server.releases.Load() > 0 })
+	if err := newClient().Release(); err == nil {
+		t.Errorf("released a lease twice")
+	}
+}
+
+func TestClientExpire(t *testing.T) {
+	ns, server := veth(t)
+
+	old := minRetry
+	minRetry = 200 * time.Millisecond
+	defer func() { minRetry = old }()
+
+	dir := t.TempDir()
+	events := filepath.Join(dir, "events")
+	script := filepath.Join(dir, "hook")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho $1 >> "+events+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	c := &Client{
+		Interface: "dh0",
+		StateDir:  filepath.Join(dir, "state"),
+		Script:    script,
+		Timeout:   200 * time.Millisecond,
+		Retries:   1,
+		Logf:      t.Logf,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		runtime.LockOSThread()
+		if err := netns.Set(ns); err != nil {
+			done <- err
+			return
+		}
+		done <- c.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	read := func() string {
+		b, _ := os.ReadFile(events)
+		return string(b)
+	}
+	waitFor(t, "bound", func() bool { return strings.HasPrefix(read(), "bound\n") })
+	// nobody answers renews or rebinds any more
+	server.silent.Store(true)
+	waitFor(t, "expire", func() bool { return strings.Contains(read(), "expire\n") })
+
+	if hasAddr(t, "dh0") {
+		t.Errorf("dh0 still has %s after the lease expired", clientIP)
+	}
+	if l, err := LoadLease(c.StateDir, "dh0"); l != nil || err != nil {
+		t.Errorf("expired lease still saved: %v, %v", l, err)
+	}
+}
+
+func TestClientRebind(t *testing.T) {
+	ns, server := veth(t)
+	// a server we can't reach directly leaves only rebinding
+	server.broadcastOnly.Store(true)
+
+	old := minRetry
+	minRetry = 200 * time.Millisecond
+	defer func() { minRetry = old }()
+
+	dir := t.TempDir()
+	events := filepath.Join(dir, "events")
+	script := filepath.Join(dir, "hook")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho $1 >> "+events+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	c := &Client{
+		Interface: "dh0",
+		Script:    script,
+		Timeout:   200 * time.Millisecond,
+		Retries:   1,
+		Logf:      t.Logf,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		runtime.LockOSThread()
+		if err := netns.Set(ns); err != nil {
+			done <- err
+			return
+		}
+		done <- c.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	read := func() string {
+		b, _ := os.ReadFile(events)
+		return string(b)
+	}
+	waitFor(t, "rebind", func() bool { return strings.Contains(read(), "rebind\n") })
+	if strings.Contains(read(), "renew\n") || strings.Contains(read(), "expire\n") {
+		t.Errorf("events = %q, want a rebind only", read())
+	}
+	if server.unicasts.Load() == 0 {
+		t.Errorf("renewing never asked the server directly")
+	}
+}
@@ -0,0 +1,59 @@
+package dhcp
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// Configure puts the lease on its interface: the address, with lifetimes so
+// the kernel drops it if we die, and a default route through the first
+// router. old is the lease being replaced, if any.
+func Configure(l, old *Lease) error {
+	link, err := netlink.LinkByName(l.Interface)
+	if err != nil {
+		return err
+	}
+	if err := netlink.LinkSetUp(link); err != nil {
+		return fmt.Errorf("%s: %w", l.Interface, err)
+	}
+
+	if old != nil && !old.IPNet().IP.Equal(l.Address) {
+		Deconfigure(old)
+	}
+
+	lifetime := int(time.Until(l.Expiry()).Seconds())
+	if lifetime <= 0 {
+		return fmt.Errorf("lease for %s already expired", l.Address)
+	}
+	addr := &netlink.Addr{IPNet: l.IPNet(), ValidLft: lifetime, PreferedLft: lifetime}
+	if err := netlink.AddrReplace(link, addr); err != nil {
+		return fmt.Errorf("adding %s to %s: %w", l.IPNet(), l.Interface, err)
+	}
+
+	if len(l.Routers) > 0 {
+		route := &netlink.Route{LinkIndex: link.Attrs().Index, Gw: l.Routers[0]}
+		if err := netlink.RouteReplace(route); err != nil {
+			return fmt.Errorf("default route via %s: %w", l.Routers[0], err)
+		}
+	}
+	return nil
+}
+
+// Deconfigure takes the address of the lease off its interface. Routes
+// through it go with it.
+func Deconfigure(l *Lease) error {
+	link, err := netlink.LinkByName(l.Interface)
+	if err != nil {
+		return err
+	}
+	err = netlink.AddrDel(link, &netlink.Addr{IPNet: l.IPNet()})
+	// the kernel drops it by itself once its lifetime is over
+	if err != nil && !errors.Is(err, unix.EADDRNOTAVAIL) {
+		return fmt.Errorf("removing %s from %s: %w", l.IPNet(), l.Interface, err)
+	}
+	return nil
+}
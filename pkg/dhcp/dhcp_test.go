@@ -0,0 +1,243 @@
+package dhcp
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
+	"github.com/insomniacslk/dhcp/rfc1035label"
+)
+
+var (
+	serverIP = net.IPv4(10, 9, 0, 1).To4()
+	clientIP = net.IPv4(10, 9, 0, 50).To4()
+)
+
+// ack builds what a server sends back for req
+func ack(req *dhcpv4.DHCPv4, typ dhcpv4.MessageType, leaseTime time.Duration) (*dhcpv4.DHCPv4, error) {
+	return dhcpv4.NewReplyFromRequest(req,
+		dhcpv4.WithMessageType(typ),
+		dhcpv4.WithYourIP(clientIP),
+		dhcpv4.WithServerIP(serverIP),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(serverIP)),
+		dhcpv4.WithOption(dhcpv4.OptSubnetMask(net.CIDRMask(24, 32))),
+		dhcpv4.WithOption(dhcpv4.OptRouter(serverIP)),
+		dhcpv4.WithOption(dhcpv4.OptDNS(serverIP, net.IPv4(9, 9, 9, 9))),
+		dhcpv4.WithOption(dhcpv4.OptDomainName("test.example")),
+		dhcpv4.WithOption(dhcpv4.OptDomainSearch(&rfc1035label.Labels{Labels: []string{"test.example", "example"}})),
+		dhcpv4.WithOption(dhcpv4.OptIPAddressLeaseTime(leaseTime)),
+	)
+}
+
+func testLease(t *testing.T) *Lease {
+	t.Helper()
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{2, 0, 0, 0, 0, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := ack(req, dhcpv4.MessageTypeAck, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acquired := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	return NewLease("eth0", &nclient4.Lease{Offer: a, ACK: a, CreationTime: acquired})
+}
+
+func TestNewLease(t *testing.T) {
+	l := testLease(t)
+
+	if got, want := l.IPNet().String(), "10.9.0.50/24"; got != want {
+		t.Errorf("IPNet() = %v, want %v", got, want)
+	}
+	if l.Domain != "test.example" || !reflect.DeepEqual(l.Search, []string{"test.example", "example"}) {
+		t.Errorf("domain = %q, search = %q", l.Domain, l.Search)
+	}
+	if !l.ServerID.Equal(serverIP) || len(l.Routers) != 1 || !l.Routers[0].Equal(serverIP) {
+		t.Errorf("server = %v, routers = %v", l.ServerID, l.Routers)
+	}
+	if l.T1 != 30*time.Minute || l.T2 != 52*time.Minute+30*time.Second {
+		t.Errorf("T1 = %v, T2 = %v, want defaults from a one hour lease", l.T1, l.T2)
+	}
+	if got := l.RenewAt().Sub(l.Acquired); got != l.T1 {
+		t.Errorf("RenewAt() is %v after acquiring, want %v", got, l.T1)
+	}
+	if !l.Expired(l.Acquired.Add(time.Hour)) || l.Expired(l.Acquired.Add(time.Hour-time.Second)) {
+		t.Errorf("Expired() doesn't flip at an hour")
+	}
+}
+
+func TestSaveLoadLease(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	l := testLease(t)
+
+	if got, err := LoadLease(dir, "eth0"); got != nil || err != nil {
+		t.Fatalf("LoadLease() without a lease = %v, %v", got, err)
+	}
+	if err := l.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadLease(dir, "eth0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Acquired.Equal(l.Acquired) || !got.Address.Equal(l.Address) || got.Duration != l.Duration {
+		t.Errorf("LoadLease() = %+v, want %+v", got, l)
+	}
+
+	// the saved packets are good enough to renew from
+	nl, err := got.nclient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !nl.ACK.YourIPAddr.Equal(clientIP) || !nl.Offer.ServerIdentifier().Equal(serverIP) {
+		t.Errorf("nclient() = %v", nl.ACK)
+	}
+
+	if err := RemoveLease(dir, "eth0"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := LoadLease(dir, "eth0"); got != nil || err != nil {
+		t.Errorf("LoadLease() after RemoveLease() = %v, %v", got, err)
+	}
+}
+
+func TestServerAddr(t *testing.T) {
+	fixed := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 9), Port: 1067}
+	for _, tt := range []struct {
+		c      Client
+		server net.IP
+		want   string
+	}{
+		{Client{}, nil, "255.255.255.255:67"},
+		{Client{}, serverIP, "10.9.0.1:67"},
+		{Client{ServerPort: 1067}, nil, "255.255.255.255:1067"},
+		{Client{ServerPort: 1067}, serverIP, "10.9.0.1:1067"},
+		{Client{ServerAddr: fixed}, serverIP, "10.0.0.9:1067"},
+	} {
+		if got := tt.c.serverAddr(tt.server).String(); got != tt.want {
+			t.Errorf("serverAddr(%v) with port %d, addr %v = %s, want %s", tt.server, tt.c.ServerPort, tt.c.ServerAddr, got, tt.want)
+		}
+	}
+}
+
+func TestResolvConf(t *testing.T) {
+	l := testLease(t)
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := WriteResolvConf(path, l); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# generated by dhclient for eth0\nsearch test.example example\nnameserver 10.9.0.1\nnameserver 9.9.9.9\n"
+	if string(b) != want {
+		t.Errorf("resolv.conf = %q, want %q", b, want)
+	}
+
+	// nothing to say, nothing written
+	l.DNS = nil
+	if err := WriteResolvConf(path, l); err != nil {
+		t.Fatal(err)
+	}
+	if b2, _ := os.ReadFile(path); string(b2) != want {
+		t.Errorf("resolv.conf without name servers = %q", b2)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+
+	// a second interface adds its name servers instead of replacing
+	l.DNS = []net.IP{serverIP, net.IPv4(9, 9, 9, 9)}
+	if err := WriteResolvConf(path, l); err != nil {
+		t.Fatal(err)
+	}
+	l2 := testLease(t)
+	l2.Interface, l2.Search, l2.Domain = "eth1", nil, "other.example"
+	l2.DNS = []net.IP{net.IPv4(10, 8, 0, 1), net.IPv4(9, 9, 9, 9)}
+	if err := WriteResolvConf(path, l2); err != nil {
+		t.Fatal(err)
+	}
+	want = "# generated by dhclient for eth0, eth1\nsearch test.example example other.example\n" +
+		"nameserver 10.9.0.1\nnameserver 9.9.9.9\nnameserver 10.8.0.1\n"
+	if b, _ := os.ReadFile(path); string(b) != want {
+		t.Errorf("resolv.conf of two interfaces = %q, want %q", b, want)
+	}
+
+	if err := RemoveResolvConf(path, "eth0"); err != nil {
+		t.Fatal(err)
+	}
+	want = "# generated by dhclient for eth1\nsearch other.example\nnameserver 10.8.0.1\nnameserver 9.9.9.9\n"
+	if b, _ := os.ReadFile(path); string(b) != want {
+		t.Errorf("resolv.conf after eth0 is gone = %q, want %q", b, want)
+	}
+}
+
+func TestRunHooks(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	hooks := filepath.Join(dir, "hooks.d")
+	if err := os.Mkdir(hooks, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, body := range map[string]string{
+		"10-first":  "echo first $1 $interface $ip/$mask $subnet \"$router\" \"$dns\" >> " + out,
+		"20-second": "echo second $1 $lease >> " + out,
+	} {
+		if err := os.WriteFile(filepath.Join(hooks, name), []byte("#!/bin/sh\n"+body+"\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// not executable, skipped
+	if err := os.WriteFile(filepath.Join(hooks, "README"), []byte("exit 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RunHooks(hooks, EventBound, testLease(t)); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "first bound eth0 10.9.0.50/24 255.255.255.0 10.9.0.1 10.9.0.1 9.9.9.9\nsecond bound 3600\n"
+	if string(b) != want {
+		t.Errorf("hooks wrote %q, want %q", b, want)
+	}
+
+	if err := RunHooks(filepath.Join(dir, "missing"), EventBound, testLease(t)); err != nil {
+		t.Errorf("RunHooks() with a missing script = %v", err)
+	}
+	failing := filepath.Join(dir, "fail")
+	if err := os.WriteFile(failing, []byte("#!/bin/sh\nexit 3\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := RunHooks(failing, EventExpire, testLease(t)); err == nil || !strings.Contains(err.Error(), "expire") {
+		t.Errorf("RunHooks() with a failing script = %v", err)
+	}
+}
+
+func TestRetryAt(t *testing.T) {
+	old := minRetry
+	minRetry = time.Minute
+	defer func() { minRetry = old }()
+
+	now := time.Unix(0, 0)
+	for _, tt := range []struct {
+		limit, want time.Duration
+	}{
+		{time.Hour, 30 * time.Minute},
+		{90 * time.Second, time.Minute},
+		{30 * time.Second, 30 * time.Second},
+	} {
+		if got := retryAt(now, now.Add(tt.limit)).Sub(now); got != tt.want {
+			t.Errorf("retryAt(%v) = %v, want %v", tt.limit, got, tt.want)
+		}
+	}
+}
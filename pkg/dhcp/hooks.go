@@ -0,0 +1,93 @@
+package dhcp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultScript is where hook scripts live, a file or a directory of them
+const DefaultScript = "/etc/dhclient.d"
+
+// Events passed to hook scripts as their first argument
+const (
+	EventBound   = "bound"
+	EventRenew   = "renew"
+	EventRebind  = "rebind"
+	EventExpire  = "expire"
+	EventRelease = "release"
+)
+
+func joinIPs(ips []net.IP) string {
+	s := make([]string, len(ips))
+	for i, ip := range ips {
+		s[i] = ip.String()
+	}
+	return strings.Join(s, " ")
+}
+
+// Environment is what hook scripts get, using the variable names udhcpc
+// scripts already know
+func (l *Lease) Environment() []string {
+	return []string{
+		"interface=" + l.Interface,
+		"ip=" + l.Address.String(),
+		"mask=" + fmt.Sprint(l.Prefixlen),
+		"subnet=" + net.IP(net.CIDRMask(l.Prefixlen, 32)).String(),
+		"router=" + joinIPs(l.Routers),
+		"dns=" + joinIPs(l.DNS),
+		"domain=" + l.Domain,
+		"search=" + strings.Join(l.Search, " "),
+		"serverid=" + l.ServerID.String(),
+		"lease=" + fmt.Sprint(int64(l.Duration.Seconds())),
+	}
+}
+
+// RunHooks runs script with the event as its only argument and the lease in
+// its environment. If script is a directory every executable in it is run
+// in lexical order. A missing script is not an error.
+func RunHooks(script, event string, l *Lease) error {
+	if script == "" {
+		return nil
+	}
+	fi, err := os.Stat(script)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	scripts := []string{script}
+	if fi.IsDir() {
+		entries, err := os.ReadDir(script)
+		if err != nil {
+			return err
+		}
+		scripts = scripts[:0]
+		for _, e := range entries {
+			info, err := e.Info()
+			if err != nil || !info.Mode().IsRegular() || info.Mode()&0o111 == 0 {
+				continue
+			}
+			scripts = append(scripts, filepath.Join(script, e.Name()))
+		}
+		sort.Strings(scripts)
+	}
+
+	var errs []error
+	for _, s := range scripts {
+		cmd := exec.Command(s, event)
+		cmd.Env = append(os.Environ(), l.Environment()...)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			errs = append(errs, fmt.Errorf("%s %s: %w", s, event, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,184 @@
+// Package dhcp is a DHCPv4 client that keeps its lease: it persists leases
+// across restarts, renews and rebinds them at T1 and T2, and keeps
+// resolv.conf and hook scripts up to date along the way.
+package dhcp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
+)
+
+// DefaultLeaseTime is assumed when a server doesn't send a lease time
+const DefaultLeaseTime = time.Hour
+
+// Lease is what we got from the server, in a form that can be saved to disk
+// and picked up again by the next run
+type Lease struct {
+	Interface string        `json:"interface"`
+	Address   net.IP        `json:"address"`
+	Prefixlen int           `json:"prefixlen"`
+	Routers   []net.IP      `json:"routers,omitempty"`
+	DNS       []net.IP      `json:"dns,omitempty"`
+	Domain    string        `json:"domain,omitempty"`
+	Search    []string      `json:"search,omitempty"`
+	ServerID  net.IP        `json:"server_id"`
+	Acquired  time.Time     `json:"acquired"`
+	Duration  time.Duration `json:"lease_time"`
+	T1        time.Duration `json:"renewal_time"`
+	T2        time.Duration `json:"rebinding_time"`
+
+	// the raw packets, needed to renew and release
+	Offer []byte `json:"offer"`
+	ACK   []byte `json:"ack"`
+}
+
+// NewLease extracts the settings of an nclient4 lease
+func NewLease(iface string, l *nclient4.Lease) *Lease {
+	ack := l.ACK
+	lease := &Lease{
+		Interface: iface,
+		Address:   ack.YourIPAddr,
+		Routers:   ack.Router(),
+		DNS:       ack.DNS(),
+		Domain:    ack.DomainName(),
+		ServerID:  ack.ServerIdentifier(),
+		Acquired:  l.CreationTime,
+		Duration:  ack.IPAddressLeaseTime(DefaultLeaseTime),
+		ACK:       ack.ToBytes(),
+	}
+	if l.Offer != nil {
+		lease.Offer = l.Offer.ToBytes()
+	}
+	if mask := ack.SubnetMask(); mask != nil {
+		lease.Prefixlen, _ = mask.Size()
+	} else {
+		lease.Prefixlen, _ = lease.Address.DefaultMask().Size()
+	}
+	if search := ack.DomainSearch(); search != nil {
+		lease.Search = search.Labels
+	}
+	// RFC 2131 4.4.5
+	lease.T1 = ack.IPAddressRenewalTime(lease.Duration / 2)
+	lease.T2 = ack.IPAddressRebindingTime(lease.Duration * 7 / 8)
+	return lease
+}
+
+// IPNet is the address with its netmask
+func (l *Lease) IPNet() *net.IPNet {
+	return &net.IPNet{IP: l.Address, Mask: net.CIDRMask(l.Prefixlen, 32)}
+}
+
+// RenewAt is T1, when we start asking our server to extend the lease
+func (l *Lease) RenewAt() time.Time {
+	return l.Acquired.Add(l.T1)
+}
+
+// RebindAt is T2, when we give up on our server and ask anyone
+func (l *Lease) RebindAt() time.Time {
+	return l.Acquired.Add(l.T2)
+}
+
+// Expiry is when the address has to go
+func (l *Lease) Expiry() time.Time {
+	return l.Acquired.Add(l.Duration)
+}
+
+func (l *Lease) Expired(now time.Time) bool {
+	return !now.Before(l.Expiry())
+}
+
+// nclient rebuilds the lease nclient4 needs for renewing and releasing
+func (l *Lease) nclient() (*nclient4.Lease, error) {
+	ack, err := dhcpv4.FromBytes(l.ACK)
+	if err != nil {
+		return nil, fmt.Errorf("saved ACK: %w", err)
+	}
+	nl := &nclient4.Lease{ACK: ack, Offer: ack, CreationTime: l.Acquired}
+	if len(l.Offer) > 0 {
+		if nl.Offer, err = dhcpv4.FromBytes(l.Offer); err != nil {
+			return nil, fmt.Errorf("saved offer: %w", err)
+		}
+	}
+	return nl, nil
+}
+
+func (l *Lease) String() string {
+	return fmt.Sprintf("%s on %s from %s for %v", l.IPNet(), l.Interface, l.ServerID, l.Duration)
+}
+
+func leasePath(dir, iface string) string {
+	return filepath.Join(dir, iface+".lease")
+}
+
+// Save writes the lease to dir/IFACE.lease
+func (l *Lease) Save(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(leasePath(dir, l.Interface), append(b, '\n'), 0o644)
+}
+
+// LoadLease reads the lease saved for iface, which is nil without error if
+// there is none
+func LoadLease(dir, iface string) (*Lease, error) {
+	b, err := os.ReadFile(leasePath(dir, iface))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var l Lease
+	if err := json.Unmarshal(b, &l); err != nil {
+		return nil, fmt.Errorf("%s: %w", leasePath(dir, iface), err)
+	}
+	return &l, nil
+}
+
+// RemoveLease forgets the lease saved for iface
+func RemoveLease(dir, iface string) error {
+	err := os.Remove(leasePath(dir, iface))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// WriteFileAtomic replaces path with data so readers see either the old or
+// the new content, never a partial file
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
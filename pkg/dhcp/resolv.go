@@ -0,0 +1,103 @@
+package dhcp
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultResolvConf is where name servers from the lease end up
+const DefaultResolvConf = "/etc/resolv.conf"
+
+// resolvLeases are the leases written to each resolv.conf, by interface, so
+// that the clients of several interfaces share it instead of taking turns
+var (
+	resolvMu     sync.Mutex
+	resolvLeases = map[string]map[string]*Lease{}
+)
+
+// appendNew appends the values not in list yet
+func appendNew(list []string, values ...string) []string {
+	for _, v := range values {
+		if !slices.Contains(list, v) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// ResolvConf renders the resolv.conf for leases, their search domains and
+// name servers merged in order
+func ResolvConf(leases ...*Lease) []byte {
+	var ifaces, search, servers []string
+	for _, l := range leases {
+		ifaces = append(ifaces, l.Interface)
+		switch {
+		case len(l.Search) > 0:
+			search = appendNew(search, l.Search...)
+		case l.Domain != "":
+			search = appendNew(search, l.Domain)
+		}
+		for _, ns := range l.DNS {
+			servers = appendNew(servers, ns.String())
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# generated by dhclient for %s\n", strings.Join(ifaces, ", "))
+	if len(search) > 0 {
+		fmt.Fprintf(&b, "search %s\n", strings.Join(search, " "))
+	}
+	for _, ns := range servers {
+		fmt.Fprintf(&b, "nameserver %s\n", ns)
+	}
+	return []byte(b.String())
+}
+
+// writeResolvConf atomically replaces path with what the leases written to
+// it have, in the order of their interfaces. Without any name servers it
+// leaves the file alone.
+func writeResolvConf(path string) error {
+	var ifaces []string
+	for iface, l := range resolvLeases[path] {
+		if len(l.DNS) > 0 {
+			ifaces = append(ifaces, iface)
+		}
+	}
+	if len(ifaces) == 0 {
+		return nil
+	}
+	sort.Strings(ifaces)
+	leases := make([]*Lease, len(ifaces))
+	for i, iface := range ifaces {
+		leases[i] = resolvLeases[path][iface]
+	}
+	return WriteFileAtomic(path, ResolvConf(leases...), 0o644)
+}
+
+// WriteResolvConf atomically replaces path with the name servers of the
+// lease, merged with those of the other interfaces written to path. Leases
+// without name servers leave it alone.
+func WriteResolvConf(path string, l *Lease) error {
+	resolvMu.Lock()
+	defer resolvMu.Unlock()
+	if resolvLeases[path] == nil {
+		resolvLeases[path] = map[string]*Lease{}
+	}
+	resolvLeases[path][l.Interface] = l
+	return writeResolvConf(path)
+}
+
+// RemoveResolvConf takes the name servers of iface out of path again,
+// leaving those of the other interfaces
+func RemoveResolvConf(path, iface string) error {
+	resolvMu.Lock()
+	defer resolvMu.Unlock()
+	if _, ok := resolvLeases[path][iface]; !ok {
+		return nil
+	}
+	delete(resolvLeases[path], iface)
+	return writeResolvConf(path)
+}
@@ -1,3 +1,5 @@
 // Package modprobe allows users to load and unload Linux kernel modules by
-// calling the relevant Linux syscalls.
+// calling the relevant Linux syscalls, and to read their ELF module
+// information. Modules are found and loaded with their dependencies by
+// mybox/pkg/kmod.
 package modprobe
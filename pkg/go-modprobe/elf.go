@@ -81,6 +81,9 @@ func elfMap(root string) (map[string]string, error) {
 	err := filepath.WalkDir(
 		root,
 		func(path string, info fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
 			if info.IsDir() {
 				return nil
 			}
@@ -114,25 +117,66 @@ func elfMap(root string) (map[string]string, error) {
 	return ret, nil
 }
 
-func ModInfo(file *os.File) (map[string]string, error) {
-	ext := filepath.Ext(file.Name())
-	var r io.Reader
-	var err error
-
-	switch ext {
+// decompress returns the content of a module file, uncompressed according
+// to its extension.
+func decompress(file *os.File) (io.Reader, error) {
+	switch ext := filepath.Ext(file.Name()); ext {
 	case ".ko":
-		r = file
+		return file, nil
 	case ".zst":
-		r, err = zstd.NewReader(file)
+		return zstd.NewReader(file)
 	case ".xz":
-		r, err = xz.NewReader(file, 0)
+		return xz.NewReader(file, 0)
 	case ".lz4":
-		r = lz4.NewReader(file)
+		return lz4.NewReader(file), nil
 	case ".gz":
-		r, err = gzip.NewReader(file)
-
+		return gzip.NewReader(file)
 	default:
-		err = fmt.Errorf("unknown module compression format: %s", ext)
+		return nil, fmt.Errorf("unknown module compression format: %s", ext)
+	}
+}
+
+// Attr is an entry of the .modinfo section of a module, like license=GPL.
+type Attr struct {
+	Key, Value string
+}
+
+// Module is what a module file tells of itself.
+type Module struct {
+	// Attrs are the entries of the .modinfo section, in order. Keys like
+	// alias, author and parm can repeat.
+	Attrs []Attr
+
+	// Exports are the symbols the module exports to other modules.
+	Exports []string
+
+	// Undefined are the symbols the module needs from the kernel or
+	// other modules.
+	Undefined []string
+}
+
+// Get returns the values of the .modinfo entries of key.
+func (m *Module) Get(key string) []string {
+	var values []string
+	for _, a := range m.Attrs {
+		if a.Key == key {
+			values = append(values, a.Value)
+		}
+	}
+	return values
+}
+
+// ReadModule reads the .modinfo section and the symbols of a module file,
+// compressed or not. The exported symbols are those of the
+// __ksymtab_strings section, or of the __ksymtab_ symbols for modules that
+// have none.
+func ReadModule(file *os.File) (*Module, error) {
+	r, err := decompress(file)
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := r.(*zstd.Decoder); ok {
+		defer d.Close()
 	}
 
 	content, err := io.ReadAll(r)
@@ -145,8 +189,6 @@ func ModInfo(file *os.File) (map[string]string, error) {
 		return nil, err
 	}
 
-	attrs := map[string]string{}
-
 	sec := f.Section(".modinfo")
 	if sec == nil {
 		return nil, errors.New("missing modinfo section")
@@ -157,12 +199,54 @@ func ModInfo(file *os.File) (map[string]string, error) {
 		return nil, fmt.Errorf("failed to get section data: %w", err)
 	}
 
+	m := &Module{}
 	for _, info := range bytes.Split(data, []byte{0}) {
-		if parts := strings.SplitN(string(info), "=", 2); len(parts) == 2 {
-			attrs[parts[0]] = parts[1]
+		if key, value, ok := strings.Cut(string(info), "="); ok {
+			m.Attrs = append(m.Attrs, Attr{key, value})
+		}
+	}
+
+	syms, err := f.Symbols()
+	if err != nil && !errors.Is(err, elf.ErrNoSymbols) {
+		return nil, fmt.Errorf("failed to get symbols: %w", err)
+	}
+	for _, s := range syms {
+		switch {
+		case s.Name == "":
+		case s.Section == elf.SHN_UNDEF:
+			m.Undefined = append(m.Undefined, s.Name)
+		case strings.HasPrefix(s.Name, "__ksymtab_") && f.Section("__ksymtab_strings") == nil:
+			m.Exports = append(m.Exports, strings.TrimPrefix(s.Name, "__ksymtab_"))
+		}
+	}
+	if sec := f.Section("__ksymtab_strings"); sec != nil {
+		data, err := sec.Data()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get exported symbols: %w", err)
+		}
+		for _, s := range bytes.Split(data, []byte{0}) {
+			if len(s) > 0 {
+				m.Exports = append(m.Exports, string(s))
+			}
 		}
 	}
 
+	return m, nil
+}
+
+// ModInfo returns the .modinfo entries of a module file. Of the keys that
+// repeat, the last value is kept: see ReadModule for all of them.
+func ModInfo(file *os.File) (map[string]string, error) {
+	m, err := ReadModule(file)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := map[string]string{}
+	for _, a := range m.Attrs {
+		attrs[a.Key] = a.Value
+	}
+
 	return attrs, nil
 }
 
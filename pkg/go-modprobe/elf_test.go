@@ -1,23 +1,100 @@
-package modprobe_test
+package modprobe
 
 import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
 	"testing"
-
-	"pault.ag/go/modprobe"
 )
 
+func TestReadModule(t *testing.T) {
+	for _, tt := range []struct {
+		file      string
+		name      string
+		exports   []string
+		undefined []string
+		aliases   []string
+	}{
+		{
+			file:    "kernel/lib/crc16.ko.zst",
+			name:    "crc16",
+			exports: []string{"crc16"},
+		},
+		{
+			file:      "kernel/fs/jbd2/jbd2.ko.gz",
+			name:      "jbd2",
+			exports:   []string{"jbd2_journal_start"},
+			undefined: []string{"crc16", "printk"},
+		},
+		{
+			file:      "kernel/fs/ext4/ext4.ko.xz",
+			name:      "ext4",
+			undefined: []string{"crc16", "jbd2_journal_start", "printk"},
+			aliases:   []string{"ext3", "fs-ext3", "fs-ext4"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := os.Open("testdata/lib/modules/6.1.0-test/" + tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			m, err := ReadModule(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(m.Undefined)
+			aliases := m.Get("alias")
+			sort.Strings(aliases)
+			if !reflect.DeepEqual(m.Exports, tt.exports) || !reflect.DeepEqual(m.Undefined, tt.undefined) || !reflect.DeepEqual(aliases, tt.aliases) {
+				t.Errorf("got exports %q, undefined %q and aliases %q, want %q, %q and %q", m.Exports, m.Undefined, aliases, tt.exports, tt.undefined, tt.aliases)
+			}
+			if got := m.Get("name"); len(got) != 1 || got[0] != tt.name {
+				t.Errorf("got name %q, want %s", got, tt.name)
+			}
+
+			f.Seek(0, 0)
+			if name, err := Name(f); err != nil || name != tt.name {
+				t.Errorf("Name() = %q, %v, want %s", name, err, tt.name)
+			}
+		})
+	}
+
+	f, err := os.Open("testdata/src/gen.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := ReadModule(f); err == nil {
+		t.Error("a shell script is a module")
+	}
+}
+
 func TestResolve(t *testing.T) {
-	path, err := modprobe.ResolveName("snd")
+	saved := moduleRoot
+	defer func() { moduleRoot = saved }()
+	root, err := filepath.Abs("testdata/lib/modules/6.1.0-test")
 	if err != nil {
-		t.Errorf("%s", err)
+		t.Fatal(err)
 	}
+	moduleRoot = root
 
-	if !strings.Contains(path, "snd") {
-		t.Fail()
+	path, err := ResolveName("jbd2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(root, "kernel/fs/jbd2/jbd2.ko.gz"); path != want {
+		t.Errorf("ResolveName(jbd2) = %q, want %q", path, want)
+	}
+	if _, err := ResolveName("snd"); err == nil {
+		t.Error("ResolveName(snd) found a module that isn't there")
 	}
 
-	if !strings.Contains(path, "/usr/modules") {
-		t.Fail()
+	// no modules at all is an error, not a panic
+	moduleRoot = filepath.Join(root, "nothere")
+	if _, err := ResolveName("jbd2"); err == nil || !strings.Contains(err.Error(), "nothere") {
+		t.Errorf("ResolveName(jbd2) = %v, want an error for the missing directory", err)
 	}
 }
@@ -0,0 +1,15 @@
+#include "modinfo.h"
+
+MODINFO(license, "license=GPL");
+MODINFO(description, "description=CRC16 calculations");
+MODINFO(depends, "depends=");
+MODINFO(name, "name=crc16");
+MODINFO(vermagic, "vermagic=6.1.0-test SMP mod_unload ");
+
+EXPORT(crc16);
+unsigned short crc16(unsigned short crc, const unsigned char *b, unsigned long n)
+{
+	while (n--)
+		crc = (crc >> 8) ^ *b++;
+	return crc;
+}
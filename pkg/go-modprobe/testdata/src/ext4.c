@@ -0,0 +1,24 @@
+#include "modinfo.h"
+
+MODINFO(alias1, "alias=fs-ext4");
+MODINFO(alias2, "alias=ext3");
+MODINFO(alias3, "alias=fs-ext3");
+MODINFO(softdep, "softdep=pre: crc32c");
+MODINFO(license, "license=GPL");
+MODINFO(description, "description=Fourth Extended Filesystem");
+MODINFO(author, "author=Remy Card, Stephen Tweedie, Andrew Morton, Andreas Dilger, Theodore Ts'o and others");
+MODINFO(parm, "parm=mballoc_debug:Debugging level for ext4's mballoc");
+MODINFO(parmtype, "parmtype=mballoc_debug:ushort");
+MODINFO(depends, "depends=jbd2,crc16");
+MODINFO(name, "name=ext4");
+MODINFO(vermagic, "vermagic=6.1.0-test SMP mod_unload ");
+
+extern int jbd2_journal_start(const unsigned char *);
+extern unsigned short crc16(unsigned short, const unsigned char *, unsigned long);
+extern int printk(const char *, ...);
+
+int ext4_init(void)
+{
+	printk("ext4\n");
+	return jbd2_journal_start((const unsigned char *)"ext4") + crc16(0, 0, 0);
+}
@@ -0,0 +1,14 @@
+#!/bin/sh
+# gen.sh builds the fake modules of lib/modules/6.1.0-test: ELF objects
+# with the .modinfo and __ksymtab_strings sections and the undefined
+# symbols of real modules, each compressed another way.
+set -e
+cd "$(dirname "$0")"
+dir=../lib/modules/6.1.0-test/kernel
+mkdir -p $dir/lib $dir/fs/jbd2 $dir/fs/ext4
+for m in crc16 jbd2 ext4; do
+	gcc -c -O2 -fno-asynchronous-unwind-tables -fno-pic -o $m.ko $m.c
+done
+zstd -q -f --rm crc16.ko -o $dir/lib/crc16.ko.zst
+gzip -n -9 -c jbd2.ko > $dir/fs/jbd2/jbd2.ko.gz && rm jbd2.ko
+xz -f -c ext4.ko > $dir/fs/ext4/ext4.ko.xz && rm ext4.ko
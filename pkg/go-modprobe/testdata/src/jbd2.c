@@ -0,0 +1,18 @@
+#include "modinfo.h"
+
+MODINFO(license, "license=GPL");
+MODINFO(parm, "parm=jbd2_debug:Debugging level for jbd2");
+MODINFO(parmtype, "parmtype=jbd2_debug:ushort");
+MODINFO(depends, "depends=crc16");
+MODINFO(name, "name=jbd2");
+MODINFO(vermagic, "vermagic=6.1.0-test SMP mod_unload ");
+
+extern unsigned short crc16(unsigned short, const unsigned char *, unsigned long);
+extern int printk(const char *, ...);
+
+EXPORT(jbd2_journal_start);
+int jbd2_journal_start(const unsigned char *b)
+{
+	printk("start\n");
+	return crc16(0, b, 4);
+}
@@ -0,0 +1,9 @@
+/* MODINFO puts key=value in .modinfo, as MODULE_INFO does in the kernel. */
+#define MODINFO(id, s) \
+	static const char __modinfo_##id[] \
+	__attribute__((section(".modinfo"), used, aligned(1))) = s
+
+/* EXPORT puts a symbol name in __ksymtab_strings, as EXPORT_SYMBOL does. */
+#define EXPORT(sym) \
+	static const char __kstrtab_##sym[] \
+	__attribute__((section("__ksymtab_strings"), used, aligned(1))) = #sym
@@ -0,0 +1,164 @@
+package kmod
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultConfigDirs are where modprobe.d(5) files are read from, in order.
+// A file in a directory hides those of the same name in the directories
+// after it.
+var DefaultConfigDirs = []string{
+	"/etc/modprobe.d",
+	"/run/modprobe.d",
+	"/usr/local/lib/modprobe.d",
+	"/usr/lib/modprobe.d",
+	"/lib/modprobe.d",
+}
+
+// alias is an alias line of the configuration: modules matching pattern
+// are name.
+type alias struct {
+	pattern, name string
+}
+
+// softdep are the modules to load before and after a module.
+type softdep struct {
+	pre, post []string
+}
+
+// config is what the modprobe.d files say of modules, by module name.
+type config struct {
+	aliases   []alias
+	blacklist map[string]bool
+	options   map[string][]string
+	install   map[string]string
+	remove    map[string]string
+	softdeps  map[string]softdep
+}
+
+func newConfig() *config {
+	return &config{
+		blacklist: map[string]bool{},
+		options:   map[string][]string{},
+		install:   map[string]string{},
+		remove:    map[string]string{},
+		softdeps:  map[string]softdep{},
+	}
+}
+
+// parse reads a modprobe.d file.
+//
+//	alias <wildcard> <modulename>
+//	blacklist <modulename>
+//	install <modulename> <command...>
+//	options <modulename> <option...>
+//	remove <modulename> <command...>
+//	softdep <modulename> pre: <modules...> post: <modules...>
+//
+// Lines ending in \ go on in the next line.
+func (c *config) parse(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	var line string
+	for scanner.Scan() {
+		line += scanner.Text()
+		if strings.HasSuffix(line, `\`) {
+			line = strings.TrimSuffix(line, `\`) + " "
+			continue
+		}
+		fields := strings.Fields(line)
+		line = ""
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		name := cleanName(fields[1])
+		switch fields[0] {
+		case "alias":
+			if len(fields) >= 3 {
+				c.aliases = append(c.aliases, alias{name, cleanName(fields[2])})
+			}
+		case "blacklist":
+			c.blacklist[name] = true
+		case "options":
+			c.options[name] = append(c.options[name], fields[2:]...)
+		case "install":
+			c.install[name] = strings.Join(fields[2:], " ")
+		case "remove":
+			c.remove[name] = strings.Join(fields[2:], " ")
+		case "softdep":
+			var s softdep
+			var list *[]string
+			for _, f := range fields[2:] {
+				switch f {
+				case "pre:":
+					list = &s.pre
+				case "post:":
+					list = &s.post
+				default:
+					if list != nil {
+						*list = append(*list, cleanName(f))
+					}
+				}
+			}
+			c.softdeps[name] = s
+		}
+	}
+	return scanner.Err()
+}
+
+// parseFile reads a modprobe.d file, if it exists.
+func (c *config) parseFile(name string) error {
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.parse(f)
+}
+
+// parseDirs reads the .conf files of dirs, by name, skipping those hidden
+// by a file of the same name in an earlier directory.
+func (c *config) parseDirs(dirs []string) error {
+	files := map[string]string{}
+	for _, d := range dirs {
+		names, err := filepath.Glob(filepath.Join(d, "*.conf"))
+		if err != nil {
+			return err
+		}
+		for _, n := range names {
+			if _, ok := files[filepath.Base(n)]; !ok {
+				files[filepath.Base(n)] = n
+			}
+		}
+	}
+	var names []string
+	for n := range files {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		if err := c.parseFile(files[n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// alias is the modules the alias lines make of name.
+func (c *config) alias(name string) []string {
+	var names []string
+	for _, a := range c.aliases {
+		if ok, _ := path.Match(a.pattern, name); ok {
+			names = append(names, a.name)
+		}
+	}
+	return names
+}
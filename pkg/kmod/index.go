@@ -0,0 +1,368 @@
+package kmod
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The binary indexes written by depmod, like modules.dep.bin, are tries of
+// keys and their values. All numbers are big endian. The file starts with
+//
+//	magic, version, root node offset	uint32 each
+//
+// and each node, at an offset with its flags in the high nibble, is
+//
+//	prefix		NUL terminated, with indexNodePrefix
+//	first, last	a byte each, with indexNodeChilds
+//	children	last-first+1 uint32 node offsets, 0 for none
+//	count		uint32, with indexNodeValues
+//	values		count uint32 priorities, each followed by a NUL
+//			terminated value
+//
+// The key of a node is the key of its parent, the byte of the node among
+// the children of its parent, and its prefix. The values of a key are in
+// the order of their priorities, which depmod makes the position of their
+// module.
+const (
+	indexMagic        = 0xb007f457
+	indexVersionMajor = 0x0002
+	indexVersionMinor = 0x0001
+
+	indexNodePrefix = 0x80000000
+	indexNodeValues = 0x40000000
+	indexNodeChilds = 0x20000000
+	indexNodeMask   = 0x0fffffff
+
+	// indexMaxDepth bounds the trie, against indexes that loop
+	indexMaxDepth = 4096
+)
+
+// ErrBadIndex is the error of a binary index that can not be read.
+var ErrBadIndex = errors.New("bad module index")
+
+// IndexValue is a value of a key in a binary index.
+type IndexValue struct {
+	Value    string
+	Priority uint32
+}
+
+// readIndex reads all the keys and values of a binary index.
+func readIndex(b []byte) (map[string][]string, error) {
+	index, err := readIndexValues(b)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string][]string{}
+	for k, values := range index {
+		for _, v := range values {
+			m[k] = append(m[k], v.Value)
+		}
+	}
+	return m, nil
+}
+
+// readIndexValues reads all the keys of a binary index, and their values
+// with their priorities.
+func readIndexValues(b []byte) (map[string][]IndexValue, error) {
+	if len(b) < 12 || binary.BigEndian.Uint32(b) != indexMagic {
+		return nil, fmt.Errorf("%w: no magic number", ErrBadIndex)
+	}
+	if v := binary.BigEndian.Uint32(b[4:]) >> 16; v != indexVersionMajor {
+		return nil, fmt.Errorf("%w: version %d", ErrBadIndex, v)
+	}
+	m := map[string][]IndexValue{}
+	var walk func(node uint32, key string, depth int) error
+	walk = func(node uint32, key string, depth int) error {
+		off := int(node & indexNodeMask)
+		if depth > indexMaxDepth || off < 12 || off > len(b) {
+			return fmt.Errorf("%w: node at %#x", ErrBadIndex, off)
+		}
+		p := b[off:]
+		cstring := func() (string, error) {
+			i := bytes.IndexByte(p, 0)
+			if i < 0 {
+				return "", fmt.Errorf("%w: string at %#x", ErrBadIndex, len(b)-len(p))
+			}
+			s := string(p[:i])
+			p = p[i+1:]
+			return s, nil
+		}
+		if node&indexNodePrefix != 0 {
+			prefix, err := cstring()
+			if err != nil {
+				return err
+			}
+			key += prefix
+		}
+		if node&indexNodeChilds != 0 {
+			if len(p) < 2 {
+				return fmt.Errorf("%w: children at %#x", ErrBadIndex, off)
+			}
+			first, last := int(p[0]), int(p[1])
+			n := last - first + 1
+			if n < 0 || len(p) < 2+4*n {
+				return fmt.Errorf("%w: children at %#x", ErrBadIndex, off)
+			}
+			children := p[2 : 2+4*n]
+			p = p[2+4*n:]
+			for i := 0; i < n; i++ {
+				if c := binary.BigEndian.Uint32(children[4*i:]); c != 0 {
+					if err := walk(c, key+string([]byte{byte(first + i)}), depth+1); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		if node&indexNodeValues != 0 {
+			if len(p) < 4 {
+				return fmt.Errorf("%w: values at %#x", ErrBadIndex, off)
+			}
+			count := binary.BigEndian.Uint32(p)
+			p = p[4:]
+			for ; count > 0; count-- {
+				if len(p) < 4 {
+					return fmt.Errorf("%w: values at %#x", ErrBadIndex, off)
+				}
+				priority := binary.BigEndian.Uint32(p)
+				p = p[4:]
+				v, err := cstring()
+				if err != nil {
+					return err
+				}
+				m[key] = append(m[key], IndexValue{v, priority})
+			}
+		}
+		return nil
+	}
+	if err := walk(binary.BigEndian.Uint32(b[8:]), "", 0); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// trie is a node of an index being written.
+type trie struct {
+	prefix   string
+	children map[byte]*trie
+	values   []IndexValue
+}
+
+// add adds the values of key. Like depmod, a value goes before those of
+// its priority already there.
+func (t *trie) add(key string, values []IndexValue) {
+	for i := 0; i < len(key); i++ {
+		if t.children == nil {
+			t.children = map[byte]*trie{}
+		}
+		c := t.children[key[i]]
+		if c == nil {
+			c = &trie{}
+			t.children[key[i]] = c
+		}
+		t = c
+	}
+	for _, v := range values {
+		i := sort.Search(len(t.values), func(i int) bool { return t.values[i].Priority >= v.Priority })
+		t.values = append(t.values[:i], append([]IndexValue{v}, t.values[i:]...)...)
+	}
+}
+
+// compress folds the nodes with no values and one child into the prefix of
+// their parents.
+func (t *trie) compress() {
+	for len(t.values) == 0 && len(t.children) == 1 {
+		for b, c := range t.children {
+			t.prefix += string([]byte{b}) + c.prefix
+			t.children, t.values = c.children, c.values
+		}
+	}
+	for _, c := range t.children {
+		c.compress()
+	}
+}
+
+// write writes the children of the node before the node, as their offsets
+// go in it, and returns the offset of the node with its flags.
+func (t *trie) write(b *bytes.Buffer) (uint32, error) {
+	var flags uint32
+	var first, last byte = 0xff, 0
+	offsets := map[byte]uint32{}
+	var keys []byte
+	for c := range t.children {
+		keys = append(keys, c)
+	}
+	// in order, for the same index from the same keys
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	for _, c := range keys {
+		child := t.children[c]
+		off, err := child.write(b)
+		if err != nil {
+			return 0, err
+		}
+		offsets[c] = off
+		first, last = min(first, c), max(last, c)
+	}
+
+	off := uint32(b.Len())
+	if off&^indexNodeMask != 0 {
+		return 0, fmt.Errorf("%w: more than %d bytes", ErrBadIndex, indexNodeMask)
+	}
+	if t.prefix != "" {
+		flags |= indexNodePrefix
+		b.WriteString(t.prefix)
+		b.WriteByte(0)
+	}
+	if len(t.children) > 0 {
+		flags |= indexNodeChilds
+		b.WriteByte(first)
+		b.WriteByte(last)
+		for c := int(first); c <= int(last); c++ {
+			binary.Write(b, binary.BigEndian, offsets[byte(c)])
+		}
+	}
+	if len(t.values) > 0 {
+		flags |= indexNodeValues
+		binary.Write(b, binary.BigEndian, uint32(len(t.values)))
+		for _, v := range t.values {
+			binary.Write(b, binary.BigEndian, v.Priority)
+			b.WriteString(v.Value)
+			b.WriteByte(0)
+		}
+	}
+	return off | flags, nil
+}
+
+// WriteIndex writes keys and their values as a binary index, like
+// modules.dep.bin, byte for byte as depmod does given the values of each
+// key in the order it adds them.
+func WriteIndex(w io.Writer, index map[string][]IndexValue) error {
+	root := &trie{}
+	for k, v := range index {
+		root.add(k, v)
+	}
+	// depmod never gives the root a prefix
+	for _, c := range root.children {
+		c.compress()
+	}
+
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, []uint32{indexMagic, indexVersionMajor<<16 | indexVersionMinor, 0})
+	off, err := root.write(&b)
+	if err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(b.Bytes()[8:], off)
+	_, err = w.Write(b.Bytes())
+	return err
+}
+
+// readLines calls fn with the fields of each line of a text file that is
+// not empty or a comment.
+func readLines(name string, fn func(fields []string)) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return scanLines(f, fn)
+}
+
+func scanLines(r io.Reader, fn func(fields []string)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fn(strings.Fields(line))
+	}
+	return scanner.Err()
+}
+
+// readDeps reads modules.dep.bin, or modules.dep if there is no binary
+// index, into the paths of each module, relative to dir, followed by those
+// of its dependencies.
+//
+// modules.dep
+//
+//	kernel/fs/ext4/ext4.ko.xz: kernel/fs/jbd2/jbd2.ko.xz kernel/fs/mbcache.ko.xz
+func readDeps(dir string) (map[string][]string, error) {
+	deps := map[string][]string{}
+	add := func(fields []string) {
+		if len(fields) == 0 || !strings.HasSuffix(fields[0], ":") {
+			return
+		}
+		fields[0] = strings.TrimSuffix(fields[0], ":")
+		deps[pathToName(fields[0])] = fields
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "modules.dep.bin")); err == nil {
+		index, err := readIndex(b)
+		if err != nil {
+			return nil, fmt.Errorf("modules.dep.bin: %w", err)
+		}
+		for _, values := range index {
+			for _, v := range values {
+				add(strings.Fields(v))
+			}
+		}
+		return deps, nil
+	}
+	if err := readLines(filepath.Join(dir, "modules.dep"), add); err != nil {
+		return nil, err
+	}
+	return deps, nil
+}
+
+// readAliases reads modules.alias.bin, or modules.alias, into the modules
+// of each alias pattern.
+//
+// modules.alias
+//
+//	alias fs-xfs xfs
+//	alias pci:v00008086d000010D3sv*sd*bc*sc*i* e1000e
+func readAliases(dir string) (map[string][]string, error) {
+	if b, err := os.ReadFile(filepath.Join(dir, "modules.alias.bin")); err == nil {
+		index, err := readIndex(b)
+		if err != nil {
+			return nil, fmt.Errorf("modules.alias.bin: %w", err)
+		}
+		aliases := map[string][]string{}
+		for pattern, mods := range index {
+			aliases[cleanName(pattern)] = append(aliases[cleanName(pattern)], mods...)
+		}
+		return aliases, nil
+	}
+	aliases := map[string][]string{}
+	err := readLines(filepath.Join(dir, "modules.alias"), func(fields []string) {
+		if len(fields) >= 3 && fields[0] == "alias" {
+			pattern := cleanName(fields[1])
+			aliases[pattern] = append(aliases[pattern], cleanName(fields[2]))
+		}
+	})
+	if os.IsNotExist(err) {
+		return aliases, nil
+	}
+	return aliases, err
+}
+
+// readBuiltin reads the names of the modules built into the kernel from
+// modules.builtin.
+func readBuiltin(dir string) (map[string]bool, error) {
+	builtin := map[string]bool{}
+	err := readLines(filepath.Join(dir, "modules.builtin"), func(fields []string) {
+		builtin[pathToName(fields[0])] = true
+	})
+	if os.IsNotExist(err) {
+		return builtin, nil
+	}
+	return builtin, err
+}
@@ -1,21 +1,22 @@
-//go:generate stringer -type status
-
 // Package kmod provides functions to load and unload Linux kernel modules.
 //
-// Module dependencies are loaded / unloaded automatically according to <mod_dir>/modules.dep.
-// Compressed module files can be loaded via a custom InitFunc provided by the caller.
-// See SetInitFunc and cmd/modprobe for details.
+// Modules are found by name or alias with <mod_dir>/modules.dep(.bin),
+// modules.alias(.bin) and modules.builtin, and their dependencies loaded /
+// unloaded automatically. The modprobe.d(5) files give options, aliases,
+// blacklists, install and remove commands and soft dependencies. Compressed
+// module files (.ko.xz, .ko.gz and .ko.zst) are loaded by the default
+// InitFunc. See SetInitFunc and cmd/modprobe for details.
 package kmod
 
 import (
-	"bufio"
-	"bytes"
 	"errors"
 	"fmt"
-	"io"
 	"log"
 	"os"
+	"os/exec"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"golang.org/x/sys/unix"
@@ -28,20 +29,37 @@ var ErrModuleNotFound = errors.New("module not found")
 // it is in use.
 var ErrModuleInUse = errors.New("module is in use")
 
+// ErrBlacklisted is the error resulting if the modules of an alias are all
+// blacklisted, or a module asked for by name is, with SetUseBlacklist.
+var ErrBlacklisted = errors.New("module is blacklisted")
+
 // InitFunc provides a hook to load a kernel module into the kernel.
 type InitFunc func(filename string, params string, flags int) error
 
+// RemoveFunc provides a hook to remove a kernel module from the kernel.
+type RemoveFunc func(name string, flags int) error
+
 // Kmod represents internal configuration
 type Kmod struct {
-	dryrun        bool
-	ignoreAlias   bool
-	ignoreBuiltin bool
-	ignoreStatus  bool
-	modConfig     string
-	modDir        string
-	modInitFunc   InitFunc
-	modRootdir    string
-	verbose       bool
+	dryrun         bool
+	ignoreAlias    bool
+	ignoreBuiltin  bool
+	ignoreStatus   bool
+	ignoreCommands bool
+	useBlacklist   bool
+	modConfig      string
+	configDirs     []string
+	modDir         string
+	modInitFunc    InitFunc
+	modRemoveFunc  RemoveFunc
+	modRootdir     string
+	release        string
+	verbose        bool
+
+	deps    map[string][]string
+	aliases map[string][]string
+	builtin map[string]bool
+	config  *config
 }
 
 // Option configures Kmod.
@@ -51,7 +69,7 @@ type Option func(*Kmod)
 func SetDryrun() Option { return func(k *Kmod) { k.dryrun = true } }
 
 // SetIgnoreAlias returns an Option that specifies not to consult modules.alias
-// to resolve aliases.
+// and the alias lines of the configuration to resolve aliases.
 func SetIgnoreAlias() Option { return func(k *Kmod) { k.ignoreAlias = true } }
 
 // SetIgnoreBuiltin returns an Option that specifies not to consult modules.builtin
@@ -62,97 +80,269 @@ func SetIgnoreBuiltin() Option { return func(k *Kmod) { k.ignoreBuiltin = true }
 // to get the current status of a module.
 func SetIgnoreStatus() Option { return func(k *Kmod) { k.ignoreStatus = true } }
 
+// SetIgnoreCommands returns an Option that specifies to load and unload
+// modules rather than run the install and remove commands of the
+// configuration.
+func SetIgnoreCommands() Option { return func(k *Kmod) { k.ignoreCommands = true } }
+
+// SetUseBlacklist returns an Option that specifies to refuse blacklisted
+// modules asked for by name too, not only those found by alias.
+func SetUseBlacklist() Option { return func(k *Kmod) { k.useBlacklist = true } }
+
 // SetInitFunc returns an Option that sets fn to be used for loading module files
 // into the kernel. The default function tries to use finit_module(2) first and if that
-// failes init_module(2). To support compressed module files see the example cmd/modprobe.
+// fails, or the file is compressed, init_module(2).
 func SetInitFunc(fn InitFunc) Option {
 	return func(k *Kmod) { k.modInitFunc = fn }
 }
 
+// SetRemoveFunc returns an Option that sets fn to be used for removing
+// modules from the kernel, instead of delete_module(2).
+func SetRemoveFunc(fn RemoveFunc) Option {
+	return func(k *Kmod) { k.modRemoveFunc = fn }
+}
+
 // SetConfigFile returns an Option that specifies the (optional) configuration file
-// for modules, default: /etc/modprobe.conf. The config file is used only for module
-// parameters.
-// options <name> parameter [parameter]...
+// for modules, default: /etc/modprobe.conf. It is read before the configuration
+// directories, in the format of modprobe.d(5).
 func SetConfigFile(path string) Option {
 	return func(k *Kmod) { k.modConfig = path }
 }
 
+// SetConfigDirs returns an Option that specifies the directories of
+// modprobe.d(5) files, default: DefaultConfigDirs.
+func SetConfigDirs(dirs ...string) Option {
+	return func(k *Kmod) { k.configDirs = dirs }
+}
+
 // SetRootDir returns an Option that sets dir as root directory for modules,
 // default: /lib/modules
 func SetRootDir(dir string) Option { return func(k *Kmod) { k.modRootdir = dir } }
 
+// SetKernelRelease returns an Option that sets the kernel release whose
+// modules are used, default: that of the running kernel (uname -r).
+func SetKernelRelease(release string) Option { return func(k *Kmod) { k.release = release } }
+
 // SetVerbose returns an Option that specifies to log info messages about what's going on.
 func SetVerbose() Option { return func(k *Kmod) { k.verbose = true } }
 
-// New returns a new Kmod.
+// New returns a new Kmod, with the module indexes and the configuration
+// read.
 func New(opts ...Option) (*Kmod, error) {
 	k := &Kmod{
-		modConfig:  "/etc/modprobe.conf",
-		modRootdir: "/lib/modules",
+		modConfig:     "/etc/modprobe.conf",
+		configDirs:    DefaultConfigDirs,
+		modRootdir:    "/lib/modules",
+		modInitFunc:   initFile,
+		modRemoveFunc: deleteModule,
 	}
 	for _, opt := range opts {
 		opt(k)
 	}
 
-	var u unix.Utsname
-	if err := unix.Uname(&u); err != nil {
-		return nil, err
+	if k.release == "" {
+		var u unix.Utsname
+		if err := unix.Uname(&u); err != nil {
+			return nil, err
+		}
+		k.release = unix.ByteSliceToString(u.Release[:])
 	}
-	rel := string(u.Release[:bytes.IndexByte(u.Release[:], 0)])
-	k.modDir = filepath.Join(k.modRootdir, rel)
+	k.modDir = filepath.Join(k.modRootdir, k.release)
 
-	if _, err := os.Stat(filepath.Join(k.modDir, "modules.dep")); err != nil {
+	var err error
+	if k.deps, err = readDeps(k.modDir); err != nil {
+		return nil, err
+	}
+	if k.aliases, err = readAliases(k.modDir); err != nil {
+		return nil, err
+	}
+	if k.builtin, err = readBuiltin(k.modDir); err != nil {
+		return nil, err
+	}
+	k.config = newConfig()
+	if k.modConfig != "" {
+		if err := k.config.parseFile(k.modConfig); err != nil {
+			return nil, err
+		}
+	}
+	if err := k.config.parseDirs(k.configDirs); err != nil {
+		return nil, err
+	}
+	// the soft dependencies depmod found in the modules
+	if err := k.config.parseFile(filepath.Join(k.modDir, "modules.softdep")); err != nil {
 		return nil, err
 	}
 	return k, nil
 }
 
-// Load loads a kernel module. If the module depends on other modules
-// Load will try to load all dependencies first.
-func (k *Kmod) Load(name, params string, flags int) error {
+// ModDir is the directory of the modules, like /lib/modules/6.1.0.
+func (k *Kmod) ModDir() string {
+	return k.modDir
+}
+
+// Resolve returns the modules name stands for: the modules of the aliases
+// matching it in the configuration, or the module of that name, or the
+// modules of the aliases matching it in modules.alias, without those
+// blacklisted.
+func (k *Kmod) Resolve(name string) ([]string, error) {
 	name = cleanName(name)
+	if !k.ignoreAlias {
+		if names := k.config.alias(name); len(names) > 0 {
+			names = k.notBlacklisted(names)
+			if len(names) == 0 {
+				return nil, fmt.Errorf("%s: %w", name, ErrBlacklisted)
+			}
+			k.infof("%s is alias for %s", name, names)
+			return names, nil
+		}
+	}
+	if k.deps[name] != nil || k.isBuiltin(name) {
+		if k.useBlacklist && k.config.blacklist[name] {
+			return nil, fmt.Errorf("%s: %w", name, ErrBlacklisted)
+		}
+		return []string{name}, nil
+	}
+	if k.ignoreAlias {
+		return nil, fmt.Errorf("%s: %w", name, ErrModuleNotFound)
+	}
 
-	realname, err := k.checkAlias(name)
-	if err != nil {
-		return fmt.Errorf("get alias %s failed: %w", name, err)
+	var names []string
+	seen := map[string]bool{}
+	for pattern, mods := range k.aliases {
+		if ok, _ := path.Match(pattern, name); !ok {
+			continue
+		}
+		for _, m := range mods {
+			if !seen[m] {
+				seen[m] = true
+				names = append(names, m)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%s: %w", name, ErrModuleNotFound)
 	}
-	if realname != "" {
-		k.infof("%s is alias for %s", name, realname)
-		name = realname
+	sort.Strings(names)
+	if names = k.notBlacklisted(names); len(names) == 0 {
+		return nil, fmt.Errorf("%s: %w", name, ErrBlacklisted)
 	}
+	k.infof("%s is alias for %s", name, names)
+	return names, nil
+}
+
+// notBlacklisted are the names that are not blacklisted
+func (k *Kmod) notBlacklisted(names []string) []string {
+	var ok []string
+	for _, n := range names {
+		if k.config.blacklist[n] {
+			k.infof("%s is blacklisted", n)
+			continue
+		}
+		ok = append(ok, n)
+	}
+	return ok
+}
 
-	builtin, err := k.isBuiltin(name)
+// Load loads a kernel module, or the modules of an alias. If a module
+// depends on other modules Load will try to load all dependencies first.
+// params are added to the options of the configuration.
+func (k *Kmod) Load(name, params string, flags int) error {
+	names, err := k.Resolve(name)
 	if err != nil {
-		return fmt.Errorf("check builtin %s failed: %w", name, err)
+		return err
+	}
+	var errs []error
+	for _, n := range names {
+		if err := k.loadModule(n, params, flags, map[string]bool{}); err != nil {
+			errs = append(errs, err)
+		}
 	}
-	if builtin {
+	return errors.Join(errs...)
+}
+
+// loadModule loads a module after its dependencies, and its soft
+// dependencies around it. seen are the modules already on the way, against
+// soft dependencies that loop.
+func (k *Kmod) loadModule(name, params string, flags int, seen map[string]bool) error {
+	if seen[name] {
+		return nil
+	}
+	seen[name] = true
+
+	if cmd, ok := k.config.install[name]; ok && !k.ignoreCommands {
+		return k.command(name, cmd, k.params(name, params))
+	}
+	if k.isBuiltin(name) {
 		k.infof("%s is builtin", name)
 		return nil
 	}
 
-	modules, err := k.modDeps(name)
-	if err != nil {
-		return err
+	soft := k.config.softdeps[name]
+	for _, s := range soft.pre {
+		k.softdep(s, seen)
 	}
 
-	if err := k.applyConfig(modules); err != nil {
+	modules, err := k.modDeps(name)
+	if err != nil {
 		return err
 	}
-	modules[0].params += " " + params
+	modules[0].params = k.params(name, params)
 	modules[0].flags = flags
 
-	// load dependencies first, ignore errors
+	// load dependencies first
 	for i := len(modules) - 1; i > 0; i-- {
-		_ = k.load(modules[i])
+		if seen[modules[i].name] {
+			continue
+		}
+		seen[modules[i].name] = true
+		if err := k.load(modules[i]); err != nil && !errors.Is(err, unix.EEXIST) {
+			return fmt.Errorf("load %s, needed by %s, failed: %w", modules[i].name, name, err)
+		}
 	}
 
 	// load target module, check error
 	err = k.load(modules[0])
-	if errors.Is(err, unix.EEXIST) {
-		return nil
+	if err != nil && !errors.Is(err, unix.EEXIST) {
+		return fmt.Errorf("load %s failed: %w", name, err)
+	}
+
+	for _, s := range soft.post {
+		k.softdep(s, seen)
+	}
+	return nil
+}
+
+// softdep loads a soft dependency, that may fail.
+func (k *Kmod) softdep(name string, seen map[string]bool) {
+	names, err := k.Resolve(name)
+	for _, n := range names {
+		if err == nil {
+			err = k.loadModule(n, "", 0, seen)
+		}
 	}
 	if err != nil {
-		return fmt.Errorf("load %s failed: %w", modules[0].name, err)
+		k.infof("soft dependency %s: %v", name, err)
+	}
+}
+
+// params joins the options of the configuration for a module and params.
+func (k *Kmod) params(name, params string) string {
+	return strings.TrimSpace(strings.Join(k.config.options[name], " ") + " " + params)
+}
+
+// command runs an install or remove command, with $CMDLINE_OPTS replaced by
+// params, through the shell.
+func (k *Kmod) command(name, cmd, params string) error {
+	cmd = strings.ReplaceAll(cmd, "$CMDLINE_OPTS", params)
+	k.infof("running %q for %s", cmd, name)
+	if k.dryrun {
+		return nil
+	}
+	c := exec.Command("/bin/sh", "-c", cmd)
+	c.Env = append(os.Environ(), "MODPROBE_MODULE="+name)
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("%s: command %q: %w", name, cmd, err)
 	}
 	return nil
 }
@@ -160,22 +350,24 @@ func (k *Kmod) Load(name, params string, flags int) error {
 // Unload unloads a module from the kernel. Unload also tries to unload all
 // module dependencies that are no longer in use.
 func (k *Kmod) Unload(name string) error {
-	name = cleanName(name)
-
-	alias, err := k.checkAlias(name)
+	names, err := k.Resolve(name)
 	if err != nil {
-		return fmt.Errorf("check alias %s failed: %w", name, err)
+		return err
 	}
-	if alias != "" {
-		k.infof("%s is alias for %s", name, alias)
-		name = alias
+	var errs []error
+	for _, n := range names {
+		if err := k.unloadModule(n); err != nil {
+			errs = append(errs, err)
+		}
 	}
+	return errors.Join(errs...)
+}
 
-	builtin, err := k.isBuiltin(name)
-	if err != nil {
-		return fmt.Errorf("check builtin %s failed: %w", name, err)
+func (k *Kmod) unloadModule(name string) error {
+	if cmd, ok := k.config.remove[name]; ok && !k.ignoreCommands {
+		return k.command(name, cmd, "")
 	}
-	if builtin {
+	if k.isBuiltin(name) {
 		k.infof("%s is builtin", name)
 		return nil
 	}
@@ -187,10 +379,10 @@ func (k *Kmod) Unload(name string) error {
 
 	// unload target module, check error
 	if err := k.unload(modules[0]); err != nil {
-		if err == unix.EBUSY || err == unix.EAGAIN {
-			return ErrModuleInUse
+		if errors.Is(err, unix.EBUSY) || errors.Is(err, unix.EAGAIN) {
+			return fmt.Errorf("%s: %w", name, ErrModuleInUse)
 		}
-		if err != unix.ENOENT {
+		if !errors.Is(err, unix.ENOENT) {
 			return err
 		}
 	}
@@ -203,63 +395,43 @@ func (k *Kmod) Unload(name string) error {
 	return nil
 }
 
-// Dependencies returns a list of module dependencies.
+// Dependencies returns the paths of a module and its dependencies, in the
+// order to load them, with the target module last.
 func (k *Kmod) Dependencies(name string) ([]string, error) {
-	name = cleanName(name)
-
-	realname, err := k.checkAlias(name)
-	if err != nil {
-		return nil, fmt.Errorf("get alias %s failed: %w", name, err)
-	}
-	if realname != "" {
-		k.infof("%s is alias for %s", name, realname)
-		name = realname
-	}
-
-	builtin, err := k.isBuiltin(name)
-	if err != nil {
-		return nil, fmt.Errorf("check builtin %s failed: %w", name, err)
-	}
-	if builtin {
-		return nil, fmt.Errorf("%s is builtin", name)
-	}
-
-	modules, err := k.modDeps(name)
+	names, err := k.Resolve(name)
 	if err != nil {
 		return nil, err
 	}
 
 	var list []string
-	for i := len(modules) - 1; i >= 0; i-- {
-		list = append(list, modules[i].path)
+	for _, n := range names {
+		if k.isBuiltin(n) {
+			return nil, fmt.Errorf("%s is builtin", n)
+		}
+		modules, err := k.modDeps(n)
+		if err != nil {
+			return nil, err
+		}
+		for i := len(modules) - 1; i >= 0; i-- {
+			list = append(list, modules[i].path)
+		}
 	}
 
 	return list, nil
 }
 
-func (k *Kmod) isBuiltin(name string) (bool, error) {
-	if k.ignoreBuiltin {
-		return false, nil
-	}
-	f, err := os.Open(filepath.Join(k.modDir, "modules.builtin"))
-	if err != nil {
-		return false, err
-	}
-	defer f.Close()
+// Options returns the options of the configuration for a module.
+func (k *Kmod) Options(name string) string {
+	return k.params(cleanName(name), "")
+}
 
-	var found bool
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if pathToName(line) == name {
-			found = true
-			break
-		}
-	}
-	if err := scanner.Err(); err != nil {
-		return false, err
-	}
-	return found, nil
+// Builtin tells whether a module is built into the kernel.
+func (k *Kmod) Builtin(name string) bool {
+	return k.isBuiltin(cleanName(name))
+}
+
+func (k *Kmod) isBuiltin(name string) bool {
+	return !k.ignoreBuiltin && k.builtin[name]
 }
 
 type module struct {
@@ -280,160 +452,54 @@ const (
 	inuse
 )
 
-// /proc/modules
-//
-//	     name | memory size | reference count | references | state: <Live|Loading|Unloading>
-//			macvlan 28672 1 macvtap, Live 0x0000000000000000
+// modStatus is the status of a module in /proc/modules
 func (k *Kmod) modStatus(name string) (status, error) {
-	var state status = unknown
 	if k.ignoreStatus {
-		return state, nil
+		return unknown, nil
 	}
-	f, err := os.Open("/proc/modules")
+	mods, err := LoadedModules()
 	if err != nil {
-		return state, err
+		return unknown, err
 	}
-	defer f.Close()
-
-	state = unloaded
-
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		fields := strings.Fields(scanner.Text())
-		if fields[0] == name {
-			if fields[2] != "0" {
-				state = inuse
-				break
-			}
-			switch fields[4] {
-			case "Live":
-				state = live
-			case "Loading":
-				state = loading
-			case "Unloading":
-				state = unloading
-			}
-			break
-		}
-	}
-	if err := scanner.Err(); err != nil {
-		return state, err
-	}
-
-	return state, nil
-}
-
-// modules.alias
-//
-//	alias   fs-xfs     xfs
-func (k *Kmod) checkAlias(name string) (string, error) {
-	if k.ignoreAlias {
-		return "", nil
-	}
-	f, err := os.Open(filepath.Join(k.modDir, "modules.alias"))
-	if err != nil {
-		return "", err
-	}
-	defer f.Close()
-
-	var realname string
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "#") {
+	for _, m := range mods {
+		if m.Name != name {
 			continue
 		}
-		fields := strings.Fields(line)
-		if len(fields) >= 3 {
-			if cleanName(fields[1]) == name {
-				realname = fields[2]
-				break
-			}
+		if m.Refs != 0 {
+			return inuse, nil
 		}
+		switch m.State {
+		case "Live":
+			return live, nil
+		case "Loading":
+			return loading, nil
+		case "Unloading":
+			return unloading, nil
+		}
+		return unknown, nil
 	}
-	if err := scanner.Err(); err != nil {
-		return "", err
-	}
-
-	return realname, nil
+	return unloaded, nil
 }
 
 // modDeps returns a module and all its depenencies
 func (k *Kmod) modDeps(name string) ([]module, error) {
-	f, err := os.Open(filepath.Join(k.modDir, "modules.dep"))
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-
-	var deps []string
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := scanner.Text()
-		fields := strings.Fields(line)
-		if pathToName(strings.TrimSuffix(fields[0], ":")) == name {
-			deps = fields
-			break
-		}
-	}
-	if err := scanner.Err(); err != nil {
-		return nil, err
-	}
-
+	deps := k.deps[name]
 	if len(deps) == 0 {
-		return nil, ErrModuleNotFound
+		return nil, fmt.Errorf("%s: %w", name, ErrModuleNotFound)
 	}
-	deps[0] = strings.TrimSuffix(deps[0], ":")
 
 	var modules []module
 	for _, v := range deps {
 		modules = append(modules, module{
-			name: pathToName(v),
-			path: filepath.Join(k.modDir, v),
+			name:   pathToName(v),
+			path:   filepath.Join(k.modDir, v),
+			params: k.params(pathToName(v), ""),
 		})
 	}
 
 	return modules, nil
 }
 
-// modprobe.conf
-//
-//	options <modname> option [option]...
-func (k *Kmod) applyConfig(modules []module) error {
-	if k.modConfig == "" {
-		return nil
-	}
-	f, err := os.Open(k.modConfig)
-	if os.IsNotExist(err) {
-		return nil
-	}
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if strings.HasPrefix(line, "#") {
-			continue
-		}
-		fields := strings.Fields(line)
-		if len(fields) < 3 || fields[0] != "options" {
-			continue
-		}
-		name := cleanName(fields[1])
-		for i, m := range modules {
-			if m.name == name {
-				m.params = strings.Join(fields[2:], " ")
-				modules[i] = m
-				break
-			}
-		}
-	}
-	return scanner.Err()
-}
-
 func (k *Kmod) load(m module) error {
 	state, err := k.modStatus(m.name)
 	if err != nil {
@@ -442,34 +508,11 @@ func (k *Kmod) load(m module) error {
 	if state >= loading {
 		return nil
 	}
+	k.infof("loading %s %s %s", m.name, m.path, m.params)
 	if k.dryrun {
 		return nil
 	}
-	k.infof("loading %s %s %s", m.name, m.path, m.params)
-
-	if k.modInitFunc != nil {
-		return k.modInitFunc(m.path, m.params, m.flags)
-	}
-
-	f, err := os.Open(m.path)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	// first try finit_module(2), then init_module(2)
-	err = unix.FinitModule(int(f.Fd()), m.params, m.flags)
-	if errors.Is(err, unix.ENOSYS) {
-		if m.flags != 0 {
-			return err
-		}
-		buf, err := io.ReadAll(f)
-		if err != nil {
-			return err
-		}
-		return unix.InitModule(buf, m.params)
-	}
-	return err
+	return k.modInitFunc(m.path, m.params, m.flags)
 }
 
 func (k *Kmod) unload(m module) error {
@@ -483,11 +526,11 @@ func (k *Kmod) unload(m module) error {
 	if state == inuse {
 		return ErrModuleInUse
 	}
+	k.infof("unloading %s", m.name)
 	if k.dryrun {
 		return nil
 	}
-	k.infof("unloading %s", m.name)
-	return unix.DeleteModule(m.name, 0)
+	return k.modRemoveFunc(m.name, 0)
 }
 
 func (k *Kmod) infof(format string, a ...interface{}) {
@@ -0,0 +1,497 @@
+package kmod
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const release = "6.1.0-test"
+
+// fake records the modules loaded and removed instead of touching the
+// kernel
+type fake struct {
+	loaded  []string
+	removed []string
+	fail    map[string]error
+}
+
+func (f *fake) init(filename, params string, flags int) error {
+	name := strings.TrimPrefix(filename, filepath.Join("testdata/lib/modules", release)+"/")
+	if err := f.fail[name]; err != nil {
+		return err
+	}
+	f.loaded = append(f.loaded, strings.TrimSpace(fmt.Sprintf("%s %s", name, params)))
+	return nil
+}
+
+func (f *fake) remove(name string, flags int) error {
+	f.removed = append(f.removed, name)
+	return nil
+}
+
+// newKmod makes a Kmod on the fixture tree, with the configuration conf
+func newKmod(t *testing.T, f *fake, conf string, opts ...Option) *Kmod {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "test.conf"), []byte(conf), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opts = append([]Option{
+		SetRootDir("testdata/lib/modules"),
+		SetKernelRelease(release),
+		SetConfigFile(""),
+		SetConfigDirs(dir),
+		SetIgnoreStatus(),
+		SetInitFunc(f.init),
+		SetRemoveFunc(f.remove),
+	}, opts...)
+	k, err := New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+const conf = `# options going on in the next line
+options ext4 debug=1 \
+	journal=2
+options crc16 fast
+alias my-net e1000e
+blacklist igb
+softdep e1000e post: dca
+`
+
+func TestLoad(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		module string
+		params string
+		opts   []Option
+		want   []string
+		err    error
+	}{
+		{
+			name:   "dependencies, options and soft dependencies",
+			module: "ext4",
+			params: "x=1",
+			want: []string{
+				"kernel/lib/crc16.ko fast",
+				"kernel/fs/mbcache.ko.zst",
+				"kernel/fs/jbd2/jbd2.ko.gz",
+				"kernel/fs/ext4/ext4.ko.xz debug=1 journal=2 x=1",
+			},
+		},
+		{
+			name:   "alias",
+			module: "fs-ext4",
+			want:   []string{"kernel/lib/crc16.ko fast", "kernel/fs/mbcache.ko.zst", "kernel/fs/jbd2/jbd2.ko.gz", "kernel/fs/ext4/ext4.ko.xz debug=1 journal=2"},
+		},
+		{
+			name:   "wildcard alias without the blacklisted module",
+			module: "pci:v00008086d000010D3sv00001028sd00000001bc02sc00i00",
+			want:   []string{"kernel/drivers/net/ethernet/intel/e1000e/e1000e.ko", "kernel/drivers/dca/dca.ko"},
+		},
+		{
+			name:   "alias of the configuration",
+			module: "my-net",
+			want:   []string{"kernel/drivers/net/ethernet/intel/e1000e/e1000e.ko", "kernel/drivers/dca/dca.ko"},
+		},
+		{
+			name:   "alias of a blacklisted module",
+			module: "net-pf-99",
+			err:    ErrBlacklisted,
+		},
+		{
+			name:   "blacklisted module by name",
+			module: "igb",
+			want:   []string{"kernel/drivers/dca/dca.ko", "kernel/drivers/net/ethernet/intel/igb/igb.ko"},
+		},
+		{
+			name:   "blacklisted module by name with the blacklist",
+			module: "igb",
+			opts:   []Option{SetUseBlacklist()},
+			err:    ErrBlacklisted,
+		},
+		{
+			name:   "hyphens",
+			module: "snd-hda-intel",
+			want:   []string{"kernel/sound/pci/hda/snd-hda-intel.ko"},
+		},
+		{
+			name:   "builtin",
+			module: "loop",
+		},
+		{
+			name:   "not found",
+			module: "nothere",
+			err:    ErrModuleNotFound,
+		},
+		{
+			name:   "aliases ignored",
+			module: "fs-ext4",
+			opts:   []Option{SetIgnoreAlias()},
+			err:    ErrModuleNotFound,
+		},
+		{
+			name:   "dry run",
+			module: "ext4",
+			opts:   []Option{SetDryrun()},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fake{}
+			k := newKmod(t, f, conf, tt.opts...)
+			err := k.Load(tt.module, tt.params, 0)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(f.loaded, tt.want) {
+				t.Errorf("loaded %q, want %q", f.loaded, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadFails(t *testing.T) {
+	f := &fake{fail: map[string]error{"kernel/fs/mbcache.ko.zst": os.ErrPermission}}
+	k := newKmod(t, f, "")
+	if err := k.Load("ext4", "", 0); !errors.Is(err, os.ErrPermission) || !strings.Contains(err.Error(), "mbcache, needed by ext4") {
+		t.Errorf("got %v, want mbcache failing for ext4", err)
+	}
+	if want := []string{"kernel/lib/crc16.ko"}; !reflect.DeepEqual(f.loaded, want) {
+		t.Errorf("loaded %q, want %q", f.loaded, want)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	f := &fake{}
+	k := newKmod(t, f, fmt.Sprintf("options e1000e debug=3\ninstall e1000e echo install $MODPROBE_MODULE $CMDLINE_OPTS >> %s\nremove e1000e echo remove >> %[1]s\n", out))
+	if err := k.Load("e1000e", "x=1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Unload("e1000e"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "install e1000e debug=3 x=1\nremove\n"; string(b) != want {
+		t.Errorf("got %q, want %q", b, want)
+	}
+	if len(f.loaded) != 0 || len(f.removed) != 0 {
+		t.Errorf("loaded %q and removed %q, want the commands only", f.loaded, f.removed)
+	}
+
+	k = newKmod(t, f, "install e1000e false\n", SetIgnoreCommands())
+	if err := k.Load("e1000e", "", 0); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"kernel/drivers/net/ethernet/intel/e1000e/e1000e.ko"}; !reflect.DeepEqual(f.loaded, want) {
+		t.Errorf("loaded %q, want %q", f.loaded, want)
+	}
+}
+
+func TestUnload(t *testing.T) {
+	saved := procModules
+	defer func() { procModules = saved }()
+	procModules = "testdata/modules"
+
+	f := &fake{}
+	k := newKmod(t, f, "")
+	k.ignoreStatus = false
+	// jbd2, mbcache and crc16 are still used by ext4, as far as the fake
+	// /proc/modules tells
+	if err := k.Unload("ext4"); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Unload("dca"); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Unload("jbd2"); !errors.Is(err, ErrModuleInUse) {
+		t.Errorf("got %v, want %v", err, ErrModuleInUse)
+	}
+	if want := []string{"ext4"}; !reflect.DeepEqual(f.removed, want) {
+		t.Errorf("removed %q, want %q", f.removed, want)
+	}
+
+	// e1000e is loading, and ext4 live
+	if err := k.Load("e1000e", "", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Load("ext4", "", 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.loaded) != 0 {
+		t.Errorf("loaded %q, want nothing", f.loaded)
+	}
+}
+
+func TestDryRunVerbose(t *testing.T) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+
+	f := &fake{}
+	k := newKmod(t, f, "", SetDryrun(), SetVerbose())
+	if err := k.Load("fs-ext4", "", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Unload("ext4"); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.loaded) != 0 || len(f.removed) != 0 {
+		t.Errorf("loaded %q and removed %q in a dry run", f.loaded, f.removed)
+	}
+	// a dry run still tells what it would do
+	for _, want := range []string{"fs_ext4 is alias for [ext4]", "loading crc16 ", "loading jbd2 ", "loading ext4 ", "unloading ext4"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("dry run printed\n%s\nwithout %q", out.String(), want)
+		}
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	k := newKmod(t, &fake{}, conf)
+	deps, err := k.Dependencies("ext3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"kernel/lib/crc16.ko", "kernel/fs/mbcache.ko.zst", "kernel/fs/jbd2/jbd2.ko.gz", "kernel/fs/ext4/ext4.ko.xz"}
+	for i := range want {
+		want[i] = filepath.Join(k.ModDir(), want[i])
+	}
+	if !reflect.DeepEqual(deps, want) {
+		t.Errorf("got %q, want %q", deps, want)
+	}
+	if _, err := k.Dependencies("ext2"); err == nil {
+		t.Error("a builtin module has dependencies")
+	}
+	if got := k.Options("ext4"); got != "debug=1 journal=2" {
+		t.Errorf("got options %q, want debug=1 journal=2", got)
+	}
+}
+
+func TestConfigDirs(t *testing.T) {
+	etc, lib := t.TempDir(), t.TempDir()
+	for name, data := range map[string]string{
+		filepath.Join(etc, "a.conf"):     "options ext4 etc",
+		filepath.Join(lib, "a.conf"):     "options ext4 hidden",
+		filepath.Join(lib, "b.conf"):     "options ext4 lib",
+		filepath.Join(lib, "c.conf.old"): "options ext4 old",
+	} {
+		if err := os.WriteFile(name, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := newConfig()
+	if err := c.parseDirs([]string{etc, lib}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c.options["ext4"], []string{"etc", "lib"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// index makes a binary index of keys starting with different bytes: the
+// root has a child for each, holding the rest of the key and the values
+func index(keys map[string][]string) []byte {
+	var firsts []string
+	for k := range keys {
+		firsts = append(firsts, k)
+	}
+	sort.Strings(firsts)
+	first, last := firsts[0][0], firsts[len(firsts)-1][0]
+
+	u32 := func(b []byte, v uint32) []byte { return binary.BigEndian.AppendUint32(b, v) }
+	b := u32(u32(u32(nil, indexMagic), indexVersionMajor<<16|1), 12|indexNodeChilds)
+	b = append(b, first, last)
+	children := len(b)
+	b = append(b, make([]byte, 4*(int(last-first)+1))...)
+	for _, k := range firsts {
+		binary.BigEndian.PutUint32(b[children+4*int(k[0]-first):], uint32(len(b))|indexNodePrefix|indexNodeValues)
+		b = append(append(b, k[1:]...), 0)
+		b = u32(b, uint32(len(keys[k])))
+		for _, v := range keys[k] {
+			b = append(append(u32(b, 0), v...), 0)
+		}
+	}
+	return b
+}
+
+func TestIndex(t *testing.T) {
+	keys := map[string][]string{
+		"ext4":  {"kernel/fs/ext4/ext4.ko: kernel/lib/crc16.ko"},
+		"crc16": {"kernel/lib/crc16.ko:"},
+		"abc":   {"a", "b"},
+	}
+	got, err := readIndex(index(keys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, keys) {
+		t.Errorf("got %q, want %q", got, keys)
+	}
+
+	dir := filepath.Join(t.TempDir(), release)
+	os.MkdirAll(dir, 0o755)
+	delete(keys, "abc")
+	if err := os.WriteFile(filepath.Join(dir, "modules.dep.bin"), index(keys), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f := &fake{}
+	k, err := New(SetRootDir(filepath.Dir(dir)), SetKernelRelease(release), SetConfigFile(""), SetConfigDirs(), SetIgnoreStatus(), SetInitFunc(f.init))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps, err := k.Dependencies("ext4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{filepath.Join(dir, "kernel/lib/crc16.ko"), filepath.Join(dir, "kernel/fs/ext4/ext4.ko")}; !reflect.DeepEqual(deps, want) {
+		t.Errorf("got %q, want %q", deps, want)
+	}
+
+	for _, bad := range [][]byte{
+		nil,
+		[]byte("not an index at all"),
+		index(keys)[:30],
+		append(u32be(indexMagic, 1<<16, 12|indexNodeChilds), 'a', 'a', 0, 0, 0, 12|0x20),
+	} {
+		if _, err := readIndex(bad); !errors.Is(err, ErrBadIndex) {
+			t.Errorf("readIndex(%q): got %v, want %v", bad, err, ErrBadIndex)
+		}
+	}
+}
+
+func u32be(vs ...uint32) []byte {
+	var b []byte
+	for _, v := range vs {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	return b
+}
+
+func TestReadLoadedModules(t *testing.T) {
+	f, err := os.Open("testdata/modules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	mods, err := ReadLoadedModules(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mods) != 5 {
+		t.Fatalf("got %d modules, want 5", len(mods))
+	}
+	want := LoadedModule{Name: "jbd2", Size: 184320, Refs: 1, UsedBy: []string{"ext4"}, State: "Live"}
+	if !reflect.DeepEqual(mods[1], want) {
+		t.Errorf("got %+v, want %+v", mods[1], want)
+	}
+	if mods[0].UsedBy != nil {
+		t.Errorf("got %q using ext4, want none", mods[0].UsedBy)
+	}
+	if _, err := ReadLoadedModules(strings.NewReader("short line\n")); err == nil {
+		t.Error("a short line is not an error")
+	}
+}
+
+func TestWriteIndex(t *testing.T) {
+	for _, keys := range []map[string][]IndexValue{
+		{},
+		{"ext4": {{"kernel/fs/ext4/ext4.ko:", 0}}},
+		{
+			"symbol:crc16":              {{"crc16", 2}},
+			"symbol:crc16_table":        {{"crc16", 2}},
+			"symbol:jbd2_journal_start": {{"jbd2", 1}},
+			"pci:v00008086d*":           {{"e1000e", 0}, {"igb", 3}},
+			"pci:v00008086d000010D3*":   {{"e1000e", 0}},
+			"p":                         {{"a", 0}},
+			"\xff\x80":                  {{"high bytes", 0}},
+		},
+	} {
+		var b bytes.Buffer
+		if err := WriteIndex(&b, keys); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readIndexValues(b.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, keys) {
+			t.Errorf("got %q, want %q", got, keys)
+		}
+		if root := binary.BigEndian.Uint32(b.Bytes()[8:]); root&indexNodePrefix != 0 {
+			t.Errorf("the root of %q has a prefix, which depmod never gives it", keys)
+		}
+		var again bytes.Buffer
+		WriteIndex(&again, keys)
+		if !bytes.Equal(b.Bytes(), again.Bytes()) {
+			t.Errorf("two indexes of %q differ", keys)
+		}
+	}
+
+	// like depmod, by priority, and the last added first among equals
+	var b bytes.Buffer
+	WriteIndex(&b, map[string][]IndexValue{"a": {{"x", 2}, {"y", 0}, {"z", 2}, {"w", 1}}})
+	got, err := readIndex(b.Bytes())
+	if want := []string{"y", "w", "z", "x"}; err != nil || !reflect.DeepEqual(got["a"], want) {
+		t.Errorf("values of a = %q, %v, want %q", got["a"], err, want)
+	}
+}
+
+// TestGoldenIndex checks what we read and write against the indexes of the
+// modules in pkg/go-modprobe/testdata. They were written with the index code
+// of depmod in kmod 30, and libkmod 30 reads them back as
+//
+//	crc16 kernel/lib/crc16.ko.zst:
+//	ext4 kernel/fs/ext4/ext4.ko.xz: kernel/fs/jbd2/jbd2.ko.gz kernel/lib/crc16.ko.zst
+//	jbd2 kernel/fs/jbd2/jbd2.ko.gz: kernel/lib/crc16.ko.zst
+//	alias ext3 ext4
+//	alias fs_ext3 ext4
+//	alias fs_ext4 ext4
+func TestGoldenIndex(t *testing.T) {
+	for name, want := range map[string]map[string][]IndexValue{
+		"modules.dep.bin": {
+			"ext4":  {{"kernel/fs/ext4/ext4.ko.xz: kernel/fs/jbd2/jbd2.ko.gz kernel/lib/crc16.ko.zst", 0}},
+			"jbd2":  {{"kernel/fs/jbd2/jbd2.ko.gz: kernel/lib/crc16.ko.zst", 1}},
+			"crc16": {{"kernel/lib/crc16.ko.zst:", 2}},
+		},
+		"modules.alias.bin": {
+			"fs_ext3": {{"ext4", 0}},
+			"ext3":    {{"ext4", 0}},
+			"fs_ext4": {{"ext4", 0}},
+		},
+	} {
+		golden, err := os.ReadFile(filepath.Join("testdata/index", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := readIndexValues(golden)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+		var b bytes.Buffer
+		if err := WriteIndex(&b, want); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b.Bytes(), golden) {
+			t.Errorf("WriteIndex() of %s = %x, want %x", name, b.Bytes(), golden)
+		}
+	}
+}
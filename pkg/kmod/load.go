@@ -0,0 +1,96 @@
+// Copyright 2017-2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kmod
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+	"github.com/ulikunitz/xz"
+	"golang.org/x/sys/unix"
+)
+
+// Flags to finit_module(2) / FileInit.
+const (
+	// Ignore symbol version hashes.
+	MODULE_INIT_IGNORE_MODVERSIONS = 0x1
+
+	// Ignore kernel version magic.
+	MODULE_INIT_IGNORE_VERMAGIC = 0x2
+)
+
+// Init loads the kernel module given by image with the given options.
+func Init(image []byte, opts string) error {
+	return unix.InitModule(image, opts)
+}
+
+// FileInit loads the kernel module contained by `f` with the given opts and
+// flags. Uncompresses modules with a .xz, .gz and .zst suffix before loading.
+//
+// FileInit falls back to init_module(2) via Init when the finit_module(2)
+// syscall is not available and when loading compressed modules.
+func FileInit(f *os.File, opts string, flags uintptr) error {
+	var r io.Reader
+	var err error
+	switch filepath.Ext(f.Name()) {
+	case ".xz":
+		if r, err = xz.NewReader(f); err != nil {
+			return err
+		}
+	case ".gz":
+		if r, err = pgzip.NewReader(f); err != nil {
+			return err
+		}
+	case ".zst":
+		d, err := zstd.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer d.Close()
+		r = d
+	}
+
+	if r == nil {
+		err := unix.FinitModule(int(f.Fd()), opts, int(flags))
+		if !errors.Is(err, unix.ENOSYS) {
+			return err
+		}
+		if flags != 0 {
+			return err
+		}
+		// Fall back to init_module(2).
+		r = f
+	}
+
+	img, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return Init(img, opts)
+}
+
+// Delete removes a kernel module.
+func Delete(name string, flags uintptr) error {
+	return unix.DeleteModule(name, int(flags))
+}
+
+// initFile is the default InitFunc: it loads a module file with FileInit.
+func initFile(filename, params string, flags int) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return FileInit(f, params, uintptr(flags))
+}
+
+// deleteModule is the default RemoveFunc.
+func deleteModule(name string, flags int) error {
+	return Delete(name, uintptr(flags))
+}
@@ -0,0 +1,67 @@
+package kmod
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// procModules lists the loaded modules, changed by tests.
+var procModules = "/proc/modules"
+
+// LoadedModule is a module in the kernel, as /proc/modules shows it.
+type LoadedModule struct {
+	Name string
+
+	// Size is the memory the module takes, in bytes.
+	Size uint64
+
+	// Refs is how many times the module is used.
+	Refs int
+
+	// UsedBy are the modules using this one.
+	UsedBy []string
+
+	// State is Live, Loading or Unloading.
+	State string
+}
+
+// LoadedModules lists the modules in the kernel.
+func LoadedModules() ([]LoadedModule, error) {
+	f, err := os.Open(procModules)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadLoadedModules(f)
+}
+
+// ReadLoadedModules reads the modules in the format of /proc/modules.
+//
+//	name | memory size | reference count | references | state: <Live|Loading|Unloading>
+//	macvlan 28672 1 macvtap, Live 0x0000000000000000
+func ReadLoadedModules(r io.Reader) ([]LoadedModule, error) {
+	var mods []LoadedModule
+	var errs []string
+	err := scanLines(r, func(fields []string) {
+		if len(fields) < 5 {
+			errs = append(errs, strings.Join(fields, " "))
+			return
+		}
+		m := LoadedModule{Name: fields[0], State: fields[4]}
+		m.Size, _ = strconv.ParseUint(fields[1], 10, 64)
+		m.Refs, _ = strconv.Atoi(fields[2])
+		for _, u := range strings.Split(fields[3], ",") {
+			if u != "" && u != "-" {
+				m.UsedBy = append(m.UsedBy, u)
+			}
+		}
+		mods = append(mods, m)
+	})
+	if err == nil && len(errs) > 0 {
+		err = fmt.Errorf("bad lines in the module list: %q", errs)
+	}
+	return mods, err
+}
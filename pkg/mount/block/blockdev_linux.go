@@ -111,14 +111,7 @@ func (b *BlockDev) GPTTable() (*gpt.Table, error) {
 		blkSize = 512
 	}
 
-	if _, err := fd.Seek(int64(blkSize), io.SeekStart); err != nil {
-		return nil, err
-	}
-	table, err := gpt.ReadTable(fd, uint64(blkSize))
-	if err != nil {
-		return nil, err
-	}
-	return &table, nil
+	return readGPT(fd, blkSize)
 }
 
 // PhysicalBlockSize returns the physical block size.
@@ -226,163 +219,103 @@ func (b *BlockDev) PCIInfo() (*pci.PCI, error) {
 }
 
 func getFSUUID(devpath string) (string, error) {
-	file, err := os.Open(devpath)
+	sb, err := probeDevice(devpath)
 	if err != nil {
 		return "", err
 	}
-	defer file.Close()
-
-	fsuuid, err := tryFAT32(file)
-	if err == nil {
-		return fsuuid, nil
-	}
-	fsuuid, err = tryFAT16(file)
-	if err == nil {
-		return fsuuid, nil
+	if sb.UUID == "" {
+		return "", fmt.Errorf("%s has no UUID", sb.Type)
 	}
-	fsuuid, err = tryEXT4(file)
-	if err == nil {
-		return fsuuid, nil
-	}
-	fsuuid, err = tryXFS(file)
-	if err == nil {
-		return fsuuid, nil
-	}
-	return "", fmt.Errorf("unknown UUID (not vfat, ext4, nor xfs)")
+	return strings.ToLower(sb.UUID), nil
 }
 
-// See https://www.nongnu.org/ext2-doc/ext2.html#DISK-ORGANISATION.
-const (
-	// Offset of superblock in partition.
-	ext2SprblkOff = 1024
-
-	// Offset of magic number in suberblock.
-	ext2SprblkMagicOff  = 56
-	ext2SprblkMagicSize = 2
-
-	ext2SprblkMagic = 0xEF53
-
-	// Offset of UUID in superblock.
-	ext2SprblkUUIDOff  = 104
-	ext2SprblkUUIDSize = 16
-)
-
-func tryEXT4(file io.ReaderAt) (string, error) {
-	var off int64
-
-	// Read magic number.
-	b := make([]byte, ext2SprblkMagicSize)
-	off = ext2SprblkOff + ext2SprblkMagicOff
-	if _, err := file.ReadAt(b, off); err != nil {
-		return "", err
-	}
-	magic := binary.LittleEndian.Uint16(b[:2])
-	if magic != ext2SprblkMagic {
-		return "", fmt.Errorf("ext4 magic not found")
-	}
-
-	// Filesystem UUID.
-	b = make([]byte, ext2SprblkUUIDSize)
-	off = ext2SprblkOff + ext2SprblkUUIDOff
-	if _, err := file.ReadAt(b, off); err != nil {
-		return "", err
+// probeDevice reads the superblock of devpath.
+func probeDevice(devpath string) (*Superblock, error) {
+	file, err := os.Open(devpath)
+	if err != nil {
+		return nil, err
 	}
-
-	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+	defer file.Close()
+	return ProbeFS(file)
 }
 
-// See https://de.wikipedia.org/wiki/File_Allocation_Table#Aufbau.
-const (
-	fat12Magic = "FAT12   "
-	fat16Magic = "FAT16   "
-
-	// Offset of magic number.
-	fat16MagicOff  = 0x36
-	fat16MagicSize = 8
+// Superblock reads the superblock of the block device.
+func (b *BlockDev) Superblock() (*Superblock, error) {
+	return probeDevice(b.DevicePath())
+}
 
-	// Offset of filesystem ID / serial number. Treated as short filesystem UUID.
-	fat16IDOff  = 0x27
-	fat16IDSize = 4
-)
+// PartInfo is what the partition table of its disk says about a partition.
+type PartInfo struct {
+	Number int
+	// UUID and Label are PARTUUID and PARTLABEL. An MBR has no labels, and
+	// its UUIDs are the disk signature with the partition number.
+	UUID  string
+	Label string
+	// Type is the type GUID, or the MBR type byte in hex.
+	Type string
+}
 
-func tryFAT16(file io.ReaderAt) (string, error) {
-	// Read magic number.
-	b := make([]byte, fat16MagicSize)
-	if _, err := file.ReadAt(b, fat16MagicOff); err != nil {
-		return "", err
+// PartInfo finds the disk the partition is on and reads its entry from the
+// disk's partition table.
+func (b *BlockDev) PartInfo() (*PartInfo, error) {
+	sys := filepath.Join("/sys/class/block", b.Name)
+	n, err := os.ReadFile(filepath.Join(sys, "partition"))
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a partition: %w", b.Name, err)
 	}
-	magic := string(b)
-	if magic != fat16Magic && magic != fat12Magic {
-		return "", fmt.Errorf("fat16 magic not found")
+	number, err := strconv.Atoi(strings.TrimSpace(string(n)))
+	if err != nil || number < 1 {
+		return nil, fmt.Errorf("%s: bad partition number %q", b.Name, n)
 	}
-
-	// Filesystem UUID.
-	b = make([]byte, fat16IDSize)
-	if _, err := file.ReadAt(b, fat16IDOff); err != nil {
-		return "", err
+	p, err := filepath.EvalSymlinks(sys)
+	if err != nil {
+		return nil, err
 	}
+	disk := &BlockDev{Name: filepath.Base(filepath.Dir(p))}
 
-	return fmt.Sprintf("%02x%02x-%02x%02x", b[3], b[2], b[1], b[0]), nil
-}
-
-// See https://de.wikipedia.org/wiki/File_Allocation_Table#Aufbau.
-const (
-	fat32Magic = "FAT32   "
-
-	// Offset of magic number.
-	fat32MagicOff  = 0x52
-	fat32MagicSize = 8
-
-	// Offset of filesystem ID / serial number. Treated as short filesystem UUID.
-	fat32IDOff  = 67
-	fat32IDSize = 4
-)
-
-func tryFAT32(file io.ReaderAt) (string, error) {
-	// Read magic number.
-	b := make([]byte, fat32MagicSize)
-	if _, err := file.ReadAt(b, fat32MagicOff); err != nil {
-		return "", err
+	if table, err := disk.GPTTable(); err == nil {
+		return gptEntry(table, number)
 	}
-	magic := string(b)
-	if magic != fat32Magic {
-		return "", fmt.Errorf("fat32 magic not found")
+	f, err := os.Open(disk.DevicePath())
+	if err != nil {
+		return nil, err
 	}
+	defer f.Close()
+	return mbrEntry(f, number)
+}
 
-	// Filesystem UUID.
-	b = make([]byte, fat32IDSize)
-	if _, err := file.ReadAt(b, fat32IDOff); err != nil {
-		return "", err
+// gptEntry is partition number of a GPT.
+func gptEntry(table *gpt.Table, number int) (*PartInfo, error) {
+	if number > len(table.Partitions) || table.Partitions[number-1].IsEmpty() {
+		return nil, fmt.Errorf("no partition %d in GPT", number)
 	}
-
-	return fmt.Sprintf("%02x%02x-%02x%02x", b[3], b[2], b[1], b[0]), nil
+	part := table.Partitions[number-1]
+	return &PartInfo{
+		Number: number,
+		UUID:   strings.ToLower(part.Id.String()),
+		Label:  part.Name(),
+		Type:   strings.ToLower(part.Type.String()),
+	}, nil
 }
 
-const (
-	xfsMagic     = "XFSB"
-	xfsMagicSize = 4
-	xfsUUIDOff   = 32
-	xfsUUIDSize  = 16
-)
-
-func tryXFS(file io.ReaderAt) (string, error) {
-	// Read magic number.
-	b := make([]byte, xfsMagicSize)
-	if _, err := file.ReadAt(b, 0); err != nil {
-		return "", err
+// mbrEntry is partition number of the MBR of the disk r holds.
+func mbrEntry(r io.ReaderAt, number int) (*PartInfo, error) {
+	mbr := make([]byte, 512)
+	if _, err := r.ReadAt(mbr, 0); err != nil {
+		return nil, err
 	}
-	magic := string(b)
-	if magic != xfsMagic {
-		return "", fmt.Errorf("xfs magic not found")
+	if mbr[510] != 0x55 || mbr[511] != 0xaa {
+		return nil, fmt.Errorf("no partition table")
 	}
-
-	// Filesystem UUID.
-	b = make([]byte, xfsUUIDSize)
-	if _, err := file.ReadAt(b, xfsUUIDOff); err != nil {
-		return "", err
+	info := &PartInfo{
+		Number: number,
+		UUID:   fmt.Sprintf("%08x-%02x", binary.LittleEndian.Uint32(mbr[440:]), number),
 	}
-
-	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+	// logical partitions are in extended boot records, without a type here
+	if number <= 4 {
+		info.Type = fmt.Sprintf("0x%x", mbr[446+16*(number-1)+4])
+	}
+	return info, nil
 }
 
 // BlockDevices is a list of block devices.
@@ -454,7 +387,19 @@ func (b BlockDevices) FilterNames(names ...string) BlockDevices {
 func (b BlockDevices) FilterFSUUID(fsuuid string) BlockDevices {
 	partitions := make(BlockDevices, 0)
 	for _, device := range b {
-		if device.FsUUID == fsuuid {
+		if strings.EqualFold(device.FsUUID, fsuuid) {
+			partitions = append(partitions, device)
+		}
+	}
+	return partitions
+}
+
+// FilterFSLabel returns a list of BlockDev objects whose underlying block
+// device has a filesystem with the given label.
+func (b BlockDevices) FilterFSLabel(label string) BlockDevices {
+	partitions := make(BlockDevices, 0)
+	for _, device := range b {
+		if sb, err := device.Superblock(); err == nil && sb.Label == label {
 			partitions = append(partitions, device)
 		}
 	}
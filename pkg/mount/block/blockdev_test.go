@@ -240,6 +240,11 @@ func TestBlockDevicesFilterFSUUID(t *testing.T) {
 	if !reflect.DeepEqual(devs, want) {
 		t.Fatalf("Filtered block devices: \n\t%v \nwant: \n\t%v", devs, want)
 	}
+
+	// blkid shows FAT serial numbers in upper case
+	if upper := devs.FilterFSUUID("ABCD-1234"); !reflect.DeepEqual(upper, want) {
+		t.Fatalf("Filtered block devices: \n\t%v \nwant: \n\t%v", upper, want)
+	}
 }
 
 func TestGetMountpointByDevice(t *testing.T) {
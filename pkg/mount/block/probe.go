@@ -0,0 +1,455 @@
+package block
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/rekby/gpt"
+)
+
+// ErrUnknownFS is returned when no superblock blkid knows is found.
+var ErrUnknownFS = errors.New("unknown file system")
+
+// Superblock is what the superblock of a device tells about its content,
+// named like the blkid tags.
+type Superblock struct {
+	// Type is the file system type, or swap or crypto_LUKS.
+	Type string
+	// SecType is a type the file system may be mounted as too.
+	SecType   string
+	UUID      string
+	Label     string
+	Version   string
+	BlockSize uint32
+	// Usage is filesystem, crypto or other.
+	Usage string
+}
+
+// PartTable is the partition table at the start of a disk.
+type PartTable struct {
+	// Type is gpt or dos.
+	Type string
+	UUID string
+}
+
+// prober recognizes one kind of superblock
+type prober func(r io.ReaderAt) (*Superblock, error)
+
+// probers are tried in order. Those with a magic far from the start come
+// first, since a file system may leave an old boot sector in place.
+var probers = []prober{
+	probeBtrfs,
+	probeISO9660,
+	probeSwap,
+	probeEXT,
+	probeF2FS,
+	probeXFS,
+	probeSquashfs,
+	probeLUKS,
+	probeFAT,
+}
+
+// ProbeFS reads the superblock of what r holds.
+func ProbeFS(r io.ReaderAt) (*Superblock, error) {
+	for _, p := range probers {
+		if sb, err := p(r); err == nil {
+			return sb, nil
+		}
+	}
+	return nil, ErrUnknownFS
+}
+
+// ProbePartTable reads the partition table of the disk r holds.
+func ProbePartTable(r io.ReaderAt, sectorSize int) (*PartTable, error) {
+	if sectorSize == 0 {
+		sectorSize = 512
+	}
+	mbr := make([]byte, 512)
+	if _, err := r.ReadAt(mbr, 0); err != nil {
+		return nil, err
+	}
+	if mbr[510] != 0x55 || mbr[511] != 0xaa {
+		return nil, fmt.Errorf("no partition table")
+	}
+	// a protective MBR has one partition of type 0xee over the disk
+	if mbr[446+4] == 0xee {
+		hdr := make([]byte, 72)
+		if _, err := r.ReadAt(hdr, int64(sectorSize)); err == nil && string(hdr[:8]) == "EFI PART" {
+			return &PartTable{Type: "gpt", UUID: guid(hdr[56:72])}, nil
+		}
+	}
+	return &PartTable{Type: "dos", UUID: fmt.Sprintf("%08x", binary.LittleEndian.Uint32(mbr[440:]))}, nil
+}
+
+// readGPT reads the GPT of the disk r holds.
+func readGPT(r io.ReaderAt, sectorSize int) (*gpt.Table, error) {
+	s := io.NewSectionReader(r, 0, 1<<62)
+	if _, err := s.Seek(int64(sectorSize), io.SeekStart); err != nil {
+		return nil, err
+	}
+	table, err := gpt.ReadTable(s, uint64(sectorSize))
+	if err != nil {
+		return nil, err
+	}
+	return &table, nil
+}
+
+// guid formats a GUID, whose first three fields are little endian
+func guid(b []byte) string {
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+		binary.LittleEndian.Uint32(b[0:4]), binary.LittleEndian.Uint16(b[4:6]),
+		binary.LittleEndian.Uint16(b[6:8]), b[8:10], b[10:16])
+}
+
+// uuid formats a UUID stored as bytes in order
+func uuid(b []byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// cstring is b up to the first NUL
+func cstring(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// readAt reads n bytes at off
+func readAt(r io.ReaderAt, off int64, n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := r.ReadAt(b, off); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// See https://www.nongnu.org/ext2-doc/ext2.html#DISK-ORGANISATION.
+const (
+	// Offset of superblock in partition.
+	ext2SprblkOff = 1024
+
+	// Offset of magic number in suberblock.
+	ext2SprblkMagicOff = 56
+
+	ext2SprblkMagic = 0xEF53
+
+	// Offset of UUID in superblock.
+	ext2SprblkUUIDOff  = 104
+	ext2SprblkUUIDSize = 16
+
+	// Offset of volume name in superblock.
+	ext2SprblkLabelOff  = 120
+	ext2SprblkLabelSize = 16
+
+	ext3FeatureCompatHasJournal   = 0x0004
+	ext3FeatureIncompatJournalDev = 0x0008
+	ext3FeatureIncompatSupported  = 0x0002 | 0x0004 | 0x0010
+	ext3FeatureROCompatSupported  = 0x0001 | 0x0002 | 0x0004
+	ext2SprblkFeatureCompatOff    = 92
+	ext2SprblkFeatureIncompatOff  = 96
+	ext2SprblkFeatureROCompatOff  = 100
+	ext2SprblkLogBlockSizeOff     = 24
+	ext2SprblkRevLevelOff         = 76
+	ext2SprblkMinorRevLevelOff    = 62
+)
+
+// probeEXT tells ext2, ext3 and ext4 apart like blkid: a journal makes
+// ext3, and features ext3 lacks make ext4.
+func probeEXT(r io.ReaderAt) (*Superblock, error) {
+	sb, err := readAt(r, ext2SprblkOff, 264)
+	if err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint16(sb[ext2SprblkMagicOff:]) != ext2SprblkMagic {
+		return nil, fmt.Errorf("ext magic not found")
+	}
+	compat := binary.LittleEndian.Uint32(sb[ext2SprblkFeatureCompatOff:])
+	incompat := binary.LittleEndian.Uint32(sb[ext2SprblkFeatureIncompatOff:])
+	roCompat := binary.LittleEndian.Uint32(sb[ext2SprblkFeatureROCompatOff:])
+	s := &Superblock{
+		UUID:      uuid(sb[ext2SprblkUUIDOff : ext2SprblkUUIDOff+ext2SprblkUUIDSize]),
+		Label:     cstring(sb[ext2SprblkLabelOff : ext2SprblkLabelOff+ext2SprblkLabelSize]),
+		BlockSize: 1024 << binary.LittleEndian.Uint32(sb[ext2SprblkLogBlockSizeOff:]),
+		Version: fmt.Sprintf("%d.%d", binary.LittleEndian.Uint32(sb[ext2SprblkRevLevelOff:]),
+			binary.LittleEndian.Uint16(sb[ext2SprblkMinorRevLevelOff:])),
+		Usage: "filesystem",
+	}
+	switch {
+	case incompat&ext3FeatureIncompatJournalDev != 0:
+		s.Type, s.Usage = "jbd", "other"
+	case incompat&^ext3FeatureIncompatSupported != 0, roCompat&^ext3FeatureROCompatSupported != 0:
+		s.Type = "ext4"
+	case compat&ext3FeatureCompatHasJournal != 0:
+		s.Type = "ext3"
+	default:
+		s.Type = "ext2"
+	}
+	return s, nil
+}
+
+// See https://de.wikipedia.org/wiki/File_Allocation_Table#Aufbau.
+const (
+	fat12Magic = "FAT12   "
+	fat16Magic = "FAT16   "
+	fat32Magic = "FAT32   "
+
+	// Offsets of the magic numbers.
+	fat16MagicOff = 0x36
+	fat32MagicOff = 0x52
+
+	// Offsets of the filesystem ID / serial number, and of the label
+	// right after it.
+	fat16IDOff = 0x27
+	fat32IDOff = 0x43
+)
+
+func probeFAT(r io.ReaderAt) (*Superblock, error) {
+	bs, err := readAt(r, 0, 512)
+	if err != nil {
+		return nil, err
+	}
+	if bs[510] != 0x55 || bs[511] != 0xaa {
+		return nil, fmt.Errorf("fat magic not found")
+	}
+	var idOff int
+	s := &Superblock{
+		Type:      "vfat",
+		BlockSize: uint32(binary.LittleEndian.Uint16(bs[11:])),
+		Usage:     "filesystem",
+	}
+	switch {
+	case string(bs[fat32MagicOff:fat32MagicOff+8]) == fat32Magic:
+		idOff, s.Version = fat32IDOff, "FAT32"
+	case string(bs[fat16MagicOff:fat16MagicOff+8]) == fat16Magic:
+		idOff, s.Version, s.SecType = fat16IDOff, "FAT16", "msdos"
+	case string(bs[fat16MagicOff:fat16MagicOff+8]) == fat12Magic:
+		idOff, s.Version, s.SecType = fat16IDOff, "FAT12", "msdos"
+	default:
+		return nil, fmt.Errorf("fat magic not found")
+	}
+	id := bs[idOff : idOff+4]
+	s.UUID = fmt.Sprintf("%02X%02X-%02X%02X", id[3], id[2], id[1], id[0])
+	if l := strings.TrimRight(string(bs[idOff+4:idOff+15]), " "); l != "NO NAME" {
+		s.Label = l
+	}
+	return s, nil
+}
+
+const (
+	xfsMagic     = "XFSB"
+	xfsUUIDOff   = 32
+	xfsLabelOff  = 108
+	xfsLabelSize = 12
+)
+
+func probeXFS(r io.ReaderAt) (*Superblock, error) {
+	sb, err := readAt(r, 0, xfsLabelOff+xfsLabelSize)
+	if err != nil {
+		return nil, err
+	}
+	if string(sb[:4]) != xfsMagic {
+		return nil, fmt.Errorf("xfs magic not found")
+	}
+	return &Superblock{
+		Type:      "xfs",
+		UUID:      uuid(sb[xfsUUIDOff : xfsUUIDOff+16]),
+		Label:     cstring(sb[xfsLabelOff : xfsLabelOff+xfsLabelSize]),
+		BlockSize: binary.BigEndian.Uint32(sb[4:]),
+		Usage:     "filesystem",
+	}, nil
+}
+
+// See https://btrfs.readthedocs.io/en/latest/dev/On-disk-format.html.
+const (
+	btrfsSprblkOff = 0x10000
+	btrfsMagic     = "_BHRfS_M"
+	btrfsMagicOff  = 0x40
+	btrfsUUIDOff   = 0x20
+	btrfsSectorOff = 0x90
+	btrfsLabelOff  = 0x12b
+	btrfsLabelSize = 256
+)
+
+func probeBtrfs(r io.ReaderAt) (*Superblock, error) {
+	sb, err := readAt(r, btrfsSprblkOff, btrfsLabelOff+btrfsLabelSize)
+	if err != nil {
+		return nil, err
+	}
+	if string(sb[btrfsMagicOff:btrfsMagicOff+8]) != btrfsMagic {
+		return nil, fmt.Errorf("btrfs magic not found")
+	}
+	return &Superblock{
+		Type:      "btrfs",
+		UUID:      uuid(sb[btrfsUUIDOff : btrfsUUIDOff+16]),
+		Label:     cstring(sb[btrfsLabelOff : btrfsLabelOff+btrfsLabelSize]),
+		BlockSize: binary.LittleEndian.Uint32(sb[btrfsSectorOff:]),
+		Usage:     "filesystem",
+	}, nil
+}
+
+// squashfs has neither a UUID nor a label.
+const squashfsMagic = "hsqs"
+
+func probeSquashfs(r io.ReaderAt) (*Superblock, error) {
+	sb, err := readAt(r, 0, 32)
+	if err != nil {
+		return nil, err
+	}
+	if string(sb[:4]) != squashfsMagic {
+		return nil, fmt.Errorf("squashfs magic not found")
+	}
+	return &Superblock{
+		Type:      "squashfs",
+		Version:   fmt.Sprintf("%d.%d", binary.LittleEndian.Uint16(sb[28:]), binary.LittleEndian.Uint16(sb[30:])),
+		BlockSize: binary.LittleEndian.Uint32(sb[12:]),
+		Usage:     "filesystem",
+	}, nil
+}
+
+// The primary volume descriptor of ISO 9660 is at sector 16. There is no
+// UUID; like blkid, the time the volume was last modified, or else made,
+// stands in for it.
+const (
+	isoPVDOff      = 0x8000
+	isoMagic       = "CD001"
+	isoLabelOff    = 40
+	isoLabelSize   = 32
+	isoBlockOff    = 128
+	isoCreatedOff  = 813
+	isoModifiedOff = 830
+)
+
+func probeISO9660(r io.ReaderAt) (*Superblock, error) {
+	pvd, err := readAt(r, isoPVDOff, 2048)
+	if err != nil {
+		return nil, err
+	}
+	if pvd[0] != 1 || string(pvd[1:6]) != isoMagic {
+		return nil, fmt.Errorf("iso9660 magic not found")
+	}
+	s := &Superblock{
+		Type:      "iso9660",
+		Label:     strings.TrimRight(string(pvd[isoLabelOff:isoLabelOff+isoLabelSize]), " "),
+		BlockSize: uint32(binary.LittleEndian.Uint16(pvd[isoBlockOff:])),
+		Usage:     "filesystem",
+	}
+	for _, off := range []int{isoModifiedOff, isoCreatedOff} {
+		// the date is YYYYMMDDHHMMSScc as digits, or zeros when unset
+		d := pvd[off : off+16]
+		if strings.Trim(string(d), "0\x00") == "" {
+			continue
+		}
+		s.UUID = fmt.Sprintf("%s-%s-%s-%s-%s-%s-%s", d[0:4], d[4:6], d[6:8], d[8:10], d[10:12], d[12:14], d[14:16])
+		break
+	}
+	return s, nil
+}
+
+// The swap header fills the first page, whose size varies, and ends in
+// the magic.
+const (
+	swapVersionOff    = 1024
+	swapUUIDOff       = 1036
+	swapLabelOff      = 1052
+	swapLabelSize     = 16
+	swapMagicSize     = 10
+	swapMagic         = "SWAPSPACE2"
+	swapMagicVersion0 = "SWAP-SPACE"
+)
+
+func probeSwap(r io.ReaderAt) (*Superblock, error) {
+	for _, page := range []int64{4096, 8192, 16384, 65536} {
+		magic, err := readAt(r, page-swapMagicSize, swapMagicSize)
+		if err != nil {
+			break
+		}
+		switch string(magic) {
+		case swapMagicVersion0:
+			return &Superblock{Type: "swap", Version: "0", Usage: "other"}, nil
+		case swapMagic:
+			hdr, err := readAt(r, swapVersionOff, swapLabelOff+swapLabelSize-swapVersionOff)
+			if err != nil {
+				return nil, err
+			}
+			return &Superblock{
+				Type:    "swap",
+				UUID:    uuid(hdr[swapUUIDOff-swapVersionOff:]),
+				Label:   cstring(hdr[swapLabelOff-swapVersionOff:]),
+				Version: fmt.Sprint(binary.LittleEndian.Uint32(hdr)),
+				Usage:   "other",
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("swap magic not found")
+}
+
+// LUKS1 and LUKS2 share the start of the header. Only LUKS2 has a label.
+const (
+	luksMagic      = "LUKS\xba\xbe"
+	luksUUIDOff    = 168
+	luksUUIDSize   = 40
+	luks2LabelOff  = 24
+	luks2LabelSize = 48
+)
+
+func probeLUKS(r io.ReaderAt) (*Superblock, error) {
+	hdr, err := readAt(r, 0, luksUUIDOff+luksUUIDSize)
+	if err != nil {
+		return nil, err
+	}
+	if string(hdr[:6]) != luksMagic {
+		return nil, fmt.Errorf("luks magic not found")
+	}
+	version := binary.BigEndian.Uint16(hdr[6:])
+	s := &Superblock{
+		Type:    "crypto_LUKS",
+		UUID:    cstring(hdr[luksUUIDOff : luksUUIDOff+luksUUIDSize]),
+		Version: fmt.Sprint(version),
+		Usage:   "crypto",
+	}
+	if version == 2 {
+		s.Label = cstring(hdr[luks2LabelOff : luks2LabelOff+luks2LabelSize])
+	}
+	return s, nil
+}
+
+// f2fs keeps its label as UTF-16.
+const (
+	f2fsSprblkOff = 1024
+	f2fsMagic     = 0xF2F52010
+	f2fsUUIDOff   = 108
+	f2fsLabelOff  = 124
+	f2fsLabelSize = 512
+	f2fsLogBlkOff = 16
+)
+
+func probeF2FS(r io.ReaderAt) (*Superblock, error) {
+	sb, err := readAt(r, f2fsSprblkOff, f2fsLabelOff+2*f2fsLabelSize)
+	if err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(sb) != f2fsMagic {
+		return nil, fmt.Errorf("f2fs magic not found")
+	}
+	var label []uint16
+	for i := 0; i < f2fsLabelSize; i++ {
+		c := binary.LittleEndian.Uint16(sb[f2fsLabelOff+2*i:])
+		if c == 0 {
+			break
+		}
+		label = append(label, c)
+	}
+	return &Superblock{
+		Type:      "f2fs",
+		UUID:      uuid(sb[f2fsUUIDOff : f2fsUUIDOff+16]),
+		Label:     string(utf16.Decode(label)),
+		Version:   fmt.Sprintf("%d.%d", binary.LittleEndian.Uint16(sb[4:]), binary.LittleEndian.Uint16(sb[6:])),
+		BlockSize: 1 << binary.LittleEndian.Uint32(sb[f2fsLogBlkOff:]),
+		Usage:     "filesystem",
+	}, nil
+}
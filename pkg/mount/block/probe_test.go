@@ -0,0 +1,325 @@
+package block
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+	"unicode/utf16"
+)
+
+// image is a file system image built in memory
+type image []byte
+
+func newImage(size int) image {
+	return make(image, size)
+}
+
+func (i image) put(off int, data any) image {
+	var b bytes.Buffer
+	switch d := data.(type) {
+	case string:
+		b.WriteString(d)
+	case []byte:
+		b.Write(d)
+	default:
+		binary.Write(&b, binary.LittleEndian, d)
+	}
+	copy(i[off:], b.Bytes())
+	return i
+}
+
+func (i image) putBE(off int, data any) image {
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, data)
+	copy(i[off:], b.Bytes())
+	return i
+}
+
+var testUUID = []byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}
+
+const testUUIDString = "12345678-9abc-def0-0123-456789abcdef"
+
+func ext(compat, incompat, roCompat uint32) image {
+	return newImage(4096).
+		put(1024+24, uint32(2)).
+		put(1024+56, uint16(0xef53)).
+		put(1024+62, uint16(0)).
+		put(1024+76, uint32(1)).
+		put(1024+92, compat).
+		put(1024+96, incompat).
+		put(1024+100, roCompat).
+		put(1024+104, testUUID).
+		put(1024+120, "root")
+}
+
+func utf16le(s string) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, utf16.Encode([]rune(s)))
+	return b.Bytes()
+}
+
+func TestProbeFS(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		img  image
+		want *Superblock
+	}{
+		{
+			name: "ext2",
+			img:  ext(0, 0x2, 0x3),
+			want: &Superblock{Type: "ext2", UUID: testUUIDString, Label: "root", Version: "1.0", BlockSize: 4096, Usage: "filesystem"},
+		},
+		{
+			name: "ext3",
+			img:  ext(0x4, 0x2, 0x3),
+			want: &Superblock{Type: "ext3", UUID: testUUIDString, Label: "root", Version: "1.0", BlockSize: 4096, Usage: "filesystem"},
+		},
+		{
+			name: "ext4",
+			img:  ext(0x4, 0x2|0x40|0x200, 0x3),
+			want: &Superblock{Type: "ext4", UUID: testUUIDString, Label: "root", Version: "1.0", BlockSize: 4096, Usage: "filesystem"},
+		},
+		{
+			name: "ext4 without journal",
+			img:  ext(0, 0x2, 0x3|0x400),
+			want: &Superblock{Type: "ext4", UUID: testUUIDString, Label: "root", Version: "1.0", BlockSize: 4096, Usage: "filesystem"},
+		},
+		{
+			name: "fat32",
+			img: newImage(512).
+				put(11, uint16(512)).
+				put(0x43, []byte{0x44, 0x51, 0xe5, 0xac}).
+				put(0x47, "EFI        ").
+				put(0x52, "FAT32   ").
+				put(510, []byte{0x55, 0xaa}),
+			want: &Superblock{Type: "vfat", UUID: "ACE5-5144", Label: "EFI", Version: "FAT32", BlockSize: 512, Usage: "filesystem"},
+		},
+		{
+			name: "fat16 without label",
+			img: newImage(512).
+				put(11, uint16(2048)).
+				put(0x27, []byte{0x44, 0x51, 0xe5, 0xac}).
+				put(0x2b, "NO NAME    ").
+				put(0x36, "FAT16   ").
+				put(510, []byte{0x55, 0xaa}),
+			want: &Superblock{Type: "vfat", SecType: "msdos", UUID: "ACE5-5144", Version: "FAT16", BlockSize: 2048, Usage: "filesystem"},
+		},
+		{
+			name: "xfs",
+			img: newImage(512).
+				put(0, "XFSB").
+				putBE(4, uint32(4096)).
+				put(32, testUUID).
+				put(108, "data"),
+			want: &Superblock{Type: "xfs", UUID: testUUIDString, Label: "data", BlockSize: 4096, Usage: "filesystem"},
+		},
+		{
+			name: "btrfs",
+			img: newImage(0x11000).
+				put(0x10000+0x20, testUUID).
+				put(0x10000+0x40, "_BHRfS_M").
+				put(0x10000+0x90, uint32(4096)).
+				put(0x10000+0x12b, "pool"),
+			want: &Superblock{Type: "btrfs", UUID: testUUIDString, Label: "pool", BlockSize: 4096, Usage: "filesystem"},
+		},
+		{
+			name: "squashfs",
+			img: newImage(512).
+				put(0, "hsqs").
+				put(12, uint32(131072)).
+				put(28, []uint16{4, 0}),
+			want: &Superblock{Type: "squashfs", Version: "4.0", BlockSize: 131072, Usage: "filesystem"},
+		},
+		{
+			name: "iso9660",
+			img: newImage(0x8800).
+				put(0x8000, []byte{1}).
+				put(0x8001, "CD001").
+				put(0x8000+40, "UBUNTU                          ").
+				put(0x8000+128, uint16(2048)).
+				put(0x8000+813, "2024013012000000").
+				put(0x8000+830, "0000000000000000"),
+			want: &Superblock{Type: "iso9660", UUID: "2024-01-30-12-00-00-00", Label: "UBUNTU", BlockSize: 2048, Usage: "filesystem"},
+		},
+		{
+			name: "swap",
+			img: newImage(4096).
+				put(1024, uint32(1)).
+				put(1036, testUUID).
+				put(1052, "swp").
+				put(4086, "SWAPSPACE2"),
+			want: &Superblock{Type: "swap", UUID: testUUIDString, Label: "swp", Version: "1", Usage: "other"},
+		},
+		{
+			name: "swap with 64k pages",
+			img: newImage(65536).
+				put(1024, uint32(1)).
+				put(1036, testUUID).
+				put(65526, "SWAPSPACE2"),
+			want: &Superblock{Type: "swap", UUID: testUUIDString, Version: "1", Usage: "other"},
+		},
+		{
+			name: "luks1",
+			img: newImage(1024).
+				put(0, "LUKS\xba\xbe").
+				putBE(6, uint16(1)).
+				put(168, testUUIDString),
+			want: &Superblock{Type: "crypto_LUKS", UUID: testUUIDString, Version: "1", Usage: "crypto"},
+		},
+		{
+			name: "luks2",
+			img: newImage(1024).
+				put(0, "LUKS\xba\xbe").
+				putBE(6, uint16(2)).
+				put(24, "vault").
+				put(168, testUUIDString),
+			want: &Superblock{Type: "crypto_LUKS", UUID: testUUIDString, Label: "vault", Version: "2", Usage: "crypto"},
+		},
+		{
+			name: "f2fs",
+			img: newImage(4096).
+				put(1024, uint32(0xf2f52010)).
+				put(1024+4, []uint16{1, 16}).
+				put(1024+16, uint32(12)).
+				put(1024+108, testUUID).
+				put(1024+124, utf16le("données")),
+			want: &Superblock{Type: "f2fs", UUID: testUUIDString, Label: "données", Version: "1.16", BlockSize: 4096, Usage: "filesystem"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ProbeFS(bytes.NewReader(tt.img))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ProbeFS() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbeFSImage(t *testing.T) {
+	f, err := os.Open("../testdata/1MB.ext4_vfat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for _, tt := range []struct {
+		name string
+		off  int64
+		want *Superblock
+	}{
+		{
+			name: "ext4",
+			off:  512,
+			want: &Superblock{Type: "ext4", UUID: "2183ead8-a510-4b3d-9777-19c7090f66d9", Version: "1.0", BlockSize: 1024, Usage: "filesystem"},
+		},
+		{
+			name: "vfat",
+			off:  1025 * 512,
+			want: &Superblock{Type: "vfat", SecType: "msdos", UUID: "ACE5-5144", Version: "FAT12", BlockSize: 512, Usage: "filesystem"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ProbeFS(io.NewSectionReader(f, tt.off, 1<<20-tt.off))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ProbeFS() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	for _, name := range []string{"../testdata/12Kzeros", "../testdata/emptyFile", "../testdata/gptdisk"} {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sb, err := ProbeFS(f); !errors.Is(err, ErrUnknownFS) {
+			t.Errorf("ProbeFS(%s) = %+v, %v, want %v", name, sb, err, ErrUnknownFS)
+		}
+		f.Close()
+	}
+}
+
+func TestProbePartTable(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		want *PartTable
+	}{
+		{name: "../testdata/1MB.ext4_vfat", want: &PartTable{Type: "dos", UUID: "675c66d6"}},
+		{name: "../testdata/12Kzeros", want: &PartTable{Type: "dos", UUID: "65bf3dbf"}},
+		{name: "../testdata/gptdisk", want: &PartTable{Type: "gpt", UUID: "569f7b95-0f2e-45dc-97d3-a9e4add43b64"}},
+		{name: "testdata/gptdisk_label", want: &PartTable{Type: "gpt", UUID: "ec4c7a93-6517-e34d-a1ea-6f09873d5e06"}},
+		{name: "../testdata/emptyFile"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := os.Open(tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			got, err := ProbePartTable(f, 512)
+			if tt.want == nil {
+				if err == nil {
+					t.Errorf("ProbePartTable() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ProbePartTable() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPartEntry(t *testing.T) {
+	f, err := os.Open("testdata/gptdisk_label")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	table, err := readGPT(f, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := gptEntry(table, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &PartInfo{
+		Number: 2,
+		UUID:   "53adc1b3-ce89-4b44-9460-8a1d7667b2bf",
+		Label:  "TEST_LABEL",
+		Type:   "0fc63daf-8483-4772-8e79-3d69d8477de4",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("gptEntry(2) = %+v, want %+v", got, want)
+	}
+	if got, err := gptEntry(table, 3); err == nil {
+		t.Errorf("gptEntry(3) = %+v, want an error", got)
+	}
+
+	mbr, err := os.Open("../testdata/1MB.ext4_vfat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mbr.Close()
+	got, err = mbrEntry(mbr, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = &PartInfo{Number: 2, UUID: "675c66d6-02", Type: "0x83"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mbrEntry(2) = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,204 @@
+package gpt
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+// Types are the partition type GUIDs known by name.
+var Types = map[string]GUID{
+	"linux": MustParseGUID("0fc63daf-8483-4772-8e79-3d69d8477de4"),
+	"esp":   MustParseGUID("c12a7328-f81f-11d2-ba4b-00a0c93ec93b"),
+	"swap":  MustParseGUID("0657fd6d-a4ab-43c4-84e5-0933c84b4f4f"),
+	"lvm":   MustParseGUID("e6d6d379-f507-44c2-a23c-238f2a3df928"),
+	"raid":  MustParseGUID("a19d880f-05fc-4d3b-a006-743f0f84911e"),
+	"home":  MustParseGUID("933ac7e1-2eb4-4f13-b844-0e14e2aef915"),
+	"bios":  MustParseGUID("21686148-6449-6e6f-744e-656564454649"),
+}
+
+// TypeName returns the name of the partition type g in Types, or its GUID
+// if it has none.
+func TypeName(g GUID) string {
+	for name, t := range Types {
+		if t == g {
+			return name
+		}
+	}
+	return g.String()
+}
+
+// ParseGUID parses a GUID in its usual text form,
+// xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx, in either case.
+func ParseGUID(s string) (GUID, error) {
+	var g GUID
+	parts := strings.Split(s, "-")
+	if len(parts) != 5 || len(parts[0]) != 8 || len(parts[1]) != 4 || len(parts[2]) != 4 || len(parts[3]) != 4 || len(parts[4]) != 12 {
+		return g, fmt.Errorf("%q is not a GUID", s)
+	}
+	b, err := hex.DecodeString(strings.Join(parts, ""))
+	if err != nil {
+		return g, fmt.Errorf("%q is not a GUID: %v", s, err)
+	}
+	g.L = binary.BigEndian.Uint32(b[0:4])
+	g.W1 = binary.BigEndian.Uint16(b[4:6])
+	g.W2 = binary.BigEndian.Uint16(b[6:8])
+	copy(g.B[:], b[8:])
+	return g, nil
+}
+
+// MustParseGUID is ParseGUID for GUIDs known to be good. It panics on error.
+func MustParseGUID(s string) GUID {
+	g, err := ParseGUID(s)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+// NewGUID returns a random (version 4) GUID.
+func NewGUID() (GUID, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return GUID{}, err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return GUID{
+		L:  binary.BigEndian.Uint32(b[0:4]),
+		W1: binary.BigEndian.Uint16(b[4:6]),
+		W2: binary.BigEndian.Uint16(b[6:8]),
+		B:  [8]byte(b[8:]),
+	}, nil
+}
+
+// NewPartName encodes s as a partition name, in UTF-16LE. Names are at
+// most 36 code units long.
+func NewPartName(s string) (PartName, error) {
+	var n PartName
+	u := utf16.Encode([]rune(s))
+	if len(u) > len(n)/2 {
+		return n, fmt.Errorf("name %q is longer than %d UTF-16 code units", s, len(n)/2)
+	}
+	for i, c := range u {
+		binary.LittleEndian.PutUint16(n[2*i:], c)
+	}
+	return n, nil
+}
+
+func (n *PartName) String() string {
+	var u []uint16
+	for i := 0; i < len(n); i += 2 {
+		c := binary.LittleEndian.Uint16(n[i:])
+		if c == 0 {
+			break
+		}
+		u = append(u, c)
+	}
+	return string(utf16.Decode(u))
+}
+
+// IsEmpty tells whether p is an unused entry, with a zero type GUID.
+func (p *Part) IsEmpty() bool {
+	return p.PartGUID == GUID{}
+}
+
+// Sectors is the number of blocks of p.
+func (p *Part) Sectors() uint64 {
+	return p.LastLBA - p.FirstLBA + 1
+}
+
+// ProtectiveMBR returns an MBR with one partition of type 0xee covering
+// the disk of sectors blocks, as far as an MBR can.
+func ProtectiveMBR(sectors uint64) *MBR {
+	m := &MBR{}
+	e := m[0x1be:]
+	// CHS of the start is 0/0/2, of the end unrepresentable
+	copy(e[1:4], []byte{0x00, 0x02, 0x00})
+	e[4] = 0xee
+	copy(e[5:8], []byte{0xff, 0xff, 0xff})
+	binary.LittleEndian.PutUint32(e[8:], 1)
+	binary.LittleEndian.PutUint32(e[12:], uint32(min(sectors-1, 0xffffffff)))
+	m[510], m[511] = 0x55, 0xaa
+	return m
+}
+
+// Create returns the partition table of an empty disk of sectors blocks:
+// a protective MBR and a primary and backup GPT of MaxNPart unused
+// entries, identified by disk.
+func Create(sectors uint64, disk GUID) (*PartitionTable, error) {
+	// the MBR, two headers and two copies of the entries
+	const entryBlocks = MaxNPart * 0x80 / BlockSize
+	if sectors < 3+2*entryBlocks+1 {
+		return nil, fmt.Errorf("a disk of %d blocks is too small for a GPT", sectors)
+	}
+	g := &GPT{
+		Header: Header{
+			Signature:  Signature,
+			Revision:   Revision,
+			HeaderSize: HeaderSize,
+			CurrentLBA: 1,
+			BackupLBA:  sectors - 1,
+			FirstLBA:   2 + entryBlocks,
+			LastLBA:    sectors - 2 - entryBlocks,
+			DiskGUID:   disk,
+			PartStart:  2,
+			NPart:      MaxNPart,
+			PartSize:   0x80,
+		},
+		Parts: make([]Part, MaxNPart),
+	}
+	return &PartitionTable{
+		MasterBootRecord: ProtectiveMBR(sectors),
+		Primary:          g,
+		Backup:           g.Mirror(),
+	}, nil
+}
+
+// Mirror returns the other copy of g: the backup of a primary GPT, or the
+// primary of a backup. The entries of a backup are right after the last
+// usable block, those of a primary right after its header.
+func (g *GPT) Mirror() *GPT {
+	m := &GPT{Header: g.Header, Parts: append([]Part(nil), g.Parts...)}
+	m.CurrentLBA, m.BackupLBA = g.BackupLBA, g.CurrentLBA
+	m.PartStart = 2
+	if m.CurrentLBA > m.BackupLBA {
+		m.PartStart = g.LastLBA + 1
+	}
+	m.CRC, m.PartCRC = 0, 0
+	return m
+}
+
+// Repair reads the partition table of a disk of size bytes like New, and
+// rebuilds a broken primary GPT from the backup in the last block, or a
+// broken backup from the primary. It also makes a protective MBR if there
+// is no MBR. It tells whether anything was repaired, and fails if neither
+// GPT can be read.
+func Repair(r io.ReaderAt, size int64) (*PartitionTable, bool, error) {
+	p, err := New(r)
+	if err == nil {
+		return p, false, nil
+	}
+	if p.MasterBootRecord == nil {
+		return nil, false, err
+	}
+	if p.MasterBootRecord[510] != 0x55 || p.MasterBootRecord[511] != 0xaa {
+		p.MasterBootRecord = ProtectiveMBR(uint64(size / BlockSize))
+	}
+	b, berr := Table(r, size-BlockSize)
+	switch {
+	case p.Primary != nil:
+		p.Backup = p.Primary.Mirror()
+	case berr == nil:
+		p.Primary = b.Mirror()
+		p.Backup = b
+	default:
+		return nil, false, errors.Join(err, berr)
+	}
+	return p, true, nil
+}
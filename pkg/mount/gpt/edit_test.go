@@ -0,0 +1,155 @@
+package gpt
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseGUID(t *testing.T) {
+	for _, s := range []string{
+		"0fc63daf-8483-4772-8e79-3d69d8477de4",
+		"C12A7328-F81F-11D2-BA4B-00A0C93EC93B",
+	} {
+		g, err := ParseGUID(s)
+		if err != nil {
+			t.Fatalf("ParseGUID(%q): %v", s, err)
+		}
+		if got := g.String(); got != strings.ToLower(s) {
+			t.Errorf("ParseGUID(%q).String() = %q", s, got)
+		}
+	}
+	// the first three fields are little endian on disk
+	if g := Types["esp"]; g.L != 0xc12a7328 || g.W1 != 0xf81f || g.W2 != 0x11d2 || g.B != [8]byte{0xba, 0x4b, 0, 0xa0, 0xc9, 0x3e, 0xc9, 0x3b} {
+		t.Errorf("esp is %#v", g)
+	}
+	for _, s := range []string{"", "0fc63daf-8483-4772-8e79", "0fc63daf84834772-8e79-3d69d8477de4", "0fc63daf-8483-4772-8e79-3d69d8477dzz"} {
+		if _, err := ParseGUID(s); err == nil {
+			t.Errorf("ParseGUID(%q): got nil, want error", s)
+		}
+	}
+
+	g, err := NewGUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.W2>>12 != 4 || g.B[0]>>6 != 2 {
+		t.Errorf("NewGUID() = %s, not a version 4 GUID", &g)
+	}
+}
+
+func TestPartName(t *testing.T) {
+	for _, s := range []string{"", "root", "EFI system partition", "héllo 🙂", "123456789012345678901234567890123456"} {
+		n, err := NewPartName(s)
+		if err != nil {
+			t.Fatalf("NewPartName(%q): %v", s, err)
+		}
+		if got := n.String(); got != s {
+			t.Errorf("NewPartName(%q).String() = %q", s, got)
+		}
+	}
+	if _, err := NewPartName("1234567890123456789012345678901234567"); err == nil {
+		t.Errorf("NewPartName of 37 characters: got nil, want error")
+	}
+}
+
+// image writes a new, empty partition table to a sparse file of sectors
+// blocks
+func image(t *testing.T, sectors uint64) *os.File {
+	t.Helper()
+	f, err := os.Create(filepath.Join(t.TempDir(), "disk.img"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	if err := f.Truncate(int64(sectors * BlockSize)); err != nil {
+		t.Fatal(err)
+	}
+	p, err := Create(sectors, MustParseGUID("569f7b95-0f2e-45dc-97d3-a9e4add43b64"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Primary.Parts[0] = Part{PartGUID: Types["linux"], UniqueGUID: Types["lvm"], FirstLBA: 2048, LastLBA: 4095}
+	p.Backup = p.Primary.Mirror()
+	if err := Write(f, p); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestCreate(t *testing.T) {
+	if _, err := Create(67, GUID{}); err == nil {
+		t.Errorf("Create(67 blocks): got nil, want error")
+	}
+
+	f := image(t, 8192)
+	p, err := New(f)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if p.MasterBootRecord[0x1be+4] != 0xee || p.MasterBootRecord[510] != 0x55 {
+		t.Errorf("MBR is not protective")
+	}
+	for _, tt := range []struct {
+		name      string
+		got, want uint64
+	}{
+		{"primary FirstLBA", p.Primary.FirstLBA, 34},
+		{"primary LastLBA", p.Primary.LastLBA, 8158},
+		{"primary BackupLBA", p.Primary.BackupLBA, 8191},
+		{"backup CurrentLBA", p.Backup.CurrentLBA, 8191},
+		{"backup PartStart", p.Backup.PartStart, 8159},
+		{"partition 1 LastLBA", p.Backup.Parts[0].LastLBA, 4095},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("%s = %d, want %d", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestRepair(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		zero  []int64
+		fixed bool
+		err   bool
+	}{
+		{name: "intact"},
+		{name: "primary header", zero: []int64{1}, fixed: true},
+		{name: "primary entries", zero: []int64{2}, fixed: true},
+		{name: "backup header", zero: []int64{8191}, fixed: true},
+		{name: "MBR and primary", zero: []int64{0, 1}, fixed: true},
+		{name: "both headers", zero: []int64{1, 8191}, err: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f := image(t, 8192)
+			for _, lba := range tt.zero {
+				var b [BlockSize]byte
+				if lba == 2 {
+					b[0] = 1
+				}
+				if _, err := f.WriteAt(b[:], lba*BlockSize); err != nil {
+					t.Fatal(err)
+				}
+			}
+			p, fixed, err := Repair(f, 8192*BlockSize)
+			if (err != nil) != tt.err || fixed != tt.fixed {
+				t.Fatalf("Repair: got %v, %v, want error %v, repaired %v", fixed, err, tt.err, tt.fixed)
+			}
+			if err != nil {
+				return
+			}
+			if err := Write(f, p); err != nil {
+				t.Fatal(err)
+			}
+			q, err := New(f)
+			if err != nil {
+				t.Fatalf("New after Repair: %v", err)
+			}
+			if q.Primary.Parts[0].LastLBA != 4095 || q.Primary.PartStart != 2 || q.Backup.PartStart != 8159 {
+				t.Errorf("repaired table is %v", q)
+			}
+		})
+	}
+}
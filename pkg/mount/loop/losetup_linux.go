@@ -5,6 +5,7 @@
 package loop
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
@@ -82,3 +83,90 @@ func ClearFile(devicename string) error {
 
 	return ClearFD(int(device.Fd()))
 }
+
+// Config is how a file is put on a loop device.
+type Config struct {
+	// Offset is where the device starts in the file.
+	Offset uint64
+
+	// SizeLimit is the size of the device. 0 is up to the end of the file.
+	SizeLimit uint64
+
+	// ReadOnly makes the device read only.
+	ReadOnly bool
+
+	// PartScan makes the kernel scan the device for partitions.
+	PartScan bool
+
+	// AutoClear frees the device once it is closed, or unmounted, last.
+	AutoClear bool
+}
+
+func (c Config) info() unix.LoopInfo64 {
+	info := unix.LoopInfo64{Offset: c.Offset, Sizelimit: c.SizeLimit}
+	if c.ReadOnly {
+		info.Flags |= unix.LO_FLAGS_READ_ONLY
+	}
+	if c.PartScan {
+		info.Flags |= unix.LO_FLAGS_PARTSCAN
+	}
+	if c.AutoClear {
+		info.Flags |= unix.LO_FLAGS_AUTOCLEAR
+	}
+	return info
+}
+
+// ConfigureFD associates a loop device lfd with a regular file ffd as c
+// says.
+//
+// It uses LOOP_CONFIGURE, which does it all at once, and on kernels older
+// than 5.8 which do not have it, LOOP_SET_FD and then LOOP_SET_STATUS64.
+// ffd must be read only for a read only device then.
+func ConfigureFD(lfd, ffd int, c Config) error {
+	info := c.info()
+	err := unix.IoctlLoopConfigure(lfd, &unix.LoopConfig{Fd: uint32(ffd), Info: info})
+	if !errors.Is(err, unix.EINVAL) && !errors.Is(err, unix.ENOTTY) {
+		return err
+	}
+	if err := SetFD(lfd, ffd); err != nil {
+		return err
+	}
+	// the kernel tells read only from the mode of ffd
+	info.Flags &^= unix.LO_FLAGS_READ_ONLY
+	if err := unix.IoctlLoopSetStatus64(lfd, &info); err != nil {
+		ClearFD(lfd)
+		return err
+	}
+	return nil
+}
+
+// Configure associates loop device "devicename" with regular file
+// "filename" as c says. Like SetFile, the device is read only if the file
+// can only be read.
+//
+// With c.AutoClear, the device is freed when Configure returns, unless
+// something else holds it open or it is mounted; use ConfigureFD to keep
+// it.
+func Configure(devicename, filename string, c Config) error {
+	mode := os.O_RDWR
+	if c.ReadOnly {
+		mode = os.O_RDONLY
+	}
+	file, err := os.OpenFile(filename, mode, 0o644)
+	if err != nil && mode == os.O_RDWR {
+		mode, c.ReadOnly = os.O_RDONLY, true
+		file, err = os.OpenFile(filename, mode, 0o644)
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	device, err := os.OpenFile(devicename, mode, 0o644)
+	if err != nil {
+		return err
+	}
+	defer device.Close()
+
+	return ConfigureFD(int(device.Fd()), int(file.Fd()), c)
+}
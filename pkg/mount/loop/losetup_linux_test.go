@@ -66,3 +66,28 @@ func TestSetFile(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestConfigure(t *testing.T) {
+	guest.SkipIfNotInVM(t)
+
+	testdisk := filepath.Join(t.TempDir(), "testdisk")
+	if err := cp.Copy("./testdata/pristine-vfat-disk", testdisk); err != nil {
+		t.Fatal(err)
+	}
+	loopdev, err := FindDevice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Configure(loopdev, testdisk, Config{Offset: 512, SizeLimit: 4096, ReadOnly: true}); err != nil {
+		t.Fatal(err)
+	}
+	defer ClearFile(loopdev) //nolint:errcheck
+
+	info, err := Stat(loopdev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.BackingFile != testdisk || info.Offset != 512 || info.SizeLimit != 4096 || !info.ReadOnly {
+		t.Errorf("got %+v, want %s at 512 for 4096 bytes, read only", info, testdisk)
+	}
+}
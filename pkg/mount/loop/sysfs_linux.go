@@ -0,0 +1,82 @@
+package loop
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sysfs is where the devices are read from, changed by tests.
+var sysfs = "/sys"
+
+// Info is the state of a loop device in use, as sysfs shows it.
+type Info struct {
+	// Dev is the loop device path.
+	Dev string
+
+	// BackingFile is the path of the file behind the device.
+	BackingFile string
+
+	Offset    uint64
+	SizeLimit uint64
+	ReadOnly  bool
+	PartScan  bool
+	AutoClear bool
+	DirectIO  bool
+}
+
+func readAttr(dir, name string) string {
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// Stat reads the state of loop device "devicename" from
+// /sys/block/loopN/loop. It fails with os.ErrNotExist if the device is not
+// in use.
+func Stat(devicename string) (*Info, error) {
+	name := filepath.Base(devicename)
+	dir := filepath.Join(sysfs, "block", name)
+	if _, err := os.Stat(filepath.Join(dir, "loop")); err != nil {
+		return nil, fmt.Errorf("%s: %w", devicename, os.ErrNotExist)
+	}
+	num := func(attr string) uint64 {
+		n, _ := strconv.ParseUint(readAttr(dir, attr), 10, 64)
+		return n
+	}
+	return &Info{
+		Dev:         filepath.Join("/dev", name),
+		BackingFile: readAttr(dir, "loop/backing_file"),
+		Offset:      num("loop/offset"),
+		SizeLimit:   num("loop/sizelimit"),
+		ReadOnly:    readAttr(dir, "ro") == "1",
+		PartScan:    readAttr(dir, "loop/partscan") == "1",
+		AutoClear:   readAttr(dir, "loop/autoclear") == "1",
+		DirectIO:    readAttr(dir, "loop/dio") == "1",
+	}, nil
+}
+
+// Devices lists the loop devices in use, by number.
+func Devices() ([]*Info, error) {
+	names, err := filepath.Glob(filepath.Join(sysfs, "block", "loop*"))
+	if err != nil {
+		return nil, err
+	}
+	number := func(name string) int {
+		n, _ := strconv.Atoi(strings.TrimPrefix(filepath.Base(name), "loop"))
+		return n
+	}
+	sort.Slice(names, func(i, j int) bool { return number(names[i]) < number(names[j]) })
+	var devices []*Info
+	for _, name := range names {
+		if info, err := Stat(name); err == nil {
+			devices = append(devices, info)
+		}
+	}
+	return devices, nil
+}
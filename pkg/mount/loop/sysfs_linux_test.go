@@ -0,0 +1,72 @@
+package loop
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fakeSys makes a sysfs with loop0 and loop10 in use, and loop2 free
+func fakeSys(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	write := func(name, value string) {
+		p := filepath.Join(dir, "block", name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(value+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("loop10/ro", "1")
+	write("loop10/loop/backing_file", "/tmp/disk.img")
+	write("loop10/loop/offset", "1048576")
+	write("loop10/loop/sizelimit", "0")
+	write("loop10/loop/partscan", "1")
+	write("loop10/loop/autoclear", "0")
+	write("loop10/loop/dio", "0")
+	write("loop2/ro", "0")
+	write("loop0/ro", "0")
+	write("loop0/loop/backing_file", "/tmp/file (deleted)")
+	write("loop0/loop/offset", "0")
+	write("loop0/loop/sizelimit", "4096")
+	write("loop0/loop/autoclear", "1")
+	write("sda/ro", "0")
+	saved := sysfs
+	t.Cleanup(func() { sysfs = saved })
+	sysfs = dir
+}
+
+func TestDevices(t *testing.T) {
+	fakeSys(t)
+	devices, err := Devices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*Info{
+		{Dev: "/dev/loop0", BackingFile: "/tmp/file (deleted)", SizeLimit: 4096, AutoClear: true},
+		{Dev: "/dev/loop10", BackingFile: "/tmp/disk.img", Offset: 1 << 20, ReadOnly: true, PartScan: true},
+	}
+	if !reflect.DeepEqual(devices, want) {
+		t.Errorf("got %+v, want %+v", devices, want)
+	}
+}
+
+func TestStat(t *testing.T) {
+	fakeSys(t)
+	info, err := Stat("/dev/loop10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.BackingFile != "/tmp/disk.img" || info.Offset != 1<<20 {
+		t.Errorf("got %+v, want /tmp/disk.img at 1048576", info)
+	}
+	for _, dev := range []string{"/dev/loop2", "/dev/loop3"} {
+		if _, err := Stat(dev); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s: got %v, want %v", dev, err, os.ErrNotExist)
+		}
+	}
+}
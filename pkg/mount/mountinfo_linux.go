@@ -0,0 +1,158 @@
+package mount
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// MountinfoPath is where the kernel lists the mounts of our mount namespace.
+var MountinfoPath = "/proc/self/mountinfo"
+
+// Info is a line of mountinfo, described in proc(5).
+type Info struct {
+	ID     int
+	Parent int
+	Major  uint32
+	Minor  uint32
+	// Root is the directory of the file system that is mounted at Path.
+	Root string
+	Path string
+	// Options are the per mount options, like rw and nosuid.
+	Options string
+	// Optional are the propagation fields, like shared:1 and master:2.
+	Optional []string
+	FSType   string
+	Source   string
+	// SuperOptions are the options of the file system itself.
+	SuperOptions string
+}
+
+// unescape decodes the \040 style escapes the kernel uses for spaces, tabs,
+// newlines and backslashes in mountinfo fields.
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// ParseInfo parses a mountinfo line like
+// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+func ParseInfo(line string) (*Info, error) {
+	fields := strings.Fields(line)
+	sep := -1
+	for i, f := range fields {
+		if f == "-" {
+			sep = i
+			break
+		}
+	}
+	if sep < 6 || len(fields) < sep+3 {
+		return nil, fmt.Errorf("malformed mountinfo line: %q", line)
+	}
+
+	id, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed mount id: %q", fields[0])
+	}
+	parent, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed parent id: %q", fields[1])
+	}
+	var major, minor uint32
+	if _, err := fmt.Sscanf(fields[2], "%d:%d", &major, &minor); err != nil {
+		return nil, fmt.Errorf("malformed device: %q", fields[2])
+	}
+	info := &Info{
+		ID:       id,
+		Parent:   parent,
+		Major:    major,
+		Minor:    minor,
+		Root:     unescape(fields[3]),
+		Path:     unescape(fields[4]),
+		Options:  fields[5],
+		Optional: fields[6:sep],
+		FSType:   unescape(fields[sep+1]),
+		Source:   unescape(fields[sep+2]),
+	}
+	if len(fields) > sep+3 {
+		info.SuperOptions = fields[sep+3]
+	}
+	return info, nil
+}
+
+// ParseMountinfo parses mountinfo from r. The mounts are in the order the
+// kernel lists them, which is the order they were mounted in.
+func ParseMountinfo(r io.Reader) ([]*Info, error) {
+	var mounts []*Info
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		if s.Text() == "" {
+			continue
+		}
+		m, err := ParseInfo(s.Text())
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, s.Err()
+}
+
+// Mountinfo returns the mounts listed in MountinfoPath.
+func Mountinfo() ([]*Info, error) {
+	f, err := os.Open(MountinfoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseMountinfo(f)
+}
+
+// TypeMatch tells whether fstype is in types, a comma separated list like
+// the -t option of mount and umount takes. Like util-linux, a no in front
+// of the list turns all of it around, while a no in front of a later type
+// only keeps that type out.
+func TypeMatch(types, fstype string) bool {
+	if types == "" {
+		return true
+	}
+	types, negate := strings.CutPrefix(types, "no")
+	for _, t := range strings.Split(types, ",") {
+		if n, ok := strings.CutPrefix(t, "no"); ok && strings.EqualFold(n, fstype) {
+			return false
+		}
+		if strings.EqualFold(t, fstype) {
+			return !negate
+		}
+	}
+	return negate
+}
+
+// AllOptions merges the per mount and file system options like util-linux
+// shows them, leaving out the rw or ro of the file system.
+func (i *Info) AllOptions() string {
+	opts := i.Options
+	for _, o := range strings.Split(i.SuperOptions, ",") {
+		if o == "" || o == "rw" || o == "ro" {
+			continue
+		}
+		opts += "," + o
+	}
+	return opts
+}
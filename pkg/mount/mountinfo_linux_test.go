@@ -0,0 +1,94 @@
+package mount
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMountinfo(t *testing.T) {
+	f, err := os.Open("testdata/mountinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	mounts, err := ParseMountinfo(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mounts) != 5 {
+		t.Fatalf("got %d mounts, want 5", len(mounts))
+	}
+
+	want := &Info{
+		ID:           40,
+		Parent:       22,
+		Major:        8,
+		Minor:        1,
+		Root:         "/srv/data",
+		Path:         "/mnt/my data",
+		Options:      "rw,relatime",
+		Optional:     []string{"shared:1"},
+		FSType:       "ext4",
+		Source:       "/dev/sda1",
+		SuperOptions: "rw,errors=remount-ro",
+	}
+	if !reflect.DeepEqual(mounts[3], want) {
+		t.Errorf("mounts[3] = %+v, want %+v", mounts[3], want)
+	}
+	if len(mounts[4].Optional) != 0 {
+		t.Errorf("mounts[4].Optional = %q, want none", mounts[4].Optional)
+	}
+
+	for _, tt := range []struct {
+		i    int
+		want string
+	}{
+		{0, "rw,relatime,errors=remount-ro"},
+		{1, "rw,nosuid,nodev,noexec,relatime"},
+		{2, "rw,nosuid,relatime,size=3071872k,mode=755"},
+	} {
+		if got := mounts[tt.i].AllOptions(); got != tt.want {
+			t.Errorf("mounts[%d].AllOptions() = %q, want %q", tt.i, got, tt.want)
+		}
+	}
+}
+
+func TestParseInfoErrors(t *testing.T) {
+	for _, line := range []string{
+		"22 1 8:1 / / rw,relatime",
+		"x 1 8:1 / / rw - ext4 /dev/sda1 rw",
+		"22 1 8 / / rw - ext4 /dev/sda1 rw",
+	} {
+		if _, err := ParseMountinfo(strings.NewReader(line)); err == nil {
+			t.Errorf("ParseMountinfo(%q) succeeded, want an error", line)
+		}
+	}
+}
+
+func TestTypeMatch(t *testing.T) {
+	for _, tt := range []struct {
+		types, fstype string
+		want          bool
+	}{
+		{"", "ext4", true},
+		{"ext4", "ext4", true},
+		{"vfat,ext4", "ext4", true},
+		{"vfat", "ext4", false},
+		{"noext4,vfat", "vfat", false},
+		{"noext4,vfat", "xfs", true},
+		{"nfs,nocifs", "cifs", false},
+		{"nfs,nocifs", "nfs", true},
+		{"ext4,noxfs", "btrfs", false},
+		{"ext4,noxfs", "ext4", true},
+		{"ext4,noxfs", "xfs", false},
+		{"noext4,noxfs", "btrfs", true},
+		{"noext4,noxfs", "xfs", false},
+		{"EXT4", "ext4", true},
+	} {
+		if got := TypeMatch(tt.types, tt.fstype); got != tt.want {
+			t.Errorf("TypeMatch(%q, %q) = %v, want %v", tt.types, tt.fstype, got, tt.want)
+		}
+	}
+}
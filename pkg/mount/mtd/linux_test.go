@@ -45,3 +45,38 @@ func TestOpen(t *testing.T) {
 		t.Errorf("want %s == %s, want m.DevName() == testmtd.Name()", m.Name(), DevName)
 	}
 }
+
+func TestEraseFile(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "testmtd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(bytes.Repeat([]byte{0x55}, 8192)); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	m, err := NewDev(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	d := m.(*Dev)
+
+	if size, err := d.Size(); err != nil || size != 8192 {
+		t.Errorf("d.Size()=%d, %v, want 8192, nil", size, err)
+	}
+	if n := d.EraseSize(); n != 0 {
+		t.Errorf("d.EraseSize()=%d, want 0 for a file", n)
+	}
+	if err := d.Erase(4096, 4096); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 8192)
+	if _, err := d.ReadAt(buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	want := append(bytes.Repeat([]byte{0x55}, 4096), bytes.Repeat([]byte{0xff}, 4096)...)
+	if !bytes.Equal(buf, want) {
+		t.Errorf("the second block is not erased, or the first is")
+	}
+}
@@ -5,9 +5,36 @@
 package mtd
 
 import (
+	"bytes"
+	"errors"
 	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ioctls of mtd-abi.h
+const (
+	memGetInfo = 0x80204d01
+	memErase   = 0x40084d02
 )
 
+// mtdInfo is struct mtd_info_user
+type mtdInfo struct {
+	Type      uint8
+	Flags     uint32
+	Size      uint32
+	EraseSize uint32
+	WriteSize uint32
+	OOBSize   uint32
+	Padding   uint64
+}
+
+// eraseInfo is struct erase_info_user
+type eraseInfo struct {
+	Start, Length uint32
+}
+
 // Dev contains information about ongoing MTD status and operation.
 type Dev struct {
 	*os.File
@@ -50,3 +77,47 @@ func (m *Dev) Close() error {
 func (m *Dev) Name() string {
 	return m.devName
 }
+
+func (m *Dev) info() (*mtdInfo, error) {
+	var info mtdInfo
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, m.File.Fd(), memGetInfo, uintptr(unsafe.Pointer(&info))); errno != 0 {
+		return nil, errno
+	}
+	return &info, nil
+}
+
+// Size returns the size of the device, or of the file standing in for one.
+func (m *Dev) Size() (int64, error) {
+	if info, err := m.info(); err == nil {
+		return int64(info.Size), nil
+	}
+	fi, err := m.File.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// EraseSize implements Eraser.EraseSize. It is 0 for a file.
+func (m *Dev) EraseSize() int64 {
+	info, err := m.info()
+	if err != nil {
+		return 0
+	}
+	return int64(info.EraseSize)
+}
+
+// Erase implements Eraser.Erase. A file standing in for a device has the
+// blocks written with 0xff.
+func (m *Dev) Erase(off, size int64) error {
+	e := eraseInfo{Start: uint32(off), Length: uint32(size)}
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, m.File.Fd(), memErase, uintptr(unsafe.Pointer(&e)))
+	if errno == 0 {
+		return nil
+	}
+	if !errors.Is(errno, unix.ENOTTY) {
+		return errno
+	}
+	_, err := m.File.WriteAt(bytes.Repeat([]byte{0xff}, int(size)), off)
+	return err
+}
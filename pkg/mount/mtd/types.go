@@ -92,3 +92,14 @@ type ChipDevice struct {
 	pageSize int
 	numPages int
 }
+
+// Eraser is a Flasher whose blocks must be erased before bits written to 0
+// can be written back to 1, like a NOR flash. Erasing a block sets all of
+// its bytes to 0xff.
+type Eraser interface {
+	// EraseSize returns the size of the erase blocks, or 0 if unknown.
+	EraseSize() int64
+	// Erase erases the blocks from off for size bytes, which must be
+	// multiples of the EraseSize.
+	Erase(off, size int64) error
+}
@@ -27,7 +27,6 @@ const (
 
 	//	ataUsingLBA uint8 = (1 << 6)  nolint:golint,unused
 	//	ataStatDRQ  uint8 = (1 << 3)  nolint:golint,unused
-	//	ataStatErr  uint8 = (1 << 0)  nolint:golint,unused
 
 	//	read  uint8 = 0  nolint:golint,unused
 	// ataTo int32 = 1
@@ -62,6 +61,15 @@ const (
 	tdirTo    = 0 << 3
 	tdirFrom  = 1 << 3
 	checkCond = 1 << 5
+
+	// senseDescriptor is the response code of descriptor format sense
+	// data, and ataReturnDescriptor the code of the descriptor holding the
+	// ATA registers.
+	senseDescriptor     = 0x72
+	ataReturnDescriptor = 0x09
+
+	ataStatErr = 1 << 0
+	ataStatDF  = 1 << 5
 )
 
 type (
@@ -86,6 +94,19 @@ type (
 	statusBlock [maxStatusBlockLen]byte
 )
 
+// failed tells whether the status block holds an error. Commands without
+// data ask for the ATA registers back, and get sense data even when they
+// succeed: then the ATA status in the ATA Return descriptor tells.
+func (s statusBlock) failed() bool {
+	switch {
+	case s[0] == 0:
+		return false
+	case s[0] == senseDescriptor && s[8] == ataReturnDescriptor:
+		return s[21]&(ataStatErr|ataStatDF) != 0
+	}
+	return true
+}
+
 func (b dataBlock) toWordBlock() (wordBlock, error) {
 	var w wordBlock
 	err := binary.Read(bytes.NewBuffer(b[:]), binary.BigEndian, &w)
@@ -51,3 +51,26 @@ func TestMustLBA(t *testing.T) {
 		t.Errorf("good mustLBA: got %v, want nil", err)
 	}
 }
+
+func TestFailed(t *testing.T) {
+	ataReturn := func(status byte) statusBlock {
+		var s statusBlock
+		s[0], s[7], s[8], s[9], s[21] = 0x72, 14, 0x09, 0x0c, status
+		return s
+	}
+	for _, tt := range []struct {
+		name string
+		s    statusBlock
+		want bool
+	}{
+		{"no sense data", statusBlock{}, false},
+		{"ATA status ready", ataReturn(0x50), false},
+		{"ATA status error", ataReturn(0x51), true},
+		{"ATA status device fault", ataReturn(0x60), true},
+		{"fixed format sense", statusBlock{0x70, 0x00, 0x05}, true},
+	} {
+		if got := tt.s.failed(); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
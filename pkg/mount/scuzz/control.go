@@ -13,6 +13,10 @@ import (
 // DefaultTimeout is the default timeout for disk operations.
 const DefaultTimeout time.Duration = 15 * time.Second
 
+// EraseTimeout is the least time a secure erase is given, as it can take
+// hours on big disks.
+const EraseTimeout time.Duration = 12 * time.Hour
+
 const (
 	securitySupported    DiskSecurityStatus = 0x1
 	securityEnabled      DiskSecurityStatus = 0x2
@@ -60,6 +64,16 @@ type Disk interface {
 
 	// Identify returns drive identity information
 	Identify() (*Info, error)
+
+	// SetPassword sets the admin or user password.
+	SetPassword(password string, admin bool) error
+
+	// DisablePassword removes the user password, given the admin or user
+	// password.
+	DisablePassword(password string, admin bool) error
+
+	// SecureErase erases the disk, given the admin or user password.
+	SecureErase(password string, admin, enhanced bool) error
 }
 
 // DiskSecurityStatus is information about how the disk is secured.
@@ -126,6 +126,14 @@ type diskFile interface {
 	Fd() uintptr
 }
 
+// Operator is a disk file that carries out commands itself instead of
+// through the SG_IO ioctl, like a fake disk in tests. Operate is given the
+// ATA16 command and data block and the data block, which it reads or fills
+// depending on the command, and fills the status block.
+type Operator interface {
+	Operate(cdb, data, status []byte) error
+}
+
 // SGDisk implements a Disk using the Linux SG device
 type SGDisk struct {
 	f      diskFile
@@ -237,7 +245,22 @@ func (s *SGDisk) newPacket(cmd Cmd, direction direction, ataType uint8) *packet
 }
 
 func (s *SGDisk) unlockPacket(password string, admin bool) *packet {
-	p := s.newPacket(unix.WIN_SECURITY_UNLOCK, _SG_DXFER_TO_DEV, lba48)
+	return s.passwordPacket(unix.WIN_SECURITY_UNLOCK, password, admin)
+}
+
+// Unlock performs unlock requests for Linux SCSI Generic Disks
+func (s *SGDisk) Unlock(password string, admin bool) error {
+	p := s.unlockPacket(password, admin)
+	if err := s.operate(p); err != nil {
+		return err
+	}
+	return nil
+}
+
+// passwordPacket is a packet for the security commands that send a
+// password in their data block, as the admin (master) or user password.
+func (s *SGDisk) passwordPacket(cmd Cmd, password string, admin bool) *packet {
+	p := s.newPacket(cmd, _SG_DXFER_TO_DEV, lba48)
 	p.genCommandDataBlock()
 	if admin {
 		p.block[1] = 1
@@ -246,13 +269,42 @@ func (s *SGDisk) unlockPacket(password string, admin bool) *packet {
 	return p
 }
 
-// Unlock performs unlock requests for Linux SCSI Generic Disks
-func (s *SGDisk) Unlock(password string, admin bool) error {
-	p := s.unlockPacket(password, admin)
-	if err := s.operate(p); err != nil {
+// SetPassword sets the admin or user password, enabling security when it is
+// the user password.
+func (s *SGDisk) SetPassword(password string, admin bool) error {
+	return s.operate(s.passwordPacket(unix.WIN_SECURITY_SET_PASS, password, admin))
+}
+
+// DisablePassword removes the user password, given the admin or user
+// password, disabling security.
+func (s *SGDisk) DisablePassword(password string, admin bool) error {
+	return s.operate(s.passwordPacket(unix.WIN_SECURITY_DISABLE, password, admin))
+}
+
+func (s *SGDisk) erasePackets(password string, admin, enhanced bool) (*packet, *packet) {
+	prepare := s.newPacket(unix.WIN_SECURITY_ERASE_PREPARE, _SG_DXFER_NONE, lba48)
+	prepare.dataLen = 0
+	prepare.nsect = 0
+	prepare.genCommandDataBlock()
+
+	erase := s.passwordPacket(unix.WIN_SECURITY_ERASE_UNIT, password, admin)
+	if enhanced {
+		erase.block[0] |= 2
+	}
+	erase.timeout = uint32(max(s.Timeout, EraseTimeout).Seconds() * 1000)
+	return prepare, erase
+}
+
+// SecureErase erases all of the disk, given the admin or user password, and
+// disables security. The enhanced erase also writes over the sectors that
+// are no longer in use, if the disk supports it. The erase waits for at
+// least EraseTimeout.
+func (s *SGDisk) SecureErase(password string, admin, enhanced bool) error {
+	prepare, erase := s.erasePackets(password, admin, enhanced)
+	if err := s.operate(prepare); err != nil {
 		return err
 	}
-	return nil
+	return s.operate(erase)
 }
 
 func (s *SGDisk) identifyPacket() *packet {
@@ -274,13 +326,25 @@ func (s *SGDisk) Identify() (*Info, error) {
 const _SG_IO = 0x2285
 
 func (s *SGDisk) operate(p *packet) error {
-	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(s.f.Fd()), _SG_IO, uintptr(unsafe.Pointer(&p.packetHeader)))
-	sb := p.status[0]
-	if errno != 0 || sb != 0 {
+	if o, ok := s.f.(Operator); ok {
+		if err := o.Operate(p.command[:], p.block[:], p.status[:]); err != nil {
+			return &os.PathError{Op: "operate", Path: s.f.Name(), Err: err}
+		}
+	} else {
+		_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(s.f.Fd()), _SG_IO, uintptr(unsafe.Pointer(&p.packetHeader)))
+		if errno != 0 {
+			return &os.PathError{
+				Op:   "ioctl SG_IO",
+				Path: s.f.Name(),
+				Err:  fmt.Errorf("SCSI generic error %v", errno),
+			}
+		}
+	}
+	if p.status.failed() {
 		return &os.PathError{
 			Op:   "ioctl SG_IO",
 			Path: s.f.Name(),
-			Err:  fmt.Errorf("SCSI generic error %v and drive error status %#02x", errno, sb),
+			Err:  fmt.Errorf("drive error status %#02x", p.status[0]),
 		}
 	}
 	w, err := p.block.toWordBlock()
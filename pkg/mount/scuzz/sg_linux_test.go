@@ -187,3 +187,36 @@ func TestIdentify(t *testing.T) {
 	p := (&SGDisk{dev: 0x40, Timeout: DefaultTimeout}).identifyPacket()
 	check(t, p, want)
 }
+
+func TestSecurityPackets(t *testing.T) {
+	d := &SGDisk{dev: 0x40, Timeout: DefaultTimeout}
+	password := "secret"
+	data := func(admin, enhanced bool) dataBlock {
+		var b dataBlock
+		if admin {
+			b[1] = 1
+		}
+		if enhanced {
+			b[0] = 2
+		}
+		copy(b[2:], password)
+		return b
+	}
+	out := func(cmd Cmd, timeout uint32, b dataBlock) *packet {
+		return &packet{
+			packetHeader: packetHeader{interfaceID: 'S', direction: -2, cmdLen: 16, maxStatusBlockLen: 32, dataLen: 512, timeout: timeout},
+			command:      commandDataBlock{0x85, 0xb, 0x6, 0x00, 0x00, 0x00, 0x1, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x40, uint8(cmd), 0x00},
+			block:        b,
+		}
+	}
+
+	check(t, d.passwordPacket(0xf1, password, true), out(0xf1, 15000, data(true, false)))
+	check(t, d.passwordPacket(0xf6, password, false), out(0xf6, 15000, data(false, false)))
+
+	prepare, erase := d.erasePackets(password, false, true)
+	check(t, prepare, &packet{
+		packetHeader: packetHeader{interfaceID: 'S', direction: -1, cmdLen: 16, maxStatusBlockLen: 32, timeout: 15000},
+		command:      commandDataBlock{0x85, 0x7, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x40, 0xf3, 0x00},
+	})
+	check(t, erase, out(0xf4, 12*60*60*1000, data(false, true)))
+}
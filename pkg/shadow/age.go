@@ -0,0 +1,50 @@
+package shadow
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrExpired is returned by Check for accounts past their expiry date
+	ErrExpired = errors.New("account expired")
+	// ErrInactive is returned by Check for accounts whose password expired
+	// longer ago than the inactivity period
+	ErrInactive = errors.New("account inactive")
+)
+
+// never is the maximum password age that means no aging
+const never = 99999
+
+// Day is the day since the epoch now is on, as the shadow file counts them
+func Day(now time.Time) int {
+	return int(now.Unix() / (24 * 60 * 60))
+}
+
+// Check tells whether the account can log in on now's day, like shadow's
+// isexpired
+func (e *Entry) Check(now time.Time) error {
+	today := Day(now)
+	if e.Expire > 0 && today >= e.Expire {
+		return ErrExpired
+	}
+	if e.LastChange > 0 && e.Max >= 0 && e.Max < never && e.Inactive >= 0 &&
+		today >= e.LastChange+e.Max+e.Inactive {
+		return ErrInactive
+	}
+	return nil
+}
+
+// PasswordExpiry is the number of days until the password has to be
+// changed, negative once it is overdue. ok is false for passwords that
+// don't age, and when it's too early to warn.
+func (e *Entry) PasswordExpiry(now time.Time) (days int, ok bool) {
+	if e.LastChange <= 0 || e.Max < 0 || e.Max >= never {
+		return 0, false
+	}
+	days = e.LastChange + e.Max - Day(now)
+	if days >= 0 && (e.Warn < 0 || days > e.Warn) {
+		return days, false
+	}
+	return days, true
+}
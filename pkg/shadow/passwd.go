@@ -0,0 +1,94 @@
+package shadow
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// User is a line of the passwd file
+type User struct {
+	Name  string
+	UID   int
+	GID   int
+	Gecos string
+	Home  string
+	Shell string
+}
+
+// scan calls fn with the fields of each line of the colon separated file
+// at path under Root until it returns true
+func scan(path string, fn func([]string) bool) error {
+	f, err := os.Open(filepath.Join(Root, path))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		l := s.Text()
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		if fn(strings.Split(l, ":")) {
+			return nil
+		}
+	}
+	return s.Err()
+}
+
+// LookupUser finds name in the passwd file
+func LookupUser(name string) (*User, error) {
+	var u *User
+	var err error
+	serr := scan("etc/passwd", func(f []string) bool {
+		if f[0] != name {
+			return false
+		}
+		if len(f) != 7 {
+			err = fmt.Errorf("passwd entry for %s has %d fields, want 7", name, len(f))
+			return true
+		}
+		u = &User{Name: f[0], Gecos: f[4], Home: f[5], Shell: f[6]}
+		if u.UID, err = strconv.Atoi(f[2]); err == nil {
+			u.GID, err = strconv.Atoi(f[3])
+		}
+		return true
+	})
+	switch {
+	case serr != nil:
+		return nil, serr
+	case err != nil:
+		return nil, err
+	case u == nil:
+		return nil, fmt.Errorf("%s: %w", name, ErrNoUser)
+	}
+	return u, nil
+}
+
+// Groups lists gid and the groups that have name as a member, like
+// initgroups(3)
+func Groups(name string, gid int) ([]int, error) {
+	groups := []int{gid}
+	err := scan("etc/group", func(f []string) bool {
+		if len(f) != 4 {
+			return false
+		}
+		id, err := strconv.Atoi(f[2])
+		if err != nil || id == gid {
+			return false
+		}
+		for _, m := range strings.Split(f[3], ",") {
+			if m == name {
+				groups = append(groups, id)
+				break
+			}
+		}
+		return false
+	})
+	return groups, err
+}
@@ -0,0 +1,132 @@
+// Package shadow looks up accounts in /etc/passwd, /etc/group and
+// /etc/shadow and checks passwords against their crypt hashes.
+//
+// Root can be pointed at a directory of fixture files for testing.
+package shadow
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/GehirnInc/crypt"
+	_ "github.com/GehirnInc/crypt/md5_crypt"
+	_ "github.com/GehirnInc/crypt/sha256_crypt"
+	_ "github.com/GehirnInc/crypt/sha512_crypt"
+)
+
+// Root is the directory the etc files are under
+var Root = "/"
+
+var (
+	// ErrNoUser is returned for names that aren't in the file
+	ErrNoUser = errors.New("no such user")
+	// ErrLocked is returned for accounts whose password can't match
+	ErrLocked = errors.New("account locked")
+	// ErrPassword is returned for a wrong password
+	ErrPassword = errors.New("wrong password")
+	// ErrUnsupported is returned for hashes we can't check, like yescrypt
+	// without the libxcrypt build tag
+	ErrUnsupported = errors.New("unsupported password hash")
+)
+
+// Entry is a line of the shadow file. The day counts are days since the
+// epoch, or -1 where the field is empty.
+type Entry struct {
+	Name     string
+	Password string
+	LastChange,
+	Min,
+	Max,
+	Warn,
+	Inactive,
+	Expire int
+}
+
+func day(field string) (int, error) {
+	if field == "" {
+		return -1, nil
+	}
+	return strconv.Atoi(field)
+}
+
+// Parse parses a line of the shadow file
+func Parse(line string) (*Entry, error) {
+	f := strings.Split(line, ":")
+	if len(f) != 9 {
+		return nil, fmt.Errorf("%d fields in shadow entry, want 9", len(f))
+	}
+	e := &Entry{Name: f[0], Password: f[1]}
+	for i, d := range []*int{&e.LastChange, &e.Min, &e.Max, &e.Warn, &e.Inactive, &e.Expire} {
+		v, err := day(f[i+2])
+		if err != nil {
+			return nil, fmt.Errorf("shadow entry for %s: %w", e.Name, err)
+		}
+		*d = v
+	}
+	return e, nil
+}
+
+// Lookup finds the shadow entry for name
+func Lookup(name string) (*Entry, error) {
+	var e *Entry
+	var err error
+	serr := scan("etc/shadow", func(f []string) bool {
+		if f[0] != name {
+			return false
+		}
+		e, err = Parse(strings.Join(f, ":"))
+		return true
+	})
+	switch {
+	case serr != nil:
+		return nil, serr
+	case err != nil:
+		return nil, err
+	case e == nil:
+		return nil, fmt.Errorf("%s: %w", name, ErrNoUser)
+	}
+	return e, nil
+}
+
+// Locked tells whether no password can log in to the account, which is
+// how "!" and "*" entries lock it
+func (e *Entry) Locked() bool {
+	return strings.HasPrefix(e.Password, "!") || strings.HasPrefix(e.Password, "*")
+}
+
+// hashID is the $id$ a crypt hash starts with
+func hashID(hash string) string {
+	if rest, ok := strings.CutPrefix(hash, "$"); ok {
+		if id, _, ok := strings.Cut(rest, "$"); ok {
+			return "$" + id + "$"
+		}
+	}
+	return "DES"
+}
+
+// Verify checks password against the entry. An empty hash takes any
+// password.
+func (e *Entry) Verify(password string) error {
+	if e.Locked() {
+		return ErrLocked
+	}
+	if e.Password == "" {
+		return nil
+	}
+	if !crypt.IsHashSupported(e.Password) {
+		ok, supported := xcrypt(e.Password, password)
+		if !supported {
+			return fmt.Errorf("%s: %w %s", e.Name, ErrUnsupported, hashID(e.Password))
+		}
+		if !ok {
+			return ErrPassword
+		}
+		return nil
+	}
+	if crypt.NewFromHash(e.Password).Verify(e.Password, []byte(password)) != nil {
+		return ErrPassword
+	}
+	return nil
+}
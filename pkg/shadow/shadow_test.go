@@ -0,0 +1,148 @@
+package shadow
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func withRoot(t *testing.T, dir string) {
+	t.Helper()
+	old := Root
+	Root = dir
+	t.Cleanup(func() { Root = old })
+}
+
+func TestLookup(t *testing.T) {
+	withRoot(t, "testdata")
+
+	e, err := Lookup("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Entry{Name: "alice", Password: e.Password, LastChange: 19650, Min: 1, Max: 90, Warn: 14, Inactive: 30, Expire: 20000}
+	if *e != want {
+		t.Errorf("Lookup(alice) = %+v, want %+v", *e, want)
+	}
+	if e, err := Lookup("bob"); err != nil || e.Min != -1 || e.Expire != -1 {
+		t.Errorf("Lookup(bob) = %+v, %v, want empty fields as -1", e, err)
+	}
+	if _, err := Lookup("nobody"); !errors.Is(err, ErrNoUser) {
+		t.Errorf("Lookup(nobody) = %v, want ErrNoUser", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	withRoot(t, "testdata")
+
+	for _, tt := range []struct {
+		name, password string
+		want           error
+	}{
+		{"root", "secret", nil},
+		{"root", "Secret", ErrPassword},
+		{"alice", "secret", nil},
+		{"bob", "secret", nil},
+		{"bob", "", ErrPassword},
+		{"carol", "secret", ErrLocked},
+		{"daemon", "", ErrLocked},
+		{"guest", "anything", nil},
+	} {
+		e, err := Lookup(tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Verify(tt.password); !errors.Is(err, tt.want) {
+			t.Errorf("Verify(%s, %q) = %v, want %v", tt.name, tt.password, err, tt.want)
+		}
+	}
+
+	e, err := Lookup("dave")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// yescrypt is left to the C library when built with libxcrypt
+	switch err := e.Verify("secret"); {
+	case errors.Is(err, ErrPassword):
+		t.Errorf("Verify(dave) rejected the right password")
+	case err == nil:
+		if err := e.Verify("Secret"); !errors.Is(err, ErrPassword) {
+			t.Errorf("Verify(dave, wrong) = %v, want ErrPassword", err)
+		}
+	case !errors.Is(err, ErrUnsupported):
+		t.Errorf("Verify(dave) = %v, want ErrUnsupported without libxcrypt", err)
+	default:
+		t.Logf("no yescrypt: %v", err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	if _, err := Parse("root:x:1:2"); err == nil {
+		t.Errorf("Parse took a short line")
+	}
+	if _, err := Parse("root:x:day:0:99999:7:::"); err == nil {
+		t.Errorf("Parse took a bad day count")
+	}
+}
+
+func TestLookupUser(t *testing.T) {
+	withRoot(t, "testdata")
+
+	u, err := LookupUser("alice")
+	want := User{Name: "alice", UID: 1000, GID: 1000, Gecos: "Alice", Home: "/home/alice", Shell: "/bin/bash"}
+	if err != nil || *u != want {
+		t.Errorf("LookupUser(alice) = %+v, %v, want %+v", u, err, want)
+	}
+	if _, err := LookupUser("nobody"); !errors.Is(err, ErrNoUser) {
+		t.Errorf("LookupUser(nobody) = %v, want ErrNoUser", err)
+	}
+	if _, err := LookupUser("broken"); err == nil {
+		t.Errorf("LookupUser(broken) took a short line")
+	}
+}
+
+func TestGroups(t *testing.T) {
+	withRoot(t, "testdata")
+
+	for _, tt := range []struct {
+		name string
+		gid  int
+		want []int
+	}{
+		{"alice", 1000, []int{1000, 10, 63}},
+		{"bob", 100, []int{100, 10}},
+		{"root", 0, []int{0}},
+	} {
+		got, err := Groups(tt.name, tt.gid)
+		if err != nil || fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("Groups(%s) = %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	day := func(d int) time.Time { return time.Unix(int64(d)*24*60*60+3600, 0) }
+	for _, tt := range []struct {
+		e      Entry
+		now    int
+		want   error
+		expiry int
+		warn   bool
+	}{
+		{Entry{LastChange: 19650, Max: 99999, Inactive: -1, Expire: -1}, 30000, nil, 0, false},
+		{Entry{LastChange: 19650, Max: 90, Warn: 7, Inactive: 30, Expire: 20000}, 19700, nil, 40, false},
+		{Entry{LastChange: 19650, Max: 90, Warn: 7, Inactive: 30, Expire: 20000}, 19735, nil, 5, true},
+		{Entry{LastChange: 19650, Max: 90, Warn: 7, Inactive: 30, Expire: 20000}, 19750, nil, -10, true},
+		{Entry{LastChange: 19650, Max: 90, Warn: 7, Inactive: 30, Expire: 20000}, 19770, ErrInactive, -30, true},
+		{Entry{LastChange: 19650, Max: 90, Warn: 7, Inactive: 30, Expire: 19700}, 19700, ErrExpired, 40, false},
+		{Entry{LastChange: 0, Max: 90, Inactive: 0, Expire: -1}, 19700, nil, 0, false},
+	} {
+		if err := tt.e.Check(day(tt.now)); err != tt.want {
+			t.Errorf("%+v on day %d: Check() = %v, want %v", tt.e, tt.now, err, tt.want)
+		}
+		if days, warn := tt.e.PasswordExpiry(day(tt.now)); warn != tt.warn || days != tt.expiry {
+			t.Errorf("%+v on day %d: PasswordExpiry() = %d, %v, want %d, %v", tt.e, tt.now, days, warn, tt.expiry, tt.warn)
+		}
+	}
+}
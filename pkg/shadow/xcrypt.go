@@ -0,0 +1,33 @@
+//go:build cgo && libxcrypt
+
+package shadow
+
+/*
+#cgo LDFLAGS: -lcrypt
+#include <crypt.h>
+#include <stdlib.h>
+#include <string.h>
+
+static int verify(const char *key, const char *hash)
+{
+	struct crypt_data data;
+	char *out;
+
+	memset(&data, 0, sizeof(data));
+	out = crypt_r(key, hash, &data);
+	return out != NULL && out[0] != '*' && strcmp(out, hash) == 0;
+}
+*/
+import "C"
+
+import "unsafe"
+
+// xcrypt checks hashes the Go crypt packages don't know, like yescrypt,
+// with the C library. It links libcrypt, so it takes -tags libxcrypt.
+func xcrypt(hash, password string) (ok, supported bool) {
+	h := C.CString(hash)
+	defer C.free(unsafe.Pointer(h))
+	k := C.CString(password)
+	defer C.free(unsafe.Pointer(k))
+	return C.verify(k, h) != 0, true
+}
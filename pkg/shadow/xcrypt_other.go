@@ -0,0 +1,9 @@
+//go:build !cgo || !libxcrypt
+
+package shadow
+
+// xcrypt needs the C library, and -tags libxcrypt, for what the Go crypt
+// packages don't know
+func xcrypt(hash, password string) (ok, supported bool) {
+	return false, false
+}
@@ -0,0 +1,76 @@
+package sysinfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// LastlogSize is the size of a struct lastlog, the records of the lastlog
+// file are indexed by uid
+const LastlogSize = 292
+
+// rawLastlog mirrors struct lastlog
+type rawLastlog struct {
+	Time int32
+	Line [32]byte
+	Host [256]byte
+}
+
+// Lastlog is a user's most recent login
+type Lastlog struct {
+	Time time.Time
+	Line string
+	Host string
+}
+
+// ReadLastlog reads uid's record. Someone who never logged in has the zero
+// Lastlog.
+func ReadLastlog(path string, uid int) (Lastlog, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Lastlog{}, nil
+	}
+	if err != nil {
+		return Lastlog{}, err
+	}
+	defer f.Close()
+
+	b := make([]byte, LastlogSize)
+	if _, err := f.ReadAt(b, int64(uid)*LastlogSize); err != nil {
+		if errors.Is(err, io.EOF) {
+			return Lastlog{}, nil
+		}
+		return Lastlog{}, err
+	}
+	var r rawLastlog
+	if err := binary.Read(bytes.NewReader(b), binary.NativeEndian, &r); err != nil {
+		return Lastlog{}, err
+	}
+	if r.Time == 0 {
+		return Lastlog{}, nil
+	}
+	return Lastlog{Time: time.Unix(int64(r.Time), 0), Line: cstring(r.Line[:]), Host: cstring(r.Host[:])}, nil
+}
+
+// WriteLastlog replaces uid's record
+func WriteLastlog(path string, uid int, l *Lastlog) error {
+	r := rawLastlog{Time: int32(l.Time.Unix())}
+	copy(r.Line[:], l.Line)
+	copy(r.Host[:], l.Host)
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.NativeEndian, &r); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteAt(buf.Bytes(), int64(uid)*LastlogSize)
+	return err
+}
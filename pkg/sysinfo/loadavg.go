@@ -0,0 +1,60 @@
+package sysinfo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// LoadAvg is the content of /proc/loadavg
+type LoadAvg struct {
+	One     float64 `json:"1min"`
+	Five    float64 `json:"5min"`
+	Fifteen float64 `json:"15min"`
+	Running int     `json:"running"`
+	Total   int     `json:"total"`
+	LastPid int     `json:"last_pid"`
+}
+
+// ReadLoadAvg reads /proc/loadavg
+func ReadLoadAvg() (*LoadAvg, error) {
+	buf, err := readProc("loadavg")
+	if err != nil {
+		return nil, err
+	}
+	return ParseLoadAvg(string(buf))
+}
+
+// ParseLoadAvg parses a line like "0.20 0.18 0.12 1/80 11206"
+func ParseLoadAvg(contents string) (*LoadAvg, error) {
+	f := strings.Fields(contents)
+	if len(f) < 3 {
+		return nil, fmt.Errorf("loadavg: invalid contents %q", contents)
+	}
+
+	var l LoadAvg
+	var err error
+	for i, v := range []*float64{&l.One, &l.Five, &l.Fifteen} {
+		if *v, err = strconv.ParseFloat(f[i], 64); err != nil {
+			return nil, fmt.Errorf("loadavg: %w", err)
+		}
+	}
+
+	// the scheduling entities and last PID are missing on some emulated /proc files
+	if len(f) >= 5 {
+		running, total, ok := strings.Cut(f[3], "/")
+		if !ok {
+			return nil, fmt.Errorf("loadavg: invalid entity count %q", f[3])
+		}
+		if l.Running, err = strconv.Atoi(running); err != nil {
+			return nil, fmt.Errorf("loadavg: %w", err)
+		}
+		if l.Total, err = strconv.Atoi(total); err != nil {
+			return nil, fmt.Errorf("loadavg: %w", err)
+		}
+		if l.LastPid, err = strconv.Atoi(f[4]); err != nil {
+			return nil, fmt.Errorf("loadavg: %w", err)
+		}
+	}
+	return &l, nil
+}
@@ -0,0 +1,135 @@
+package sysinfo
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// MemInfoMap holds the fields of /proc/meminfo, values are in kibibytes
+// like the file itself
+type MemInfoMap map[string]uint64
+
+// Mem is the physical memory summary, in bytes
+type Mem struct {
+	Total     uint64 `json:"total"`
+	Used      uint64 `json:"used"`
+	Free      uint64 `json:"free"`
+	Shared    uint64 `json:"shared"`
+	Cached    uint64 `json:"cached"`
+	Buffers   uint64 `json:"buffers"`
+	Available uint64 `json:"available"`
+}
+
+// Swap is the swap space summary, in bytes
+type Swap struct {
+	Total uint64 `json:"total"`
+	Used  uint64 `json:"used"`
+	Free  uint64 `json:"free"`
+}
+
+// MemInfo represents the main memory and swap space information in a structured
+// manner, suitable for JSON encoding.
+type MemInfo struct {
+	Mem  Mem  `json:"mem"`
+	Swap Swap `json:"swap"`
+}
+
+// ReadMemInfoMap returns a mapping that represents the fields contained in
+// /proc/meminfo
+func ReadMemInfoMap() (MemInfoMap, error) {
+	buf, err := readProc("meminfo")
+	if err != nil {
+		return nil, err
+	}
+	return ParseMemInfoMap(buf)
+}
+
+// ParseMemInfoMap returns a mapping that represents the fields contained in a
+// byte stream with a content compatible with /proc/meminfo
+func ParseMemInfoMap(buf []byte) (MemInfoMap, error) {
+	ret := make(MemInfoMap)
+	for _, line := range bytes.Split(buf, []byte{'\n'}) {
+		kv := bytes.SplitN(line, []byte{':'}, 2)
+		if len(kv) != 2 {
+			// invalid line?
+			continue
+		}
+		key := string(kv[0])
+		tokens := bytes.Fields(kv[1])
+		if len(tokens) > 0 {
+			value, err := strconv.ParseUint(string(tokens[0]), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("meminfo: %v: %w", key, err)
+			}
+			ret[key] = value
+		}
+	}
+	return ret, nil
+}
+
+func (m MemInfoMap) require(fields ...string) error {
+	for _, f := range fields {
+		if _, ok := m[f]; !ok {
+			return fmt.Errorf("meminfo: missing field %q", f)
+		}
+	}
+	return nil
+}
+
+// Mem summarizes the physical memory the way free(1) does
+func (m MemInfoMap) Mem() (Mem, error) {
+	if err := m.require("MemTotal", "MemFree", "Buffers", "Cached", "Shmem", "SReclaimable", "MemAvailable"); err != nil {
+		return Mem{}, err
+	}
+
+	mem := Mem{
+		Total:     m["MemTotal"] << 10,
+		Free:      m["MemFree"] << 10,
+		Shared:    m["Shmem"] << 10,
+		Cached:    (m["Cached"] + m["SReclaimable"]) << 10,
+		Buffers:   m["Buffers"] << 10,
+		Available: m["MemAvailable"] << 10,
+	}
+
+	// used can not go below zero when the cache is accounted oddly, like in containers
+	if used := mem.Free + mem.Cached + mem.Buffers; used < mem.Total {
+		mem.Used = mem.Total - used
+	}
+	return mem, nil
+}
+
+// Swap summarizes the swap space
+func (m MemInfoMap) Swap() (Swap, error) {
+	if err := m.require("SwapTotal", "SwapFree"); err != nil {
+		return Swap{}, err
+	}
+
+	return Swap{
+		Total: m["SwapTotal"] << 10,
+		Used:  (m["SwapTotal"] - m["SwapFree"]) << 10,
+		Free:  m["SwapFree"] << 10,
+	}, nil
+}
+
+// MemInfo returns both summaries
+func (m MemInfoMap) MemInfo() (*MemInfo, error) {
+	mem, err := m.Mem()
+	if err != nil {
+		return nil, err
+	}
+	swap, err := m.Swap()
+	if err != nil {
+		return nil, err
+	}
+	return &MemInfo{Mem: mem, Swap: swap}, nil
+}
+
+// ReadMemInfo reads /proc/meminfo and summarizes it
+func ReadMemInfo() (*MemInfo, error) {
+	m, err := ReadMemInfoMap()
+	if err != nil {
+		return nil, err
+	}
+	return m.MemInfo()
+}
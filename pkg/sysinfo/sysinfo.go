@@ -0,0 +1,27 @@
+// Package sysinfo parses the /proc files and the utmp database that free,
+// uptime and friends report on.
+//
+// Every reader has a Parse variant that takes the file contents, so the
+// parsers can be tested against fixtures. ProcRoot and UtmpFile can be
+// pointed somewhere else for the same reason.
+package sysinfo
+
+import (
+	"os"
+	"path/filepath"
+)
+
+var (
+	// ProcRoot is where procfs is mounted
+	ProcRoot = "/proc"
+	// UtmpFile is the utmp database of current logins
+	UtmpFile = "/var/run/utmp"
+	// WtmpFile is the utmp database of past logins
+	WtmpFile = "/var/log/wtmp"
+	// LastlogFile has every user's most recent login
+	LastlogFile = "/var/log/lastlog"
+)
+
+func readProc(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(ProcRoot, name))
+}
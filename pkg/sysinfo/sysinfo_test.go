@@ -0,0 +1,242 @@
+package sysinfo
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func withProcRoot(t *testing.T, dir string) {
+	t.Helper()
+	old := ProcRoot
+	ProcRoot = dir
+	t.Cleanup(func() { ProcRoot = old })
+}
+
+func TestReadMemInfo(t *testing.T) {
+	withProcRoot(t, "testdata")
+
+	mi, err := ReadMemInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := MemInfo{
+		Mem: Mem{
+			Total:     8039764 << 10,
+			Free:      1262556 << 10,
+			Shared:    431772 << 10,
+			Cached:    (3863484 + 312388) << 10,
+			Buffers:   287452 << 10,
+			Available: 5470400 << 10,
+			Used:      (8039764 - 1262556 - 3863484 - 312388 - 287452) << 10,
+		},
+		Swap: Swap{
+			Total: 2097148 << 10,
+			Used:  (2097148 - 2006012) << 10,
+			Free:  2006012 << 10,
+		},
+	}
+	if *mi != want {
+		t.Errorf("ReadMemInfo() = %+v, want %+v", *mi, want)
+	}
+}
+
+func TestParseMemInfoMap(t *testing.T) {
+	m, err := ParseMemInfoMap([]byte("MemTotal: 10 kB\nHugePages_Total:       4\ngarbage\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m["MemTotal"] != 10 || m["HugePages_Total"] != 4 {
+		t.Errorf("ParseMemInfoMap() = %v", m)
+	}
+
+	if _, err := m.Mem(); err == nil {
+		t.Errorf("Mem() with missing fields = nil, want error")
+	}
+
+	if _, err := ParseMemInfoMap([]byte("MemTotal: ten kB\n")); err == nil {
+		t.Errorf("ParseMemInfoMap() with bad value = nil, want error")
+	}
+}
+
+func TestReadLoadAvg(t *testing.T) {
+	withProcRoot(t, "testdata")
+
+	l, err := ReadLoadAvg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := LoadAvg{One: 0.20, Five: 0.18, Fifteen: 0.12, Running: 1, Total: 80, LastPid: 11206}
+	if *l != want {
+		t.Errorf("ReadLoadAvg() = %+v, want %+v", *l, want)
+	}
+
+	for _, bad := range []string{"", "0.1 0.2", "a b c", "0.1 0.2 0.3 1-80 5"} {
+		if _, err := ParseLoadAvg(bad); err == nil {
+			t.Errorf("ParseLoadAvg(%q) = nil, want error", bad)
+		}
+	}
+}
+
+func TestUptime(t *testing.T) {
+	withProcRoot(t, "testdata")
+
+	u, err := ReadUptime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 3456789120 * time.Millisecond; u.Up != want {
+		t.Errorf("Up = %v, want %v", u.Up, want)
+	}
+	if want := 6543210980 * time.Millisecond; u.Idle != want {
+		t.Errorf("Idle = %v, want %v", u.Idle, want)
+	}
+
+	// 40 days and 13 minutes
+	if got, want := u.Pretty(), "up 5 weeks, 5 days, 13 minutes"; got != want {
+		t.Errorf("Pretty() = %q, want %q", got, want)
+	}
+	if got, want := u.Short(), "40 days, 13 min"; got != want {
+		t.Errorf("Short() = %q, want %q", got, want)
+	}
+	for n, want := range map[int]string{0: "0 users", 1: "1 user", 2: "2 users"} {
+		if got := Plural(n, "user"); got != want {
+			t.Errorf("Plural(%d, user) = %q, want %q", n, got, want)
+		}
+	}
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	if got, want := u.Since(now), now.Add(-3456790*time.Second); !got.Equal(want) {
+		t.Errorf("Since() = %v, want %v", got, want)
+	}
+
+	for _, tt := range []struct {
+		up   time.Duration
+		long string
+		sht  string
+	}{
+		{0, "up 0 minutes", "0 min"},
+		{61 * time.Second, "up 1 minute", "1 min"},
+		{25 * time.Hour, "up 1 day, 1 hour", "1 day,  1:00"},
+	} {
+		u := Uptime{Up: tt.up}
+		if got := u.Pretty(); got != tt.long {
+			t.Errorf("Pretty(%v) = %q, want %q", tt.up, got, tt.long)
+		}
+		if got := u.Short(); got != tt.sht {
+			t.Errorf("Short(%v) = %q, want %q", tt.up, got, tt.sht)
+		}
+	}
+
+	if _, err := ParseUptime(""); err == nil {
+		t.Errorf("ParseUptime(\"\") = nil, want error")
+	}
+}
+
+func TestUsers(t *testing.T) {
+	old := UtmpFile
+	UtmpFile = "testdata/utmp"
+	defer func() { UtmpFile = old }()
+
+	users, err := Users()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 {
+		t.Fatalf("Users() returned %d records, want 2", len(users))
+	}
+
+	bob := users[1]
+	if bob.User != "bob" || bob.Line != "pts/0" || bob.Host != "10.0.0.2" || bob.Pid != 1300 {
+		t.Errorf("got %+v, want bob on pts/0", bob)
+	}
+	if want := netip.MustParseAddr("10.0.0.2"); bob.Addr != want {
+		t.Errorf("Addr = %v, want %v", bob.Addr, want)
+	}
+	if want := time.Unix(1700000200, 0); !bob.Time.Equal(want) {
+		t.Errorf("Time = %v, want %v", bob.Time, want)
+	}
+
+	UtmpFile = filepath.Join(t.TempDir(), "missing")
+	if users, err := Users(); err != nil || len(users) != 0 {
+		t.Errorf("Users() without utmp = %v, %v, want nothing", users, err)
+	}
+}
+
+func TestWriteUtmp(t *testing.T) {
+	src, err := os.ReadFile("testdata/utmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "utmp")
+	if err := os.WriteFile(path, src, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// takes the slot of the dead pts/1 process
+	carol := &Utmp{Type: UserProcess, Pid: 42, Line: "pts/2", ID: "ts/2", User: "carol", Time: time.Unix(1700001000, 0)}
+	if err := WriteUtmp(path, carol); err != nil {
+		t.Fatal(err)
+	}
+	// replaces the LOGIN record on ttyS0
+	dave := &Utmp{Type: UserProcess, Pid: 1500, Line: "ttyS0", ID: "S0", User: "dave", Time: time.Unix(1700002000, 0)}
+	if err := WriteUtmp(path, dave); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := ReadUtmp(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 5 {
+		t.Fatalf("got %d records, want 5", len(records))
+	}
+	if records[3].User != "carol" || records[4].User != "dave" {
+		t.Errorf("got users %q and %q, want carol and dave", records[3].User, records[4].User)
+	}
+
+	wtmp := filepath.Join(t.TempDir(), "wtmp")
+	for i := 0; i < 2; i++ {
+		if err := AppendUtmp(wtmp, carol); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fi, err := os.Stat(wtmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != 2*UtmpSize {
+		t.Errorf("wtmp size = %d, want %d", fi.Size(), 2*UtmpSize)
+	}
+}
+
+func TestLastlog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lastlog")
+	if l, err := ReadLastlog(path, 1000); err != nil || l != (Lastlog{}) {
+		t.Errorf("ReadLastlog without a file = %+v, %v", l, err)
+	}
+
+	want := Lastlog{Time: time.Unix(1700000000, 0), Line: "ttyS0", Host: "10.0.0.1"}
+	if err := WriteLastlog(path, 1000, &want); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteLastlog(path, 0, &Lastlog{Time: time.Unix(1600000000, 0), Line: "tty1"}); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Size() != 1001*LastlogSize {
+		t.Errorf("lastlog is %v bytes, %v, want %d", fi.Size(), err, 1001*LastlogSize)
+	}
+
+	if got, err := ReadLastlog(path, 1000); err != nil || !got.Time.Equal(want.Time) || got.Line != want.Line || got.Host != want.Host {
+		t.Errorf("ReadLastlog(1000) = %+v, %v, want %+v", got, err, want)
+	}
+	// the hole between them and past the end
+	for _, uid := range []int{500, 2000} {
+		if l, err := ReadLastlog(path, uid); err != nil || l != (Lastlog{}) {
+			t.Errorf("ReadLastlog(%d) = %+v, %v, want nothing", uid, l, err)
+		}
+	}
+}
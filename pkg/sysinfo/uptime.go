@@ -0,0 +1,110 @@
+package sysinfo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Uptime is the content of /proc/uptime
+type Uptime struct {
+	// time since boot, including suspend
+	Up time.Duration
+	// sum of the time every CPU spent idle
+	Idle time.Duration
+}
+
+// ReadUptime reads /proc/uptime
+func ReadUptime() (*Uptime, error) {
+	buf, err := readProc("uptime")
+	if err != nil {
+		return nil, err
+	}
+	return ParseUptime(string(buf))
+}
+
+// ParseUptime parses a line like "350735.47 234388.90"
+func ParseUptime(contents string) (*Uptime, error) {
+	f := strings.Fields(contents)
+	if len(f) == 0 {
+		return nil, fmt.Errorf("uptime: contents are empty")
+	}
+
+	var u Uptime
+	var err error
+	for i, v := range []*time.Duration{&u.Up, &u.Idle} {
+		if i >= len(f) {
+			break
+		}
+		secs, err2 := strconv.ParseFloat(f[i], 64)
+		if err2 != nil {
+			err = fmt.Errorf("uptime: %w", err2)
+			break
+		}
+		*v = time.Duration(secs * float64(time.Second)).Round(10 * time.Millisecond)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// Since returns the time of boot relative to now
+func (u *Uptime) Since(now time.Time) time.Time {
+	return now.Add(-u.Up).Truncate(time.Second)
+}
+
+// Plural formats a count of unit, like "1 day" or "2 days"
+func Plural(n int, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, unit)
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+// Pretty formats the uptime like "up 1 week, 2 days, 3 hours, 1 minute"
+func (u *Uptime) Pretty() string {
+	mins := int(u.Up / time.Minute)
+	weeks := mins / (7 * 24 * 60)
+	mins -= weeks * 7 * 24 * 60
+	days := mins / (24 * 60)
+	mins -= days * 24 * 60
+	hours := mins / 60
+	mins -= hours * 60
+
+	var parts []string
+	for _, p := range []struct {
+		n    int
+		unit string
+	}{{weeks, "week"}, {days, "day"}, {hours, "hour"}, {mins, "minute"}} {
+		if p.n > 0 {
+			parts = append(parts, Plural(p.n, p.unit))
+		}
+	}
+	if len(parts) == 0 {
+		parts = append(parts, Plural(0, "minute"))
+	}
+	return "up " + strings.Join(parts, ", ")
+}
+
+// Short formats the uptime like the classic uptime(1) line, "2 days,  3:04"
+// or "49 min"
+func (u *Uptime) Short() string {
+	mins := int(u.Up / time.Minute)
+	days := mins / (24 * 60)
+	mins -= days * 24 * 60
+	hours := mins / 60
+	mins -= hours * 60
+
+	var s string
+	if days > 0 {
+		s = Plural(days, "day") + ", "
+	}
+	if hours > 0 {
+		s += fmt.Sprintf("%2d:%02d", hours, mins)
+	} else {
+		s += fmt.Sprintf("%d min", mins)
+	}
+	return s
+}
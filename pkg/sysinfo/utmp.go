@@ -0,0 +1,236 @@
+package sysinfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"time"
+)
+
+// utmp record types from utmp.h
+const (
+	Empty        = 0
+	RunLevel     = 1
+	BootTime     = 2
+	NewTime      = 3
+	OldTime      = 4
+	InitProcess  = 5
+	LoginProcess = 6
+	UserProcess  = 7
+	DeadProcess  = 8
+	Accounting   = 9
+)
+
+// UtmpSize is the size of a glibc struct utmp on every 64 bit and most 32
+// bit platforms
+const UtmpSize = 384
+
+// rawUtmp mirrors struct utmp, the timestamps are 32 bit for compatibility
+// between 32 and 64 bit programs
+type rawUtmp struct {
+	Type    int16
+	_       [2]byte
+	Pid     int32
+	Line    [32]byte
+	ID      [4]byte
+	User    [32]byte
+	Host    [256]byte
+	Exit    [2]int16
+	Session int32
+	Sec     int32
+	Usec    int32
+	Addr    [4]int32
+	_       [20]byte
+}
+
+// Utmp is a login record
+type Utmp struct {
+	Type    int
+	Pid     int
+	Line    string
+	ID      string
+	User    string
+	Host    string
+	Session int
+	Time    time.Time
+	Addr    netip.Addr
+}
+
+func cstring(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func (r *rawUtmp) utmp() Utmp {
+	u := Utmp{
+		Type:    int(r.Type),
+		Pid:     int(r.Pid),
+		Line:    cstring(r.Line[:]),
+		ID:      cstring(r.ID[:]),
+		User:    cstring(r.User[:]),
+		Host:    cstring(r.Host[:]),
+		Session: int(r.Session),
+		Time:    time.Unix(int64(r.Sec), int64(r.Usec)*1000),
+	}
+
+	var addr [16]byte
+	for i, v := range r.Addr {
+		// ut_addr_v6 holds the address bytes in network order
+		binary.NativeEndian.PutUint32(addr[i*4:], uint32(v))
+	}
+	if r.Addr[1] == 0 && r.Addr[2] == 0 && r.Addr[3] == 0 {
+		if r.Addr[0] != 0 {
+			u.Addr = netip.AddrFrom4([4]byte(addr[:4]))
+		}
+	} else {
+		u.Addr = netip.AddrFrom16(addr)
+	}
+	return u
+}
+
+// MarshalBinary encodes the record as a struct utmp
+func (u *Utmp) MarshalBinary() ([]byte, error) {
+	r := rawUtmp{
+		Type:    int16(u.Type),
+		Pid:     int32(u.Pid),
+		Session: int32(u.Session),
+		Sec:     int32(u.Time.Unix()),
+		Usec:    int32(u.Time.Nanosecond() / 1000),
+	}
+	copy(r.Line[:], u.Line)
+	copy(r.ID[:], u.ID)
+	copy(r.User[:], u.User)
+	copy(r.Host[:], u.Host)
+
+	if u.Addr.IsValid() {
+		var addr [16]byte
+		if u.Addr.Is4() {
+			a4 := u.Addr.As4()
+			copy(addr[:], a4[:])
+		} else {
+			addr = u.Addr.As16()
+		}
+		for i := range r.Addr {
+			r.Addr[i] = int32(binary.NativeEndian.Uint32(addr[i*4:]))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.NativeEndian, &r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ParseUtmp decodes every record of a utmp or wtmp file
+func ParseUtmp(rd io.Reader) ([]Utmp, error) {
+	var records []Utmp
+	for {
+		var r rawUtmp
+		err := binary.Read(rd, binary.NativeEndian, &r)
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return records, fmt.Errorf("utmp: truncated record")
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, r.utmp())
+	}
+}
+
+// ReadUtmp reads every record of a utmp file
+func ReadUtmp(path string) ([]Utmp, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseUtmp(f)
+}
+
+// Users returns the user process records of UtmpFile, a missing file means
+// nobody is logged in
+func Users() ([]Utmp, error) {
+	records, err := ReadUtmp(UtmpFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var users []Utmp
+	for _, r := range records {
+		if r.Type == UserProcess && r.User != "" {
+			users = append(users, r)
+		}
+	}
+	return users, nil
+}
+
+// AppendUtmp appends a record to a wtmp style log
+func AppendUtmp(path string, u *Utmp) error {
+	b, err := u.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o664)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(b)
+	return err
+}
+
+// WriteUtmp replaces the record of the same line in a utmp file, or adds
+// it to the first free slot
+func WriteUtmp(path string, u *Utmp) error {
+	b, err := u.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o664)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	records, err := ParseUtmp(f)
+	if err != nil {
+		return err
+	}
+
+	match, free := -1, -1
+	for i, r := range records {
+		if r.Line == u.Line && r.ID == u.ID {
+			match = i
+			break
+		}
+		if free < 0 && (r.Type == Empty || r.Type == DeadProcess) {
+			free = i
+		}
+	}
+
+	slot := len(records)
+	if match >= 0 {
+		slot = match
+	} else if free >= 0 {
+		slot = free
+	}
+
+	_, err = f.WriteAt(b, int64(slot*UtmpSize))
+	return err
+}
@@ -0,0 +1,122 @@
+// Package termios sets up terminals: raw and cooked modes, line speeds,
+// window sizes and controlling terminals for getty, login and friends.
+//
+// Settings are read and written with TCGETS2 and TCSETS2, so a line can run
+// at any speed the driver supports rather than only the ones with a B
+// constant.
+package termios
+
+import "golang.org/x/sys/unix"
+
+// Termios is the kernel's struct termios2
+type Termios = unix.Termios
+
+// Control characters for cooked mode, the ones stty sane uses
+var cooked = map[int]uint8{
+	unix.VINTR:    'C' & 0x1f,
+	unix.VQUIT:    '\\' & 0x1f,
+	unix.VERASE:   0x7f,
+	unix.VKILL:    'U' & 0x1f,
+	unix.VEOF:     'D' & 0x1f,
+	unix.VTIME:    0,
+	unix.VMIN:     1,
+	unix.VSTART:   'Q' & 0x1f,
+	unix.VSTOP:    'S' & 0x1f,
+	unix.VSUSP:    'Z' & 0x1f,
+	unix.VREPRINT: 'R' & 0x1f,
+	unix.VWERASE:  'W' & 0x1f,
+	unix.VLNEXT:   'V' & 0x1f,
+	unix.VDISCARD: 'O' & 0x1f,
+}
+
+// MakeRaw returns t with input passed through byte by byte: no echo, line
+// editing, signals or output processing
+func MakeRaw(t *Termios) *Termios {
+	raw := *t
+	raw.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	raw.Oflag &^= unix.OPOST
+	raw.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	raw.Cflag &^= unix.CSIZE | unix.PARENB
+	raw.Cflag |= unix.CS8
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	return &raw
+}
+
+// MakeCooked returns t in the mode a shell expects, like stty sane: 8 bit
+// characters, line editing, echo, signals and CR/NL translation. The line
+// speed and CLOCAL are kept.
+func MakeCooked(t *Termios) *Termios {
+	c := *t
+	c.Iflag = unix.BRKINT | unix.ICRNL | unix.IXON | unix.IMAXBEL | unix.IUTF8
+	c.Oflag = unix.OPOST | unix.ONLCR
+	c.Cflag &= unix.CBAUD | unix.CBAUD<<unix.IBSHIFT | unix.CSTOPB | unix.CLOCAL
+	c.Cflag |= unix.CS8 | unix.CREAD | unix.HUPCL
+	c.Lflag = unix.ISIG | unix.ICANON | unix.IEXTEN | unix.ECHO | unix.ECHOE | unix.ECHOK | unix.ECHOCTL | unix.ECHOKE
+	c.Line = 0
+	for i, v := range cooked {
+		c.Cc[i] = v
+	}
+	return &c
+}
+
+// MakeNoEcho returns t with echo off, for reading passwords
+func MakeNoEcho(t *Termios) *Termios {
+	n := *t
+	n.Lflag &^= unix.ECHO | unix.ECHOE | unix.ECHOK
+	n.Lflag |= unix.ECHONL
+	return &n
+}
+
+// speeds are the rates with a B constant, for drivers that only fill in
+// the c_cflag bits
+var speeds = map[uint32]int{
+	unix.B50:      50,
+	unix.B75:      75,
+	unix.B110:     110,
+	unix.B134:     134,
+	unix.B150:     150,
+	unix.B200:     200,
+	unix.B300:     300,
+	unix.B600:     600,
+	unix.B1200:    1200,
+	unix.B1800:    1800,
+	unix.B2400:    2400,
+	unix.B4800:    4800,
+	unix.B9600:    9600,
+	unix.B19200:   19200,
+	unix.B38400:   38400,
+	unix.B57600:   57600,
+	unix.B115200:  115200,
+	unix.B230400:  230400,
+	unix.B460800:  460800,
+	unix.B500000:  500000,
+	unix.B576000:  576000,
+	unix.B921600:  921600,
+	unix.B1000000: 1000000,
+	unix.B1152000: 1152000,
+	unix.B1500000: 1500000,
+	unix.B2000000: 2000000,
+	unix.B2500000: 2500000,
+	unix.B3000000: 3000000,
+	unix.B3500000: 3500000,
+	unix.B4000000: 4000000,
+}
+
+// SetSpeed sets both the input and output speed of t to baud, which
+// needn't be one of the standard rates
+func SetSpeed(t *Termios, baud int) {
+	t.Cflag &^= unix.CBAUD | unix.CBAUD<<unix.IBSHIFT
+	t.Cflag |= unix.BOTHER | unix.BOTHER<<unix.IBSHIFT
+	t.Ispeed = uint32(baud)
+	t.Ospeed = uint32(baud)
+}
+
+// Speed is the output speed of t
+func Speed(t *Termios) int {
+	b := t.Cflag & unix.CBAUD
+	if b == unix.BOTHER || t.Ospeed != 0 {
+		return int(t.Ospeed)
+	}
+	return speeds[b]
+}
@@ -0,0 +1,112 @@
+package termios
+
+import (
+	"bufio"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/creack/pty"
+	"golang.org/x/sys/unix"
+)
+
+// openPty returns the terminal end of a new pty pair and its master
+func openPty(t *testing.T) (*TTY, *bufio.Reader) {
+	t.Helper()
+	ptm, pts, err := pty.Open()
+	if err != nil {
+		t.Skipf("no ptys: %v", err)
+	}
+	t.Cleanup(func() {
+		ptm.Close()
+		pts.Close()
+	})
+	return New(pts), bufio.NewReader(ptm)
+}
+
+func TestModes(t *testing.T) {
+	tty, _ := openPty(t)
+
+	old, err := tty.Raw()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := tty.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw.Lflag&(unix.ECHO|unix.ICANON|unix.ISIG) != 0 || raw.Oflag&unix.OPOST != 0 || raw.Cc[unix.VMIN] != 1 {
+		t.Errorf("raw mode = %+v", raw)
+	}
+	if err := tty.Set(old); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := tty.Get(); got.Lflag != old.Lflag {
+		t.Errorf("restored lflag = %#x, want %#x", got.Lflag, old.Lflag)
+	}
+
+	if _, err := tty.NoEcho(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tty.Cooked(); err != nil {
+		t.Fatal(err)
+	}
+	c, _ := tty.Get()
+	if c.Lflag&(unix.ECHO|unix.ICANON|unix.ISIG) != unix.ECHO|unix.ICANON|unix.ISIG || c.Iflag&unix.ICRNL == 0 || c.Cc[unix.VERASE] != 0x7f {
+		t.Errorf("cooked mode = %+v", c)
+	}
+}
+
+func TestSpeed(t *testing.T) {
+	tty, _ := openPty(t)
+
+	for _, baud := range []int{115200, 9600, 250000} {
+		if err := tty.SetSpeed(baud); err != nil {
+			t.Fatalf("SetSpeed(%d): %v", baud, err)
+		}
+		if got, err := tty.Speed(); err != nil || got != baud {
+			t.Errorf("Speed() = %d, %v, want %d", got, err, baud)
+		}
+	}
+
+	// cooked mode keeps the speed
+	if _, err := tty.Cooked(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := tty.Speed(); got != 250000 {
+		t.Errorf("Speed() after Cooked = %d, want 250000", got)
+	}
+
+	var tio Termios
+	tio.Cflag = unix.B38400
+	if got := Speed(&tio); got != 38400 {
+		t.Errorf("Speed(B38400) = %d", got)
+	}
+}
+
+func TestWinSize(t *testing.T) {
+	tty, _ := openPty(t)
+
+	want := unix.Winsize{Row: 42, Col: 132}
+	if err := tty.SetWinSize(&want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := tty.WinSize()
+	if err != nil || *got != want {
+		t.Errorf("WinSize() = %+v, %v, want %+v", got, err, want)
+	}
+}
+
+func TestCtty(t *testing.T) {
+	tty, out := openPty(t)
+
+	// /dev/tty only opens for a process with a controlling terminal
+	c := exec.Command("sh", "-c", "exec 3</dev/tty && echo ctty")
+	tty.Ctty(c)
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if l, err := out.ReadString('\n'); err != nil || strings.TrimSpace(l) != "ctty" {
+		t.Errorf("command printed %q, %v", l, err)
+	}
+}
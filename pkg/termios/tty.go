@@ -0,0 +1,164 @@
+package termios
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// TTY is an open terminal
+type TTY struct {
+	f *os.File
+}
+
+// Open opens the terminal at path for reading and writing, without making
+// it our controlling terminal
+func Open(path string) (*TTY, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := unix.IoctlGetTermios(int(f.Fd()), unix.TCGETS2); err != nil {
+		f.Close()
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return &TTY{f: f}, nil
+}
+
+// New wraps an already open terminal
+func New(f *os.File) *TTY {
+	return &TTY{f: f}
+}
+
+// File is the terminal's file
+func (t *TTY) File() *os.File {
+	return t.f
+}
+
+// Name is the path the terminal was opened as
+func (t *TTY) Name() string {
+	return t.f.Name()
+}
+
+func (t *TTY) fd() int {
+	return int(t.f.Fd())
+}
+
+func (t *TTY) Read(b []byte) (int, error) {
+	return t.f.Read(b)
+}
+
+func (t *TTY) Write(b []byte) (int, error) {
+	return t.f.Write(b)
+}
+
+// Close closes the terminal
+func (t *TTY) Close() error {
+	return t.f.Close()
+}
+
+// Get reads the terminal's settings
+func (t *TTY) Get() (*Termios, error) {
+	return unix.IoctlGetTermios(t.fd(), unix.TCGETS2)
+}
+
+// Set changes the terminal's settings once pending output is written
+func (t *TTY) Set(tio *Termios) error {
+	return unix.IoctlSetTermios(t.fd(), unix.TCSETSW2, tio)
+}
+
+// change applies mode to the current settings and returns the old ones
+func (t *TTY) change(mode func(*Termios) *Termios) (*Termios, error) {
+	old, err := t.Get()
+	if err != nil {
+		return nil, err
+	}
+	return old, t.Set(mode(old))
+}
+
+// Raw puts the terminal in raw mode and returns the settings to restore
+func (t *TTY) Raw() (*Termios, error) {
+	return t.change(MakeRaw)
+}
+
+// Cooked puts the terminal in cooked mode and returns the settings to
+// restore
+func (t *TTY) Cooked() (*Termios, error) {
+	return t.change(MakeCooked)
+}
+
+// NoEcho turns echo off and returns the settings to restore
+func (t *TTY) NoEcho() (*Termios, error) {
+	return t.change(MakeNoEcho)
+}
+
+// Speed is the terminal's output speed
+func (t *TTY) Speed() (int, error) {
+	tio, err := t.Get()
+	if err != nil {
+		return 0, err
+	}
+	return Speed(tio), nil
+}
+
+// SetSpeed sets the terminal's input and output speed to baud
+func (t *TTY) SetSpeed(baud int) error {
+	tio, err := t.Get()
+	if err != nil {
+		return err
+	}
+	SetSpeed(tio, baud)
+	return t.Set(tio)
+}
+
+// Flush throws away input that hasn't been read
+func (t *TTY) Flush() error {
+	return unix.IoctlSetInt(t.fd(), unix.TCFLSH, unix.TCIFLUSH)
+}
+
+// WinSize reads the terminal's window size
+func (t *TTY) WinSize() (*unix.Winsize, error) {
+	return unix.IoctlGetWinsize(t.fd(), unix.TIOCGWINSZ)
+}
+
+// SetWinSize changes the terminal's window size
+func (t *TTY) SetWinSize(w *unix.Winsize) error {
+	return unix.IoctlSetWinsize(t.fd(), unix.TIOCSWINSZ, w)
+}
+
+// Ctty makes the terminal c's standard input, output and error and its
+// controlling terminal, in a session of its own
+func (t *TTY) Ctty(c *exec.Cmd) {
+	c.Stdin, c.Stdout, c.Stderr = t.f, t.f, t.f
+	if c.SysProcAttr == nil {
+		c.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	c.SysProcAttr.Setsid = true
+	c.SysProcAttr.Setctty = true
+	// a descriptor in the child
+	c.SysProcAttr.Ctty = 0
+}
+
+// LoginTTY does for this process what Ctty does for a command, like
+// login_tty(3): it starts a session if we don't lead one, takes the
+// terminal from whoever has it and puts it on 0, 1 and 2
+func (t *TTY) LoginTTY() error {
+	if _, err := unix.Setsid(); err != nil && !errors.Is(err, unix.EPERM) {
+		return err
+	}
+	if err := unix.IoctlSetInt(t.fd(), unix.TIOCSCTTY, 1); err != nil {
+		return err
+	}
+	for fd := 0; fd < 3; fd++ {
+		if fd == t.fd() {
+			continue
+		}
+		if err := unix.Dup3(t.fd(), fd, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
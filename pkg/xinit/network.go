@@ -1,87 +1,36 @@
 package internal
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"net"
 	"os"
-	"time"
 
-	"github.com/digineo/go-dhclient"
-	"github.com/google/gopacket/layers"
+	"mybox/pkg/dhcp"
+
 	"github.com/vishvananda/netlink"
 )
 
-func DHCPClient(ifname string) (*dhclient.Client, error) {
-	// Setup interface to receive DHCP traffic
-	iface, err := net.InterfaceByName(ifname)
-	if err != nil {
+// DHCPClient keeps a lease on ifname, with resolv.conf and hooks, until ctx
+// is done
+func DHCPClient(ctx context.Context, ifname string) (*dhcp.Client, error) {
+	if _, err := net.InterfaceByName(ifname); err != nil {
 		return nil, fmt.Errorf("error finding interface by name %q: %w", ifname, err)
 	}
 
-	client := &dhclient.Client{
-		Iface: iface,
-		OnBound: func(lease *dhclient.Lease) {
-			ip := &net.IPNet{IP: lease.FixedAddress, Mask: lease.Netmask}
-			log.Printf(
-				"dhcp lease server=%s expires=%s ip=%s domain=%s resolvers=%s routers=%s\n",
-				lease.ServerID, time.Until(lease.Expire), ip.String(),
-				lease.DomainName, lease.DNS, lease.Router,
-			)
-
-			link, err := netlink.LinkByName(iface.Name)
-			if err != nil {
-				log.Printf("error getting link by name %q: %s", iface.Name, err)
-				return
-			}
-
-			// Set address / netmask into cidr we can use to apply to interface
-			cidr := net.IPNet{
-				IP:   lease.FixedAddress,
-				Mask: lease.Netmask,
-			}
-			addr, err := netlink.ParseAddr(cidr.String())
-			if err != nil {
-				log.Printf("error parsing address %q: %s", cidr.String(), err)
-				return
-			}
-
-			if err := netlink.AddrAdd(link, addr); err != nil {
-				log.Printf("error adding %s to link %s", cidr.String(), iface.Name)
-			}
-
-			var gw net.IP
-
-			if len(lease.Router) > 0 {
-				gw = lease.Router[0]
-			} else {
-				gw = lease.ServerID
-			}
-
-			// Apply default gateway so we can route outside
-			route := netlink.Route{
-				Scope: netlink.SCOPE_UNIVERSE,
-				Gw:    gw,
-			}
-			if err := netlink.RouteAdd(&route); err != nil {
-				log.Printf("error setting gateway [%v]", err)
-			}
-		},
-	}
-
-	// Add requests for default options
-	for _, param := range dhclient.DefaultParamsRequestList {
-		client.AddParamRequest(layers.DHCPOpt(param))
-	}
-
-	// Add hostname option
 	hostname, err := os.Hostname()
 	if err != nil {
 		return nil, fmt.Errorf("error getting hostname: %w", err)
 	}
-	client.AddOption(layers.DHCPOptHostname, []byte(hostname))
 
-	client.Start()
+	client := &dhcp.Client{
+		Interface:  ifname,
+		StateDir:   dhcp.DefaultStateDir,
+		ResolvConf: dhcp.DefaultResolvConf,
+		Script:     dhcp.DefaultScript,
+		Hostname:   hostname,
+	}
+	go client.Run(ctx)
 
 	return client, nil
 }